@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/daptin/daptin/server"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/daptin/daptin/server/statementbuilder"
+	log "github.com/sirupsen/logrus"
+)
+
+// backupPasswordEnv is read when no password is given, so it is not left in
+// the shell history
+const backupPasswordEnv = "DAPTIN_BACKUP_PASSWORD"
+
+// runBackupCommand writes a backup of an instance to a file, or uploads it to
+// a folder of a cloud store of the instance:
+//
+//	daptin backup -db_type sqlite3 -db_connection_string daptin.db -output daptin.tar.gz
+func runBackupCommand(args []string) error {
+
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	var dbType = flags.String("db_type", "sqlite3", "Database to use: sqlite3/mysql/postgres")
+	var connectionString = flags.String("db_connection_string", "daptin.db", "connection string of the database")
+	var output = flags.String("output", "", "file to write the backup to, a name with the time by default")
+	var password = flags.String("password", "", "password to encrypt the backup with, or set "+backupPasswordEnv)
+	var cloudStoreName = flags.String("cloud_store", "", "name of the cloud store to upload the backup to, instead of a file")
+	var backupPath = flags.String("path", "backups", "folder of the cloud store to upload the backup to")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *password == "" {
+		*password = os.Getenv(backupPasswordEnv)
+	}
+
+	cruds, err := loadBackupResources(*dbType, *connectionString)
+	if err != nil {
+		return err
+	}
+
+	var cloudStore *resource.CloudStore
+	if *cloudStoreName != "" {
+		cloudStores, err := cruds["cloud_store"].GetAllCloudStores()
+		if err != nil {
+			return err
+		}
+		for i := range cloudStores {
+			if cloudStores[i].Name == *cloudStoreName {
+				cloudStore = &cloudStores[i]
+			}
+		}
+		if cloudStore == nil {
+			return fmt.Errorf("no cloud store named [%v]", *cloudStoreName)
+		}
+	}
+
+	outputPath := *output
+	if outputPath == "" {
+		outputPath = resource.BackupFileName(*password != "")
+		if cloudStore != nil {
+			tempDir, err := ioutil.TempDir("", "daptin-backup-file")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(tempDir)
+			outputPath = filepath.Join(tempDir, outputPath)
+		}
+	}
+
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	summary, err := resource.CreateBackup(cruds, file, *password)
+	file.Close()
+	if err != nil {
+		os.Remove(outputPath)
+		return err
+	}
+	log.Printf("Wrote backup of %d tables, %d rows and %d files to %v", len(summary.Manifest.Tables), summary.Rows, summary.Files, outputPath)
+
+	if cloudStore != nil {
+		err = cruds["cloud_store"].CopyToCloudStore(*cloudStore, outputPath, *backupPath)
+		if err != nil {
+			return err
+		}
+		log.Printf("Uploaded backup to %v/%v", *cloudStoreName, *backupPath)
+	}
+	return nil
+}
+
+// runRestoreCommand restores a backup into the database of an instance, the
+// server should not be running:
+//
+//	daptin restore -db_type sqlite3 -db_connection_string daptin.db -input daptin.tar.gz
+func runRestoreCommand(args []string) error {
+
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	var dbType = flags.String("db_type", "sqlite3", "Database to use: sqlite3/mysql/postgres")
+	var connectionString = flags.String("db_connection_string", "daptin.db", "connection string of the database")
+	var input = flags.String("input", "", "backup file to restore")
+	var password = flags.String("password", "", "password the backup is encrypted with, or set "+backupPasswordEnv)
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *input == "" {
+		return fmt.Errorf("no backup file to restore, set -input")
+	}
+	if *password == "" {
+		*password = os.Getenv(backupPasswordEnv)
+	}
+
+	file, err := os.Open(*input)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	cruds, err := loadBackupResources(*dbType, *connectionString)
+	if err != nil {
+		return err
+	}
+
+	summary, err := resource.RestoreBackup(cruds, file, *password)
+	if err != nil {
+		return err
+	}
+	log.Printf("Restored %d rows and %d files from %v", summary.Rows, summary.Files, *input)
+	if len(summary.Skipped) > 0 {
+		log.Warnf("Not restored: %v", summary.Skipped)
+	}
+	return nil
+}
+
+func loadBackupResources(dbType string, connectionString string) (map[string]*resource.DbResource, error) {
+	statementbuilder.InitialiseStatementBuilder(dbType)
+	db, err := server.GetDbConnection(dbType, connectionString)
+	if err != nil {
+		return nil, err
+	}
+	resource.InitialiseColumnManager()
+	return server.LoadDbResources(db)
+}
@@ -25,8 +25,11 @@ require (
 	github.com/disintegration/gift v1.2.0
 	github.com/dlclark/regexp2 v0.0.0-20171009020623-7632a260cbaf // indirect
 	github.com/dop251/goja v0.0.0-20181125163413-2dd08a5fc665
+	github.com/emersion/go-ical v0.0.0-20240127095438-fc1c9d8fb2b6
 	github.com/emersion/go-message v0.11.1
 	github.com/emersion/go-msgauth v0.4.0
+	github.com/emersion/go-vcard v0.0.0-20230815062825-8fda7d206ec9
+	github.com/emersion/go-webdav v0.6.0
 	github.com/etgryphon/stringUp v0.0.0-20121020160746-31534ccd8cac // indirect
 	github.com/fclairamb/ftpserver v0.0.0-20200221221851-84e5d668e655
 	github.com/getkin/kin-openapi v0.2.0
@@ -73,6 +76,7 @@ require (
 	github.com/mattn/go-sqlite3 v1.11.0
 	github.com/naoina/toml v0.1.1
 	github.com/pkg/errors v0.8.1
+	github.com/pkg/sftp v1.11.0
 	github.com/pquerna/otp v1.2.0
 	github.com/robfig/cron v1.0.0
 	github.com/sadlil/go-trigger v0.0.0-20170328161825-cfc3d83007cd
@@ -83,6 +87,7 @@ require (
 	github.com/spf13/cobra v0.0.5
 	github.com/tealeg/xlsx v0.0.0-20181024002044-dbf71b6a931e
 	github.com/ugorji/go v1.1.7 // indirect
+	github.com/xeipuuv/gojsonschema v1.2.0
 	golang.org/x/crypto v0.0.0-20200109152110-61a87790db17
 	golang.org/x/net v0.0.0-20200222125558-5a598a2470a0
 	golang.org/x/oauth2 v0.0.0-20190604053449-0f29369cfe45
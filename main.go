@@ -47,6 +47,33 @@ func init() {
 func main() {
 	//eventEmitter := &emitter.Emitter{}
 
+	if len(os.Args) > 1 && os.Args[1] == "sdk" {
+		err := runSdkCommand(os.Args[2:])
+		if err != nil {
+			log.Errorf("Failed to generate client: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "backup" {
+		err := runBackupCommand(os.Args[2:])
+		if err != nil {
+			log.Errorf("Failed to backup: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		err := runRestoreCommand(os.Args[2:])
+		if err != nil {
+			log.Errorf("Failed to restore: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	var dbType = flag.String("db_type", "sqlite3", "Database to use: sqlite3/mysql/postgres")
 	var connectionString = flag.String("db_connection_string", "daptin.db", "\n\tSQLite: test.db\n"+
 		"\tMySql: <username>:<password>@tcp(<hostname>:<port>)/<db_name>\n"+
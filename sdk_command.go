@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/daptin/daptin/server/sdkgen"
+)
+
+// runSdkCommand generates a client from the schema of a running server, or
+// from a schema saved from /sdk/schema:
+//
+//	daptin sdk -language go -server http://localhost:6336 -output client.go
+func runSdkCommand(args []string) error {
+
+	flags := flag.NewFlagSet("sdk", flag.ExitOnError)
+	var language = flags.String("language", "typescript", "language of the client: go/typescript")
+	var serverUrl = flags.String("server", "http://localhost:6336", "url of the daptin server to read the schema from")
+	var schemaFile = flags.String("schema", "", "file with the schema from /sdk/schema, instead of the server")
+	var packageName = flags.String("package", "daptin", "package name of the go client")
+	var output = flags.String("output", "", "file to write the client to, the standard output by default")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	var schemaBytes []byte
+	if *schemaFile != "" {
+		schemaBytes, err = ioutil.ReadFile(*schemaFile)
+		if err != nil {
+			return err
+		}
+	} else {
+		response, err := http.Get(strings.TrimRight(*serverUrl, "/") + "/sdk/schema")
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+		if response.StatusCode != 200 {
+			return fmt.Errorf("failed to get the schema from %v: %v", *serverUrl, response.Status)
+		}
+		schemaBytes, err = ioutil.ReadAll(response.Body)
+		if err != nil {
+			return err
+		}
+	}
+
+	var schema sdkgen.Schema
+	err = json.Unmarshal(schemaBytes, &schema)
+	if err != nil {
+		return fmt.Errorf("invalid schema: %v", err)
+	}
+	if schema.ComputeHash() != schema.Hash {
+		return fmt.Errorf("the hash of the schema does not match its tables and actions")
+	}
+
+	client, err := sdkgen.Generate(schema, *language, *packageName)
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		_, err = os.Stdout.WriteString(client)
+		return err
+	}
+	return ioutil.WriteFile(*output, []byte(client), 0644)
+}
@@ -42,6 +42,18 @@ func GetActionPerformers(initConfig *resource.CmsConfig, configStore *resource.C
 	resource.CheckErr(err, "Failed to create data import performer")
 	performers = append(performers, importDataPerformer)
 
+	importRowsPerformer, err := resource.NewImportRowsPerformer(initConfig, cruds)
+	resource.CheckErr(err, "Failed to create import rows performer")
+	performers = append(performers, importRowsPerformer)
+
+	backupPerformer, err := resource.NewBackupPerformer(initConfig, cruds)
+	resource.CheckErr(err, "Failed to create backup performer")
+	performers = append(performers, backupPerformer)
+
+	restorePerformer, err := resource.NewRestorePerformer(initConfig, cruds)
+	resource.CheckErr(err, "Failed to create restore performer")
+	performers = append(performers, restorePerformer)
+
 	oauth2redirect, err := resource.NewOauthLoginBeginActionPerformer(initConfig, cruds, configStore)
 	resource.CheckErr(err, "Failed to create oauth2 request performer")
 	performers = append(performers, oauth2redirect)
@@ -3,7 +3,9 @@ package apiblueprint
 import (
 	"bytes"
 	"github.com/artpar/api2go"
+	"github.com/artpar/api2go/jsonapi"
 	"github.com/daptin/daptin/server/resource"
+	"github.com/graphql-go/graphql"
 	"github.com/iancoleman/strcase"
 
 	"fmt"
@@ -13,6 +15,10 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// jsonApiMediaType is the media type of the requests and the responses of the
+// routes under /api
+const jsonApiMediaType = "application/vnd.api+json"
+
 func InfoError(err error, args ...interface{}) bool {
 	if err != nil {
 		if len(args) > 0 {
@@ -58,26 +64,177 @@ var skipColumns = map[string]bool{
 	"status":     true,
 }
 
+// columnFormats are the formats of the string values of the column types
+var columnFormats = map[string]string{
+	"date":       "date",
+	"datetime":   "date-time",
+	"timestamp":  "date-time",
+	"time":       "time",
+	"email":      "email",
+	"url":        "uri",
+	"password":   "password",
+	"bcrypt":     "password",
+	"md5-bcrypt": "password",
+}
+
 func CreateColumnLine(colInfo api2go.ColumnInfo) map[string]interface{} {
-	columnType := colInfo.ColumnType
+
+	// files are stored in a cloud store, the column has the list of the files
+	if colInfo.IsForeignKey && colInfo.ForeignKeyData.DataSource == "cloud_store" {
+		return map[string]interface{}{
+			"type":     "array",
+			"items":    schemaRef("FileObject"),
+			"nullable": true,
+		}
+	}
+
+	columnType := strings.Split(colInfo.ColumnType, ".")[0]
 	typ := resource.ColumnManager.GetBlueprintType(columnType)
 
 	if typ == "" {
 		typ = "string"
 	}
+	if typ == "number" && resource.ColumnManager.ColumnMap[columnType].GraphqlType == graphql.Int {
+		typ = "integer"
+	}
 
 	m := map[string]interface{}{
 		"type": typ,
 	}
+	if format, ok := columnFormats[columnType]; ok && typ == "string" {
+		m["format"] = format
+	}
+	if len(colInfo.Options) > 0 {
+		values := make([]interface{}, 0)
+		for _, option := range colInfo.Options {
+			values = append(values, option.Value)
+		}
+		m["enum"] = values
+	}
+	if colInfo.IsNullable {
+		m["nullable"] = true
+	}
+	if colInfo.ColumnDescription != "" {
+		m["description"] = colInfo.ColumnDescription
+	}
 	return m
 }
 
-func BuildApiBlueprint(config *resource.CmsConfig, cruds map[string]*resource.DbResource) string {
+// columnName is the name of the column in the attributes, the columns of the
+// streams and the in fields of the actions only have a Name sometimes
+func columnName(colInfo api2go.ColumnInfo) string {
+	if colInfo.ColumnName != "" {
+		return colInfo.ColumnName
+	}
+	return colInfo.Name
+}
+
+// isApiColumn tells if the column is one of the attributes of the rows, the
+// other foreign keys are relationships
+func isApiColumn(colInfo api2go.ColumnInfo) bool {
+	if colInfo.ExcludeFromApi || skipColumns[columnName(colInfo)] {
+		return false
+	}
+	return !colInfo.IsForeignKey || colInfo.ForeignKeyData.DataSource == "cloud_store"
+}
+
+// relationReference is a relation as seen from the rows of a table, named as
+// it is in the relationships of the rows and in the routes of the relation
+type relationReference struct {
+	Name   string
+	Target string
+	Many   bool
+}
+
+// tableReferences are the relations of the table, in the way api2go lists the
+// references of a model
+func tableReferences(tableInfo resource.TableInfo) []relationReference {
+	references := make([]relationReference, 0)
+	names := make(map[string]bool)
+	for _, relation := range tableInfo.Relations {
+		var reference relationReference
+		if relation.GetSubject() == tableInfo.TableName {
+			reference = relationReference{
+				Name:   relation.GetObjectName(),
+				Target: relation.GetObject(),
+				Many:   relation.GetRelation() == "has_many" || relation.GetRelation() == "has_many_and_belongs_to_many",
+			}
+		} else {
+			reference = relationReference{
+				Name:   relation.GetSubjectName(),
+				Target: relation.GetSubject(),
+				Many:   relation.GetRelation() != "has_one",
+			}
+		}
+		if names[reference.Name] {
+			continue
+		}
+		names[reference.Name] = true
+		references = append(references, reference)
+	}
+	return references
+}
+
+func isJoinTable(tableName string) bool {
+	return strings.Index(tableName, "_has_") > -1
+}
+
+func schemaRef(name string) map[string]interface{} {
+	return map[string]interface{}{
+		"$ref": "#/components/schemas/" + name,
+	}
+}
+
+func pathParameter(name string, description string) map[string]interface{} {
+	return map[string]interface{}{
+		"name": name,
+		"schema": map[string]interface{}{
+			"type": "string",
+		},
+		"required":    true,
+		"in":          "path",
+		"description": description,
+	}
+}
+
+func queryParameter(name string, description string, schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        name,
+		"schema":      schema,
+		"required":    false,
+		"in":          "query",
+		"description": description,
+	}
+}
+
+func referenceIdParameter(tableName string) map[string]interface{} {
+	return pathParameter("referenceId", "Reference Id of the "+tableName)
+}
+
+// contentResponse is a response with a body of the media type
+func contentResponse(description string, mediaType string, schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			mediaType: map[string]interface{}{
+				"schema": schema,
+			},
+		},
+	}
+}
 
-	tableMap := map[string]resource.TableInfo{}
-	for _, table := range config.Tables {
-		tableMap[table.TableName] = table
+// errorResponses are the JSON:API errors of the routes under /api
+func errorResponses(responses map[string]interface{}) map[string]interface{} {
+	responses["default"] = map[string]interface{}{
+		"$ref": "#/components/responses/Error",
 	}
+	return responses
+}
+
+// BuildApiBlueprint describes the routes of the server as an OpenAPI 3
+// document, with the schemas of the rows of the tables and streams and of the
+// inputs of the actions
+func BuildApiBlueprint(config *resource.CmsConfig, cruds map[string]*resource.DbResource) string {
 
 	apiDefinition := make(map[string]interface{})
 
@@ -91,7 +248,8 @@ func BuildApiBlueprint(config *resource.CmsConfig, cruds map[string]*resource.Db
 		"contact": map[string]interface{}{
 			"name": "Parth",
 		},
-		"description":    "Daptin server API spec",
+		"description": "Daptin server API spec. The files under /static and the WebDAV, CalDAV and CardDAV " +
+			"routes under /webdav, /caldav and /carddav are not described here.",
 		"termsOfService": config.Hostname + "/tos",
 	}
 
@@ -101,6 +259,16 @@ func BuildApiBlueprint(config *resource.CmsConfig, cruds map[string]*resource.Db
 			"description": "Server " + config.Hostname,
 		},
 	}
+
+	// the token is read from the authorization header, the token parameter or the token cookie,
+	// requests without a token are served with the permissions of a guest
+	apiDefinition["security"] = []map[string]interface{}{
+		{"bearerAuth": []string{}},
+		{"tokenParameter": []string{}},
+		{"tokenCookie": []string{}},
+		{},
+	}
+
 	typeMap := make(map[string]map[string]interface{})
 	typeMap["RelatedStructure"] = map[string]interface{}{
 		"type": "object",
@@ -114,6 +282,7 @@ func BuildApiBlueprint(config *resource.CmsConfig, cruds map[string]*resource.Db
 				"description": "Type of the included object",
 			},
 		},
+		"required": []string{"id", "type"},
 	}
 
 	paginationObject := make(map[string]interface{})
@@ -138,12 +307,14 @@ func BuildApiBlueprint(config *resource.CmsConfig, cruds map[string]*resource.Db
 		"type": "object",
 		"properties": map[string]interface{}{
 			"ResponseType": map[string]interface{}{
-				"type": "string",
+				"type":        "string",
+				"description": "Type of the response, like client.notify or client.store.set",
 			},
 			"Attributes": map[string]interface{}{
 				"type": "object",
 			},
 		},
+		"required": []string{"ResponseType"},
 	}
 
 	paginationStatus := make(map[string]interface{})
@@ -177,15 +348,25 @@ func BuildApiBlueprint(config *resource.CmsConfig, cruds map[string]*resource.Db
 	typeMap["PaginationStatus"] = paginationStatus
 	typeMap["ActionResponse"] = actionResponse
 
+	typeMap["RelationshipData"] = map[string]interface{}{
+		"nullable": true,
+		"oneOf": []interface{}{
+			schemaRef("RelatedStructure"),
+			map[string]interface{}{
+				"type":  "array",
+				"items": schemaRef("RelatedStructure"),
+			},
+		},
+		"description": "The related object, or the list of the related objects",
+	}
+
 	IncludedRelationship := make(map[string]interface{})
 	IncludedRelationship["type"] = "object"
 	IncludedRelationship["properties"] = map[string]interface{}{
-		"data": map[string]interface{}{
-			"$ref": "#/components/schemas/RelatedStructure",
-		},
+		"data": schemaRef("RelationshipData"),
 		"links": map[string]interface{}{
 			"type":        "object",
-			"description": "From page",
+			"description": "Links of the relationship",
 			"properties": map[string]interface{}{
 				"related": map[string]interface{}{
 					"type":        "string",
@@ -200,10 +381,113 @@ func BuildApiBlueprint(config *resource.CmsConfig, cruds map[string]*resource.Db
 	}
 	typeMap["IncludedRelationship"] = IncludedRelationship
 
+	typeMap["IncludedObject"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"type": map[string]interface{}{
+				"type": "string",
+			},
+			"id": map[string]interface{}{
+				"type": "string",
+			},
+			"attributes": map[string]interface{}{
+				"type": "object",
+			},
+			"relationships": map[string]interface{}{
+				"type": "object",
+			},
+		},
+		"required":    []string{"type", "id"},
+		"description": "An object included with the include parameter",
+	}
+
+	typeMap["FileObject"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"name": map[string]interface{}{
+				"type":        "string",
+				"description": "Name of the file",
+			},
+			"type": map[string]interface{}{
+				"type":        "string",
+				"description": "Mime type of the file",
+			},
+			"path": map[string]interface{}{
+				"type":        "string",
+				"description": "Path of the file in the cloud store",
+			},
+			"file": map[string]interface{}{
+				"type":        "string",
+				"format":      "byte",
+				"description": "Base64 encoded contents of the file, only sent when uploading",
+			},
+		},
+		"required": []string{"name"},
+	}
+
+	typeMap["Errors"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"errors": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"id": map[string]interface{}{
+							"type": "string",
+						},
+						"status": map[string]interface{}{
+							"type":        "string",
+							"description": "Http status of the error",
+						},
+						"code": map[string]interface{}{
+							"type": "string",
+						},
+						"title": map[string]interface{}{
+							"type": "string",
+						},
+						"detail": map[string]interface{}{
+							"type": "string",
+						},
+						"source": map[string]interface{}{
+							"type": "object",
+							"properties": map[string]interface{}{
+								"pointer": map[string]interface{}{
+									"type":        "string",
+									"description": "JSON pointer to the value in the request document",
+								},
+								"parameter": map[string]interface{}{
+									"type":        "string",
+									"description": "Query parameter of the error",
+								},
+							},
+						},
+						"meta": map[string]interface{}{
+							"type": "object",
+						},
+					},
+				},
+			},
+		},
+		"required": []string{"errors"},
+	}
+
+	typeMap["DaptinError"] = map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"Message": map[string]interface{}{
+				"type": "string",
+			},
+			"Code": map[string]interface{}{
+				"type": "string",
+			},
+		},
+	}
+
 	for _, tableInfo := range config.Tables {
 		ramlType := make(map[string]interface{})
 		// skip join tables
-		if strings.Index(tableInfo.TableName, "_has_") > -1 {
+		if isJoinTable(tableInfo.TableName) {
 			continue
 		}
 
@@ -211,10 +495,7 @@ func BuildApiBlueprint(config *resource.CmsConfig, cruds map[string]*resource.Db
 		requiredCols := make([]string, 0)
 		ramlType["type"] = "object"
 		for _, colInfo := range tableInfo.Columns {
-			if colInfo.IsForeignKey {
-				continue
-			}
-			if skipColumns[colInfo.ColumnName] {
+			if !isApiColumn(colInfo) {
 				continue
 			}
 
@@ -225,10 +506,23 @@ func BuildApiBlueprint(config *resource.CmsConfig, cruds map[string]*resource.Db
 			properties[colInfo.ColumnName] = CreateColumnLine(colInfo)
 		}
 
+		// computed columns are returned but cannot be set
+		for _, computedColumn := range tableInfo.ComputedColumns {
+			property := CreateColumnLine(api2go.ColumnInfo{ColumnType: computedColumn.GetColumnType()})
+			property["readOnly"] = true
+			if computedColumn.Description != "" {
+				property["description"] = computedColumn.Description
+			}
+			properties[computedColumn.Name] = property
+		}
+
 		ramlType["properties"] = properties
-		ramlType["required"] = requiredCols
+		if len(requiredCols) > 0 {
+			ramlType["required"] = requiredCols
+		}
 
 		typeMap[strcase.ToCamel(tableInfo.TableName)] = ramlType
+		typeMap[strcase.ToCamel(tableInfo.TableName)+"Resource"] = CreateDataInResponse(tableInfo)
 
 		//worldActions, err := cruds["action"].GetActionsByType(tableInfo.TableName)
 		//if InfoError(err, "Failed to list world actions for raml") {
@@ -237,62 +531,63 @@ func BuildApiBlueprint(config *resource.CmsConfig, cruds map[string]*resource.Db
 
 	}
 	for _, tableInfo := range config.Tables {
-		ramlType := make(map[string]interface{})
 		// skip join tables
-		if strings.Index(tableInfo.TableName, "_has_") > -1 {
+		if isJoinTable(tableInfo.TableName) {
 			continue
 		}
 
-		properties := make(map[string]interface{})
-		requiredCols := make([]string, 0)
-		ramlType["type"] = "object"
-		for _, colInfo := range tableInfo.Columns {
-			if colInfo.IsForeignKey {
-				continue
-			}
-			if skipColumns[colInfo.ColumnName] {
-				continue
-			}
-			if resource.IsStandardColumn(colInfo.ColumnName) {
-				continue
-			}
+		typeMap["New"+strcase.ToCamel(tableInfo.TableName)] = CreateAttributesSchema(tableInfo, true)
+		typeMap["Update"+strcase.ToCamel(tableInfo.TableName)] = CreateAttributesSchema(tableInfo, false)
 
-			if !colInfo.IsNullable && colInfo.DefaultValue == "" {
-				requiredCols = append(requiredCols, colInfo.ColumnName)
-			}
+	}
 
-			properties[colInfo.ColumnName] = CreateColumnLine(colInfo)
+	for _, stream := range config.Streams {
+		properties := make(map[string]interface{})
+		for _, colInfo := range stream.Columns {
+			properties[columnName(colInfo)] = CreateColumnLine(colInfo)
 		}
-
-		ramlType["properties"] = properties
-		ramlType["required"] = requiredCols
-
-		typeMap["New"+strcase.ToCamel(tableInfo.TableName)] = ramlType
-
+		streamName := strcase.ToCamel(stream.StreamName) + "Stream"
+		typeMap[streamName] = map[string]interface{}{
+			"type":       "object",
+			"properties": properties,
+		}
+		typeMap[streamName+"Resource"] = CreateResourceSchema(stream.StreamName, streamName, nil)
 	}
 
 	for _, action := range config.Actions {
-		ramlActionType := make(map[string]interface{})
-		// ramlActionType["type"] = "object"
-
-		actionProperties := make(map[string]interface{})
-		for _, colInfo := range action.InFields {
-			if colInfo.IsForeignKey {
-				continue
-			}
-			if skipColumns[colInfo.ColumnName] {
-				continue
-			}
-
-			actionProperties[colInfo.ColumnName] = CreateColumnLine(colInfo)
-		}
-		ramlActionType["properties"] = actionProperties
-		typeMap[fmt.Sprintf("%s%sObject", strcase.ToCamel(action.Name), strcase.ToCamel(action.OnType))] = ramlActionType
-
+		typeMap[ActionSchemaName(action)] = CreateActionSchema(action)
 	}
 
 	apiDefinition["components"] = map[string]interface{}{
 		"schemas": typeMap,
+		"securitySchemes": map[string]interface{}{
+			"basicAuth": map[string]interface{}{
+				"type":        "http",
+				"scheme":      "basic",
+				"description": "The email and password of the user, used by the dav clients",
+			},
+			"bearerAuth": map[string]interface{}{
+				"type":         "http",
+				"scheme":       "bearer",
+				"bearerFormat": "JWT",
+				"description":  "The token returned by the signin action on user_account",
+			},
+			"tokenParameter": map[string]interface{}{
+				"type":        "apiKey",
+				"in":          "query",
+				"name":        "token",
+				"description": "The JWT token as a query parameter",
+			},
+			"tokenCookie": map[string]interface{}{
+				"type":        "apiKey",
+				"in":          "cookie",
+				"name":        "token",
+				"description": "The JWT token as a cookie",
+			},
+		},
+		"responses": map[string]interface{}{
+			"Error": contentResponse("The request failed", jsonApiMediaType, schemaRef("Errors")),
+		},
 	}
 
 	resourcesMap := map[string]map[string]interface{}{}
@@ -304,13 +599,13 @@ func BuildApiBlueprint(config *resource.CmsConfig, cruds map[string]*resource.Db
 	for _, tableInfo := range config.Tables {
 
 		// skip join tables
-		if strings.Index(tableInfo.TableName, "_has_") > -1 {
+		if isJoinTable(tableInfo.TableName) {
 			continue
 		}
 
 		resourceInstance := make(map[string]interface{})
 
-		dataInResponse := CreateDataInResponse(tableInfo)
+		dataInResponse := schemaRef(strcase.ToCamel(tableInfo.TableName) + "Resource")
 
 		// BEGIN: POST request
 		postMethod := CreatePostMethod(tableInfo, dataInResponse)
@@ -345,89 +640,42 @@ func BuildApiBlueprint(config *resource.CmsConfig, cruds map[string]*resource.Db
 
 		nestedMap["/api/"+tableInfo.TableName+"/{referenceId}"] = byIdResource
 
-		for _, rel := range tableInfo.Relations {
+		for _, reference := range tableReferences(tableInfo) {
 
 			// BEGIN: Get Relations Method
 
-			relationsById := make(map[string]interface{})
-
-			if tableInfo.TableName == rel.Subject {
-				relatedTable := tableInfoMap[rel.Object]
-				getMethod := CreateGetAllMethod(relatedTable, CreateDataInResponse(relatedTable))
-
-				getMethod["operationId"] = "Related" + strcase.ToCamel(rel.ObjectName) + "Of" + strcase.ToCamel(tableInfo.TableName)
-				getMethod["summary"] = fmt.Sprintf("Fetch related %s of %v", rel.ObjectName, tableInfo.TableName)
-
-				getMethod["parameters"] = []map[string]interface{}{
-					{
-						"name": "referenceId",
-						"schema": map[string]interface{}{
-							"type": "string",
-						},
-						"required":    true,
-						"in":          "path",
-						"description": "Reference Id of the " + tableInfo.TableName,
-					},
-				}
-
-				deleteMethod := CreateDeleteRelationMethod(relatedTable)
-				deleteMethod["summary"] = fmt.Sprintf("Delete related %s of %v", rel.ObjectName, tableInfo.TableName)
-				deleteMethod["operationId"] = "Delete" + strcase.ToCamel(rel.ObjectName) + "Of" + strcase.ToCamel(tableInfo.TableName)
-
-				relationsById["get"] = getMethod
-				relationsById["delete"] = deleteMethod
+			relatedTable, ok := tableInfoMap[reference.Target]
+			if !ok {
+				continue
+			}
+			relationName := strcase.ToCamel(reference.Name) + "Of" + strcase.ToCamel(tableInfo.TableName)
+
+			getMethod := CreateGetAllMethod(relatedTable, schemaRef(strcase.ToCamel(relatedTable.TableName)+"Resource"))
+			getMethod["operationId"] = "Related" + relationName
+			getMethod["summary"] = fmt.Sprintf("Fetch related %s of %v", reference.Name, tableInfo.TableName)
+			getMethod["tags"] = []string{tableInfo.TableName}
+			getMethod["parameters"] = append([]map[string]interface{}{
+				referenceIdParameter(tableInfo.TableName),
+			}, getMethod["parameters"].([]map[string]interface{})...)
+
+			nestedMap[fmt.Sprintf("/api/%s/{referenceId}/%s", tableInfo.TableName, reference.Name)] = map[string]interface{}{
+				"get": getMethod,
+			}
 
-				deleteMethod["parameters"] = []map[string]interface{}{
-					{
-						"name": "referenceId",
-						"schema": map[string]interface{}{
-							"type": "string",
-						},
-						"required":    true,
-						"in":          "path",
-						"description": "Reference Id of the " + tableInfo.TableName,
-					},
-				}
-
-				nestedMap[fmt.Sprintf("/api/%s/{referenceId}/%s", tableInfo.TableName, rel.Object)] = relationsById
-			} else {
-				relatedTable := tableInfoMap[rel.Subject]
-				getMethod := CreateGetAllMethod(relatedTable, CreateDataInResponse(relatedTable))
-				getMethod["operationId"] = "Related" + strcase.ToCamel(rel.SubjectName) + "Of" + strcase.ToCamel(tableInfo.TableName)
-				patchMethod["summary"] = fmt.Sprintf("Fetch related %s of %v", rel.SubjectName, tableInfo.TableName)
-				deleteMethod := CreateDeleteRelationMethod(relatedTable)
-				deleteMethod["operationId"] = "Delete" + strcase.ToCamel(relatedTable.TableName) + "Of" + strcase.ToCamel(tableInfo.TableName)
-				patchMethod["summary"] = fmt.Sprintf("Delete related %s of %v", rel.SubjectName, tableInfo.TableName)
-				relationsById["get"] = getMethod
-
-				getMethod["parameters"] = []map[string]interface{}{
-					{
-						"name": "referenceId",
-						"schema": map[string]interface{}{
-							"type": "string",
-						},
-						"required":    true,
-						"in":          "path",
-						"description": "Reference Id of the " + tableInfo.TableName,
-					},
-				}
+			// END: Get relations method
 
-				deleteMethod["parameters"] = []map[string]interface{}{
-					{
-						"name": "referenceId",
-						"schema": map[string]interface{}{
-							"type": "string",
-						},
-						"required":    true,
-						"in":          "path",
-						"description": "Reference Id of the " + tableInfo.TableName,
-					},
-				}
+			relationshipsById := map[string]interface{}{
+				"get":   CreateGetRelationshipMethod(tableInfo, reference),
+				"patch": CreateReplaceRelationshipMethod(tableInfo, reference),
+			}
 
-				relationsById["delete"] = deleteMethod
-				nestedMap[fmt.Sprintf("/api/%s/{referenceId}/%s", tableInfo.TableName, rel.Subject)] = relationsById
+			// api2go serves the routes to add and remove related objects for the plural names
+			if reference.Name == jsonapi.Pluralize(reference.Name) {
+				relationshipsById["post"] = CreateAddRelationshipMethod(tableInfo, reference)
+				relationshipsById["delete"] = CreateDeleteRelationMethod(tableInfo, reference)
 			}
-			// END: Get relations method
+
+			nestedMap[fmt.Sprintf("/api/%s/{referenceId}/relationships/%s", tableInfo.TableName, reference.Name)] = relationshipsById
 
 		}
 
@@ -444,87 +692,235 @@ func BuildApiBlueprint(config *resource.CmsConfig, cruds map[string]*resource.Db
 		resourcesMap["/api/"+tableInfo.TableName] = resourceInstance
 	}
 
+	for _, stream := range config.Streams {
+		streamName := strcase.ToCamel(stream.StreamName) + "Stream"
+		getAllMethod := CreateGetAllMethod(resource.TableInfo{TableName: stream.StreamName}, schemaRef(streamName+"Resource"))
+		getAllMethod["operationId"] = "List" + streamName
+		getAllMethod["summary"] = fmt.Sprintf("List the rows of the %v stream", stream.StreamName)
+		getAllMethod["description"] = fmt.Sprintf("Returns the rows of %v transformed by the %v stream", stream.RootEntityName, stream.StreamName)
+		getAllMethod["tags"] = []string{"stream"}
+		resourcesMap["/api/"+stream.StreamName] = map[string]interface{}{
+			"get": getAllMethod,
+		}
+	}
+
 	for _, action := range config.Actions {
 
-		resourcesMap[fmt.Sprintf("/action/%s/%s", action.OnType, action.Name)] = map[string]interface{}{
-			"post": map[string]interface{}{
-				"tags":        []string{action.OnType},
-				"operationId": "Execute" + strcase.ToCamel(action.Name) + "On" + strcase.ToCamel(action.OnType),
-				"summary":     action.Label,
-				"requestBody": map[string]interface{}{
-					"content": map[string]interface{}{
-						"application/json": map[string]interface{}{
-							"schema": map[string]interface{}{
-								"$ref": "#/components/schemas/" + fmt.Sprintf("%s%sObject", strcase.ToCamel(action.Name), strcase.ToCamel(action.OnType)),
-							},
-						},
-					},
-				},
-				"responses": map[string]interface{}{
-					"200": map[string]interface{}{
-
-						"description": "action response of " + action.Name,
-						"content": map[string]interface{}{
-							"application/json": map[string]interface{}{
-								"schema": map[string]interface{}{
-									"type": "array",
-									"items": map[string]interface{}{
-										"$ref": "#/components/schemas/ActionResponse",
-									},
-								},
-							},
-						},
-					},
-				},
-			},
+		actionPath := map[string]interface{}{
+			"post": CreateActionMethod(action, true),
 		}
+		// without a body the action has no inputs, so it can be called with a GET when none are required
+		if action.InstanceOptional && len(requiredActionFields(action)) == 0 {
+			actionPath["get"] = CreateActionMethod(action, false)
+		}
+		resourcesMap[fmt.Sprintf("/action/%s/%s", action.OnType, action.Name)] = actionPath
 
 	}
 
-	actionResource := make(map[string]interface{})
+	for path, pathItem := range CreateServerPaths(config, tableInfoMap) {
+		resourcesMap[path] = pathItem
+	}
 
 	apiDefinition["paths"] = resourcesMap
-	for n, v := range actionResource {
-		apiDefinition[n] = v
-	}
 
 	ym, _ := yaml.Marshal(apiDefinition)
 	return string(ym)
 
 }
 
-func CreateDataInResponse(tableInfo resource.TableInfo) map[string]interface{} {
-	relationshipMap := make(map[string]interface{}, 0)
-	for _, relation := range tableInfo.Relations {
-		if relation.Object == tableInfo.TableName {
-			relationshipMap[relation.SubjectName] = "IncludedRelationship"
-		} else {
-			relationshipMap[relation.ObjectName] = "IncludedRelationship"
-		}
+// CreateResourceSchema is the schema of the JSON:API resource objects of a
+// type, with the attributes of the attributes schema
+func CreateResourceSchema(typeName string, attributesSchema string, references []relationReference) map[string]interface{} {
+	relationshipMap := make(map[string]interface{})
+	for _, reference := range references {
+		relationshipMap[reference.Name] = schemaRef("IncludedRelationship")
 	}
 
-	var dataInResponse = map[string]interface{}{
+	return map[string]interface{}{
 		"type": "object",
 		"properties": map[string]interface{}{
-			"attributes": map[string]interface{}{
-				"schema": map[string]interface{}{
-					"$ref": "#/components/schemas/New" + strcase.ToCamel(tableInfo.TableName),
-				},
-			},
+			"attributes": schemaRef(attributesSchema),
 			"id": map[string]interface{}{
 				"type": "string",
 			},
 			"type": map[string]interface{}{
 				"type": "string",
+				"enum": []string{typeName},
 			},
 			"relationships": map[string]interface{}{
 				"type":       "object",
 				"properties": relationshipMap,
 			},
+			"links": map[string]interface{}{
+				"type": "object",
+			},
+		},
+		"required": []string{"type", "id"},
+	}
+}
+
+func CreateDataInResponse(tableInfo resource.TableInfo) map[string]interface{} {
+	return CreateResourceSchema(tableInfo.TableName, strcase.ToCamel(tableInfo.TableName), tableReferences(tableInfo))
+}
+
+// CreateAttributesSchema is the schema of the attributes sent to create a row,
+// or to update a row when the values are not required
+func CreateAttributesSchema(tableInfo resource.TableInfo, requireValues bool) map[string]interface{} {
+	ramlType := make(map[string]interface{})
+	properties := make(map[string]interface{})
+	requiredCols := make([]string, 0)
+	ramlType["type"] = "object"
+	for _, colInfo := range tableInfo.Columns {
+		if !isApiColumn(colInfo) {
+			continue
+		}
+		if resource.IsStandardColumn(colInfo.ColumnName) {
+			continue
+		}
+
+		if requireValues && !colInfo.IsNullable && colInfo.DefaultValue == "" {
+			requiredCols = append(requiredCols, colInfo.ColumnName)
+		}
+
+		properties[colInfo.ColumnName] = CreateColumnLine(colInfo)
+	}
+
+	ramlType["properties"] = properties
+	if len(requiredCols) > 0 {
+		ramlType["required"] = requiredCols
+	}
+	return ramlType
+}
+
+// CreateRelationshipsSchema is the schema of the relationships sent with a row
+func CreateRelationshipsSchema(tableInfo resource.TableInfo) map[string]interface{} {
+	relationshipMap := make(map[string]interface{})
+	for _, reference := range tableReferences(tableInfo) {
+		relationshipMap[reference.Name] = relationshipDocument(reference)
+	}
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": relationshipMap,
+	}
+}
+
+// relationshipDocument is the document with the linkage of a relationship, an
+// object for relations to one and a list for relations to many
+func relationshipDocument(reference relationReference) map[string]interface{} {
+	data := schemaRef("RelatedStructure")
+	if reference.Many {
+		data = map[string]interface{}{
+			"type":  "array",
+			"items": schemaRef("RelatedStructure"),
+		}
+	}
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"data": data,
+		},
+		"required": []string{"data"},
+	}
+}
+
+// ActionSchemaName is the name of the schema of the inputs of the action
+func ActionSchemaName(action resource.Action) string {
+	return fmt.Sprintf("%s%sObject", strcase.ToCamel(action.Name), strcase.ToCamel(action.OnType))
+}
+
+// requiredActionFields are the inputs the action cannot run without, the
+// reference id of the object and the fields validated as required
+func requiredActionFields(action resource.Action) []string {
+	required := make([]string, 0)
+	if !action.InstanceOptional {
+		required = append(required, action.OnType+"_id")
+	}
+	for _, validation := range action.Validations {
+		for _, tag := range strings.Split(validation.Tags, ",") {
+			if tag == "required" {
+				required = append(required, validation.ColumnName)
+				break
+			}
+		}
+	}
+	return required
+}
+
+// CreateActionSchema is the schema of the inputs of the action, from its in
+// fields
+func CreateActionSchema(action resource.Action) map[string]interface{} {
+	actionProperties := make(map[string]interface{})
+	if !action.InstanceOptional {
+		actionProperties[action.OnType+"_id"] = map[string]interface{}{
+			"type":        "string",
+			"description": "Reference id of the " + action.OnType + " to run the action on",
+		}
+	}
+	for _, colInfo := range action.InFields {
+		if skipColumns[columnName(colInfo)] {
+			continue
+		}
+
+		actionProperties[columnName(colInfo)] = CreateColumnLine(colInfo)
+	}
+
+	ramlActionType := map[string]interface{}{
+		"type":       "object",
+		"properties": actionProperties,
+	}
+	if required := requiredActionFields(action); len(required) > 0 {
+		ramlActionType["required"] = required
+	}
+	return ramlActionType
+}
+
+// CreateActionMethod runs the action, with the inputs in the body or without
+// any inputs
+func CreateActionMethod(action resource.Action, withBody bool) map[string]interface{} {
+	actionResponses := map[string]interface{}{
+		"type":  "array",
+		"items": schemaRef("ActionResponse"),
+	}
+	summary := action.Label
+	if summary == "" {
+		summary = fmt.Sprintf("Execute %v on %v", action.Name, action.OnType)
+	}
+
+	actionMethod := map[string]interface{}{
+		"tags":        []string{action.OnType},
+		"operationId": "Execute" + strcase.ToCamel(action.Name) + "On" + strcase.ToCamel(action.OnType),
+		"summary":     summary,
+		"responses": map[string]interface{}{
+			"200": contentResponse("action response of "+action.Name, "application/json", actionResponses),
+			"400": contentResponse("invalid inputs of "+action.Name, "application/json", actionResponses),
+			"403": contentResponse("the user is not allowed to execute "+action.Name, "application/json", actionResponses),
+			"500": contentResponse("failed to execute "+action.Name, "application/json", actionResponses),
+		},
+	}
+
+	if !withBody {
+		actionMethod["operationId"] = actionMethod["operationId"].(string) + "WithoutInputs"
+		actionMethod["description"] = "Executes the action without any inputs"
+		return actionMethod
+	}
+
+	actionMethod["requestBody"] = map[string]interface{}{
+		"required": true,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{
+				"schema": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"attributes": schemaRef(ActionSchemaName(action)),
+					},
+					"required": []string{"attributes"},
+				},
+			},
 		},
 	}
-	return dataInResponse
+	return actionMethod
 }
+
 func CreatePostMethod(tableInfo resource.TableInfo, dataInResponse map[string]interface{}) map[string]interface{} {
 	postMethod := make(map[string]interface{})
 	postMethod["operationId"] = fmt.Sprintf("Create%s", strcase.ToCamel(tableInfo.TableName))
@@ -535,111 +931,79 @@ func CreatePostMethod(tableInfo resource.TableInfo, dataInResponse map[string]in
 	postBody["description"] = tableInfo.TableName + " to create"
 	postBody["required"] = true
 	postBody["content"] = map[string]interface{}{
-		"application/json": map[string]interface{}{
+		jsonApiMediaType: map[string]interface{}{
 			"schema": map[string]interface{}{
 				"type": "object",
 				"properties": map[string]interface{}{
 					"data": map[string]interface{}{
 						"type": "object",
 						"properties": map[string]interface{}{
-							"attributes": map[string]interface{}{
-								"$ref": "#/components/schemas/New" + strcase.ToCamel(tableInfo.TableName),
+							"type": map[string]interface{}{
+								"type": "string",
+								"enum": []string{tableInfo.TableName},
 							},
+							"attributes":    schemaRef("New" + strcase.ToCamel(tableInfo.TableName)),
+							"relationships": CreateRelationshipsSchema(tableInfo),
 						},
+						"required": []string{"type", "attributes"},
 					},
 				},
+				"required": []string{"data"},
 			},
 		},
 	}
 	postMethod["requestBody"] = postBody
 	postResponseMap := make(map[string]interface{})
-	postResponseBody := make(map[string]interface{})
-	postResponseBody["type"] = "object"
 
-	postResponseBody = map[string]interface{}{
+	postResponseBody := map[string]interface{}{
 		"type": "object",
 		"properties": map[string]interface{}{
-			"data": map[string]interface{}{
-				"$ref": "#/components/schemas/" + strcase.ToCamel(tableInfo.TableName),
-			},
-			"links": map[string]interface{}{
-				"$ref": "#/components/schemas/PaginationStatus",
-			},
-		},
-	}
-	postOkResponse := make(map[string]interface{})
-	postOkResponse["description"] = tableInfo.TableName + " response"
-
-	postOkResponse["content"] = map[string]interface{}{
-		"application/json": map[string]interface{}{
-			"schema": postResponseBody,
+			"data": dataInResponse,
 		},
 	}
 
-	postResponseMap["200"] = postOkResponse
-	postMethod["responses"] = postResponseMap
+	postResponseMap["201"] = contentResponse(tableInfo.TableName+" response", jsonApiMediaType, postResponseBody)
+	postMethod["responses"] = errorResponses(postResponseMap)
 	return postMethod
 }
 func CreateGetAllMethod(tableInfo resource.TableInfo, dataInResponse map[string]interface{}) map[string]interface{} {
 	getAllMethod := make(map[string]interface{})
 	getAllMethod["description"] = fmt.Sprintf("Returns a list of %v", ProperCase(tableInfo.TableName))
-	getAllMethod["operationId"] = fmt.Sprintf("Get" + tableInfo.TableName)
+	getAllMethod["operationId"] = "List" + strcase.ToCamel(tableInfo.TableName)
 	getAllMethod["summary"] = fmt.Sprintf("List all %v", tableInfo.TableName)
 	getAllMethod["tags"] = []string{tableInfo.TableName}
+	stringSchema := map[string]interface{}{
+		"type": "string",
+	}
 	getAllMethod["parameters"] = []map[string]interface{}{
-		{
-			"name": "sort",
-			"schema": map[string]interface{}{
-				"type": "string",
-			},
-			"required":    false,
-			"in":          "query",
-			"description": "Field name to sort by",
-		},
-		{
-			"name": "page[number]",
-			"in":   "query",
-			"schema": map[string]interface{}{
-				"type": "string",
-			},
-			"required":    false,
-			"description": "Page number for the query set, starts with 1",
-		},
-		{
-			"schema": map[string]interface{}{
-				"type": "string",
-			},
-			"name":        "page[size]",
-			"required":    false,
-			"in":          "query",
-			"description": "Size of one page, try 10",
-		},
-		{
-			"schema": map[string]interface{}{
-				"type": "string",
-			},
-			"in":          "query",
-			"name":        "query",
-			"required":    false,
-			"description": "search text in indexed columns",
-		},
+		queryParameter("sort", "Field name to sort by, descending with a - prefix", stringSchema),
+		queryParameter("page[number]", "Page number for the query set, starts with 1", stringSchema),
+		queryParameter("page[size]", "Size of one page, try 10", stringSchema),
+		queryParameter("page[after]", "Reference id of the object after which to list, instead of the page number", stringSchema),
+		queryParameter("page[before]", "Reference id of the object before which to list, instead of the page number", stringSchema),
+		queryParameter("query", "search text in indexed columns, or a json list of column filters", stringSchema),
+		queryParameter("filter", "filter on the searchable columns", stringSchema),
+		queryParameter("include", "comma separated names of the relations to include", stringSchema),
+		queryParameter("fields", "comma separated names of the columns to return", stringSchema),
 	}
 	getResponseMap := make(map[string]interface{})
-	get200Response := make(map[string]interface{})
-	get200Response["description"] = "list of all " + tableInfo.TableName
-	get200Response["content"] = map[string]interface{}{
-		"application/json": map[string]interface{}{
-			"schema": map[string]interface{}{
-				"type": "array",
-				"items": map[string]interface{}{
-					"$ref": "#/components/schemas/" + strcase.ToCamel(tableInfo.TableName),
-				},
+	getResponseMap["200"] = contentResponse("list of all "+tableInfo.TableName, jsonApiMediaType, map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"data": map[string]interface{}{
+				"type":  "array",
+				"items": dataInResponse,
 			},
+			"included": map[string]interface{}{
+				"type":  "array",
+				"items": schemaRef("IncludedObject"),
+			},
+			"links": schemaRef("PaginationStatus"),
 		},
-	}
+		"required": []string{"data"},
+	})
 
-	getResponseMap["200"] = get200Response
-	getAllMethod["responses"] = getResponseMap
+	getAllMethod["responses"] = errorResponses(getResponseMap)
 	return getAllMethod
 }
 func ProperCase(str string) string {
@@ -651,86 +1015,112 @@ func ProperCase(str string) string {
 
 func CreateDeleteMethod(tableInfo resource.TableInfo) map[string]interface{} {
 	deleteByIdMethod := make(map[string]interface{})
-	deleteByIdMethod200Response := make(map[string]interface{})
 	deleteByIdResponseMap := make(map[string]interface{})
-	deleteByIdMethod200Response["description"] = "delete " + tableInfo.TableName + " by reference id"
-	deleteByIdResponseMap["200"] = deleteByIdMethod200Response
-	deleteByIdMethod["responses"] = deleteByIdResponseMap
+	deleteByIdResponseMap["200"] = contentResponse("delete "+tableInfo.TableName+" by reference id", jsonApiMediaType, map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"meta": map[string]interface{}{
+				"type": "object",
+			},
+		},
+	})
+	deleteByIdMethod["responses"] = errorResponses(deleteByIdResponseMap)
 
+	deleteByIdMethod["operationId"] = fmt.Sprintf("Delete%s", strcase.ToCamel(tableInfo.TableName))
 	deleteByIdMethod["description"] = fmt.Sprintf("Delete a %v", tableInfo.TableName)
 
 	deleteByIdMethod["summary"] = fmt.Sprintf("Delete %v", tableInfo.TableName)
 	deleteByIdMethod["tags"] = []string{tableInfo.TableName}
 	deleteByIdMethod["parameters"] = []map[string]interface{}{
-		{
-			"name": "referenceId",
-			"schema": map[string]interface{}{
-				"type": "string",
-			},
-			"required":    true,
-			"in":          "path",
-			"description": "Reference Id of the " + tableInfo.TableName,
-		},
+		referenceIdParameter(tableInfo.TableName),
 	}
 
 	return deleteByIdMethod
 }
 
-func CreateDeleteRelationMethod(tableInfo resource.TableInfo) map[string]interface{} {
-	deleteByIdMethod := make(map[string]interface{})
-	deleteByIdMethod200Response := make(map[string]interface{})
-	deleteByIdMethod200Response["description"] = "Successful deletion of " + tableInfo.TableName
-	deleteBody := make(map[string]interface{})
-	deleteBody["type"] = tableInfo.TableName
-	deleteByIdMethod["description"] = "Delete a " + tableInfo.TableName
+// CreateGetRelationshipMethod returns the linkage of the related objects
+func CreateGetRelationshipMethod(tableInfo resource.TableInfo, reference relationReference) map[string]interface{} {
+	return map[string]interface{}{
+		"operationId": "Get" + strcase.ToCamel(reference.Name) + "RelationshipOf" + strcase.ToCamel(tableInfo.TableName),
+		"summary":     fmt.Sprintf("Get the %s relationship of %v", reference.Name, tableInfo.TableName),
+		"tags":        []string{tableInfo.TableName},
+		"parameters": []map[string]interface{}{
+			referenceIdParameter(tableInfo.TableName),
+		},
+		"responses": errorResponses(map[string]interface{}{
+			"200": contentResponse("related "+reference.Target+" of "+tableInfo.TableName, jsonApiMediaType, relationshipDocument(reference)),
+		}),
+	}
+}
 
-	deleteByIdResponseMap := make(map[string]interface{})
-	deleteByIdResponseMap["200"] = deleteByIdMethod200Response
-	deleteByIdMethod["responses"] = deleteByIdResponseMap
-	deleteByIdMethod["description"] = fmt.Sprintf("Remove a related %v from the parent object", tableInfo.TableName)
-	deleteByIdMethod["tags"] = []string{tableInfo.TableName}
-	deleteByIdMethod["parameters"] = []map[string]interface{}{
-		{
-			"name": "referenceId",
-			"schema": map[string]interface{}{
-				"type": "string",
+func relationshipChangeMethod(operationId string, summary string, tableInfo resource.TableInfo, reference relationReference) map[string]interface{} {
+	return map[string]interface{}{
+		"operationId": operationId,
+		"summary":     summary,
+		"tags":        []string{tableInfo.TableName},
+		"parameters": []map[string]interface{}{
+			referenceIdParameter(tableInfo.TableName),
+		},
+		"requestBody": map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				jsonApiMediaType: map[string]interface{}{
+					"schema": relationshipDocument(reference),
+				},
 			},
-			"required":    true,
-			"in":          "path",
-			"description": "Reference Id of the " + tableInfo.TableName,
 		},
+		"responses": errorResponses(map[string]interface{}{
+			"204": map[string]interface{}{
+				"description": "the relationship is updated",
+			},
+		}),
 	}
+}
 
-	return deleteByIdMethod
+// CreateReplaceRelationshipMethod replaces the related objects
+func CreateReplaceRelationshipMethod(tableInfo resource.TableInfo, reference relationReference) map[string]interface{} {
+	return relationshipChangeMethod("Replace"+strcase.ToCamel(reference.Name)+"Of"+strcase.ToCamel(tableInfo.TableName),
+		fmt.Sprintf("Replace related %s of %v", reference.Name, tableInfo.TableName), tableInfo, reference)
+}
+
+// CreateAddRelationshipMethod adds objects to the related objects
+func CreateAddRelationshipMethod(tableInfo resource.TableInfo, reference relationReference) map[string]interface{} {
+	return relationshipChangeMethod("Add"+strcase.ToCamel(reference.Name)+"To"+strcase.ToCamel(tableInfo.TableName),
+		fmt.Sprintf("Add related %s to %v", reference.Name, tableInfo.TableName), tableInfo, reference)
+}
+
+// CreateDeleteRelationMethod removes objects from the related objects
+func CreateDeleteRelationMethod(tableInfo resource.TableInfo, reference relationReference) map[string]interface{} {
+	deleteMethod := relationshipChangeMethod("Delete"+strcase.ToCamel(reference.Name)+"Of"+strcase.ToCamel(tableInfo.TableName),
+		fmt.Sprintf("Delete related %s of %v", reference.Name, tableInfo.TableName), tableInfo, reference)
+	deleteMethod["description"] = fmt.Sprintf("Remove a related %v from the parent object", reference.Target)
+	return deleteMethod
 }
 
 func CreateGetMethod(tableInfo resource.TableInfo, dataInResponse map[string]interface{}) map[string]interface{} {
 	getByIdMethod := make(map[string]interface{})
-	getByIdMethod200Response := make(map[string]interface{})
+	getByIdMethod["operationId"] = fmt.Sprintf("Get%s", strcase.ToCamel(tableInfo.TableName))
 	getByIdMethod["tags"] = []string{tableInfo.TableName}
-	getByIdMethod200Response["description"] = "get " + tableInfo.TableName + " by reference id"
-	getByIdMethod200Response["content"] = map[string]interface{}{
-		"application/json": map[string]interface{}{
-			"schema": map[string]interface{}{
-				"$ref": "#/components/schemas/" + strcase.ToCamel(tableInfo.TableName),
-			},
-		},
-	}
 
 	getByIdResponseMap := make(map[string]interface{})
-	getByIdResponseMap["200"] = getByIdMethod200Response
-	getByIdMethod["responses"] = getByIdResponseMap
-
-	getByIdMethod["parameters"] = []map[string]interface{}{
-		{
-			"name": "referenceId",
-			"schema": map[string]interface{}{
-				"type": "string",
+	getByIdResponseMap["200"] = contentResponse("get "+tableInfo.TableName+" by reference id", jsonApiMediaType, map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"data": dataInResponse,
+			"included": map[string]interface{}{
+				"type":  "array",
+				"items": schemaRef("IncludedObject"),
 			},
-			"required":    true,
-			"in":          "path",
-			"description": "Reference Id of the " + tableInfo.TableName,
 		},
+		"required": []string{"data"},
+	})
+	getByIdMethod["responses"] = errorResponses(getByIdResponseMap)
+
+	getByIdMethod["parameters"] = []map[string]interface{}{
+		referenceIdParameter(tableInfo.TableName),
+		queryParameter("include", "comma separated names of the relations to include", map[string]interface{}{
+			"type": "string",
+		}),
 	}
 
 	getByIdMethod["summary"] = fmt.Sprintf("Get %v by id", tableInfo.TableName)
@@ -744,93 +1134,47 @@ func CreatePatchMethod(tableInfo resource.TableInfo) map[string]interface{} {
 	patchMethod["summary"] = fmt.Sprintf("Update existing %v", tableInfo.TableName)
 	patchMethod["description"] = fmt.Sprintf("Edit an existing %s", tableInfo.TableName)
 	patchMethod["tags"] = []string{tableInfo.TableName}
-	patchBody := make(map[string]interface{})
-	patchBody["type"] = tableInfo.TableName
 	patchResponseMap := make(map[string]interface{})
-	patchOkResponse := make(map[string]interface{})
-	patchResponseBody := make(map[string]interface{})
-	patchResponseBody["type"] = "object"
-	patchRelationshipMap := make(map[string]interface{}, 0)
 
 	patchMethod["requestBody"] = map[string]interface{}{
+		"required": true,
 		"content": map[string]interface{}{
-			"application/json": map[string]interface{}{
+			jsonApiMediaType: map[string]interface{}{
 				"schema": map[string]interface{}{
 					"type": "object",
 					"properties": map[string]interface{}{
 						"data": map[string]interface{}{
 							"type": "object",
 							"properties": map[string]interface{}{
-								"attributes": map[string]interface{}{
-									"$ref": "#/components/schemas/" + strcase.ToCamel(tableInfo.TableName),
+								"type": map[string]interface{}{
+									"type": "string",
+									"enum": []string{tableInfo.TableName},
 								},
+								"attributes":    schemaRef("Update" + strcase.ToCamel(tableInfo.TableName)),
+								"relationships": CreateRelationshipsSchema(tableInfo),
 								"id": map[string]interface{}{
 									"type": "string",
 								},
 							},
+							"required": []string{"type", "id"},
 						},
 					},
+					"required": []string{"data"},
 				},
 			},
 		},
 	}
 
-	for _, relation := range tableInfo.Relations {
-		if relation.Object == tableInfo.TableName {
-			patchRelationshipMap[relation.SubjectName] = map[string]interface{}{
-				"$ref": "#/components/schemas/IncludedRelationship",
-			}
-		} else {
-			patchRelationshipMap[relation.ObjectName] = map[string]interface{}{
-				"$ref": "#/components/schemas/IncludedRelationship",
-			}
-		}
-	}
-	var patchDataInResponse = map[string]interface{}{
+	patchResponseBody := map[string]interface{}{
 		"type": "object",
 		"properties": map[string]interface{}{
-			"attributes": map[string]interface{}{
-				"$ref": "#/components/schemas/" + strcase.ToCamel(tableInfo.TableName),
-			},
-			"id": map[string]interface{}{
-				"type": "string",
-			},
-			"type": map[string]interface{}{
-				"type": "string",
-			},
-			"relationships": map[string]interface{}{
-				"type":       "object",
-				"properties": patchRelationshipMap,
-			},
+			"data": schemaRef(strcase.ToCamel(tableInfo.TableName) + "Resource"),
 		},
 	}
-	patchResponseBody = map[string]interface{}{
-		"type": "object",
-		"properties": map[string]interface{}{
-			"data": patchDataInResponse,
-			"links": map[string]interface{}{
-				"$ref": "#/components/schemas/PaginationStatus",
-			},
-		},
-	}
-	patchOkResponse["content"] = map[string]interface{}{
-		"application/json": map[string]interface{}{
-			"schema": patchResponseBody,
-		},
-	}
-	patchOkResponse["description"] = "updated " + tableInfo.TableName
-	patchResponseMap["200"] = patchOkResponse
+	patchResponseMap["200"] = contentResponse("updated "+tableInfo.TableName, jsonApiMediaType, patchResponseBody)
 	patchMethod["parameters"] = []map[string]interface{}{
-		{
-			"name": "referenceId",
-			"schema": map[string]interface{}{
-				"type": "string",
-			},
-			"required":    true,
-			"in":          "path",
-			"description": "Reference Id of the " + tableInfo.TableName,
-		},
+		referenceIdParameter(tableInfo.TableName),
 	}
-	patchMethod["responses"] = patchResponseMap
+	patchMethod["responses"] = errorResponses(patchResponseMap)
 	return patchMethod
 }
@@ -0,0 +1,164 @@
+package apiblueprint
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+func blueprintTestConfig() *resource.CmsConfig {
+
+	columns := func(extra ...api2go.ColumnInfo) []api2go.ColumnInfo {
+		return append(append([]api2go.ColumnInfo{}, resource.StandardColumns...), extra...)
+	}
+
+	config := &resource.CmsConfig{
+		Hostname:      "localhost:6336",
+		EnableGraphQL: true,
+		Tables: append([]resource.TableInfo{
+			{
+				TableName: "book",
+				Columns: columns(
+					api2go.ColumnInfo{Name: "title", ColumnName: "title", ColumnType: "label"},
+					api2go.ColumnInfo{Name: "pages", ColumnName: "pages", ColumnType: "measurement", IsNullable: true},
+					api2go.ColumnInfo{Name: "published_at", ColumnName: "published_at", ColumnType: "datetime", IsNullable: true},
+					api2go.ColumnInfo{Name: "cover", ColumnName: "cover", ColumnType: "file.image", IsForeignKey: true, IsNullable: true,
+						ForeignKeyData: api2go.ForeignKeyData{DataSource: "cloud_store", Namespace: "localstore", KeyName: "covers"}},
+					api2go.ColumnInfo{Name: "author_id", ColumnName: "author_id", ColumnType: "alias", IsForeignKey: true,
+						ForeignKeyData: api2go.ForeignKeyData{DataSource: "self", Namespace: "author", KeyName: "id"}},
+				),
+			},
+			{
+				TableName: "author",
+				Columns:   columns(api2go.ColumnInfo{Name: "name", ColumnName: "name", ColumnType: "label"}),
+			},
+		}, resource.StandardTables...),
+		Actions: append([]resource.Action{
+			{
+				Name:   "publish",
+				Label:  "Publish the book",
+				OnType: "book",
+				InFields: []api2go.ColumnInfo{
+					{Name: "edition", ColumnName: "edition", ColumnType: "measurement"},
+					{Name: "note", ColumnName: "note", ColumnType: "content"},
+				},
+				Validations: []resource.ColumnTag{
+					{ColumnName: "edition", Tags: "required,gt=0"},
+				},
+			},
+		}, resource.SystemActions...),
+		Streams: resource.StandardStreams,
+	}
+
+	// the relations are on the tables of both sides, as they are after reading them from the database
+	relations := append([]api2go.TableRelation{
+		api2go.NewTableRelation("book", "belongs_to", "author"),
+		api2go.NewTableRelation("author", "has_many_and_belongs_to_many", "user_account"),
+	}, resource.StandardRelations...)
+	for i, table := range config.Tables {
+		for _, relation := range relations {
+			if relation.GetSubject() == table.TableName || relation.GetObject() == table.TableName {
+				config.Tables[i].Relations = append(config.Tables[i].Relations, relation)
+			}
+		}
+	}
+	return config
+}
+
+func TestBuildApiBlueprint(t *testing.T) {
+
+	resource.InitialiseColumnManager()
+	config := blueprintTestConfig()
+
+	document := BuildApiBlueprint(config, nil)
+	swagger, err := openapi3.NewSwaggerLoader().LoadSwaggerFromData([]byte(document))
+	if err != nil {
+		t.Fatalf("Failed to load the OpenAPI document: %v", err)
+	}
+	if err = swagger.Validate(context.Background()); err != nil {
+		t.Fatalf("Invalid OpenAPI document: %v", err)
+	}
+
+	for _, path := range []string{
+		"/api/book",
+		"/api/book/{referenceId}",
+		"/api/book/{referenceId}/author_id",
+		"/api/book/{referenceId}/relationships/author_id",
+		"/api/author/{referenceId}/relationships/book_id",
+		"/api/author/{referenceId}/relationships/user_account_id",
+		"/api/transformed_user",
+		"/api/operations",
+		"/action/book/publish",
+		"/action/user_account/signin",
+		"/stats/{typename}",
+		"/asset/{typename}/{resource_id}/{columnname}",
+		"/feed/{feedname}",
+		"/export/{typename}",
+		"/graphql",
+		"/_config/{end}/{key}",
+		"/caldav/{path}",
+		"/.well-known/carddav",
+		"/webdav/{path}",
+	} {
+		if swagger.Paths[path] == nil {
+			t.Errorf("Expected the path %v", path)
+		}
+	}
+
+	// every path parameter is declared, and no operation id is used twice
+	pathParameterPattern := regexp.MustCompile(`{([^}]+)}`)
+	operationIds := make(map[string]string)
+	for path, pathItem := range swagger.Paths {
+		for method, operation := range pathItem.Operations() {
+			if operation.OperationID == "" {
+				t.Errorf("No operation id for %v %v", method, path)
+			} else if other, ok := operationIds[operation.OperationID]; ok {
+				t.Errorf("Operation id %v of %v %v is used by %v", operation.OperationID, method, path, other)
+			}
+			operationIds[operation.OperationID] = method + " " + path
+
+			for _, match := range pathParameterPattern.FindAllStringSubmatch(path, -1) {
+				parameter := operation.Parameters.GetByInAndName("path", match[1])
+				if parameter == nil || !parameter.Required {
+					t.Errorf("Expected a required path parameter %v for %v %v", match[1], method, path)
+				}
+			}
+		}
+	}
+
+	book := swagger.Components.Schemas["Book"].Value
+	if book.Properties["pages"].Value.Type != "integer" || book.Properties["published_at"].Value.Format != "date-time" {
+		t.Errorf("Expected typed columns, got pages [%v] and published_at [%v]",
+			book.Properties["pages"].Value.Type, book.Properties["published_at"].Value.Format)
+	}
+	if cover := book.Properties["cover"]; cover == nil || cover.Value.Type != "array" {
+		t.Errorf("Expected the files of the cover as a list")
+	}
+	if _, ok := book.Properties["author_id"]; ok {
+		t.Errorf("Expected the author as a relationship and not an attribute")
+	}
+
+	publish := swagger.Components.Schemas[ActionSchemaName(config.Actions[0])].Value
+	if len(publish.Required) != 2 || publish.Required[0] != "book_id" || publish.Required[1] != "edition" {
+		t.Errorf("Expected the book and the edition to be required, got %v", publish.Required)
+	}
+	if publish.Properties["note"] == nil || publish.Properties["edition"].Value.Type != "integer" {
+		t.Errorf("Expected the in fields of the action as properties")
+	}
+
+	if stream := swagger.Components.Schemas["TransformedUserStream"]; stream == nil || stream.Value.Properties["primary_email"] == nil {
+		t.Errorf("Expected the columns of the stream")
+	}
+
+	bearer := swagger.Components.SecuritySchemes["bearerAuth"]
+	if bearer == nil || bearer.Value.Scheme != "bearer" || bearer.Value.BearerFormat != "JWT" {
+		t.Errorf("Expected the JWT bearer security scheme")
+	}
+	if len(swagger.Security) == 0 {
+		t.Errorf("Expected the security requirements of the routes")
+	}
+}
@@ -0,0 +1,743 @@
+package apiblueprint
+
+import (
+	"sort"
+
+	"github.com/daptin/daptin/server/resource"
+)
+
+// atomicMediaType is the media type of the JSON:API atomic operations extension
+const atomicMediaType = `application/vnd.api+json; ext="https://jsonapi.org/ext/atomic"`
+
+// typeNameParameter is the name of a table as a path parameter
+func typeNameParameter(tableNames []string) map[string]interface{} {
+	parameter := pathParameter("typename", "Name of the table")
+	parameter["schema"] = map[string]interface{}{
+		"type": "string",
+		"enum": tableNames,
+	}
+	return parameter
+}
+
+func arrayQueryParameter(name string, description string) map[string]interface{} {
+	return queryParameter(name, description, map[string]interface{}{
+		"type": "array",
+		"items": map[string]interface{}{
+			"type": "string",
+		},
+	})
+}
+
+func objectResponse(description string) map[string]interface{} {
+	return contentResponse(description, "application/json", map[string]interface{}{
+		"type": "object",
+	})
+}
+
+func textResponse(description string) map[string]interface{} {
+	return contentResponse(description, "text/plain", map[string]interface{}{
+		"type": "string",
+	})
+}
+
+func statusResponse(description string) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+	}
+}
+
+// CreateServerPaths describes the routes the server serves next to the
+// JSON:API routes of the tables and the actions
+func CreateServerPaths(config *resource.CmsConfig, tableInfoMap map[string]resource.TableInfo) map[string]map[string]interface{} {
+
+	tableNames := make([]string, 0)
+	for tableName := range tableInfoMap {
+		if !isJoinTable(tableName) {
+			tableNames = append(tableNames, tableName)
+		}
+	}
+	sort.Strings(tableNames)
+
+	stringSchema := map[string]interface{}{
+		"type": "string",
+	}
+
+	paths := make(map[string]map[string]interface{})
+
+	paths["/"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"operationId": "ReadIndex",
+			"summary":     "The dashboard",
+			"tags":        []string{"system"},
+			"responses": map[string]interface{}{
+				"200": contentResponse("index page of the dashboard", "text/html", stringSchema),
+			},
+		},
+	}
+
+	paths["/ping"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"operationId": "Ping",
+			"summary":     "Check if the server is up",
+			"tags":        []string{"system"},
+			"responses": map[string]interface{}{
+				"200": textResponse("pong"),
+			},
+		},
+	}
+
+	for path, operationId := range map[string]string{"/statistics": "ReadStatistics", "/system": "ReadSystem"} {
+		paths[path] = map[string]interface{}{
+			"get": map[string]interface{}{
+				"operationId": operationId,
+				"summary":     "Statistics of the server, like the number of requests and the memory in use",
+				"tags":        []string{"system"},
+				"responses": map[string]interface{}{
+					"200": objectResponse("statistics of the server"),
+				},
+			},
+		}
+	}
+
+	for path, operationId := range map[string]string{"/favicon.ico": "ReadFaviconIco", "/favicon.png": "ReadFaviconPng"} {
+		paths[path] = map[string]interface{}{
+			"get": map[string]interface{}{
+				"operationId": operationId,
+				"summary":     "The icon of the site",
+				"tags":        []string{"system"},
+				"responses": map[string]interface{}{
+					"200": contentResponse("the icon", "image/*", map[string]interface{}{
+						"type":   "string",
+						"format": "binary",
+					}),
+				},
+			},
+		}
+	}
+
+	paths["/actions"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"operationId": "ReadGuestActions",
+			"summary":     "The actions a guest can execute, like signup and signin",
+			"tags":        []string{"action"},
+			"responses": map[string]interface{}{
+				"200": objectResponse("the actions by type:name"),
+			},
+		},
+	}
+
+	paths["/meta"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"operationId": "ReadMeta",
+			"summary":     "Describes the column types",
+			"tags":        []string{"meta"},
+			"parameters": []map[string]interface{}{
+				queryParameter("query", "What to describe", map[string]interface{}{
+					"type": "string",
+					"enum": []string{"column_types"},
+				}),
+			},
+			"responses": map[string]interface{}{
+				"200": objectResponse("the column types by name"),
+			},
+		},
+	}
+
+	paths["/recline_model"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"operationId": "ReadReclineModel",
+			"summary":     "The recline types of the column types",
+			"tags":        []string{"meta"},
+			"responses": map[string]interface{}{
+				"200": objectResponse("the recline type by column type"),
+			},
+		},
+	}
+
+	paths["/jsmodel/{typename}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"operationId": "ReadJsModel",
+			"summary":     "The columns, relations, actions and state machines of a table",
+			"tags":        []string{"meta"},
+			"parameters": []map[string]interface{}{
+				typeNameParameter(tableNames),
+			},
+			"responses": map[string]interface{}{
+				"200": objectResponse("the model of the table"),
+			},
+		},
+	}
+
+	paths["/openapi.yaml"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"operationId": "ReadOpenApi",
+			"summary":     "This document",
+			"tags":        []string{"meta"},
+			"responses": map[string]interface{}{
+				"200": textResponse("the OpenAPI 3 document of the server, as yaml"),
+			},
+		},
+	}
+
+	// the same handlers answer the preflight requests of the browsers
+	for path, operationId := range map[string]string{"/recline_model": "OptionsReclineModel", "/jsmodel/{typename}": "OptionsJsModel", "/openapi.yaml": "OptionsOpenApi"} {
+		options := map[string]interface{}{
+			"operationId": operationId,
+			"summary":     "Preflight request for " + path,
+			"tags":        []string{"meta"},
+			"responses": map[string]interface{}{
+				"200": statusResponse("the allowed methods and headers"),
+			},
+		}
+		if path == "/jsmodel/{typename}" {
+			options["parameters"] = []map[string]interface{}{
+				typeNameParameter(tableNames),
+			}
+		}
+		paths[path]["options"] = options
+	}
+
+	paths["/sdk/{language}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"operationId": "ReadSdk",
+			"summary":     "A typed client of the api, or the schema the clients are generated from",
+			"tags":        []string{"meta"},
+			"parameters": []map[string]interface{}{
+				{
+					"name": "language",
+					"schema": map[string]interface{}{
+						"type": "string",
+						"enum": []string{"schema", "go", "typescript", "ts"},
+					},
+					"required":    true,
+					"in":          "path",
+					"description": "Language of the client, or schema for the schema",
+				},
+				queryParameter("package", "Package name of the go client", stringSchema),
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "the client, or the schema as json",
+					"content": map[string]interface{}{
+						"text/plain": map[string]interface{}{
+							"schema": stringSchema,
+						},
+						"application/json": map[string]interface{}{
+							"schema": map[string]interface{}{
+								"type": "object",
+							},
+						},
+					},
+				},
+				"400": statusResponse("no client generator for the language"),
+			},
+		},
+	}
+
+	paths["/stats/{typename}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"operationId": "ReadStats",
+			"summary":     "Aggregates the rows of a table",
+			"description": "Groups the rows of the table and projects aggregate columns like count(*) or sum(column)",
+			"tags":        []string{"stats"},
+			"parameters": []map[string]interface{}{
+				typeNameParameter(tableNames),
+				arrayQueryParameter("group", "Columns to group by"),
+				arrayQueryParameter("column", "Columns to project, like count or sum(amount), the count of the rows by default"),
+				arrayQueryParameter("filter", "Conditions on the rows, like eq(status,open), with eq, neq, lt, lte, gt, gte, like and in"),
+				arrayQueryParameter("order", "Columns to order by, like amount desc"),
+				arrayQueryParameter("query", "Json list of column filters"),
+			},
+			"responses": map[string]interface{}{
+				"200": contentResponse("the aggregated rows", "application/json", map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"data": map[string]interface{}{
+							"type": "array",
+							"items": map[string]interface{}{
+								"type": "object",
+							},
+						},
+					},
+				}),
+				"400": contentResponse("invalid query", "application/json", schemaRef("DaptinError")),
+				"403": statusResponse("the user is not allowed to execute on the table"),
+				"500": contentResponse("failed to query stats", "application/json", schemaRef("DaptinError")),
+			},
+		},
+	}
+
+	paths["/search"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"operationId": "Search",
+			"summary":     "Full text search over the searchable columns of the tables",
+			"tags":        []string{"search"},
+			"parameters": []map[string]interface{}{
+				{
+					"name":        "q",
+					"schema":      stringSchema,
+					"required":    true,
+					"in":          "query",
+					"description": "Text to search for",
+				},
+				queryParameter("limit", "Maximum number of results", map[string]interface{}{
+					"type": "integer",
+				}),
+				arrayQueryParameter("types", "Tables to search in, all by default"),
+			},
+			"responses": map[string]interface{}{
+				"200": contentResponse("the matching rows, best match first", "application/json", map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"data": map[string]interface{}{
+							"type": "array",
+							"items": map[string]interface{}{
+								"type": "object",
+								"properties": map[string]interface{}{
+									"type": stringSchema,
+									"id":   stringSchema,
+									"attributes": map[string]interface{}{
+										"type": "object",
+									},
+									"meta": map[string]interface{}{
+										"type": "object",
+										"properties": map[string]interface{}{
+											"rank": map[string]interface{}{
+												"type":        "number",
+												"description": "Number of words of the searchable columns matching the query",
+											},
+											"snippet": stringSchema,
+										},
+									},
+								},
+							},
+						},
+						"meta": map[string]interface{}{
+							"type": "object",
+						},
+					},
+				}),
+				"400": contentResponse("invalid query", "application/json", schemaRef("DaptinError")),
+			},
+		},
+	}
+
+	paths["/geojson/{typename}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"operationId": "ReadGeoJson",
+			"summary":     "The rows of a table as a GeoJSON feature collection",
+			"tags":        []string{"search"},
+			"parameters": []map[string]interface{}{
+				typeNameParameter(tableNames),
+				queryParameter("column", "The location column, the first one by default", stringSchema),
+				queryParameter("query", "search text in indexed columns, or a json list of column filters", stringSchema),
+				queryParameter("page[size]", "Number of rows", stringSchema),
+			},
+			"responses": map[string]interface{}{
+				"200": contentResponse("feature collection", "application/geo+json", map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"type": stringSchema,
+						"features": map[string]interface{}{
+							"type": "array",
+							"items": map[string]interface{}{
+								"type": "object",
+							},
+						},
+					},
+				}),
+				"400": contentResponse("no location column, or invalid query", "application/json", schemaRef("DaptinError")),
+			},
+		},
+	}
+
+	paths["/asset/{typename}/{resource_id}/{columnname}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"operationId": "ReadAsset",
+			"summary":     "The file stored in a column of a row",
+			"tags":        []string{"asset"},
+			"parameters": []map[string]interface{}{
+				typeNameParameter(tableNames),
+				pathParameter("resource_id", "Reference id of the row"),
+				pathParameter("columnname", "Name of the column, with the extension of the file, like photo.png"),
+			},
+			"responses": map[string]interface{}{
+				"200": contentResponse("contents of the file", "*/*", map[string]interface{}{
+					"type":   "string",
+					"format": "binary",
+				}),
+				"404": statusResponse("no such row, or no file in the column"),
+			},
+		},
+	}
+
+	paths["/feed/{feedname}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"operationId": "ReadFeed",
+			"summary":     "The rows of the stream of a feed",
+			"tags":        []string{"feed"},
+			"parameters": []map[string]interface{}{
+				pathParameter("feedname", "Name of the feed with the format as the extension: rss, atom or json, like news.rss"),
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "the feed",
+					"content": map[string]interface{}{
+						"application/rss+xml": map[string]interface{}{
+							"schema": stringSchema,
+						},
+						"application/atom+xml": map[string]interface{}{
+							"schema": stringSchema,
+						},
+						"application/json": map[string]interface{}{
+							"schema": map[string]interface{}{
+								"type": "object",
+							},
+						},
+					},
+				},
+				"404": statusResponse("no such feed, or the feed is disabled"),
+			},
+		},
+	}
+
+	exportDescription := "The rows are read in batches and written as they are read, in the order they were created. " +
+		"Foreign keys are exported as the reference id of the related row. When the export fails after the rows started, " +
+		"the error is written as the last row and the connection is closed before the end of the response"
+	paths["/export/{typename}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"operationId": "ExportRows",
+			"summary":     "Streams the rows of a table matching a list query",
+			"description": exportDescription,
+			"tags":        []string{"export"},
+			"parameters": []map[string]interface{}{
+				typeNameParameter(tableNames),
+				queryParameter("format", "Format of the export, csv by default", map[string]interface{}{
+					"type": "string",
+					"enum": []string{"csv", "ndjson", "xlsx"},
+				}),
+				queryParameter("query", "search text in indexed columns, or a json list of column filters", stringSchema),
+				queryParameter("filter", "search text in indexed columns", stringSchema),
+				queryParameter("fields", "Columns to export, all the columns by default", stringSchema),
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "the rows",
+					"content": map[string]interface{}{
+						"text/csv": map[string]interface{}{
+							"schema": stringSchema,
+						},
+						"application/x-ndjson": map[string]interface{}{
+							"schema": stringSchema,
+						},
+						"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": map[string]interface{}{
+							"schema": map[string]interface{}{
+								"type":   "string",
+								"format": "binary",
+							},
+						},
+					},
+				},
+				"400": contentResponse("unknown format", "application/json", schemaRef("DaptinError")),
+				"403": contentResponse("the user is not allowed to read the table", "application/json", schemaRef("DaptinError")),
+				"404": contentResponse("no such table", "application/json", schemaRef("DaptinError")),
+			},
+		},
+	}
+
+	configKeyParameters := []map[string]interface{}{
+		pathParameter("end", "Which end the configuration is for, like backend"),
+		pathParameter("key", "Name of the configuration, like graphql.max_depth"),
+	}
+	configValueBody := map[string]interface{}{
+		"required": true,
+		"content": map[string]interface{}{
+			"text/plain": map[string]interface{}{
+				"schema": stringSchema,
+			},
+		},
+	}
+	paths["/_config"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"operationId": "ReadConfigs",
+			"summary":     "All the configurations, for the administrators",
+			"tags":        []string{"config"},
+			"responses": map[string]interface{}{
+				"200": objectResponse("the configurations"),
+				"403": statusResponse("the user is not an administrator"),
+			},
+		},
+	}
+	paths["/_config/{end}/{key}"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"operationId": "ReadConfig",
+			"summary":     "Get a configuration",
+			"tags":        []string{"config"},
+			"parameters":  configKeyParameters,
+			"responses": map[string]interface{}{
+				"200": textResponse("the value"),
+				"403": statusResponse("the user is not an administrator"),
+				"404": statusResponse("no such configuration"),
+			},
+		},
+	}
+	for method, operationId := range map[string]string{"post": "SetConfig", "put": "PutConfig", "patch": "PatchConfig"} {
+		paths["/_config/{end}/{key}"][method] = map[string]interface{}{
+			"operationId": operationId,
+			"summary":     "Set a configuration",
+			"tags":        []string{"config"},
+			"parameters":  configKeyParameters,
+			"requestBody": configValueBody,
+			"responses": map[string]interface{}{
+				"200": statusResponse("the configuration is stored"),
+				"403": statusResponse("the user is not an administrator"),
+			},
+		}
+	}
+	paths["/_config/{end}/{key}"]["delete"] = map[string]interface{}{
+		"operationId": "RemoveConfig",
+		"summary":     "Delete a configuration",
+		"tags":        []string{"config"},
+		"parameters":  configKeyParameters,
+		"responses": map[string]interface{}{
+			"200": statusResponse("the configuration is deleted"),
+			"403": statusResponse("the user is not an administrator"),
+		},
+	}
+
+	paths["/track/start/{stateMachineId}"] = map[string]interface{}{
+		"post": map[string]interface{}{
+			"operationId": "StartStateMachine",
+			"summary":     "Start tracking the state of an object with a state machine",
+			"tags":        []string{"state machine"},
+			"parameters": []map[string]interface{}{
+				pathParameter("stateMachineId", "Reference id of the state machine"),
+			},
+			"requestBody": map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": map[string]interface{}{
+							"type": "object",
+							"properties": map[string]interface{}{
+								"typeName": map[string]interface{}{
+									"type":        "string",
+									"enum":        tableNames,
+									"description": "Table of the object",
+								},
+								"referenceId": map[string]interface{}{
+									"type":        "string",
+									"description": "Reference id of the object",
+								},
+							},
+							"required": []string{"typeName", "referenceId"},
+						},
+					},
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": objectResponse("the state of the object"),
+				"400": statusResponse("the table does not track states"),
+				"403": statusResponse("the user is not allowed to track the object"),
+			},
+		},
+	}
+
+	paths["/track/event/{typename}/{objectStateId}/{eventName}"] = map[string]interface{}{
+		"post": map[string]interface{}{
+			"operationId": "FireStateMachineEvent",
+			"summary":     "Fire an event on the state of an object",
+			"tags":        []string{"state machine"},
+			"parameters": []map[string]interface{}{
+				typeNameParameter(tableNames),
+				pathParameter("objectStateId", "Reference id of the state of the object"),
+				pathParameter("eventName", "Name of the event"),
+			},
+			"responses": map[string]interface{}{
+				"200": statusResponse("the state of the object moved"),
+				"400": statusResponse("the event cannot be fired in the current state"),
+				"403": statusResponse("the user is not allowed to update the state"),
+			},
+		},
+	}
+
+	// the dav clients also send PROPFIND, PROPPATCH, REPORT, MKCOL, COPY and
+	// MOVE, which an OpenAPI document cannot describe
+	davSecurity := []map[string]interface{}{
+		{"basicAuth": []string{}},
+		{"bearerAuth": []string{}},
+	}
+	davOperations := func(operationId string, summary string, tag string, parameters []map[string]interface{}, methods []string) map[string]interface{} {
+		methodNames := map[string]string{
+			"get":     "Read",
+			"head":    "Head",
+			"options": "Options",
+			"put":     "Put",
+			"delete":  "Remove",
+			"post":    "Post",
+		}
+		operations := make(map[string]interface{})
+		for _, method := range methods {
+			operation := map[string]interface{}{
+				"operationId": methodNames[method] + operationId,
+				"summary":     summary,
+				"tags":        []string{tag},
+				"security":    davSecurity,
+				"responses": map[string]interface{}{
+					"200":     statusResponse("the response of the dav handler"),
+					"401":     statusResponse("the credentials are missing or wrong"),
+					"default": statusResponse("the status of the dav request"),
+				},
+			}
+			if len(parameters) > 0 {
+				operation["parameters"] = parameters
+			}
+			operations[method] = operation
+		}
+		return operations
+	}
+	davPathParameter := []map[string]interface{}{
+		pathParameter("path", "Path of the collection or the item, it can have slashes"),
+	}
+	davMethods := []string{"get", "head", "options", "put", "delete"}
+
+	paths["/caldav/{path}"] = davOperations("CalDav", "The calendars of the user over CalDAV", "dav", davPathParameter, davMethods)
+	paths["/.well-known/caldav"] = davOperations("CalDavWellKnown", "Discovery of the CalDAV calendars", "dav", nil, davMethods)
+	paths["/carddav/{path}"] = davOperations("CardDav", "The address books of the user over CardDAV", "dav", davPathParameter, davMethods)
+	paths["/.well-known/carddav"] = davOperations("CardDavWellKnown", "Discovery of the CardDAV address books", "dav", nil, davMethods)
+	paths["/webdav/{path}"] = davOperations("WebDav", "The sites and the cloud store folders over WebDAV, LOCK and UNLOCK are served as well", "webdav", davPathParameter, append(davMethods, "post"))
+	paths["/webdav"] = davOperations("WebDavRoot", "The root of the WebDAV folders, for PROPFIND", "webdav", nil, []string{"options"})
+
+	// a table named operations takes the place of the atomic operations
+	if _, ok := tableInfoMap["operations"]; !ok {
+		paths["/api/operations"] = map[string]interface{}{
+			"post": map[string]interface{}{
+				"operationId": "AtomicOperations",
+				"summary":     "Run a list of operations in one transaction",
+				"description": "Implements the JSON:API atomic operations extension, either all the operations are applied or none",
+				"tags":        []string{"operations"},
+				"requestBody": map[string]interface{}{
+					"required": true,
+					"content": map[string]interface{}{
+						atomicMediaType: map[string]interface{}{
+							"schema": map[string]interface{}{
+								"type": "object",
+								"properties": map[string]interface{}{
+									"atomic:operations": map[string]interface{}{
+										"type": "array",
+										"items": map[string]interface{}{
+											"type": "object",
+											"properties": map[string]interface{}{
+												"op": map[string]interface{}{
+													"type": "string",
+													"enum": []string{"add", "update", "remove"},
+												},
+												"ref": map[string]interface{}{
+													"type": "object",
+													"properties": map[string]interface{}{
+														"type": stringSchema,
+														"id":   stringSchema,
+														"lid":  stringSchema,
+													},
+												},
+												"data": map[string]interface{}{
+													"type": "object",
+												},
+											},
+											"required": []string{"op"},
+										},
+									},
+								},
+								"required": []string{"atomic:operations"},
+							},
+						},
+					},
+				},
+				"responses": map[string]interface{}{
+					"200": contentResponse("the results of the operations, in order", atomicMediaType, map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"atomic:results": map[string]interface{}{
+								"type": "array",
+								"items": map[string]interface{}{
+									"type": "object",
+								},
+							},
+						},
+					}),
+					"default": contentResponse("the operation which failed", "application/json", schemaRef("Errors")),
+				},
+			},
+		}
+	}
+
+	if config.EnableGraphQL {
+		graphqlRequest := map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"query":         stringSchema,
+				"operationName": stringSchema,
+				"variables": map[string]interface{}{
+					"type": "object",
+				},
+				"extensions": map[string]interface{}{
+					"type":        "object",
+					"description": "persistedQuery with the sha256Hash of a persisted query",
+				},
+			},
+		}
+		graphqlResponses := map[string]interface{}{
+			"200": contentResponse("the result of the query", "application/json", map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"data": map[string]interface{}{
+						"type": "object",
+					},
+					"errors": map[string]interface{}{
+						"type": "array",
+						"items": map[string]interface{}{
+							"type": "object",
+						},
+					},
+				},
+			}),
+		}
+
+		paths["/graphql"] = map[string]interface{}{
+			"get": map[string]interface{}{
+				"operationId": "GraphqlQuery",
+				"summary":     "Run a graphql query",
+				"description": "Serves graphiql to browsers when enabled, and subscriptions over graphql-ws when the request upgrades to a websocket",
+				"tags":        []string{"graphql"},
+				"parameters": []map[string]interface{}{
+					queryParameter("query", "The graphql query", stringSchema),
+					queryParameter("operationName", "The operation to run", stringSchema),
+					queryParameter("variables", "The variables as json", stringSchema),
+					queryParameter("extensions", "The persisted query as json", stringSchema),
+				},
+				"responses": graphqlResponses,
+			},
+		}
+		for method, operationId := range map[string]string{"post": "Graphql", "put": "GraphqlPut", "patch": "GraphqlPatch", "delete": "GraphqlDelete"} {
+			paths["/graphql"][method] = map[string]interface{}{
+				"operationId": operationId,
+				"summary":     "Run a graphql query or mutation",
+				"tags":        []string{"graphql"},
+				"requestBody": map[string]interface{}{
+					"required": true,
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{
+							"schema": graphqlRequest,
+						},
+						"application/graphql": map[string]interface{}{
+							"schema": stringSchema,
+						},
+					},
+				},
+				"responses": graphqlResponses,
+			}
+		}
+	}
+
+	return paths
+}
@@ -0,0 +1,346 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/database"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/gin-gonic/gin"
+	"github.com/json-iterator/go"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+)
+
+// media type of the JSON:API atomic operations extension
+const atomicMediaType = `application/vnd.api+json; ext="https://jsonapi.org/ext/atomic"`
+
+// the number of operations accepted in one request
+const maxAtomicOperations = 10000
+
+type atomicResourceRef struct {
+	Type string `json:"type"`
+	Id   string `json:"id,omitempty"`
+	Lid  string `json:"lid,omitempty"`
+}
+
+type atomicRelationship struct {
+	Data jsoniter.RawMessage `json:"data"`
+}
+
+type atomicResource struct {
+	atomicResourceRef
+	Attributes    map[string]interface{}        `json:"attributes"`
+	Relationships map[string]atomicRelationship `json:"relationships"`
+}
+
+type atomicOperation struct {
+	Op   string             `json:"op"`
+	Ref  *atomicResourceRef `json:"ref"`
+	Data *atomicResource    `json:"data"`
+}
+
+type atomicOperationsDocument struct {
+	Operations []atomicOperation `json:"atomic:operations"`
+}
+
+// atomicError is a failed operation, the index points to the operation in the request
+type atomicError struct {
+	index  int
+	status int
+	err    error
+}
+
+func (e atomicError) Error() string {
+	return e.err.Error()
+}
+
+// CreateAtomicOperationsHandler implements the JSON:API atomic operations
+// extension. All the operations run in one transaction, through the same
+// middlewares as the single row apis, and either all of them are applied or
+// none. Rows created by an earlier "add" can be referred to by their "lid".
+func CreateAtomicOperationsHandler(cruds map[string]*resource.DbResource, db database.DatabaseConnection) func(*gin.Context) {
+
+	return func(c *gin.Context) {
+
+		body, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(400, atomicErrorResponse(atomicError{index: -1, status: 400, err: err}))
+			return
+		}
+
+		var document atomicOperationsDocument
+		err = json.Unmarshal(body, &document)
+		if err != nil || document.Operations == nil {
+			c.JSON(400, atomicErrorResponse(atomicError{index: -1, status: 400, err: errors.New("expected a list of atomic:operations")}))
+			return
+		}
+		if len(document.Operations) > maxAtomicOperations {
+			c.JSON(413, atomicErrorResponse(atomicError{index: -1, status: 413,
+				err: fmt.Errorf("at most %d operations are allowed in one request", maxAtomicOperations)}))
+			return
+		}
+
+		tx, err := db.Beginx()
+		if err != nil {
+			c.JSON(500, atomicErrorResponse(atomicError{index: -1, status: 500, err: err}))
+			return
+		}
+
+		executor := &atomicOperationsExecutor{
+			cruds:     resource.NewTransactionCruds(cruds, tx),
+			request:   c.Request,
+			localIds:  make(map[string]string),
+			localType: make(map[string]string),
+		}
+
+		results := make([]map[string]interface{}, 0)
+		for i, operation := range document.Operations {
+			result, err := executor.execute(operation)
+			if err != nil {
+				rollbackErr := tx.Rollback()
+				resource.CheckErr(rollbackErr, "Failed to rollback atomic operations")
+
+				failure, ok := err.(atomicError)
+				if !ok {
+					failure = atomicError{status: 400, err: err}
+				}
+				failure.index = i
+				c.JSON(failure.status, atomicErrorResponse(failure))
+				return
+			}
+			results = append(results, result)
+		}
+
+		err = resource.CommitTransactionCruds(executor.cruds, tx)
+		if err != nil {
+			log.Errorf("Failed to commit atomic operations: %v", err)
+			c.JSON(500, atomicErrorResponse(atomicError{index: -1, status: 500, err: err}))
+			return
+		}
+
+		c.Header("Content-Type", atomicMediaType)
+		c.JSON(200, map[string]interface{}{
+			"atomic:results": results,
+		})
+	}
+}
+
+type atomicOperationsExecutor struct {
+	cruds     map[string]*resource.DbResource
+	request   *http.Request
+	localIds  map[string]string
+	localType map[string]string
+}
+
+// resolve returns the reference id of the row, local ids are looked up in the
+// rows added earlier in the request
+func (e *atomicOperationsExecutor) resolve(ref atomicResourceRef) (string, error) {
+
+	if _, ok := e.cruds[ref.Type]; !ok {
+		return "", atomicError{status: 404, err: fmt.Errorf("unknown type [%v]", ref.Type)}
+	}
+	if ref.Id != "" {
+		return ref.Id, nil
+	}
+	if ref.Lid == "" {
+		return "", errors.New("id or lid is required")
+	}
+
+	id, ok := e.localIds[ref.Lid]
+	if !ok || e.localType[ref.Lid] != ref.Type {
+		return "", fmt.Errorf("unknown lid [%v] of type [%v]", ref.Lid, ref.Type)
+	}
+	return id, nil
+}
+
+// subRequest is a new api request for one operation, with the user and the
+// url of the atomic operations request. The headers and the body of the atomic
+// operations request are not for the operations.
+func (e *atomicOperationsExecutor) subRequest(method string) api2go.Request {
+	url := *e.request.URL
+	plainRequest := &http.Request{
+		Method:     method,
+		URL:        &url,
+		Proto:      e.request.Proto,
+		ProtoMajor: e.request.ProtoMajor,
+		ProtoMinor: e.request.ProtoMinor,
+		Header:     http.Header{},
+		Host:       e.request.Host,
+		RemoteAddr: e.request.RemoteAddr,
+	}
+	return api2go.Request{
+		PlainRequest: plainRequest.WithContext(e.request.Context()),
+		QueryParams:  map[string][]string{},
+	}
+}
+
+// attributes merges the relationships of the resource into its attributes the
+// way the api expects them, to one relations as the reference id of the
+// related row and to many relations as a list
+func (e *atomicOperationsExecutor) attributes(data *atomicResource) (map[string]interface{}, error) {
+
+	attributes := make(map[string]interface{})
+	for name, value := range data.Attributes {
+		attributes[name] = value
+	}
+
+	for name, relationship := range data.Relationships {
+
+		var toOne *atomicResourceRef
+		if json.Unmarshal(relationship.Data, &toOne) == nil {
+			if toOne == nil {
+				attributes[name] = nil
+				continue
+			}
+			id, err := e.resolve(*toOne)
+			if err != nil {
+				return nil, err
+			}
+			attributes[name] = id
+			continue
+		}
+
+		var toMany []atomicResourceRef
+		err := json.Unmarshal(relationship.Data, &toMany)
+		if err != nil {
+			return nil, fmt.Errorf("invalid relationship [%v]", name)
+		}
+		related := make([]map[string]interface{}, 0)
+		for _, ref := range toMany {
+			id, err := e.resolve(ref)
+			if err != nil {
+				return nil, err
+			}
+			related = append(related, map[string]interface{}{name: id})
+		}
+		attributes[name] = related
+	}
+
+	return attributes, nil
+}
+
+func (e *atomicOperationsExecutor) execute(operation atomicOperation) (map[string]interface{}, error) {
+
+	switch operation.Op {
+	case "add":
+		if operation.Data == nil {
+			return nil, errors.New("data is required to add")
+		}
+		dbResource, ok := e.cruds[operation.Data.Type]
+		if !ok {
+			return nil, atomicError{status: 404, err: fmt.Errorf("unknown type [%v]", operation.Data.Type)}
+		}
+
+		attributes, err := e.attributes(operation.Data)
+		if err != nil {
+			return nil, err
+		}
+		if operation.Data.Id != "" {
+			attributes["reference_id"] = operation.Data.Id
+		}
+
+		model := api2go.NewApi2GoModelWithData(operation.Data.Type, dbResource.TableInfo().Columns, 0, nil, attributes)
+		response, err := dbResource.Create(model, e.subRequest("POST"))
+		if err != nil {
+			return nil, atomicFailure(err)
+		}
+
+		created := response.Result().(*api2go.Api2GoModel)
+		if operation.Data.Lid != "" {
+			e.localIds[operation.Data.Lid] = created.GetID()
+			e.localType[operation.Data.Lid] = operation.Data.Type
+		}
+		return atomicResultData(operation.Data.Type, created.Data), nil
+
+	case "update":
+		if operation.Data == nil {
+			return nil, errors.New("data is required to update")
+		}
+		ref := operation.Data.atomicResourceRef
+		if operation.Ref != nil {
+			ref = *operation.Ref
+		}
+		id, err := e.resolve(ref)
+		if err != nil {
+			return nil, err
+		}
+
+		attributes, err := e.attributes(operation.Data)
+		if err != nil {
+			return nil, err
+		}
+		attributes["reference_id"] = id
+
+		dbResource := e.cruds[ref.Type]
+		model := api2go.NewApi2GoModelWithData(ref.Type, dbResource.TableInfo().Columns, 0, nil, attributes)
+		response, err := dbResource.Update(model, e.subRequest("PATCH"))
+		if err != nil {
+			return nil, atomicFailure(err)
+		}
+		return atomicResultData(ref.Type, response.Result().(*api2go.Api2GoModel).Data), nil
+
+	case "remove":
+		if operation.Ref == nil {
+			return nil, errors.New("ref is required to remove")
+		}
+		id, err := e.resolve(*operation.Ref)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = e.cruds[operation.Ref.Type].Delete(id, e.subRequest("DELETE"))
+		if err != nil {
+			return nil, atomicFailure(err)
+		}
+		return map[string]interface{}{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown op [%v]", operation.Op)
+}
+
+// atomicFailure keeps the status of errors returned by the middlewares
+func atomicFailure(err error) error {
+	if httpErr, ok := err.(api2go.HTTPError); ok && httpErr.Status() != 0 {
+		return atomicError{status: httpErr.Status(), err: err}
+	}
+	return atomicError{status: 400, err: err}
+}
+
+func atomicResultData(typeName string, row map[string]interface{}) map[string]interface{} {
+
+	attributes := make(map[string]interface{})
+	for key, value := range row {
+		if key == "id" || key == "__type" || key == "reference_id" {
+			continue
+		}
+		attributes[key] = value
+	}
+
+	return map[string]interface{}{
+		"data": map[string]interface{}{
+			"type":       typeName,
+			"id":         row["reference_id"],
+			"attributes": attributes,
+		},
+	}
+}
+
+func atomicErrorResponse(failure atomicError) map[string]interface{} {
+
+	jsonError := map[string]interface{}{
+		"status": fmt.Sprintf("%d", failure.status),
+		"title":  http.StatusText(failure.status),
+		"detail": failure.err.Error(),
+	}
+	if failure.index > -1 {
+		jsonError["source"] = map[string]interface{}{
+			"pointer": fmt.Sprintf("/atomic:operations/%d", failure.index),
+		}
+	}
+
+	return map[string]interface{}{
+		"errors": []interface{}{jsonError},
+	}
+}
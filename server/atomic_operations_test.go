@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/daptin/daptin/server/auth"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAtomicOperations(t *testing.T) {
+
+	cruds, db, sessionUser, cleanup := serverTestFixture(t)
+	defer cleanup()
+
+	router := withSessionUser(sessionUser)
+	router.POST("/api/operations", CreateAtomicOperationsHandler(cruds, db))
+
+	count := func(query string) int {
+		var rowCount int
+		if err := db.QueryRowx(query).Scan(&rowCount); err != nil {
+			t.Fatal(err)
+		}
+		return rowCount
+	}
+	post := func(body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("POST", "/api/operations", strings.NewReader(body))
+		request.Header.Set("Content-Type", atomicMediaType)
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	// rows added earlier are referred to by their lid
+	response := post(`{"atomic:operations": [
+		{"op": "add", "data": {"type": "address_book", "lid": "book", "attributes": {"name": "personal"}}},
+		{"op": "add", "data": {"type": "contact_card", "lid": "card", "attributes": {"full_name": "first", "resource_name": "first.vcf", "uid": "first", "content": "BEGIN:VCARD"},
+			"relationships": {"address_book_id": {"data": {"type": "address_book", "lid": "book"}}}}},
+		{"op": "update", "ref": {"type": "contact_card", "lid": "card"}, "data": {"type": "contact_card", "attributes": {"full_name": "second"}}}
+	]}`)
+	if response.Code != 200 {
+		t.Fatalf("Expected the operations to succeed, got %v: %v", response.Code, response.Body.String())
+	}
+	if strings.Count(response.Body.String(), `"data"`) != 3 {
+		t.Errorf("Expected a result for every operation, got %v", response.Body.String())
+	}
+	if count("select count(*) from contact_card c join address_book b on b.id = c.address_book_id where b.name = 'personal' and c.full_name = 'second'") != 1 {
+		t.Errorf("Expected the card to be added to the book added before it and updated")
+	}
+
+	for _, test := range []struct {
+		failing string
+		status  int
+	}{
+		{`{"op": "update", "ref": {"type": "contact_card", "lid": "unknown"}, "data": {"type": "contact_card", "attributes": {}}}`, 400},
+		{`{"op": "add", "data": {"type": "not_a_table", "attributes": {}}}`, 404},
+		{`{"op": "remove", "ref": {"type": "address_book", "id": "not-a-reference-id"}}`, 400},
+		{`{"op": "move", "ref": {"type": "address_book", "lid": "first"}}`, 400},
+	} {
+		before := count("select count(*) from address_book")
+		response = post(fmt.Sprintf(`{"atomic:operations": [
+			{"op": "add", "data": {"type": "address_book", "lid": "first", "attributes": {"name": "first"}}},
+			{"op": "add", "data": {"type": "address_book", "lid": "second", "attributes": {"name": "second"}}},
+			{"op": "update", "ref": {"type": "address_book", "lid": "first"}, "data": {"type": "address_book", "attributes": {"name": "changed"}}},
+			%v
+		]}`, test.failing))
+		if response.Code != test.status || !strings.Contains(response.Body.String(), "/atomic:operations/3") {
+			t.Errorf("Expected [%v] to fail with %v at operation 3, got %v: %v", test.failing, test.status, response.Code, response.Body.String())
+		}
+		// none of the operations before the failing one are applied
+		if count("select count(*) from address_book") != before || count("select count(*) from address_book where name in ('first', 'changed')") != 0 {
+			t.Errorf("Expected the operations before [%v] to be rolled back", test.failing)
+		}
+	}
+
+	if response = post(`{"data": []}`); response.Code != 400 {
+		t.Errorf("Expected a document without operations to fail, got %v", response.Code)
+	}
+}
+
+func TestAtomicSubRequest(t *testing.T) {
+
+	sessionUser := &auth.SessionUser{UserReferenceId: "user-reference-id"}
+	request := httptest.NewRequest("POST", "/api/operations", strings.NewReader("{}"))
+	request.Header.Set("If-Match", `"1"`)
+	request = request.WithContext(context.WithValue(request.Context(), "user", sessionUser))
+
+	executor := &atomicOperationsExecutor{request: request}
+	first := executor.subRequest("PATCH")
+	second := executor.subRequest("DELETE")
+
+	if first.PlainRequest == request || first.PlainRequest == second.PlainRequest {
+		t.Errorf("Expected a new request for every operation")
+	}
+	if first.PlainRequest.Method != "PATCH" || second.PlainRequest.Method != "DELETE" || request.Method != "POST" {
+		t.Errorf("Expected the method of each operation, got %v %v %v", first.PlainRequest.Method, second.PlainRequest.Method, request.Method)
+	}
+	if first.PlainRequest.Header.Get("If-Match") != "" || request.Header.Get("If-Match") == "" {
+		t.Errorf("Expected the operations not to have the headers of the request")
+	}
+	if first.PlainRequest.Body != nil && first.PlainRequest.Body != http.NoBody {
+		t.Errorf("Expected the operations not to have the body of the request")
+	}
+	if first.PlainRequest.Context().Value("user") != sessionUser {
+		t.Errorf("Expected the operations to be made by the user of the request")
+	}
+}
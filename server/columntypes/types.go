@@ -10,6 +10,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -344,13 +345,9 @@ var detectorMap = map[EntityType]DataTypeDetector{
 		DetectorFunction: func(d string) (bool, interface{}) {
 			d = strings.ToLower(d)
 			switch d {
-			case "yes":
-			case "true":
-			case "1":
+			case "yes", "true", "1":
 				d = "true"
-			case "no":
-			case "0":
-			case "false":
+			case "no", "0", "false":
 				d = "false"
 			}
 			r, err := strconv.ParseBool(d)
@@ -553,6 +550,37 @@ func ConvertValues(d []string, typ EntityType) ([]interface{}, error) {
 	return converted, nil
 }
 
+// compiledDetectorRegex are the patterns of the regex detectors, matching the
+// whole value, compiled on first use by ConvertValue
+var compiledDetectorRegex sync.Map
+
+// ConvertValue converts a single value to the type with its detector, ok is
+// false when the value is not of the type
+func ConvertValue(d string, typ EntityType) (ok bool, value interface{}, err error) {
+	converter, found := detectorMap[typ]
+	if !found {
+		return false, nil, errors.New("Converter not found for " + typ.String())
+	}
+	d = strings.TrimSpace(d)
+
+	switch converter.DetectorType {
+	case "function":
+		ok, value = converter.DetectorFunction(d)
+		return ok, value, nil
+	case "regex":
+		compiled, found := compiledDetectorRegex.Load(typ)
+		if !found {
+			reg, err := regexp.Compile("^(?:" + converter.Attributes["regex"].(string) + ")$")
+			if err != nil {
+				return false, nil, err
+			}
+			compiled, _ = compiledDetectorRegex.LoadOrStore(typ, reg)
+		}
+		return compiled.(*regexp.Regexp).MatchString(d), d, nil
+	}
+	return false, nil, errors.New("Converter not found for " + typ.String())
+}
+
 func checkStringsAgainstDetector(d []string, detect DataTypeDetector) (ok bool, unidentified []string) {
 	unidentified = make([]string, 0)
 
@@ -1,16 +1,35 @@
 package server
 
 import (
+	"database/sql"
+	"github.com/daptin/daptin/server/resource"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 	"strings"
 	"time"
 	//"github.com/casbin/xorm-adapter"
 	//"github.com/casbin/casbin"
 )
 
+// sqlite connections get the functions used by location and json queries
+const sqliteDriverName = "sqlite3_daptin"
+
+func init() {
+	sql.Register(sqliteDriverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			// SpatiaLite is used for distances when it is installed
+			_ = conn.LoadExtension("mod_spatialite", "sqlite3_modspatialite_init")
+			err := conn.RegisterFunc("haversine", resource.Haversine, true)
+			if err != nil {
+				return err
+			}
+			return conn.RegisterFunc("json_path_text", resource.JsonPathText, true)
+		},
+	})
+}
+
 func GetDbConnection(dbType string, connectionString string) (*sqlx.DB, error) {
 
 	if dbType == "mysql" && strings.Index(connectionString, "charset=") == -1 {
@@ -29,7 +48,17 @@ func GetDbConnection(dbType string, connectionString string) (*sqlx.DB, error) {
 		}
 	}
 
-	db, e := sqlx.Open(dbType, connectionString)
+	var db *sqlx.DB
+	var e error
+	if dbType == "sqlite3" {
+		var sqliteDb *sql.DB
+		sqliteDb, e = sql.Open(sqliteDriverName, connectionString)
+		if e == nil {
+			db = sqlx.NewDb(sqliteDb, dbType)
+		}
+	} else {
+		db, e = sqlx.Open(dbType, connectionString)
+	}
 	if e != nil {
 		return nil, e
 	}
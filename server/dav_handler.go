@@ -0,0 +1,59 @@
+package server
+
+import (
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/emersion/go-webdav/caldav"
+	"github.com/emersion/go-webdav/carddav"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// methods used by CalDAV and CardDAV clients in addition to the usual http methods
+var davMethods = []string{
+	"GET", "HEAD", "OPTIONS", "PUT", "DELETE", "PROPFIND", "PROPPATCH", "REPORT", "MKCOL", "COPY", "MOVE",
+}
+
+// CreateDavHandler wraps a CalDAV or CardDAV handler. Dav clients mostly use
+// basic auth with the daptin email and password, a jwt token is accepted as well.
+func CreateDavHandler(davHandler http.Handler, authMiddleware *auth.AuthMiddleware) func(*gin.Context) {
+
+	return func(c *gin.Context) {
+
+		ok, abort, modifiedRequest := authMiddleware.AuthCheckMiddlewareWithHttp(c.Request, c.Writer, true)
+		if ok {
+			c.Request = modifiedRequest
+		}
+
+		user, isUser := c.Request.Context().Value("user").(*auth.SessionUser)
+		if abort || !isUser || user == nil || user.UserReferenceId == "" {
+			c.Header("WWW-Authenticate", `Basic realm="daptin"`)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		davHandler.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// AddDavRoutes serves calendars over CalDAV at /caldav and address books over
+// CardDAV at /carddav, along with the /.well-known discovery urls
+func AddDavRoutes(defaultRouter *gin.Engine, cruds map[string]*resource.DbResource, authMiddleware *auth.AuthMiddleware) {
+
+	calDavHandler := CreateDavHandler(&caldav.Handler{
+		Backend: resource.NewCalDavBackend(cruds, "/caldav"),
+		Prefix:  "/caldav",
+	}, authMiddleware)
+
+	cardDavHandler := CreateDavHandler(&carddav.Handler{
+		Backend: resource.NewCardDavBackend(cruds, "/carddav"),
+		Prefix:  "/carddav",
+	}, authMiddleware)
+
+	for _, method := range davMethods {
+		defaultRouter.Handle(method, "/caldav/*path", calDavHandler)
+		defaultRouter.Handle(method, "/carddav/*path", cardDavHandler)
+		defaultRouter.Handle(method, "/.well-known/caldav", calDavHandler)
+		defaultRouter.Handle(method, "/.well-known/carddav", cardDavHandler)
+	}
+}
@@ -0,0 +1,414 @@
+package server
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/tealeg/xlsx"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportBatchSize is the number of rows read from the database at a time, a
+// batch is written to the response before the next one is read. The ids of a
+// batch are sql variables, sqlite allows 999 of them.
+const exportBatchSize = 500
+
+// xlsxSheetRows is the number of rows a sheet can have after the header,
+// larger exports continue on the next sheet
+const xlsxSheetRows = 1048575
+
+// exportContentTypes are the formats rows can be exported in
+var exportContentTypes = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"ndjson": "application/x-ndjson",
+	"xlsx":   "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// exportWriter writes the exported rows in a format, the columns are known
+// before the first row is written. WriteError marks an export which failed
+// before all the rows were written.
+type exportWriter interface {
+	WriteRow(row map[string]interface{}) error
+	WriteError(err error) error
+	Flush() error
+	Close() error
+}
+
+// CreateExportHandler streams the rows of a table matching a list query at
+// /export/:typename, as csv, ndjson or xlsx. The query parameters are the ones
+// of the list at /api/:typename, like query, filter and fields. The rows are
+// read in batches after the id of the last row read, in the order they were
+// created, so the size of the table does not matter and rows written meanwhile
+// do not shift the batches. The rows are counted once, for the first batch.
+func CreateExportHandler(cruds map[string]*resource.DbResource) func(*gin.Context) {
+
+	return func(c *gin.Context) {
+
+		typeName := c.Param("typename")
+		dbResource, ok := cruds[typeName]
+		if !ok {
+			c.AbortWithStatusJSON(404, resource.NewDaptinError("No such table: "+typeName, "not found"))
+			return
+		}
+
+		format := strings.ToLower(c.DefaultQuery("format", "csv"))
+		contentType, ok := exportContentTypes[format]
+		if !ok {
+			c.AbortWithStatusJSON(400, resource.NewDaptinError("Unknown export format: "+format, "invalid format"))
+			return
+		}
+
+		queryParams := exportQueryParams(c.Request.URL.Query())
+		columns := exportColumns(dbResource.TableInfo(), queryParams["fields"])
+
+		pagination, rows, err := exportBatch(dbResource, c.Request, queryParams, "", true)
+		if err != nil {
+			status := 500
+			if httpErr, ok := err.(api2go.HTTPError); ok && httpErr.Status() != 0 {
+				status = httpErr.Status()
+			}
+			c.AbortWithStatusJSON(status, resource.NewDaptinError("Failed to export "+typeName+": "+err.Error(), "export failed"))
+			return
+		}
+
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%v_%v.%v", typeName, time.Now().Format("2006-01-02-15-04-05"), format))
+		c.Status(200)
+
+		writer, err := newExportWriter(format, c.Writer, typeName, columns, uint(pagination.TotalCount))
+		if err != nil {
+			log.Errorf("Failed to start the export of [%v]: %v", typeName, err)
+			abortExport(c, nil, err)
+			return
+		}
+
+		// a batch can have no rows the user can read, the export ends when the
+		// query returns no rows
+		exported := 0
+		for pagination.After != "" {
+			for _, row := range rows {
+				if err = writer.WriteRow(row); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				err = writer.Flush()
+			}
+			if err != nil {
+				log.Errorf("Failed to export [%v] after %d rows: %v", typeName, exported, err)
+				abortExport(c, writer, err)
+				return
+			}
+			c.Writer.Flush()
+			exported += len(rows)
+
+			pagination, rows, err = exportBatch(dbResource, c.Request, queryParams, pagination.After, false)
+			if err != nil {
+				log.Errorf("Failed to read [%v] to export after %d rows: %v", typeName, exported, err)
+				abortExport(c, writer, err)
+				return
+			}
+		}
+
+		err = writer.Close()
+		if err != nil {
+			log.Errorf("Failed to complete the export of [%v]: %v", typeName, err)
+			abortExport(c, nil, err)
+		}
+	}
+}
+
+// abortExport ends an export failing after the status was sent. The error is
+// written as the last row when the writer is given, and the connection is
+// closed before the end of the response, so clients do not take the file as
+// complete.
+func abortExport(c *gin.Context, writer exportWriter, err error) {
+
+	if writer != nil && writer.WriteError(err) == nil {
+		writer.Flush()
+	}
+	c.Writer.Flush()
+	c.Abort()
+
+	conn, _, hijackErr := c.Writer.Hijack()
+	if hijackErr != nil {
+		log.Errorf("Failed to close the connection of the failed export: %v", hijackErr)
+		return
+	}
+	conn.Close()
+}
+
+// exportQueryParams are the parameters of the list query without the paging
+// and the sort, rows are exported in the order of their ids
+func exportQueryParams(values map[string][]string) map[string][]string {
+
+	queryParams := make(map[string][]string)
+	for key, value := range values {
+		if key == "format" || key == "sort" || strings.HasPrefix(key, "page[") {
+			continue
+		}
+		queryParams[key] = value
+	}
+	queryParams["sort"] = []string{"id"}
+	return queryParams
+}
+
+// exportBatch reads the batch of rows after the row with the reference id
+// through the middlewares of the table, so only the rows the user can read are
+// exported, with the values the api has. The rows matching the query are
+// counted when countRows is set.
+func exportBatch(dbResource *resource.DbResource, request *http.Request, queryParams map[string][]string, after string, countRows bool) (*resource.PaginationData, []map[string]interface{}, error) {
+
+	params := make(map[string][]string)
+	for key, value := range queryParams {
+		params[key] = append([]string{}, value...)
+	}
+	if after != "" {
+		params["page[after]"] = []string{after}
+	}
+	params["page[size]"] = []string{fmt.Sprintf("%v", exportBatchSize)}
+
+	req := api2go.Request{
+		PlainRequest: request,
+		QueryParams:  params,
+	}
+	pagination, responder, err := dbResource.PaginatedFindAllAfter(req, countRows)
+	if err != nil {
+		return nil, nil, err
+	}
+	if pagination == nil {
+		pagination = &resource.PaginationData{}
+	}
+
+	rows := make([]map[string]interface{}, 0)
+	if models, ok := responder.Result().([]*api2go.Api2GoModel); ok {
+		for _, model := range models {
+			rows = append(rows, model.GetAttributes())
+		}
+	}
+	return pagination, rows, nil
+}
+
+// exportColumns are the reference id and the requested fields, or all the
+// columns of the table. Foreign keys are exported as the reference id of the
+// related row.
+func exportColumns(tableInfo *resource.TableInfo, fields []string) []string {
+
+	columns := []string{"reference_id"}
+	requested := make(map[string]bool)
+	for _, field := range fields {
+		for _, name := range strings.Split(field, ",") {
+			if name != "" {
+				requested[name] = true
+			}
+		}
+	}
+
+	for _, column := range tableInfo.Columns {
+		if column.ExcludeFromApi || column.ColumnType == "password" || column.ColumnName == "id" || column.ColumnName == "reference_id" {
+			continue
+		}
+		if len(requested) > 0 && !requested[column.ColumnName] {
+			continue
+		}
+		columns = append(columns, column.ColumnName)
+	}
+	return columns
+}
+
+// exportCellValue is the text of a value in a cell of a csv or xlsx file,
+// lists and objects like the files of a column are written as json
+func exportCellValue(value interface{}) string {
+
+	switch typedValue := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return typedValue
+	case []byte:
+		return string(typedValue)
+	case time.Time:
+		return typedValue.Format(time.RFC3339)
+	case float64:
+		return strconv.FormatFloat(typedValue, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(typedValue), 'f', -1, 32)
+	case map[string]interface{}, []interface{}, []map[string]interface{}:
+		data, err := json.Marshal(typedValue)
+		if err != nil {
+			return fmt.Sprintf("%v", typedValue)
+		}
+		return string(data)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+func newExportWriter(format string, writer io.Writer, typeName string, columns []string, total uint) (exportWriter, error) {
+
+	switch format {
+	case "ndjson":
+		return &ndjsonExportWriter{writer: writer, columns: columns}, nil
+	case "xlsx":
+		return newXlsxExportWriter(writer, typeName, columns, total)
+	}
+
+	csvWriter := csv.NewWriter(writer)
+	err := csvWriter.Write(columns)
+	if err != nil {
+		return nil, err
+	}
+	return &csvExportWriter{writer: csvWriter, columns: columns}, nil
+}
+
+type csvExportWriter struct {
+	writer  *csv.Writer
+	columns []string
+}
+
+func (w *csvExportWriter) WriteRow(row map[string]interface{}) error {
+	cells := make([]string, len(w.columns))
+	for i, column := range w.columns {
+		cells[i] = exportCellValue(row[column])
+	}
+	return w.writer.Write(cells)
+}
+
+func (w *csvExportWriter) WriteError(err error) error {
+	return w.writer.Write([]string{"error", err.Error()})
+}
+
+func (w *csvExportWriter) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+func (w *csvExportWriter) Close() error {
+	return w.Flush()
+}
+
+// ndjsonExportWriter writes a json object per line, the keys are in the order
+// of the columns and the values keep their types
+type ndjsonExportWriter struct {
+	writer  io.Writer
+	columns []string
+}
+
+func (w *ndjsonExportWriter) WriteRow(row map[string]interface{}) error {
+	values := make([]interface{}, len(w.columns))
+	for i, column := range w.columns {
+		values[i] = row[column]
+	}
+	return w.writeObject(w.columns, values)
+}
+
+func (w *ndjsonExportWriter) WriteError(err error) error {
+	return w.writeObject([]string{"error"}, []interface{}{err.Error()})
+}
+
+func (w *ndjsonExportWriter) writeObject(keys []string, values []interface{}) error {
+	line := []byte{'{'}
+	for i, key := range keys {
+		if i > 0 {
+			line = append(line, ',')
+		}
+		name, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(values[i])
+		if err != nil {
+			return err
+		}
+		line = append(line, name...)
+		line = append(line, ':')
+		line = append(line, value...)
+	}
+	line = append(line, '}', '\n')
+	_, err := w.writer.Write(line)
+	return err
+}
+
+func (w *ndjsonExportWriter) Flush() error {
+	return nil
+}
+
+func (w *ndjsonExportWriter) Close() error {
+	return nil
+}
+
+// xlsxExportWriter streams the rows to the sheets of a workbook, the sheets
+// for all the rows are added before the workbook is started
+type xlsxExportWriter struct {
+	file    *xlsx.StreamFile
+	columns []string
+	sheets  int
+	sheet   int
+	rows    int
+}
+
+func newXlsxExportWriter(writer io.Writer, typeName string, columns []string, total uint) (*xlsxExportWriter, error) {
+
+	sheets := 1
+	if total > xlsxSheetRows {
+		sheets = int((total + xlsxSheetRows - 1) / xlsxSheetRows)
+	}
+
+	builder := xlsx.NewStreamFileBuilder(writer)
+	for i := 1; i <= sheets; i++ {
+		// sheet names are at most 31 characters
+		name := fmt.Sprintf("%v %d", typeName, i)
+		if len(name) > 31 {
+			name = fmt.Sprintf("%v %d", typeName[:31-len(fmt.Sprintf(" %d", i))], i)
+		}
+		if err := builder.AddSheet(name, columns, nil); err != nil {
+			return nil, err
+		}
+	}
+	file, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	return &xlsxExportWriter{file: file, columns: columns, sheets: sheets}, nil
+}
+
+func (w *xlsxExportWriter) WriteRow(row map[string]interface{}) error {
+	if w.rows == xlsxSheetRows {
+		if w.sheet+1 >= w.sheets {
+			return fmt.Errorf("more than the %d rows counted at the start of the export", w.sheets*xlsxSheetRows)
+		}
+		if err := w.file.NextSheet(); err != nil {
+			return err
+		}
+		w.sheet++
+		w.rows = 0
+	}
+	cells := make([]string, len(w.columns))
+	for i, column := range w.columns {
+		cells[i] = exportCellValue(row[column])
+	}
+	w.rows++
+	return w.file.Write(cells)
+}
+
+// WriteError writes the error in a row, the workbook is not completed
+func (w *xlsxExportWriter) WriteError(err error) error {
+	cells := make([]string, len(w.columns))
+	cells[0] = "error: " + err.Error()
+	return w.file.Write(cells)
+}
+
+func (w *xlsxExportWriter) Flush() error {
+	w.file.Flush()
+	return w.file.Error()
+}
+
+func (w *xlsxExportWriter) Close() error {
+	return w.file.Close()
+}
@@ -0,0 +1,341 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/gin-gonic/gin"
+	"github.com/tealeg/xlsx"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportColumns(t *testing.T) {
+
+	tableInfo := &resource.TableInfo{
+		TableName: "book",
+		Columns: []api2go.ColumnInfo{
+			{ColumnName: "id"},
+			{ColumnName: "reference_id"},
+			{ColumnName: "title", ColumnType: "label"},
+			{ColumnName: "pages", ColumnType: "measurement"},
+			{ColumnName: "secret", ColumnType: "password"},
+			{ColumnName: "internal", ColumnType: "label", ExcludeFromApi: true},
+			{ColumnName: "author_id", ColumnType: "alias"},
+		},
+	}
+
+	for _, test := range []struct {
+		fields  []string
+		columns []string
+	}{
+		{nil, []string{"reference_id", "title", "pages", "author_id"}},
+		{[]string{"pages,title"}, []string{"reference_id", "title", "pages"}},
+		{[]string{"title", "author_id"}, []string{"reference_id", "title", "author_id"}},
+		{[]string{"secret,internal,id"}, []string{"reference_id"}},
+		{[]string{",,pages"}, []string{"reference_id", "pages"}},
+	} {
+		if columns := exportColumns(tableInfo, test.fields); !reflect.DeepEqual(columns, test.columns) {
+			t.Errorf("Expected the columns %v for the fields %v, got %v", test.columns, test.fields, columns)
+		}
+	}
+}
+
+func TestExportCellValue(t *testing.T) {
+
+	for _, test := range []struct {
+		value interface{}
+		cell  string
+	}{
+		{nil, ""},
+		{"text", "text"},
+		{[]byte("bytes"), "bytes"},
+		{time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), "2020-01-02T03:04:05Z"},
+		{float64(12), "12"},
+		{1.5, "1.5"},
+		{float32(0.25), "0.25"},
+		{int64(42), "42"},
+		{true, "true"},
+		{[]interface{}{"a", "b"}, `["a","b"]`},
+		{[]map[string]interface{}{}, `[]`},
+	} {
+		if cell := exportCellValue(test.value); cell != test.cell {
+			t.Errorf("Expected [%v] for %#v, got [%v]", test.cell, test.value, cell)
+		}
+	}
+}
+
+func TestExportWriters(t *testing.T) {
+
+	columns := []string{"reference_id", "title", "pages"}
+	rows := []map[string]interface{}{
+		{"reference_id": "a", "title": "first, with a comma", "pages": float64(10)},
+		{"reference_id": "b", "title": "second", "pages": nil, "other": "not exported"},
+	}
+	exportFailure := errors.New("database is gone")
+
+	for _, format := range []string{"csv", "ndjson", "xlsx"} {
+		for _, failing := range []bool{false, true} {
+
+			buffer := &bytes.Buffer{}
+			writer, err := newExportWriter(format, buffer, "book", columns, uint(len(rows)))
+			if err != nil {
+				t.Fatalf("Failed to start %v export: %v", format, err)
+			}
+			for _, row := range rows {
+				if err = writer.WriteRow(row); err != nil {
+					t.Fatalf("Failed to write %v row: %v", format, err)
+				}
+			}
+			if failing {
+				if err = writer.WriteError(exportFailure); err != nil {
+					t.Fatalf("Failed to write %v error: %v", format, err)
+				}
+			}
+			if err = writer.Close(); err != nil {
+				t.Fatalf("Failed to close %v export: %v", format, err)
+			}
+
+			var lines [][]string
+			switch format {
+			case "csv":
+				reader := csv.NewReader(buffer)
+				reader.FieldsPerRecord = -1
+				lines, err = reader.ReadAll()
+			case "ndjson":
+				scanner := bufio.NewScanner(buffer)
+				for scanner.Scan() {
+					var object map[string]interface{}
+					if err = json.Unmarshal(scanner.Bytes(), &object); err != nil {
+						break
+					}
+					if message, ok := object["error"]; ok {
+						lines = append(lines, []string{"error", fmt.Sprintf("%v", message)})
+						continue
+					}
+					if len(object) != len(columns) {
+						t.Errorf("Expected only the exported columns in %v", object)
+					}
+					line := make([]string, 0)
+					for _, column := range columns {
+						line = append(line, exportCellValue(object[column]))
+					}
+					lines = append(lines, line)
+				}
+				lines = append([][]string{columns}, lines...)
+			case "xlsx":
+				var file *xlsx.File
+				file, err = xlsx.OpenBinary(buffer.Bytes())
+				if err == nil {
+					var sheets [][][]string
+					sheets, err = file.ToSlice()
+					if err == nil {
+						lines = sheets[0]
+					}
+				}
+			}
+			if err != nil {
+				t.Fatalf("Failed to read %v export: %v", format, err)
+			}
+
+			expected := [][]string{
+				columns,
+				{"a", "first, with a comma", "10"},
+				{"b", "second", ""},
+			}
+			if failing {
+				if format == "xlsx" {
+					expected = append(expected, []string{"error: database is gone", "", ""})
+				} else {
+					expected = append(expected, []string{"error", "database is gone"})
+				}
+			}
+			if !reflect.DeepEqual(lines, expected) {
+				t.Errorf("Expected the %v export %v, got %v", format, expected, lines)
+			}
+		}
+	}
+}
+
+func TestExportHandler(t *testing.T) {
+
+	cruds, db, sessionUser, cleanup := serverTestFixture(t)
+	defer cleanup()
+
+	// more rows than a batch, the rows after the first batch are read after the
+	// id of the last row of the batch before
+	rowCount := exportBatchSize*2 + 10
+	tx := db.MustBegin()
+	for i := 0; i < rowCount; i++ {
+		_, err := tx.Exec("insert into address_book (name, description, reference_id, permission, user_account_id, version, created_at) values (?, ?, ?, ?, ?, 1, ?)",
+			fmt.Sprintf("book %d", i), "", fmt.Sprintf("book-%d", i), auth.DEFAULT_PERMISSION, sessionUser.UserId, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	router := withSessionUser(sessionUser)
+	router.GET("/export/:typename", CreateExportHandler(cruds))
+	export := func(url string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest("GET", url, nil))
+		return recorder
+	}
+
+	response := export("/export/address_book?format=ndjson&fields=name&sort=-name")
+	if response.Code != 200 || response.Header().Get("Content-Type") != exportContentTypes["ndjson"] {
+		t.Fatalf("Expected the export to succeed, got %v: %v", response.Code, response.Body.String())
+	}
+	lines := strings.Split(strings.TrimSpace(response.Body.String()), "\n")
+	if len(lines) != rowCount {
+		t.Fatalf("Expected %d rows, got %d", rowCount, len(lines))
+	}
+	for i, line := range lines {
+		var row map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &row); err != nil {
+			t.Fatal(err)
+		}
+		// in the order the rows were created, every row once
+		if row["reference_id"] != fmt.Sprintf("book-%d", i) || row["name"] != fmt.Sprintf("book %d", i) || len(row) != 2 {
+			t.Fatalf("Expected book %d at line %d, got %v", i, i, row)
+		}
+	}
+
+	response = export("/export/address_book?query=" + url.QueryEscape(`[{"column":"name","operator":"is","value":"book 7"}]`))
+	records, err := csv.NewReader(response.Body).ReadAll()
+	if err != nil || len(records) != 2 || records[1][0] != "book-7" {
+		t.Errorf("Expected the rows matching the query, got %v: %v", records, err)
+	}
+
+	if response = export("/export/not_a_table"); response.Code != 404 {
+		t.Errorf("Expected an unknown table to fail, got %v", response.Code)
+	}
+	if response = export("/export/address_book?format=pdf"); response.Code != 400 {
+		t.Errorf("Expected an unknown format to fail, got %v", response.Code)
+	}
+}
+
+// hideRowsInterceptor removes the rows a test user cannot read after they are
+// read, like the permission checks of the middlewares do
+type hideRowsInterceptor struct {
+	hidden func(row map[string]interface{}) bool
+}
+
+func (i *hideRowsInterceptor) String() string {
+	return "hide rows"
+}
+
+func (i *hideRowsInterceptor) InterceptBefore(dr *resource.DbResource, req *api2go.Request, rows []map[string]interface{}) ([]map[string]interface{}, error) {
+	return rows, nil
+}
+
+func (i *hideRowsInterceptor) InterceptAfter(dr *resource.DbResource, req *api2go.Request, rows []map[string]interface{}) ([]map[string]interface{}, error) {
+	visible := make([]map[string]interface{}, 0)
+	for _, row := range rows {
+		if !i.hidden(row) {
+			visible = append(visible, row)
+		}
+	}
+	return visible, nil
+}
+
+func TestExportHandlerHiddenBatch(t *testing.T) {
+
+	cruds, db, sessionUser, cleanup := serverTestFixture(t)
+	defer cleanup()
+
+	// the rows of the first batch and the first rows of the second are
+	// hidden, the export goes on after a batch without a row to write
+	rowCount := exportBatchSize * 2
+	hiddenCount := exportBatchSize + 5
+	tx := db.MustBegin()
+	for i := 0; i < rowCount; i++ {
+		_, err := tx.Exec("insert into address_book (name, description, reference_id, permission, user_account_id, version, created_at) values (?, ?, ?, ?, ?, 1, ?)",
+			fmt.Sprintf("book %d", i), "", fmt.Sprintf("book-%d", i), auth.DEFAULT_PERMISSION, sessionUser.UserId, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	configStore, err := resource.NewConfigStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := *cruds["address_book"].TableInfo()
+	ms := &resource.MiddlewareSet{
+		AfterFindAll: []resource.DatabaseRequestInterceptor{
+			&hideRowsInterceptor{hidden: func(row map[string]interface{}) bool {
+				var index int
+				fmt.Sscanf(row["reference_id"].(string), "book-%d", &index)
+				return index < hiddenCount
+			}},
+		},
+	}
+	model := api2go.NewApi2GoModel(table.TableName, table.Columns, int64(table.DefaultPermission), table.Relations)
+	cruds["address_book"] = resource.NewDbResource(model, db, ms, cruds, configStore, table)
+
+	router := withSessionUser(sessionUser)
+	router.GET("/export/:typename", CreateExportHandler(cruds))
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/export/address_book?format=ndjson&fields=name", nil))
+
+	lines := strings.Split(strings.TrimSpace(recorder.Body.String()), "\n")
+	if recorder.Code != 200 || len(lines) != rowCount-hiddenCount {
+		t.Fatalf("Expected the %d visible rows, got %v: %d lines", rowCount-hiddenCount, recorder.Code, len(lines))
+	}
+	var row map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &row); err != nil || row["reference_id"] != fmt.Sprintf("book-%d", hiddenCount) {
+		t.Errorf("Expected the first visible row first, got %v: %v", row, err)
+	}
+}
+
+func TestAbortExport(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/export", func(c *gin.Context) {
+		c.Header("Content-Type", exportContentTypes["csv"])
+		c.Status(200)
+		writer, err := newExportWriter("csv", c.Writer, "book", []string{"reference_id"}, 2)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		writer.WriteRow(map[string]interface{}{"reference_id": "a"})
+		writer.Flush()
+		c.Writer.Flush()
+		abortExport(c, writer, errors.New("database is gone"))
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if response.StatusCode != 200 || err == nil {
+		t.Errorf("Expected the response to end before it is complete, got %v", response.StatusCode)
+	}
+	if !strings.HasSuffix(string(body), "error,database is gone\n") {
+		t.Errorf("Expected the error as the last row, got %v", string(body))
+	}
+}
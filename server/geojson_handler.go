@@ -0,0 +1,84 @@
+package server
+
+import (
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/gin-gonic/gin"
+)
+
+// CreateGeoJsonHandler lists the rows of an entity as a GeoJSON feature
+// collection. The point of each feature is taken from the location column named
+// in the column parameter, or the first location column of the entity. All the
+// parameters of the list api, like query, near, sort and page, are supported.
+//
+//	GET /geojson/place?near=18.52,73.85&query=location within '18.52,73.85,5000'
+func CreateGeoJsonHandler(initConfig *resource.CmsConfig, cruds map[string]*resource.DbResource) func(*gin.Context) {
+
+	return func(c *gin.Context) {
+
+		typeName := c.Param("typename")
+		dbResource, ok := cruds[typeName]
+		if !ok {
+			c.AbortWithStatus(404)
+			return
+		}
+
+		locationColumn := c.Query("column")
+		for _, column := range dbResource.TableInfo().Columns {
+			if column.ColumnType == "location" && (locationColumn == "" || locationColumn == column.ColumnName) {
+				locationColumn = column.ColumnName
+				break
+			}
+		}
+		column, ok := dbResource.TableInfo().GetColumnByName(locationColumn)
+		if !ok || column.ColumnType != "location" {
+			c.JSON(400, resource.NewDaptinError("No location column on "+typeName, "invalid column"))
+			return
+		}
+
+		req := api2go.Request{
+			PlainRequest: c.Request,
+			QueryParams:  c.Request.URL.Query(),
+		}
+
+		_, response, err := dbResource.PaginatedFindAll(req)
+		if err != nil {
+			c.JSON(400, resource.NewDaptinError(err.Error(), "query failed"))
+			return
+		}
+
+		features := make([]map[string]interface{}, 0)
+		rows, _ := response.Result().([]*api2go.Api2GoModel)
+		for _, row := range rows {
+
+			var geometry map[string]interface{}
+			latitude, longitude, err := resource.ParseLocation(row.Data[locationColumn])
+			if err == nil {
+				geometry = map[string]interface{}{
+					"type":        "Point",
+					"coordinates": []float64{longitude, latitude},
+				}
+			}
+
+			properties := make(map[string]interface{})
+			for key, value := range row.Data {
+				if key != locationColumn && key != "__type" && key != "permission" {
+					properties[key] = value
+				}
+			}
+
+			features = append(features, map[string]interface{}{
+				"type":       "Feature",
+				"id":         row.GetID(),
+				"geometry":   geometry,
+				"properties": properties,
+			})
+		}
+
+		c.Header("Content-Type", "application/geo+json")
+		c.JSON(200, map[string]interface{}{
+			"type":     "FeatureCollection",
+			"features": features,
+		})
+	}
+}
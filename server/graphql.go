@@ -3,6 +3,7 @@ package server
 import (
 	"github.com/artpar/api2go"
 	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/database"
 	"github.com/daptin/daptin/server/resource"
 	"github.com/graphql-go/graphql"
 	"github.com/graphql-go/relay"
@@ -16,7 +17,6 @@ import (
 	"github.com/json-iterator/go"
 	//"fmt"
 	"fmt"
-	"github.com/artpar/api2go/jsonapi"
 )
 
 var json = jsoniter.ConfigCompatibleWithStandardLibrary
@@ -25,7 +25,7 @@ var nodeDefinitions *relay.NodeDefinitions
 
 var Schema graphql.Schema
 
-func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*resource.DbResource) *graphql.Schema {
+func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*resource.DbResource, fsmManager resource.FsmManager, db database.DatabaseConnection) *graphql.Schema {
 
 	//mutations := make(graphql.InputObjectConfigFieldMap)
 	//query := make(graphql.InputObjectConfigFieldMap)
@@ -33,7 +33,7 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 
 	inputTypesMap := make(map[string]*graphql.Object)
 	//outputTypesMap := make(map[string]graphql.Output)
-	//connectionMap := make(map[string]*relay.GraphQLConnectionDefinitions)
+	connectionTypes := make(map[string]*relay.GraphQLConnectionDefinitions)
 
 	nodeDefinitions = relay.NewNodeDefinitions(relay.NodeDefinitionsConfig{
 		IDFetcher: func(id string, info graphql.ResolveInfo, ctx context.Context) (interface{}, error) {
@@ -61,6 +61,7 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 
 	rootFields := make(graphql.Fields)
 	mutationFields := make(graphql.Fields)
+	subscriptionFields := make(graphql.Fields)
 
 	actionResponseType := graphql.NewObject(graphql.ObjectConfig{
 		Name:        "ActionResponse",
@@ -132,13 +133,16 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 		DefaultValue: "",
 	}
 
-	queryArgument := graphql.ArgumentConfig{
-		Type: graphql.NewList(graphql.NewInputObject(graphql.InputObjectConfig{
-			Name:        "query",
-			Description: "query results",
-			Fields: graphql.InputObjectConfigFieldMap{
+	// queries nest through and, or and not, the same as the query parameter of the json api
+	var queryInputType *graphql.InputObject
+	queryInputType = graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:        "query",
+		Description: "query results",
+		Fields: graphql.InputObjectConfigFieldMapThunk(func() graphql.InputObjectConfigFieldMap {
+			return graphql.InputObjectConfigFieldMap{
 				"column": &graphql.InputObjectFieldConfig{
-					Type: graphql.String,
+					Type:        graphql.String,
+					Description: "column name, columns of related rows as relation.column",
 				},
 				"operator": &graphql.InputObjectFieldConfig{
 					Type: graphql.String,
@@ -146,12 +150,30 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 				"value": &graphql.InputObjectFieldConfig{
 					Type: graphql.String,
 				},
-			},
-		})),
+				"and": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewList(queryInputType),
+				},
+				"or": &graphql.InputObjectFieldConfig{
+					Type: graphql.NewList(queryInputType),
+				},
+				"not": &graphql.InputObjectFieldConfig{
+					Type: queryInputType,
+				},
+			}
+		}),
+	})
+
+	queryArgument := graphql.ArgumentConfig{
+		Type:         graphql.NewList(queryInputType),
 		Description:  "filter results by search query",
 		DefaultValue: "",
 	}
 
+	whereArgument := graphql.ArgumentConfig{
+		Type:        graphql.String,
+		Description: "filter results by a query expression, eg: status = 'open' and (priority >= 3 or author.email ~ 'example.com')",
+	}
+
 	filterArgument := graphql.ArgumentConfig{
 		Type:         graphql.String,
 		Description:  "filter data by keyword",
@@ -173,21 +195,31 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 		})
 
 		inputTypesMap[table.TableName] = tableType
+		connectionTypes[table.TableName] = relay.ConnectionDefinitions(relay.ConnectionConfig{
+			Name:     table.TableName,
+			NodeType: tableType,
+			ConnectionFields: graphql.Fields{
+				"totalCount": &graphql.Field{
+					Type:        graphql.NewNonNull(graphql.Int),
+					Description: "number of " + table.TableName + " matching the arguments, on all the pages",
+				},
+			},
+		})
 
 	}
 
-	tableColumnMap := map[string]map[string]api2go.ColumnInfo{}
-
+	// the relations of each table which are a foreign key column of its rows
+	foreignKeyFields := make(map[string]map[string]string)
 	for _, table := range cmsConfig.Tables {
 		if table.IsJoinTable {
 			continue
 		}
-		columnMap := map[string]api2go.ColumnInfo{}
-
-		for _, col := range table.Columns {
-			columnMap[col.ColumnName] = col
+		foreignKeyFields[table.TableName] = make(map[string]string)
+		for _, relation := range table.Relations {
+			if relation.Subject == table.TableName && (relation.Relation == "belongs_to" || relation.Relation == "has_one") {
+				foreignKeyFields[table.TableName][relation.GetObjectName()] = relation.GetObject()
+			}
 		}
-		tableColumnMap[table.TableName] = columnMap
 	}
 
 	for _, table := range cmsConfig.Tables {
@@ -227,43 +259,82 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 			}
 		}
 
+		for _, computedColumn := range table.ComputedColumns {
+			fields[computedColumn.Name] = &graphql.Field{
+				Type:        resource.ColumnManager.GetGraphqlType(computedColumn.GetColumnType()),
+				Description: computedColumn.Description,
+			}
+		}
+
 		for _, relation := range table.Relations {
 
 			targetName := relation.GetSubjectName()
 			targetObject := relation.GetSubject()
+			// the name of the relation on the other table, to filter its rows
+			inverseName := relation.GetObjectName()
 			if relation.Subject == table.TableName {
 				targetName = relation.GetObjectName()
 				targetObject = relation.GetObject()
+				inverseName = relation.GetSubjectName()
+			}
+			if inputTypesMap[targetObject] == nil {
+				continue
 			}
 
-			switch relation.Relation {
-			case "belongs_to":
+			connectionArgs := relay.NewConnectionArgs(graphql.FieldConfigArgument{
+				"filter": &filterArgument,
+				"query":  &queryArgument,
+				"where":  &whereArgument,
+			})
+
+			switch {
+			case relation.Relation == "belongs_to" && relation.Subject == table.TableName:
 				fields[targetName] = &graphql.Field{
 					Type:        graphql.NewNonNull(inputTypesMap[targetObject]),
-					Description: fmt.Sprintf("Belongs to %v", relation.Subject),
+					Description: fmt.Sprintf("Belongs to %v", relation.Object),
+					Resolve:     resolveGraphqlForeignKey(resources, targetObject, foreignKeyFields),
+				}
+			case relation.Relation == "has_one" && relation.Subject == table.TableName:
+				fields[targetName] = &graphql.Field{
+					Type:        inputTypesMap[targetObject],
+					Description: fmt.Sprintf("Has one %v", relation.Object),
+					Resolve:     resolveGraphqlForeignKey(resources, targetObject, foreignKeyFields),
 				}
-			case "has_one":
+			case relation.Relation == "has_one":
 				fields[targetName] = &graphql.Field{
 					Type:        inputTypesMap[targetObject],
 					Description: fmt.Sprintf("Has one %v", relation.Subject),
+					Resolve:     resolveGraphqlRelatedRow(resources, targetObject, inverseName, foreignKeyFields),
 				}
-
-			case "has_many":
+			case relation.Relation == "belongs_to":
 				fields[targetName] = &graphql.Field{
-					Type:        graphql.NewList(inputTypesMap[targetObject]),
-					Description: fmt.Sprintf("Has many %v", relation.Subject),
+					Type:        connectionTypes[targetObject].ConnectionType,
+					Description: fmt.Sprintf("%v which belong to it", relation.Subject),
+					Args:        connectionArgs,
+					Resolve:     resolveGraphqlRelatedConnection(resources, targetObject, inverseName, foreignKeyFields),
 				}
-
-			case "has_many_and_belongs_to_many":
+			case relation.Relation == "has_many":
 				fields[targetName] = &graphql.Field{
-					Type:        graphql.NewList(inputTypesMap[targetObject]),
-					Description: fmt.Sprintf("Related %v", relation.Subject),
+					Type:        connectionTypes[targetObject].ConnectionType,
+					Description: fmt.Sprintf("Has many %v", targetObject),
+					Args:        connectionArgs,
+					Resolve:     resolveGraphqlRelatedConnection(resources, targetObject, inverseName, foreignKeyFields),
+				}
+			case relation.Relation == "has_many_and_belongs_to_many":
+				fields[targetName] = &graphql.Field{
+					Type:        connectionTypes[targetObject].ConnectionType,
+					Description: fmt.Sprintf("Related %v", targetObject),
+					Args:        connectionArgs,
+					Resolve:     resolveGraphqlRelatedConnection(resources, targetObject, inverseName, foreignKeyFields),
 				}
-
 			}
 
 		}
 
+		if table.IsStateTrackingEnabled {
+			fields["states"] = graphqlObjectStatesField(resources, table.TableName)
+		}
+
 		fields["id"] = &graphql.Field{
 			Description: "The ID of an object",
 			Type:        graphql.NewNonNull(graphql.ID),
@@ -276,131 +347,39 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 		// all table names query field
 
 		rootFields[table.TableName] = &graphql.Field{
-			Type:        graphql.NewList(inputTypesMap[table.TableName]),
+			Type:        connectionTypes[table.TableName].ConnectionType,
 			Description: "Find all " + table.TableName,
-			Args: graphql.FieldConfigArgument{
+			Args: relay.NewConnectionArgs(graphql.FieldConfigArgument{
 				"filter": &filterArgument,
 				"query":  &queryArgument,
+				"where":  &whereArgument,
 				"page":   &pageConfig,
-			},
+			}),
 			//Args:        uniqueFields,
 			Resolve: func(table resource.TableInfo) func(params graphql.ResolveParams) (interface{}, error) {
 				return func(params graphql.ResolveParams) (interface{}, error) {
 
 					log.Printf("Arguments: %v", params.Args)
 
-					filters := make([]resource.Query, 0)
-
-					query, isQueried := params.Args["query"]
-					if isQueried {
-						queryMap, ok := query.([]interface{})
-						if ok {
-							for _, qu := range queryMap {
-								q := qu.(map[string]interface{})
-								query := resource.Query{
-									ColumnName: q["column"].(string),
-									Operator:   q["operator"].(string),
-									Value:      q["value"].(string),
-								}
-								filters = append(filters, query)
-							}
-						}
-					}
-
-					filter, isFiltered := params.Args["filter"]
-
-					if !isFiltered {
-						filter = ""
-					}
-
-					pr := &http.Request{
-						Method: "GET",
-					}
-					pr = pr.WithContext(params.Context)
-
-					pageNumber := 1
-					pageSize := 10
-					pageParams, ok := params.Args["page"]
-					if ok {
-						pageParamsMap, ok := pageParams.(map[string]interface{})
-						if ok {
-							pageSizeNew, ok := pageParamsMap["size"]
-							if ok {
-								pageSize, ok = pageSizeNew.(int)
-							}
-							pageNumberNew, ok := pageParamsMap["number"]
-							if ok {
-								pageNumber, ok = pageNumberNew.(int)
-							}
-						}
-
-					}
-
-					jsStr, err := json.Marshal(filters)
-					req := api2go.Request{
-						PlainRequest: pr,
-
-						QueryParams: map[string][]string{
-							"query":              {string(jsStr)},
-							"filter":             {filter.(string)},
-							"page[number]":       {fmt.Sprintf("%v", pageNumber)},
-							"page[size]":         {fmt.Sprintf("%v", pageSize)},
-							"included_relations": {"*"},
-						},
-					}
-
-					count, responder, err := resources[table.TableName].PaginatedFindAll(req)
-
-					if count == 0 {
-						return nil, errors.New("no such entity")
-					}
-					items := make([]map[string]interface{}, 0)
-
-					results := responder.Result().([]*api2go.Api2GoModel)
-
-					columnMap := tableColumnMap[table.TableName]
-
-					for _, r := range results {
-
-						included := r.Includes
-						includedMap := make(map[string]jsonapi.MarshalIdentifier)
-
-						for _, included := range included {
-							includedMap[included.GetID()] = included
-						}
-
-						data := r.Data
-
-						for key, val := range data {
-							colInfo, ok := columnMap[key]
-							if !ok {
-								continue
-							}
-
-							strVal, ok := val.(string)
-							if !ok {
-								continue
-							}
-
-							if colInfo.IsForeignKey {
-								fObj, ok := includedMap[strVal]
-
-								if ok {
-									data[key] = fObj.GetAttributes()
-								}
-							}
-
-						}
-
-						items = append(items, data)
-
-					}
-
-					return items, err
-
+					return graphqlConnection(resources[table.TableName], params, nil, foreignKeyFields)
 				}
 			}(table),
 		}
+
+		rootFields["aggregate"+strcase.ToCamel(table.TableName)] = graphqlAggregateField(resources[table.TableName], table, &queryArgument, &whereArgument)
+
+		for _, eventType := range []string{resource.EntityCreated, resource.EntityUpdated, resource.EntityDeleted} {
+			subscriptionFields[graphqlSubscriptionFieldName(eventType, table.TableName)] = &graphql.Field{
+				Type:        inputTypesMap[table.TableName],
+				Description: fmt.Sprintf("Each %v %v", strings.ReplaceAll(table.TableName, "_", " "), eventType),
+				Args: graphql.FieldConfigArgument{
+					"filter": &filterArgument,
+					"query":  &queryArgument,
+					"where":  &whereArgument,
+				},
+				Resolve: resolveGraphqlSubscriptionField(table.TableName, eventType),
+			}
+		}
 		//
 		//rootFields["all"+Capitalize(inflector.Pluralize(table.TableName))] = &graphql.Field{
 		//	Type:        graphql.NewList(inputTypesMap[table.TableName]),
@@ -630,6 +609,38 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 				},
 			}
 
+			if len(table.UpsertKeys) > 0 {
+				mutationFields["upsert"+strcase.ToCamel(table.TableName)] = &graphql.Field{
+					Type:        inputTypesMap[table.TableName],
+					Description: "Create new " + strings.ReplaceAll(table.TableName, "_", " ") + " or update the one with the same " + strings.Join(table.UpsertKeys, ", "),
+					Args:        inputFields,
+					Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+						obj := api2go.NewApi2GoModelWithData(table.TableName, nil, 0, nil, params.Args)
+
+						pr := &http.Request{
+							Method: "POST",
+						}
+
+						pr = pr.WithContext(params.Context)
+
+						req := api2go.Request{
+							PlainRequest: pr,
+							QueryParams: map[string][]string{
+								"upsert": {"true"},
+							},
+						}
+
+						created, err := resources[table.TableName].Create(obj, req)
+
+						if err != nil {
+							return nil, err
+						}
+
+						return created.Result().(*api2go.Api2GoModel).Data, err
+					},
+				}
+			}
+
 			updateInputFields := make(graphql.FieldConfigArgument)
 			for k, v := range updateFields {
 				updateInputFields[k] = v
@@ -640,6 +651,11 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 				Description: "Resource id",
 			}
 
+			updateInputFields["version"] = &graphql.ArgumentConfig{
+				Type:        graphql.Int,
+				Description: "Expected current version, the update fails if the resource was changed since",
+			}
+
 			mutationFields["update"+strcase.ToCamel(table.TableName)] = &graphql.Field{
 				Type:        inputTypesMap[table.TableName],
 				Description: "Update " + strings.ReplaceAll(table.TableName, "_", " "),
@@ -732,6 +748,10 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 				},
 			}
 
+			if table.IsStateTrackingEnabled {
+				addGraphqlStateMutations(mutationFields, resources, fsmManager, db, table.TableName)
+			}
+
 		}(t)
 
 	}
@@ -971,10 +991,16 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 		Fields: mutationFields,
 	})
 
+	subscriptionType := graphql.NewObject(graphql.ObjectConfig{
+		Name:   "Subscription",
+		Fields: subscriptionFields,
+	})
+
 	var err error
 	Schema, err = graphql.NewSchema(graphql.SchemaConfig{
-		Query:    rootQuery,
-		Mutation: mutationType,
+		Query:        rootQuery,
+		Mutation:     mutationType,
+		Subscription: subscriptionType,
 	})
 	if err != nil {
 		panic(err)
@@ -1180,3 +1206,144 @@ func MakeGraphqlSchema(cmsConfig *resource.CmsConfig, resources map[string]*reso
 	//return &schema
 
 }
+
+// graphqlListRequest builds the find all request for the filter, query, where
+// and page arguments of a list field, and the other filters
+func graphqlListRequest(args map[string]interface{}, ctx context.Context, otherFilters []resource.Query) (api2go.Request, error) {
+
+	filters, err := graphqlQueryFilters(args)
+	if err != nil {
+		return api2go.Request{}, err
+	}
+	filters = append(filters, otherFilters...)
+
+	filter, isFiltered := args["filter"].(string)
+
+	if !isFiltered {
+		filter = ""
+	}
+
+	pr := &http.Request{
+		Method: "GET",
+	}
+	pr = pr.WithContext(ctx)
+
+	pageNumber := 1
+	pageSize := 10
+	pageParams, ok := args["page"]
+	if ok {
+		pageParamsMap, ok := pageParams.(map[string]interface{})
+		if ok {
+			pageSizeNew, ok := pageParamsMap["size"]
+			if ok {
+				pageSize, ok = pageSizeNew.(int)
+			}
+			pageNumberNew, ok := pageParamsMap["number"]
+			if ok {
+				pageNumber, ok = pageNumberNew.(int)
+			}
+		}
+
+	}
+
+	jsStr, err := json.Marshal(filters)
+	req := api2go.Request{
+		PlainRequest: pr,
+
+		QueryParams: map[string][]string{
+			"query":        {string(jsStr)},
+			"filter":       {filter},
+			"page[number]": {fmt.Sprintf("%v", pageNumber)},
+			"page[size]":   {fmt.Sprintf("%v", pageSize)},
+		},
+	}
+	return req, err
+}
+
+// graphqlQueryFilters reads the queries of the query and where arguments
+func graphqlQueryFilters(args map[string]interface{}) ([]resource.Query, error) {
+
+	filters := make([]resource.Query, 0)
+
+	query, isQueried := args["query"]
+	if isQueried {
+		queryList, ok := query.([]interface{})
+		if ok {
+			queryJson, err := json.Marshal(queryList)
+			if err != nil {
+				return nil, err
+			}
+			err = json.Unmarshal(queryJson, &filters)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	where, isWhere := args["where"].(string)
+	if isWhere && where != "" {
+		whereQueries, err := resource.ParseQueryParam(where)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, whereQueries...)
+	}
+
+	return filters, nil
+}
+
+// graphqlListItems returns the rows of a find all response, with the foreign
+// keys replaced by the included rows, the other foreign keys are resolved when
+// they are selected
+func graphqlListItems(responder api2go.Responder) []map[string]interface{} {
+
+	items := make([]map[string]interface{}, 0)
+	if responder == nil {
+		return items
+	}
+
+	results := responder.Result().([]*api2go.Api2GoModel)
+
+	for _, r := range results {
+
+		// included rows refer to each other through the includes of nested relations
+		includedMap := make(map[string]map[string]interface{})
+		includedColumns := make(map[string]map[string]api2go.ColumnInfo)
+		for _, included := range r.Includes {
+			includedMap[included.GetID()] = included.GetAttributes()
+			if model, ok := included.(*api2go.Api2GoModel); ok {
+				includedColumns[included.GetID()] = model.GetColumnMap()
+			}
+		}
+
+		for id, attributes := range includedMap {
+			graphqlReplaceForeignKeys(attributes, includedColumns[id], includedMap)
+		}
+
+		data := r.Data
+		graphqlReplaceForeignKeys(data, r.GetColumnMap(), includedMap)
+
+		items = append(items, data)
+
+	}
+
+	return items
+}
+
+func graphqlReplaceForeignKeys(data map[string]interface{}, columnMap map[string]api2go.ColumnInfo, includedMap map[string]map[string]interface{}) {
+	for key, val := range data {
+		colInfo, ok := columnMap[key]
+		if !ok || !colInfo.IsForeignKey {
+			continue
+		}
+
+		strVal, ok := val.(string)
+		if !ok {
+			continue
+		}
+
+		if fObj, ok := includedMap[strVal]; ok {
+			data[key] = fObj
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"strings"
+)
+
+// graphqlAggregateFunctions are the metrics of the numeric columns, the count
+// of rows is a field of its own
+var graphqlAggregateFunctions = []string{"sum", "avg", "min", "max"}
+
+// graphqlAggregateField is the aggregate<Table> query. The rows matching the
+// query and where arguments are grouped by the groupBy columns, and the
+// metrics selected are computed for each group, through the same
+// aggregation as /stats/:typename.
+func graphqlAggregateField(dr *resource.DbResource, table resource.TableInfo, queryArgument *graphql.ArgumentConfig, whereArgument *graphql.ArgumentConfig) *graphql.Field {
+
+	fields := graphql.Fields{
+		"count": &graphql.Field{
+			Type:        graphql.Int,
+			Description: "number of rows in the group",
+		},
+	}
+
+	groupColumns := make(map[string]bool)
+	numericFields := graphql.Fields{}
+	for _, column := range table.Columns {
+		if column.IsForeignKey || column.ColumnType == "password" || column.ColumnType == "encrypted" {
+			continue
+		}
+		graphqlType := resource.ColumnManager.GetGraphqlType(column.ColumnType)
+		groupColumns[column.ColumnName] = true
+		fields[column.ColumnName] = &graphql.Field{
+			Type:        graphqlType,
+			Description: "value of " + column.ColumnName + " for the group, when grouped by it",
+		}
+		if column.ColumnName != "id" && (graphqlType == graphql.Int || graphqlType == graphql.Float) {
+			numericFields[column.ColumnName] = &graphql.Field{
+				Type: graphql.Float,
+			}
+		}
+	}
+
+	if len(numericFields) > 0 {
+		metricType := graphql.NewObject(graphql.ObjectConfig{
+			Name:        table.TableName + "AggregateValues",
+			Description: "metric of each numeric column of " + table.TableName,
+			Fields:      numericFields,
+		})
+		for _, function := range graphqlAggregateFunctions {
+			fields[function] = &graphql.Field{
+				Type:        metricType,
+				Description: function + " of the columns in the group",
+			}
+		}
+	}
+
+	aggregateType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        table.TableName + "Aggregate",
+		Description: "a group of " + table.TableName,
+		Fields:      fields,
+	})
+
+	return &graphql.Field{
+		Type:        graphql.NewList(aggregateType),
+		Description: "Aggregate " + strings.ReplaceAll(table.TableName, "_", " ") + " by groups",
+		Args: graphql.FieldConfigArgument{
+			"groupBy": &graphql.ArgumentConfig{
+				Type:        graphql.NewList(graphql.String),
+				Description: "columns to group the rows by",
+			},
+			"orderBy": &graphql.ArgumentConfig{
+				Type:        graphql.NewList(graphql.String),
+				Description: "group columns, count or metrics like sum.price to order the groups by, -count orders by count descending",
+			},
+			"query": queryArgument,
+			"where": whereArgument,
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+
+			sessionUser, ok := params.Context.Value("user").(*auth.SessionUser)
+			perm := dr.GetObjectPermissionByWhereClause("world", "table_name", table.TableName)
+			if !ok || !perm.CanExecute(sessionUser.UserReferenceId, sessionUser.Groups) {
+				return nil, errors.New("unauthorized")
+			}
+
+			aggReq := resource.AggregationRequest{
+				RootEntity: table.TableName,
+				GroupBy:    make([]string, 0),
+				User:       sessionUser,
+			}
+
+			groupBy, _ := params.Args["groupBy"].([]interface{})
+			grouped := make(map[string]bool)
+			for _, value := range groupBy {
+				column, _ := value.(string)
+				if !groupColumns[column] {
+					return nil, fmt.Errorf("cannot group by [%v]", column)
+				}
+				grouped[column] = true
+				aggReq.GroupBy = append(aggReq.GroupBy, column)
+			}
+
+			// the metrics computed are the ones selected
+			metrics := make(map[string]bool)
+			selections := graphqlFieldAstSelections(params.Info)
+			if graphqlSelectsField(selections, params.Info.Fragments, "count") {
+				metrics["count"] = true
+				aggReq.ProjectColumn = append(aggReq.ProjectColumn, "count(*) as count")
+			}
+			for _, function := range graphqlAggregateFunctions {
+				functionSelections := graphqlFieldSelections(selections, params.Info.Fragments, function)
+				for column := range numericFields {
+					if !graphqlSelectsField(functionSelections, params.Info.Fragments, column) {
+						continue
+					}
+					metrics[function+"."+column] = true
+					aggReq.ProjectColumn = append(aggReq.ProjectColumn, fmt.Sprintf("%s(%s) as %s__%s", function, column, function, column))
+				}
+			}
+
+			orderBy, _ := params.Args["orderBy"].([]interface{})
+			for _, value := range orderBy {
+				order, _ := value.(string)
+				direction := "asc"
+				if strings.HasPrefix(order, "-") {
+					direction = "desc"
+					order = order[1:]
+				}
+				switch {
+				case grouped[order]:
+				case metrics[order]:
+					order = strings.Replace(order, ".", "__", 1)
+				default:
+					return nil, fmt.Errorf("cannot order by [%v], order by a group column or a selected metric", order)
+				}
+				aggReq.Order = append(aggReq.Order, order+" "+direction)
+			}
+
+			var err error
+			aggReq.Query, err = graphqlQueryFilters(params.Args)
+			if err != nil {
+				return nil, err
+			}
+
+			aggResponse, err := dr.DataStats(aggReq)
+			if err != nil {
+				return nil, err
+			}
+
+			groups := make([]map[string]interface{}, 0)
+			for _, row := range aggResponse.Data {
+				group := make(map[string]interface{})
+				for column := range grouped {
+					group[column] = row[column]
+				}
+				if metrics["count"] {
+					group["count"] = row["count"]
+				}
+				for _, function := range graphqlAggregateFunctions {
+					values := make(map[string]interface{})
+					for column := range numericFields {
+						if metrics[function+"."+column] {
+							values[column] = row[function+"__"+column]
+						}
+					}
+					if len(values) > 0 {
+						group[function] = values
+					}
+				}
+				groups = append(groups, group)
+			}
+
+			return groups, nil
+		},
+	}
+}
+
+// graphqlSelectsField tells if a field with the name is selected in the
+// selection sets, including the ones in fragments
+func graphqlSelectsField(selectionSets []*ast.SelectionSet, fragments map[string]ast.Definition, name string) bool {
+
+	for _, selectionSet := range selectionSets {
+		if selectionSet == nil {
+			continue
+		}
+		for _, selection := range selectionSet.Selections {
+			switch selection := selection.(type) {
+			case *ast.Field:
+				if selection.Name != nil && selection.Name.Value == name {
+					return true
+				}
+			case *ast.InlineFragment:
+				if graphqlSelectsField([]*ast.SelectionSet{selection.SelectionSet}, fragments, name) {
+					return true
+				}
+			case *ast.FragmentSpread:
+				if selection.Name == nil {
+					continue
+				}
+				if definition, ok := fragments[selection.Name.Value].(*ast.FragmentDefinition); ok &&
+					graphqlSelectsField([]*ast.SelectionSet{definition.SelectionSet}, fragments, name) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,275 @@
+package server
+
+import (
+	"fmt"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/relay"
+)
+
+// graphqlDefaultPageSize is the number of rows in a connection when neither
+// first nor last are given
+const graphqlDefaultPageSize = 10
+
+// graphqlMaxPageSize is the most rows a list or a connection returns, larger
+// first, last and page sizes are cut down to it
+const graphqlMaxPageSize = 1000
+
+// graphqlConnection finds the rows of a list field as a relay connection. The
+// rows are picked by offset, with the first, after, last and before arguments
+// or else with the page argument. The relations selected on the nodes are
+// included in the same request.
+func graphqlConnection(dr *resource.DbResource, params graphql.ResolveParams, otherFilters []resource.Query, foreignKeyFields map[string]map[string]string) (interface{}, error) {
+
+	req, err := graphqlListRequest(params.Args, params.Context, otherFilters)
+	if err != nil {
+		return nil, err
+	}
+
+	start, end := 0, -1
+	if page, ok := params.Args["page"].(map[string]interface{}); ok {
+		number, _ := page["number"].(int)
+		size, _ := page["size"].(int)
+		if number > 1 {
+			start = (number - 1) * size
+		}
+		end = start + size
+	}
+	if after, ok := params.Args["after"].(string); ok {
+		offset, err := relay.CursorToOffset(relay.ConnectionCursor(after))
+		if err != nil {
+			return nil, fmt.Errorf("invalid cursor [%v]", after)
+		}
+		if offset+1 > start {
+			start = offset + 1
+		}
+	}
+	if before, ok := params.Args["before"].(string); ok {
+		offset, err := relay.CursorToOffset(relay.ConnectionCursor(before))
+		if err != nil {
+			return nil, fmt.Errorf("invalid cursor [%v]", before)
+		}
+		if end < 0 || offset < end {
+			end = offset
+		}
+	}
+	if first, ok := params.Args["first"].(int); ok {
+		if first < 0 {
+			return nil, fmt.Errorf("first cannot be negative")
+		}
+		if end < 0 || start+first < end {
+			end = start + first
+		}
+	}
+	if last, ok := params.Args["last"].(int); ok {
+		if last < 0 {
+			return nil, fmt.Errorf("last cannot be negative")
+		}
+		if end < 0 {
+			// the last rows are counted from the end
+			req.QueryParams["page[offset]"] = []string{"0"}
+			req.QueryParams["page[size]"] = []string{"1"}
+			total, _, err := dr.PaginatedFindAll(req)
+			if err != nil {
+				return nil, err
+			}
+			end = int(total)
+		}
+		if end-last > start {
+			start = end - last
+		}
+	}
+	if end < 0 {
+		end = start + graphqlDefaultPageSize
+	}
+	if end < start {
+		end = start
+	}
+
+	if end-start > graphqlMaxPageSize {
+		end = start + graphqlMaxPageSize
+	}
+
+	size := end - start
+	if size < 1 {
+		size = 1
+	}
+	req.QueryParams["page[offset]"] = []string{fmt.Sprintf("%v", start)}
+	req.QueryParams["page[size]"] = []string{fmt.Sprintf("%v", size)}
+
+	nodes := graphqlFieldSelections(graphqlFieldSelections(graphqlFieldAstSelections(params.Info), params.Info.Fragments, "edges"), params.Info.Fragments, "node")
+	req.QueryParams["included_relations"] = graphqlIncludePaths(nodes, params.Info.Fragments, dr.TableInfo().TableName, foreignKeyFields)
+
+	total, responder, err := dr.PaginatedFindAll(req)
+	if err != nil {
+		return nil, err
+	}
+
+	items := graphqlListItems(responder)
+	if len(items) > end-start {
+		items = items[:end-start]
+	}
+
+	edges := make([]*relay.Edge, 0)
+	for i, item := range items {
+		edges = append(edges, &relay.Edge{
+			Node:   item,
+			Cursor: relay.OffsetToCursor(start + i),
+		})
+	}
+
+	pageInfo := relay.PageInfo{
+		HasPreviousPage: start > 0,
+		HasNextPage:     start+len(items) < int(total),
+	}
+	if len(edges) > 0 {
+		pageInfo.StartCursor = edges[0].Cursor
+		pageInfo.EndCursor = edges[len(edges)-1].Cursor
+	}
+
+	return map[string]interface{}{
+		"edges":      edges,
+		"pageInfo":   pageInfo,
+		"totalCount": int(total),
+	}, nil
+}
+
+// graphqlFindRow finds the row matching the filters, with the relations
+// selected on it
+func graphqlFindRow(dr *resource.DbResource, params graphql.ResolveParams, filters []resource.Query, foreignKeyFields map[string]map[string]string) (interface{}, error) {
+
+	req, err := graphqlListRequest(map[string]interface{}{}, params.Context, filters)
+	if err != nil {
+		return nil, err
+	}
+	req.QueryParams["page[size]"] = []string{"1"}
+	req.QueryParams["included_relations"] = graphqlIncludePaths(graphqlFieldAstSelections(params.Info), params.Info.Fragments, dr.TableInfo().TableName, foreignKeyFields)
+
+	_, responder, err := dr.PaginatedFindAll(req)
+	if err != nil {
+		return nil, err
+	}
+
+	items := graphqlListItems(responder)
+	if len(items) == 0 {
+		return nil, nil
+	}
+	return items[0], nil
+}
+
+// resolveGraphqlForeignKey resolves the row a foreign key refers to, from the
+// includes of the row when it was selected with it, or else by its reference id
+func resolveGraphqlForeignKey(resources map[string]*resource.DbResource, targetName string, foreignKeyFields map[string]map[string]string) graphql.FieldResolveFn {
+	return func(params graphql.ResolveParams) (interface{}, error) {
+
+		source, _ := params.Source.(map[string]interface{})
+		switch value := source[params.Info.FieldName].(type) {
+		case map[string]interface{}:
+			return value, nil
+		case string:
+			if value == "" {
+				return nil, nil
+			}
+			return graphqlFindRow(resources[targetName], params, []resource.Query{
+				{ColumnName: "reference_id", Operator: "is", Value: value},
+			}, foreignKeyFields)
+		}
+		return nil, nil
+	}
+}
+
+// resolveGraphqlRelatedRow resolves the one row of the other table related to
+// the row, through the relation named inverseName on the other table
+func resolveGraphqlRelatedRow(resources map[string]*resource.DbResource, targetName string, inverseName string, foreignKeyFields map[string]map[string]string) graphql.FieldResolveFn {
+	return func(params graphql.ResolveParams) (interface{}, error) {
+
+		source, _ := params.Source.(map[string]interface{})
+		referenceId, ok := source["reference_id"].(string)
+		if !ok {
+			return nil, nil
+		}
+		return graphqlFindRow(resources[targetName], params, []resource.Query{
+			{ColumnName: inverseName + ".reference_id", Operator: "is", Value: referenceId},
+		}, foreignKeyFields)
+	}
+}
+
+// resolveGraphqlRelatedConnection resolves the rows of the other table related
+// to the row as a connection, through the relation named inverseName on the
+// other table
+func resolveGraphqlRelatedConnection(resources map[string]*resource.DbResource, targetName string, inverseName string, foreignKeyFields map[string]map[string]string) graphql.FieldResolveFn {
+	return func(params graphql.ResolveParams) (interface{}, error) {
+
+		source, _ := params.Source.(map[string]interface{})
+		referenceId, ok := source["reference_id"].(string)
+		if !ok {
+			return nil, nil
+		}
+		return graphqlConnection(resources[targetName], params, []resource.Query{
+			{ColumnName: inverseName + ".reference_id", Operator: "is", Value: referenceId},
+		}, foreignKeyFields)
+	}
+}
+
+// graphqlIncludePaths lists the foreign key relations selected in the
+// selection sets of a row of the table, and below them, as dotted include
+// paths. Relations which are not selected are not loaded.
+func graphqlIncludePaths(selectionSets []*ast.SelectionSet, fragments map[string]ast.Definition, tableName string, foreignKeyFields map[string]map[string]string) []string {
+
+	paths := make([]string, 0)
+	for relationName, targetName := range foreignKeyFields[tableName] {
+		selected := graphqlFieldSelections(selectionSets, fragments, relationName)
+		if len(selected) == 0 {
+			continue
+		}
+		paths = append(paths, relationName)
+		for _, path := range graphqlIncludePaths(selected, fragments, targetName, foreignKeyFields) {
+			paths = append(paths, relationName+"."+path)
+		}
+	}
+
+	return paths
+}
+
+// graphqlFieldAstSelections returns the selection sets of the field being resolved
+func graphqlFieldAstSelections(info graphql.ResolveInfo) []*ast.SelectionSet {
+	selectionSets := make([]*ast.SelectionSet, 0)
+	for _, field := range info.FieldASTs {
+		if field.SelectionSet != nil {
+			selectionSets = append(selectionSets, field.SelectionSet)
+		}
+	}
+	return selectionSets
+}
+
+// graphqlFieldSelections returns the selection sets of the fields with the
+// name in the selection sets, including the ones in fragments
+func graphqlFieldSelections(selectionSets []*ast.SelectionSet, fragments map[string]ast.Definition, name string) []*ast.SelectionSet {
+
+	found := make([]*ast.SelectionSet, 0)
+	for _, selectionSet := range selectionSets {
+		if selectionSet == nil {
+			continue
+		}
+		for _, selection := range selectionSet.Selections {
+			switch selection := selection.(type) {
+			case *ast.Field:
+				if selection.Name != nil && selection.Name.Value == name && selection.SelectionSet != nil {
+					found = append(found, selection.SelectionSet)
+				}
+			case *ast.InlineFragment:
+				found = append(found, graphqlFieldSelections([]*ast.SelectionSet{selection.SelectionSet}, fragments, name)...)
+			case *ast.FragmentSpread:
+				if selection.Name == nil {
+					continue
+				}
+				if definition, ok := fragments[selection.Name.Value].(*ast.FragmentDefinition); ok {
+					found = append(found, graphqlFieldSelections([]*ast.SelectionSet{definition.SelectionSet}, fragments, name)...)
+				}
+			}
+		}
+	}
+
+	return found
+}
@@ -0,0 +1,647 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// graphqlPersistedQueryCacheSize is the number of persisted queries kept in
+// memory, the oldest ones are dropped first
+const graphqlPersistedQueryCacheSize = 1000
+
+// GraphqlLimits are the checks a graphql request goes through before it is
+// executed: the depth and the complexity of the operations, introspection,
+// and the persisted queries allow-list. Persisted queries are served with the
+// Automatic Persisted Queries protocol, a query registered with its sha256
+// hash can be sent again by the hash alone.
+//
+// The limits are read from the config:
+//
+//	graphql.max_depth            deepest field selection, 15 by default
+//	graphql.max_complexity       highest complexity score, 5000 by default
+//	graphql.field_costs          cost of fields, like "Type.field=5,field=2", 1 by default
+//	graphql.persisted_queries    comma separated sha256 hashes of the only queries allowed
+//	graphql.graphiql             serve GraphiQL, true/false
+//	graphql.introspection        allow __schema and __type queries, true/false
+//
+// GraphiQL and introspection are enabled unless set to false, or unless the
+// server runs in release mode and they are not set to true.
+type GraphqlLimits struct {
+	MaxDepth         int
+	MaxComplexity    int
+	FieldCosts       map[string]int
+	GraphiQL         bool
+	Introspection    bool
+	PersistedQueries map[string]bool
+
+	schema      *graphql.Schema
+	lock        sync.Mutex
+	cache       map[string]string
+	cacheHashes []string
+}
+
+// graphqlLimitError is a request rejected before it is executed
+type graphqlLimitError struct {
+	message string
+	code    string
+}
+
+func (e *graphqlLimitError) Error() string {
+	return e.message
+}
+
+func NewGraphqlLimits(configStore *resource.ConfigStore, schema *graphql.Schema) *GraphqlLimits {
+
+	limits := &GraphqlLimits{
+		MaxDepth:         15,
+		MaxComplexity:    5000,
+		FieldCosts:       make(map[string]int),
+		GraphiQL:         gin.Mode() != gin.ReleaseMode,
+		Introspection:    gin.Mode() != gin.ReleaseMode,
+		PersistedQueries: make(map[string]bool),
+		schema:           schema,
+		cache:            make(map[string]string),
+	}
+
+	limits.MaxDepth = graphqlLimitConfigInt(configStore, "graphql.max_depth", limits.MaxDepth)
+	limits.MaxComplexity = graphqlLimitConfigInt(configStore, "graphql.max_complexity", limits.MaxComplexity)
+
+	fieldCosts, err := configStore.GetConfigValueFor("graphql.field_costs", "backend")
+	if err == nil {
+		for _, fieldCost := range strings.Split(fieldCosts, ",") {
+			parts := strings.SplitN(strings.TrimSpace(fieldCost), "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			cost, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+			if err != nil || cost < 0 {
+				log.Errorf("Invalid cost of graphql field [%v]", fieldCost)
+				continue
+			}
+			limits.FieldCosts[strings.TrimSpace(parts[0])] = cost
+		}
+	}
+
+	persistedQueries, err := configStore.GetConfigValueFor("graphql.persisted_queries", "backend")
+	if err == nil {
+		for _, hash := range strings.Split(persistedQueries, ",") {
+			hash = strings.ToLower(strings.TrimSpace(hash))
+			if hash != "" {
+				limits.PersistedQueries[hash] = true
+			}
+		}
+	}
+
+	if graphiql, err := configStore.GetConfigValueFor("graphql.graphiql", "backend"); err == nil {
+		limits.GraphiQL = graphiql == "true"
+	}
+	if introspection, err := configStore.GetConfigValueFor("graphql.introspection", "backend"); err == nil {
+		limits.Introspection = introspection == "true"
+	}
+
+	return limits
+}
+
+func graphqlLimitConfigInt(configStore *resource.ConfigStore, key string, defaultValue int) int {
+	value, err := configStore.GetConfigValueFor(key, "backend")
+	if err != nil {
+		err = configStore.SetConfigValueFor(key, fmt.Sprintf("%v", defaultValue), "backend")
+		resource.CheckErr(err, "Failed to store default value for %v", key)
+		return defaultValue
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 1 {
+		log.Errorf("Invalid %v [%v], using %v", key, value, defaultValue)
+		return defaultValue
+	}
+	return number
+}
+
+// graphqlHttpOperation is the operation of a graphql request
+type graphqlHttpOperation struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// graphqlHttpRequest is a graphql request sent over http, with the extensions
+// of the persisted queries protocol
+type graphqlHttpRequest struct {
+	graphqlHttpOperation
+	Extensions struct {
+		PersistedQuery *struct {
+			Version    int    `json:"version"`
+			Sha256Hash string `json:"sha256Hash"`
+		} `json:"persistedQuery"`
+	} `json:"extensions"`
+}
+
+// readGraphqlHttpRequest reads the operation from the url of a GET request, or
+// else from the body as json, graphql or a form
+func readGraphqlHttpRequest(r *http.Request) (*graphqlHttpRequest, error) {
+
+	request := &graphqlHttpRequest{}
+	values := r.URL.Query()
+	if r.Method != http.MethodGet && values.Get("query") == "" && values.Get("extensions") == "" {
+
+		contentType := strings.TrimSpace(strings.Split(r.Header.Get("Content-Type"), ";")[0])
+		switch contentType {
+		case "application/graphql":
+			body, err := readGraphqlBody(r)
+			if err != nil {
+				return nil, err
+			}
+			request.Query = string(body)
+			return request, nil
+		case "application/x-www-form-urlencoded":
+			if err := r.ParseForm(); err != nil {
+				return nil, err
+			}
+			values = r.PostForm
+		default:
+			body, err := readGraphqlBody(r)
+			if err != nil {
+				return nil, err
+			}
+			if len(body) == 0 {
+				return request, nil
+			}
+			err = json.Unmarshal(body, request)
+			if err != nil {
+				return nil, fmt.Errorf("invalid graphql request: %v", err)
+			}
+			return request, nil
+		}
+	}
+
+	request.Query = values.Get("query")
+	request.OperationName = values.Get("operationName")
+	if variables := values.Get("variables"); variables != "" {
+		if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+			return nil, fmt.Errorf("invalid graphql variables: %v", err)
+		}
+	}
+	if extensions := values.Get("extensions"); extensions != "" {
+		if err := json.Unmarshal([]byte(extensions), &request.Extensions); err != nil {
+			return nil, fmt.Errorf("invalid graphql extensions: %v", err)
+		}
+	}
+	return request, nil
+}
+
+// readGraphqlBody reads the body of the request, and puts it back for the
+// graphql handler
+func readGraphqlBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return []byte{}, nil
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// Handler checks the graphql requests, and passes the ones within the limits
+// to the graphql handler, with the persisted query in place of the hash
+func (limits *GraphqlLimits) Handler(next http.Handler) func(*gin.Context) {
+	return func(c *gin.Context) {
+
+		request, err := readGraphqlHttpRequest(c.Request)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, graphqlErrorResponse(err.Error(), "BAD_REQUEST"))
+			return
+		}
+
+		hash := ""
+		if request.Extensions.PersistedQuery != nil {
+			hash = strings.ToLower(request.Extensions.PersistedQuery.Sha256Hash)
+		}
+
+		sentQuery := request.Query
+		request.Query, err = limits.PersistedQuery(request.Query, hash)
+		if err == nil && request.Query != "" {
+			err = limits.Check(request.Query, request.OperationName, request.Variables)
+		}
+		if err != nil {
+			status := http.StatusBadRequest
+			code := "BAD_REQUEST"
+			if limitErr, ok := err.(*graphqlLimitError); ok {
+				code = limitErr.code
+				if code == "PERSISTED_QUERY_NOT_FOUND" {
+					// clients retry with the query on this error
+					status = http.StatusOK
+				}
+			}
+			c.AbortWithStatusJSON(status, graphqlErrorResponse(err.Error(), code))
+			return
+		}
+
+		if sentQuery == "" && request.Query != "" {
+			// the graphql handler reads the query persisted with the hash
+			if c.Request.Method == http.MethodGet {
+				values := c.Request.URL.Query()
+				values.Set("query", request.Query)
+				c.Request.URL.RawQuery = values.Encode()
+			} else {
+				body, err := json.Marshal(graphqlHttpOperation{
+					Query:         request.Query,
+					Variables:     request.Variables,
+					OperationName: request.OperationName,
+				})
+				if err != nil {
+					c.AbortWithStatusJSON(http.StatusBadRequest, graphqlErrorResponse(err.Error(), "BAD_REQUEST"))
+					return
+				}
+				c.Request.URL.RawQuery = ""
+				c.Request.Header.Set("Content-Type", "application/json")
+				c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+				c.Request.ContentLength = int64(len(body))
+			}
+		}
+
+		next.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// graphqlErrorResponse is a graphql response with a single error
+func graphqlErrorResponse(message string, code string) map[string]interface{} {
+	return map[string]interface{}{
+		"errors": []interface{}{graphqlError(message, code)},
+	}
+}
+
+// graphqlError is an error with its code in the extensions
+func graphqlError(message string, code string) map[string]interface{} {
+	return map[string]interface{}{
+		"message": message,
+		"extensions": map[string]interface{}{
+			"code": code,
+		},
+	}
+}
+
+// PersistedQuery returns the query to run for a request with a query, a
+// persisted query hash or both. A query sent with its hash is kept to be sent
+// by the hash alone later. When there is an allow-list, only the hashes in it
+// are kept.
+func (limits *GraphqlLimits) PersistedQuery(query string, hash string) (string, error) {
+
+	if hash == "" {
+		return query, nil
+	}
+
+	if len(limits.PersistedQueries) > 0 && !limits.PersistedQueries[hash] {
+		return "", &graphqlLimitError{message: "query is not a persisted query", code: "PERSISTED_QUERY_NOT_ALLOWED"}
+	}
+
+	if query == "" {
+		limits.lock.Lock()
+		query, ok := limits.cache[hash]
+		limits.lock.Unlock()
+		if !ok {
+			return "", &graphqlLimitError{message: "PersistedQueryNotFound", code: "PERSISTED_QUERY_NOT_FOUND"}
+		}
+		return query, nil
+	}
+
+	if graphqlQueryHash(query) != hash {
+		return "", &graphqlLimitError{message: "provided sha does not match query", code: "INVALID_PERSISTED_QUERY_HASH"}
+	}
+
+	limits.lock.Lock()
+	defer limits.lock.Unlock()
+	if _, ok := limits.cache[hash]; !ok {
+		if len(limits.cacheHashes) >= graphqlPersistedQueryCacheSize {
+			delete(limits.cache, limits.cacheHashes[0])
+			limits.cacheHashes = limits.cacheHashes[1:]
+		}
+		limits.cache[hash] = query
+		limits.cacheHashes = append(limits.cacheHashes, hash)
+	}
+	return query, nil
+}
+
+func graphqlQueryHash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// Check rejects a query which is not in the persisted queries allow-list, and
+// the operations which are deeper or more complex than the limits, or which use
+// introspection when it is disabled. Invalid
+// queries are left to the validation of the schema.
+func (limits *GraphqlLimits) Check(query string, operationName string, variables map[string]interface{}) error {
+
+	if len(limits.PersistedQueries) > 0 && !limits.PersistedQueries[graphqlQueryHash(query)] {
+		return &graphqlLimitError{message: "query is not a persisted query", code: "PERSISTED_QUERY_NOT_ALLOWED"}
+	}
+
+	document, err := parser.Parse(parser.ParseParams{Source: query})
+	if err != nil {
+		// reported when the query is executed
+		return nil
+	}
+
+	fragments := make(map[string]*ast.FragmentDefinition)
+	for _, definition := range document.Definitions {
+		if fragment, ok := definition.(*ast.FragmentDefinition); ok && fragment.Name != nil {
+			fragments[fragment.Name.Value] = fragment
+		}
+	}
+
+	for _, definition := range document.Definitions {
+		operation, ok := definition.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if operationName != "" && (operation.Name == nil || operation.Name.Value != operationName) {
+			continue
+		}
+
+		var rootType *graphql.Object
+		switch operation.Operation {
+		case ast.OperationTypeMutation:
+			rootType = limits.schema.MutationType()
+		case ast.OperationTypeSubscription:
+			rootType = limits.schema.SubscriptionType()
+		default:
+			rootType = limits.schema.QueryType()
+		}
+		if rootType == nil {
+			continue
+		}
+
+		cost := &graphqlCost{
+			limits:    limits,
+			fragments: fragments,
+			variables: variables,
+			visiting:  make(map[string]bool),
+		}
+		complexity, depth, err := cost.selectionSet(operation.SelectionSet, rootType)
+		if err != nil {
+			return err
+		}
+		if depth > limits.MaxDepth {
+			return &graphqlLimitError{
+				message: fmt.Sprintf("query depth %d is over the limit of %d", depth, limits.MaxDepth),
+				code:    "QUERY_TOO_DEEP",
+			}
+		}
+		if complexity < 0 || complexity > limits.MaxComplexity {
+			return &graphqlLimitError{
+				message: fmt.Sprintf("query complexity %d is over the limit of %d", complexity, limits.MaxComplexity),
+				code:    "QUERY_TOO_COMPLEX",
+			}
+		}
+	}
+
+	return nil
+}
+
+// graphqlCost scores the selections of an operation. A field costs 1 unless
+// set otherwise, and the fields selected below a list count once for each row
+// it can have, which is first, last or page.size or else the default page size.
+// The score stops growing at graphqlMaxCost instead of overflowing.
+type graphqlCost struct {
+	limits    *GraphqlLimits
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+	visiting  map[string]bool
+}
+
+func (cost *graphqlCost) selectionSet(selectionSet *ast.SelectionSet, parentType graphql.Type) (int, int, error) {
+
+	if selectionSet == nil {
+		return 0, 0, nil
+	}
+
+	complexity, depth := 0, 0
+	for _, selection := range selectionSet.Selections {
+		var selectionComplexity, selectionDepth int
+		var err error
+
+		switch selection := selection.(type) {
+		case *ast.Field:
+			selectionComplexity, selectionDepth, err = cost.field(selection, parentType)
+		case *ast.InlineFragment:
+			fragmentType := parentType
+			if selection.TypeCondition != nil && selection.TypeCondition.Name != nil {
+				if namedType, ok := cost.limits.schema.TypeMap()[selection.TypeCondition.Name.Value]; ok {
+					fragmentType = namedType
+				}
+			}
+			selectionComplexity, selectionDepth, err = cost.selectionSet(selection.SelectionSet, fragmentType)
+		case *ast.FragmentSpread:
+			if selection.Name == nil {
+				continue
+			}
+			name := selection.Name.Value
+			fragment, ok := cost.fragments[name]
+			if !ok || cost.visiting[name] {
+				// unknown and cyclic fragments fail the validation
+				continue
+			}
+			fragmentType := parentType
+			if fragment.TypeCondition != nil && fragment.TypeCondition.Name != nil {
+				if namedType, ok := cost.limits.schema.TypeMap()[fragment.TypeCondition.Name.Value]; ok {
+					fragmentType = namedType
+				}
+			}
+			cost.visiting[name] = true
+			selectionComplexity, selectionDepth, err = cost.selectionSet(fragment.SelectionSet, fragmentType)
+			delete(cost.visiting, name)
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+
+		complexity = graphqlCostAdd(complexity, selectionComplexity)
+		if selectionDepth > depth {
+			depth = selectionDepth
+		}
+	}
+
+	return complexity, depth, nil
+}
+
+func (cost *graphqlCost) field(field *ast.Field, parentType graphql.Type) (int, int, error) {
+
+	if field.Name == nil {
+		return 0, 0, nil
+	}
+	name := field.Name.Value
+
+	if name == "__schema" || name == "__type" {
+		if !cost.limits.Introspection {
+			return 0, 0, &graphqlLimitError{message: "introspection is disabled", code: "INTROSPECTION_DISABLED"}
+		}
+	}
+
+	var fieldType graphql.Type
+	switch parent := parentType.(type) {
+	case *graphql.Object:
+		if definition, ok := parent.Fields()[name]; ok {
+			fieldType = definition.Type
+		}
+	case *graphql.Interface:
+		if definition, ok := parent.Fields()[name]; ok {
+			fieldType = definition.Type
+		}
+	}
+
+	fieldCost := 1
+	if configured, ok := cost.limits.FieldCosts[parentType.Name()+"."+name]; ok {
+		fieldCost = configured
+	} else if configured, ok := cost.limits.FieldCosts[name]; ok {
+		fieldCost = configured
+	}
+
+	if field.SelectionSet == nil || fieldType == nil {
+		return fieldCost, 1, nil
+	}
+
+	isList := false
+	for {
+		if nonNull, ok := fieldType.(*graphql.NonNull); ok {
+			fieldType = nonNull.OfType
+			continue
+		}
+		if list, ok := fieldType.(*graphql.List); ok {
+			isList = true
+			fieldType = list.OfType
+			continue
+		}
+		break
+	}
+
+	childComplexity, childDepth, err := cost.selectionSet(field.SelectionSet, fieldType)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	rows, paged := cost.pageSize(field)
+	if isList || paged {
+		childComplexity = graphqlCostMultiply(childComplexity, rows)
+	}
+
+	return graphqlCostAdd(fieldCost, childComplexity), childDepth + 1, nil
+}
+
+// graphqlMaxCost is the highest score of a query
+const graphqlMaxCost = math.MaxInt32
+
+func graphqlCostAdd(a int, b int) int {
+	if a > graphqlMaxCost-b {
+		return graphqlMaxCost
+	}
+	return a + b
+}
+
+func graphqlCostMultiply(a int, b int) int {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	if a > graphqlMaxCost/b {
+		return graphqlMaxCost
+	}
+	return a * b
+}
+
+// pageSize is the number of rows a list or a connection field asks for, at
+// most graphqlMaxPageSize
+func (cost *graphqlCost) pageSize(field *ast.Field) (int, bool) {
+
+	size, paged := 0, false
+	for _, argument := range field.Arguments {
+		if argument.Name == nil {
+			continue
+		}
+		switch argument.Name.Value {
+		case "first", "last":
+			if value, ok := cost.intValue(argument.Value); ok && value > size {
+				size, paged = value, true
+			}
+		case "page":
+			value := cost.value(argument.Value)
+			if page, ok := value.(map[string]interface{}); ok {
+				if pageSize, ok := graphqlIntOf(page["size"]); ok && pageSize > size {
+					size, paged = pageSize, true
+				}
+			}
+		}
+	}
+
+	if !paged {
+		return graphqlDefaultPageSize, false
+	}
+	if size > graphqlMaxPageSize {
+		size = graphqlMaxPageSize
+	}
+	return size, true
+}
+
+func (cost *graphqlCost) intValue(value ast.Value) (int, bool) {
+	return graphqlIntOf(cost.value(value))
+}
+
+// value is the value of an argument, with the variables in place
+func (cost *graphqlCost) value(value ast.Value) interface{} {
+	switch value := value.(type) {
+	case *ast.Variable:
+		if value.Name == nil {
+			return nil
+		}
+		return cost.variables[value.Name.Value]
+	case *ast.IntValue:
+		number, err := strconv.Atoi(value.Value)
+		if err != nil {
+			return nil
+		}
+		return number
+	case *ast.ObjectValue:
+		object := make(map[string]interface{})
+		for _, field := range value.Fields {
+			if field.Name != nil {
+				object[field.Name.Value] = cost.value(field.Value)
+			}
+		}
+		return object
+	}
+	return nil
+}
+
+// graphqlIntOf reads a number from an argument, numbers beyond graphqlMaxCost
+// are read as graphqlMaxCost
+func graphqlIntOf(value interface{}) (int, bool) {
+	switch value := value.(type) {
+	case int:
+		return graphqlIntOf(int64(value))
+	case int64:
+		if value > graphqlMaxCost {
+			return graphqlMaxCost, true
+		}
+		return int(value), true
+	case float64:
+		if value > graphqlMaxCost {
+			return graphqlMaxCost, true
+		}
+		return int(value), true
+	}
+	return 0, false
+}
@@ -0,0 +1,347 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// graphqlLimitsFixture is a schema of books and their authors, with the
+// limits of the test
+func graphqlLimitsFixture(t *testing.T, maxDepth int, maxComplexity int) *GraphqlLimits {
+
+	pageInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "PageInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"size":   &graphql.InputObjectFieldConfig{Type: graphql.Int},
+			"number": &graphql.InputObjectFieldConfig{Type: graphql.Int},
+		},
+	})
+	listArguments := graphql.FieldConfigArgument{
+		"first": &graphql.ArgumentConfig{Type: graphql.Int},
+		"page":  &graphql.ArgumentConfig{Type: pageInput},
+	}
+
+	author := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Author",
+		Fields: graphql.Fields{
+			"name": &graphql.Field{Type: graphql.String},
+		},
+	})
+	book := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Book",
+		Fields: graphql.Fields{
+			"title":  &graphql.Field{Type: graphql.String},
+			"author": &graphql.Field{Type: author},
+		},
+	})
+	author.AddFieldConfig("books", &graphql.Field{Type: graphql.NewList(book), Args: listArguments})
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"book":  &graphql.Field{Type: book},
+				"books": &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(book)), Args: listArguments},
+			},
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &GraphqlLimits{
+		MaxDepth:         maxDepth,
+		MaxComplexity:    maxComplexity,
+		FieldCosts:       make(map[string]int),
+		Introspection:    true,
+		PersistedQueries: make(map[string]bool),
+		schema:           &schema,
+		cache:            make(map[string]string),
+	}
+}
+
+// graphqlLimitCode is the code of the error, or "" when there is none
+func graphqlLimitCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	if limitErr, ok := err.(*graphqlLimitError); ok {
+		return limitErr.code
+	}
+	return err.Error()
+}
+
+func TestGraphqlLimitsCheck(t *testing.T) {
+
+	for _, test := range []struct {
+		name          string
+		query         string
+		operationName string
+		variables     map[string]interface{}
+		fieldCosts    map[string]int
+		introspection bool
+		code          string
+	}{
+		{name: "within the limits", query: `{ book { title author { name } } }`},
+		{name: "depth at the limit", query: `{ book { author { books(first: 1) { title } } } }`},
+		{name: "too deep", query: `{ book { author { books(first: 1) { author { name } } } } }`, code: "QUERY_TOO_DEEP"},
+		{name: "too deep through a fragment", query: `{ book { ...deep } } fragment deep on Book { author { books(first: 1) { author { name } } } }`, code: "QUERY_TOO_DEEP"},
+		{name: "too deep through an inline fragment", query: `{ book { ... on Book { author { books(first: 1) { author { name } } } } } }`, code: "QUERY_TOO_DEEP"},
+		{name: "cyclic fragments are left to the validation", query: `{ book { ...a } } fragment a on Book { ...a }`},
+		{name: "default page size", query: `{ books { title author { name } } }`},
+		{name: "first over the complexity", query: `{ books(first: 50) { title author { name } } }`, code: "QUERY_TOO_COMPLEX"},
+		{name: "page size over the complexity", query: `{ books(page: {size: 100}) { title } }`, code: "QUERY_TOO_COMPLEX"},
+		{name: "page size in a variable", query: `query q($size: Int) { books(page: {size: $size}) { title } }`,
+			variables: map[string]interface{}{"size": float64(500)}, code: "QUERY_TOO_COMPLEX"},
+		{name: "first in a variable", query: `query q($first: Int) { books(first: $first) { title } }`,
+			variables: map[string]interface{}{"first": float64(5)}},
+		{name: "field cost", query: `{ book { title } }`, fieldCosts: map[string]int{"Book.title": 100}, code: "QUERY_TOO_COMPLEX"},
+		{name: "field cost by name", query: `{ book { title } }`, fieldCosts: map[string]int{"title": 100}, code: "QUERY_TOO_COMPLEX"},
+		{name: "only the named operation", query: `query small { book { title } } query large { books(first: 100) { title } }`, operationName: "small"},
+		{name: "the named large operation", query: `query small { book { title } } query large { books(first: 100) { title } }`, operationName: "large", code: "QUERY_TOO_COMPLEX"},
+		{name: "introspection", query: `{ __schema { types { name } } }`, introspection: true},
+		{name: "introspection disabled", query: `{ __schema { types { name } } }`, code: "INTROSPECTION_DISABLED"},
+		{name: "type introspection disabled", query: `{ __type(name: "Book") { name } }`, code: "INTROSPECTION_DISABLED"},
+		{name: "invalid queries are left to the validation", query: `{ book {`},
+	} {
+		limits := graphqlLimitsFixture(t, 4, 60)
+		limits.Introspection = test.introspection
+		if test.fieldCosts != nil {
+			limits.FieldCosts = test.fieldCosts
+		}
+
+		err := limits.Check(test.query, test.operationName, test.variables)
+		if code := graphqlLimitCode(err); code != test.code {
+			t.Errorf("%v: expected [%v], got [%v]: %v", test.name, test.code, code, err)
+		}
+	}
+}
+
+func TestGraphqlCostOverflow(t *testing.T) {
+
+	limits := graphqlLimitsFixture(t, 10, 5000)
+	for _, query := range []string{
+		`{ books(first: 2147483647) { author { books(first: 2147483647) { author { books(first: 2147483647) { title } } } } } }`,
+		`query q($size: Int) { books(page: {size: $size}) { author { books(page: {size: $size}) { author { books(page: {size: $size}) { title } } } } } }`,
+	} {
+		err := limits.Check(query, "", map[string]interface{}{"size": float64(9223372036854775807)})
+		if code := graphqlLimitCode(err); code != "QUERY_TOO_COMPLEX" {
+			t.Errorf("Expected the nested lists to be too complex, got [%v]: %v", code, err)
+		}
+	}
+
+	if graphqlCostMultiply(graphqlMaxCost, 2) != graphqlMaxCost || graphqlCostAdd(graphqlMaxCost, 1) != graphqlMaxCost {
+		t.Errorf("Expected the cost to stop at the most")
+	}
+	if graphqlCostMultiply(3, 4) != 12 || graphqlCostAdd(3, 4) != 7 {
+		t.Errorf("Unexpected cost")
+	}
+}
+
+func TestGraphqlPersistedQuery(t *testing.T) {
+
+	query := `{ book { title } }`
+	hash := graphqlQueryHash(query)
+	otherQuery := `{ book { author { name } } }`
+
+	limits := graphqlLimitsFixture(t, 10, 1000)
+	for _, test := range []struct {
+		name  string
+		query string
+		hash  string
+		want  string
+		code  string
+	}{
+		{name: "no hash", query: otherQuery, want: otherQuery},
+		{name: "unknown hash", hash: hash, code: "PERSISTED_QUERY_NOT_FOUND"},
+		{name: "wrong hash", query: otherQuery, hash: hash, code: "INVALID_PERSISTED_QUERY_HASH"},
+		{name: "registered with the hash", query: query, hash: hash, want: query},
+		{name: "sent by the hash alone", hash: hash, want: query},
+	} {
+		got, err := limits.PersistedQuery(test.query, test.hash)
+		if code := graphqlLimitCode(err); code != test.code || got != test.want {
+			t.Errorf("%v: expected [%v] [%v], got [%v] [%v]", test.name, test.want, test.code, got, code)
+		}
+	}
+
+	// only the queries in the allow-list are run, with or without a hash
+	limits = graphqlLimitsFixture(t, 10, 1000)
+	limits.PersistedQueries[hash] = true
+	if _, err := limits.PersistedQuery(otherQuery, graphqlQueryHash(otherQuery)); graphqlLimitCode(err) != "PERSISTED_QUERY_NOT_ALLOWED" {
+		t.Errorf("Expected a hash not in the allow-list to be rejected, got %v", err)
+	}
+	if err := limits.Check(otherQuery, "", nil); graphqlLimitCode(err) != "PERSISTED_QUERY_NOT_ALLOWED" {
+		t.Errorf("Expected a query not in the allow-list to be rejected, got %v", err)
+	}
+	if err := limits.Check(query, "", nil); err != nil {
+		t.Errorf("Expected a query in the allow-list to be allowed, got %v", err)
+	}
+	if got, err := limits.PersistedQuery(query, hash); err != nil || got != query {
+		t.Errorf("Expected a hash in the allow-list to be registered, got %v", err)
+	}
+
+	// the oldest queries are dropped from the cache
+	limits = graphqlLimitsFixture(t, 10, 1000)
+	first := `{ book { title } } # 0`
+	for i := 0; i <= graphqlPersistedQueryCacheSize; i++ {
+		cached := first
+		if i > 0 {
+			cached = strings.Replace(first, "# 0", "# "+strings.Repeat("x", i), 1)
+		}
+		if _, err := limits.PersistedQuery(cached, graphqlQueryHash(cached)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := limits.PersistedQuery("", graphqlQueryHash(first)); graphqlLimitCode(err) != "PERSISTED_QUERY_NOT_FOUND" {
+		t.Errorf("Expected the oldest query to be dropped from the cache, got %v", err)
+	}
+	if len(limits.cache) != graphqlPersistedQueryCacheSize {
+		t.Errorf("Expected %d queries in the cache, got %d", graphqlPersistedQueryCacheSize, len(limits.cache))
+	}
+}
+
+func TestReadGraphqlHttpRequest(t *testing.T) {
+
+	hash := graphqlQueryHash(`{ book { title } }`)
+	extensions := `{"persistedQuery": {"version": 1, "sha256Hash": "` + hash + `"}}`
+	getUrl := "/graphql?" + url.Values{
+		"query":         {`query q($id: ID) { book { title } }`},
+		"operationName": {"q"},
+		"variables":     {`{"id": "1"}`},
+		"extensions":    {extensions},
+	}.Encode()
+
+	for _, test := range []struct {
+		name          string
+		method        string
+		url           string
+		contentType   string
+		body          string
+		query         string
+		operationName string
+		variables     int
+		hash          string
+		fails         bool
+	}{
+		{name: "get", method: "GET", url: getUrl,
+			query: `query q($id: ID) { book { title } }`, operationName: "q", variables: 1, hash: hash},
+		{name: "get ignores the body", method: "GET", url: "/graphql?query=%7Bbook%7Btitle%7D%7D", contentType: "application/json",
+			body: `{"query": "{ books { title } }"}`, query: `{book{title}}`},
+		{name: "get with the hash alone", method: "GET", url: "/graphql?" + url.Values{"extensions": {extensions}}.Encode(), hash: hash},
+		{name: "get with invalid variables", method: "GET", url: "/graphql?query=x&variables=%7B", fails: true},
+		{name: "get with invalid extensions", method: "GET", url: "/graphql?extensions=%7B", fails: true},
+		{name: "post json", method: "POST", url: "/graphql", contentType: "application/json; charset=utf-8",
+			body:  `{"query": "{ book { title } }", "operationName": "q", "variables": {"a": 1, "b": 2}, "extensions": ` + extensions + `}`,
+			query: `{ book { title } }`, operationName: "q", variables: 2, hash: hash},
+		{name: "post without a content type", method: "POST", url: "/graphql",
+			body: `{"query": "{ book { title } }"}`, query: `{ book { title } }`},
+		{name: "post graphql", method: "POST", url: "/graphql", contentType: "application/graphql",
+			body: `{ book { title } }`, query: `{ book { title } }`},
+		{name: "post form", method: "POST", url: "/graphql", contentType: "application/x-www-form-urlencoded",
+			body: url.Values{"query": {`{ book { title } }`}, "variables": {`{"a": 1}`}}.Encode(), query: `{ book { title } }`, variables: 1},
+		{name: "post with the query in the url", method: "POST", url: "/graphql?query=%7Bbook%7Btitle%7D%7D", contentType: "application/json",
+			body: `{"query": "{ books { title } }"}`, query: `{book{title}}`},
+		{name: "post empty", method: "POST", url: "/graphql", contentType: "application/json"},
+		{name: "post invalid json", method: "POST", url: "/graphql", contentType: "application/json", body: `{"query": `, fails: true},
+	} {
+		request := httptest.NewRequest(test.method, test.url, strings.NewReader(test.body))
+		if test.contentType != "" {
+			request.Header.Set("Content-Type", test.contentType)
+		}
+
+		graphqlRequest, err := readGraphqlHttpRequest(request)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%v: expected the request to fail", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: failed to read the request: %v", test.name, err)
+			continue
+		}
+
+		hash := ""
+		if graphqlRequest.Extensions.PersistedQuery != nil {
+			hash = graphqlRequest.Extensions.PersistedQuery.Sha256Hash
+		}
+		if graphqlRequest.Query != test.query || graphqlRequest.OperationName != test.operationName ||
+			len(graphqlRequest.Variables) != test.variables || hash != test.hash {
+			t.Errorf("%v: got query [%v] operation [%v] variables %v hash [%v]", test.name,
+				graphqlRequest.Query, graphqlRequest.OperationName, graphqlRequest.Variables, hash)
+		}
+
+		// the body is left for the graphql handler
+		if test.method == "POST" && test.contentType != "application/x-www-form-urlencoded" {
+			rest, _ := ioutil.ReadAll(request.Body)
+			if string(rest) != test.body {
+				t.Errorf("%v: expected the body to be put back, got [%v]", test.name, string(rest))
+			}
+		}
+	}
+}
+
+func TestGraphqlLimitsHandler(t *testing.T) {
+
+	query := `{ book { title } }`
+	hash := graphqlQueryHash(query)
+	extensions := `{"persistedQuery": {"version": 1, "sha256Hash": "` + hash + `"}}`
+
+	limits := graphqlLimitsFixture(t, 2, 1000)
+	var received *graphqlHttpRequest
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Any("/graphql", limits.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		received, err = readGraphqlHttpRequest(r)
+		if err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(200)
+	})))
+
+	for _, test := range []struct {
+		name   string
+		method string
+		url    string
+		body   string
+		status int
+		code   string
+		query  string
+	}{
+		{name: "hash before the query is known", method: "GET", url: "/graphql?" + url.Values{"extensions": {extensions}}.Encode(),
+			status: 200, code: "PERSISTED_QUERY_NOT_FOUND"},
+		{name: "query with its hash", method: "POST", url: "/graphql", body: `{"query": "` + query + `", "extensions": ` + extensions + `}`,
+			status: 200, query: query},
+		{name: "get by the hash", method: "GET", url: "/graphql?" + url.Values{"extensions": {extensions}}.Encode(),
+			status: 200, query: query},
+		{name: "post by the hash", method: "POST", url: "/graphql", body: `{"extensions": ` + extensions + `}`,
+			status: 200, query: query},
+		{name: "too deep", method: "POST", url: "/graphql", body: `{"query": "{ book { author { name } } }"}`,
+			status: 400, code: "QUERY_TOO_DEEP"},
+		{name: "invalid request", method: "POST", url: "/graphql", body: `{"query": `,
+			status: 400, code: "BAD_REQUEST"},
+	} {
+		received = nil
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(test.method, test.url, strings.NewReader(test.body))
+		request.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(recorder, request)
+
+		if recorder.Code != test.status || (test.code != "" && !strings.Contains(recorder.Body.String(), test.code)) {
+			t.Errorf("%v: expected %v [%v], got %v: %v", test.name, test.status, test.code, recorder.Code, recorder.Body.String())
+		}
+		if test.code != "" && received != nil {
+			t.Errorf("%v: expected the request not to reach the graphql handler", test.name)
+		}
+		if test.code == "" && (received == nil || received.Query != test.query) {
+			t.Errorf("%v: expected the graphql handler to get the query [%v], got %+v", test.name, test.query, received)
+		}
+	}
+}
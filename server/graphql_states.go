@@ -0,0 +1,203 @@
+package server
+
+import (
+	"errors"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/database"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/graphql-go/graphql"
+	"github.com/iancoleman/strcase"
+	"net/http"
+	"strings"
+)
+
+// graphqlObjectStateType is a state machine an object is in, with the events
+// which can be fired at its current state
+var graphqlObjectStateType = graphql.NewObject(graphql.ObjectConfig{
+	Name:        "ObjectState",
+	Description: "A state machine an object is in",
+	Fields: graphql.Fields{
+		"reference_id": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.String),
+			Description: "id of the state of the object, to fire events on it",
+		},
+		"state_machine": &graphql.Field{
+			Type:        graphql.String,
+			Description: "reference id of the state machine",
+		},
+		"name": &graphql.Field{
+			Type:        graphql.String,
+			Description: "name of the state machine",
+		},
+		"label": &graphql.Field{
+			Type:        graphql.String,
+			Description: "label of the state machine",
+		},
+		"current_state": &graphql.Field{
+			Type:        graphql.String,
+			Description: "state the object is in",
+		},
+		"allowed_events": &graphql.Field{
+			Type:        graphql.NewList(graphql.String),
+			Description: "events which can be fired at the current state",
+		},
+	},
+})
+
+// graphqlObjectStates finds the states of the objects of a table matching the
+// filters, which the user can read
+func graphqlObjectStates(resources map[string]*resource.DbResource, params graphql.ResolveParams, typeName string, filters []resource.Query) ([]map[string]interface{}, error) {
+
+	req, err := graphqlListRequest(map[string]interface{}{}, params.Context, filters)
+	if err != nil {
+		return nil, err
+	}
+	req.QueryParams["page[size]"] = []string{"100"}
+
+	_, responder, err := resources[typeName+"_state"].PaginatedFindAll(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// the events of each state machine are read once
+	stateMachines := make(map[string]map[string]interface{})
+	states := make([]map[string]interface{}, 0)
+	for _, item := range graphqlListItems(responder) {
+
+		stateMachineId, _ := item[typeName+"_smd"].(string)
+		currentState, _ := item["current_state"].(string)
+		state := map[string]interface{}{
+			"reference_id":   item["reference_id"],
+			"state_machine":  stateMachineId,
+			"current_state":  currentState,
+			"allowed_events": []string{},
+		}
+
+		stateMachine, ok := stateMachines[stateMachineId]
+		if !ok && stateMachineId != "" {
+			stateMachine, _, err = resources["smd"].GetSingleRowByReferenceId("smd", stateMachineId)
+			resource.CheckErr(err, "Failed to get state machine [%v]", stateMachineId)
+			stateMachines[stateMachineId] = stateMachine
+		}
+		if stateMachine != nil {
+			state["name"] = stateMachine["name"]
+			state["label"] = stateMachine["label"]
+			events, err := resource.StateMachineEvents(stateMachine["events"])
+			resource.CheckErr(err, "Failed to read events of state machine [%v]", stateMachineId)
+			state["allowed_events"] = resource.AllowedStateEvents(currentState, events)
+		}
+
+		states = append(states, state)
+	}
+
+	return states, nil
+}
+
+// graphqlObjectStatesField lists the states of an object of a table which
+// tracks states
+func graphqlObjectStatesField(resources map[string]*resource.DbResource, typeName string) *graphql.Field {
+	return &graphql.Field{
+		Type:        graphql.NewList(graphqlObjectStateType),
+		Description: "State machines the " + strings.ReplaceAll(typeName, "_", " ") + " is in",
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+
+			source, _ := params.Source.(map[string]interface{})
+			referenceId, ok := source["reference_id"].(string)
+			if !ok {
+				return nil, nil
+			}
+
+			return graphqlObjectStates(resources, params, typeName, []resource.Query{
+				{ColumnName: "is_state_of_" + typeName + ".reference_id", Operator: "is", Value: referenceId},
+			})
+		},
+	}
+}
+
+// addGraphqlStateMutations adds the mutations to start a state machine on an
+// object of the table and to fire events on it, like /track/start and
+// /track/event
+func addGraphqlStateMutations(mutationFields graphql.Fields, resources map[string]*resource.DbResource, fsmManager resource.FsmManager, db database.DatabaseConnection, typeName string) {
+
+	// the state the mutation changed, as the user sees it
+	changedState := func(params graphql.ResolveParams, stateId string) (interface{}, error) {
+		states, err := graphqlObjectStates(resources, params, typeName, []resource.Query{
+			{ColumnName: "reference_id", Operator: "is", Value: stateId},
+		})
+		if err != nil || len(states) == 0 {
+			return nil, err
+		}
+		return states[0], nil
+	}
+
+	mutationFields["start"+strcase.ToCamel(typeName)+"StateMachine"] = &graphql.Field{
+		Type:        graphqlObjectStateType,
+		Description: "Start a state machine on a " + strings.ReplaceAll(typeName, "_", " "),
+		Args: graphql.FieldConfigArgument{
+			"reference_id": &graphql.ArgumentConfig{
+				Type:        graphql.NewNonNull(graphql.String),
+				Description: "reference id of the " + strings.ReplaceAll(typeName, "_", " "),
+			},
+			"state_machine_id": &graphql.ArgumentConfig{
+				Type:        graphql.NewNonNull(graphql.String),
+				Description: "reference id of the state machine",
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+
+			pr := &http.Request{
+				Method: "GET",
+			}
+			pr = pr.WithContext(params.Context)
+			req := api2go.Request{
+				PlainRequest: pr,
+				QueryParams:  map[string][]string{},
+			}
+
+			created, _, err := startObjectStateMachine(resources, params.Args["state_machine_id"].(string), typeName, params.Args["reference_id"].(string), req)
+			if err != nil {
+				return nil, err
+			}
+
+			model, ok := created.Result().(*api2go.Api2GoModel)
+			if !ok {
+				return nil, errors.New("failed to start the state machine")
+			}
+			return changedState(params, model.GetID())
+		},
+	}
+
+	mutationFields["fire"+strcase.ToCamel(typeName)+"StateEvent"] = &graphql.Field{
+		Type:        graphqlObjectStateType,
+		Description: "Fire an event on the state of a " + strings.ReplaceAll(typeName, "_", " "),
+		Args: graphql.FieldConfigArgument{
+			"state_id": &graphql.ArgumentConfig{
+				Type:        graphql.NewNonNull(graphql.String),
+				Description: "reference id of the state",
+			},
+			"event": &graphql.ArgumentConfig{
+				Type:        graphql.NewNonNull(graphql.String),
+				Description: "name of the event",
+			},
+		},
+		Resolve: func(params graphql.ResolveParams) (interface{}, error) {
+
+			pr := &http.Request{
+				Method: "GET",
+			}
+			pr = pr.WithContext(params.Context)
+			req := api2go.Request{
+				PlainRequest: pr,
+				QueryParams:  map[string][]string{},
+			}
+
+			stateId := params.Args["state_id"].(string)
+			_, _, err := applyObjectStateEvent(fsmManager, resources, db, typeName, stateId, params.Args["event"].(string), req)
+			if err != nil {
+				return nil, err
+			}
+
+			return changedState(params, stateId)
+		},
+	}
+}
@@ -0,0 +1,410 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	"github.com/iancoleman/strcase"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// graphqlWsProtocol is the protocol of subscriptions-transport-ws
+	graphqlWsProtocol = "graphql-ws"
+	// graphqlTransportWsProtocol is the protocol of graphql-ws
+	graphqlTransportWsProtocol = "graphql-transport-ws"
+)
+
+// graphqlWsKeepAlive is how often a graphql-ws connection is kept alive
+const graphqlWsKeepAlive = 30 * time.Second
+
+// graphqlSubscriptionFieldName is the subscription to a change of a table, like onCreatedUserAccount
+func graphqlSubscriptionFieldName(eventType string, tableName string) string {
+	return "on" + strcase.ToCamel(eventType) + strcase.ToCamel(tableName)
+}
+
+// graphqlSubscriptionRegistration is filled in by the subscription field when
+// a subscription is started, with the change subscribed to
+type graphqlSubscriptionRegistration struct {
+	fieldName string
+	typeName  string
+	eventType string
+	args      map[string]interface{}
+	fields    int
+}
+
+type graphqlSubscriptionRegistrationKey struct{}
+
+// resolveGraphqlSubscriptionField registers the subscription when it is
+// started, and resolves to the changed row when a change is sent
+func resolveGraphqlSubscriptionField(typeName string, eventType string) graphql.FieldResolveFn {
+	return func(params graphql.ResolveParams) (interface{}, error) {
+
+		registration, ok := params.Context.Value(graphqlSubscriptionRegistrationKey{}).(*graphqlSubscriptionRegistration)
+		if ok {
+			registration.fields++
+			registration.fieldName = params.Info.FieldName
+			registration.typeName = typeName
+			registration.eventType = eventType
+			registration.args = params.Args
+			return nil, nil
+		}
+
+		event, _ := params.Source.(map[string]interface{})
+		return event[params.Info.FieldName], nil
+	}
+}
+
+type graphqlWsMessage struct {
+	Id      string      `json:"id,omitempty"`
+	Type    string      `json:"type"`
+	Payload interface{} `json:"payload,omitempty"`
+}
+
+// CreateGraphqlSubscriptionHandler serves graphql over a websocket, with the
+// graphql-ws or the graphql-transport-ws protocol. Subscriptions get the rows
+// changed which match their arguments and which the user can read. The
+// operations are checked against the limits as the ones sent over http.
+func CreateGraphqlSubscriptionHandler(schema *graphql.Schema, limits *GraphqlLimits, eventBroker *resource.EntityEventBroker, authMiddleware *auth.AuthMiddleware) func(*gin.Context) {
+
+	server := websocket.Server{
+		Handshake: func(config *websocket.Config, req *http.Request) error {
+			var err error
+			config.Origin, err = websocket.Origin(config, req)
+			if err == nil && config.Origin == nil {
+				return fmt.Errorf("null origin")
+			}
+			if err != nil {
+				return err
+			}
+
+			for _, protocol := range config.Protocol {
+				if protocol == graphqlWsProtocol || protocol == graphqlTransportWsProtocol {
+					config.Protocol = []string{protocol}
+					return nil
+				}
+			}
+			return fmt.Errorf("expected the %v or %v protocol", graphqlWsProtocol, graphqlTransportWsProtocol)
+		},
+		Handler: func(ws *websocket.Conn) {
+			user := graphqlWsRequestUser(ws.Request())
+			connection := &graphqlWsConnection{
+				ws:             ws,
+				protocol:       ws.Config().Protocol[0],
+				schema:         schema,
+				limits:         limits,
+				eventBroker:    eventBroker,
+				authMiddleware: authMiddleware,
+				user:           user,
+				subscriptions:  make(map[string]*resource.EntitySubscription),
+			}
+			connection.serve()
+		},
+	}
+
+	return func(c *gin.Context) {
+		server.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// graphqlWsRequestUser is the user of the websocket request when it was
+// authenticated with a token in the Authorization header or in the url. The
+// token cookie is not used, a browser sends it with a websocket opened by any
+// other site, which could then subscribe as the user.
+func graphqlWsRequestUser(req *http.Request) *auth.SessionUser {
+	if req.Header.Get("Authorization") == "" && req.URL.Query().Get("token") == "" {
+		return nil
+	}
+	user, _ := req.Context().Value("user").(*auth.SessionUser)
+	return user
+}
+
+type graphqlWsConnection struct {
+	ws             *websocket.Conn
+	protocol       string
+	schema         *graphql.Schema
+	limits         *GraphqlLimits
+	eventBroker    *resource.EntityEventBroker
+	authMiddleware *auth.AuthMiddleware
+	user           *auth.SessionUser
+	writeLock      sync.Mutex
+	lock           sync.Mutex
+	subscriptions  map[string]*resource.EntitySubscription
+}
+
+// serve reads the messages of the client until the connection is closed
+func (c *graphqlWsConnection) serve() {
+
+	done := make(chan bool)
+	defer func() {
+		close(done)
+		c.stopAll()
+		err := c.ws.Close()
+		resource.CheckErr(err, "Failed to close graphql websocket")
+	}()
+
+	initialised := false
+	for {
+		var text string
+		err := websocket.Message.Receive(c.ws, &text)
+		if err != nil {
+			if err != io.EOF {
+				log.Errorf("Failed to read graphql websocket message: %v", err)
+			}
+			return
+		}
+
+		var message graphqlWsMessage
+		err = json.Unmarshal([]byte(text), &message)
+		if err != nil {
+			log.Errorf("Invalid graphql websocket message: %v", err)
+			return
+		}
+
+		switch message.Type {
+		case "connection_init":
+			if initialised {
+				return
+			}
+			err = c.authenticate(message.Payload)
+			if err != nil {
+				c.send(graphqlWsMessage{Type: "connection_error", Payload: map[string]interface{}{"message": err.Error()}})
+				return
+			}
+			initialised = true
+			c.send(graphqlWsMessage{Type: "connection_ack"})
+			if c.protocol == graphqlWsProtocol {
+				go c.keepAlive(done)
+			}
+		case "start", "subscribe":
+			if !initialised {
+				return
+			}
+			c.start(message.Id, message.Payload)
+		case "stop", "complete":
+			c.stop(message.Id)
+		case "ping":
+			c.send(graphqlWsMessage{Type: "pong"})
+		case "pong":
+		case "connection_terminate":
+			return
+		default:
+			log.Errorf("Unknown graphql websocket message type: %v", message.Type)
+		}
+	}
+}
+
+func (c *graphqlWsConnection) send(message graphqlWsMessage) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	text, err := json.Marshal(message)
+	if err != nil {
+		log.Errorf("Failed to marshal graphql websocket message: %v", err)
+		return
+	}
+	err = websocket.Message.Send(c.ws, string(text))
+	resource.CheckErr(err, "Failed to send graphql websocket message")
+}
+
+func (c *graphqlWsConnection) sendResult(id string, result *graphql.Result) {
+	if c.protocol == graphqlTransportWsProtocol {
+		c.send(graphqlWsMessage{Id: id, Type: "next", Payload: result})
+		return
+	}
+	c.send(graphqlWsMessage{Id: id, Type: "data", Payload: result})
+}
+
+func (c *graphqlWsConnection) sendError(id string, errs []interface{}) {
+	if c.protocol == graphqlTransportWsProtocol {
+		c.send(graphqlWsMessage{Id: id, Type: "error", Payload: errs})
+		return
+	}
+	c.send(graphqlWsMessage{Id: id, Type: "error", Payload: errs[0]})
+}
+
+func (c *graphqlWsConnection) keepAlive(done chan bool) {
+	c.send(graphqlWsMessage{Type: "ka"})
+	ticker := time.NewTicker(graphqlWsKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			c.send(graphqlWsMessage{Type: "ka"})
+		}
+	}
+}
+
+// authenticate the user with the token in the connection_init payload, the
+// user of a token sent with the websocket request is used when there is none
+func (c *graphqlWsConnection) authenticate(payload interface{}) error {
+
+	params, _ := payload.(map[string]interface{})
+	token := ""
+	for _, key := range []string{"Authorization", "authorization", "token"} {
+		if value, ok := params[key].(string); ok && value != "" {
+			token = value
+			break
+		}
+	}
+	if token == "" {
+		return nil
+	}
+	if !strings.HasPrefix(strings.ToLower(token), "bearer ") {
+		token = "Bearer " + token
+	}
+
+	req, err := http.NewRequest("GET", "/graphql", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", token)
+	_, abort, req := c.authMiddleware.AuthCheckMiddlewareWithHttp(req, nil, false)
+	user, ok := req.Context().Value("user").(*auth.SessionUser)
+	if abort || !ok {
+		return errors.New("invalid token")
+	}
+	c.user = user
+	return nil
+}
+
+// context is the context of the requests made for the user of the connection
+func (c *graphqlWsConnection) context() context.Context {
+	ctx := context.Background()
+	if c.user != nil {
+		ctx = context.WithValue(ctx, "user", c.user)
+	}
+	return ctx
+}
+
+// start an operation, subscriptions send the changes until they are stopped,
+// queries and mutations are answered once
+func (c *graphqlWsConnection) start(id string, payload interface{}) {
+
+	operation, _ := payload.(map[string]interface{})
+	query, _ := operation["query"].(string)
+	variables, _ := operation["variables"].(map[string]interface{})
+	operationName, _ := operation["operationName"].(string)
+
+	c.lock.Lock()
+	_, exists := c.subscriptions[id]
+	c.lock.Unlock()
+	if exists {
+		c.sendError(id, []interface{}{map[string]interface{}{"message": fmt.Sprintf("subscription %v is already started", id)}})
+		return
+	}
+
+	if c.limits != nil {
+		if err := c.limits.Check(query, operationName, variables); err != nil {
+			code := "BAD_REQUEST"
+			if limitErr, ok := err.(*graphqlLimitError); ok {
+				code = limitErr.code
+			}
+			c.sendError(id, []interface{}{graphqlError(err.Error(), code)})
+			return
+		}
+	}
+
+	registration := &graphqlSubscriptionRegistration{}
+	result := graphql.Do(graphql.Params{
+		Schema:         *c.schema,
+		RequestString:  query,
+		VariableValues: variables,
+		OperationName:  operationName,
+		Context:        context.WithValue(c.context(), graphqlSubscriptionRegistrationKey{}, registration),
+	})
+	if result.HasErrors() {
+		errs := make([]interface{}, 0)
+		for _, err := range result.Errors {
+			errs = append(errs, err)
+		}
+		c.sendError(id, errs)
+		return
+	}
+	if registration.fields == 0 {
+		c.sendResult(id, result)
+		c.send(graphqlWsMessage{Id: id, Type: "complete"})
+		return
+	}
+	if registration.fields > 1 {
+		c.sendError(id, []interface{}{map[string]interface{}{"message": "a subscription selects one field"}})
+		return
+	}
+
+	subscription := c.eventBroker.Subscribe(registration.typeName, registration.eventType, c.matcher(registration))
+	c.lock.Lock()
+	c.subscriptions[id] = subscription
+	c.lock.Unlock()
+
+	go func() {
+		for object := range subscription.Events {
+			c.sendResult(id, graphql.Do(graphql.Params{
+				Schema:         *c.schema,
+				RequestString:  query,
+				VariableValues: variables,
+				OperationName:  operationName,
+				RootObject:     map[string]interface{}{registration.fieldName: object},
+				Context:        c.context(),
+			}))
+		}
+	}()
+}
+
+// matcher finds the changed row with the arguments of the subscription, as the
+// user of the connection, the same as a list query
+func (c *graphqlWsConnection) matcher(registration *graphqlSubscriptionRegistration) resource.EntitySubscriptionMatcher {
+	return func(dr *resource.DbResource, referenceId string) (map[string]interface{}, bool) {
+
+		req, err := graphqlListRequest(registration.args, c.context(), []resource.Query{
+			{ColumnName: "reference_id", Operator: "is", Value: referenceId},
+		})
+		if err != nil {
+			return nil, false
+		}
+
+		count, responder, err := dr.PaginatedFindAll(req)
+		if err != nil || count == 0 {
+			return nil, false
+		}
+
+		items := graphqlListItems(responder)
+		if len(items) == 0 {
+			return nil, false
+		}
+		return items[0], true
+	}
+}
+
+func (c *graphqlWsConnection) stop(id string) {
+	c.lock.Lock()
+	subscription, ok := c.subscriptions[id]
+	delete(c.subscriptions, id)
+	c.lock.Unlock()
+
+	if ok {
+		subscription.Unsubscribe()
+	}
+}
+
+func (c *graphqlWsConnection) stopAll() {
+	c.lock.Lock()
+	subscriptions := c.subscriptions
+	c.subscriptions = make(map[string]*resource.EntitySubscription)
+	c.lock.Unlock()
+
+	for _, subscription := range subscriptions {
+		subscription.Unsubscribe()
+	}
+}
@@ -1,6 +1,8 @@
 package server
 
 import (
+	"errors"
+	"fmt"
 	"github.com/Masterminds/squirrel"
 	"github.com/artpar/api2go"
 	"github.com/daptin/daptin/server/auth"
@@ -17,104 +19,121 @@ func CreateEventHandler(initConfig *resource.CmsConfig, fsmManager resource.FsmM
 
 	return func(gincontext *gin.Context) {
 
-		sessionUser := gincontext.Request.Context().Value("user").(*auth.SessionUser)
-
-		pr := &http.Request{}
-		pr.Method = "GET"
 		req := api2go.Request{
 			PlainRequest: gincontext.Request,
 			QueryParams:  map[string][]string{},
 		}
 
-		objectStateMachineId := gincontext.Param("objectStateId")
-		typename := gincontext.Param("typename")
-
-		objectStateMachineResponse, err := cruds[typename+"_state"].FindOne(objectStateMachineId, req)
+		_, status, err := applyObjectStateEvent(fsmManager, cruds, db, gincontext.Param("typename"),
+			gincontext.Param("objectStateId"), gincontext.Param("eventName"), req)
+		if status == 403 {
+			gincontext.AbortWithStatus(403)
+			return
+		}
 		if err != nil {
-			log.Errorf("Failed to get object state machine: %v", err)
-			gincontext.AbortWithError(400, err)
+			gincontext.AbortWithError(status, err)
 			return
 		}
 
-		objectStateMachine := objectStateMachineResponse.Result().(*api2go.Api2GoModel)
+		gincontext.AbortWithStatus(200)
 
-		stateObject := objectStateMachine.Data
+	}
 
-		var subjectInstanceModel *api2go.Api2GoModel
-		//var stateMachineDescriptionInstance *api2go.Api2GoModel
+}
 
-		for _, included := range objectStateMachine.Includes {
-			casted := included.(*api2go.Api2GoModel)
-			if casted.GetTableName() == typename {
-				subjectInstanceModel = casted
-			}
+// applyObjectStateEvent fires the event on the state of an object, records the
+// change in the audit of the state, and returns the next state. The status is
+// the http status of the failure.
+func applyObjectStateEvent(fsmManager resource.FsmManager, cruds map[string]*resource.DbResource, db database.DatabaseConnection,
+	typename string, objectStateMachineId string, eventName string, req api2go.Request) (string, int, error) {
 
-		}
+	sessionUser, ok := req.PlainRequest.Context().Value("user").(*auth.SessionUser)
+	if !ok {
+		return "", 403, errors.New("unauthorized")
+	}
 
-		stateMachineId := objectStateMachine.GetID()
-		eventName := gincontext.Param("eventName")
+	stateCrud, ok := cruds[typename+"_state"]
+	if !ok {
+		return "", 400, fmt.Errorf("[%v] does not track states", typename)
+	}
 
-		stateMachinePermission := cruds["smd"].GetRowPermission(objectStateMachine.GetAllAsAttributes())
+	objectStateMachineResponse, err := stateCrud.FindOne(objectStateMachineId, req)
+	if err != nil {
+		log.Errorf("Failed to get object state machine: %v", err)
+		return "", 400, err
+	}
 
-		if !stateMachinePermission.CanExecute(sessionUser.UserReferenceId, sessionUser.Groups) {
-			gincontext.AbortWithStatus(403)
-			return
-		}
+	objectStateMachine := objectStateMachineResponse.Result().(*api2go.Api2GoModel)
 
-		nextState, err := fsmManager.ApplyEvent(subjectInstanceModel.GetAllAsAttributes(), resource.NewStateMachineEvent(stateMachineId, eventName))
-		if err != nil {
-			gincontext.AbortWithError(400, err)
-			return
+	stateObject := objectStateMachine.Data
+
+	var subjectInstanceModel *api2go.Api2GoModel
+	//var stateMachineDescriptionInstance *api2go.Api2GoModel
+
+	for _, included := range objectStateMachine.Includes {
+		casted := included.(*api2go.Api2GoModel)
+		if casted.GetTableName() == typename {
+			subjectInstanceModel = casted
 		}
 
-		stateAudit := objectStateMachine.GetAuditModel()
-		creator, ok := cruds[stateAudit.GetTableName()]
-		if ok {
+	}
+	if subjectInstanceModel == nil {
+		return "", 400, fmt.Errorf("state [%v] is not the state of a [%v]", objectStateMachineId, typename)
+	}
 
-			newRequest := &http.Request{
-				Method: "POST",
-			}
-			newRequest = newRequest.WithContext(gincontext.Request.Context())
+	stateMachineId := objectStateMachine.GetID()
 
-			req := api2go.Request{
-				PlainRequest: newRequest,
-				QueryParams:  map[string][]string{},
-			}
+	stateMachinePermission := cruds["smd"].GetRowPermission(objectStateMachine.GetAllAsAttributes())
 
-			stateAudit.Data["source_reference_id"] = objectStateMachine.GetReferenceId()
+	if !stateMachinePermission.CanExecute(sessionUser.UserReferenceId, sessionUser.Groups) {
+		return "", 403, errors.New("forbidden")
+	}
 
-			_, err := creator.Create(stateAudit, req)
-			resource.CheckErr(err, "Failed to create audit for [%v]", objectStateMachine.GetTableName())
-		}
+	nextState, err := fsmManager.ApplyEvent(subjectInstanceModel.GetAllAsAttributes(), resource.NewStateMachineEvent(stateMachineId, eventName))
+	if err != nil {
+		return "", 400, err
+	}
 
-		s, v, err := statementbuilder.Squirrel.Update(typename+"_state").
-			Set("current_state", nextState).
-			Set("version", stateObject["version"].(int64)+1).
-			Where(squirrel.Eq{"reference_id": stateMachineId}).ToSql()
+	stateAudit := objectStateMachine.GetAuditModel()
+	creator, ok := cruds[stateAudit.GetTableName()]
+	if ok {
 
-		_, err = db.Exec(s, v...)
-		if err != nil {
-			gincontext.AbortWithError(500, err)
-			return
+		newRequest := &http.Request{
+			Method: "POST",
 		}
+		newRequest = newRequest.WithContext(req.PlainRequest.Context())
 
-		gincontext.AbortWithStatus(200)
+		req := api2go.Request{
+			PlainRequest: newRequest,
+			QueryParams:  map[string][]string{},
+		}
+
+		stateAudit.Data["source_reference_id"] = objectStateMachine.GetReferenceId()
 
+		_, err := creator.Create(stateAudit, req)
+		resource.CheckErr(err, "Failed to create audit for [%v]", objectStateMachine.GetTableName())
 	}
 
+	s, v, err := statementbuilder.Squirrel.Update(typename+"_state").
+		Set("current_state", nextState).
+		Set("version", stateObject["version"].(int64)+1).
+		Where(squirrel.Eq{"reference_id": stateMachineId}).ToSql()
+	if err != nil {
+		return "", 500, err
+	}
+
+	_, err = db.Exec(s, v...)
+	if err != nil {
+		return "", 500, err
+	}
+
+	return nextState, 200, nil
 }
 
 func CreateEventStartHandler(fsmManager resource.FsmManager, cruds map[string]*resource.DbResource, db database.DatabaseConnection) func(context *gin.Context) {
 
 	return func(gincontext *gin.Context) {
 
-		user := gincontext.Request.Context().Value("user")
-		var sessionUser *auth.SessionUser
-
-		if user != nil {
-			sessionUser = user.(*auth.SessionUser)
-		}
-
 		jsBytes, err := ioutil.ReadAll(gincontext.Request.Body)
 		if err != nil {
 			log.Errorf("Failed to read post body: %v", err)
@@ -125,9 +144,8 @@ func CreateEventStartHandler(fsmManager resource.FsmManager, cruds map[string]*r
 		m := make(map[string]interface{})
 		json.Unmarshal(jsBytes, &m)
 
-		typename := m["typeName"].(string)
-		refId := m["referenceId"].(string)
-		stateMachineId := gincontext.Param("stateMachineId")
+		typename, _ := m["typeName"].(string)
+		refId, _ := m["referenceId"].(string)
 
 		pr := &http.Request{}
 		pr.Method = "GET"
@@ -137,47 +155,70 @@ func CreateEventStartHandler(fsmManager resource.FsmManager, cruds map[string]*r
 			QueryParams:  map[string][]string{},
 		}
 
-		response, err := cruds["smd"].FindOne(stateMachineId, req)
-		if err != nil {
-			gincontext.AbortWithError(400, err)
-			return
-		}
-
-		stateMachineInstance := response.Result().(*api2go.Api2GoModel)
-		stateMachineInstanceProperties := stateMachineInstance.GetAttributes()
-		stateMachinePermission := cruds["smd"].GetRowPermission(stateMachineInstance.GetAllAsAttributes())
-
-		if !stateMachinePermission.CanExecute(sessionUser.UserReferenceId, sessionUser.Groups) {
+		resp, status, err := startObjectStateMachine(cruds, gincontext.Param("stateMachineId"), typename, refId, req)
+		if status == 403 {
 			gincontext.AbortWithStatus(403)
 			return
 		}
-
-		subjectInstanceResponse, err := cruds[typename].FindOne(refId, req)
 		if err != nil {
-			gincontext.AbortWithError(400, err)
+			gincontext.AbortWithError(status, err)
 			return
 		}
-		subjectInstanceModel := subjectInstanceResponse.Result().(*api2go.Api2GoModel).GetAttributes()
 
-		newStateMachine := make(map[string]interface{})
+		gincontext.JSON(200, resp)
 
-		newStateMachine["current_state"] = stateMachineInstanceProperties["initial_state"]
-		newStateMachine[typename+"_smd"] = stateMachineInstanceProperties["reference_id"]
-		newStateMachine["is_state_of_"+typename] = subjectInstanceModel["reference_id"]
-		newStateMachine["permission"] = int64(auth.None | auth.UserRead | auth.UserExecute | auth.GroupCreate | auth.GroupExecute)
+	}
 
-		req.PlainRequest.Method = "POST"
+}
 
-		resp, err := cruds[typename+"_state"].Create(api2go.NewApi2GoModelWithData(typename+"_state", nil, 0, nil, newStateMachine), req)
+// startObjectStateMachine starts tracking the state of an object with a state
+// machine, from its initial state. The status is the http status of the failure.
+func startObjectStateMachine(cruds map[string]*resource.DbResource, stateMachineId string, typename string, refId string, req api2go.Request) (api2go.Responder, int, error) {
 
-		if err != nil {
-			log.Errorf("Failed to execute state insert query: %v", err)
-			gincontext.AbortWithError(500, err)
-			return
-		}
+	sessionUser, ok := req.PlainRequest.Context().Value("user").(*auth.SessionUser)
+	if !ok {
+		return nil, 403, errors.New("unauthorized")
+	}
 
-		gincontext.JSON(200, resp)
+	stateCrud, ok := cruds[typename+"_state"]
+	if !ok {
+		return nil, 400, fmt.Errorf("[%v] does not track states", typename)
+	}
+
+	response, err := cruds["smd"].FindOne(stateMachineId, req)
+	if err != nil {
+		return nil, 400, err
+	}
+
+	stateMachineInstance := response.Result().(*api2go.Api2GoModel)
+	stateMachineInstanceProperties := stateMachineInstance.GetAttributes()
+	stateMachinePermission := cruds["smd"].GetRowPermission(stateMachineInstance.GetAllAsAttributes())
+
+	if !stateMachinePermission.CanExecute(sessionUser.UserReferenceId, sessionUser.Groups) {
+		return nil, 403, errors.New("forbidden")
+	}
+
+	subjectInstanceResponse, err := cruds[typename].FindOne(refId, req)
+	if err != nil {
+		return nil, 400, err
+	}
+	subjectInstanceModel := subjectInstanceResponse.Result().(*api2go.Api2GoModel).GetAttributes()
+
+	newStateMachine := make(map[string]interface{})
+
+	newStateMachine["current_state"] = stateMachineInstanceProperties["initial_state"]
+	newStateMachine[typename+"_smd"] = stateMachineInstanceProperties["reference_id"]
+	newStateMachine["is_state_of_"+typename] = subjectInstanceModel["reference_id"]
+	newStateMachine["permission"] = int64(auth.None | auth.UserRead | auth.UserExecute | auth.GroupCreate | auth.GroupExecute)
+
+	req.PlainRequest.Method = "POST"
+
+	resp, err := stateCrud.Create(api2go.NewApi2GoModelWithData(typename+"_state", nil, 0, nil, newStateMachine), req)
 
+	if err != nil {
+		log.Errorf("Failed to execute state insert query: %v", err)
+		return nil, 500, err
 	}
 
+	return resp, 200, nil
 }
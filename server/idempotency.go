@@ -0,0 +1,144 @@
+package server
+
+import (
+	"bytes"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"time"
+)
+
+// IdempotencyMiddleware stores the responses of requests sent with an
+// Idempotency-Key header, a retry of the request with the same key gets the
+// stored response instead of being served again. Keys are stored for the
+// window set in the idempotency.window config, 24 hours by default.
+type IdempotencyMiddleware struct {
+	cruds  map[string]*resource.DbResource
+	window time.Duration
+}
+
+func NewIdempotencyMiddleware(configStore *resource.ConfigStore, cruds map[string]*resource.DbResource) *IdempotencyMiddleware {
+
+	window := 24 * time.Hour
+	windowValue, err := configStore.GetConfigValueFor("idempotency.window", "backend")
+	if err != nil {
+		err = configStore.SetConfigValueFor("idempotency.window", window.String(), "backend")
+		resource.CheckErr(err, "Failed to store default value for idempotency window")
+	} else if duration, err := time.ParseDuration(windowValue); err == nil && duration > 0 {
+		window = duration
+	} else {
+		log.Errorf("Invalid idempotency.window [%v], using %v", windowValue, window)
+	}
+
+	return &IdempotencyMiddleware{
+		cruds:  cruds,
+		window: window,
+	}
+}
+
+// idempotencyRecorder keeps a copy of the response body to store it
+type idempotencyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *idempotencyRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *idempotencyRecorder) WriteString(data string) (int, error) {
+	r.body.WriteString(data)
+	return r.ResponseWriter.WriteString(data)
+}
+
+func (im *IdempotencyMiddleware) IdempotencyMiddlewareFunc(c *gin.Context) {
+
+	key := c.GetHeader("Idempotency-Key")
+	method := c.Request.Method
+	if key == "" || method == "GET" || method == "HEAD" || method == "OPTIONS" {
+		return
+	}
+	if len(key) > 255 {
+		c.AbortWithStatusJSON(400, resource.NewDaptinError("Idempotency-Key is too long", "invalid idempotency key"))
+		return
+	}
+
+	dbResource, ok := im.cruds[resource.IDEMPOTENCY_KEY_TABLE_NAME]
+	if !ok {
+		return
+	}
+
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatusJSON(400, resource.NewDaptinError("Failed to read request", "invalid request"))
+		return
+	}
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	// keys are per user, and a key is used for one endpoint only
+	userReferenceId := ""
+	if user := c.Request.Context().Value("user"); user != nil {
+		userReferenceId = user.(*auth.SessionUser).UserReferenceId
+	}
+	keyHash := resource.GetMD5Hash(userReferenceId + " " + method + " " + c.Request.URL.RequestURI() + " " + key)
+	requestHash := resource.GetMD5Hash(string(body))
+
+	stored, err := dbResource.GetIdempotentResponse(keyHash)
+	if err != nil {
+		log.Errorf("Failed to get response for idempotency key: %v", err)
+		return
+	}
+
+	if stored != nil {
+		if stored.RequestHash != requestHash {
+			c.AbortWithStatusJSON(422, resource.NewDaptinError("Idempotency-Key was used with a different request", "idempotency key reused"))
+			return
+		}
+		if stored.Status == 0 {
+			c.AbortWithStatusJSON(409, resource.NewDaptinError("A request with this Idempotency-Key is being served", "idempotency key in use"))
+			return
+		}
+		c.Header("Idempotent-Replayed", "true")
+		c.Data(stored.Status, stored.ContentType, stored.Body)
+		c.Abort()
+		return
+	}
+
+	err = dbResource.ReserveIdempotencyKey(keyHash, requestHash, time.Now().Add(im.window))
+	if err != nil {
+		// another request with the same key got it first
+		c.AbortWithStatusJSON(409, resource.NewDaptinError("A request with this Idempotency-Key is being served", "idempotency key in use"))
+		return
+	}
+
+	served := false
+	defer func() {
+		// a request which panicked is not stored so it can be retried
+		if !served {
+			err := dbResource.ReleaseIdempotencyKey(keyHash)
+			resource.CheckErr(err, "Failed to release idempotency key")
+		}
+	}()
+
+	recorder := &idempotencyRecorder{ResponseWriter: c.Writer}
+	c.Writer = recorder
+	c.Next()
+	served = true
+
+	// server errors are not stored so the request can be retried
+	if recorder.Status() >= 500 {
+		err = dbResource.ReleaseIdempotencyKey(keyHash)
+		resource.CheckErr(err, "Failed to release idempotency key")
+		return
+	}
+
+	err = dbResource.StoreIdempotentResponse(keyHash, recorder.Status(), recorder.Header().Get("Content-Type"), recorder.body.Bytes())
+	if err != nil {
+		log.Errorf("Failed to store response for idempotency key: %v", err)
+		err = dbResource.ReleaseIdempotencyKey(keyHash)
+		resource.CheckErr(err, "Failed to release idempotency key")
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/gin-gonic/gin"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+
+	cruds, db, sessionUser, cleanup := serverTestFixture(t)
+	defer cleanup()
+	otherUser := &auth.SessionUser{UserReferenceId: "other-user-reference-id"}
+
+	middleware := &IdempotencyMiddleware{
+		cruds:  cruds,
+		window: time.Hour,
+	}
+
+	served := 0
+	failing := false
+	panicking := false
+	users := map[string]*auth.SessionUser{"user": sessionUser, "other": otherUser}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(gin.Recovery())
+	router.Use(func(c *gin.Context) {
+		if user, ok := users[c.GetHeader("X-Test-User")]; ok {
+			c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), "user", user))
+		}
+	})
+	router.Use(middleware.IdempotencyMiddlewareFunc)
+	handler := func(c *gin.Context) {
+		served++
+		if panicking {
+			panic("handler failed")
+		}
+		if failing {
+			c.JSON(500, map[string]interface{}{"served": served})
+			return
+		}
+		c.JSON(201, map[string]interface{}{"token": "secret-token", "served": served})
+	}
+	router.POST("/api/book", handler)
+	router.GET("/api/book", handler)
+
+	send := func(method string, key string, user string, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(method, "/api/book", strings.NewReader(body))
+		if key != "" {
+			request.Header.Set("Idempotency-Key", key)
+		}
+		request.Header.Set("X-Test-User", user)
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	first := send("POST", "key-1", "user", `{"title": "first"}`)
+	retry := send("POST", "key-1", "user", `{"title": "first"}`)
+	if served != 1 || first.Code != 201 || retry.Code != 201 || retry.Body.String() != first.Body.String() {
+		t.Errorf("Expected the retry to get the stored response, served %d times: %v %v", served, first.Body.String(), retry.Body.String())
+	}
+	if first.Header().Get("Idempotent-Replayed") != "" || retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("Expected only the retry to be replayed")
+	}
+	if retry.Header().Get("Content-Type") != first.Header().Get("Content-Type") {
+		t.Errorf("Expected the content type to be stored, got %v", retry.Header().Get("Content-Type"))
+	}
+
+	// the stored response is encrypted
+	var storedBody string
+	if err := db.QueryRowx("select response_body from idempotency_key where response_status = 201").Scan(&storedBody); err != nil {
+		t.Fatal(err)
+	}
+	if storedBody == "" || strings.Contains(storedBody, "secret-token") {
+		t.Errorf("Expected the response to be stored encrypted, got %v", storedBody)
+	}
+
+	if response := send("POST", "key-1", "user", `{"title": "second"}`); response.Code != 422 {
+		t.Errorf("Expected a key used with another request to fail, got %v", response.Code)
+	}
+	if send("POST", "key-1", "other", `{"title": "first"}`); served != 2 {
+		t.Errorf("Expected the keys of other users to be apart")
+	}
+	if send("POST", "", "user", `{"title": "first"}`); served != 3 {
+		t.Errorf("Expected requests without a key to be served")
+	}
+	send("GET", "key-1", "user", "")
+	if send("GET", "key-1", "user", ""); served != 5 {
+		t.Errorf("Expected GET requests to be served every time")
+	}
+	if response := send("POST", strings.Repeat("k", 256), "user", `{}`); response.Code != 400 {
+		t.Errorf("Expected a long key to be rejected, got %v", response.Code)
+	}
+
+	// server errors are not stored
+	failing = true
+	served = 0
+	send("POST", "key-2", "user", `{}`)
+	failing = false
+	if response := send("POST", "key-2", "user", `{}`); served != 2 || response.Code != 201 {
+		t.Errorf("Expected a request failing with a server error to be served again, got %v", response.Code)
+	}
+
+	// a request which panicked does not keep the key
+	panicking = true
+	served = 0
+	if response := send("POST", "key-3", "user", `{}`); response.Code != 500 {
+		t.Errorf("Expected a panic to fail the request, got %v", response.Code)
+	}
+	panicking = false
+	if response := send("POST", "key-3", "user", `{}`); served != 2 || response.Code != 201 {
+		t.Errorf("Expected a request which panicked to be served again, got %v: %v", response.Code, response.Body.String())
+	}
+
+	// a request being served blocks the retries with its key
+	keyHash := resource.GetMD5Hash(sessionUser.UserReferenceId + " POST /api/book key-4")
+	if err := cruds[resource.IDEMPOTENCY_KEY_TABLE_NAME].ReserveIdempotencyKey(keyHash, resource.GetMD5Hash("{}"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if response := send("POST", "key-4", "user", `{}`); response.Code != 409 {
+		t.Errorf("Expected a key in use to be rejected, got %v", response.Code)
+	}
+
+	// expired keys are served again
+	if err := cruds[resource.IDEMPOTENCY_KEY_TABLE_NAME].ReserveIdempotencyKey(resource.GetMD5Hash(sessionUser.UserReferenceId+" POST /api/book key-5"),
+		resource.GetMD5Hash("{}"), time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	served = 0
+	if response := send("POST", "key-5", "user", `{}`); served != 1 || response.Code != 201 {
+		t.Errorf("Expected an expired key to be served again, got %v", response.Code)
+	}
+}
@@ -62,6 +62,7 @@ func CreateStatsHandler(initConfig *resource.CmsConfig, cruds map[string]*resour
 		aggReq := resource.AggregationRequest{}
 
 		aggReq.RootEntity = typeName
+		aggReq.User = sessionUser
 		aggReq.Filter = c.QueryArray("filter")
 		aggReq.GroupBy = c.QueryArray("group")
 		aggReq.Join = c.QueryArray("join")
@@ -70,6 +71,15 @@ func CreateStatsHandler(initConfig *resource.CmsConfig, cruds map[string]*resour
 		aggReq.TimeFrom = c.Query("timefrom")
 		aggReq.TimeTo = c.Query("timeto")
 		aggReq.Order = c.QueryArray("order")
+		aggReq.Query = make([]resource.Query, 0)
+		for _, query := range c.QueryArray("query") {
+			queries, err := resource.ParseQueryParam(query)
+			if err != nil {
+				c.JSON(400, resource.NewDaptinError("Invalid query: "+err.Error(), "invalid query"))
+				return
+			}
+			aggReq.Query = append(aggReq.Query, queries...)
+		}
 
 		aggResponse, err := cruds[typeName].DataStats(aggReq)
 
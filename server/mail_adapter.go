@@ -245,6 +245,12 @@ func DaptinSmtpDbResource(dbResource *resource.DbResource, certificateManager *r
 						//	continue
 						//}
 
+						// inbound rules invoke actions for matching mails to the local hosts, eg to create a ticket
+						if dbResource.RunInboundMailRules(recipient, rcpt.Host, mailAccount != nil && err == nil, e.Subject, mailBytes) {
+							log.Printf("Mail for [%v] was handled by a mail rule", rcpt.String())
+							continue
+						}
+
 						if mailAccount == nil || err != nil {
 							log.Printf("Mail is for someone else [%v] [%v]", rcpt.Host, rcpt.String())
 
@@ -377,6 +383,17 @@ func DaptinSmtpDbResource(dbResource *resource.DbResource, certificateManager *r
 							}
 						}
 
+						err = dbResource.CheckMailAccountQuota(mailAccount["id"].(int64), int64(mailSize), 1)
+						if err != nil {
+							log.Printf("Rejecting mail for [%v]: %v", recipient, err)
+							return backends.NewResult(fmt.Sprint("552 Error: mailbox is full")), backends.StorageError
+						}
+
+						modSeq, err := dbResource.NextMailBoxModSeq(mailBox["id"].(int64))
+						if err != nil {
+							return backends.NewResult(fmt.Sprint("554 Error: could not save email")), backends.StorageError
+						}
+
 						pr = pr.WithContext(context.WithValue(context.Background(), "user", sessionUser))
 
 						req := &api2go.Request{
@@ -430,6 +447,7 @@ func DaptinSmtpDbResource(dbResource *resource.DbResource, certificateManager *r
 								"recent":           true,
 								"flags":            flags,
 								"size":             mailSize,
+								"modseq":           modSeq,
 							},
 						}
 						_, err = dbResource.Cruds["mail"].CreateWithoutFilter(&model, *req)
@@ -0,0 +1,350 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/daptin/daptin/server/apiblueprint"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RequestValidator checks the requests to /api and /action against the
+// OpenAPI document of the server before they are served, so invalid values
+// are reported with a pointer to each of them instead of failing in the
+// database. It is enabled with the request_validation.enable config.
+type RequestValidator struct {
+	initConfig *resource.CmsConfig
+	cruds      map[string]*resource.DbResource
+	enabled    bool
+	once       sync.Once
+	router     *openapi3filter.Router
+}
+
+// requestValidationError is an invalid value of a request, with either the
+// JSON pointer to the value in the body or the name of the parameter
+type requestValidationError struct {
+	pointer   string
+	parameter string
+	code      string
+	detail    string
+}
+
+func NewRequestValidator(initConfig *resource.CmsConfig, configStore *resource.ConfigStore, cruds map[string]*resource.DbResource) *RequestValidator {
+
+	enabled := false
+	enableValue, err := configStore.GetConfigValueFor("request_validation.enable", "backend")
+	if err != nil {
+		err = configStore.SetConfigValueFor("request_validation.enable", "false", "backend")
+		resource.CheckErr(err, "Failed to store default value for request_validation.enable")
+	} else {
+		enabled = enableValue == "true"
+	}
+
+	return &RequestValidator{
+		initConfig: initConfig,
+		cruds:      cruds,
+		enabled:    enabled,
+	}
+}
+
+// Router is built on first use, once the tables are loaded and the column
+// types are known
+func (rv *RequestValidator) Router() *openapi3filter.Router {
+	rv.once.Do(func() {
+		document := apiblueprint.BuildApiBlueprint(rv.initConfig, rv.cruds)
+		swagger, err := openapi3.NewSwaggerLoader().LoadSwaggerFromData([]byte(document))
+		if err != nil {
+			log.Errorf("Failed to load the OpenAPI document, requests will not be validated: %v", err)
+			return
+		}
+		// routes are matched on the path only, whatever the host the server is reached at
+		swagger.Servers = nil
+		router := openapi3filter.NewRouter()
+		if err = router.AddSwagger(swagger); err != nil {
+			log.Errorf("Failed to route the OpenAPI document, requests will not be validated: %v", err)
+			return
+		}
+		rv.router = router
+	})
+	return rv.router
+}
+
+func (rv *RequestValidator) RequestValidationMiddlewareFunc(c *gin.Context) {
+
+	if !rv.enabled {
+		return
+	}
+	path := c.Request.URL.Path
+	if !strings.HasPrefix(path, "/api/") && !strings.HasPrefix(path, "/action/") {
+		return
+	}
+	router := rv.Router()
+	if router == nil {
+		return
+	}
+
+	route, pathParams, err := router.FindRoute(c.Request.Method, c.Request.URL)
+	if err != nil {
+		// not a documented route, the handlers decide what to do with it
+		return
+	}
+
+	validationErrors := rv.validateParameters(c.Request, route, pathParams)
+
+	if requestBody := route.Operation.RequestBody; requestBody != nil && requestBody.Value != nil {
+		body, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(400, resource.NewDaptinError("Failed to read request", "invalid request"))
+			return
+		}
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+		validationErrors = append(validationErrors, validateRequestBody(requestBody.Value, body, strings.HasPrefix(path, "/action/"))...)
+	}
+
+	if len(validationErrors) > 0 {
+		c.AbortWithStatusJSON(400, requestValidationErrorResponse(validationErrors))
+	}
+}
+
+// validateParameters checks the path and query parameters of the operation
+func (rv *RequestValidator) validateParameters(request *http.Request, route *openapi3filter.Route, pathParams map[string]string) []requestValidationError {
+
+	input := &openapi3filter.RequestValidationInput{
+		Request:    request,
+		PathParams: pathParams,
+		Route:      route,
+	}
+
+	validationErrors := make([]requestValidationError, 0)
+	for _, parameterRef := range route.Operation.Parameters {
+		parameter := parameterRef.Value
+		err := openapi3filter.ValidateParameter(context.Background(), input, parameter)
+		if err == nil {
+			continue
+		}
+		validationError := requestValidationError{
+			parameter: parameter.Name,
+			code:      "invalid_value",
+			detail:    fmt.Sprintf("%v is invalid", parameter.Name),
+		}
+		if requestError, ok := err.(*openapi3filter.RequestError); ok {
+			if requestError.Err == openapi3filter.ErrInvalidRequired {
+				validationError.code = "required"
+				validationError.detail = fmt.Sprintf("%v is required", parameter.Name)
+			} else if schemaError, ok := requestError.Err.(*openapi3.SchemaError); ok {
+				validationError.code, validationError.detail = describeSchemaError(parameter.Name, schemaError)
+			}
+		}
+		validationErrors = append(validationErrors, validationError)
+	}
+	return validationErrors
+}
+
+// validateRequestBody checks the body against the schema of the operation.
+// The inputs of an action can also be sent next to the attributes, like the
+// action handler reads them, and those which are not json are left to it.
+func validateRequestBody(requestBody *openapi3.RequestBody, body []byte, isAction bool) []requestValidationError {
+
+	var schema *openapi3.Schema
+	for _, mediaType := range requestBody.Content {
+		if mediaType.Schema != nil {
+			schema = mediaType.Schema.Value
+		}
+	}
+	if schema == nil {
+		return nil
+	}
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		if requestBody.Required {
+			return []requestValidationError{{pointer: "", code: "required", detail: "the request body is required"}}
+		}
+		return nil
+	}
+
+	var document interface{}
+	err := json.Unmarshal(body, &document)
+	if err != nil {
+		if isAction {
+			return nil
+		}
+		return []requestValidationError{{pointer: "", code: "invalid_json", detail: "the request body is not valid json"}}
+	}
+
+	if !isAction {
+		return validateJSONValue(schema, document, "")
+	}
+
+	values, ok := document.(map[string]interface{})
+	if !ok {
+		return validateJSONValue(schema, document, "")
+	}
+	attributes, ok := values["attributes"].(map[string]interface{})
+	if !ok {
+		attributes = make(map[string]interface{})
+	}
+	inputs := make(map[string]interface{})
+	for key, value := range attributes {
+		inputs[key] = value
+	}
+	for key, value := range values {
+		if key != "attributes" {
+			inputs[key] = value
+		}
+	}
+
+	validationErrors := validateJSONValue(schema, map[string]interface{}{"attributes": inputs}, "")
+	for i, validationError := range validationErrors {
+		// point to the inputs sent next to the attributes where they are
+		key := strings.SplitN(strings.TrimPrefix(validationError.pointer, "/attributes/"), "/", 2)[0]
+		if _, inAttributes := attributes[key]; !inAttributes {
+			if _, inBody := values[key]; inBody {
+				validationErrors[i].pointer = "/" + strings.TrimPrefix(validationError.pointer, "/attributes/")
+			}
+		}
+	}
+	return validationErrors
+}
+
+// validateJSONValue checks a value against a schema, objects and lists are
+// checked member by member so every invalid value is reported
+func validateJSONValue(schema *openapi3.Schema, value interface{}, pointer string) []requestValidationError {
+
+	if schema == nil || schema.IsEmpty() {
+		return nil
+	}
+	name := pointer[strings.LastIndex(pointer, "/")+1:]
+
+	if value == nil {
+		if schema.Nullable {
+			return nil
+		}
+		return []requestValidationError{{pointer: pointer, code: "invalid_value", detail: strings.TrimSpace(name + " must not be null")}}
+	}
+
+	composed := len(schema.OneOf) > 0 || len(schema.AnyOf) > 0 || len(schema.AllOf) > 0 || schema.Not != nil
+	if !composed {
+		switch typedValue := value.(type) {
+		case map[string]interface{}:
+			if schema.Type == "object" {
+				return validateJSONObject(schema, typedValue, pointer)
+			}
+		case []interface{}:
+			if schema.Type == "array" && schema.Items != nil && len(schema.Enum) == 0 {
+				validationErrors := make([]requestValidationError, 0)
+				for i, item := range typedValue {
+					validationErrors = append(validationErrors, validateJSONValue(schema.Items.Value, item, fmt.Sprintf("%v/%d", pointer, i))...)
+				}
+				return validationErrors
+			}
+		}
+	}
+
+	err := schema.VisitJSON(value)
+	if err == nil {
+		return nil
+	}
+	validationError := requestValidationError{pointer: pointer, code: "invalid_value", detail: strings.TrimSpace(name + " is invalid")}
+	if schemaError, ok := err.(*openapi3.SchemaError); ok {
+		if path := schemaError.JSONPointer(); len(path) > 0 {
+			validationError.pointer = pointer + "/" + strings.Join(path, "/")
+			name = path[len(path)-1]
+		}
+		validationError.code, validationError.detail = describeSchemaError(name, schemaError)
+	}
+	return []requestValidationError{validationError}
+}
+
+func validateJSONObject(schema *openapi3.Schema, value map[string]interface{}, pointer string) []requestValidationError {
+
+	validationErrors := make([]requestValidationError, 0)
+	for _, key := range schema.Required {
+		if _, ok := value[key]; !ok {
+			validationErrors = append(validationErrors, requestValidationError{
+				pointer: pointer + "/" + key,
+				code:    "required",
+				detail:  fmt.Sprintf("%v is required", key),
+			})
+		}
+	}
+
+	keys := make([]string, 0, len(value))
+	for key := range value {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		property, ok := schema.Properties[key]
+		if !ok || property.Value == nil {
+			continue
+		}
+		validationErrors = append(validationErrors, validateJSONValue(property.Value, value[key], pointer+"/"+key)...)
+	}
+	return validationErrors
+}
+
+// describeSchemaError is the code and the message of a value which does not
+// match its schema
+func describeSchemaError(name string, schemaError *openapi3.SchemaError) (string, string) {
+
+	schema := schemaError.Schema
+	switch schemaError.SchemaField {
+	case "type":
+		if schema.Type == "integer" || schema.Type == "array" || schema.Type == "object" {
+			return "invalid_type", fmt.Sprintf("%v must be an %v", name, schema.Type)
+		}
+		return "invalid_type", fmt.Sprintf("%v must be a %v", name, schema.Type)
+	case "enum":
+		values := make([]string, 0, len(schema.Enum))
+		for _, value := range schema.Enum {
+			values = append(values, fmt.Sprintf("%v", value))
+		}
+		return "invalid_option", fmt.Sprintf("%v must be one of %v", name, strings.Join(values, ", "))
+	case "format":
+		return "invalid_format", fmt.Sprintf("%v must be a %v", name, schema.Format)
+	case "nullable":
+		return "invalid_value", fmt.Sprintf("%v must not be null", name)
+	case "required":
+		return "required", schemaError.Reason
+	}
+	if schemaError.Reason != "" {
+		return "invalid_value", fmt.Sprintf("%v: %v", name, schemaError.Reason)
+	}
+	return "invalid_value", fmt.Sprintf("%v does not match %v", name, schemaError.SchemaField)
+}
+
+// requestValidationErrorResponse is the JSON:API document with the errors
+func requestValidationErrorResponse(validationErrors []requestValidationError) map[string]interface{} {
+
+	jsonErrors := make([]interface{}, 0, len(validationErrors))
+	for _, validationError := range validationErrors {
+		source := map[string]interface{}{
+			"pointer": validationError.pointer,
+		}
+		if validationError.parameter != "" {
+			source = map[string]interface{}{
+				"parameter": validationError.parameter,
+			}
+		}
+		jsonErrors = append(jsonErrors, map[string]interface{}{
+			"status": "400",
+			"code":   validationError.code,
+			"title":  http.StatusText(400),
+			"detail": validationError.detail,
+			"source": source,
+		})
+	}
+
+	return map[string]interface{}{
+		"errors": jsonErrors,
+	}
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// requestValidationFixture is a router validating the requests for a book
+// table and its publish action, which answers 200 to the valid ones
+func requestValidationFixture(t *testing.T, enabled bool) *gin.Engine {
+
+	resource.InitialiseColumnManager()
+	columns := append(append([]api2go.ColumnInfo{}, resource.StandardColumns...),
+		api2go.ColumnInfo{Name: "title", ColumnName: "title", ColumnType: "label"},
+		api2go.ColumnInfo{Name: "pages", ColumnName: "pages", ColumnType: "measurement", IsNullable: true},
+	)
+	initConfig := &resource.CmsConfig{
+		Hostname: "localhost:6336",
+		Tables: append([]resource.TableInfo{
+			{TableName: "book", Columns: columns},
+		}, resource.StandardTables...),
+		Actions: []resource.Action{
+			{
+				Name:   "publish",
+				Label:  "Publish the book",
+				OnType: "book",
+				InFields: []api2go.ColumnInfo{
+					{Name: "edition", ColumnName: "edition", ColumnType: "measurement"},
+				},
+				Validations: []resource.ColumnTag{
+					{ColumnName: "edition", Tags: "required"},
+				},
+			},
+		},
+	}
+
+	validator := &RequestValidator{
+		initConfig: initConfig,
+		cruds:      map[string]*resource.DbResource{},
+		enabled:    enabled,
+	}
+	if validator.Router() == nil {
+		t.Fatalf("Failed to route the OpenAPI document")
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(validator.RequestValidationMiddlewareFunc)
+	served := func(c *gin.Context) {
+		// the body is left for the handler
+		body, _ := ioutil.ReadAll(c.Request.Body)
+		c.String(200, string(body))
+	}
+	router.GET("/api/book", served)
+	router.POST("/api/book", served)
+	router.PATCH("/api/book/:referenceId", served)
+	router.POST("/action/book/publish", served)
+	router.POST("/api/undocumented", served)
+	return router
+}
+
+func TestRequestValidation(t *testing.T) {
+
+	for _, enabled := range []bool{true, false} {
+		router := requestValidationFixture(t, enabled)
+
+		for _, test := range []struct {
+			name    string
+			method  string
+			url     string
+			body    string
+			status  int
+			code    string
+			pointer string
+		}{
+			{name: "create", method: "POST", url: "/api/book",
+				body: `{"data": {"type": "book", "attributes": {"title": "first", "pages": 10}}}`, status: 200},
+			{name: "create without a required value", method: "POST", url: "/api/book",
+				body: `{"data": {"type": "book", "attributes": {"pages": 10}}}`, status: 400, code: "required", pointer: "/data/attributes/title"},
+			{name: "create with a wrong type", method: "POST", url: "/api/book",
+				body: `{"data": {"type": "book", "attributes": {"title": "first", "pages": "many"}}}`, status: 400, code: "invalid_type", pointer: "/data/attributes/pages"},
+			{name: "create with null for a value which is not nullable", method: "POST", url: "/api/book",
+				body: `{"data": {"type": "book", "attributes": {"title": null}}}`, status: 400, code: "invalid_value", pointer: "/data/attributes/title"},
+			{name: "create with invalid json", method: "POST", url: "/api/book",
+				body: `{"data": `, status: 400, code: "invalid_json", pointer: ""},
+			{name: "update without the required values", method: "PATCH", url: "/api/book/a-reference-id",
+				body: `{"data": {"type": "book", "id": "a-reference-id", "attributes": {"pages": 12}}}`, status: 200},
+			{name: "update with a wrong type", method: "PATCH", url: "/api/book/a-reference-id",
+				body: `{"data": {"type": "book", "id": "a-reference-id", "attributes": {"pages": "many"}}}`, status: 400, code: "invalid_type", pointer: "/data/attributes/pages"},
+			{name: "list", method: "GET", url: "/api/book?page[size]=10", status: 200},
+			{name: "action", method: "POST", url: "/action/book/publish",
+				body: `{"attributes": {"book_id": "a-reference-id", "edition": 2}}`, status: 200},
+			{name: "action with the inputs next to the attributes", method: "POST", url: "/action/book/publish",
+				body: `{"book_id": "a-reference-id", "edition": 2}`, status: 200},
+			{name: "action without a required input", method: "POST", url: "/action/book/publish",
+				body: `{"attributes": {"book_id": "a-reference-id"}}`, status: 400, code: "required", pointer: "/attributes/edition"},
+			{name: "action with a wrong type", method: "POST", url: "/action/book/publish",
+				body: `{"attributes": {"book_id": "a-reference-id", "edition": "second"}}`, status: 400, code: "invalid_type", pointer: "/attributes/edition"},
+			{name: "action with a wrong type next to the attributes", method: "POST", url: "/action/book/publish",
+				body: `{"book_id": "a-reference-id", "edition": "second"}`, status: 400, code: "invalid_type", pointer: "/edition"},
+			{name: "action with a body which is not json", method: "POST", url: "/action/book/publish",
+				body: `edition=2`, status: 200},
+			{name: "undocumented route", method: "POST", url: "/api/undocumented",
+				body: `{"data": `, status: 200},
+		} {
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(test.method, test.url, strings.NewReader(test.body))
+			request.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(recorder, request)
+
+			if !enabled {
+				// nothing is checked, every request reaches the handler as it was sent
+				if recorder.Code != 200 || recorder.Body.String() != test.body {
+					t.Errorf("%v: expected the request to be served without validation, got %v: %v", test.name, recorder.Code, recorder.Body.String())
+				}
+				continue
+			}
+
+			if recorder.Code != test.status {
+				t.Errorf("%v: expected %v, got %v: %v", test.name, test.status, recorder.Code, recorder.Body.String())
+				continue
+			}
+			if test.status == 200 {
+				if recorder.Body.String() != test.body {
+					t.Errorf("%v: expected the handler to get the body, got %v", test.name, recorder.Body.String())
+				}
+				continue
+			}
+			var response struct {
+				Errors []struct {
+					Code   string            `json:"code"`
+					Source map[string]string `json:"source"`
+				} `json:"errors"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || len(response.Errors) != 1 {
+				t.Errorf("%v: expected one error, got %v", test.name, recorder.Body.String())
+				continue
+			}
+			if response.Errors[0].Code != test.code || response.Errors[0].Source["pointer"] != test.pointer {
+				t.Errorf("%v: expected [%v] at [%v], got %v", test.name, test.code, test.pointer, recorder.Body.String())
+			}
+		}
+	}
+}
+
+func TestRequestValidationConfig(t *testing.T) {
+
+	_, db, _, cleanup := serverTestFixture(t)
+	defer cleanup()
+
+	configStore, err := resource.NewConfigStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if NewRequestValidator(&resource.CmsConfig{}, configStore, nil).enabled {
+		t.Errorf("Expected request validation to be off unless enabled")
+	}
+	if value, err := configStore.GetConfigValueFor("request_validation.enable", "backend"); err != nil || value != "false" {
+		t.Errorf("Expected the default value to be stored, got [%v]: %v", value, err)
+	}
+
+	if err = configStore.SetConfigValueFor("request_validation.enable", "true", "backend"); err != nil {
+		t.Fatal(err)
+	}
+	if !NewRequestValidator(&resource.CmsConfig{}, configStore, nil).enabled {
+		t.Errorf("Expected request validation to be on when enabled")
+	}
+}
@@ -0,0 +1,85 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/artpar/api2go"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BackupPerformer writes a backup of the instance with CreateBackup, and
+// uploads it to a folder of a cloud store. The backup is not sent in the
+// response, a backup with the files of the instance does not fit in memory,
+// the command line can write a backup to a local file instead.
+type BackupPerformer struct {
+	cmsConfig *CmsConfig
+	cruds     map[string]*DbResource
+}
+
+func (d *BackupPerformer) Name() string {
+	return "__backup"
+}
+
+func (d *BackupPerformer) DoAction(request Outcome, inFields map[string]interface{}) (api2go.Responder, []ActionResponse, []error) {
+
+	responses := make([]ActionResponse, 0)
+
+	password, _ := inFields["password"].(string)
+	cloudStoreName, _ := inFields["cloud_store_name"].(string)
+	backupPath, _ := inFields["path"].(string)
+	if strings.TrimSpace(backupPath) == "" {
+		backupPath = "backups"
+	}
+
+	if cloudStoreName == "" {
+		return nil, nil, []error{fmt.Errorf("a cloud store is needed to upload the backup to")}
+	}
+	cloudStore, err := d.cruds["cloud_store"].GetCloudStoreByName(cloudStoreName)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+
+	tempDir, err := ioutil.TempDir("", "daptin-backup-file")
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+	defer os.RemoveAll(tempDir)
+
+	fileName := BackupFileName(password != "")
+	filePath := filepath.Join(tempDir, fileName)
+	file, err := os.Create(filePath)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+	summary, err := CreateBackup(d.cruds, file, password)
+	file.Close()
+	if err != nil {
+		return nil, nil, []error{fmt.Errorf("failed to create backup: %v", err)}
+	}
+
+	responses = append(responses, NewActionResponse("backup.summary", summary))
+	message := fmt.Sprintf("%d tables, %d rows and %d files", len(summary.Manifest.Tables), summary.Rows, summary.Files)
+
+	err = d.cruds["cloud_store"].CopyToCloudStore(cloudStore, filePath, backupPath)
+	if err != nil {
+		return nil, nil, []error{fmt.Errorf("failed to upload backup to [%v]: %v", cloudStoreName, err)}
+	}
+	log.Infof("Uploaded backup [%v] to [%v/%v]", fileName, cloudStoreName, backupPath)
+	responses = append(responses, NewActionResponse("client.notify",
+		NewClientNotification("success", fmt.Sprintf("Uploaded %v to %v/%v with %v", fileName, cloudStoreName, backupPath, message), "Backup")))
+
+	return nil, responses, nil
+}
+
+func NewBackupPerformer(initConfig *CmsConfig, cruds map[string]*DbResource) (ActionPerformerInterface, error) {
+
+	handler := BackupPerformer{
+		cmsConfig: initConfig,
+		cruds:     cruds,
+	}
+
+	return &handler, nil
+}
@@ -37,6 +37,7 @@ func (d *ImportDataPerformer) DoAction(request Outcome, inFields map[string]inte
 	files := inFields["dump_file"].([]interface{})
 
 	truncate_before_insert := inFields["truncate_before_insert"].(bool)
+	upsert, _ := inFields["upsert"].(bool)
 	//execute_middleware_chain := inFields["execute_middleware_chain"].(bool)
 
 	imports := make(map[string][]interface{})
@@ -122,7 +123,12 @@ func (d *ImportDataPerformer) DoAction(request Outcome, inFields map[string]inte
 					data[USER_ACCOUNT_TABLE_NAME] = userIdInt
 				}
 
-				err := d.cruds[tableName].DirectInsert(tableName, data)
+				var err error
+				if upsert && len(d.cruds[tableName].TableInfo().UpsertKeys) > 0 {
+					err = d.cruds[tableName].DirectUpsert(tableName, data)
+				} else {
+					err = d.cruds[tableName].DirectInsert(tableName, data)
+				}
 				if err != nil {
 					log.Errorf("Was about to insert this: %v", data)
 					log.Errorf("Failed to direct insert into table [%v] : %v", tableName, err)
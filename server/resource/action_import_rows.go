@@ -0,0 +1,187 @@
+package resource
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ImportRowsPerformer imports the rows of a csv or xlsx file into an existing
+// table with ImportRows, and responds with the report of the import and the
+// rows which failed as a csv file to download
+type ImportRowsPerformer struct {
+	cmsConfig *CmsConfig
+	cruds     map[string]*DbResource
+}
+
+func (d *ImportRowsPerformer) Name() string {
+	return "__import_rows"
+}
+
+func (d *ImportRowsPerformer) DoAction(request Outcome, inFields map[string]interface{}) (api2go.Responder, []ActionResponse, []error) {
+
+	responses := make([]ActionResponse, 0)
+
+	tableName, _ := inFields["table_name"].(string)
+	dbResource, ok := d.cruds[tableName]
+	if !ok {
+		return nil, nil, []error{fmt.Errorf("no such table: %v", tableName)}
+	}
+
+	mode, _ := inFields["mode"].(string)
+	options := ImportOptions{
+		Mode:      strings.ToLower(mode),
+		DryRun:    importFlag(inFields["dry_run"]),
+		BatchSize: importNumber(inFields["batch_size"]),
+	}
+	var err error
+	options.Mapping, err = importStringMap(inFields["mapping"])
+	if err != nil {
+		return nil, nil, []error{fmt.Errorf("invalid column mapping: %v", err)}
+	}
+	options.RelationKeys, err = importStringMap(inFields["relation_keys"])
+	if err != nil {
+		return nil, nil, []error{fmt.Errorf("invalid relation keys: %v", err)}
+	}
+
+	// the rows are written with the permissions of the user calling the action
+	options.User = &auth.SessionUser{}
+	if user, ok := inFields["user"].(map[string]interface{}); ok {
+		if userReferenceId, ok := user["reference_id"].(string); ok {
+			options.User.UserReferenceId = userReferenceId
+			options.User.UserId, err = dbResource.GetReferenceIdToId(USER_ACCOUNT_TABLE_NAME, userReferenceId)
+			CheckErr(err, "Failed to get user id from user reference id")
+			options.User.Groups = dbResource.GetObjectUserGroupsByWhere(USER_ACCOUNT_TABLE_NAME, "reference_id", userReferenceId)
+		}
+	}
+
+	files, _ := inFields["data_file"].([]interface{})
+	if len(files) == 0 {
+		return nil, nil, []error{fmt.Errorf("no file to import")}
+	}
+	file, _ := files[0].(map[string]interface{})
+	fileName, _ := file["name"].(string)
+	fileContentsBase64, _ := file["file"].(string)
+	contentParts := strings.Split(fileContentsBase64, ",")
+	fileBytes, err := base64.StdEncoding.DecodeString(contentParts[len(contentParts)-1])
+	if err != nil {
+		return nil, nil, []error{fmt.Errorf("uploaded file is not base64 encoded: %v", err)}
+	}
+	log.Infof("Import rows of [%v] into [%v]", fileName, tableName)
+
+	var source ImportSource
+	if strings.HasSuffix(strings.ToLower(fileName), ".xlsx") || strings.HasSuffix(strings.ToLower(fileName), ".xls") {
+		source, err = NewXlsxImportSource(fileBytes)
+		if err != nil {
+			return nil, nil, []error{fmt.Errorf("failed to read xlsx file: %v", err)}
+		}
+	} else {
+		source = NewCsvImportSource(bytes.NewReader(fileBytes))
+	}
+
+	errorReport := &bytes.Buffer{}
+	report, err := dbResource.ImportRows(source, options, errorReport)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+
+	responses = append(responses, NewActionResponse("import.report", report))
+
+	title := "Imported"
+	if report.DryRun {
+		title = "Dry run"
+	}
+	notificationType := "success"
+	if report.Failed > 0 {
+		notificationType = "warning"
+	}
+	message := fmt.Sprintf("%d rows of %d: %d inserted, %d updated, %d skipped, %d failed",
+		report.Total-report.Failed, report.Total, report.Inserted, report.Updated, report.Skipped, report.Failed)
+	responses = append(responses, NewActionResponse("client.notify", NewClientNotification(notificationType, message, title)))
+
+	if report.Failed > 0 {
+		responseAttrs := make(map[string]interface{})
+		responseAttrs["content"] = base64.StdEncoding.EncodeToString(errorReport.Bytes())
+		responseAttrs["name"] = fmt.Sprintf("import_errors_%v_%v.csv", tableName, time.Now().Format("2006-01-02-15-04-05"))
+		responseAttrs["contentType"] = "text/csv"
+		responseAttrs["message"] = "Downloading the rows which were not imported"
+		responses = append(responses, NewActionResponse("client.file.download", responseAttrs))
+	}
+
+	return nil, responses, nil
+}
+
+// importFlag is a true false in field, sent as a boolean or as text by a form
+func importFlag(value interface{}) bool {
+	switch typedValue := value.(type) {
+	case bool:
+		return typedValue
+	case string:
+		flag, _ := strconv.ParseBool(typedValue)
+		return flag
+	}
+	return false
+}
+
+func importNumber(value interface{}) int {
+	switch typedValue := value.(type) {
+	case float64:
+		return int(typedValue)
+	case int:
+		return typedValue
+	case int64:
+		return int(typedValue)
+	case string:
+		number, _ := strconv.Atoi(typedValue)
+		return number
+	}
+	return 0
+}
+
+// importStringMap is a json in field of names, sent as an object or as the
+// text of one
+func importStringMap(value interface{}) (map[string]string, error) {
+
+	values := make(map[string]string)
+	var object map[string]interface{}
+	switch typedValue := value.(type) {
+	case nil:
+		return values, nil
+	case map[string]interface{}:
+		object = typedValue
+	case string:
+		if strings.TrimSpace(typedValue) == "" {
+			return values, nil
+		}
+		if err := json.Unmarshal([]byte(typedValue), &object); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("expected an object, got %T", value)
+	}
+
+	for key, name := range object {
+		nameString, ok := name.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a name for [%v], got %v", key, name)
+		}
+		values[key] = nameString
+	}
+	return values, nil
+}
+
+func NewImportRowsPerformer(initConfig *CmsConfig, cruds map[string]*DbResource) (ActionPerformerInterface, error) {
+
+	handler := ImportRowsPerformer{
+		cmsConfig: initConfig,
+		cruds:     cruds,
+	}
+
+	return &handler, nil
+}
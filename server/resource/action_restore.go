@@ -0,0 +1,106 @@
+package resource
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/artpar/api2go"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// RestorePerformer restores a backup with RestoreBackup, and restarts daptin
+// to use the restored schema and config. The backup is read from a file of a
+// cloud store, or from an upload for backups small enough to be uploaded.
+type RestorePerformer struct {
+	cmsConfig *CmsConfig
+	cruds     map[string]*DbResource
+}
+
+func (d *RestorePerformer) Name() string {
+	return "__restore"
+}
+
+func (d *RestorePerformer) DoAction(request Outcome, inFields map[string]interface{}) (api2go.Responder, []ActionResponse, []error) {
+
+	responses := make([]ActionResponse, 0)
+
+	password, _ := inFields["password"].(string)
+	cloudStoreName, _ := inFields["cloud_store_name"].(string)
+	backupPath, _ := inFields["path"].(string)
+
+	var backupReader io.Reader
+	if cloudStoreName != "" {
+		if strings.TrimSpace(backupPath) == "" {
+			return nil, nil, []error{fmt.Errorf("path of the backup in [%v] is needed", cloudStoreName)}
+		}
+		cloudStore, err := d.cruds["cloud_store"].GetCloudStoreByName(cloudStoreName)
+		if err != nil {
+			return nil, nil, []error{err}
+		}
+
+		tempDir, err := ioutil.TempDir("", "daptin-restore-file")
+		if err != nil {
+			return nil, nil, []error{err}
+		}
+		defer os.RemoveAll(tempDir)
+
+		err = d.cruds["cloud_store"].CopyFileFromCloudStore(cloudStore, backupPath, tempDir)
+		if err != nil {
+			return nil, nil, []error{fmt.Errorf("failed to download backup from [%v]: %v", cloudStoreName, err)}
+		}
+		file, err := os.Open(filepath.Join(tempDir, path.Base(backupPath)))
+		if err != nil {
+			return nil, nil, []error{err}
+		}
+		defer file.Close()
+		log.Infof("Restore backup [%v/%v]", cloudStoreName, backupPath)
+		backupReader = file
+	} else {
+		files, _ := inFields["backup_file"].([]interface{})
+		if len(files) == 0 {
+			return nil, nil, []error{fmt.Errorf("no backup to restore")}
+		}
+		file, _ := files[0].(map[string]interface{})
+		fileName, _ := file["name"].(string)
+		fileContentsBase64, _ := file["file"].(string)
+		if index := strings.Index(fileContentsBase64, ","); index > -1 {
+			fileContentsBase64 = fileContentsBase64[index+1:]
+		}
+		log.Infof("Restore backup [%v]", fileName)
+		// decoded as it is read, the upload is not held in memory twice
+		backupReader = base64.NewDecoder(base64.StdEncoding, strings.NewReader(fileContentsBase64))
+	}
+
+	summary, err := RestoreBackup(d.cruds, backupReader, password)
+	if err != nil {
+		return nil, nil, []error{fmt.Errorf("failed to restore backup: %v", err)}
+	}
+
+	responses = append(responses, NewActionResponse("backup.summary", summary))
+	message := fmt.Sprintf("Restored %d rows and %d files, restarting", summary.Rows, summary.Files)
+	notificationType := "success"
+	if len(summary.Skipped) > 0 {
+		notificationType = "warning"
+		message = message + fmt.Sprintf(", not restored: %v", strings.Join(summary.Skipped, ", "))
+	}
+	responses = append(responses, NewActionResponse("client.notify", NewClientNotification(notificationType, message, "Restore")))
+
+	go restart()
+
+	return nil, responses, nil
+}
+
+func NewRestorePerformer(initConfig *CmsConfig, cruds map[string]*DbResource) (ActionPerformerInterface, error) {
+
+	handler := RestorePerformer{
+		cmsConfig: initConfig,
+		cruds:     cruds,
+	}
+
+	return &handler, nil
+}
@@ -0,0 +1,866 @@
+package resource
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"github.com/araddon/dateparse"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/database"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BackupFormat and BackupFormatVersion are written in the manifest of every
+// backup. A backup of a newer version than this daptin is not restored.
+const BackupFormat = "daptin-backup"
+const BackupFormatVersion = 1
+
+const backupManifestEntry = "manifest.json"
+const backupSchemaEntry = "schema/world.ndjson"
+const backupAssetsFolder = "assets"
+
+// backupTableNamePattern matches the names of tables created from a backup
+var backupTableNamePattern = regexp.MustCompile("^[a-zA-Z0-9_]+$")
+
+// backupInsertParameters is the number of values inserted in a statement when
+// a table is restored, below the limit of the databases
+const backupInsertParameters = 900
+
+// BackupManifest describes the contents of a backup
+type BackupManifest struct {
+	Format       string    `json:"format"`
+	Version      int       `json:"version"`
+	CreatedAt    time.Time `json:"created_at"`
+	DatabaseType string    `json:"database_type"`
+	// Tables are in the order they are restored in, a table comes after the
+	// tables it refers to
+	Tables []string `json:"tables"`
+	// AssetColumns are the table.column of the cloud store folders of the
+	// backup
+	AssetColumns []string `json:"asset_columns"`
+}
+
+// BackupSummary is what was written or restored
+type BackupSummary struct {
+	Manifest BackupManifest `json:"manifest"`
+	Rows     int            `json:"rows"`
+	Files    int            `json:"files"`
+	Skipped  []string       `json:"skipped"`
+}
+
+// backupTableHeader is the first line of a table in a backup, the rows follow
+// as json arrays of the values of the columns
+type backupTableHeader struct {
+	Table       string   `json:"table"`
+	Columns     []string `json:"columns"`
+	TimeColumns []string `json:"time_columns"`
+}
+
+// BackupFileName is the name of a backup taken now
+func BackupFileName(encrypted bool) string {
+	name := fmt.Sprintf("daptin-backup-%v.tar.gz", time.Now().Format("2006-01-02-15-04-05"))
+	if encrypted {
+		name = name + ".enc"
+	}
+	return name
+}
+
+// backupTableEntry is the name of the data of the table in a backup. The
+// schema, the config and the certificates are kept apart from the data of the
+// other tables.
+func backupTableEntry(tableName string) string {
+	switch tableName {
+	case "world":
+		return backupSchemaEntry
+	case settingsTableName:
+		return "config/" + settingsTableName + ".ndjson"
+	case "certificate":
+		return "certificates/certificate.ndjson"
+	}
+	return "data/" + tableName + ".ndjson"
+}
+
+// backupTimeColumns are the columns of the table whose values are read back
+// as time
+func backupTimeColumns(tableInfo TableInfo) map[string]bool {
+	timeColumns := make(map[string]bool)
+	for _, column := range tableInfo.Columns {
+		dataType := strings.ToLower(column.DataType)
+		if column.ColumnType == "datetime" || column.ColumnType == "date" || column.ColumnType == "time" ||
+			strings.HasPrefix(dataType, "timestamp") || strings.HasPrefix(dataType, "datetime") || dataType == "date" {
+			timeColumns[column.ColumnName] = true
+		}
+	}
+	return timeColumns
+}
+
+// backupTableOrder sorts the tables so that a table comes after the tables
+// its foreign keys refer to. Tables in a cycle are left at the end by name.
+func backupTableOrder(tables map[string]TableInfo) []string {
+
+	dependencies := make(map[string]map[string]bool)
+	for tableName, tableInfo := range tables {
+		dependencies[tableName] = make(map[string]bool)
+		for _, column := range tableInfo.Columns {
+			if !column.IsForeignKey || column.ForeignKeyData.DataSource != "self" {
+				continue
+			}
+			referred := column.ForeignKeyData.Namespace
+			if _, ok := tables[referred]; ok && referred != tableName {
+				dependencies[tableName][referred] = true
+			}
+		}
+	}
+
+	order := make([]string, 0, len(tables))
+	for len(dependencies) > 0 {
+		ready := make([]string, 0)
+		for tableName, refers := range dependencies {
+			if len(refers) == 0 {
+				ready = append(ready, tableName)
+			}
+		}
+		if len(ready) == 0 {
+			for tableName := range dependencies {
+				ready = append(ready, tableName)
+			}
+		}
+		sort.Strings(ready)
+		for _, tableName := range ready {
+			delete(dependencies, tableName)
+			for _, refers := range dependencies {
+				delete(refers, tableName)
+			}
+		}
+		order = append(order, ready...)
+	}
+	return order
+}
+
+// CreateBackup writes the schema, the data of all tables, the config, the
+// certificates and the files of the cloud store folders of the instance to
+// writer as a gzipped tar archive. The archive is encrypted with the password
+// if it is not empty.
+func CreateBackup(cruds map[string]*DbResource, writer io.Writer, password string) (*BackupSummary, error) {
+
+	worldResource, ok := cruds["world"]
+	if !ok {
+		return nil, fmt.Errorf("no world table to back up")
+	}
+	db := worldResource.connection
+
+	tables := make(map[string]TableInfo)
+	for tableName, dbResource := range cruds {
+		if dbResource.tableInfo != nil {
+			tables[tableName] = *dbResource.tableInfo
+		}
+	}
+	tables[settingsTableName] = ConfigTableStructure
+
+	summary := &BackupSummary{
+		Manifest: BackupManifest{
+			Format:       BackupFormat,
+			Version:      BackupFormatVersion,
+			CreatedAt:    time.Now().UTC(),
+			DatabaseType: db.DriverName(),
+			Tables:       backupTableOrder(tables),
+			AssetColumns: make([]string, 0),
+		},
+		Skipped: make([]string, 0),
+	}
+
+	tempDir, err := ioutil.TempDir("", "daptin-backup")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	// the folders are found before the archive is written, so the manifest
+	// lists them
+	assetFolders := make(map[string]string)
+	for _, tableName := range summary.Manifest.Tables {
+		for _, column := range tables[tableName].Columns {
+			if !column.IsForeignKey || column.ForeignKeyData.DataSource != "cloud_store" {
+				continue
+			}
+			assetColumn := tableName + "." + column.ColumnName
+			folderPath, err := backupAssetFolder(cruds, tableName, column, tempDir)
+			if err != nil {
+				log.Errorf("Failed to get the files of [%v] for backup: %v", assetColumn, err)
+				summary.Skipped = append(summary.Skipped, assetColumn)
+				continue
+			}
+			assetFolders[assetColumn] = folderPath
+			summary.Manifest.AssetColumns = append(summary.Manifest.AssetColumns, assetColumn)
+		}
+	}
+
+	var archiveWriter io.Writer = writer
+	var encryptWriter io.WriteCloser
+	if password != "" {
+		encryptWriter, err = newBackupEncryptWriter(writer, password)
+		if err != nil {
+			return nil, err
+		}
+		archiveWriter = encryptWriter
+	}
+	gzipWriter := gzip.NewWriter(archiveWriter)
+	archive := tar.NewWriter(gzipWriter)
+
+	manifest, err := json.MarshalIndent(summary.Manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err = writeBackupEntry(archive, backupManifestEntry, int64(len(manifest)), summary.Manifest.CreatedAt, strings.NewReader(string(manifest))); err != nil {
+		return nil, err
+	}
+
+	for _, tableName := range summary.Manifest.Tables {
+		dumpPath := filepath.Join(tempDir, tableName+".ndjson")
+		rowCount, err := dumpBackupTable(db, tableName, backupTimeColumns(tables[tableName]), dumpPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to back up table [%v]: %v", tableName, err)
+		}
+		if err = writeBackupFile(archive, backupTableEntry(tableName), dumpPath); err != nil {
+			return nil, err
+		}
+		os.Remove(dumpPath)
+		summary.Rows += rowCount
+	}
+
+	for _, assetColumn := range summary.Manifest.AssetColumns {
+		folderPath := assetFolders[assetColumn]
+		entryFolder := path.Join(backupAssetsFolder, strings.Replace(assetColumn, ".", "/", 1))
+		err = filepath.Walk(folderPath, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			relativePath, err := filepath.Rel(folderPath, filePath)
+			if err != nil {
+				return err
+			}
+			summary.Files++
+			return writeBackupFile(archive, path.Join(entryFolder, filepath.ToSlash(relativePath)), filePath)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to back up the files of [%v]: %v", assetColumn, err)
+		}
+	}
+
+	if err = archive.Close(); err != nil {
+		return nil, err
+	}
+	if err = gzipWriter.Close(); err != nil {
+		return nil, err
+	}
+	if encryptWriter != nil {
+		if err = encryptWriter.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Infof("Backup of %d tables, %d rows and %d files", len(summary.Manifest.Tables), summary.Rows, summary.Files)
+	return summary, nil
+}
+
+// backupAssetFolder is the local folder with the files of the cloud store
+// folder of the column. When the server is not syncing the folder, it is
+// downloaded to a folder in tempDir.
+func backupAssetFolder(cruds map[string]*DbResource, tableName string, column api2go.ColumnInfo, tempDir string) (string, error) {
+
+	dbResource, ok := cruds[tableName]
+	if !ok {
+		dbResource = cruds["world"]
+	}
+
+	if columnCache, ok := dbResource.AssetFolderCache[tableName]; ok {
+		if folder, ok := columnCache[column.ColumnName]; ok && folder.LocalSyncPath != "" {
+			return folder.LocalSyncPath, nil
+		}
+	}
+
+	cloudStores, err := dbResource.GetAllCloudStores()
+	if err != nil {
+		return "", err
+	}
+	for _, cloudStore := range cloudStores {
+		if cloudStore.Name != column.ForeignKeyData.Namespace {
+			continue
+		}
+		folderPath := filepath.Join(tempDir, "assets", tableName, column.ColumnName)
+		if err = os.MkdirAll(folderPath, 0755); err != nil {
+			return "", err
+		}
+		return folderPath, dbResource.CopyFromCloudStore(cloudStore, column.ForeignKeyData.KeyName, folderPath)
+	}
+	return "", fmt.Errorf("no cloud store named [%v]", column.ForeignKeyData.Namespace)
+}
+
+// dumpBackupTable writes the rows of the table to a file, as the file has to
+// be complete to know its size in the archive
+func dumpBackupTable(db database.DatabaseConnection, tableName string, timeColumns map[string]bool, dumpPath string) (int, error) {
+
+	query, args, err := statementbuilder.Squirrel.Select("*").From(tableName).OrderBy("id").ToSql()
+	if err != nil {
+		return 0, err
+	}
+	rows, err := db.Queryx(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	header := backupTableHeader{
+		Table:       tableName,
+		Columns:     columns,
+		TimeColumns: make([]string, 0),
+	}
+	for _, column := range columns {
+		if timeColumns[column] {
+			header.TimeColumns = append(header.TimeColumns, column)
+		}
+	}
+
+	file, err := os.Create(dumpPath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	fileWriter := bufio.NewWriter(file)
+	encoder := json.NewEncoder(fileWriter)
+	if err = encoder.Encode(header); err != nil {
+		return 0, err
+	}
+
+	rowCount := 0
+	for rows.Next() {
+		values, err := rows.SliceScan()
+		if err != nil {
+			return rowCount, err
+		}
+		for i, value := range values {
+			switch typedValue := value.(type) {
+			case []byte:
+				values[i] = string(typedValue)
+			case time.Time:
+				values[i] = typedValue.UTC().Format(time.RFC3339Nano)
+			}
+		}
+		if err = encoder.Encode(values); err != nil {
+			return rowCount, err
+		}
+		rowCount++
+	}
+	if err = rows.Err(); err != nil {
+		return rowCount, err
+	}
+	return rowCount, fileWriter.Flush()
+}
+
+func writeBackupFile(archive *tar.Writer, name string, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return writeBackupEntry(archive, name, stat.Size(), stat.ModTime(), file)
+}
+
+func writeBackupEntry(archive *tar.Writer, name string, size int64, modTime time.Time, reader io.Reader) error {
+	err := archive.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(archive, reader)
+	return err
+}
+
+// RestoreBackup replaces the schema, the data, the config, the certificates
+// and the cloud store files of the instance with those of a backup written by
+// CreateBackup. Tables of the backup missing in the database are created
+// first. The data is restored in a single transaction, the server has to be
+// restarted to use the restored schema and config.
+func RestoreBackup(cruds map[string]*DbResource, reader io.Reader, password string) (*BackupSummary, error) {
+
+	worldResource, ok := cruds["world"]
+	if !ok {
+		return nil, fmt.Errorf("no world table to restore into")
+	}
+	db := worldResource.connection
+
+	bufferedReader := bufio.NewReader(reader)
+	var archiveReader io.Reader = bufferedReader
+	if IsEncryptedBackup(bufferedReader) {
+		if password == "" {
+			return nil, fmt.Errorf("the backup is encrypted, a password is needed to restore it")
+		}
+		decryptReader, err := newBackupDecryptReader(bufferedReader, password)
+		if err != nil {
+			return nil, err
+		}
+		archiveReader = decryptReader
+	}
+
+	tempDir, err := ioutil.TempDir("", "daptin-restore")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	// the archive is read in full before anything is changed, so a damaged
+	// backup is not half restored
+	if err = extractBackup(archiveReader, tempDir); err != nil {
+		return nil, err
+	}
+
+	summary := &BackupSummary{
+		Skipped: make([]string, 0),
+	}
+	manifest, err := ioutil.ReadFile(filepath.Join(tempDir, backupManifestEntry))
+	if err != nil {
+		return nil, fmt.Errorf("not a daptin backup, no manifest: %v", err)
+	}
+	if err = json.Unmarshal(manifest, &summary.Manifest); err != nil {
+		return nil, fmt.Errorf("invalid backup manifest: %v", err)
+	}
+	if summary.Manifest.Format != BackupFormat {
+		return nil, fmt.Errorf("not a daptin backup: %v", summary.Manifest.Format)
+	}
+	if summary.Manifest.Version > BackupFormatVersion {
+		return nil, fmt.Errorf("backup version %d is newer than the supported version %d", summary.Manifest.Version, BackupFormatVersion)
+	}
+	log.Infof("Restore backup of [%v] from %v", summary.Manifest.DatabaseType, summary.Manifest.CreatedAt)
+
+	// the schema and the data are restored in one transaction, a failed
+	// restore leaves the database as it was. Mysql commits changes to tables
+	// as they are made, they are kept there.
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	schemaTables, err := restoreBackupSchema(db, tx, filepath.Join(tempDir, filepath.FromSlash(backupSchemaEntry)))
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to restore the schema: %v", err)
+	}
+
+	if db.DriverName() == "mysql" {
+		if _, err = tx.Exec("SET FOREIGN_KEY_CHECKS = 0"); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	restoredTables := make([]string, 0)
+	for i := len(summary.Manifest.Tables) - 1; i >= 0; i-- {
+		tableName := summary.Manifest.Tables[i]
+		// only tables known to daptin or created from the schema of the
+		// backup are restored, the manifest is not trusted for table names
+		if _, ok := cruds[tableName]; !ok && !schemaTables[tableName] && tableName != settingsTableName {
+			log.Errorf("Table [%v] of the backup is not in the schema, not restored", tableName)
+			summary.Skipped = append(summary.Skipped, tableName)
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(tempDir, filepath.FromSlash(backupTableEntry(tableName)))); err != nil {
+			continue
+		}
+		query, args, err := statementbuilder.Squirrel.Delete(tableName).ToSql()
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		if _, err = tx.Exec(query, args...); err != nil {
+			log.Errorf("Failed to clear table [%v] to restore: %v", tableName, err)
+			summary.Skipped = append(summary.Skipped, tableName)
+			continue
+		}
+		restoredTables = append([]string{tableName}, restoredTables...)
+	}
+
+	for _, tableName := range restoredTables {
+		rowCount, err := restoreBackupTable(tx, tableName, filepath.Join(tempDir, filepath.FromSlash(backupTableEntry(tableName))))
+		if err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("failed to restore table [%v]: %v", tableName, err)
+		}
+		summary.Rows += rowCount
+	}
+
+	if db.DriverName() == "mysql" {
+		if _, err = tx.Exec("SET FOREIGN_KEY_CHECKS = 1"); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if db.DriverName() == "postgres" {
+		for _, tableName := range restoredTables {
+			quotedName := quoteBackupIdentifier(tableName)
+			_, err = db.Exec(fmt.Sprintf("SELECT setval(pg_get_serial_sequence('%v', 'id'), COALESCE(MAX(id), 1)) FROM %v",
+				strings.Replace(quotedName, "'", "''", -1), quotedName))
+			CheckErr(err, "Failed to reset the id sequence of [%v]", tableName)
+		}
+	}
+
+	for _, assetColumn := range summary.Manifest.AssetColumns {
+		files, err := restoreBackupAssets(cruds, assetColumn, filepath.Join(tempDir, backupAssetsFolder))
+		if err != nil {
+			log.Errorf("Failed to restore the files of [%v]: %v", assetColumn, err)
+			summary.Skipped = append(summary.Skipped, assetColumn)
+		}
+		summary.Files += files
+	}
+
+	log.Infof("Restored %d tables, %d rows and %d files", len(restoredTables), summary.Rows, summary.Files)
+	return summary, nil
+}
+
+// extractBackup writes the entries of the archive in folder, entries leading
+// out of the folder are rejected
+func extractBackup(reader io.Reader, folder string) error {
+
+	gzipReader, err := gzip.NewReader(reader)
+	if err == ErrBackupPassword {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("not a daptin backup: %v", err)
+	}
+	archive := tar.NewReader(gzipReader)
+
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("invalid file in backup: %v", header.Name)
+		}
+		filePath := filepath.Join(folder, filepath.FromSlash(name))
+		if err = os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return err
+		}
+		file, err := os.Create(filePath)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, archive)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readBackupTable calls rowHandler with the values of each row of the table
+// in the file, and the column names of the values
+func readBackupTable(filePath string, rowHandler func(header backupTableHeader, row []interface{}) error) error {
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	decoder.UseNumber()
+
+	var header backupTableHeader
+	if err = decoder.Decode(&header); err != nil {
+		return fmt.Errorf("invalid table header: %v", err)
+	}
+	timeColumns := make(map[int]bool)
+	for _, timeColumn := range header.TimeColumns {
+		for i, column := range header.Columns {
+			if column == timeColumn {
+				timeColumns[i] = true
+			}
+		}
+	}
+
+	for decoder.More() {
+		var row []interface{}
+		if err = decoder.Decode(&row); err != nil {
+			return err
+		}
+		if len(row) != len(header.Columns) {
+			return fmt.Errorf("expected %d values in a row, got %d", len(header.Columns), len(row))
+		}
+		for i, value := range row {
+			row[i] = restoreBackupValue(value, timeColumns[i])
+		}
+		if err = rowHandler(header, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreBackupValue converts a value read from json back to the value it was
+// read from the database as
+func restoreBackupValue(value interface{}, isTime bool) interface{} {
+	switch typedValue := value.(type) {
+	case interface {
+		Int64() (int64, error)
+		Float64() (float64, error)
+	}:
+		if number, err := typedValue.Int64(); err == nil {
+			return number
+		}
+		number, _ := typedValue.Float64()
+		return number
+	case string:
+		if !isTime {
+			return typedValue
+		}
+		timeValue, err := time.Parse(time.RFC3339Nano, typedValue)
+		if err != nil {
+			timeValue, err = dateparse.ParseAny(typedValue)
+		}
+		if err == nil {
+			return timeValue
+		}
+	}
+	return value
+}
+
+// quoteBackupIdentifier quotes the table name for postgres
+func quoteBackupIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// restoreBackupSchema creates the tables of the world rows of the backup
+// and the config table in the transaction when they are not in the database.
+// Join and audit tables are made again from the relations, as when the server
+// starts. The names of the tables of the schema are returned.
+func restoreBackupSchema(db database.DatabaseConnection, tx *sqlx.Tx, schemaPath string) (map[string]bool, error) {
+
+	tables := make([]TableInfo, 0)
+	tableNames := make(map[string]bool)
+	err := readBackupTable(schemaPath, func(header backupTableHeader, row []interface{}) error {
+		var tableName, schemaJson string
+		for i, column := range header.Columns {
+			switch column {
+			case "table_name":
+				tableName, _ = row[i].(string)
+			case "world_schema_json":
+				schemaJson, _ = row[i].(string)
+			}
+		}
+		if tableName == "" || strings.Contains(tableName, "_has_") || strings.HasSuffix(tableName, "_audit") {
+			return nil
+		}
+		if !backupTableNamePattern.MatchString(tableName) {
+			return fmt.Errorf("invalid table name [%v]", tableName)
+		}
+
+		var tableInfo TableInfo
+		if err := json.Unmarshal([]byte(schemaJson), &tableInfo); err != nil {
+			return fmt.Errorf("invalid schema of [%v]: %v", tableName, err)
+		}
+		for i, column := range tableInfo.Columns {
+			if column.Name == "" {
+				column.Name = column.ColumnName
+			} else if column.ColumnName == "" {
+				column.ColumnName = column.Name
+			}
+			tableInfo.Columns[i] = column
+		}
+		tableInfo.TableName = tableName
+		tables = append(tables, tableInfo)
+		tableNames[tableName] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, tableInfo := range StandardTables {
+		if !tableNames[tableInfo.TableName] {
+			tables = append(tables, tableInfo)
+		}
+	}
+
+	config := &CmsConfig{
+		Tables: tables,
+	}
+	CheckRelations(config)
+	CheckAuditTables(config)
+	CheckTranslationTables(config)
+
+	CheckAllTableStatus(config, db, tx)
+	CreateRelations(config, tx)
+
+	// the config table is not in the world table
+	query, args, err := statementbuilder.Squirrel.Select("count(*)").From(settingsTableName).ToSql()
+	if err != nil {
+		return nil, err
+	}
+	var count int
+	if err = db.QueryRowx(query, args...).Scan(&count); err != nil {
+		if _, err = tx.Exec(MakeCreateTableQuery(&ConfigTableStructure, db.DriverName())); err != nil {
+			return nil, fmt.Errorf("failed to create the config table: %v", err)
+		}
+	}
+
+	schemaTables := make(map[string]bool)
+	for _, tableInfo := range config.Tables {
+		schemaTables[tableInfo.TableName] = true
+	}
+	return schemaTables, nil
+}
+
+// restoreBackupTable inserts the rows of the table in the file with their
+// ids. Columns of the backup which the table does not have anymore are left
+// out.
+func restoreBackupTable(tx *sqlx.Tx, tableName string, filePath string) (int, error) {
+
+	query, args, err := statementbuilder.Squirrel.Select("*").From(tableName).Where("1 = 0").ToSql()
+	if err != nil {
+		return 0, err
+	}
+	rows, err := tx.Queryx(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	tableColumns, err := rows.Columns()
+	rows.Close()
+	if err != nil {
+		return 0, err
+	}
+	hasColumn := make(map[string]bool)
+	for _, column := range tableColumns {
+		hasColumn[column] = true
+	}
+
+	rowCount := 0
+	var columns []string
+	var columnIndexes []int
+	batch := make([][]interface{}, 0)
+
+	insertBatch := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		insert := statementbuilder.Squirrel.Insert(tableName).Columns(columns...)
+		for _, values := range batch {
+			insert = insert.Values(values...)
+		}
+		query, args, err := insert.ToSql()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(query, args...)
+		batch = batch[:0]
+		return err
+	}
+
+	err = readBackupTable(filePath, func(header backupTableHeader, row []interface{}) error {
+		if columns == nil {
+			columns = make([]string, 0)
+			columnIndexes = make([]int, 0)
+			for i, column := range header.Columns {
+				if hasColumn[column] {
+					columns = append(columns, column)
+					columnIndexes = append(columnIndexes, i)
+				} else {
+					log.Warnf("Column [%v] of [%v] in the backup is not in the table", column, tableName)
+				}
+			}
+			if len(columns) == 0 {
+				return fmt.Errorf("no column of the backup is in the table")
+			}
+		}
+
+		values := make([]interface{}, len(columnIndexes))
+		for i, index := range columnIndexes {
+			values[i] = row[index]
+		}
+		batch = append(batch, values)
+		rowCount++
+		if len(batch)*len(columns) >= backupInsertParameters {
+			return insertBatch()
+		}
+		return nil
+	})
+	if err != nil {
+		return rowCount, err
+	}
+	return rowCount, insertBatch()
+}
+
+// restoreBackupAssets uploads the files of the table.column in the backup to
+// the folder of its cloud store
+func restoreBackupAssets(cruds map[string]*DbResource, assetColumn string, assetsFolder string) (int, error) {
+
+	parts := strings.SplitN(assetColumn, ".", 2)
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid asset column")
+	}
+	tableName, columnName := parts[0], parts[1]
+	dbResource, ok := cruds[tableName]
+	if !ok {
+		return 0, fmt.Errorf("no such table")
+	}
+	column, ok := dbResource.tableInfo.GetColumnByName(columnName)
+	if !ok || column.ForeignKeyData.DataSource != "cloud_store" {
+		return 0, fmt.Errorf("not a cloud store column")
+	}
+
+	folderPath := filepath.Join(assetsFolder, tableName, columnName)
+	files := 0
+	err := filepath.Walk(folderPath, func(filePath string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files++
+		}
+		return err
+	})
+	if err != nil || files == 0 {
+		return 0, nil
+	}
+
+	cloudStores, err := dbResource.GetAllCloudStores()
+	if err != nil {
+		return 0, err
+	}
+	for _, cloudStore := range cloudStores {
+		if cloudStore.Name == column.ForeignKeyData.Namespace {
+			return files, dbResource.CopyToCloudStore(cloudStore, folderPath, column.ForeignKeyData.KeyName)
+		}
+	}
+	return 0, fmt.Errorf("no cloud store named [%v]", column.ForeignKeyData.Namespace)
+}
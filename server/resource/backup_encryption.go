@@ -0,0 +1,198 @@
+package resource
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/scrypt"
+	"io"
+)
+
+// backupEncryptionMagic starts an encrypted backup, it is followed by the
+// salt of the key and the sealed chunks of the archive
+const backupEncryptionMagic = "DAPTINE1"
+
+const backupSaltSize = 16
+
+// backupChunkSize is the size of the plain text sealed at a time, so a backup
+// is encrypted and decrypted as it is streamed
+const backupChunkSize = 64 * 1024
+
+// ErrBackupPassword is returned when an encrypted backup cannot be opened
+// with the password
+var ErrBackupPassword = errors.New("wrong password for the backup, or the backup is damaged")
+
+// backupCipher is the AES-GCM cipher of the key derived from the password
+func backupCipher(password string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(password), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// backupNonce is the number of the chunk, so chunks cannot be reordered
+func backupNonce(aead cipher.AEAD, counter uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], counter)
+	return nonce
+}
+
+// backupEncryptWriter seals the archive in chunks. Each chunk is written with
+// a flag marking the last one, so a truncated backup is detected.
+type backupEncryptWriter struct {
+	writer  io.Writer
+	aead    cipher.AEAD
+	buffer  []byte
+	counter uint64
+}
+
+func newBackupEncryptWriter(writer io.Writer, password string) (io.WriteCloser, error) {
+
+	salt := make([]byte, backupSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	aead, err := backupCipher(password, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = writer.Write([]byte(backupEncryptionMagic)); err != nil {
+		return nil, err
+	}
+	if _, err = writer.Write(salt); err != nil {
+		return nil, err
+	}
+	return &backupEncryptWriter{
+		writer: writer,
+		aead:   aead,
+		buffer: make([]byte, 0, backupChunkSize),
+	}, nil
+}
+
+func (w *backupEncryptWriter) Write(data []byte) (int, error) {
+	written := 0
+	for len(data) > 0 {
+		size := backupChunkSize - len(w.buffer)
+		if size > len(data) {
+			size = len(data)
+		}
+		w.buffer = append(w.buffer, data[:size]...)
+		data = data[size:]
+		written += size
+		if len(w.buffer) == backupChunkSize {
+			if err := w.seal(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close seals the rest of the archive as the last chunk
+func (w *backupEncryptWriter) Close() error {
+	return w.seal(true)
+}
+
+func (w *backupEncryptWriter) seal(last bool) error {
+
+	flag := []byte{0}
+	if last {
+		flag[0] = 1
+	}
+	sealed := w.aead.Seal(nil, backupNonce(w.aead, w.counter), w.buffer, flag)
+	w.counter++
+	w.buffer = w.buffer[:0]
+
+	header := make([]byte, 5)
+	header[0] = flag[0]
+	binary.BigEndian.PutUint32(header[1:], uint32(len(sealed)))
+	if _, err := w.writer.Write(header); err != nil {
+		return err
+	}
+	_, err := w.writer.Write(sealed)
+	return err
+}
+
+// backupDecryptReader opens the chunks of an encrypted backup
+type backupDecryptReader struct {
+	reader  io.Reader
+	aead    cipher.AEAD
+	plain   []byte
+	counter uint64
+	last    bool
+}
+
+func newBackupDecryptReader(reader io.Reader, password string) (io.Reader, error) {
+
+	header := make([]byte, len(backupEncryptionMagic)+backupSaltSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, fmt.Errorf("not an encrypted backup: %v", err)
+	}
+	if string(header[:len(backupEncryptionMagic)]) != backupEncryptionMagic {
+		return nil, fmt.Errorf("not an encrypted backup")
+	}
+	aead, err := backupCipher(password, header[len(backupEncryptionMagic):])
+	if err != nil {
+		return nil, err
+	}
+	return &backupDecryptReader{reader: reader, aead: aead}, nil
+}
+
+func (r *backupDecryptReader) Read(data []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.last {
+			return 0, io.EOF
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	read := copy(data, r.plain)
+	r.plain = r.plain[read:]
+	return read, nil
+}
+
+func (r *backupDecryptReader) open() error {
+
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r.reader, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("the backup is truncated")
+		}
+		return err
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > backupChunkSize+uint32(r.aead.Overhead()) || header[0] > 1 {
+		return ErrBackupPassword
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(r.reader, sealed); err != nil {
+		return fmt.Errorf("the backup is truncated")
+	}
+
+	plain, err := r.aead.Open(nil, backupNonce(r.aead, r.counter), sealed, header[:1])
+	if err != nil {
+		return ErrBackupPassword
+	}
+	r.counter++
+	r.plain = plain
+	r.last = header[0] == 1
+	return nil
+}
+
+// IsEncryptedBackup tells if the backup read by the reader is encrypted,
+// without consuming it
+func IsEncryptedBackup(reader *bufio.Reader) bool {
+	magic, err := reader.Peek(len(backupEncryptionMagic))
+	return err == nil && string(magic) == backupEncryptionMagic
+}
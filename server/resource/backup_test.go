@@ -0,0 +1,239 @@
+package resource
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"github.com/artpar/api2go"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// rewriteBackup copies the entries of a backup without a password, with the
+// contents changed by rewrite
+func rewriteBackup(t *testing.T, backup []byte, rewrite func(name string, contents []byte) []byte) []byte {
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(backup))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archiveReader := tar.NewReader(gzipReader)
+
+	rewritten := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(rewritten)
+	archive := tar.NewWriter(gzipWriter)
+	for {
+		header, err := archiveReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, err := ioutil.ReadAll(archiveReader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = rewrite(header.Name, contents)
+		if err = writeBackupEntry(archive, header.Name, int64(len(contents)), header.ModTime, bytes.NewReader(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return rewritten.Bytes()
+}
+
+// backupFixture is a database with users, a book table which is not one of
+// the standard tables, its world rows and a config value
+func backupFixture(t *testing.T) (map[string]*DbResource, func()) {
+
+	cruds, cleanup := newTestCruds(t)
+	db := cruds["world"].connection
+	insertTestUser(t, cruds, "author", "author@example.com")
+	insertTestUser(t, cruds, "editor", "editor@example.com")
+
+	bookTable := TableInfo{
+		TableName: "book",
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "title",
+				ColumnName: "title",
+				ColumnType: "label",
+				DataType:   "varchar(100)",
+			},
+			{
+				Name:       "published_at",
+				ColumnName: "published_at",
+				ColumnType: "datetime",
+				DataType:   "timestamp",
+				IsNullable: true,
+			},
+		},
+	}
+	config := &CmsConfig{
+		Tables: []TableInfo{bookTable},
+	}
+	tx := db.MustBegin()
+	CheckAllTableStatus(config, db, tx)
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	bookTable = config.Tables[0]
+	model := api2go.NewApi2GoModel(bookTable.TableName, bookTable.Columns, int64(bookTable.DefaultPermission), bookTable.Relations)
+	cruds["book"] = NewDbResource(model, db, &MiddlewareSet{}, cruds, nil, bookTable)
+
+	insertTestRow(t, cruds, "book", map[string]interface{}{
+		"title":        "first",
+		"published_at": time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	})
+	insertTestRow(t, cruds, "book", map[string]interface{}{
+		"title": "second",
+	})
+
+	worldConfig := &CmsConfig{}
+	for _, dbResource := range cruds {
+		worldConfig.Tables = append(worldConfig.Tables, *dbResource.tableInfo)
+	}
+	tx = db.MustBegin()
+	UpdateWorldTable(worldConfig, tx)
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	configStore, err := NewConfigStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = configStore.SetConfigValueFor("hostname", "backup.example.com", "backend"); err != nil {
+		t.Fatal(err)
+	}
+	return cruds, cleanup
+}
+
+func TestBackupAndRestore(t *testing.T) {
+
+	InitialiseColumnManager()
+	source, cleanupSource := backupFixture(t)
+	defer cleanupSource()
+
+	count := func(cruds map[string]*DbResource, query string) int {
+		var rowCount int
+		if err := cruds["world"].connection.QueryRowx(query).Scan(&rowCount); err != nil {
+			return -1
+		}
+		return rowCount
+	}
+
+	encrypted := &bytes.Buffer{}
+	summary, err := CreateBackup(source, encrypted, "secret")
+	if err != nil {
+		t.Fatalf("Failed to create backup: %v", err)
+	}
+	if summary.Rows == 0 || summary.Manifest.Version != BackupFormatVersion {
+		t.Errorf("Expected rows in the backup, got %+v", summary)
+	}
+	order := make(map[string]int)
+	for i, tableName := range summary.Manifest.Tables {
+		order[tableName] = i
+	}
+	if order[USER_ACCOUNT_TABLE_NAME] > order["world"] || order["usergroup"] > order["user_account_user_account_id_has_usergroup_usergroup_id"] {
+		t.Errorf("Expected tables to come after the tables they refer to, got %v", summary.Manifest.Tables)
+	}
+
+	destination, cleanupDestination := newTestCruds(t)
+	defer cleanupDestination()
+	if _, err = RestoreBackup(destination, bytes.NewReader(encrypted.Bytes()), "wrong"); err != ErrBackupPassword {
+		t.Errorf("Expected a wrong password to fail, got %v", err)
+	}
+	if _, err = RestoreBackup(destination, bytes.NewReader(encrypted.Bytes()[:encrypted.Len()/2]), "secret"); err == nil {
+		t.Errorf("Expected a truncated backup to fail")
+	}
+	if count(destination, "select count(*) from user_account") != 0 {
+		t.Errorf("Expected a failed restore to leave the database as it is")
+	}
+
+	plain := &bytes.Buffer{}
+	if _, err = CreateBackup(source, plain, ""); err != nil {
+		t.Fatalf("Failed to create backup: %v", err)
+	}
+	if IsEncryptedBackup(bufio.NewReader(bytes.NewReader(plain.Bytes()))) {
+		t.Errorf("Expected a backup without a password not to be encrypted")
+	}
+
+	// the schema is not kept when the data fails to restore
+	damaged := rewriteBackup(t, plain.Bytes(), func(name string, contents []byte) []byte {
+		if name == backupTableEntry("book") {
+			return append(contents, []byte("not a row\n")...)
+		}
+		return contents
+	})
+	if _, err = RestoreBackup(destination, bytes.NewReader(damaged), ""); err == nil {
+		t.Errorf("Expected a backup with a damaged table to fail")
+	}
+	if count(destination, "select count(*) from book") != -1 || count(destination, "select count(*) from world where table_name = 'book'") != 0 {
+		t.Errorf("Expected a failed restore not to create the tables of the backup")
+	}
+
+	// table names in the manifest which are not in the schema are skipped
+	unknownTable := `book"; drop table user_account; --`
+	tampered := rewriteBackup(t, plain.Bytes(), func(name string, contents []byte) []byte {
+		if name == backupManifestEntry {
+			var manifest BackupManifest
+			if err := json.Unmarshal(contents, &manifest); err != nil {
+				t.Fatal(err)
+			}
+			manifest.Tables = append(manifest.Tables, unknownTable)
+			contents, _ = json.Marshal(manifest)
+		}
+		return contents
+	})
+	summary, err = RestoreBackup(destination, bytes.NewReader(tampered), "")
+	if err != nil {
+		t.Fatalf("Failed to restore backup: %v", err)
+	}
+	if len(summary.Skipped) != 1 || summary.Skipped[0] != unknownTable {
+		t.Errorf("Expected the unknown table to be skipped, got %v", summary.Skipped)
+	}
+
+	// the book table is created from the schema in the backup
+	summary, err = RestoreBackup(destination, bytes.NewReader(encrypted.Bytes()), "secret")
+	if err != nil {
+		t.Fatalf("Failed to restore backup: %v", err)
+	}
+	if count(destination, "select count(*) from book") != 2 || count(destination, "select count(*) from user_account") != 2 {
+		t.Errorf("Expected the books and users to be restored")
+	}
+	if count(destination, "select count(*) from _config where name = 'hostname' and value = 'backup.example.com'") != 1 {
+		t.Errorf("Expected the config to be restored")
+	}
+	if count(destination, "select count(*) from world where table_name = 'book'") != 1 {
+		t.Errorf("Expected the schema to be restored")
+	}
+	var publishedAt time.Time
+	err = destination["world"].connection.QueryRowx("select published_at from book where title = 'first'").Scan(&publishedAt)
+	if err != nil || !publishedAt.Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("Expected the time of the book to be restored, got %v: %v", publishedAt, err)
+	}
+
+	db := source["world"].connection
+	if _, err = db.Exec("update user_account set name = 'changed' where email = 'author@example.com'"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec("delete from book where title = 'second'"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = RestoreBackup(source, bytes.NewReader(plain.Bytes()), ""); err != nil {
+		t.Fatalf("Failed to restore backup: %v", err)
+	}
+	if count(source, "select count(*) from book") != 2 || count(source, "select count(*) from user_account where name = 'author'") != 1 {
+		t.Errorf("Expected the changes after the backup to be undone")
+	}
+}
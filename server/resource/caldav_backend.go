@@ -0,0 +1,349 @@
+package resource
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/emersion/go-ical"
+	"github.com/emersion/go-webdav"
+	"github.com/emersion/go-webdav/caldav"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DaptinCalDavBackend serves the calendar and calendar_object tables over
+// CalDAV (RFC 4791). Each user sees the calendars they own and the calendars
+// shared with their usergroups at /caldav/<user reference id>/calendars/
+type DaptinCalDavBackend struct {
+	cruds  map[string]*DbResource
+	prefix string
+}
+
+func NewCalDavBackend(cruds map[string]*DbResource, prefix string) *DaptinCalDavBackend {
+	return &DaptinCalDavBackend{
+		cruds:  cruds,
+		prefix: strings.TrimSuffix(prefix, "/"),
+	}
+}
+
+func (be *DaptinCalDavBackend) CurrentUserPrincipal(ctx context.Context) (string, error) {
+	user, err := davSessionUser(ctx)
+	if err != nil {
+		return "", err
+	}
+	return be.prefix + "/" + user.UserReferenceId + "/", nil
+}
+
+func (be *DaptinCalDavBackend) CalendarHomeSetPath(ctx context.Context) (string, error) {
+	principal, err := be.CurrentUserPrincipal(ctx)
+	if err != nil {
+		return "", err
+	}
+	return principal + "calendars/", nil
+}
+
+func (be *DaptinCalDavBackend) calendarPath(user string, calendarName string) string {
+	return be.prefix + "/" + user + "/calendars/" + calendarName + "/"
+}
+
+// parsePath returns the session user and the parsed path, paths of other users
+// are not accessible
+func (be *DaptinCalDavBackend) parsePath(ctx context.Context, path string) (DavPath, error) {
+
+	user, err := davSessionUser(ctx)
+	if err != nil {
+		return DavPath{}, err
+	}
+
+	davPath := ParseDavPath(be.prefix, path)
+	if davPath.UserReferenceId != user.UserReferenceId {
+		return davPath, webdav.NewHTTPError(http.StatusNotFound, errors.New("no such calendar home"))
+	}
+	return davPath, nil
+}
+
+func (be *DaptinCalDavBackend) CreateCalendar(ctx context.Context, calendar *caldav.Calendar) error {
+
+	davPath, err := be.parsePath(ctx, calendar.Path)
+	if err != nil {
+		return err
+	}
+	user, _ := davSessionUser(ctx)
+
+	return be.cruds["calendar"].createDavCollection(ctx, user, "calendar", davPath.Collection, calendar.Name, calendar.Description)
+}
+
+func (be *DaptinCalDavBackend) ListCalendars(ctx context.Context) ([]caldav.Calendar, error) {
+
+	user, err := davSessionUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := be.cruds["calendar"].listDavCollections(user, "calendar")
+	if err != nil {
+		return nil, err
+	}
+
+	calendars := make([]caldav.Calendar, 0)
+	for _, row := range rows {
+		calendars = append(calendars, be.rowToCalendar(user.UserReferenceId, row))
+	}
+	return calendars, nil
+}
+
+func (be *DaptinCalDavBackend) GetCalendar(ctx context.Context, path string) (*caldav.Calendar, error) {
+
+	davPath, err := be.parsePath(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	user, _ := davSessionUser(ctx)
+
+	row, err := be.cruds["calendar"].getDavCollection(user, "calendar", davPath.Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	calendar := be.rowToCalendar(user.UserReferenceId, row)
+	return &calendar, nil
+}
+
+func (be *DaptinCalDavBackend) GetCalendarObject(ctx context.Context, path string, req *caldav.CalendarCompRequest) (*caldav.CalendarObject, error) {
+
+	davPath, err := be.parsePath(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	user, _ := davSessionUser(ctx)
+
+	calendar, err := be.cruds["calendar"].getDavCollection(user, "calendar", davPath.Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	row, err := be.cruds["calendar_object"].getDavObject("calendar_object", "calendar_id", calendar, davPath.Object)
+	if err != nil {
+		return nil, err
+	}
+	if row == nil {
+		return nil, webdav.NewHTTPError(http.StatusNotFound, fmt.Errorf("no such calendar object [%v]", davPath.Object))
+	}
+
+	return be.rowToCalendarObject(user.UserReferenceId, davPath.Collection, row)
+}
+
+func (be *DaptinCalDavBackend) ListCalendarObjects(ctx context.Context, path string, req *caldav.CalendarCompRequest) ([]caldav.CalendarObject, error) {
+
+	davPath, err := be.parsePath(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	user, _ := davSessionUser(ctx)
+
+	calendar, err := be.cruds["calendar"].getDavCollection(user, "calendar", davPath.Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := be.cruds["calendar_object"].getDavObjects("calendar_object", "calendar_id", calendar, "")
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make([]caldav.CalendarObject, 0)
+	for _, row := range rows {
+		object, err := be.rowToCalendarObject(user.UserReferenceId, davPath.Collection, row)
+		if err != nil {
+			log.Errorf("Failed to read calendar object [%v]: %v", row["reference_id"], err)
+			continue
+		}
+		objects = append(objects, *object)
+	}
+	return objects, nil
+}
+
+func (be *DaptinCalDavBackend) QueryCalendarObjects(ctx context.Context, path string, query *caldav.CalendarQuery) ([]caldav.CalendarObject, error) {
+
+	objects, err := be.ListCalendarObjects(ctx, path, &query.CompRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	return caldav.Filter(query, objects)
+}
+
+func (be *DaptinCalDavBackend) PutCalendarObject(ctx context.Context, path string, calendarData *ical.Calendar, opts *caldav.PutCalendarObjectOptions) (*caldav.CalendarObject, error) {
+
+	davPath, err := be.parsePath(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	user, _ := davSessionUser(ctx)
+
+	calendar, err := be.cruds["calendar"].getDavCollection(user, "calendar", davPath.Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	_, canWrite, _ := be.cruds["calendar"].davCollectionPermission(user, calendar)
+	if !canWrite {
+		return nil, webdav.NewHTTPError(http.StatusForbidden, errors.New("calendar is read only"))
+	}
+
+	data, err := calendarObjectData(calendarData)
+	if err != nil {
+		return nil, webdav.NewHTTPError(http.StatusBadRequest, err)
+	}
+	data["resource_name"] = davPath.Object
+
+	existing, err := be.cruds["calendar_object"].getDavObject("calendar_object", "calendar_id", calendar, davPath.Object)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts != nil {
+		err = davCheckConditions(existing, opts.IfMatch, opts.IfNoneMatch)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the uid of a calendar component must be unique within the calendar
+	sameUid, err := be.cruds["calendar_object"].GetAllObjectsWithWhere("calendar_object", squirrel.Eq{
+		"calendar_id": calendar["id"],
+		"uid":         data["uid"],
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range sameUid {
+		if row["resource_name"] != davPath.Object {
+			return nil, caldav.NewPreconditionError(caldav.PreconditionNoUIDConflict)
+		}
+	}
+
+	row, err := be.cruds["calendar_object"].saveDavObject(ctx, "calendar_object", "calendar_id", calendar, existing, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return be.rowToCalendarObject(user.UserReferenceId, davPath.Collection, row)
+}
+
+func (be *DaptinCalDavBackend) DeleteCalendarObject(ctx context.Context, path string) error {
+
+	davPath, err := be.parsePath(ctx, path)
+	if err != nil {
+		return err
+	}
+	user, _ := davSessionUser(ctx)
+
+	calendar, err := be.cruds["calendar"].getDavCollection(user, "calendar", davPath.Collection)
+	if err != nil {
+		return err
+	}
+
+	// DELETE on the calendar itself removes the whole calendar
+	if davPath.Object == "" {
+		return be.cruds["calendar"].deleteDavCollection(ctx, user, "calendar", "calendar_object", "calendar_id", calendar)
+	}
+
+	_, canWrite, _ := be.cruds["calendar"].davCollectionPermission(user, calendar)
+	if !canWrite {
+		return webdav.NewHTTPError(http.StatusForbidden, errors.New("calendar is read only"))
+	}
+
+	row, err := be.cruds["calendar_object"].getDavObject("calendar_object", "calendar_id", calendar, davPath.Object)
+	if err != nil {
+		return err
+	}
+	if row == nil {
+		return webdav.NewHTTPError(http.StatusNotFound, fmt.Errorf("no such calendar object [%v]", davPath.Object))
+	}
+
+	return be.cruds["calendar_object"].DeleteWithoutFilters(row["reference_id"].(string), davRequest(ctx, "DELETE"))
+}
+
+func (be *DaptinCalDavBackend) rowToCalendar(user string, row map[string]interface{}) caldav.Calendar {
+	return caldav.Calendar{
+		Path:                  be.calendarPath(user, davCollectionName(user, row)),
+		Name:                  davString(row["name"]),
+		Description:           davString(row["description"]),
+		SupportedComponentSet: []string{ical.CompEvent, ical.CompToDo, ical.CompJournal},
+	}
+}
+
+func (be *DaptinCalDavBackend) rowToCalendarObject(user string, calendarName string, row map[string]interface{}) (*caldav.CalendarObject, error) {
+
+	content := davString(row["content"])
+	calendarData, err := ical.NewDecoder(strings.NewReader(content)).Decode()
+	if err != nil {
+		return nil, err
+	}
+
+	return &caldav.CalendarObject{
+		Path:          be.calendarPath(user, calendarName) + davString(row["resource_name"]),
+		ModTime:       davModTime(row),
+		ContentLength: int64(len(content)),
+		ETag:          davETag(content),
+		Data:          calendarData,
+	}, nil
+}
+
+// calendarObjectData extracts the columns stored on the calendar_object table
+// from the first component of the calendar which is not a timezone
+func calendarObjectData(calendarData *ical.Calendar) (map[string]interface{}, error) {
+
+	var component *ical.Component
+	for _, child := range calendarData.Children {
+		if child.Name != ical.CompTimezone {
+			component = child
+			break
+		}
+	}
+	if component == nil {
+		return nil, errors.New("calendar has no component")
+	}
+
+	uid, err := component.Props.Text(ical.PropUID)
+	if err != nil || uid == "" {
+		return nil, errors.New("calendar component has no uid")
+	}
+	summary, _ := component.Props.Text(ical.PropSummary)
+
+	var buffer bytes.Buffer
+	err = ical.NewEncoder(&buffer).Encode(calendarData)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]interface{}{
+		"uid":            uid,
+		"component_type": component.Name,
+		"summary":        summary,
+		"content":        buffer.String(),
+		"start_time":     nil,
+		"end_time":       nil,
+	}
+
+	startTime, err := component.Props.DateTime(ical.PropDateTimeStart, time.UTC)
+	if err == nil && !startTime.IsZero() {
+		data["start_time"] = startTime
+	}
+
+	endProperty := ical.PropDateTimeEnd
+	if component.Name == ical.CompToDo {
+		endProperty = ical.PropDue
+	}
+	endTime, err := component.Props.DateTime(endProperty, time.UTC)
+	if err == nil && !endTime.IsZero() {
+		data["end_time"] = endTime
+	}
+
+	return data, nil
+}
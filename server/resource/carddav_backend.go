@@ -0,0 +1,318 @@
+package resource
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/emersion/go-vcard"
+	"github.com/emersion/go-webdav"
+	"github.com/emersion/go-webdav/carddav"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+)
+
+// DaptinCardDavBackend serves the address_book and contact_card tables over
+// CardDAV (RFC 6352). Each user sees the address books they own and the address
+// books shared with their usergroups at /carddav/<user reference id>/contacts/
+type DaptinCardDavBackend struct {
+	cruds  map[string]*DbResource
+	prefix string
+}
+
+func NewCardDavBackend(cruds map[string]*DbResource, prefix string) *DaptinCardDavBackend {
+	return &DaptinCardDavBackend{
+		cruds:  cruds,
+		prefix: strings.TrimSuffix(prefix, "/"),
+	}
+}
+
+func (be *DaptinCardDavBackend) CurrentUserPrincipal(ctx context.Context) (string, error) {
+	user, err := davSessionUser(ctx)
+	if err != nil {
+		return "", err
+	}
+	return be.prefix + "/" + user.UserReferenceId + "/", nil
+}
+
+func (be *DaptinCardDavBackend) AddressBookHomeSetPath(ctx context.Context) (string, error) {
+	principal, err := be.CurrentUserPrincipal(ctx)
+	if err != nil {
+		return "", err
+	}
+	return principal + "contacts/", nil
+}
+
+func (be *DaptinCardDavBackend) addressBookPath(user string, addressBookName string) string {
+	return be.prefix + "/" + user + "/contacts/" + addressBookName + "/"
+}
+
+// parsePath returns the parsed path, paths of other users are not accessible
+func (be *DaptinCardDavBackend) parsePath(ctx context.Context, path string) (DavPath, error) {
+
+	user, err := davSessionUser(ctx)
+	if err != nil {
+		return DavPath{}, err
+	}
+
+	davPath := ParseDavPath(be.prefix, path)
+	if davPath.UserReferenceId != user.UserReferenceId {
+		return davPath, webdav.NewHTTPError(http.StatusNotFound, errors.New("no such address book home"))
+	}
+	return davPath, nil
+}
+
+func (be *DaptinCardDavBackend) ListAddressBooks(ctx context.Context) ([]carddav.AddressBook, error) {
+
+	user, err := davSessionUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := be.cruds["address_book"].listDavCollections(user, "address_book")
+	if err != nil {
+		return nil, err
+	}
+
+	addressBooks := make([]carddav.AddressBook, 0)
+	for _, row := range rows {
+		addressBooks = append(addressBooks, be.rowToAddressBook(user.UserReferenceId, row))
+	}
+	return addressBooks, nil
+}
+
+func (be *DaptinCardDavBackend) GetAddressBook(ctx context.Context, path string) (*carddav.AddressBook, error) {
+
+	davPath, err := be.parsePath(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	user, _ := davSessionUser(ctx)
+
+	row, err := be.cruds["address_book"].getDavCollection(user, "address_book", davPath.Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	addressBook := be.rowToAddressBook(user.UserReferenceId, row)
+	return &addressBook, nil
+}
+
+func (be *DaptinCardDavBackend) CreateAddressBook(ctx context.Context, addressBook *carddav.AddressBook) error {
+
+	davPath, err := be.parsePath(ctx, addressBook.Path)
+	if err != nil {
+		return err
+	}
+	user, _ := davSessionUser(ctx)
+
+	return be.cruds["address_book"].createDavCollection(ctx, user, "address_book", davPath.Collection, addressBook.Name, addressBook.Description)
+}
+
+func (be *DaptinCardDavBackend) DeleteAddressBook(ctx context.Context, path string) error {
+
+	davPath, err := be.parsePath(ctx, path)
+	if err != nil {
+		return err
+	}
+	user, _ := davSessionUser(ctx)
+
+	addressBook, err := be.cruds["address_book"].getDavCollection(user, "address_book", davPath.Collection)
+	if err != nil {
+		return err
+	}
+
+	return be.cruds["address_book"].deleteDavCollection(ctx, user, "address_book", "contact_card", "address_book_id", addressBook)
+}
+
+func (be *DaptinCardDavBackend) GetAddressObject(ctx context.Context, path string, req *carddav.AddressDataRequest) (*carddav.AddressObject, error) {
+
+	davPath, err := be.parsePath(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	user, _ := davSessionUser(ctx)
+
+	addressBook, err := be.cruds["address_book"].getDavCollection(user, "address_book", davPath.Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	row, err := be.cruds["contact_card"].getDavObject("contact_card", "address_book_id", addressBook, davPath.Object)
+	if err != nil {
+		return nil, err
+	}
+	if row == nil {
+		return nil, webdav.NewHTTPError(http.StatusNotFound, fmt.Errorf("no such contact card [%v]", davPath.Object))
+	}
+
+	return be.rowToAddressObject(user.UserReferenceId, davPath.Collection, row)
+}
+
+func (be *DaptinCardDavBackend) ListAddressObjects(ctx context.Context, path string, req *carddav.AddressDataRequest) ([]carddav.AddressObject, error) {
+
+	davPath, err := be.parsePath(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	user, _ := davSessionUser(ctx)
+
+	addressBook, err := be.cruds["address_book"].getDavCollection(user, "address_book", davPath.Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := be.cruds["contact_card"].getDavObjects("contact_card", "address_book_id", addressBook, "")
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make([]carddav.AddressObject, 0)
+	for _, row := range rows {
+		object, err := be.rowToAddressObject(user.UserReferenceId, davPath.Collection, row)
+		if err != nil {
+			log.Errorf("Failed to read contact card [%v]: %v", row["reference_id"], err)
+			continue
+		}
+		objects = append(objects, *object)
+	}
+	return objects, nil
+}
+
+func (be *DaptinCardDavBackend) QueryAddressObjects(ctx context.Context, path string, query *carddav.AddressBookQuery) ([]carddav.AddressObject, error) {
+
+	objects, err := be.ListAddressObjects(ctx, path, &query.DataRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	return carddav.Filter(query, objects)
+}
+
+func (be *DaptinCardDavBackend) PutAddressObject(ctx context.Context, path string, card vcard.Card, opts *carddav.PutAddressObjectOptions) (*carddav.AddressObject, error) {
+
+	davPath, err := be.parsePath(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	user, _ := davSessionUser(ctx)
+
+	addressBook, err := be.cruds["address_book"].getDavCollection(user, "address_book", davPath.Collection)
+	if err != nil {
+		return nil, err
+	}
+
+	_, canWrite, _ := be.cruds["address_book"].davCollectionPermission(user, addressBook)
+	if !canWrite {
+		return nil, webdav.NewHTTPError(http.StatusForbidden, errors.New("address book is read only"))
+	}
+
+	data, err := contactCardData(card)
+	if err != nil {
+		return nil, webdav.NewHTTPError(http.StatusBadRequest, err)
+	}
+	data["resource_name"] = davPath.Object
+
+	existing, err := be.cruds["contact_card"].getDavObject("contact_card", "address_book_id", addressBook, davPath.Object)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts != nil {
+		err = davCheckConditions(existing, opts.IfMatch, opts.IfNoneMatch)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	row, err := be.cruds["contact_card"].saveDavObject(ctx, "contact_card", "address_book_id", addressBook, existing, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return be.rowToAddressObject(user.UserReferenceId, davPath.Collection, row)
+}
+
+func (be *DaptinCardDavBackend) DeleteAddressObject(ctx context.Context, path string) error {
+
+	davPath, err := be.parsePath(ctx, path)
+	if err != nil {
+		return err
+	}
+	user, _ := davSessionUser(ctx)
+
+	addressBook, err := be.cruds["address_book"].getDavCollection(user, "address_book", davPath.Collection)
+	if err != nil {
+		return err
+	}
+
+	_, canWrite, _ := be.cruds["address_book"].davCollectionPermission(user, addressBook)
+	if !canWrite {
+		return webdav.NewHTTPError(http.StatusForbidden, errors.New("address book is read only"))
+	}
+
+	row, err := be.cruds["contact_card"].getDavObject("contact_card", "address_book_id", addressBook, davPath.Object)
+	if err != nil {
+		return err
+	}
+	if row == nil {
+		return webdav.NewHTTPError(http.StatusNotFound, fmt.Errorf("no such contact card [%v]", davPath.Object))
+	}
+
+	return be.cruds["contact_card"].DeleteWithoutFilters(row["reference_id"].(string), davRequest(ctx, "DELETE"))
+}
+
+func (be *DaptinCardDavBackend) rowToAddressBook(user string, row map[string]interface{}) carddav.AddressBook {
+	return carddav.AddressBook{
+		Path:        be.addressBookPath(user, davCollectionName(user, row)),
+		Name:        davString(row["name"]),
+		Description: davString(row["description"]),
+		SupportedAddressData: []carddav.AddressDataType{
+			{ContentType: vcard.MIMEType, Version: "3.0"},
+			{ContentType: vcard.MIMEType, Version: "4.0"},
+		},
+	}
+}
+
+func (be *DaptinCardDavBackend) rowToAddressObject(user string, addressBookName string, row map[string]interface{}) (*carddav.AddressObject, error) {
+
+	content := davString(row["content"])
+	card, err := vcard.NewDecoder(strings.NewReader(content)).Decode()
+	if err != nil {
+		return nil, err
+	}
+
+	return &carddav.AddressObject{
+		Path:          be.addressBookPath(user, addressBookName) + davString(row["resource_name"]),
+		ModTime:       davModTime(row),
+		ContentLength: int64(len(content)),
+		ETag:          davETag(content),
+		Card:          card,
+	}, nil
+}
+
+// contactCardData extracts the columns stored on the contact_card table from
+// the vcard
+func contactCardData(card vcard.Card) (map[string]interface{}, error) {
+
+	uid := card.Value(vcard.FieldUID)
+	if uid == "" {
+		return nil, errors.New("contact card has no uid")
+	}
+
+	var buffer bytes.Buffer
+	err := vcard.NewEncoder(&buffer).Encode(card)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"uid":          uid,
+		"full_name":    card.PreferredValue(vcard.FieldFormattedName),
+		"email":        card.PreferredValue(vcard.FieldEmail),
+		"phone":        card.PreferredValue(vcard.FieldTelephone),
+		"organization": card.PreferredValue(vcard.FieldOrganization),
+		"content":      buffer.String(),
+	}, nil
+}
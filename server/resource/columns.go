@@ -83,6 +83,9 @@ var StandardRelations = []api2go.TableRelation{
 	api2go.NewTableRelation("mail_account", "belongs_to", "mail_server"),
 	api2go.NewTableRelation("mail_box", "belongs_to", "mail_account"),
 	api2go.NewTableRelation("mail", "belongs_to", "mail_box"),
+	api2go.NewTableRelation("mail_rule", "has_one", "cloud_store"),
+	api2go.NewTableRelation("calendar_object", "belongs_to", "calendar"),
+	api2go.NewTableRelation("contact_card", "belongs_to", "address_book"),
 	api2go.NewTableRelationWithNames("task", "task_executed", "has_one", USER_ACCOUNT_TABLE_NAME, "as_user_id"),
 }
 
@@ -611,6 +614,11 @@ var SystemActions = []Action{
 				ColumnName: "truncate_before_insert",
 				ColumnType: "truefalse",
 			},
+			{
+				Name:       "upsert",
+				ColumnName: "upsert",
+				ColumnType: "truefalse",
+			},
 		},
 		OutFields: []Outcome{
 			{
@@ -619,6 +627,7 @@ var SystemActions = []Action{
 				Attributes: map[string]interface{}{
 					"world_reference_id":     "$.reference_id",
 					"truncate_before_insert": "~truncate_before_insert",
+					"upsert":                 "~upsert",
 					"dump_file":              "~dump_file",
 					"table_name":             "$.table_name",
 					"user":                   "~user",
@@ -775,6 +784,163 @@ var SystemActions = []Action{
 			},
 		},
 	},
+	{
+		Name:             "import_rows",
+		Label:            "Import rows from csv or xlsx",
+		OnType:           "world",
+		InstanceOptional: true,
+		InFields: []api2go.ColumnInfo{
+			{
+				Name:       "Data file",
+				ColumnName: "data_file",
+				ColumnType: "file.csv|xlsx",
+				IsNullable: false,
+			},
+			{
+				Name:       "Table name",
+				ColumnName: "table_name",
+				ColumnType: "label",
+				IsNullable: false,
+			},
+			{
+				Name:       "Column mapping",
+				ColumnName: "mapping",
+				ColumnType: "json",
+				IsNullable: true,
+			},
+			{
+				Name:       "Mode",
+				ColumnName: "mode",
+				ColumnType: "label",
+				IsNullable: true,
+			},
+			{
+				Name:       "Relation keys",
+				ColumnName: "relation_keys",
+				ColumnType: "json",
+				IsNullable: true,
+			},
+			{
+				Name:       "Dry run",
+				ColumnName: "dry_run",
+				ColumnType: "truefalse",
+				IsNullable: true,
+			},
+			{
+				Name:       "Batch size",
+				ColumnName: "batch_size",
+				ColumnType: "measurement",
+				IsNullable: true,
+			},
+		},
+		Validations: []ColumnTag{
+			{
+				ColumnName: "table_name",
+				Tags:       "required",
+			},
+			{
+				ColumnName: "mode",
+				Tags:       "omitempty,oneof=insert upsert skip",
+			},
+		},
+		OutFields: []Outcome{
+			{
+				Type:   "__import_rows",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"data_file":     "~data_file",
+					"table_name":    "~table_name",
+					"mapping":       "~mapping",
+					"mode":          "~mode",
+					"relation_keys": "~relation_keys",
+					"dry_run":       "~dry_run",
+					"batch_size":    "~batch_size",
+					"user":          "~user",
+				},
+			},
+		},
+	},
+	{
+		Name:             "backup",
+		Label:            "Backup the instance",
+		OnType:           "world",
+		InstanceOptional: true,
+		InFields: []api2go.ColumnInfo{
+			{
+				Name:       "Cloud store name",
+				ColumnName: "cloud_store_name",
+				ColumnType: "label",
+				IsNullable: false,
+			},
+			{
+				Name:       "Path",
+				ColumnName: "path",
+				ColumnType: "label",
+				IsNullable: true,
+			},
+			{
+				Name:       "Password",
+				ColumnName: "password",
+				ColumnType: "password",
+				IsNullable: true,
+			},
+		},
+		OutFields: []Outcome{
+			{
+				Type:   "__backup",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"cloud_store_name": "~cloud_store_name",
+					"path":             "~path",
+					"password":         "~password",
+				},
+			},
+		},
+	},
+	{
+		Name:             "restore",
+		Label:            "Restore the instance from a backup",
+		OnType:           "world",
+		InstanceOptional: true,
+		InFields: []api2go.ColumnInfo{
+			{
+				Name:       "Backup file",
+				ColumnName: "backup_file",
+				ColumnType: "file.*",
+				IsNullable: true,
+			},
+			{
+				Name:       "Cloud store name",
+				ColumnName: "cloud_store_name",
+				ColumnType: "label",
+				IsNullable: true,
+			},
+			{
+				Name:       "Path",
+				ColumnName: "path",
+				ColumnType: "label",
+				IsNullable: true,
+			},
+			{
+				Name:       "Password",
+				ColumnName: "password",
+				ColumnType: "password",
+				IsNullable: true,
+			},
+		},
+		OutFields: []Outcome{
+			{
+				Type:   "__restore",
+				Method: "EXECUTE",
+				Attributes: map[string]interface{}{
+					"backup_file":      "~backup_file",
+					"cloud_store_name": "~cloud_store_name",
+					"path":             "~path",
+					"password":         "~password",
+				},
+			},
+		},
+	},
 	{
 		Name:             "download_system_schema",
 		Label:            "Download system schema",
@@ -1480,6 +1646,59 @@ var StandardTables = []TableInfo{
 			},
 		},
 	},
+	{
+		TableName:     "idempotency_key",
+		Icon:          "fa-key",
+		DefaultGroups: adminsGroup,
+		IsHidden:      true,
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "key_hash",
+				ColumnName: "key_hash",
+				ColumnType: "label",
+				DataType:   "varchar(50)",
+				IsIndexed:  true,
+				IsUnique:   true,
+				IsNullable: false,
+			},
+			{
+				Name:       "request_hash",
+				ColumnName: "request_hash",
+				ColumnType: "label",
+				DataType:   "varchar(50)",
+				IsNullable: false,
+			},
+			{
+				Name:         "response_status",
+				ColumnName:   "response_status",
+				ColumnType:   "measurement",
+				DataType:     "int(11)",
+				DefaultValue: "0",
+			},
+			{
+				Name:       "response_content_type",
+				ColumnName: "response_content_type",
+				ColumnType: "label",
+				DataType:   "varchar(100)",
+				IsNullable: true,
+			},
+			{
+				Name:       "response_body",
+				ColumnName: "response_body",
+				ColumnType: "content",
+				DataType:   "text",
+				IsNullable: true,
+			},
+			{
+				Name:       "expires_at",
+				ColumnName: "expires_at",
+				ColumnType: "datetime",
+				DataType:   "timestamp",
+				IsIndexed:  true,
+				IsNullable: false,
+			},
+		},
+	},
 	{
 		TableName:     "timeline",
 		Icon:          "fa-clock-o",
@@ -1686,6 +1905,13 @@ var StandardTables = []TableInfo{
 				IsNullable:   false,
 				DefaultValue: "false",
 			},
+			{
+				Name:       "ssh_public_keys",
+				ColumnName: "ssh_public_keys",
+				DataType:   "text",
+				ColumnType: "content",
+				IsNullable: true,
+			},
 		},
 		Validations: []ColumnTag{
 			{
@@ -2162,6 +2388,20 @@ var StandardTables = []TableInfo{
 				ColumnName: "password_md5",
 				ColumnType: "md5-bcrypt",
 			},
+			{
+				Name:         "quota_storage",
+				ColumnName:   "quota_storage",
+				DataType:     "int(11)",
+				ColumnType:   "measurement",
+				DefaultValue: "0",
+			},
+			{
+				Name:         "quota_messages",
+				ColumnName:   "quota_messages",
+				DataType:     "int(11)",
+				ColumnType:   "measurement",
+				DefaultValue: "0",
+			},
 		},
 	},
 	{
@@ -2196,6 +2436,13 @@ var StandardTables = []TableInfo{
 				ColumnType:   "value",
 				DefaultValue: "1",
 			},
+			{
+				Name:         "highestmodseq",
+				ColumnName:   "highestmodseq",
+				DataType:     "int(11)",
+				ColumnType:   "value",
+				DefaultValue: "1",
+			},
 			{
 				Name:       "attributes",
 				ColumnName: "attributes",
@@ -2373,6 +2620,235 @@ var StandardTables = []TableInfo{
 				ColumnType:   "label",
 				DefaultValue: "",
 			},
+			{
+				Name:         "modseq",
+				ColumnName:   "modseq",
+				DataType:     "int(11)",
+				ColumnType:   "value",
+				DefaultValue: "1",
+			},
+		},
+	},
+	{
+		TableName:     "mail_rule",
+		IsHidden:      true,
+		DefaultGroups: adminsGroup,
+		Icon:          "fa-filter",
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "name",
+				ColumnName: "name",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+			},
+			{
+				Name:         "recipient_pattern",
+				ColumnName:   "recipient_pattern",
+				DataType:     "varchar(500)",
+				ColumnType:   "label",
+				DefaultValue: "''",
+			},
+			{
+				Name:         "subject_pattern",
+				ColumnName:   "subject_pattern",
+				DataType:     "varchar(500)",
+				ColumnType:   "label",
+				DefaultValue: "''",
+			},
+			{
+				Name:       "action_name",
+				ColumnName: "action_name",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "entity_name",
+				ColumnName: "entity_name",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+			},
+			{
+				Name:       "attributes",
+				ColumnName: "attributes",
+				DataType:   "text",
+				ColumnType: "json",
+				IsNullable: true,
+			},
+			{
+				Name:         "attachment_path",
+				ColumnName:   "attachment_path",
+				DataType:     "varchar(500)",
+				ColumnType:   "label",
+				DefaultValue: "''",
+			},
+			{
+				Name:         "is_enabled",
+				ColumnName:   "is_enabled",
+				DataType:     "int(1)",
+				ColumnType:   "truefalse",
+				DefaultValue: "1",
+			},
+		},
+	},
+	{
+		TableName: "calendar",
+		Icon:      "fa-calendar",
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "name",
+				ColumnName: "name",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+			},
+			{
+				Name:         "description",
+				ColumnName:   "description",
+				DataType:     "varchar(500)",
+				ColumnType:   "label",
+				DefaultValue: "''",
+			},
+			{
+				Name:       "resource_name",
+				ColumnName: "resource_name",
+				DataType:   "varchar(200)",
+				ColumnType: "label",
+				IsIndexed:  true,
+				IsNullable: true,
+			},
+		},
+	},
+	{
+		TableName: "calendar_object",
+		Icon:      "fa-calendar-o",
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "resource_name",
+				ColumnName: "resource_name",
+				DataType:   "varchar(200)",
+				ColumnType: "label",
+				IsIndexed:  true,
+			},
+			{
+				Name:       "uid",
+				ColumnName: "uid",
+				DataType:   "varchar(200)",
+				ColumnType: "label",
+				IsIndexed:  true,
+			},
+			{
+				Name:       "component_type",
+				ColumnName: "component_type",
+				DataType:   "varchar(20)",
+				ColumnType: "label",
+			},
+			{
+				Name:         "summary",
+				ColumnName:   "summary",
+				DataType:     "varchar(500)",
+				ColumnType:   "label",
+				DefaultValue: "''",
+			},
+			{
+				Name:       "start_time",
+				ColumnName: "start_time",
+				DataType:   "timestamp",
+				ColumnType: "datetime",
+				IsNullable: true,
+			},
+			{
+				Name:       "end_time",
+				ColumnName: "end_time",
+				DataType:   "timestamp",
+				ColumnType: "datetime",
+				IsNullable: true,
+			},
+			{
+				Name:       "content",
+				ColumnName: "content",
+				DataType:   "text",
+				ColumnType: "content",
+			},
+		},
+	},
+	{
+		TableName: "address_book",
+		Icon:      "fa-address-book",
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "name",
+				ColumnName: "name",
+				DataType:   "varchar(100)",
+				ColumnType: "label",
+			},
+			{
+				Name:         "description",
+				ColumnName:   "description",
+				DataType:     "varchar(500)",
+				ColumnType:   "label",
+				DefaultValue: "''",
+			},
+			{
+				Name:       "resource_name",
+				ColumnName: "resource_name",
+				DataType:   "varchar(200)",
+				ColumnType: "label",
+				IsIndexed:  true,
+				IsNullable: true,
+			},
+		},
+	},
+	{
+		TableName: "contact_card",
+		Icon:      "fa-address-card",
+		Columns: []api2go.ColumnInfo{
+			{
+				Name:       "resource_name",
+				ColumnName: "resource_name",
+				DataType:   "varchar(200)",
+				ColumnType: "label",
+				IsIndexed:  true,
+			},
+			{
+				Name:       "uid",
+				ColumnName: "uid",
+				DataType:   "varchar(200)",
+				ColumnType: "label",
+				IsIndexed:  true,
+			},
+			{
+				Name:         "full_name",
+				ColumnName:   "full_name",
+				DataType:     "varchar(200)",
+				ColumnType:   "label",
+				DefaultValue: "''",
+			},
+			{
+				Name:         "email",
+				ColumnName:   "email",
+				DataType:     "varchar(200)",
+				ColumnType:   "label",
+				DefaultValue: "''",
+			},
+			{
+				Name:         "phone",
+				ColumnName:   "phone",
+				DataType:     "varchar(50)",
+				ColumnType:   "label",
+				DefaultValue: "''",
+			},
+			{
+				Name:         "organization",
+				ColumnName:   "organization",
+				DataType:     "varchar(200)",
+				ColumnType:   "label",
+				DefaultValue: "''",
+			},
+			{
+				Name:       "content",
+				ColumnName: "content",
+				DataType:   "text",
+				ColumnType: "content",
+			},
 		},
 	},
 }
@@ -2462,6 +2938,10 @@ type TableInfo struct {
 	Conformations          []ColumnTag
 	DefaultOrder           string
 	Icon                   string
+	SearchableColumns      []string
+	JsonIndexes            []string
+	UpsertKeys             []string
+	ComputedColumns        []ComputedColumn
 }
 
 func (ti *TableInfo) GetColumnByName(name string) (*api2go.ColumnInfo, bool) {
@@ -0,0 +1,174 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/dop251/goja"
+	log "github.com/sirupsen/logrus"
+	"regexp"
+	"time"
+)
+
+// ComputedColumn is a column of a table which is not stored. Its value is
+// computed when the rows are selected, by the sql expression for the database
+// in Sql, or by the javascript expression in Js after the row is fetched. Sql
+// is keyed by the database, sqlite3, mysql or postgres, with default used for
+// the others. Js has the row as `row`, like row.first_name + " " + row.last_name.
+// Only the sql computed columns can be sorted and filtered on.
+type ComputedColumn struct {
+	Name        string
+	ColumnType  string
+	Description string
+	Sql         map[string]string
+	Js          string
+	// program is Js compiled by CheckComputedColumns
+	program *goja.Program
+}
+
+// computed column names are used as sql aliases
+var computedColumnNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// computedColumnTimeout is how long a javascript computed column can run for
+// a row before it is interrupted
+const computedColumnTimeout = 100 * time.Millisecond
+
+// GetColumnType is the column type of the value, label when it is not set
+func (cc ComputedColumn) GetColumnType() string {
+	if cc.ColumnType == "" {
+		return "label"
+	}
+	return cc.ColumnType
+}
+
+// SqlExpression returns the expression for the database, false when the column
+// is computed in javascript or there is no expression for the database
+func (cc ComputedColumn) SqlExpression(driverName string) (string, bool) {
+	if expression, ok := cc.Sql[driverName]; ok && expression != "" {
+		return expression, true
+	}
+	expression, ok := cc.Sql["default"]
+	return expression, ok && expression != ""
+}
+
+// CheckComputedColumns removes the invalid computed columns of the tables
+func CheckComputedColumns(config *CmsConfig) {
+	for i := range config.Tables {
+		config.Tables[i].checkComputedColumns()
+	}
+}
+
+// checkComputedColumns keeps the computed columns with a name which is not a
+// stored column and one kind of expression, and compiles the javascript ones
+func (ti *TableInfo) checkComputedColumns() {
+	columns := make([]ComputedColumn, 0)
+	for _, column := range ti.ComputedColumns {
+		if !computedColumnNamePattern.MatchString(column.Name) {
+			log.Errorf("Table[%v]: invalid computed column name [%v]", ti.TableName, column.Name)
+			continue
+		}
+		if _, ok := ti.GetColumnByName(column.Name); ok {
+			log.Errorf("Table[%v]: computed column [%v] has the name of a column", ti.TableName, column.Name)
+			continue
+		}
+		if (len(column.Sql) > 0) == (column.Js != "") {
+			log.Errorf("Table[%v]: computed column [%v] needs either a sql or a js expression", ti.TableName, column.Name)
+			continue
+		}
+		if column.Js != "" {
+			program, err := goja.Compile(column.Name, column.Js, false)
+			if err != nil {
+				log.Errorf("Table[%v]: failed to compile computed column [%v]: %v", ti.TableName, column.Name, err)
+				continue
+			}
+			column.program = program
+		}
+		columns = append(columns, column)
+	}
+	ti.ComputedColumns = columns
+}
+
+// computedColumnSql returns the sql expression of a computed column of the table
+func (dr *DbResource) computedColumnSql(tableName string, columnName string) (string, bool) {
+
+	crud, ok := dr.Cruds[tableName]
+	if !ok {
+		return "", false
+	}
+
+	for _, column := range crud.tableInfo.ComputedColumns {
+		if column.Name == columnName {
+			return column.SqlExpression(dr.driverName())
+		}
+	}
+	return "", false
+}
+
+// computedColumnSelects returns the select of each sql computed column of the
+// table, the requested ones when requestedFields is not nil
+func (dr *DbResource) computedColumnSelects(requestedFields map[string]bool) []string {
+
+	selects := make([]string, 0)
+	for _, column := range dr.tableInfo.ComputedColumns {
+		if requestedFields != nil && !requestedFields[column.Name] {
+			continue
+		}
+		expression, ok := column.SqlExpression(dr.driverName())
+		if !ok {
+			if len(column.Sql) > 0 {
+				log.Errorf("Table[%v]: computed column [%v] has no sql expression for %v", dr.tableInfo.TableName, column.Name, dr.driverName())
+			}
+			continue
+		}
+		selects = append(selects, fmt.Sprintf("(%s) as %s", expression, column.Name))
+	}
+	return selects
+}
+
+// hasJsComputedColumn tells if one of the fields is a javascript computed column
+func (dr *DbResource) hasJsComputedColumn(fields map[string]bool) bool {
+	for _, column := range dr.tableInfo.ComputedColumns {
+		if column.Js != "" && fields[column.Name] {
+			return true
+		}
+	}
+	return false
+}
+
+// computeJsColumns sets the values of the javascript computed columns of the
+// table on the rows. A column which fails to evaluate, or runs for longer than
+// computedColumnTimeout, is null.
+func (dr *DbResource) computeJsColumns(rows []map[string]interface{}) {
+
+	if dr.tableInfo == nil || len(rows) == 0 {
+		return
+	}
+
+	var vm *goja.Runtime
+	for _, column := range dr.tableInfo.ComputedColumns {
+		if column.program == nil {
+			continue
+		}
+
+		for _, row := range rows {
+			if vm == nil {
+				vm = goja.New()
+			}
+			vm.Set("row", row)
+			runtime := vm
+			timer := time.AfterFunc(computedColumnTimeout, func() {
+				runtime.Interrupt("timeout")
+			})
+			value, err := vm.RunProgram(column.program)
+			if !timer.Stop() {
+				// the interrupt can be pending when the program finished
+				// in time, the runtime is not used again
+				vm = nil
+			}
+			if err != nil {
+				log.Errorf("Table[%v]: failed to compute column [%v]: %v", dr.tableInfo.TableName, column.Name, err)
+				row[column.Name] = nil
+				continue
+			}
+			row[column.Name] = value.Export()
+		}
+	}
+}
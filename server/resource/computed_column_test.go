@@ -0,0 +1,129 @@
+package resource
+
+import (
+	"github.com/artpar/api2go"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComputedColumns(t *testing.T) {
+
+	cruds, cleanup := newTestCruds(t)
+	defer cleanup()
+	for _, name := range []string{"grace", "ada", "linus"} {
+		insertTestUser(t, cruds, name, name+"@example.com")
+	}
+	users := cruds[USER_ACCOUNT_TABLE_NAME]
+	users.tableInfo.ComputedColumns = []ComputedColumn{
+		{
+			Name: "display_name",
+			Sql: map[string]string{
+				"mysql":   "concat(name, ' <', email, '>')",
+				"default": "name || ' <' || email || '>'",
+			},
+		},
+		{
+			Name: "email_domain",
+			Js:   "row.email.split('@')[1].toUpperCase()",
+		},
+		{
+			Name: "email",
+			Js:   "'has the name of a column'",
+		},
+	}
+
+	users.tableInfo.checkComputedColumns()
+	if len(users.tableInfo.ComputedColumns) != 2 {
+		t.Errorf("Expected the computed column with the name of a column to be ignored")
+	}
+
+	results, _, _, err := users.PaginatedFindAllWithoutFilters(api2go.Request{
+		PlainRequest: httptest.NewRequest("GET", "/api/user_account", nil),
+		QueryParams: map[string][]string{
+			"sort":  {"-display_name"},
+			"query": {"display_name ~ 'example'"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 users, found %d", len(results))
+	}
+	if results[0]["display_name"] != "linus <linus@example.com>" || results[2]["display_name"] != "ada <ada@example.com>" {
+		t.Errorf("Expected the users sorted by display name, found %v, %v", results[0]["display_name"], results[2]["display_name"])
+	}
+	for _, user := range results {
+		if user["email_domain"] != "EXAMPLE.COM" {
+			t.Errorf("Unexpected email domain: %v", user["email_domain"])
+		}
+	}
+
+	results, _, _, err = users.PaginatedFindAllWithoutFilters(api2go.Request{
+		PlainRequest: httptest.NewRequest("GET", "/api/user_account", nil),
+		QueryParams: map[string][]string{
+			"query": {"display_name = 'grace <grace@example.com>'"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0]["name"] != "grace" {
+		t.Errorf("Expected to filter on the display name, found %v", results)
+	}
+
+	_, _, _, err = users.PaginatedFindAllWithoutFilters(api2go.Request{
+		PlainRequest: httptest.NewRequest("GET", "/api/user_account", nil),
+		QueryParams: map[string][]string{
+			"query": {"email_domain = 'EXAMPLE.COM'"},
+		},
+	})
+	if err == nil {
+		t.Errorf("Expected an error filtering on a javascript computed column")
+	}
+}
+
+func TestComputeJsColumns(t *testing.T) {
+
+	cruds, cleanup := newTestCruds(t)
+	defer cleanup()
+	users := cruds[USER_ACCOUNT_TABLE_NAME]
+	users.tableInfo.ComputedColumns = []ComputedColumn{
+		{Name: "upper_name", Js: "row.name.toUpperCase()"},
+		{Name: "does_not_compile", Js: "row.name +"},
+		{Name: "never_ends", Js: "while (true) {}"},
+		{Name: "lower_name", Js: "row.name.toLowerCase()"},
+	}
+	users.tableInfo.checkComputedColumns()
+
+	names := make([]string, 0)
+	for _, column := range users.tableInfo.ComputedColumns {
+		if column.program == nil {
+			t.Errorf("Expected computed column [%v] to be compiled", column.Name)
+		}
+		names = append(names, column.Name)
+	}
+	if len(names) != 3 || names[1] != "never_ends" {
+		t.Fatalf("Expected the computed column which does not compile to be removed, found %v", names)
+	}
+
+	rows := []map[string]interface{}{{"name": "First"}, {"name": "Second"}}
+	start := time.Now()
+	users.computeJsColumns(rows)
+	if elapsed := time.Since(start); elapsed > 10*computedColumnTimeout {
+		t.Errorf("Expected the computed column which does not end to be interrupted, took %v", elapsed)
+	}
+
+	for i, expected := range []string{"First", "Second"} {
+		row := rows[i]
+		if row["upper_name"] != strings.ToUpper(expected) || row["lower_name"] != strings.ToLower(expected) {
+			t.Errorf("Unexpected computed columns: %v", row)
+		}
+		if value, ok := row["never_ends"]; !ok || value != nil {
+			t.Errorf("Expected the interrupted computed column to be null: %v", row)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package resource
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/araddon/dateparse"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/emersion/go-webdav"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// DavPath is a path served by the CalDAV and CardDAV handlers split into its
+// parts. Paths look like /<prefix>/<user reference id>/<home>/<collection name>/<object name>
+type DavPath struct {
+	UserReferenceId string
+	Collection      string
+	Object          string
+}
+
+// ParseDavPath splits the path below the prefix into the user, collection and
+// object name. Parts which are not present in the path are left empty.
+func ParseDavPath(prefix string, davPath string) DavPath {
+
+	p := strings.TrimPrefix(path.Clean(davPath), strings.TrimSuffix(prefix, "/"))
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+
+	var parsed DavPath
+	if len(parts) > 0 {
+		parsed.UserReferenceId = parts[0]
+	}
+	if len(parts) > 2 {
+		parsed.Collection = parts[2]
+	}
+	if len(parts) > 3 {
+		parsed.Object = parts[3]
+	}
+	return parsed
+}
+
+// davSessionUser returns the daptin user authenticated for the dav request
+func davSessionUser(ctx context.Context) (*auth.SessionUser, error) {
+	user, ok := ctx.Value("user").(*auth.SessionUser)
+	if !ok || user == nil || user.UserReferenceId == "" {
+		return nil, webdav.NewHTTPError(http.StatusUnauthorized, errors.New("not logged in"))
+	}
+	return user, nil
+}
+
+// davRequest creates the request used to invoke DbResource methods on behalf
+// of the dav user
+func davRequest(ctx context.Context, method string) api2go.Request {
+	httpRequest := &http.Request{
+		Method: method,
+	}
+	httpRequest = httpRequest.WithContext(ctx)
+	return api2go.Request{
+		PlainRequest: httpRequest,
+	}
+}
+
+// davCollectionPermission returns the permission the user has on a calendar or
+// an address book. Collections are shared with other users through their
+// usergroups, the objects inside a collection follow the permission of the
+// collection.
+func (dr *DbResource) davCollectionPermission(user *auth.SessionUser, row map[string]interface{}) (canRead bool, canWrite bool, canDelete bool) {
+
+	adminId := dr.GetAdminReferenceId()
+	if adminId != "" && adminId == user.UserReferenceId {
+		return true, true, true
+	}
+
+	permission := dr.GetRowPermission(row)
+	return permission.CanRead(user.UserReferenceId, user.Groups),
+		permission.CanUpdate(user.UserReferenceId, user.Groups),
+		permission.CanDelete(user.UserReferenceId, user.Groups)
+}
+
+// getDavCollection loads the calendar or address book by the name in the path
+// and checks that the user can read it. The name is the resource name the user
+// created the collection with, or the reference id of the collection.
+func (dr *DbResource) getDavCollection(user *auth.SessionUser, typeName string, name string) (map[string]interface{}, error) {
+
+	if name == "" {
+		return nil, webdav.NewHTTPError(http.StatusNotFound, errors.New("no collection in path"))
+	}
+
+	rows, err := dr.GetAllObjectsWithWhere(typeName, squirrel.Eq{
+		"resource_name":        name,
+		USER_ACCOUNT_ID_COLUMN: user.UserId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var row map[string]interface{}
+	if len(rows) > 0 {
+		row = rows[0]
+	} else {
+		row, err = dr.GetReferenceIdToObject(typeName, name)
+		if err != nil {
+			return nil, webdav.NewHTTPError(http.StatusNotFound, err)
+		}
+	}
+
+	canRead, _, _ := dr.davCollectionPermission(user, row)
+	if !canRead {
+		return nil, webdav.NewHTTPError(http.StatusNotFound, fmt.Errorf("no such %v [%v]", typeName, name))
+	}
+
+	return row, nil
+}
+
+// davCollectionName is the name of a calendar or address book in the paths of
+// the user, the resource name the user created it with, or the reference id of
+// the collections created otherwise and of the ones shared with the user
+func davCollectionName(userReferenceId string, row map[string]interface{}) string {
+	name := davString(row["resource_name"])
+	if name != "" && davString(row[USER_ACCOUNT_ID_COLUMN]) == userReferenceId {
+		return name
+	}
+	return davString(row["reference_id"])
+}
+
+// listDavCollections returns all the calendars or address books the user can read
+func (dr *DbResource) listDavCollections(user *auth.SessionUser, typeName string) ([]map[string]interface{}, error) {
+
+	rows, err := dr.GetAllObjects(typeName)
+	if err != nil {
+		return nil, err
+	}
+
+	readable := make([]map[string]interface{}, 0)
+	for _, row := range rows {
+		canRead, _, _ := dr.davCollectionPermission(user, row)
+		if canRead {
+			readable = append(readable, row)
+		}
+	}
+	return readable, nil
+}
+
+// getDavObjects loads the calendar objects or contact cards of a collection,
+// optionally only the one with the given resource name
+func (dr *DbResource) getDavObjects(typeName string, collectionColumn string, collection map[string]interface{}, resourceName string) ([]map[string]interface{}, error) {
+
+	where := squirrel.Eq{
+		collectionColumn: collection["id"],
+	}
+	if resourceName != "" {
+		where["resource_name"] = resourceName
+	}
+
+	return dr.GetAllObjectsWithWhere(typeName, where)
+}
+
+// getDavObject loads the calendar object or contact card with the resource
+// name from the collection, it returns nil if there is no such object
+func (dr *DbResource) getDavObject(typeName string, collectionColumn string, collection map[string]interface{}, resourceName string) (map[string]interface{}, error) {
+
+	if resourceName == "" {
+		return nil, webdav.NewHTTPError(http.StatusNotFound, errors.New("no object in path"))
+	}
+
+	rows, err := dr.getDavObjects(typeName, collectionColumn, collection, resourceName)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	return rows[0], nil
+}
+
+// saveDavObject creates the calendar object or contact card in the collection,
+// or updates the existing row, and returns the stored row
+func (dr *DbResource) saveDavObject(ctx context.Context, typeName string, collectionColumn string,
+	collection map[string]interface{}, existing map[string]interface{}, data map[string]interface{}) (map[string]interface{}, error) {
+
+	var err error
+	if existing != nil {
+		data["reference_id"] = existing["reference_id"]
+		_, err = dr.Cruds[typeName].UpdateWithoutFilters(&api2go.Api2GoModel{
+			Data: data,
+		}, davRequest(ctx, "PATCH"))
+	} else {
+		data[collectionColumn] = collection["reference_id"]
+		_, err = dr.Cruds[typeName].CreateWithoutFilter(&api2go.Api2GoModel{
+			Data: data,
+		}, davRequest(ctx, "POST"))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	row, err := dr.getDavObject(typeName, collectionColumn, collection, data["resource_name"].(string))
+	if err != nil {
+		return nil, err
+	}
+	if row == nil {
+		return nil, errors.New("failed to store object")
+	}
+	return row, nil
+}
+
+// createDavCollection creates a calendar or an address book owned by the user
+// with the name from the path, if the user is allowed to create one. The name
+// is only unique among the collections of the user, the collection gets a new
+// reference id.
+func (dr *DbResource) createDavCollection(ctx context.Context, user *auth.SessionUser, typeName string, resourceName string, name string, description string) error {
+
+	if resourceName == "" || len(resourceName) > 200 {
+		return webdav.NewHTTPError(http.StatusForbidden, errors.New("invalid collection name"))
+	}
+
+	permission := dr.GetObjectPermissionByWhereClause("world", "table_name", typeName)
+	adminId := dr.GetAdminReferenceId()
+	if !(adminId != "" && adminId == user.UserReferenceId) && !permission.CanCreate(user.UserReferenceId, user.Groups) {
+		return webdav.NewHTTPError(http.StatusForbidden, fmt.Errorf("not allowed to create %v", typeName))
+	}
+
+	_, err := dr.getDavCollection(user, typeName, resourceName)
+	if err == nil {
+		return webdav.NewHTTPError(http.StatusMethodNotAllowed, errors.New("collection already exists"))
+	}
+
+	if name == "" {
+		name = resourceName
+	}
+
+	_, err = dr.Cruds[typeName].CreateWithoutFilter(&api2go.Api2GoModel{
+		Data: map[string]interface{}{
+			"resource_name": resourceName,
+			"name":          name,
+			"description":   description,
+		},
+	}, davRequest(ctx, "POST"))
+	return err
+}
+
+// deleteDavCollection removes a calendar or an address book along with all the
+// objects inside it
+func (dr *DbResource) deleteDavCollection(ctx context.Context, user *auth.SessionUser, typeName string,
+	objectTypeName string, collectionColumn string, collection map[string]interface{}) error {
+
+	_, _, canDelete := dr.davCollectionPermission(user, collection)
+	if !canDelete {
+		return webdav.NewHTTPError(http.StatusForbidden, errors.New("not allowed to delete collection"))
+	}
+
+	objects, err := dr.getDavObjects(objectTypeName, collectionColumn, collection, "")
+	if err != nil {
+		return err
+	}
+
+	req := davRequest(ctx, "DELETE")
+	for _, object := range objects {
+		err = dr.Cruds[objectTypeName].DeleteWithoutFilters(object["reference_id"].(string), req)
+		if err != nil {
+			return err
+		}
+	}
+
+	return dr.Cruds[typeName].DeleteWithoutFilters(collection["reference_id"].(string), req)
+}
+
+// davETag is the entity tag of a calendar object or contact card, derived from
+// its contents
+func davETag(content string) string {
+	sum := md5.Sum([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// davCheckConditions verifies the If-Match and If-None-Match headers of a PUT
+// request against the existing object, which is nil if the object is new
+func davCheckConditions(existing map[string]interface{}, ifMatch webdav.ConditionalMatch, ifNoneMatch webdav.ConditionalMatch) error {
+
+	if ifNoneMatch.IsSet() && ifNoneMatch.IsWildcard() && existing != nil {
+		return webdav.NewHTTPError(http.StatusPreconditionFailed, errors.New("object already exists"))
+	}
+
+	if ifMatch.IsSet() {
+		if existing == nil {
+			return webdav.NewHTTPError(http.StatusPreconditionFailed, errors.New("object does not exist"))
+		}
+		if !ifMatch.IsWildcard() {
+			etag, err := ifMatch.ETag()
+			if err != nil {
+				return webdav.NewHTTPError(http.StatusBadRequest, err)
+			}
+			if etag != davETag(davString(existing["content"])) {
+				return webdav.NewHTTPError(http.StatusPreconditionFailed, errors.New("object has been modified"))
+			}
+		}
+	}
+
+	return nil
+}
+
+// davModTime returns the last modification time of a row
+func davModTime(row map[string]interface{}) time.Time {
+	for _, column := range []string{"updated_at", "created_at"} {
+		switch value := row[column].(type) {
+		case time.Time:
+			return value
+		case string:
+			t, err := dateparse.ParseLocal(value)
+			if err == nil {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
+
+func davString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
@@ -0,0 +1,183 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/emersion/go-vcard"
+	"github.com/emersion/go-webdav/carddav"
+	"net/http"
+	"testing"
+)
+
+// davTestFixture has two users and the address book tables, which the users
+// can create rows in and own the rows they create
+func davTestFixture(t *testing.T) (map[string]*DbResource, []*auth.SessionUser, func()) {
+
+	cruds, cleanup := newTestCruds(t)
+
+	for _, tableName := range []string{"address_book", "contact_card"} {
+		dr := cruds[tableName]
+		model := api2go.NewApi2GoModel(tableName, dr.tableInfo.Columns, int64(auth.DEFAULT_PERMISSION), dr.tableInfo.Relations)
+		cruds[tableName] = NewDbResource(model, dr.connection, dr.ms, cruds, nil, *dr.tableInfo)
+		insertTestRow(t, cruds, "world", map[string]interface{}{
+			"table_name":        tableName,
+			"world_schema_json": "{}",
+		})
+	}
+	_, err := cruds["world"].db.Exec("update world set permission = ?", int64(auth.ALLOW_ALL_PERMISSIONS))
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	users := make([]*auth.SessionUser, 0)
+	for _, email := range []string{"first@example.com", "second@example.com"} {
+		userId := insertTestRow(t, cruds, USER_ACCOUNT_TABLE_NAME, map[string]interface{}{
+			"name":      email,
+			"email":     email,
+			"password":  "",
+			"confirmed": true,
+		})
+		referenceId, err := cruds[USER_ACCOUNT_TABLE_NAME].GetIdToReferenceId(USER_ACCOUNT_TABLE_NAME, userId)
+		if err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+		users = append(users, &auth.SessionUser{
+			UserId:          userId,
+			UserReferenceId: referenceId,
+			Groups:          []auth.GroupPermission{},
+		})
+	}
+
+	return cruds, users, cleanup
+}
+
+// davStatus is the status of an error made by webdav.NewHTTPError, its message
+// starts with the status
+func davStatus(err error) int {
+	status := 0
+	if err != nil {
+		fmt.Sscanf(err.Error(), "%d ", &status)
+	}
+	return status
+}
+
+func TestParseDavPath(t *testing.T) {
+
+	for _, test := range []struct {
+		path     string
+		expected DavPath
+	}{
+		{"/carddav/", DavPath{}},
+		{"/carddav/user-1/", DavPath{UserReferenceId: "user-1"}},
+		{"/carddav/user-1/contacts/", DavPath{UserReferenceId: "user-1"}},
+		{"/carddav/user-1/contacts/work/", DavPath{UserReferenceId: "user-1", Collection: "work"}},
+		{"/carddav/user-1/contacts/work/card.vcf", DavPath{UserReferenceId: "user-1", Collection: "work", Object: "card.vcf"}},
+		{"/carddav/user-1/contacts/../../user-2/contacts/work/", DavPath{UserReferenceId: "user-2", Collection: "work"}},
+	} {
+		if parsed := ParseDavPath("/carddav/", test.path); parsed != test.expected {
+			t.Errorf("Expected %v for %v, got %v", test.expected, test.path, parsed)
+		}
+	}
+}
+
+func TestDavCollectionsOfUsers(t *testing.T) {
+
+	cruds, users, cleanup := davTestFixture(t)
+	defer cleanup()
+	backend := NewCardDavBackend(cruds, "/carddav")
+
+	contexts := make([]context.Context, 0)
+	homes := make([]string, 0)
+	for _, user := range users {
+		ctx := context.WithValue(context.Background(), "user", user)
+		home, err := backend.AddressBookHomeSetPath(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contexts = append(contexts, ctx)
+		homes = append(homes, home)
+	}
+
+	// both users have an address book named work
+	for i := range users {
+		err := backend.CreateAddressBook(contexts[i], &carddav.AddressBook{Path: homes[i] + "work/", Name: "Work"})
+		if err != nil {
+			t.Fatalf("Expected user %d to create the work address book: %v", i, err)
+		}
+	}
+	err := backend.CreateAddressBook(contexts[0], &carddav.AddressBook{Path: homes[0] + "work/"})
+	if davStatus(err) != http.StatusMethodNotAllowed {
+		t.Errorf("Expected the second work address book of a user to fail, got %v", err)
+	}
+
+	rows, err := cruds["address_book"].GetAllObjects("address_book")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0]["reference_id"] == rows[1]["reference_id"] {
+		t.Fatalf("Expected two address books, found %v", rows)
+	}
+	for _, row := range rows {
+		if row["resource_name"] != "work" || row["reference_id"] == "work" {
+			t.Errorf("Expected the name in the path to be the resource name, found %v", row)
+		}
+	}
+
+	card := vcard.Card{}
+	card.SetValue(vcard.FieldVersion, "4.0")
+	card.SetValue(vcard.FieldUID, "card-1")
+	card.SetValue(vcard.FieldFormattedName, "Someone")
+	object, err := backend.PutAddressObject(contexts[0], homes[0]+"work/card-1.vcf", card, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if object.Path != homes[0]+"work/card-1.vcf" {
+		t.Errorf("Unexpected path of the contact card: %v", object.Path)
+	}
+
+	for i, expected := range []int{1, 0} {
+		objects, err := backend.ListAddressObjects(contexts[i], homes[i]+"work/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(objects) != expected {
+			t.Errorf("Expected %d contact cards in the work address book of user %d, found %d", expected, i, len(objects))
+		}
+
+		addressBooks, err := backend.ListAddressBooks(contexts[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(addressBooks) != 1 || addressBooks[0].Path != homes[i]+"work/" || addressBooks[0].Name != "Work" {
+			t.Errorf("Expected only the own work address book of user %d, found %v", i, addressBooks)
+		}
+	}
+
+	// the address book of the other user is not found by its reference id, nor in its home
+	var firstReferenceId string
+	for _, row := range rows {
+		if row[USER_ACCOUNT_ID_COLUMN] == users[0].UserReferenceId {
+			firstReferenceId = row["reference_id"].(string)
+		}
+	}
+	if _, err = backend.GetAddressBook(contexts[1], homes[1]+firstReferenceId+"/"); davStatus(err) != http.StatusNotFound {
+		t.Errorf("Expected the address book of the other user not to be found, got %v", err)
+	}
+	if _, err = backend.GetAddressBook(contexts[1], homes[0]+"work/"); davStatus(err) != http.StatusNotFound {
+		t.Errorf("Expected the home of the other user not to be found, got %v", err)
+	}
+	if addressBook, err := backend.GetAddressBook(contexts[0], homes[0]+firstReferenceId+"/"); err != nil || addressBook.Path != homes[0]+"work/" {
+		t.Errorf("Expected the own address book by its reference id, got %v: %v", addressBook, err)
+	}
+
+	if err = backend.DeleteAddressBook(contexts[0], homes[0]+"work/"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = backend.GetAddressBook(contexts[1], homes[1]+"work/"); err != nil {
+		t.Errorf("Expected the address book of the other user to stay, got %v", err)
+	}
+}
@@ -313,6 +313,13 @@ func CreateAMapOfColumnsWeWantInTheFinalTable(tableInfo *TableInfo) (map[string]
 
 	tableInfo.Columns = finalColumnList
 
+	// location columns are queried with hidden columns holding the coordinates
+	for _, c := range LocationCoordinateColumns(tableInfo) {
+		columnsWeWant[c.ColumnName] = false
+		colInfoMap[c.ColumnName] = c
+		tableInfo.Columns = append(tableInfo.Columns, c)
+	}
+
 	return columnsWeWant, colInfoMap
 }
 
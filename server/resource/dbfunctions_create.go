@@ -27,6 +27,20 @@ func CreateUniqueConstraints(initConfig *CmsConfig, db *sqlx.Tx) {
 			}
 		}
 
+		if len(table.UpsertKeys) > 0 {
+			ifNotExists := "if not exists "
+			if db.DriverName() == "mysql" {
+				ifNotExists = ""
+			}
+			indexName := "i" + GetMD5Hash("upsert_"+table.TableName+"_"+strings.Join(table.UpsertKeys, "_")+"_unique")
+			alterTable := "create unique index " + ifNotExists + indexName + " on " + table.TableName + "(" + strings.Join(table.UpsertKeys, ", ") + ")"
+			log.Infof("Create unique index sql: %v", alterTable)
+			_, err := db.Exec(alterTable)
+			if err != nil {
+				log.Errorf("Table[%v] Columns[%v]: Failed to create upsert key index: %v", table.TableName, table.UpsertKeys, err)
+			}
+		}
+
 		if strings.Index(table.TableName, "_has_") > -1 {
 
 			var cols []string
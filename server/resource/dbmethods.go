@@ -293,6 +293,57 @@ func (dr *DbResource) GetObjectPermissionByWhereClause(objectType string, colNam
 	return perm
 }
 
+// Get the group permissions of the objects of objType with the reference ids, by reference id
+// Used by GetRowPermissions to load the permissions of a page of rows with one query
+func (dr *DbResource) GetObjectUserGroupsByReferenceIds(objType string, referenceIds []string) map[string][]auth.GroupPermission {
+
+	s := make(map[string][]auth.GroupPermission)
+
+	rel := api2go.TableRelation{}
+	rel.Subject = objType
+	rel.SubjectName = objType + "_id"
+	rel.Object = "usergroup"
+	rel.ObjectName = "usergroup_id"
+	rel.Relation = "has_many_and_belongs_to_many"
+
+	for start := 0; start < len(referenceIds); start += idLookupBatchSize {
+		end := start + idLookupBatchSize
+		if end > len(referenceIds) {
+			end = len(referenceIds)
+		}
+
+		sql, args, err := statementbuilder.Squirrel.Select(rel.Subject+".reference_id as \"objectreferenceid\"",
+			"usergroup_id.reference_id as \"groupreferenceid\"",
+			rel.GetJoinTableName()+".reference_id as \"relationreferenceid\"", rel.GetJoinTableName()+".permission").
+			From(rel.Subject).Join(rel.GetJoinString()).
+			Where(squirrel.Eq{rel.Subject + ".reference_id": referenceIds[start:end]}).ToSql()
+		if err != nil {
+			log.Errorf("Failed to create permission select query: %v", err)
+			return s
+		}
+
+		res, err := dr.db.Queryx(sql, args...)
+		if err != nil {
+			log.Errorf("Failed to get object groups by reference ids: %v", err)
+			log.Errorf("Query: %s == [%v]", sql, args)
+			return s
+		}
+
+		for res.Next() {
+			var g auth.GroupPermission
+			err = res.StructScan(&g)
+			if err != nil {
+				log.Errorf("Failed to scan group permission: %v", err)
+				continue
+			}
+			s[g.ObjectReferenceId] = append(s[g.ObjectReferenceId], g)
+		}
+		res.Close()
+	}
+	return s
+
+}
+
 // Get list of group permissions for objects of typeName where colName=colValue
 // Utility method which makes a join query to load a lot of permissions quickly
 // Used by GetRowPermission
@@ -649,6 +700,49 @@ func (dbResource *DbResource) BecomeAdmin(userId int64) bool {
 }
 
 func (dr *DbResource) GetRowPermission(row map[string]interface{}) PermissionInstance {
+	return dr.getRowPermission(row, nil)
+}
+
+// GetRowPermissions returns the permission of each of the rows, which can be of
+// different types. User groups of the rows are loaded with one query per type.
+func (dr *DbResource) GetRowPermissions(rows []map[string]interface{}) []PermissionInstance {
+
+	referenceIdsByType := make(map[string][]string)
+	for _, row := range rows {
+		rowType := row["__type"].(string)
+		if !dr.hasUserGroups(rowType) {
+			continue
+		}
+		referenceId, ok := row["reference_id"].(string)
+		if ok {
+			referenceIdsByType[rowType] = append(referenceIdsByType[rowType], referenceId)
+		}
+	}
+
+	userGroupsByType := make(map[string]map[string][]auth.GroupPermission)
+	for rowType, referenceIds := range referenceIdsByType {
+		userGroupsByType[rowType] = dr.GetObjectUserGroupsByReferenceIds(rowType, referenceIds)
+	}
+
+	permissions := make([]PermissionInstance, len(rows))
+	for i, row := range rows {
+		permissions[i] = dr.getRowPermission(row, userGroupsByType[row["__type"].(string)])
+	}
+	return permissions
+}
+
+// hasUserGroups tells if the rows of the type are shared with user groups
+func (dr *DbResource) hasUserGroups(rowType string) bool {
+	if strings.Index(rowType, "_has_") > -1 {
+		return false
+	}
+	crud, ok := dr.Cruds[rowType]
+	return ok && crud.model.HasMany("usergroup")
+}
+
+// getRowPermission uses the user groups from userGroups, by reference id, when
+// they are loaded already, and loads them for the row otherwise
+func (dr *DbResource) getRowPermission(row map[string]interface{}, userGroups map[string][]auth.GroupPermission) PermissionInstance {
 
 	refId, ok := row["reference_id"]
 	if !ok {
@@ -677,7 +771,14 @@ func (dr *DbResource) GetRowPermission(row map[string]interface{}) PermissionIns
 	//log.Infof("Location [%v]: %v", dr.model.GetName(), loc)
 	if loc == -1 && dr.Cruds[rowType].model.HasMany("usergroup") {
 
-		perm.UserGroupId = dr.GetObjectUserGroupsByWhere(rowType, "reference_id", refId.(string))
+		if userGroups != nil {
+			perm.UserGroupId = userGroups[refId.(string)]
+			if perm.UserGroupId == nil {
+				perm.UserGroupId = []auth.GroupPermission{}
+			}
+		} else {
+			perm.UserGroupId = dr.GetObjectUserGroupsByWhere(rowType, "reference_id", refId.(string))
+		}
 
 	} else if rowType == "usergroup" {
 		originalGroupId, _ := row["reference_id"]
@@ -817,19 +918,32 @@ func (dr *DbResource) GetUserEmailIdByUsergroupId(usergroupId int64) string {
 }
 
 func (dr *DbResource) GetSingleRowByReferenceId(typeName string, referenceId string) (map[string]interface{}, []map[string]interface{}, error) {
+	return dr.GetSingleRowByReferenceIdWithIncludes(typeName, referenceId, IncludePaths{"*": {}}, nil)
+}
+
+// GetSingleRowByReferenceId with the relations to include as a tree of paths,
+// and the fields to load for the included types
+func (dr *DbResource) GetSingleRowByReferenceIdWithIncludes(typeName string, referenceId string, includePaths IncludePaths, fieldsets map[string]map[string]bool) (map[string]interface{}, []map[string]interface{}, error) {
 	//log.Infof("Get single row by id: [%v][%v]", typeName, referenceId)
-	s, q, err := statementbuilder.Squirrel.Select("*").From(typeName).Where(squirrel.Eq{"reference_id": referenceId}).ToSql()
+	typeResource := dr.Cruds[typeName]
+	fields, hasFieldset := fieldsets[typeName]
+	columns := typeResource.fieldsetSelectColumns(fields, hasFieldset, includePaths)
+	s, q, err := statementbuilder.Squirrel.Select(columns...).From(typeName).Where(squirrel.Eq{"reference_id": referenceId}).ToSql()
 	if err != nil {
 		log.Errorf("Failed to create select query by ref id: %v", referenceId)
 		return nil, nil, err
 	}
 
 	rows, err := dr.db.Queryx(s, q...)
+	if err != nil {
+		return nil, nil, err
+	}
 	defer rows.Close()
-	resultRows, includeRows, err := dr.ResultToArrayOfMap(rows, dr.Cruds[typeName].model.GetColumnMap(), map[string]bool{"*": true})
+	resultRows, includeRows, err := typeResource.ResultToArrayOfMapWithIncludes(rows, typeResource.model.GetColumnMap(), includePaths, fieldsets)
 	if err != nil {
 		return nil, nil, err
 	}
+	typeResource.computeJsColumns(resultRows)
 
 	if len(resultRows) < 1 {
 		return nil, nil, errors.New("No such entity")
@@ -914,6 +1028,85 @@ func (dr *DbResource) GetIdToObject(typeName string, id int64) (map[string]inter
 	return m[0], err
 }
 
+// Lookup a list of integer ids and return the objects of type `typeName`, by their ids
+// Foreign keys of the objects are resolved to reference ids
+func (dr *DbResource) GetIdsToObjects(typeName string, ids []int64) (map[int64]map[string]interface{}, error) {
+	objects, _, err := dr.getIncludedObjects(typeName, ids, nil, nil)
+	return objects, err
+}
+
+// fieldsetSelectColumns are the columns to select for the fieldset of the
+// table, with the ones needed to include further and to check permissions.
+// All the columns are selected without a fieldset, or when a javascript
+// computed column in the fieldset can read any of them.
+func (dr *DbResource) fieldsetSelectColumns(fields map[string]bool, hasFieldset bool, includePaths IncludePaths) []string {
+
+	columns := []string{"*"}
+	if hasFieldset && !dr.hasJsComputedColumn(fields) {
+		columns = make([]string, 0)
+		for _, column := range dr.model.GetColumns() {
+			_, included := includePaths.Relation(column)
+			if fields[column.ColumnName] || fieldsetColumns[column.ColumnName] || (column.IsForeignKey && included) {
+				columns = append(columns, column.ColumnName)
+			}
+		}
+	}
+	return append(columns, dr.computedColumnSelects(fields)...)
+}
+
+// getIncludedObjects loads the objects of type `typeName` with the ids, and the
+// objects included by them for the include paths, by the id of the object.
+// Only the columns in the fieldset of the type are loaded, with the ones needed
+// to include further and to check permissions.
+func (dr *DbResource) getIncludedObjects(typeName string, ids []int64, includePaths IncludePaths, fieldsets map[string]map[string]bool) (map[int64]map[string]interface{}, map[int64][]map[string]interface{}, error) {
+
+	typeResource := dr.Cruds[typeName]
+	columnMap := typeResource.model.GetColumnMap()
+
+	fields, hasFieldset := fieldsets[typeName]
+	columns := typeResource.fieldsetSelectColumns(fields, hasFieldset, includePaths)
+
+	objects := make(map[int64]map[string]interface{}, len(ids))
+	objectIncludes := make(map[int64][]map[string]interface{})
+	for start := 0; start < len(ids); start += idLookupBatchSize {
+		end := start + idLookupBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		s, q, err := statementbuilder.Squirrel.Select(columns...).From(typeName).Where(squirrel.Eq{"id": ids[start:end]}).ToSql()
+		if err != nil {
+			return objects, objectIncludes, err
+		}
+
+		rows, err := dr.db.Queryx(s, q...)
+		if err != nil {
+			return objects, objectIncludes, err
+		}
+
+		m, includes, err := typeResource.ResultToArrayOfMapWithIncludes(rows, columnMap, includePaths, fieldsets)
+		rows.Close()
+		if err != nil {
+			return objects, objectIncludes, err
+		}
+		typeResource.computeJsColumns(m)
+
+		for i, obj := range m {
+			id, err := foreignKeyId(obj["id"])
+			if err != nil {
+				return objects, objectIncludes, err
+			}
+			obj["__type"] = typeName
+			objects[id] = obj
+			if len(includes[i]) > 0 {
+				objectIncludes[id] = includes[i]
+			}
+		}
+	}
+
+	return objects, objectIncludes, nil
+}
+
 func (dr *DbResource) TruncateTable(typeName string, skipRelations bool) error {
 	log.Printf("Truncate table: %v", typeName)
 
@@ -962,6 +1155,25 @@ func (dr *DbResource) TruncateTable(typeName string, skipRelations bool) error {
 // Update the data and set the values using the data map without an validation or transformations
 // Invoked by data import action
 func (dr *DbResource) DirectInsert(typeName string, data map[string]interface{}) error {
+
+	cols, vals := dr.directColumnValues(typeName, data)
+
+	sqlString, args, err := statementbuilder.Squirrel.Insert(typeName).Columns(cols...).Values(vals...).ToSql()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = dr.db.Exec(sqlString, args...)
+	if err != nil {
+		log.Errorf("Failed SQL  [%v] [%v]", sqlString, args)
+	}
+	return err
+}
+
+// directColumnValues returns the columns of the table and their values from
+// the data, as they are written by DirectInsert
+func (dr *DbResource) directColumnValues(typeName string, data map[string]interface{}) ([]string, []interface{}) {
 	var err error
 
 	columnMap := dr.Cruds[typeName].model.GetColumnMap()
@@ -996,17 +1208,7 @@ func (dr *DbResource) DirectInsert(typeName string, data map[string]interface{})
 
 	}
 
-	sqlString, args, err := statementbuilder.Squirrel.Insert(typeName).Columns(cols...).Values(vals...).ToSql()
-
-	if err != nil {
-		return err
-	}
-
-	_, err = dr.db.Exec(sqlString, args...)
-	if err != nil {
-		log.Errorf("Failed SQL  [%v] [%v]", sqlString, args)
-	}
-	return err
+	return cols, vals
 }
 
 // Get all rows from the table `typeName`
@@ -1197,6 +1399,46 @@ func (dr *DbResource) GetIdToReferenceId(typeName string, id int64) (string, err
 
 }
 
+// number of ids in one "in (...)" lookup, sqlite allows 999 variables in a query
+const idLookupBatchSize = 500
+
+// Lookup a list of integer ids and return the string reference ids of the objects of type `typeName`
+// Ids which are not found are not in the returned map
+func (dr *DbResource) GetIdsToReferenceIds(typeName string, ids []int64) (map[int64]string, error) {
+
+	referenceIds := make(map[int64]string, len(ids))
+	for start := 0; start < len(ids); start += idLookupBatchSize {
+		end := start + idLookupBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		s, q, err := statementbuilder.Squirrel.Select("id", "reference_id").From(typeName).Where(squirrel.Eq{"id": ids[start:end]}).ToSql()
+		if err != nil {
+			return referenceIds, err
+		}
+
+		rows, err := dr.db.Queryx(s, q...)
+		if err != nil {
+			return referenceIds, err
+		}
+
+		for rows.Next() {
+			var id int64
+			var referenceId string
+			err = rows.Scan(&id, &referenceId)
+			if err != nil {
+				rows.Close()
+				return referenceIds, err
+			}
+			referenceIds[id] = referenceId
+		}
+		rows.Close()
+	}
+
+	return referenceIds, nil
+}
+
 // Lookup an string reference id and return a internal integer id of an object of type `typeName`
 func (dr *DbResource) GetReferenceIdToId(typeName string, referenceId string) (int64, error) {
 
@@ -1256,7 +1498,15 @@ func RowsToMap(rows *sqlx.Rows, typeName string) ([]map[string]interface{}, erro
 // check usage in exiting source for example
 // includeRelationMap can be nil to include none or map[string]bool{"*": true} to include all relations
 // can be used on any *sqlx.Rows
+// the foreign keys of all the rows are resolved together, with one query per related table
 func (dr *DbResource) ResultToArrayOfMap(rows *sqlx.Rows, columnMap map[string]api2go.ColumnInfo, includedRelationMap map[string]bool) ([]map[string]interface{}, [][]map[string]interface{}, error) {
+	return dr.ResultToArrayOfMapWithIncludes(rows, columnMap, includePathsFromRelationMap(includedRelationMap), nil)
+}
+
+// ResultToArrayOfMap with the relations to include as a tree of paths, the
+// objects included through an included object are in the includes of the row
+// also. fieldsets limits the columns loaded for the included types.
+func (dr *DbResource) ResultToArrayOfMapWithIncludes(rows *sqlx.Rows, columnMap map[string]api2go.ColumnInfo, includePaths IncludePaths, fieldsets map[string]map[string]bool) ([]map[string]interface{}, [][]map[string]interface{}, error) {
 
 	//finalArray := make([]map[string]interface{}, 0)
 
@@ -1265,10 +1515,15 @@ func (dr *DbResource) ResultToArrayOfMap(rows *sqlx.Rows, columnMap map[string]a
 		return responseArray, nil, err
 	}
 
-	objMap := make(map[string]interface{})
 	includes := make([][]map[string]interface{}, 0)
 
-	for _, row := range responseArray {
+	foreignKeys := make([]foreignKeyValue, 0)
+	idsByNamespace := make(map[string][]int64)
+	idsCollected := make(map[string]map[int64]bool)
+	// paths to include below the objects of each included type
+	includedNamespaces := make(map[string]IncludePaths)
+
+	for rowIndex, row := range responseArray {
 		localInclude := make([]map[string]interface{}, 0)
 
 		for key, val := range row {
@@ -1309,36 +1564,30 @@ func (dr *DbResource) ResultToArrayOfMap(rows *sqlx.Rows, columnMap map[string]a
 			//log.Infof("Resolve foreign key from [%v][%v][%v]", columnInfo.ForeignKeyData.DataSource, namespace, val)
 			switch columnInfo.ForeignKeyData.DataSource {
 			case "self":
-				referenceIdInt, ok := val.(int64)
-				if !ok {
-					stringIntId := val.(string)
-					referenceIdInt, err = strconv.ParseInt(stringIntId, 10, 64)
-					CheckErr(err, "Failed to convert string id to int id")
-				}
-				cache_key := fmt.Sprintf("%v-%v", namespace, referenceIdInt)
-				objCached, ok := objMap[cache_key]
-				if ok {
-					localInclude = append(localInclude, objCached.(map[string]interface{}))
-					continue
-				}
-
-				refId, err := dr.GetIdToReferenceId(namespace, referenceIdInt)
-
+				referenceIdInt, err := foreignKeyId(val)
 				if err != nil {
-					log.Errorf("Failed to get ref id for [%v][%v]: %v", namespace, val, err)
+					log.Errorf("Failed to convert string id to int id for [%v][%v]: %v", namespace, val, err)
 					continue
 				}
-				row[key] = refId
-
-				if includedRelationMap != nil && (includedRelationMap[namespace] || includedRelationMap["*"]) {
-					obj, err := dr.GetIdToObject(namespace, referenceIdInt)
-					obj["__type"] = namespace
 
-					if err != nil {
-						log.Errorf("Failed to get ref object for [%v][%v]: %v", namespace, val, err)
-					} else {
-						localInclude = append(localInclude, obj)
-					}
+				// ids are looked up once all the rows are read
+				children, included := includePaths.Relation(columnInfo)
+				foreignKeys = append(foreignKeys, foreignKeyValue{
+					rowIndex:  rowIndex,
+					column:    key,
+					namespace: namespace,
+					id:        referenceIdInt,
+					included:  included,
+				})
+				if included {
+					includedNamespaces[namespace] = includedNamespaces[namespace].merge(children)
+				}
+				if idsCollected[namespace] == nil {
+					idsCollected[namespace] = make(map[int64]bool)
+				}
+				if !idsCollected[namespace][referenceIdInt] {
+					idsCollected[namespace][referenceIdInt] = true
+					idsByNamespace[namespace] = append(idsByNamespace[namespace], referenceIdInt)
 				}
 
 			case "cloud_store":
@@ -1362,7 +1611,7 @@ func (dr *DbResource) ResultToArrayOfMap(rows *sqlx.Rows, columnMap map[string]a
 					continue
 				}
 
-				if includedRelationMap != nil && (includedRelationMap[columnInfo.ColumnName] || includedRelationMap["*"]) {
+				if _, included := includePaths.Relation(columnInfo); included {
 
 					resolvedFilesList, err := dr.GetFileFromLocalCloudStore(dr.TableInfo().TableName, columnInfo.ColumnName, foreignFilesList)
 					CheckErr(err, "Failed to resolve file from cloud store")
@@ -1384,9 +1633,102 @@ func (dr *DbResource) ResultToArrayOfMap(rows *sqlx.Rows, columnMap map[string]a
 
 	}
 
+	// one query per related table for the reference ids, or for the objects
+	// when the relation is included
+	referenceIds := make(map[string]map[int64]string)
+	includedObjects := make(map[string]map[int64]map[string]interface{})
+	nestedIncludes := make(map[string]map[int64][]map[string]interface{})
+	for namespace, ids := range idsByNamespace {
+
+		if children, ok := includedNamespaces[namespace]; ok {
+			objects, objectIncludes, err := dr.getIncludedObjects(namespace, ids, children, fieldsets)
+			if err != nil {
+				log.Errorf("Failed to get ref objects for [%v]: %v", namespace, err)
+				continue
+			}
+			includedObjects[namespace] = objects
+			nestedIncludes[namespace] = objectIncludes
+			referenceIds[namespace] = make(map[int64]string)
+			for id, obj := range objects {
+				referenceIds[namespace][id], _ = obj["reference_id"].(string)
+			}
+			continue
+		}
+
+		referenceIds[namespace], err = dr.GetIdsToReferenceIds(namespace, ids)
+		if err != nil {
+			log.Errorf("Failed to get ref ids for [%v]: %v", namespace, err)
+		}
+	}
+
+	includePositions := make([]map[string]int, len(responseArray))
+	for _, foreignKey := range foreignKeys {
+		refId, ok := referenceIds[foreignKey.namespace][foreignKey.id]
+		if !ok {
+			log.Errorf("Failed to get ref id for [%v][%v]", foreignKey.namespace, foreignKey.id)
+			continue
+		}
+		responseArray[foreignKey.rowIndex][foreignKey.column] = refId
+
+		if !foreignKey.included {
+			continue
+		}
+		obj, ok := includedObjects[foreignKey.namespace][foreignKey.id]
+		if !ok {
+			continue
+		}
+		if includePositions[foreignKey.rowIndex] == nil {
+			includePositions[foreignKey.rowIndex] = make(map[string]int)
+		}
+
+		// each row gets its own copy, the includes are changed by the middlewares
+		rowIncludes := addInclude(includes[foreignKey.rowIndex], includePositions[foreignKey.rowIndex], copyMap(obj))
+		parent := []string{includeKey(obj)}
+		for _, nested := range nestedIncludes[foreignKey.namespace][foreignKey.id] {
+			include := copyMap(nested)
+			if _, ok := include[includedByKey]; !ok {
+				include[includedByKey] = parent
+			}
+			rowIncludes = addInclude(rowIncludes, includePositions[foreignKey.rowIndex], include)
+		}
+		includes[foreignKey.rowIndex] = rowIncludes
+	}
+
 	return responseArray, includes, nil
 }
 
+// a foreign key column value of a row, resolved to a reference id after all the rows are read
+type foreignKeyValue struct {
+	rowIndex  int
+	column    string
+	namespace string
+	id        int64
+	included  bool
+}
+
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// foreignKeyId reads the integer id stored in a foreign key column
+func foreignKeyId(val interface{}) (int64, error) {
+	switch id := val.(type) {
+	case int64:
+		return id, nil
+	case int:
+		return int64(id), nil
+	case []byte:
+		return strconv.ParseInt(string(id), 10, 64)
+	case string:
+		return strconv.ParseInt(id, 10, 64)
+	}
+	return 0, fmt.Errorf("invalid id [%v]", val)
+}
+
 // convert the result of db.QueryRowx => rows to array of data
 // can be used on any *sqlx.Rows and assign a typeName
 // calls RowsToMap with the current model name
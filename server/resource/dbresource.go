@@ -1,7 +1,9 @@
 package resource
 
 import (
+	"database/sql"
 	"encoding/base64"
+	"errors"
 	"github.com/Masterminds/squirrel"
 	"github.com/artpar/api2go"
 	"github.com/artpar/go-imap"
@@ -30,6 +32,7 @@ type DbResource struct {
 	contextLock        sync.RWMutex
 	AssetFolderCache   map[string]map[string]AssetFolderCache
 	SubsiteFolderCache map[string]AssetFolderCache
+	pendingEvents      *pendingEntityEvents
 }
 
 type AssetFolderCache struct {
@@ -156,7 +159,7 @@ func (dr *DbResource) GetMailBoxMailsByOffset(mailBoxId int64, start uint32, sto
 	q := statementbuilder.Squirrel.Select("*").From("mail").Where(squirrel.Eq{
 		"mail_box_id": mailBoxId,
 		"deleted":     false,
-	}).Offset(uint64(start - 1))
+	}).OrderBy("id asc").Offset(uint64(start - 1))
 
 	if stop > 0 {
 		q = q.Limit(uint64(stop - start + 1))
@@ -334,12 +337,18 @@ func (dr *DbResource) UpdateMailFlags(mailBoxId int64, mailId int64, newFlags []
 		seen = true
 	}
 
+	modSeq, err := dr.NextMailBoxModSeq(mailBoxId)
+	if err != nil {
+		return err
+	}
+
 	query, args, err := statementbuilder.Squirrel.
 		Update("mail").
 		Set("flags", strings.Join(newFlags, ",")).
 		Set("seen", seen).
 		Set("recent", recent).
 		Set("deleted", deleted).
+		Set("modseq", modSeq).
 		Where(squirrel.Eq{
 			"mail_box_id": mailBoxId,
 			"id":          mailId,
@@ -370,13 +379,22 @@ func (dr *DbResource) ExpungeMailBox(mailBoxId int64) (int64, error) {
 		return 0, err
 	}
 
-	ids := make([]interface{}, 0)
+	ids := make([]int64, 0)
 
 	for rows.Next() {
 		var id int64
 		rows.Scan(&id)
 		ids = append(ids, id)
 	}
+	rows.Close()
+
+	return dr.ExpungeMails(ids)
+
+}
+
+// ExpungeMails permanently removes the mails with the given ids along with
+// their usergroup relations
+func (dr *DbResource) ExpungeMails(ids []int64) (int64, error) {
 
 	if len(ids) < 1 {
 		return 0, nil
@@ -429,3 +447,221 @@ func (dr *DbResource) GetMailboxNextUid(mailBoxId int64) (uint32, error) {
 	return uint32(int32(uidNext) + 1), err
 
 }
+
+// GetMailBoxMailIds returns the ids (imap uids) of all the mails in the mailbox
+// which are not marked deleted, in ascending order. The position of an id in
+// this list is its imap sequence number minus one.
+func (dr *DbResource) GetMailBoxMailIds(mailBoxId int64) ([]int64, error) {
+
+	query, args, err := statementbuilder.Squirrel.Select("id").From("mail").Where(squirrel.Eq{
+		"mail_box_id": mailBoxId,
+		"deleted":     false,
+	}).OrderBy("id asc").ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := dr.db.Queryx(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// SearchMailBoxMails returns the id and modseq of the mails in the mailbox
+// which match the where clause, ordered by id
+func (dr *DbResource) SearchMailBoxMails(mailBoxId int64, where squirrel.Sqlizer) ([]map[string]interface{}, error) {
+
+	q := statementbuilder.Squirrel.Select("id", "modseq").From("mail").Where(squirrel.Eq{
+		"mail_box_id": mailBoxId,
+	})
+	if where != nil {
+		q = q.Where(where)
+	}
+
+	query, args, err := q.OrderBy("id asc").ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := dr.db.Queryx(query, args...)
+	if err != nil {
+		log.Printf("Query: %v", query)
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		var id, modSeq int64
+		err = rows.Scan(&id, &modSeq)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, map[string]interface{}{
+			"id":     id,
+			"modseq": modSeq,
+		})
+	}
+
+	return results, nil
+}
+
+// GetMailBoxHighestModSeq returns the highest mod-sequence assigned to any
+// change in the mailbox (RFC 7162)
+func (dr *DbResource) GetMailBoxHighestModSeq(mailBoxId int64) (int64, error) {
+
+	query, args, err := statementbuilder.Squirrel.Select("highestmodseq").From("mail_box").Where(squirrel.Eq{
+		"id": mailBoxId,
+	}).ToSql()
+	if err != nil {
+		return 0, err
+	}
+
+	var highestModSeq int64
+	err = dr.db.QueryRowx(query, args...).Scan(&highestModSeq)
+	return highestModSeq, err
+}
+
+// NextMailBoxModSeq increments the mod-sequence of the mailbox and returns
+// the new value, to be assigned to the mail which is being changed. The value
+// is read in the transaction of the increment, the row stays locked until it
+// ends, so concurrent changes never get the same mod-sequence.
+func (dr *DbResource) NextMailBoxModSeq(mailBoxId int64) (int64, error) {
+
+	if tx, ok := dr.db.(*sqlx.Tx); ok {
+		return nextMailBoxModSeq(tx, mailBoxId)
+	}
+
+	tx, err := dr.connection.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	modSeq, err := nextMailBoxModSeq(tx, mailBoxId)
+	if err != nil {
+		rollbackErr := tx.Rollback()
+		CheckErr(rollbackErr, "Failed to rollback the mod-sequence of mail box [%v]", mailBoxId)
+		return 0, err
+	}
+	return modSeq, tx.Commit()
+}
+
+func nextMailBoxModSeq(tx *sqlx.Tx, mailBoxId int64) (int64, error) {
+
+	query, args, err := statementbuilder.Squirrel.Update("mail_box").
+		Set("highestmodseq", squirrel.Expr("highestmodseq + 1")).
+		Where(squirrel.Eq{"id": mailBoxId}).ToSql()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	query, args, err = statementbuilder.Squirrel.Select("highestmodseq").From("mail_box").Where(squirrel.Eq{
+		"id": mailBoxId,
+	}).ToSql()
+	if err != nil {
+		return 0, err
+	}
+
+	var highestModSeq int64
+	err = tx.QueryRowx(query, args...).Scan(&highestModSeq)
+	return highestModSeq, err
+}
+
+// GetMailAccountUsage returns the total size in bytes and the number of mails
+// stored across all the mailboxes of the mail account
+func (dr *DbResource) GetMailAccountUsage(mailAccountId int64) (int64, int64, error) {
+
+	query, args, err := statementbuilder.Squirrel.Select("coalesce(sum(size), 0)", "count(*)").From("mail").
+		Where("mail_box_id in (select id from mail_box where mail_account_id = ?)", mailAccountId).ToSql()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var size, count int64
+	err = dr.db.QueryRowx(query, args...).Scan(&size, &count)
+	return size, count, err
+}
+
+// CheckMailAccountQuota returns an error if storing a mail of the given size
+// would take the mail account over its storage or message quota
+func (dr *DbResource) CheckMailAccountQuota(mailAccountId int64, mailSize int64, mailCount int64) error {
+
+	quota, err := dr.GetMailAccountQuota(mailAccountId)
+	if err != nil {
+		return err
+	}
+
+	if quota.StorageLimit > 0 && quota.StorageUsed*1024+mailSize > quota.StorageLimit*1024 {
+		return errors.New("mail account storage quota exceeded")
+	}
+
+	if quota.MessageLimit > 0 && quota.MessageUsed+mailCount > quota.MessageLimit {
+		return errors.New("mail account message quota exceeded")
+	}
+
+	return nil
+}
+
+// GetMailAccountQuota returns the current usage and limits of the mail account.
+// Storage is counted in units of 1024 octets as defined by RFC 2087, a limit
+// of zero means no limit.
+func (dr *DbResource) GetMailAccountQuota(mailAccountId int64) (*MailAccountQuota, error) {
+
+	query, args, err := statementbuilder.Squirrel.Select("quota_storage", "quota_messages").From("mail_account").
+		Where(squirrel.Eq{"id": mailAccountId}).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	var storageLimit, messageLimit sql.NullInt64
+	err = dr.db.QueryRowx(query, args...).Scan(&storageLimit, &messageLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	size, count, err := dr.GetMailAccountUsage(mailAccountId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MailAccountQuota{
+		// a started unit of 1024 octets counts as used
+		StorageUsed:  (size + 1023) / 1024,
+		StorageLimit: storageLimit.Int64,
+		MessageUsed:  count,
+		MessageLimit: messageLimit.Int64,
+	}, nil
+}
+
+// SetMailAccountQuota updates the storage (in units of 1024 octets) and message
+// limits of a mail account
+func (dr *DbResource) SetMailAccountQuota(mailAccountId int64, storageLimit int64, messageLimit int64) error {
+
+	query, args, err := statementbuilder.Squirrel.Update("mail_account").
+		Set("quota_storage", storageLimit).
+		Set("quota_messages", messageLimit).
+		Where(squirrel.Eq{"id": mailAccountId}).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = dr.db.Exec(query, args...)
+	return err
+}
@@ -0,0 +1,224 @@
+package resource
+
+import (
+	log "github.com/sirupsen/logrus"
+	"sync"
+)
+
+// the changes to a row which can be subscribed to
+const (
+	EntityCreated = "created"
+	EntityUpdated = "updated"
+	EntityDeleted = "deleted"
+)
+
+// subscriptionBufferSize is the number of changes waiting for a subscriber
+// before more changes are dropped
+const subscriptionBufferSize = 100
+
+// entityEventQueueSize is the number of changes waiting to be matched to the
+// subscriptions before more changes are dropped
+const entityEventQueueSize = 1000
+
+// EntitySubscriptionMatcher tells if a subscriber gets the change to a row, and
+// returns the row as the subscriber sees it. It is called while the row is in
+// the database, through the resource of the request which changed it.
+type EntitySubscriptionMatcher func(dr *DbResource, referenceId string) (map[string]interface{}, bool)
+
+// EntitySubscription receives the matching changes to the rows of a table on
+// Events, until it is unsubscribed
+type EntitySubscription struct {
+	Events    chan map[string]interface{}
+	id        int
+	typeName  string
+	eventType string
+	match     EntitySubscriptionMatcher
+	broker    *EntityEventBroker
+}
+
+// Unsubscribe stops the changes and closes Events
+func (s *EntitySubscription) Unsubscribe() {
+	s.broker.lock.Lock()
+	defer s.broker.lock.Unlock()
+
+	if _, ok := s.broker.subscriptions[s.typeName][s.id]; !ok {
+		return
+	}
+	delete(s.broker.subscriptions[s.typeName], s.id)
+	close(s.Events)
+}
+
+type entityEventDelivery struct {
+	subscription *EntitySubscription
+	object       map[string]interface{}
+}
+
+// entityEvent is a change to a row waiting to be matched to the
+// subscriptions. A deleted row cannot be found anymore, it is matched before
+// the delete and its deliveries are kept instead.
+type entityEvent struct {
+	broker      *EntityEventBroker
+	dr          *DbResource
+	eventType   string
+	referenceId string
+	deliveries  []entityEventDelivery
+}
+
+// EntityEventBroker passes the rows created, updated and deleted through the
+// api to the subscribers of their table. Created and updated rows are matched
+// apart from the request which changed them, so the subscriptions do not slow
+// down the writes. Changes made with the resources of NewTransactionCruds are
+// sent when the transaction is committed, and not at all if it is rolled back.
+type EntityEventBroker struct {
+	lock          sync.RWMutex
+	nextId        int
+	subscriptions map[string]map[int]*EntitySubscription
+	queue         chan entityEvent
+}
+
+func NewEntityEventBroker() *EntityEventBroker {
+	broker := &EntityEventBroker{
+		subscriptions: make(map[string]map[int]*EntitySubscription),
+		queue:         make(chan entityEvent, entityEventQueueSize),
+	}
+	go broker.matchQueued()
+	return broker
+}
+
+// Subscribe to a kind of change to the rows of a table
+func (b *EntityEventBroker) Subscribe(typeName string, eventType string, match EntitySubscriptionMatcher) *EntitySubscription {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.nextId++
+	subscription := &EntitySubscription{
+		Events:    make(chan map[string]interface{}, subscriptionBufferSize),
+		id:        b.nextId,
+		typeName:  typeName,
+		eventType: eventType,
+		match:     match,
+		broker:    b,
+	}
+	if b.subscriptions[typeName] == nil {
+		b.subscriptions[typeName] = make(map[int]*EntitySubscription)
+	}
+	b.subscriptions[typeName][subscription.id] = subscription
+	return subscription
+}
+
+// hasSubscriptions tells if any subscriber waits for the kind of change to
+// the rows of the table
+func (b *EntityEventBroker) hasSubscriptions(typeName string, eventType string) bool {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	for _, subscription := range b.subscriptions[typeName] {
+		if subscription.eventType == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// publish sends the deliveries of a deleted row, and queues a created or
+// updated row to be matched
+func (b *EntityEventBroker) publish(event entityEvent) {
+
+	if event.eventType == EntityDeleted {
+		b.deliver(event.deliveries)
+		return
+	}
+	if !b.hasSubscriptions(event.dr.model.GetName(), event.eventType) {
+		return
+	}
+	select {
+	case b.queue <- event:
+	default:
+		log.Errorf("Dropped %v event on [%v], too many changes are waiting to be matched", event.eventType, event.dr.model.GetName())
+	}
+}
+
+// matchQueued matches the queued changes to the subscriptions, one at a time
+func (b *EntityEventBroker) matchQueued() {
+	for event := range b.queue {
+		b.deliver(b.matchSubscriptions(event.dr, event.eventType, event.referenceId))
+	}
+}
+
+// matchSubscriptions finds the subscribers of the change to the row
+func (b *EntityEventBroker) matchSubscriptions(dr *DbResource, eventType string, referenceId string) []entityEventDelivery {
+
+	typeName := dr.model.GetName()
+	b.lock.RLock()
+	subscriptions := make([]*EntitySubscription, 0)
+	for _, subscription := range b.subscriptions[typeName] {
+		if subscription.eventType == eventType {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	b.lock.RUnlock()
+
+	deliveries := make([]entityEventDelivery, 0)
+	for _, subscription := range subscriptions {
+		object, ok := subscription.match(dr, referenceId)
+		if ok {
+			deliveries = append(deliveries, entityEventDelivery{subscription: subscription, object: object})
+		}
+	}
+	return deliveries
+}
+
+// deliver sends the changes to the subscribers which are still subscribed,
+// without waiting for the ones which are behind
+func (b *EntityEventBroker) deliver(deliveries []entityEventDelivery) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	for _, delivery := range deliveries {
+		subscription := delivery.subscription
+		if _, ok := b.subscriptions[subscription.typeName][subscription.id]; !ok {
+			continue
+		}
+		select {
+		case subscription.Events <- delivery.object:
+		default:
+			log.Errorf("Dropped %v event on [%v] for a subscriber which is behind", subscription.eventType, subscription.typeName)
+		}
+	}
+}
+
+// pendingEntityEvents keeps the changes made in a transaction until it is
+// committed, with the resources outside of the transaction to match them with
+type pendingEntityEvents struct {
+	lock   sync.Mutex
+	cruds  map[string]*DbResource
+	events []entityEvent
+}
+
+// publishEntityEvent sends the change now, or when the transaction of the
+// resource is committed
+func (dr *DbResource) publishEntityEvent(event entityEvent) {
+
+	if dr.pendingEvents == nil {
+		event.broker.publish(event)
+		return
+	}
+	dr.pendingEvents.lock.Lock()
+	defer dr.pendingEvents.lock.Unlock()
+	dr.pendingEvents.events = append(dr.pendingEvents.events, event)
+}
+
+func (p *pendingEntityEvents) publish() {
+	p.lock.Lock()
+	events := p.events
+	p.events = nil
+	p.lock.Unlock()
+
+	for _, event := range events {
+		// the transaction is over, the row is matched outside of it
+		if dr, ok := p.cruds[event.dr.model.GetName()]; ok {
+			event.dr = dr
+		}
+		event.broker.publish(event)
+	}
+}
@@ -0,0 +1,112 @@
+package resource
+
+import (
+	"github.com/artpar/api2go"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEntityEventBroker(t *testing.T) {
+
+	cruds, cleanup := newTestCruds(t)
+	defer cleanup()
+	usergroup := cruds["usergroup"]
+	broker := NewEntityEventBroker()
+
+	matched := broker.Subscribe("usergroup", EntityDeleted, func(dr *DbResource, referenceId string) (map[string]interface{}, bool) {
+		return map[string]interface{}{"reference_id": referenceId}, true
+	})
+	filtered := broker.Subscribe("usergroup", EntityDeleted, func(dr *DbResource, referenceId string) (map[string]interface{}, bool) {
+		return nil, false
+	})
+	created := broker.Subscribe("usergroup", EntityCreated, func(dr *DbResource, referenceId string) (map[string]interface{}, bool) {
+		t.Errorf("Expected the subscription to created rows not to be matched on delete")
+		return nil, false
+	})
+	defer created.Unsubscribe()
+
+	// deleted rows are matched before the delete and sent after it
+	middleware := NewDeleteEventHandler(broker)
+	req := &api2go.Request{PlainRequest: httptest.NewRequest("DELETE", "/api/usergroup/g1", nil)}
+	_, err := middleware.InterceptBefore(usergroup, req, []map[string]interface{}{{"reference_id": "g1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matched.Events) != 0 {
+		t.Errorf("Expected the deleted row to be sent after it is deleted")
+	}
+
+	// rows deleted with it reuse the request, their events are their own
+	nestedReq := &api2go.Request{PlainRequest: req.PlainRequest}
+	_, _ = middleware.InterceptBefore(cruds["user_account_user_account_id_has_usergroup_usergroup_id"], nestedReq, []map[string]interface{}{})
+	_, _ = middleware.InterceptAfter(cruds["user_account_user_account_id_has_usergroup_usergroup_id"], nestedReq, nil)
+
+	_, _ = middleware.InterceptAfter(usergroup, req, nil)
+	if len(matched.Events) != 1 || len(filtered.Events) != 0 {
+		t.Fatalf("Expected one event for the matching subscription, found %d and %d", len(matched.Events), len(filtered.Events))
+	}
+	if event := <-matched.Events; event["reference_id"] != "g1" {
+		t.Errorf("Unexpected event: %v", event)
+	}
+
+	matched.Unsubscribe()
+	if _, open := <-matched.Events; open {
+		t.Errorf("Expected the events to be closed after unsubscribing")
+	}
+	matched.Unsubscribe()
+	_, _ = middleware.InterceptBefore(usergroup, req, []map[string]interface{}{{"reference_id": "g2"}})
+	_, _ = middleware.InterceptAfter(usergroup, req, nil)
+}
+
+func TestEntityEventsAfterCommit(t *testing.T) {
+
+	cruds, cleanup := newTestCruds(t)
+	defer cleanup()
+	broker := NewEntityEventBroker()
+
+	created := broker.Subscribe("usergroup", EntityCreated, func(dr *DbResource, referenceId string) (map[string]interface{}, bool) {
+		if dr.pendingEvents != nil {
+			t.Errorf("Expected the row to be matched outside of the transaction")
+		}
+		return map[string]interface{}{"reference_id": referenceId}, true
+	})
+	defer created.Unsubscribe()
+
+	middleware := NewCreateEventHandler(broker)
+	req := &api2go.Request{PlainRequest: httptest.NewRequest("POST", "/api/usergroup", nil)}
+	received := func() string {
+		select {
+		case event := <-created.Events:
+			return event["reference_id"].(string)
+		case <-time.After(time.Second):
+			return ""
+		}
+	}
+
+	// rows created in a transaction which is rolled back are not sent
+	tx := cruds["usergroup"].connection.MustBegin()
+	transactionCruds := NewTransactionCruds(cruds, tx)
+	_, _ = middleware.InterceptAfter(transactionCruds["usergroup"], req, []map[string]interface{}{{"reference_id": "g1"}})
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx = cruds["usergroup"].connection.MustBegin()
+	transactionCruds = NewTransactionCruds(cruds, tx)
+	_, _ = middleware.InterceptAfter(transactionCruds["usergroup"], req, []map[string]interface{}{{"reference_id": "g2"}})
+	if len(created.Events) != 0 {
+		t.Errorf("Expected the created row to be sent after the commit")
+	}
+	if err := CommitTransactionCruds(transactionCruds, tx); err != nil {
+		t.Fatal(err)
+	}
+	if referenceId := received(); referenceId != "g2" {
+		t.Errorf("Expected the row of the committed transaction, got [%v]", referenceId)
+	}
+
+	_, _ = middleware.InterceptAfter(cruds["usergroup"], req, []map[string]interface{}{{"reference_id": "g3"}})
+	if referenceId := received(); referenceId != "g3" {
+		t.Errorf("Expected the row created outside of a transaction, got [%v]", referenceId)
+	}
+}
@@ -0,0 +1,144 @@
+package resource
+
+import (
+	"errors"
+	"fmt"
+	"github.com/artpar/api2go"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VersionETag is the ETag of a row, derived from its version
+func VersionETag(version int64) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// representationETag is the ETag of the row as returned for the request. The
+// includes, the fieldsets and the languages of the request are mixed in, a
+// response with other includes or fields gets another ETag.
+func representationETag(req api2go.Request, version int64, languagePreferences []string) string {
+
+	variant := make([]string, 0)
+	for name, values := range req.QueryParams {
+		if name == "include" || name == "included_relations" || sparseFieldsetRegex.MatchString(name) {
+			variant = append(variant, name+"="+strings.Join(values, ","))
+		}
+	}
+	if len(languagePreferences) > 0 {
+		variant = append(variant, "language="+strings.Join(languagePreferences, ","))
+	}
+	if len(variant) == 0 {
+		return VersionETag(version)
+	}
+
+	sort.Strings(variant)
+	return fmt.Sprintf(`"%d-%s"`, version, GetMD5Hash(strings.Join(variant, "&"))[:16])
+}
+
+// etagListContains checks if the If-None-Match header, a list of ETags or *,
+// has the etag
+func etagListContains(header string, etag string) bool {
+	for _, value := range strings.Split(header, ",") {
+		value = strings.TrimPrefix(strings.TrimSpace(value), "W/")
+		if value == "*" || value == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// parseVersion reads a version from an ETag, or from the version attribute of
+// a request body. The ETag of a representation has the version before the dash.
+func parseVersion(value interface{}) (int64, error) {
+
+	switch v := value.(type) {
+	case int64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case float64:
+		if v != float64(int64(v)) {
+			return 0, fmt.Errorf("invalid version [%v]", v)
+		}
+		return int64(v), nil
+	case []byte:
+		return parseVersion(string(v))
+	case string:
+		text := strings.Trim(strings.TrimPrefix(strings.TrimSpace(v), "W/"), `"`)
+		if dash := strings.Index(text, "-"); dash > 0 {
+			text = text[:dash]
+		}
+		version, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid version [%v]", v)
+		}
+		return version, nil
+	}
+
+	return 0, fmt.Errorf("invalid version [%v]", value)
+}
+
+// etagMatches checks the version against an If-Match or If-None-Match header,
+// which is a list of ETags or *
+func etagMatches(header string, version int64) bool {
+	for _, etag := range strings.Split(header, ",") {
+		etag = strings.TrimSpace(etag)
+		if etag == "*" {
+			return true
+		}
+		if etagVersion, err := parseVersion(etag); err == nil && etagVersion == version {
+			return true
+		}
+	}
+	return false
+}
+
+// checkExpectedVersion fails the update when the request expects another
+// version of the row than the stored one. The expected version is taken from
+// the If-Match header, 412 on a mismatch, or from the version attribute in the
+// request body, 409 on a mismatch. The returned bool tells if a version was
+// expected, the update is then made only if the row is still at that version.
+func checkExpectedVersion(req api2go.Request, allChanges map[string]api2go.Change, currentVersion int64) (bool, error) {
+
+	ifMatch := ""
+	if req.PlainRequest != nil {
+		ifMatch = req.PlainRequest.Header.Get("If-Match")
+	}
+	if ifMatch != "" {
+		if !etagMatches(ifMatch, currentVersion) {
+			err := errors.New("the object has been modified")
+			return true, api2go.NewHTTPError(err, fmt.Sprintf("If-Match does not match the current version %v", currentVersion), http.StatusPreconditionFailed)
+		}
+		return true, nil
+	}
+
+	change, ok := allChanges["version"]
+	if !ok {
+		return false, nil
+	}
+	expectedVersion, err := parseVersion(change.NewValue)
+	if err != nil {
+		return true, api2go.NewHTTPError(err, err.Error(), http.StatusBadRequest)
+	}
+	if expectedVersion != currentVersion {
+		err := errors.New("the object has been modified")
+		return true, api2go.NewHTTPError(err, fmt.Sprintf("version %v does not match the current version %v", expectedVersion, currentVersion), http.StatusConflict)
+	}
+	return true, nil
+}
+
+// SetResponseHeader sets a header on the response of the api request. The
+// router keeps the headers of the response in the request context.
+func SetResponseHeader(req api2go.Request, name string, value string) {
+	if req.PlainRequest == nil {
+		return
+	}
+	header, ok := req.PlainRequest.Context().Value("response_header").(http.Header)
+	if ok {
+		header.Set(name, value)
+	}
+}
@@ -0,0 +1,167 @@
+package resource
+
+import (
+	"context"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEtagMatches(t *testing.T) {
+
+	if VersionETag(3) != `"3"` {
+		t.Errorf("Unexpected etag: %v", VersionETag(3))
+	}
+
+	for _, header := range []string{`"3"`, `W/"3"`, `"1", "3"`, `"3-5d41402abc4b2a76"`, "*"} {
+		if !etagMatches(header, 3) {
+			t.Errorf("Expected [%v] to match version 3", header)
+		}
+	}
+
+	for _, header := range []string{"", `"4"`, `"1", "2"`, `"three"`} {
+		if etagMatches(header, 3) {
+			t.Errorf("Expected [%v] not to match version 3", header)
+		}
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+
+	for _, value := range []interface{}{int64(7), 7, float64(7), "7", `"7"`, []byte("7")} {
+		version, err := parseVersion(value)
+		if err != nil || version != 7 {
+			t.Errorf("Unexpected version from [%v]: %v %v", value, version, err)
+		}
+	}
+
+	for _, value := range []interface{}{nil, 7.5, "seven"} {
+		if _, err := parseVersion(value); err == nil {
+			t.Errorf("Expected [%v] to be an invalid version", value)
+		}
+	}
+}
+
+func TestFindOneNotModified(t *testing.T) {
+
+	cruds, cleanup := newTestCruds(t)
+	defer cleanup()
+	serverId := insertTestRow(t, cruds, "mail_server", map[string]interface{}{
+		"hostname": "mail.example.com",
+	})
+	referenceId, err := cruds["mail_server"].GetIdToReferenceId("mail_server", serverId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := http.Header{}
+	request := httptest.NewRequest("GET", "/api/mail_server/"+referenceId, nil)
+	request.Header.Set("If-None-Match", VersionETag(1))
+	request = request.WithContext(context.WithValue(request.Context(), "response_header", header))
+	_, err = cruds["mail_server"].FindOne(referenceId, api2go.Request{PlainRequest: request})
+	if httpError, ok := err.(api2go.HTTPError); !ok || httpError.Status() != http.StatusNotModified {
+		t.Fatalf("Expected 304 for the current version, got %v", err)
+	}
+	if header.Get("ETag") != VersionETag(1) {
+		t.Errorf("Expected the etag with the 304, got [%v]", header.Get("ETag"))
+	}
+
+	// other includes, fields or languages are another representation of the row
+	request = httptest.NewRequest("GET", "/api/mail_server/"+referenceId+"?include=mail_account&fields[mail_server]=hostname", nil)
+	request.Header.Set("If-None-Match", VersionETag(1))
+	request = request.WithContext(context.WithValue(request.Context(), "response_header", header))
+	_, err = cruds["mail_server"].FindOne(referenceId, api2go.Request{PlainRequest: request, QueryParams: request.URL.Query()})
+	if err != nil {
+		t.Fatalf("Expected the row with the fieldset, got %v", err)
+	}
+	etag := header.Get("ETag")
+	if version, err := parseVersion(etag); err != nil || version != 1 || etag == VersionETag(1) {
+		t.Errorf("Expected an etag of version 1 for the fieldset, got [%v]", etag)
+	}
+	request.Header.Set("If-None-Match", etag)
+	_, err = cruds["mail_server"].FindOne(referenceId, api2go.Request{PlainRequest: request, QueryParams: request.URL.Query()})
+	if httpError, ok := err.(api2go.HTTPError); !ok || httpError.Status() != http.StatusNotModified {
+		t.Errorf("Expected 304 for the etag of the fieldset, got %v", err)
+	}
+
+	request = httptest.NewRequest("GET", "/api/mail_server/"+referenceId, nil)
+	request.Header.Set("If-None-Match", VersionETag(1))
+	request = request.WithContext(context.WithValue(context.WithValue(request.Context(), "response_header", header), "language_preference", []string{"de"}))
+	_, err = cruds["mail_server"].FindOne(referenceId, api2go.Request{PlainRequest: request})
+	if err != nil || header.Get("ETag") == VersionETag(1) || header.Get("ETag") == etag {
+		t.Errorf("Expected another etag for the language, got [%v]: %v", header.Get("ETag"), err)
+	}
+
+	// calls from within the server have no request
+	response, err := cruds["mail_server"].FindOne(referenceId, api2go.Request{})
+	if err != nil || response.Result().(*api2go.Api2GoModel).Data["hostname"] != "mail.example.com" {
+		t.Errorf("Expected the row without a request, got %v", err)
+	}
+}
+
+func TestTranslatedUpdateExpectedVersion(t *testing.T) {
+
+	cruds, cleanup := newTestCruds(t)
+	defer cleanup()
+
+	dr := cruds["mail_server"]
+	for _, query := range []string{
+		"create table mail_server_i18n as select * from mail_server where 0",
+		"alter table mail_server_i18n add column language_id varchar(10)",
+		"alter table mail_server_i18n add column translation_reference_id integer",
+	} {
+		if _, err := dr.db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tableInfo := *dr.tableInfo
+	tableInfo.TranslationsEnabled = true
+	dr = NewDbResource(dr.model, dr.connection, dr.ms, cruds, nil, tableInfo)
+
+	serverId := insertTestRow(t, cruds, "mail_server", map[string]interface{}{
+		"hostname": "mail.example.com",
+	})
+	referenceId, err := dr.GetIdToReferenceId("mail_server", serverId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	update := func(ifMatch string, hostname string) error {
+		request := httptest.NewRequest("PATCH", "/api/mail_server/"+referenceId, nil)
+		request.Header.Set("If-Match", ifMatch)
+		ctx := context.WithValue(request.Context(), "user", &auth.SessionUser{Groups: []auth.GroupPermission{}})
+		request = request.WithContext(context.WithValue(ctx, "language_preference", []string{"fr"}))
+
+		model := api2go.NewApi2GoModelWithData("mail_server", nil, 0, nil, map[string]interface{}{
+			"hostname": hostname,
+		})
+		model.SetID(referenceId)
+		_, err := dr.UpdateWithoutFilters(model, api2go.Request{PlainRequest: request})
+		return err
+	}
+
+	for _, ifMatch := range []string{VersionETag(2), VersionETag(1)} {
+		err = update(ifMatch, "mail.example.fr")
+		if ifMatch == VersionETag(2) {
+			if httpError, ok := err.(api2go.HTTPError); !ok || httpError.Status() != http.StatusPreconditionFailed {
+				t.Errorf("Expected 412 for another version, got %v", err)
+			}
+		} else if err != nil {
+			t.Fatalf("Expected the translated update at the current version: %v", err)
+		}
+	}
+
+	row, err := dr.GetReferenceIdToObject("mail_server", referenceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := parseVersion(row["version"]); version != 2 || row["hostname"] != "mail.example.com" {
+		t.Errorf("Expected the translated update to move the version and keep the row, found %v", row)
+	}
+
+	if err = update(VersionETag(1), "mail.example.fr"); err == nil {
+		t.Errorf("Expected the old version to fail the next translated update")
+	}
+}
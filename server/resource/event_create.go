@@ -1,5 +1,5 @@
 package resource
 
-func NewCreateEventHandler() DatabaseRequestInterceptor {
-	return &eventHandlerMiddleware{}
+func NewCreateEventHandler(broker *EntityEventBroker) DatabaseRequestInterceptor {
+	return &eventHandlerMiddleware{eventType: EntityCreated, broker: broker}
 }
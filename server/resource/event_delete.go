@@ -1,5 +1,5 @@
 package resource
 
-func NewDeleteEventHandler() DatabaseRequestInterceptor {
-	return &eventHandlerMiddleware{}
+func NewDeleteEventHandler(broker *EntityEventBroker) DatabaseRequestInterceptor {
+	return &eventHandlerMiddleware{eventType: EntityDeleted, broker: broker}
 }
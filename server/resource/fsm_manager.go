@@ -175,3 +175,42 @@ func NewFsmManager(db database.DatabaseConnection, cruds map[string]*DbResource)
 	return &fsm
 
 }
+
+// StateMachineEvents reads the events of a state machine description, from the
+// json of its events column
+func StateMachineEvents(events interface{}) ([]LoopbackEventDesc, error) {
+
+	var eventsJson []byte
+	switch value := events.(type) {
+	case string:
+		eventsJson = []byte(value)
+	case []byte:
+		eventsJson = value
+	default:
+		var err error
+		eventsJson, err = json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var descriptions []LoopbackEventDesc
+	err := json.Unmarshal(eventsJson, &descriptions)
+	return descriptions, err
+}
+
+// AllowedStateEvents are the names of the events which can be fired at the
+// current state
+func AllowedStateEvents(currentState string, events []LoopbackEventDesc) []string {
+
+	allowed := make([]string, 0)
+	for _, event := range events {
+		for _, src := range event.Src {
+			if src == currentState {
+				allowed = append(allowed, event.Name)
+				break
+			}
+		}
+	}
+	return allowed
+}
@@ -0,0 +1,32 @@
+package resource
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAllowedStateEvents(t *testing.T) {
+
+	events, err := StateMachineEvents(`[
+		{"name": "close", "label": "Close", "src": ["open", "blocked"], "dst": "closed"},
+		{"name": "block", "src": ["open"], "dst": "blocked"},
+		{"name": "reopen", "src": ["closed"], "dst": "open"}
+	]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if allowed := AllowedStateEvents("open", events); !reflect.DeepEqual(allowed, []string{"close", "block"}) {
+		t.Errorf("Unexpected events at open: %v", allowed)
+	}
+	if allowed := AllowedStateEvents("closed", events); !reflect.DeepEqual(allowed, []string{"reopen"}) {
+		t.Errorf("Unexpected events at closed: %v", allowed)
+	}
+	if allowed := AllowedStateEvents("archived", events); len(allowed) != 0 {
+		t.Errorf("Expected no events at a state without transitions, found %v", allowed)
+	}
+
+	if _, err := StateMachineEvents("not json"); err == nil {
+		t.Errorf("Expected an error reading invalid events")
+	}
+}
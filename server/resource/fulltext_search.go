@@ -0,0 +1,612 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/database"
+	"github.com/daptin/daptin/server/statementbuilder"
+	log "github.com/sirupsen/logrus"
+	"html"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// full text index kinds, sqlite uses fts5 when daptin is built with the
+// sqlite_fts5 tag and falls back to fts4 otherwise
+const (
+	fullTextSqliteFts5 = "fts5"
+	fullTextSqliteFts4 = "fts4"
+	fullTextPostgres   = "tsvector"
+	fullTextMysql      = "fulltext"
+)
+
+// fullTextIndexes has the index kind of every table with a native full text
+// index, tables without one are searched with like
+var fullTextIndexes = map[string]string{}
+var fullTextIndexesLock sync.RWMutex
+
+func fullTextIndexKind(tableName string) string {
+	fullTextIndexesLock.RLock()
+	defer fullTextIndexesLock.RUnlock()
+	return fullTextIndexes[tableName]
+}
+
+// GetSearchableColumns returns the columns of the table which are listed as
+// searchable and can hold text
+func (ti *TableInfo) GetSearchableColumns() []api2go.ColumnInfo {
+	columns := make([]api2go.ColumnInfo, 0)
+	for _, name := range ti.SearchableColumns {
+		column, ok := ti.GetColumnByName(name)
+		if !ok || column.ExcludeFromApi || column.IsForeignKey {
+			continue
+		}
+		if column.ColumnType == "password" || column.ColumnType == "encrypted" {
+			continue
+		}
+		columns = append(columns, *column)
+	}
+	return columns
+}
+
+// CreateFullTextIndexes creates the native full text index for the tables with
+// searchable columns. Statements are executed one by one outside of a
+// transaction since a failing statement aborts the transaction on postgres.
+func CreateFullTextIndexes(initConfig *CmsConfig, db database.DatabaseConnection) {
+	log.Infof("Create full text indexes")
+
+	indexes := map[string]string{}
+	for _, table := range initConfig.Tables {
+		columns := table.GetSearchableColumns()
+		if len(columns) == 0 {
+			continue
+		}
+
+		columnNames := make([]string, 0)
+		for _, column := range columns {
+			columnNames = append(columnNames, column.ColumnName)
+		}
+
+		var kind string
+		var err error
+		switch db.DriverName() {
+		case "sqlite3":
+			kind, err = createSqliteFullTextIndex(db, table.TableName, columnNames)
+		case "postgres":
+			kind, err = fullTextPostgres, createPostgresFullTextIndex(db, table.TableName, columnNames)
+		case "mysql":
+			kind, err = fullTextMysql, createMysqlFullTextIndex(db, table.TableName, columnNames)
+		default:
+			continue
+		}
+
+		if err != nil {
+			log.Errorf("Failed to create full text index on [%v], falling back to like: %v", table.TableName, err)
+			continue
+		}
+		indexes[table.TableName] = kind
+	}
+
+	fullTextIndexesLock.Lock()
+	fullTextIndexes = indexes
+	fullTextIndexesLock.Unlock()
+}
+
+func createSqliteFullTextIndex(db database.DatabaseConnection, tableName string, columns []string) (string, error) {
+
+	ftsTable := tableName + "_fts"
+	columnList := strings.Join(columns, ", ")
+
+	var err error
+	for _, kind := range []string{fullTextSqliteFts5, fullTextSqliteFts4} {
+
+		var createTable string
+		if kind == fullTextSqliteFts5 {
+			createTable = fmt.Sprintf("create virtual table %s using fts5(%s, content='%s', content_rowid='id')", ftsTable, columnList, tableName)
+		} else {
+			createTable = fmt.Sprintf("create virtual table %s using fts4(%s, content='%s')", ftsTable, columnList, tableName)
+		}
+
+		var existing string
+		_ = db.QueryRowx("select sql from sqlite_master where type = 'table' and name = ?", ftsTable).Scan(&existing)
+		if strings.EqualFold(existing, createTable) {
+			return kind, nil
+		}
+
+		// the searchable columns changed, the index is created again
+		if existing != "" {
+			for _, trigger := range []string{"ai", "ad", "au", "bd", "bu"} {
+				_, _ = db.Exec(fmt.Sprintf("drop trigger if exists %s_%s", ftsTable, trigger))
+			}
+			_, err = db.Exec("drop table " + ftsTable)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		_, err = db.Exec(createTable)
+		if err != nil {
+			// fts5 is not compiled in, try fts4
+			continue
+		}
+
+		newValues := "new." + strings.Join(columns, ", new.")
+		oldValues := "old." + strings.Join(columns, ", old.")
+		var triggers []string
+		if kind == fullTextSqliteFts5 {
+			triggers = []string{
+				fmt.Sprintf("create trigger %s_ai after insert on %s begin insert into %s(rowid, %s) values (new.id, %s); end",
+					ftsTable, tableName, ftsTable, columnList, newValues),
+				fmt.Sprintf("create trigger %s_ad after delete on %s begin insert into %s(%s, rowid, %s) values ('delete', old.id, %s); end",
+					ftsTable, tableName, ftsTable, ftsTable, columnList, oldValues),
+				fmt.Sprintf("create trigger %s_au after update on %s begin insert into %s(%s, rowid, %s) values ('delete', old.id, %s); insert into %s(rowid, %s) values (new.id, %s); end",
+					ftsTable, tableName, ftsTable, ftsTable, columnList, oldValues, ftsTable, columnList, newValues),
+			}
+		} else {
+			triggers = []string{
+				fmt.Sprintf("create trigger %s_bu before update on %s begin delete from %s where docid = old.id; end", ftsTable, tableName, ftsTable),
+				fmt.Sprintf("create trigger %s_bd before delete on %s begin delete from %s where docid = old.id; end", ftsTable, tableName, ftsTable),
+				fmt.Sprintf("create trigger %s_au after update on %s begin insert into %s(docid, %s) values (new.id, %s); end",
+					ftsTable, tableName, ftsTable, columnList, newValues),
+				fmt.Sprintf("create trigger %s_ai after insert on %s begin insert into %s(docid, %s) values (new.id, %s); end",
+					ftsTable, tableName, ftsTable, columnList, newValues),
+			}
+		}
+
+		for _, trigger := range triggers {
+			_, err = db.Exec(trigger)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		// index the rows which were already in the table
+		_, err = db.Exec(fmt.Sprintf("insert into %s(%s) values ('rebuild')", ftsTable, ftsTable))
+		if err != nil {
+			return "", err
+		}
+		return kind, nil
+	}
+
+	return "", err
+}
+
+// postgresDocument is the expression indexed on postgres, queries have to use
+// the same expression for the index to be used
+func postgresDocument(tableName string, columns []string) string {
+	parts := make([]string, 0)
+	for _, column := range columns {
+		parts = append(parts, fmt.Sprintf("coalesce(%s.%s::text, '')", tableName, column))
+	}
+	return "to_tsvector('simple', " + strings.Join(parts, " || ' ' || ") + ")"
+}
+
+func fullTextIndexName(tableName string, columns []string) string {
+	return "f" + GetMD5Hash("fulltext_"+tableName+"_"+strings.Join(columns, "_"))
+}
+
+func createPostgresFullTextIndex(db database.DatabaseConnection, tableName string, columns []string) error {
+	document := strings.Replace(postgresDocument(tableName, columns), tableName+".", "", -1)
+	_, err := db.Exec(fmt.Sprintf("create index if not exists %s on %s using gin (%s)",
+		fullTextIndexName(tableName, columns), tableName, document))
+	return err
+}
+
+func createMysqlFullTextIndex(db database.DatabaseConnection, tableName string, columns []string) error {
+
+	indexName := fullTextIndexName(tableName, columns)
+
+	var count int
+	err := db.QueryRowx("select count(*) from information_schema.statistics where table_schema = database() and table_name = ? and index_name = ?",
+		tableName, indexName).Scan(&count)
+	if err == nil && count > 0 {
+		return nil
+	}
+
+	_, err = db.Exec(fmt.Sprintf("create fulltext index %s on %s (%s)", indexName, tableName, strings.Join(columns, ", ")))
+	return err
+}
+
+// SearchTerm is a word, a prefix (word*) or a quoted phrase of a search query
+type SearchTerm struct {
+	Words  []string
+	Prefix bool
+}
+
+// ParseSearchQuery splits a search query into terms. Words are lower cased and
+// stripped to letters and digits so the terms can be passed to any of the
+// native full text query syntaxes.
+func ParseSearchQuery(query string) []SearchTerm {
+
+	terms := make([]SearchTerm, 0)
+	runes := []rune(query)
+
+	for i := 0; i < len(runes); {
+		if runes[i] == '"' {
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			words := searchWords(string(runes[i+1 : end]))
+			i = end + 1
+			prefix := i < len(runes) && runes[i] == '*'
+			if len(words) > 0 {
+				terms = append(terms, SearchTerm{Words: words, Prefix: prefix})
+			}
+			continue
+		}
+
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+
+		end := i
+		for end < len(runes) && !unicode.IsSpace(runes[end]) && runes[end] != '"' {
+			end++
+		}
+		token := string(runes[i:end])
+		i = end
+
+		prefix := strings.HasSuffix(token, "*")
+		for _, word := range searchWords(token) {
+			terms = append(terms, SearchTerm{Words: []string{word}})
+		}
+		if prefix && len(terms) > 0 {
+			terms[len(terms)-1].Prefix = true
+		}
+	}
+
+	return terms
+}
+
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// fullTextQuery converts the terms to the query syntax of the index
+func fullTextQuery(kind string, terms []SearchTerm) string {
+
+	parts := make([]string, 0)
+	for _, term := range terms {
+		switch kind {
+		case fullTextSqliteFts5:
+			part := `"` + strings.Join(term.Words, " ") + `"`
+			if term.Prefix {
+				part += "*"
+			}
+			parts = append(parts, part)
+		case fullTextSqliteFts4:
+			part := strings.Join(term.Words, " ")
+			if term.Prefix {
+				part += "*"
+			}
+			parts = append(parts, `"`+part+`"`)
+		case fullTextPostgres:
+			part := strings.Join(term.Words, " <-> ")
+			if term.Prefix {
+				part += ":*"
+			}
+			parts = append(parts, part)
+		case fullTextMysql:
+			if term.Prefix {
+				for _, word := range term.Words[:len(term.Words)-1] {
+					parts = append(parts, "+"+word)
+				}
+				parts = append(parts, "+"+term.Words[len(term.Words)-1]+"*")
+			} else if len(term.Words) > 1 {
+				parts = append(parts, `+"`+strings.Join(term.Words, " ")+`"`)
+			} else {
+				parts = append(parts, "+"+term.Words[0])
+			}
+		}
+	}
+
+	if kind == fullTextPostgres {
+		return strings.Join(parts, " & ")
+	}
+	return strings.Join(parts, " ")
+}
+
+// fullTextCondition returns the where clause matching the rows of the table for
+// the terms, using the native index when there is one
+func (dr *DbResource) fullTextCondition(terms []SearchTerm, columns []string) (string, []interface{}) {
+
+	tableName := dr.tableInfo.TableName
+	kind := fullTextIndexKind(tableName)
+	query := fullTextQuery(kind, terms)
+
+	switch kind {
+	case fullTextSqliteFts5, fullTextSqliteFts4:
+		rowId := "rowid"
+		if kind == fullTextSqliteFts4 {
+			rowId = "docid"
+		}
+		return fmt.Sprintf("%s.id in (select %s from %s_fts where %s_fts match ?)", tableName, rowId, tableName, tableName), []interface{}{query}
+	case fullTextPostgres:
+		return postgresDocument(tableName, columns) + " @@ to_tsquery('simple', ?)", []interface{}{query}
+	case fullTextMysql:
+		return fmt.Sprintf("match (%s) against (? in boolean mode)", tableName+"."+strings.Join(columns, ", "+tableName+".")), []interface{}{query}
+	}
+
+	// no native index, every term has to be in one of the columns
+	conditions := make([]string, 0)
+	args := make([]interface{}, 0)
+	for _, term := range terms {
+		columnConditions := make([]string, 0)
+		for _, column := range columns {
+			columnConditions = append(columnConditions, fmt.Sprintf("%s.%s like ?", tableName, column))
+			args = append(args, "%"+strings.Join(term.Words, " ")+"%")
+		}
+		conditions = append(conditions, "("+strings.Join(columnConditions, " or ")+")")
+	}
+	return strings.Join(conditions, " and "), args
+}
+
+// fullTextRank is the relevance of a row used to pick the best rows of the
+// table, higher is better. Without a ranking index the matching terms and
+// columns are counted.
+func (dr *DbResource) fullTextRank(terms []SearchTerm, columns []string) (string, []interface{}) {
+
+	tableName := dr.tableInfo.TableName
+	kind := fullTextIndexKind(tableName)
+	query := fullTextQuery(kind, terms)
+
+	switch kind {
+	case fullTextSqliteFts5:
+		return fmt.Sprintf("(select -bm25(%s_fts) from %s_fts where %s_fts match ? and rowid = %s.id)",
+			tableName, tableName, tableName, tableName), []interface{}{query}
+	case fullTextPostgres:
+		return fmt.Sprintf("ts_rank(%s, to_tsquery('simple', ?))", postgresDocument(tableName, columns)), []interface{}{query}
+	case fullTextMysql:
+		return fmt.Sprintf("match (%s) against (? in boolean mode)", tableName+"."+strings.Join(columns, ", "+tableName+".")), []interface{}{query}
+	}
+
+	matches := make([]string, 0)
+	args := make([]interface{}, 0)
+	for _, term := range terms {
+		for _, column := range columns {
+			matches = append(matches, fmt.Sprintf("(case when %s.%s like ? then 1 else 0 end)", tableName, column))
+			args = append(args, "%"+strings.Join(term.Words, " ")+"%")
+		}
+	}
+	return "(" + strings.Join(matches, " + ") + ")", args
+}
+
+// SearchResult is a row matching a full text search
+type SearchResult struct {
+	Type        string
+	ReferenceId string
+	Rank        float64
+	Snippet     string
+	Attributes  map[string]interface{}
+}
+
+// FullTextSearch returns up to limit rows matching the search query which the
+// user can read, in the order of their relevance. The native index picks the
+// best rows of the table, the rank of a row is the number of its words matching
+// the query so the results of several tables can be sorted together.
+func (dr *DbResource) FullTextSearch(query string, sessionUser *auth.SessionUser, limit int) ([]SearchResult, error) {
+
+	results := make([]SearchResult, 0)
+
+	terms := ParseSearchQuery(query)
+	searchColumns := dr.tableInfo.GetSearchableColumns()
+	if len(terms) == 0 || len(searchColumns) == 0 || sessionUser == nil {
+		return results, nil
+	}
+
+	tableName := dr.tableInfo.TableName
+	columns := make([]string, 0)
+	selectColumns := []string{tableName + ".reference_id", tableName + ".permission"}
+	for _, column := range searchColumns {
+		columns = append(columns, column.ColumnName)
+		selectColumns = append(selectColumns, tableName+"."+column.ColumnName)
+	}
+
+	condition, conditionArgs := dr.fullTextCondition(terms, columns)
+	rank, rankArgs := dr.fullTextRank(terms, columns)
+
+	builder := statementbuilder.Squirrel.Select(selectColumns...).From(tableName).
+		Where(condition, conditionArgs...).
+		Column(squirrel.Alias(squirrel.Expr(rank, rankArgs...), "search_rank")).
+		OrderBy("search_rank desc", tableName+".id")
+	if dr.model.HasColumn(USER_ACCOUNT_ID_COLUMN) {
+		builder = builder.Column("search_owner.reference_id as " + USER_ACCOUNT_ID_COLUMN).
+			LeftJoin(fmt.Sprintf("user_account search_owner on search_owner.id = %s.%s", tableName, USER_ACCOUNT_ID_COLUMN))
+	}
+
+	isAdmin := sessionUser.UserReferenceId == dr.GetAdminReferenceId()
+	if !isAdmin {
+		readable, readableArgs, err := dr.readableRowsCondition(tableName, tableName, sessionUser)
+		if err != nil {
+			return nil, err
+		}
+		if readable != "" {
+			builder = builder.Where(readable, readableArgs...)
+		}
+	}
+
+	// the sql condition leaves out most of the rows the user cannot read, the
+	// permissions of the rows are checked a page at a time until there are
+	// enough readable rows
+	pageSize := limit * 2
+	if pageSize < 50 {
+		pageSize = 50
+	}
+	for offset := 0; len(results) < limit; offset += pageSize {
+
+		sql, args, err := builder.Limit(uint64(pageSize)).Offset(uint64(offset)).ToSql()
+		if err != nil {
+			return nil, err
+		}
+		rows, err := dr.db.Queryx(sql, args...)
+		if err != nil {
+			return nil, err
+		}
+		rowMaps, err := RowsToMap(rows, dr.model.GetName())
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		var permissions []PermissionInstance
+		if !isAdmin {
+			for _, row := range rowMaps {
+				if row[USER_ACCOUNT_ID_COLUMN] == nil {
+					row[USER_ACCOUNT_ID_COLUMN] = ""
+				}
+			}
+			permissions = dr.GetRowPermissions(rowMaps)
+		}
+
+		for i, row := range rowMaps {
+			if len(results) >= limit {
+				break
+			}
+			if !isAdmin && !permissions[i].CanRead(sessionUser.UserReferenceId, sessionUser.Groups) {
+				continue
+			}
+
+			texts := make([]string, 0)
+			attributes := make(map[string]interface{})
+			for _, column := range columns {
+				attributes[column] = row[column]
+				texts = append(texts, AsStringOrEmpty(row[column]))
+			}
+
+			results = append(results, SearchResult{
+				Type:        dr.model.GetName(),
+				ReferenceId: AsStringOrEmpty(row["reference_id"]),
+				Rank:        searchScore(texts, terms),
+				Snippet:     SearchSnippet(texts, terms, 160),
+				Attributes:  attributes,
+			})
+		}
+
+		if len(rowMaps) < pageSize {
+			break
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Rank > results[j].Rank
+	})
+	return results, nil
+}
+
+// searchTokens splits text into lower cased words along with their position
+func searchTokens(text []rune) (words []string, starts []int, ends []int) {
+	for i := 0; i < len(text); {
+		if !unicode.IsLetter(text[i]) && !unicode.IsDigit(text[i]) {
+			i++
+			continue
+		}
+		end := i
+		for end < len(text) && (unicode.IsLetter(text[end]) || unicode.IsDigit(text[end])) {
+			end++
+		}
+		words = append(words, strings.ToLower(string(text[i:end])))
+		starts = append(starts, i)
+		ends = append(ends, end)
+		i = end
+	}
+	return
+}
+
+func searchTermMatches(word string, terms []SearchTerm) bool {
+	for _, term := range terms {
+		for i, termWord := range term.Words {
+			if word == termWord || (term.Prefix && i == len(term.Words)-1 && strings.HasPrefix(word, termWord)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// searchScore counts the words matching the terms, the same for every table
+// whatever index it has
+func searchScore(texts []string, terms []SearchTerm) float64 {
+	score := 0.0
+	for _, text := range texts {
+		words, _, _ := searchTokens([]rune(text))
+		for _, word := range words {
+			if searchTermMatches(word, terms) {
+				score += 1
+			}
+		}
+	}
+	return score
+}
+
+// SearchSnippet returns an html escaped part of the first text matching the
+// terms, about width characters long, with the matching words in <mark> tags
+func SearchSnippet(texts []string, terms []SearchTerm, width int) string {
+
+	for _, text := range texts {
+		runes := []rune(text)
+		words, starts, ends := searchTokens(runes)
+
+		first := -1
+		for i, word := range words {
+			if searchTermMatches(word, terms) {
+				first = i
+				break
+			}
+		}
+		if first == -1 {
+			continue
+		}
+
+		from := starts[first] - width/3
+		if from < 0 {
+			from = 0
+		}
+		// start the snippet at a word
+		for i := range starts {
+			if starts[i] >= from {
+				from = starts[i]
+				break
+			}
+		}
+		to := from + width
+		if to > len(runes) {
+			to = len(runes)
+		} else {
+			// and end it after a word
+			for i := len(ends) - 1; i > first; i-- {
+				if ends[i] <= to {
+					to = ends[i]
+					break
+				}
+			}
+		}
+
+		var snippet strings.Builder
+		if from > 0 {
+			snippet.WriteString("…")
+		}
+		position := from
+		for i, word := range words {
+			if starts[i] < from || ends[i] > to || !searchTermMatches(word, terms) {
+				continue
+			}
+			snippet.WriteString(html.EscapeString(string(runes[position:starts[i]])))
+			snippet.WriteString("<mark>" + html.EscapeString(string(runes[starts[i]:ends[i]])) + "</mark>")
+			position = ends[i]
+		}
+		snippet.WriteString(html.EscapeString(string(runes[position:to])))
+		if to < len(runes) {
+			snippet.WriteString("…")
+		}
+		return snippet.String()
+	}
+
+	return ""
+}
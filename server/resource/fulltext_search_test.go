@@ -0,0 +1,155 @@
+package resource
+
+import (
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"strings"
+	"testing"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+
+	terms := ParseSearchQuery(`Invoice "Due  date" pay* "net 30"* x-ray`)
+	if len(terms) != 6 {
+		t.Fatalf("Expected 6 terms: %v", terms)
+	}
+
+	if terms[1].Prefix || len(terms[1].Words) != 2 || terms[1].Words[1] != "date" {
+		t.Errorf("Unexpected phrase term: %v", terms[1])
+	}
+	if !terms[2].Prefix || !terms[3].Prefix || terms[0].Prefix {
+		t.Errorf("Unexpected prefix terms: %v", terms)
+	}
+
+	expected := map[string]string{
+		fullTextSqliteFts5: `"invoice" "due date" "pay"* "net 30"* "x" "ray"`,
+		fullTextSqliteFts4: `"invoice" "due date" "pay*" "net 30*" "x" "ray"`,
+		fullTextPostgres:   `invoice & due <-> date & pay:* & net <-> 30:* & x & ray`,
+		fullTextMysql:      `+invoice +"due date" +pay* +net +30* +x +ray`,
+	}
+	for kind, query := range expected {
+		if actual := fullTextQuery(kind, terms); actual != query {
+			t.Errorf("Unexpected %v query: %v", kind, actual)
+		}
+	}
+
+	if len(ParseSearchQuery(`"" * ' ; --`)) != 0 {
+		t.Errorf("Expected no terms for punctuation")
+	}
+}
+
+func TestSearchSnippet(t *testing.T) {
+
+	terms := ParseSearchQuery("pay*")
+	snippet := SearchSnippet([]string{"no match here", "Please <b>pay</b> the invoice, payment is due"}, terms, 30)
+	if snippet != "Please &lt;b&gt;<mark>pay</mark>&lt;/b&gt; the invoice…" {
+		t.Errorf("Unexpected snippet: %v", snippet)
+	}
+
+	if searchScore([]string{"pay payment paid"}, terms) != 2 {
+		t.Errorf("Unexpected score")
+	}
+}
+
+// searchFixture is a note table whose title and body are searchable, with the
+// notes of the reader and of another user
+func searchFixture(t *testing.T) (map[string]*DbResource, *auth.SessionUser, *auth.SessionUser, func()) {
+
+	cruds, cleanup := newTestCruds(t)
+	reader := insertTestUser(t, cruds, "reader", "reader@example.com")
+	admin := insertTestUser(t, cruds, "admin", "admin@example.com")
+	db := cruds["world"].connection
+
+	noteTable := TableInfo{
+		TableName:         "note",
+		SearchableColumns: []string{"title", "body"},
+		Columns: []api2go.ColumnInfo{
+			{Name: "title", ColumnName: "title", ColumnType: "label", DataType: "varchar(100)"},
+			{Name: "body", ColumnName: "body", ColumnType: "content", DataType: "text"},
+			{
+				Name:           USER_ACCOUNT_ID_COLUMN,
+				ColumnName:     USER_ACCOUNT_ID_COLUMN,
+				ColumnType:     "alias",
+				DataType:       "int(11)",
+				IsNullable:     true,
+				IsForeignKey:   true,
+				ForeignKeyData: api2go.ForeignKeyData{DataSource: "self", Namespace: USER_ACCOUNT_TABLE_NAME, KeyName: "id"},
+			},
+		},
+	}
+	config := &CmsConfig{
+		Tables: []TableInfo{noteTable},
+	}
+	tx := db.MustBegin()
+	CheckAllTableStatus(config, db, tx)
+	if err := tx.Commit(); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	noteTable = config.Tables[0]
+	model := api2go.NewApi2GoModel(noteTable.TableName, noteTable.Columns, int64(noteTable.DefaultPermission), noteTable.Relations)
+	cruds["note"] = NewDbResource(model, db, &MiddlewareSet{}, cruds, nil, noteTable)
+	insertTestRow(t, cruds, "world", map[string]interface{}{"table_name": "note", "world_schema_json": "{}"})
+
+	// the best matches belong to the admin
+	for i := 0; i < 60; i++ {
+		insertTestRow(t, cruds, "note", map[string]interface{}{
+			"title":                "invoice invoice",
+			"body":                 "the invoice of the admin",
+			USER_ACCOUNT_ID_COLUMN: admin.UserId,
+		})
+	}
+	for _, title := range []string{"invoice", "paid invoice"} {
+		insertTestRow(t, cruds, "note", map[string]interface{}{
+			"title":                title,
+			"body":                 "an invoice of the reader",
+			USER_ACCOUNT_ID_COLUMN: reader.UserId,
+		})
+	}
+
+	return cruds, reader, admin, cleanup
+}
+
+func TestFullTextSearch(t *testing.T) {
+
+	cruds, reader, admin, cleanup := searchFixture(t)
+	defer cleanup()
+	notes := cruds["note"]
+
+	// without an index the terms are matched with like, then with the native index
+	for _, indexed := range []bool{false, true} {
+		if indexed {
+			CreateFullTextIndexes(&CmsConfig{Tables: []TableInfo{*notes.tableInfo}}, notes.connection)
+			defer CreateFullTextIndexes(&CmsConfig{}, notes.connection)
+			if fullTextIndexKind("note") == "" {
+				t.Fatalf("Expected a full text index on the notes")
+			}
+		}
+
+		results, err := notes.FullTextSearch("invoice", reader, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 2 {
+			t.Fatalf("Expected the 2 notes of the reader behind the notes of the admin, found %d", len(results))
+		}
+		for _, result := range results {
+			if result.Rank != 2 || result.Type != "note" || !strings.Contains(AsStringOrEmpty(result.Attributes["body"]), "reader") {
+				t.Errorf("Unexpected result for the reader: %+v", result)
+			}
+		}
+
+		results, err = notes.FullTextSearch("invoice", admin, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 3 || results[0].Rank != 3 {
+			t.Errorf("Expected the 3 best notes for the admin, found %+v", results)
+		}
+
+		results, err = notes.FullTextSearch("paid", reader, 5)
+		if err != nil || len(results) != 1 || results[0].Snippet != "<mark>paid</mark> invoice" {
+			t.Errorf("Expected the paid invoice, found %+v: %v", results, err)
+		}
+	}
+}
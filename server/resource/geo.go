@@ -0,0 +1,452 @@
+package resource
+
+import (
+	"errors"
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/database"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// mean earth radius in meters, distances are in meters
+const earthRadiusMeters = 6371008.8
+
+// spatial extensions found on the database, distances are computed with them
+// when available instead of the haversine formula
+const (
+	geoSupportPostgis    = "postgis"
+	geoSupportSpatialite = "spatialite"
+)
+
+var geoSupport string
+var geoSupportLock sync.RWMutex
+
+func currentGeoSupport() string {
+	geoSupportLock.RLock()
+	defer geoSupportLock.RUnlock()
+	return geoSupport
+}
+
+// location columns keep the point as "[latitude, longitude]" text, the
+// coordinates are copied to these two hidden columns so they can be queried
+func locationLatitudeColumn(columnName string) string {
+	return columnName + "_latitude"
+}
+
+func locationLongitudeColumn(columnName string) string {
+	return columnName + "_longitude"
+}
+
+// LocationCoordinateColumns returns the hidden coordinate columns for the
+// location columns of the table which are not present yet
+func LocationCoordinateColumns(tableInfo *TableInfo) []api2go.ColumnInfo {
+
+	existing := make(map[string]bool)
+	for _, column := range tableInfo.Columns {
+		existing[column.ColumnName] = true
+	}
+
+	columns := make([]api2go.ColumnInfo, 0)
+	for _, column := range tableInfo.Columns {
+		if column.ColumnType != "location" {
+			continue
+		}
+		for _, coordinate := range []struct {
+			name       string
+			columnType string
+		}{
+			{locationLatitudeColumn(column.ColumnName), "location.latitude"},
+			{locationLongitudeColumn(column.ColumnName), "location.longitude"},
+		} {
+			if existing[coordinate.name] {
+				continue
+			}
+			columns = append(columns, api2go.ColumnInfo{
+				Name:           coordinate.name,
+				ColumnName:     coordinate.name,
+				ColumnType:     coordinate.columnType,
+				DataType:       "float",
+				IsNullable:     true,
+				IsIndexed:      true,
+				ExcludeFromApi: true,
+			})
+		}
+	}
+	return columns
+}
+
+// ParseLocation reads a point from "[lat, lng]" or "lat,lng" text, a [lat, lng]
+// array, a {"latitude": .., "longitude": ..} object or a GeoJSON point
+func ParseLocation(value interface{}) (float64, float64, error) {
+
+	var latitude, longitude float64
+	switch v := value.(type) {
+	case string:
+		text := strings.TrimSpace(v)
+		if strings.HasPrefix(text, "{") {
+			var point map[string]interface{}
+			err := json.Unmarshal([]byte(text), &point)
+			if err != nil {
+				return 0, 0, err
+			}
+			return ParseLocation(point)
+		}
+		numbers, err := geoNumbers(strings.Trim(text, "[]() "), 2)
+		if err != nil {
+			return 0, 0, err
+		}
+		latitude, longitude = numbers[0], numbers[1]
+	case []interface{}:
+		numbers, err := geoNumbers(v, 2)
+		if err != nil {
+			return 0, 0, err
+		}
+		latitude, longitude = numbers[0], numbers[1]
+	case map[string]interface{}:
+		if coordinates, ok := v["coordinates"]; ok {
+			// GeoJSON has the longitude first
+			numbers, err := geoNumbers(coordinates, 2)
+			if err != nil {
+				return 0, 0, err
+			}
+			latitude, longitude = numbers[1], numbers[0]
+		} else {
+			numbers, err := geoNamedNumbers(v, []string{"latitude", "longitude"})
+			if err != nil {
+				return 0, 0, err
+			}
+			latitude, longitude = numbers[0], numbers[1]
+		}
+	default:
+		return 0, 0, fmt.Errorf("invalid location [%v]", value)
+	}
+
+	if latitude < -90 || latitude > 90 || longitude < -180 || longitude > 180 {
+		return 0, 0, fmt.Errorf("location [%v, %v] is out of range", latitude, longitude)
+	}
+	return latitude, longitude, nil
+}
+
+// geoNumbers reads count numbers from an array or comma separated text
+func geoNumbers(value interface{}, count int) ([]float64, error) {
+
+	var parts []interface{}
+	switch v := value.(type) {
+	case []interface{}:
+		parts = v
+	case []float64:
+		for _, number := range v {
+			parts = append(parts, number)
+		}
+	case string:
+		for _, part := range strings.Split(v, ",") {
+			parts = append(parts, strings.TrimSpace(part))
+		}
+	default:
+		return nil, fmt.Errorf("expected %d numbers, got [%v]", count, value)
+	}
+
+	if len(parts) != count {
+		return nil, fmt.Errorf("expected %d numbers, got [%v]", count, value)
+	}
+
+	numbers := make([]float64, count)
+	for i, part := range parts {
+		number, err := strconv.ParseFloat(fmt.Sprintf("%v", part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("expected a number, got [%v]", part)
+		}
+		numbers[i] = number
+	}
+	return numbers, nil
+}
+
+// short names accepted for the keys of geo values
+var geoKeyAliases = map[string]string{
+	"latitude":  "lat",
+	"longitude": "lng",
+}
+
+// geoNamedNumbers reads the numbers with the names from an object, an array or
+// comma separated text in the order of the names
+func geoNamedNumbers(value interface{}, names []string) ([]float64, error) {
+
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return geoNumbers(value, len(names))
+	}
+
+	values := make([]interface{}, 0)
+	for _, name := range names {
+		number, ok := object[name]
+		if !ok {
+			number, ok = object[geoKeyAliases[name]]
+		}
+		if !ok {
+			return nil, fmt.Errorf("%v is required", name)
+		}
+		values = append(values, number)
+	}
+	return geoNumbers(values, len(names))
+}
+
+// FormatLocation is how location columns are stored
+func FormatLocation(latitude float64, longitude float64) string {
+	return fmt.Sprintf("[%v, %v]", latitude, longitude)
+}
+
+// SetLocationCoordinates normalizes the values of the location columns in the
+// row and sets their coordinate columns
+func (dr *DbResource) SetLocationCoordinates(row map[string]interface{}) error {
+
+	for _, column := range dr.tableInfo.Columns {
+		if column.ColumnType != "location" {
+			continue
+		}
+
+		delete(row, locationLatitudeColumn(column.ColumnName))
+		delete(row, locationLongitudeColumn(column.ColumnName))
+
+		value, ok := row[column.ColumnName]
+		if !ok {
+			continue
+		}
+		if value == nil || value == "" {
+			row[locationLatitudeColumn(column.ColumnName)] = nil
+			row[locationLongitudeColumn(column.ColumnName)] = nil
+			continue
+		}
+
+		latitude, longitude, err := ParseLocation(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for %v: %v", column.ColumnName, err)
+		}
+		row[column.ColumnName] = FormatLocation(latitude, longitude)
+		row[locationLatitudeColumn(column.ColumnName)] = latitude
+		row[locationLongitudeColumn(column.ColumnName)] = longitude
+	}
+	return nil
+}
+
+// Haversine is the great circle distance in meters between two points, it is
+// registered as a function on sqlite which has no trigonometric functions
+func Haversine(latitude1, longitude1, latitude2, longitude2 float64) float64 {
+	toRadians := math.Pi / 180
+	deltaLatitude := (latitude2 - latitude1) * toRadians
+	deltaLongitude := (longitude2 - longitude1) * toRadians
+	a := math.Pow(math.Sin(deltaLatitude/2), 2) +
+		math.Cos(latitude1*toRadians)*math.Cos(latitude2*toRadians)*math.Pow(math.Sin(deltaLongitude/2), 2)
+	return 2 * earthRadiusMeters * math.Asin(math.Sqrt(a))
+}
+
+// geoFloat formats the number as a float literal, sqlite passes integer
+// literals to functions as integers
+func geoFloat(value float64) string {
+	text := strconv.FormatFloat(value, 'f', -1, 64)
+	if !strings.Contains(text, ".") {
+		text += ".0"
+	}
+	return text
+}
+
+// geoDistanceExpression is the sql for the distance in meters between the
+// point in the coordinate columns and the given point
+func geoDistanceExpression(driverName string, latitudeColumn string, longitudeColumn string, latitude float64, longitude float64) string {
+
+	switch currentGeoSupport() {
+	case geoSupportPostgis:
+		return fmt.Sprintf("ST_Distance(geography(ST_MakePoint(%s, %s)), geography(ST_MakePoint(%s, %s)))",
+			longitudeColumn, latitudeColumn, geoFloat(longitude), geoFloat(latitude))
+	case geoSupportSpatialite:
+		return fmt.Sprintf("ST_Distance(MakePoint(%s, %s, 4326), MakePoint(%s, %s, 4326), 1)",
+			longitudeColumn, latitudeColumn, geoFloat(longitude), geoFloat(latitude))
+	}
+
+	if driverName == "sqlite3" {
+		return fmt.Sprintf("haversine(%s, %s, %s, %s)", latitudeColumn, longitudeColumn, geoFloat(latitude), geoFloat(longitude))
+	}
+
+	return fmt.Sprintf("(%s * 2 * asin(sqrt(power(sin(radians(%s - %s) / 2), 2) + cos(radians(%s)) * cos(radians(%s)) * power(sin(radians(%s - %s) / 2), 2))))",
+		geoFloat(earthRadiusMeters), latitudeColumn, geoFloat(latitude), geoFloat(latitude), latitudeColumn, longitudeColumn, geoFloat(longitude))
+}
+
+// geoBoxCondition matches points inside the box, boxes crossing the
+// antimeridian have west > east
+func geoBoxCondition(latitudeColumn string, longitudeColumn string, south, west, north, east float64) string {
+	condition := fmt.Sprintf("%s between %s and %s", latitudeColumn, geoFloat(south), geoFloat(north))
+	if west <= east {
+		return condition + fmt.Sprintf(" and %s between %s and %s", longitudeColumn, geoFloat(west), geoFloat(east))
+	}
+	return condition + fmt.Sprintf(" and (%s >= %s or %s <= %s)", longitudeColumn, geoFloat(west), longitudeColumn, geoFloat(east))
+}
+
+// geoRadiusBox is a box around the circle, checked before the distance so the
+// index on the coordinate columns can be used
+func geoRadiusBox(latitude float64, longitude float64, radius float64) (float64, float64, float64, float64) {
+
+	deltaLatitude := radius / earthRadiusMeters * 180 / math.Pi
+	south := math.Max(latitude-deltaLatitude, -90)
+	north := math.Min(latitude+deltaLatitude, 90)
+	if south == -90 || north == 90 {
+		return south, -180, north, 180
+	}
+
+	deltaLongitude := deltaLatitude / math.Cos(latitude*math.Pi/180)
+	if deltaLongitude >= 180 {
+		return south, -180, north, 180
+	}
+	west := longitude - deltaLongitude
+	east := longitude + deltaLongitude
+	if west < -180 {
+		west += 360
+	}
+	if east > 180 {
+		east -= 360
+	}
+	return south, west, north, east
+}
+
+// isLocationColumn checks that the column of the table has the location type
+func (dr *DbResource) isLocationColumn(tableName string, columnName string) bool {
+	crud, ok := dr.Cruds[tableName]
+	if !ok {
+		return false
+	}
+	column, ok := crud.tableInfo.GetColumnByName(columnName)
+	return ok && column.ColumnName == columnName && column.ColumnType == "location"
+}
+
+// geoCondition builds the condition for the geo operators on a location column
+//
+//	within distance: {"latitude": 18.52, "longitude": 73.85, "radius": 5000} or "18.52,73.85,5000"
+//	within box:      {"south": 18.4, "west": 73.7, "north": 18.6, "east": 73.9} or "18.4,73.7,18.6,73.9"
+//	nearest:         {"latitude": 18.52, "longitude": 73.85, "count": 10} or "18.52,73.85,10"
+func (dr *DbResource) geoCondition(query Query, operator string, tableName string, alias string) (string, error) {
+
+	if !dr.isLocationColumn(tableName, query.ColumnName) {
+		return "", fmt.Errorf("[%v] of [%v] is not a location column", query.ColumnName, tableName)
+	}
+
+	latitudeColumn := alias + "." + locationLatitudeColumn(query.ColumnName)
+	longitudeColumn := alias + "." + locationLongitudeColumn(query.ColumnName)
+
+	switch operator {
+	case "within distance":
+		numbers, err := geoNamedNumbers(query.Value, []string{"latitude", "longitude", "radius"})
+		if err != nil {
+			return "", err
+		}
+		latitude, longitude, radius := numbers[0], numbers[1], numbers[2]
+		south, west, north, east := geoRadiusBox(latitude, longitude, radius)
+
+		if currentGeoSupport() == geoSupportPostgis {
+			return fmt.Sprintf("ST_DWithin(geography(ST_MakePoint(%s, %s)), geography(ST_MakePoint(%s, %s)), %s)",
+				longitudeColumn, latitudeColumn, geoFloat(longitude), geoFloat(latitude), geoFloat(radius)), nil
+		}
+		return fmt.Sprintf("(%s and %s <= %s)", geoBoxCondition(latitudeColumn, longitudeColumn, south, west, north, east),
+			geoDistanceExpression(dr.driverName(), latitudeColumn, longitudeColumn, latitude, longitude), geoFloat(radius)), nil
+
+	case "within box":
+		numbers, err := geoNamedNumbers(query.Value, []string{"south", "west", "north", "east"})
+		if err != nil {
+			return "", err
+		}
+		return "(" + geoBoxCondition(latitudeColumn, longitudeColumn, numbers[0], numbers[1], numbers[2], numbers[3]) + ")", nil
+
+	case "nearest":
+		numbers, err := geoNamedNumbers(query.Value, []string{"latitude", "longitude", "count"})
+		if err != nil {
+			return "", err
+		}
+		if numbers[2] < 1 {
+			return "", errors.New("count has to be at least 1")
+		}
+		nearAlias := alias + "_near"
+		distance := geoDistanceExpression(dr.driverName(),
+			nearAlias+"."+locationLatitudeColumn(query.ColumnName), nearAlias+"."+locationLongitudeColumn(query.ColumnName), numbers[0], numbers[1])
+		// the extra sub query lets mysql use a limit inside in ()
+		return fmt.Sprintf("%s.id in (select id from (select %s.id from %s %s where %s.%s is not null order by %s limit %d) %s_ids)",
+			alias, nearAlias, tableName, nearAlias, nearAlias, locationLatitudeColumn(query.ColumnName), distance, int(numbers[2]), nearAlias), nil
+	}
+
+	return "", fmt.Errorf("unknown query operator [%v]", query.Operator)
+}
+
+// NearDistanceColumn reads the near parameter, "latitude,longitude" or
+// "column:latitude,longitude", and returns the sql for the distance of each row
+// from that point
+func (dr *DbResource) NearDistanceColumn(near string) (string, error) {
+
+	tableName := dr.tableInfo.TableName
+	columnName := ""
+	point := near
+	if parts := strings.SplitN(near, ":", 2); len(parts) == 2 {
+		columnName, point = parts[0], parts[1]
+	} else {
+		for _, column := range dr.tableInfo.Columns {
+			if column.ColumnType == "location" {
+				columnName = column.ColumnName
+				break
+			}
+		}
+	}
+
+	if columnName == "" || !dr.isLocationColumn(tableName, columnName) {
+		return "", fmt.Errorf("no location column [%v] on [%v]", columnName, tableName)
+	}
+
+	latitude, longitude, err := ParseLocation(point)
+	if err != nil {
+		return "", err
+	}
+
+	return geoDistanceExpression(dr.driverName(), tableName+"."+locationLatitudeColumn(columnName),
+		tableName+"."+locationLongitudeColumn(columnName), latitude, longitude), nil
+}
+
+// CreateGeoIndexes finds out if PostGIS or SpatiaLite are available, and adds
+// spatial indexes for the location columns on PostGIS
+func CreateGeoIndexes(initConfig *CmsConfig, db database.DatabaseConnection) {
+
+	support := ""
+	var version string
+	switch db.DriverName() {
+	case "postgres":
+		if db.QueryRowx("select extversion from pg_extension where extname = 'postgis'").Scan(&version) == nil {
+			support = geoSupportPostgis
+		}
+	case "sqlite3":
+		if db.QueryRowx("select spatialite_version()").Scan(&version) == nil {
+			support = geoSupportSpatialite
+		}
+	}
+	if support != "" {
+		log.Infof("Using %v %v for location queries", support, version)
+	}
+
+	geoSupportLock.Lock()
+	geoSupport = support
+	geoSupportLock.Unlock()
+
+	if support != geoSupportPostgis {
+		return
+	}
+
+	for _, table := range initConfig.Tables {
+		for _, column := range table.Columns {
+			if column.ColumnType != "location" {
+				continue
+			}
+			indexName := "g" + GetMD5Hash("geo_index_"+table.TableName+"_"+column.ColumnName)
+			_, err := db.Exec(fmt.Sprintf("create index if not exists %s on %s using gist (geography(ST_MakePoint(%s, %s)))",
+				indexName, table.TableName, locationLongitudeColumn(column.ColumnName), locationLatitudeColumn(column.ColumnName)))
+			if err != nil {
+				log.Errorf("Failed to create spatial index on [%v][%v]: %v", table.TableName, column.ColumnName, err)
+			}
+		}
+	}
+}
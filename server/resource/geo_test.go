@@ -0,0 +1,46 @@
+package resource
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseLocation(t *testing.T) {
+
+	values := []interface{}{
+		"[18.5204, 73.8567]",
+		"18.5204,73.8567",
+		[]interface{}{18.5204, 73.8567},
+		map[string]interface{}{"lat": 18.5204, "lng": "73.8567"},
+		`{"type": "Point", "coordinates": [73.8567, 18.5204]}`,
+	}
+	for _, value := range values {
+		latitude, longitude, err := ParseLocation(value)
+		if err != nil || latitude != 18.5204 || longitude != 73.8567 {
+			t.Errorf("Unexpected location from [%v]: %v %v %v", value, latitude, longitude, err)
+		}
+	}
+
+	for _, invalid := range []interface{}{"north", "[91, 0]", []interface{}{1}, 42} {
+		if _, _, err := ParseLocation(invalid); err == nil {
+			t.Errorf("Expected [%v] to be invalid", invalid)
+		}
+	}
+}
+
+func TestHaversine(t *testing.T) {
+
+	// pune to mumbai is about 120 km
+	distance := Haversine(18.5204, 73.8567, 19.0760, 72.8777)
+	if math.Abs(distance-119800) > 1000 {
+		t.Errorf("Unexpected distance: %v", distance)
+	}
+
+	south, west, north, east := geoRadiusBox(0, 179.99, 10000)
+	if south >= 0 || north <= 0 || west < east {
+		t.Errorf("Expected the box to cross the antimeridian: %v %v %v %v", south, west, north, east)
+	}
+	if Haversine(0, 179.99, north, 179.99) < 9999 {
+		t.Errorf("Box is smaller than the radius")
+	}
+}
@@ -0,0 +1,125 @@
+package resource
+
+import (
+	"database/sql"
+	"github.com/Masterminds/squirrel"
+	"github.com/artpar/go.uuid"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"time"
+)
+
+const IDEMPOTENCY_KEY_TABLE_NAME = "idempotency_key"
+
+// IdempotentResponse is the response stored for a request sent with an
+// Idempotency-Key header. Status is 0 while the request is being served. The
+// body is stored encrypted with the encryption.secret config, responses carry
+// tokens and the values of encrypted columns.
+type IdempotentResponse struct {
+	RequestHash string
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// GetIdempotentResponse returns the response stored for the key, nil when the
+// key was not used or it has expired
+func (dr *DbResource) GetIdempotentResponse(keyHash string) (*IdempotentResponse, error) {
+
+	query, args, err := statementbuilder.Squirrel.
+		Select("request_hash", "response_status", "response_content_type", "response_body").
+		From(IDEMPOTENCY_KEY_TABLE_NAME).
+		Where(squirrel.Eq{"key_hash": keyHash}).
+		Where(squirrel.Gt{"expires_at": time.Now().UTC()}).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	var response IdempotentResponse
+	var contentType, body sql.NullString
+	err = dr.db.QueryRowx(query, args...).Scan(&response.RequestHash, &response.Status, &contentType, &body)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	response.ContentType = contentType.String
+	if body.String != "" {
+		secret, err := dr.configStore.GetConfigValueFor("encryption.secret", "backend")
+		if err != nil {
+			return nil, err
+		}
+		plainBody, err := Decrypt([]byte(secret), body.String)
+		if err != nil {
+			return nil, err
+		}
+		response.Body = []byte(plainBody)
+	}
+	return &response, nil
+}
+
+// ReserveIdempotencyKey stores the key of a request which is being served. It
+// fails when the key is already stored, so only one of the concurrent requests
+// with the same key is served. Expired keys are removed first.
+func (dr *DbResource) ReserveIdempotencyKey(keyHash string, requestHash string, expiresAt time.Time) error {
+
+	query, args, err := statementbuilder.Squirrel.Delete(IDEMPOTENCY_KEY_TABLE_NAME).
+		Where(squirrel.LtOrEq{"expires_at": time.Now().UTC()}).ToSql()
+	if err != nil {
+		return err
+	}
+	_, err = dr.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	u, _ := uuid.NewV4()
+	query, args, err = statementbuilder.Squirrel.Insert(IDEMPOTENCY_KEY_TABLE_NAME).
+		Columns("reference_id", "permission", "key_hash", "request_hash", "response_status", "expires_at").
+		Values(u.String(), dr.tableInfo.DefaultPermission, keyHash, requestHash, 0, expiresAt.UTC()).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = dr.db.Exec(query, args...)
+	return err
+}
+
+// StoreIdempotentResponse sets the response of the request served with the key
+func (dr *DbResource) StoreIdempotentResponse(keyHash string, status int, contentType string, body []byte) error {
+
+	secret, err := dr.configStore.GetConfigValueFor("encryption.secret", "backend")
+	if err != nil {
+		return err
+	}
+	encryptedBody, err := Encrypt([]byte(secret), string(body))
+	if err != nil {
+		return err
+	}
+
+	query, args, err := statementbuilder.Squirrel.Update(IDEMPOTENCY_KEY_TABLE_NAME).
+		Set("response_status", status).
+		Set("response_content_type", contentType).
+		Set("response_body", encryptedBody).
+		Where(squirrel.Eq{"key_hash": keyHash}).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = dr.db.Exec(query, args...)
+	return err
+}
+
+// ReleaseIdempotencyKey removes the key of a request which failed, so it can be retried
+func (dr *DbResource) ReleaseIdempotencyKey(keyHash string) error {
+
+	query, args, err := statementbuilder.Squirrel.Delete(IDEMPOTENCY_KEY_TABLE_NAME).
+		Where(squirrel.Eq{"key_hash": keyHash}).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = dr.db.Exec(query, args...)
+	return err
+}
@@ -0,0 +1,396 @@
+package resource
+
+import (
+	"errors"
+	"github.com/artpar/go-imap"
+	"github.com/artpar/go-imap/commands"
+	"github.com/artpar/go-imap/responses"
+	"github.com/artpar/go-imap/server"
+	"strconv"
+	"strings"
+)
+
+// ImapCondStoreMailbox is implemented by mailboxes which keep a mod-sequence
+// for every message, as required by CONDSTORE (RFC 7162)
+type ImapCondStoreMailbox interface {
+	HighestModSeq() (int64, error)
+	ListMessagesChangedSince(uid bool, seqset *imap.SeqSet, items []imap.FetchItem, changedSince int64, ch chan<- *imap.Message) error
+	SearchMessagesWithModSeq(uid bool, criteria *imap.SearchCriteria, modSeq int64) ([]uint32, int64, error)
+	UpdateMessagesFlagsUnchangedSince(uid bool, seqset *imap.SeqSet, operation imap.FlagsOp, flags []string, unchangedSince int64) (*imap.SeqSet, error)
+}
+
+type imapCondStoreExtension struct{}
+
+// NewImapCondStoreExtension adds the CONDSTORE extension defined in RFC 7162:
+// HIGHESTMODSEQ on SELECT and STATUS, FETCH with CHANGEDSINCE and MODSEQ,
+// STORE with UNCHANGEDSINCE and the MODSEQ search key
+func NewImapCondStoreExtension() server.Extension {
+	return &imapCondStoreExtension{}
+}
+
+func (ext *imapCondStoreExtension) Capabilities(c server.Conn) []string {
+	if c.Context().State&imap.AuthenticatedState != 0 {
+		return []string{"CONDSTORE"}
+	}
+	return nil
+}
+
+func (ext *imapCondStoreExtension) Command(name string) server.HandlerFactory {
+	switch name {
+	case "SELECT":
+		return func() server.Handler {
+			return &imapCondStoreSelectHandler{}
+		}
+	case "EXAMINE":
+		return func() server.Handler {
+			hdlr := &imapCondStoreSelectHandler{}
+			hdlr.ReadOnly = true
+			return hdlr
+		}
+	case "FETCH":
+		return func() server.Handler {
+			return &imapCondStoreFetchHandler{}
+		}
+	case "STORE":
+		return func() server.Handler {
+			return &imapCondStoreStoreHandler{}
+		}
+	case "SEARCH":
+		return func() server.Handler {
+			return &imapCondStoreSearchHandler{}
+		}
+	}
+	return nil
+}
+
+func formatModSeq(modSeq int64) imap.RawString {
+	return imap.RawString(strconv.FormatInt(modSeq, 10))
+}
+
+func parseModSeq(field interface{}) (int64, error) {
+	value, err := imap.ParseString(field)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// parseModifier reads a modifier list such as (CHANGEDSINCE 12) and returns
+// the value of the named modifier
+func parseModifier(field interface{}, name string) (int64, bool, error) {
+
+	modifiers, ok := field.([]interface{})
+	if !ok {
+		return 0, false, nil
+	}
+
+	for i := 0; i+1 < len(modifiers); i += 2 {
+		modifierName, _ := modifiers[i].(string)
+		if strings.EqualFold(modifierName, name) {
+			value, err := parseModSeq(modifiers[i+1])
+			return value, true, err
+		}
+	}
+
+	return 0, true, errors.New("Unknown modifier")
+}
+
+type imapCondStoreSelectHandler struct {
+	server.Select
+}
+
+func (cmd *imapCondStoreSelectHandler) Handle(conn server.Conn) error {
+
+	ctx := conn.Context()
+	// a failed SELECT leaves no mailbox selected
+	ctx.Mailbox = nil
+
+	statusErr := cmd.Select.Handle(conn)
+	if ctx.Mailbox == nil {
+		return statusErr
+	}
+
+	condStoreMailbox, ok := ctx.Mailbox.(ImapCondStoreMailbox)
+	if !ok {
+		return statusErr
+	}
+
+	highestModSeq, err := condStoreMailbox.HighestModSeq()
+	if err != nil {
+		return err
+	}
+
+	err = conn.WriteResp(&imap.StatusResp{
+		Tag:       "*",
+		Type:      imap.StatusRespOk,
+		Code:      "HIGHESTMODSEQ",
+		Arguments: []interface{}{formatModSeq(highestModSeq)},
+		Info:      "Highest",
+	})
+	if err != nil {
+		return err
+	}
+
+	return statusErr
+}
+
+type imapCondStoreFetchHandler struct {
+	commands.Fetch
+	ChangedSince int64
+}
+
+func (cmd *imapCondStoreFetchHandler) Parse(fields []interface{}) error {
+
+	if len(fields) > 2 {
+		changedSince, _, err := parseModifier(fields[2], "CHANGEDSINCE")
+		if err != nil {
+			return err
+		}
+		cmd.ChangedSince = changedSince
+		fields = fields[:2]
+	}
+
+	err := cmd.Fetch.Parse(fields)
+	if err != nil {
+		return err
+	}
+
+	if cmd.ChangedSince > 0 && !hasFetchItem(cmd.Items, ImapFetchModSeq) {
+		cmd.Items = append(cmd.Items, ImapFetchModSeq)
+	}
+	return nil
+}
+
+func (cmd *imapCondStoreFetchHandler) Handle(conn server.Conn) error {
+	return imapFetch(conn, false, cmd.SeqSet, cmd.Items, cmd.ChangedSince)
+}
+
+func (cmd *imapCondStoreFetchHandler) UidHandle(conn server.Conn) error {
+	if !hasFetchItem(cmd.Items, imap.FetchUid) {
+		cmd.Items = append(cmd.Items, imap.FetchUid)
+	}
+	return imapFetch(conn, true, cmd.SeqSet, cmd.Items, cmd.ChangedSince)
+}
+
+func hasFetchItem(items []imap.FetchItem, item imap.FetchItem) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+func imapFetch(conn server.Conn, uid bool, seqSet *imap.SeqSet, items []imap.FetchItem, changedSince int64) error {
+
+	ctx := conn.Context()
+	if ctx.Mailbox == nil {
+		return server.ErrNoMailboxSelected
+	}
+
+	ch := make(chan *imap.Message)
+	res := &responses.Fetch{Messages: ch}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- conn.WriteResp(res)
+		// drain the channel in case writing failed
+		for range ch {
+		}
+	}()
+
+	var err error
+	if condStoreMailbox, ok := ctx.Mailbox.(ImapCondStoreMailbox); ok {
+		err = condStoreMailbox.ListMessagesChangedSince(uid, seqSet, items, changedSince, ch)
+	} else {
+		err = ctx.Mailbox.ListMessages(uid, seqSet, items, ch)
+	}
+	if err != nil {
+		return err
+	}
+
+	return <-done
+}
+
+type imapCondStoreStoreHandler struct {
+	commands.Store
+	UnchangedSince int64
+}
+
+func (cmd *imapCondStoreStoreHandler) Parse(fields []interface{}) error {
+
+	if len(fields) > 1 {
+		unchangedSince, isModifier, err := parseModifier(fields[1], "UNCHANGEDSINCE")
+		if err != nil {
+			return err
+		}
+		if isModifier {
+			cmd.UnchangedSince = unchangedSince
+			fields = append([]interface{}{fields[0]}, fields[2:]...)
+		}
+	}
+
+	return cmd.Store.Parse(fields)
+}
+
+func (cmd *imapCondStoreStoreHandler) handle(uid bool, conn server.Conn) error {
+
+	ctx := conn.Context()
+	if ctx.Mailbox == nil {
+		return server.ErrNoMailboxSelected
+	}
+	if ctx.MailboxReadOnly {
+		return server.ErrMailboxReadOnly
+	}
+
+	condStoreMailbox, ok := ctx.Mailbox.(ImapCondStoreMailbox)
+	if !ok {
+		return errors.New("CONDSTORE is not supported by this mailbox")
+	}
+
+	op, silent, err := imap.ParseFlagsOp(cmd.Item)
+	if err != nil {
+		return err
+	}
+
+	var flags []string
+	if flagsList, ok := cmd.Value.([]interface{}); ok {
+		flags, err = imap.ParseStringList(flagsList)
+		if err != nil {
+			return err
+		}
+	} else {
+		flag, err := imap.ParseString(cmd.Value)
+		if err != nil {
+			return err
+		}
+		flags = []string{flag}
+	}
+	for i, flag := range flags {
+		flags[i] = imap.CanonicalFlag(flag)
+	}
+
+	modified, err := condStoreMailbox.UpdateMessagesFlagsUnchangedSince(uid, cmd.SeqSet, op, flags, cmd.UnchangedSince)
+	if err != nil {
+		return err
+	}
+
+	// the mail backend does not push updates, so the new flags are sent here
+	if conn.Server().Updates == nil && !silent {
+		items := []imap.FetchItem{imap.FetchFlags}
+		if cmd.UnchangedSince > 0 {
+			items = append(items, ImapFetchModSeq)
+		}
+		if uid {
+			items = append(items, imap.FetchUid)
+		}
+
+		err = imapFetch(conn, uid, cmd.SeqSet, items, 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(modified.Set) > 0 {
+		return server.ErrStatusResp(&imap.StatusResp{
+			Type:      imap.StatusRespOk,
+			Code:      "MODIFIED",
+			Arguments: []interface{}{modified},
+			Info:      "Conditional STORE failed",
+		})
+	}
+
+	return nil
+}
+
+func (cmd *imapCondStoreStoreHandler) Handle(conn server.Conn) error {
+	return cmd.handle(false, conn)
+}
+
+func (cmd *imapCondStoreStoreHandler) UidHandle(conn server.Conn) error {
+	return cmd.handle(true, conn)
+}
+
+type imapCondStoreSearchHandler struct {
+	commands.Search
+	ModSeq int64
+}
+
+// Parse removes the MODSEQ search key from the top level of the criteria since
+// the search criteria parser does not know about it. The key is either
+// MODSEQ <mod-sequence> or MODSEQ <entry-name> <entry-type> <mod-sequence>.
+func (cmd *imapCondStoreSearchHandler) Parse(fields []interface{}) error {
+
+	remaining := make([]interface{}, 0, len(fields))
+	for i := 0; i < len(fields); i++ {
+		key, ok := fields[i].(string)
+		if !ok || !strings.EqualFold(key, "MODSEQ") {
+			remaining = append(remaining, fields[i])
+			continue
+		}
+
+		if i+1 >= len(fields) {
+			return errors.New("Missing MODSEQ value")
+		}
+		modSeq, err := parseModSeq(fields[i+1])
+		if err != nil {
+			if i+3 >= len(fields) {
+				return errors.New("Invalid MODSEQ value")
+			}
+			modSeq, err = parseModSeq(fields[i+3])
+			if err != nil {
+				return err
+			}
+			i += 2
+		}
+		i += 1
+		cmd.ModSeq = modSeq
+	}
+
+	if len(remaining) == 0 {
+		remaining = append(remaining, "ALL")
+	}
+
+	return cmd.Search.Parse(remaining)
+}
+
+func (cmd *imapCondStoreSearchHandler) handle(uid bool, conn server.Conn) error {
+
+	ctx := conn.Context()
+	if ctx.Mailbox == nil {
+		return server.ErrNoMailboxSelected
+	}
+
+	condStoreMailbox, ok := ctx.Mailbox.(ImapCondStoreMailbox)
+	if !ok {
+		ids, err := ctx.Mailbox.SearchMessages(uid, cmd.Criteria)
+		if err != nil {
+			return err
+		}
+		return conn.WriteResp(&responses.Search{Ids: ids})
+	}
+
+	ids, highestModSeq, err := condStoreMailbox.SearchMessagesWithModSeq(uid, cmd.Criteria, cmd.ModSeq)
+	if err != nil {
+		return err
+	}
+
+	fields := []interface{}{imap.RawString("SEARCH")}
+	for _, id := range ids {
+		fields = append(fields, id)
+	}
+	// the highest mod-sequence is only reported when MODSEQ was searched for
+	if cmd.ModSeq > 0 && len(ids) > 0 {
+		fields = append(fields, []interface{}{imap.RawString("MODSEQ"), formatModSeq(highestModSeq)})
+	}
+
+	return conn.WriteResp(imap.NewUntaggedResp(fields))
+}
+
+func (cmd *imapCondStoreSearchHandler) Handle(conn server.Conn) error {
+	return cmd.handle(false, conn)
+}
+
+func (cmd *imapCondStoreSearchHandler) UidHandle(conn server.Conn) error {
+	return cmd.handle(true, conn)
+}
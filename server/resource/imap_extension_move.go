@@ -0,0 +1,81 @@
+package resource
+
+import (
+	"errors"
+	"github.com/artpar/go-imap"
+	"github.com/artpar/go-imap/commands"
+	"github.com/artpar/go-imap/responses"
+	"github.com/artpar/go-imap/server"
+)
+
+// ImapMover is implemented by mailboxes which can move messages to another
+// mailbox in one step
+type ImapMover interface {
+	MoveMessages(uid bool, seqset *imap.SeqSet, dest string) ([]uint32, error)
+}
+
+type imapMoveExtension struct{}
+
+// NewImapMoveExtension adds the MOVE command defined in RFC 6851
+func NewImapMoveExtension() server.Extension {
+	return &imapMoveExtension{}
+}
+
+func (ext *imapMoveExtension) Capabilities(c server.Conn) []string {
+	if c.Context().State&imap.AuthenticatedState != 0 {
+		return []string{"MOVE"}
+	}
+	return nil
+}
+
+func (ext *imapMoveExtension) Command(name string) server.HandlerFactory {
+	if name != "MOVE" {
+		return nil
+	}
+
+	return func() server.Handler {
+		return &imapMoveHandler{}
+	}
+}
+
+type imapMoveHandler struct {
+	commands.Copy
+}
+
+func (cmd *imapMoveHandler) handle(uid bool, conn server.Conn) error {
+	ctx := conn.Context()
+	if ctx.Mailbox == nil {
+		return server.ErrNoMailboxSelected
+	}
+	if ctx.MailboxReadOnly {
+		return server.ErrMailboxReadOnly
+	}
+
+	mover, ok := ctx.Mailbox.(ImapMover)
+	if !ok {
+		return errors.New("MOVE is not supported by this mailbox")
+	}
+
+	seqNums, err := mover.MoveMessages(uid, cmd.SeqSet, cmd.Mailbox)
+	if err != nil {
+		return err
+	}
+
+	// expunge from the highest sequence number, since every expunge shifts the
+	// numbers of the messages after it
+	ch := make(chan uint32, len(seqNums))
+	for i := len(seqNums) - 1; i >= 0; i-- {
+		ch <- seqNums[i]
+	}
+	close(ch)
+
+	return conn.WriteResp(&responses.Expunge{SeqNums: ch})
+}
+
+func (cmd *imapMoveHandler) Handle(conn server.Conn) error {
+	return cmd.handle(false, conn)
+}
+
+func (cmd *imapMoveHandler) UidHandle(conn server.Conn) error {
+	return cmd.handle(true, conn)
+}
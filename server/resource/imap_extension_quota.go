@@ -0,0 +1,246 @@
+package resource
+
+import (
+	"errors"
+	"github.com/artpar/go-imap"
+	"github.com/artpar/go-imap/server"
+	"github.com/artpar/go-imap/utf7"
+	"strings"
+)
+
+// MailAccountQuota is the usage and the limits of a mail account. Storage is
+// in units of 1024 octets, a limit of zero means there is no limit.
+type MailAccountQuota struct {
+	StorageUsed  int64
+	StorageLimit int64
+	MessageUsed  int64
+	MessageLimit int64
+}
+
+// ImapQuotaUser is implemented by users whose mail account has a quota. All
+// mailboxes of a mail account share a single quota root named "".
+type ImapQuotaUser interface {
+	GetQuota() (*MailAccountQuota, error)
+	SetQuota(storageLimit int64, messageLimit int64) error
+}
+
+const imapQuotaRoot = ""
+
+type imapQuotaExtension struct{}
+
+// NewImapQuotaExtension adds the GETQUOTA, GETQUOTAROOT and SETQUOTA commands
+// defined in RFC 2087
+func NewImapQuotaExtension() server.Extension {
+	return &imapQuotaExtension{}
+}
+
+func (ext *imapQuotaExtension) Capabilities(c server.Conn) []string {
+	if c.Context().State&imap.AuthenticatedState != 0 {
+		return []string{"QUOTA"}
+	}
+	return nil
+}
+
+func (ext *imapQuotaExtension) Command(name string) server.HandlerFactory {
+	switch name {
+	case "GETQUOTA":
+		return func() server.Handler {
+			return &imapGetQuotaHandler{}
+		}
+	case "GETQUOTAROOT":
+		return func() server.Handler {
+			return &imapGetQuotaRootHandler{}
+		}
+	case "SETQUOTA":
+		return func() server.Handler {
+			return &imapSetQuotaHandler{}
+		}
+	}
+	return nil
+}
+
+func imapQuotaUser(conn server.Conn) (ImapQuotaUser, error) {
+	ctx := conn.Context()
+	if ctx.User == nil {
+		return nil, server.ErrNotAuthenticated
+	}
+
+	quotaUser, ok := ctx.User.(ImapQuotaUser)
+	if !ok {
+		return nil, errors.New("QUOTA is not supported for this user")
+	}
+	return quotaUser, nil
+}
+
+func writeImapQuota(conn server.Conn, quota *MailAccountQuota) error {
+
+	resources := make([]interface{}, 0)
+	if quota.StorageLimit > 0 {
+		resources = append(resources, imap.RawString("STORAGE"), uint32(quota.StorageUsed), uint32(quota.StorageLimit))
+	}
+	if quota.MessageLimit > 0 {
+		resources = append(resources, imap.RawString("MESSAGE"), uint32(quota.MessageUsed), uint32(quota.MessageLimit))
+	}
+
+	return conn.WriteResp(imap.NewUntaggedResp([]interface{}{
+		imap.RawString("QUOTA"), imapQuotaRoot, resources,
+	}))
+}
+
+type imapGetQuotaHandler struct {
+	Root string
+}
+
+func (cmd *imapGetQuotaHandler) Parse(fields []interface{}) error {
+	if len(fields) < 1 {
+		return errors.New("No enough arguments")
+	}
+
+	root, err := imap.ParseString(fields[0])
+	if err != nil {
+		return err
+	}
+	cmd.Root = root
+	return nil
+}
+
+func (cmd *imapGetQuotaHandler) Handle(conn server.Conn) error {
+
+	quotaUser, err := imapQuotaUser(conn)
+	if err != nil {
+		return err
+	}
+
+	if cmd.Root != imapQuotaRoot {
+		return errors.New("No such quota root")
+	}
+
+	quota, err := quotaUser.GetQuota()
+	if err != nil {
+		return err
+	}
+
+	return writeImapQuota(conn, quota)
+}
+
+type imapGetQuotaRootHandler struct {
+	Mailbox string
+}
+
+func (cmd *imapGetQuotaRootHandler) Parse(fields []interface{}) error {
+	if len(fields) < 1 {
+		return errors.New("No enough arguments")
+	}
+
+	if mailbox, err := imap.ParseString(fields[0]); err != nil {
+		return err
+	} else if mailbox, err := utf7.Encoding.NewDecoder().String(mailbox); err != nil {
+		return err
+	} else {
+		cmd.Mailbox = imap.CanonicalMailboxName(mailbox)
+	}
+	return nil
+}
+
+func (cmd *imapGetQuotaRootHandler) Handle(conn server.Conn) error {
+
+	quotaUser, err := imapQuotaUser(conn)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Context().User.GetMailbox(cmd.Mailbox)
+	if err != nil {
+		return err
+	}
+
+	quota, err := quotaUser.GetQuota()
+	if err != nil {
+		return err
+	}
+
+	mailbox, _ := utf7.Encoding.NewEncoder().String(cmd.Mailbox)
+	err = conn.WriteResp(imap.NewUntaggedResp([]interface{}{
+		imap.RawString("QUOTAROOT"), imap.FormatMailboxName(mailbox), imapQuotaRoot,
+	}))
+	if err != nil {
+		return err
+	}
+
+	return writeImapQuota(conn, quota)
+}
+
+type imapSetQuotaHandler struct {
+	Root      string
+	Resources map[string]int64
+}
+
+func (cmd *imapSetQuotaHandler) Parse(fields []interface{}) error {
+	if len(fields) < 2 {
+		return errors.New("No enough arguments")
+	}
+
+	root, err := imap.ParseString(fields[0])
+	if err != nil {
+		return err
+	}
+	cmd.Root = root
+
+	resources, ok := fields[1].([]interface{})
+	if !ok || len(resources)%2 != 0 {
+		return errors.New("Quota resources must be a list of name and limit pairs")
+	}
+
+	cmd.Resources = make(map[string]int64)
+	for i := 0; i < len(resources); i += 2 {
+		name, err := imap.ParseString(resources[i])
+		if err != nil {
+			return err
+		}
+		limit, err := imap.ParseNumber(resources[i+1])
+		if err != nil {
+			return err
+		}
+		cmd.Resources[strings.ToUpper(name)] = int64(limit)
+	}
+
+	return nil
+}
+
+func (cmd *imapSetQuotaHandler) Handle(conn server.Conn) error {
+
+	// the account of the user is the only quota root, the quota of other
+	// accounts cannot be set
+	if cmd.Root != imapQuotaRoot {
+		return errors.New("No such quota root, only the quota of this account can be set")
+	}
+
+	quotaUser, err := imapQuotaUser(conn)
+	if err != nil {
+		return err
+	}
+
+	var storageLimit, messageLimit int64
+	for name, limit := range cmd.Resources {
+		switch name {
+		case "STORAGE":
+			storageLimit = limit
+		case "MESSAGE":
+			messageLimit = limit
+		default:
+			return errors.New("Unsupported quota resource " + name)
+		}
+	}
+
+	err = quotaUser.SetQuota(storageLimit, messageLimit)
+	if err != nil {
+		return err
+	}
+
+	quota, err := quotaUser.GetQuota()
+	if err != nil {
+		return err
+	}
+
+	return writeImapQuota(conn, quota)
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/artpar/api2go"
 	"github.com/artpar/go-imap"
 	"github.com/artpar/go-imap/backend/backendutil"
+	"github.com/artpar/go-imap/server"
 	"github.com/artpar/go.uuid"
 	"github.com/artpar/parsemail"
 	"github.com/bjarneh/latinx"
@@ -20,7 +21,8 @@ import (
 	"golang.org/x/net/context"
 	"io/ioutil"
 	"net/http"
-
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -37,9 +39,14 @@ type DaptinImapMailBox struct {
 	mailBoxReferenceId string
 	info               imap.MailboxInfo
 	status             *imap.MailboxStatus
-	sequenceToMail     map[uint32]*imap.Message
 }
 
+// ImapStatusHighestModSeq is the STATUS item defined by CONDSTORE (RFC 7162)
+const ImapStatusHighestModSeq imap.StatusItem = "HIGHESTMODSEQ"
+
+// ImapFetchModSeq is the FETCH item defined by CONDSTORE (RFC 7162)
+const ImapFetchModSeq imap.FetchItem = "MODSEQ"
+
 // Name returns this mailbox name.
 func (dimb *DaptinImapMailBox) Name() string {
 	return dimb.name
@@ -87,11 +94,19 @@ func (dimb *DaptinImapMailBox) Status(items []imap.StatusItem) (*imap.MailboxSta
 			mbs.UidNext = nextUid
 		case imap.StatusUidValidity:
 			mbs.UidValidity = dimb.status.UidValidity
+		case ImapStatusHighestModSeq:
+			highestModSeq, _ := dimb.dbResource["mail_box"].GetMailBoxHighestModSeq(dimb.mailBoxId)
+			mbs.Items[item] = imap.RawString(strconv.FormatInt(highestModSeq, 10))
 		}
 	}
 	return mbs, nil
 }
 
+// HighestModSeq returns the highest mod-sequence of the mailbox (RFC 7162)
+func (dimb *DaptinImapMailBox) HighestModSeq() (int64, error) {
+	return dimb.dbResource["mail_box"].GetMailBoxHighestModSeq(dimb.mailBoxId)
+}
+
 // SetSubscribed adds or removes the mailbox to the server's set of "active"
 // or "subscribed" mailboxes.
 func (dimb *DaptinImapMailBox) SetSubscribed(subscribed bool) error {
@@ -135,36 +150,37 @@ func (dimb *DaptinImapMailBox) Check() error {
 //
 // Messages must be sent to ch. When the function returns, ch must be closed.
 func (dimb *DaptinImapMailBox) ListMessages(uid bool, seqset *imap.SeqSet, items []imap.FetchItem, ch chan<- *imap.Message) error {
+	return dimb.ListMessagesChangedSince(uid, seqset, items, 0, ch)
+}
 
-	for _, seq := range seqset.Set {
-		//log.Printf("Fetch request [%v] from %v to %v", uid, seq.Start, seq.Stop)
-
-		seqNo := seq.Start
-		var mails []map[string]interface{}
-		var err error
-		if uid {
-			mails, err = dimb.dbResource["mail_box"].GetMailBoxMailsByUidSequence(dimb.mailBoxId, seq.Start, seq.Stop)
-		} else {
-			startAt := seq.Start
-			stopAt := seq.Stop
-
-			for {
+// ListMessagesChangedSince works like ListMessages but only returns messages
+// whose mod-sequence is greater than changedSince (CHANGEDSINCE, RFC 7162).
+// A changedSince of zero returns all messages.
+func (dimb *DaptinImapMailBox) ListMessagesChangedSince(uid bool, seqset *imap.SeqSet, items []imap.FetchItem, changedSince int64, ch chan<- *imap.Message) error {
 
-				if dimb.sequenceToMail[startAt] == nil {
-					break
-				}
+	defer close(ch)
 
-				ch <- dimb.sequenceToMail[startAt]
-				startAt = startAt + 1
-			}
+	mailIds, err := dimb.dbResource["mail_box"].GetMailBoxMailIds(dimb.mailBoxId)
+	if err != nil {
+		return err
+	}
+	sequenceNumbers := make(map[int64]uint32)
+	for i, id := range mailIds {
+		sequenceNumbers[id] = uint32(i + 1)
+	}
 
-			if startAt > stopAt {
-				continue
-			}
+	for _, seq := range seqset.Set {
+		//log.Printf("Fetch request [%v] from %v to %v", uid, seq.Start, seq.Stop)
 
-			mails, err = dimb.dbResource["mail_box"].GetMailBoxMailsByOffset(dimb.mailBoxId, startAt, stopAt)
+		start, stop, ok, err := imapSeqToUidRange(uid, seq, mailIds)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
 		}
 
+		mails, err := dimb.dbResource["mail_box"].GetMailBoxMailsByUidSequence(dimb.mailBoxId, start, stop)
 		if err != nil {
 			return err
 		}
@@ -172,22 +188,19 @@ func (dimb *DaptinImapMailBox) ListMessages(uid bool, seqset *imap.SeqSet, items
 		for _, mailContent := range mails {
 			//log.Printf("Return mailContent: %v", mailContent)
 
+			mailId := mailContent["id"].(int64)
+			modSeq := mailInt64(mailContent["modseq"])
+			if changedSince > 0 && modSeq <= changedSince {
+				continue
+			}
+
 			bodyContents, e := base64.StdEncoding.DecodeString(mailContent["mail"].(string))
 			if e != nil {
 				CheckErr(e, "Failed to decode mail contents")
 				continue
 			}
-			//messageBytes := bytes.NewReader(bodyContents)
-			//log.Printf("Message: %v", string(bodyContents))
-			//parsedMail, err := parsemail.Parse(messageBytes)
 
-			//messageEntity, err := message.Read(messageBytes)
-
-			if err != nil {
-				log.Printf("Failed to parse email body: %v", err)
-				continue
-			}
-			returnMail := imap.NewMessage(seqNo, items)
+			returnMail := imap.NewMessage(sequenceNumbers[mailId], items)
 			returnMail.Size = uint32(mailContent["size"].(int64))
 
 			skipMail := false
@@ -237,8 +250,9 @@ func (dimb *DaptinImapMailBox) ListMessages(uid bool, seqset *imap.SeqSet, items
 					case imap.FetchRFC822Size:
 						returnMail.Size = uint32(mailContent["size"].(int64))
 					case imap.FetchUid:
-						uid := mailContent["id"].(int64)
-						returnMail.Uid = uint32(uid)
+						returnMail.Uid = uint32(mailId)
+					case ImapFetchModSeq:
+						returnMail.Items[ImapFetchModSeq] = []interface{}{imap.RawString(strconv.FormatInt(modSeq, 10))}
 					default:
 						log.Printf("Fetch default [%v] update flags: %v", subItems, flagList)
 
@@ -252,7 +266,7 @@ func (dimb *DaptinImapMailBox) ListMessages(uid bool, seqset *imap.SeqSet, items
 							if HasAnyFlag(flagList, []string{imap.RecentFlag}) {
 								flagList = backendutil.UpdateFlags(flagList, imap.RemoveFlags, []string{imap.RecentFlag})
 								log.Printf("New flags: [%v]", flagList)
-								err := dimb.dbResource["mail_box"].UpdateMailFlags(dimb.mailBoxId, mailContent["id"].(int64), flagList)
+								err := dimb.dbResource["mail_box"].UpdateMailFlags(dimb.mailBoxId, mailId, flagList)
 								if err != nil {
 									log.Printf("Failed to update recent flag for mail[%v]: %v", mailContent["id"], err)
 								}
@@ -271,7 +285,7 @@ func (dimb *DaptinImapMailBox) ListMessages(uid bool, seqset *imap.SeqSet, items
 							break
 						}
 						flagList = backendutil.UpdateFlags(flagList, imap.AddFlags, []string{imap.SeenFlag})
-						err = dimb.dbResource["mail_box"].UpdateMailFlags(dimb.mailBoxId, mailContent["id"].(int64), flagList)
+						err = dimb.dbResource["mail_box"].UpdateMailFlags(dimb.mailBoxId, mailId, flagList)
 						CheckErr(err, "Failed to update mail with seen flag")
 
 						returnMail.Body[section] = l
@@ -283,118 +297,114 @@ func (dimb *DaptinImapMailBox) ListMessages(uid bool, seqset *imap.SeqSet, items
 			if skipMail {
 				continue
 			}
-			//err = returnMail.Parse(responseItems)
-			//if err != nil {
-			//	log.Printf("Failed to parse fields: %v", err)
-			//	continue
-			//}
 
-			dimb.sequenceToMail[seqNo] = returnMail
 			ch <- returnMail
-			seqNo += 1
 		}
 
 	}
 
-	close(ch)
-
 	return nil
 }
 
 // SearchMessages searches messages. The returned list must contain UIDs if
 // uid is set to true, or sequence numbers otherwise.
 func (dimb *DaptinImapMailBox) SearchMessages(uid bool, criteria *imap.SearchCriteria) ([]uint32, error) {
+	ids, _, err := dimb.SearchMessagesWithModSeq(uid, criteria, 0)
+	return ids, err
+}
 
-	httpRequest := http.Request{}
-
-	//filterParams := make(map[string][]string)
+// SearchMessagesWithModSeq works like SearchMessages and additionally only
+// matches messages whose mod-sequence is at least modSeq (MODSEQ search key,
+// RFC 7162). It also returns the highest mod-sequence of the matched messages.
+func (dimb *DaptinImapMailBox) SearchMessagesWithModSeq(uid bool, criteria *imap.SearchCriteria, modSeq int64) ([]uint32, int64, error) {
 
-	queries := make([]Query, 0)
+	mailIds, err := dimb.dbResource["mail_box"].GetMailBoxMailIds(dimb.mailBoxId)
+	if err != nil {
+		return nil, 0, err
+	}
+	sequenceNumbers := make(map[int64]uint32)
+	for i, id := range mailIds {
+		sequenceNumbers[id] = uint32(i + 1)
+	}
 
-	if criteria.Uid != nil {
-		queries = append(queries, Query{
-			ColumnName: "id",
-			Operator:   "contains",
-			Value:      criteria.Uid.Set,
-		})
+	where := squirrel.And{}
+	if modSeq > 0 {
+		where = append(where, squirrel.GtOrEq{"modseq": modSeq})
 	}
 
-	if len(criteria.WithFlags) > 0 {
-		for _, flag := range criteria.WithFlags {
-			switch strings.ToLower(flag) {
-			case "\\deleted":
-				queries = append(queries, Query{
-					ColumnName: "deleted",
-					Operator:   "is",
-					Value:      true,
-				})
-			}
+	requiresScan := ImapSearchRequiresScan(criteria)
+	if !requiresScan {
+		criteriaSql, err := ImapSearchCriteriaToSql(criteria, mailIds)
+		if err != nil {
+			return nil, 0, err
 		}
+		where = append(where, criteriaSql)
 	}
 
-	if len(criteria.WithoutFlags) > 0 {
-		for _, flag := range criteria.WithFlags {
-			switch strings.ToLower(flag) {
-			case "\\deleted":
-				queries = append(queries, Query{
-					ColumnName: "deleted",
-					Operator:   "is",
-					Value:      false,
-				})
-			}
-		}
+	results, err := dimb.dbResource["mail_box"].SearchMailBoxMails(dimb.mailBoxId, where)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	if len(criteria.Header) > 0 {
-		for headerName, flag := range criteria.Header {
-			switch strings.ToLower(headerName) {
-			case "Message-ID":
-				queries = append(queries, Query{
-					ColumnName: "message_id",
-					Operator:   "is",
-					Value:      flag,
-				})
+	ids := make([]uint32, 0)
+	var highestModSeq int64
+	for _, res := range results {
+		mailId := res["id"].(int64)
+		sequenceNumber, isVisible := sequenceNumbers[mailId]
+		if !uid && !isVisible {
+			continue
+		}
+
+		if requiresScan {
+			matched, err := dimb.matchMail(mailId, sequenceNumber, criteria)
+			if err != nil {
+				log.Printf("Failed to match mail [%v] against search criteria: %v", mailId, err)
+				continue
+			}
+			if !matched {
+				continue
 			}
 		}
-	}
 
-	queryJson, _ := json.Marshal(queries)
+		if res["modseq"].(int64) > highestModSeq {
+			highestModSeq = res["modseq"].(int64)
+		}
 
-	searchRequest := api2go.Request{
-		PlainRequest: &httpRequest,
-		QueryParams: map[string][]string{
-			"fields": {
-				"id",
-			},
-			"query": {
-				string(queryJson),
-			},
-		},
+		if uid {
+			ids = append(ids, uint32(mailId))
+		} else {
+			ids = append(ids, sequenceNumber)
+		}
 	}
 
-	log.Printf("Search query for mail: %v", searchRequest.QueryParams)
-	results, _, _, err := dimb.dbResource["mail"].PaginatedFindAllWithoutFilters(searchRequest)
+	log.Printf("Mail search results: %v", ids)
+	return ids, highestModSeq, nil
+}
+
+// matchMail reads the complete message and matches it against criteria which
+// cannot be converted to sql
+func (dimb *DaptinImapMailBox) matchMail(mailId int64, sequenceNumber uint32, criteria *imap.SearchCriteria) (bool, error) {
 
+	mails, err := dimb.dbResource["mail_box"].GetMailBoxMailsByUidSequence(dimb.mailBoxId, uint32(mailId), uint32(mailId))
+	if err != nil || len(mails) == 0 {
+		return false, err
+	}
+	mailContent := mails[0]
+
+	bodyContents, err := base64.StdEncoding.DecodeString(mailContent["mail"].(string))
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 
-	ids := make([]uint32, 0)
-	log.Printf("Mail search results: %v", results)
-	for i, res := range results {
-		if uid {
-			id, err := dimb.dbResource["mail"].GetReferenceIdToId("mail", res["reference_id"].(string))
-			if err != nil {
-				CheckErr(err, "Failed to get id from reference id")
-				continue
-			}
-			ids = append(ids, uint32(id))
-		} else {
-			ids = append(ids, uint32(i+1))
-		}
+	entity, err := message.Read(bytes.NewReader(bodyContents))
+	if err != nil {
+		return false, err
 	}
 
-	return ids, nil
+	flagList := strings.Split(mailContent["flags"].(string), ",")
+	internalDate, _ := mailContent["internal_date"].(time.Time)
+
+	return backendutil.Match(entity, sequenceNumber, uint32(mailId), internalDate, flagList, criteria)
 }
 
 // CreateMessage appends a new message to this mailbox. The \Recent flag will
@@ -410,6 +420,16 @@ func (dimb *DaptinImapMailBox) CreateMessage(flags []string, date time.Time, bod
 		return err
 	}
 
+	err = dimb.dbResource["mail_account"].CheckMailAccountQuota(dimb.mailAccountId, int64(len(mailBody)), 1)
+	if err != nil {
+		return err
+	}
+
+	modSeq, err := dimb.dbResource["mail_box"].NextMailBoxModSeq(dimb.mailBoxId)
+	if err != nil {
+		return err
+	}
+
 	httpRequest := &http.Request{
 		Method: "POST",
 	}
@@ -513,6 +533,7 @@ func (dimb *DaptinImapMailBox) CreateMessage(flags []string, date time.Time, bod
 			"recent":           true,
 			"flags":            strings.Join(flags, ","),
 			"size":             len(mailBody),
+			"modseq":           modSeq,
 		},
 	}
 
@@ -576,46 +597,45 @@ func HasAnyFlag(flags []string, flagToFind []string) bool {
 // If the Backend implements Updater, it must notify the client immediately
 // via a message update.
 func (dimb *DaptinImapMailBox) UpdateMessagesFlags(uid bool, seqset *imap.SeqSet, operation imap.FlagsOp, flags []string) error {
+	_, err := dimb.UpdateMessagesFlagsUnchangedSince(uid, seqset, operation, flags, 0)
+	return err
+}
+
+// UpdateMessagesFlagsUnchangedSince works like UpdateMessagesFlags but skips
+// messages whose mod-sequence is greater than unchangedSince (UNCHANGEDSINCE,
+// RFC 7162). The skipped messages are returned as UIDs or sequence numbers
+// depending on uid. An unchangedSince of zero updates all messages.
+func (dimb *DaptinImapMailBox) UpdateMessagesFlagsUnchangedSince(uid bool, seqset *imap.SeqSet, operation imap.FlagsOp, flags []string, unchangedSince int64) (*imap.SeqSet, error) {
 
 	log.Printf("Update messages flags: [%v] :[%v]: %v", seqset, operation, flags)
-	var mails []map[string]interface{}
-	var err error
-	for _, seq := range seqset.Set {
-		if uid {
-			mails, err = dimb.dbResource["mail_box"].GetMailBoxMailsByUidSequence(dimb.mailBoxId, seq.Start, seq.Stop)
-		} else {
-			mails, err = dimb.dbResource["mail_box"].GetMailBoxMailsByOffset(dimb.mailBoxId, seq.Start, seq.Stop)
-		}
 
-		if err != nil {
-			return err
-		}
+	modified := new(imap.SeqSet)
+	mails, sequenceNumbers, err := dimb.getMails(uid, seqset)
+	if err != nil {
+		return modified, err
+	}
 
-		for _, mailRow := range mails {
-			currentFlags := strings.Split(mailRow["flags"].(string), ",")
-			newFlags := backendutil.UpdateFlags(currentFlags, operation, flags)
-			log.Printf("New flags: [%v]", newFlags)
-			hasDupe := false
-			fla := map[string]bool{}
-			for _, f := range newFlags {
-				if fla[f] {
-					hasDupe = true
-					fla[f] = true
-					log.Printf("Duplicate flag: %v", f)
-				}
+	for _, mailRow := range mails {
+		mailId := mailRow["id"].(int64)
+		if unchangedSince > 0 && mailInt64(mailRow["modseq"]) > unchangedSince {
+			if uid {
+				modified.AddNum(uint32(mailId))
+			} else {
+				modified.AddNum(sequenceNumbers[mailId])
 			}
+			continue
+		}
 
-			if hasDupe {
-				log.Printf("Duplicate flag: %v", newFlags)
-			}
-			err = dimb.dbResource["mail_box"].UpdateMailFlags(dimb.mailBoxId, mailRow["id"].(int64), newFlags)
-			if err != nil {
-				return err
-			}
+		currentFlags := strings.Split(mailRow["flags"].(string), ",")
+		newFlags := backendutil.UpdateFlags(currentFlags, operation, flags)
+		log.Printf("New flags: [%v]", newFlags)
+		err = dimb.dbResource["mail_box"].UpdateMailFlags(dimb.mailBoxId, mailId, newFlags)
+		if err != nil {
+			return modified, err
 		}
 	}
 
-	return nil
+	return modified, nil
 }
 
 // CopyMessages copies the specified message(s) to the end of the specified
@@ -629,52 +649,153 @@ func (dimb *DaptinImapMailBox) UpdateMessagesFlags(uid bool, seqset *imap.SeqSet
 // via a mailbox update.
 func (dimb *DaptinImapMailBox) CopyMessages(uid bool, seqset *imap.SeqSet, dest string) error {
 
-	var mails []map[string]interface{}
-	var err error
+	destinationMailBox, err := dimb.dbResource["mail_box"].GetMailAccountBox(dimb.mailAccountId, dest)
+	if err != nil {
+		return err
+	}
+
+	mails, _, err := dimb.getMails(uid, seqset)
+	if err != nil {
+		return err
+	}
 
-	destinationMailBoxId, err := dimb.dbResource["mail_box"].GetMailAccountBox(dimb.mailAccountId, dest)
+	var totalSize int64
+	for _, mail := range mails {
+		totalSize += mailInt64(mail["size"])
+	}
+	err = dimb.dbResource["mail_account"].CheckMailAccountQuota(dimb.mailAccountId, totalSize, int64(len(mails)))
 	if err != nil {
 		return err
 	}
 
+	return copyMails(dimb.dbResource, mails, destinationMailBox)
+}
+
+// MoveMessages moves the specified message(s) to the end of the specified
+// destination mailbox (RFC 6851). The messages are removed from this mailbox
+// and the sequence numbers they had are returned in ascending order so that
+// EXPUNGE responses can be sent to the client. The copies are created and the
+// messages removed in one transaction.
+func (dimb *DaptinImapMailBox) MoveMessages(uid bool, seqset *imap.SeqSet, dest string) ([]uint32, error) {
+
+	destinationMailBox, err := dimb.dbResource["mail_box"].GetMailAccountBox(dimb.mailAccountId, dest)
+	if err != nil {
+		return nil, err
+	}
+
+	mails, sequenceNumbers, err := dimb.getMails(uid, seqset)
+	if err != nil {
+		return nil, err
+	}
+
+	mailIds := make([]int64, 0)
+	expunged := make([]uint32, 0)
+	for _, mail := range mails {
+		mailId := mail["id"].(int64)
+		mailIds = append(mailIds, mailId)
+		expunged = append(expunged, sequenceNumbers[mailId])
+	}
+
+	tx, err := dimb.dbResource["mail_box"].connection.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	transactionCruds := NewTransactionCruds(dimb.dbResource, tx)
+
+	err = copyMails(transactionCruds, mails, destinationMailBox)
+	if err == nil {
+		_, err = transactionCruds["mail_box"].ExpungeMails(mailIds)
+	}
+	if err != nil {
+		rollbackErr := tx.Rollback()
+		CheckErr(rollbackErr, "Failed to rollback moving the mails to [%v]", dest)
+		return nil, err
+	}
+
+	err = CommitTransactionCruds(transactionCruds, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(expunged, func(i, j int) bool {
+		return expunged[i] < expunged[j]
+	})
+
+	return expunged, nil
+}
+
+// copyMails creates a copy of each mail row in the destination mail box with
+// the recent flag set
+func copyMails(cruds map[string]*DbResource, mails []map[string]interface{}, destinationMailBox map[string]interface{}) error {
+
 	req := api2go.Request{
 		PlainRequest: &http.Request{},
 	}
 
-	for _, set := range seqset.Set {
+	for _, mail := range mails {
 
-		if uid {
-			mails, err = dimb.dbResource["mail_box"].GetMailBoxMailsByUidSequence(dimb.mailBoxId, set.Start, set.Stop)
-		} else {
-			mails, err = dimb.dbResource["mail_box"].GetMailBoxMailsByOffset(dimb.mailBoxId, set.Start, set.Stop)
+		modSeq, err := cruds["mail_box"].NextMailBoxModSeq(destinationMailBox["id"].(int64))
+		if err != nil {
+			return err
 		}
 
+		mail["mail_box_id"] = destinationMailBox["reference_id"]
+		mail["modseq"] = modSeq
+
+		delete(mail, "reference_id")
+		delete(mail, "updated_at")
+		delete(mail, "created_at")
+		delete(mail, "id")
+		mail["recent"] = true
+		mailFlags := strings.Split(mail["flags"].(string), ",")
+		if !HasAnyFlag(mailFlags, []string{imap.RecentFlag}) {
+			mailFlags = backendutil.UpdateFlags(mailFlags, imap.AddFlags, []string{imap.RecentFlag})
+			log.Printf("New flags: [%v]", mailFlags)
+			mail["flags"] = strings.Join(mailFlags, ",")
+		}
+
+		_, err = cruds["mail"].CreateWithoutFilter(&api2go.Api2GoModel{
+			Data: mail,
+		}, req)
 		if err != nil {
 			return err
 		}
+	}
 
-		for _, mail := range mails {
-			mail["mail_box_id"] = destinationMailBoxId["reference_id"]
-
-			delete(mail, "reference_id")
-			delete(mail, "updated_at")
-			delete(mail, "created_at")
-			delete(mail, "id")
-			mail["recent"] = true
-			mailFlags := strings.Split(mail["flags"].(string), ",")
-			if !HasAnyFlag(mailFlags, []string{imap.RecentFlag}) {
-				mailFlags = backendutil.UpdateFlags(mailFlags, imap.AddFlags, []string{imap.RecentFlag})
-				log.Printf("New flags: [%v]", mailFlags)
-				mail["flags"] = strings.Join(mailFlags, ",")
-			}
+	return nil
+}
 
-			_, err = dimb.dbResource["mail"].CreateWithoutFilter(&api2go.Api2GoModel{
-				Data: mail,
-			}, req)
+// getMails loads the mails in the sequence set, along with the sequence number
+// of each mail by its id
+func (dimb *DaptinImapMailBox) getMails(uid bool, seqset *imap.SeqSet) ([]map[string]interface{}, map[int64]uint32, error) {
+
+	mailIds, err := dimb.dbResource["mail_box"].GetMailBoxMailIds(dimb.mailBoxId)
+	if err != nil {
+		return nil, nil, err
+	}
+	sequenceNumbers := make(map[int64]uint32)
+	for i, id := range mailIds {
+		sequenceNumbers[id] = uint32(i + 1)
+	}
+
+	mails := make([]map[string]interface{}, 0)
+	for _, seq := range seqset.Set {
+		start, stop, ok, err := imapSeqToUidRange(uid, seq, mailIds)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			continue
 		}
 
+		rows, err := dimb.dbResource["mail_box"].GetMailBoxMailsByUidSequence(dimb.mailBoxId, start, stop)
+		if err != nil {
+			return nil, nil, err
+		}
+		mails = append(mails, rows...)
 	}
-	return err
+
+	return mails, sequenceNumbers, nil
 }
 
 // Expunge permanently removes all messages that have the \Deleted flag set
@@ -692,3 +813,75 @@ func (dimb *DaptinImapMailBox) Expunge() error {
 	}
 	return err
 }
+
+// errImapInvalidSequence is the BAD response to a sequence number above the
+// number of messages in the mailbox
+var errImapInvalidSequence = server.ErrStatusResp(&imap.StatusResp{
+	Type: imap.StatusRespBad,
+	Info: "Invalid sequence number",
+})
+
+// imapSeqToUidRange converts a sequence set range to a range of uids (mail
+// ids), where a stop of zero means no upper limit. mailIds are the uids of the
+// mailbox in ascending order. ok is false if the range matches no message.
+// Sequence numbers above the number of messages are an error.
+func imapSeqToUidRange(uid bool, seq imap.Seq, mailIds []int64) (uint32, uint32, bool, error) {
+
+	if !uid {
+		count := uint32(len(mailIds))
+		if seq.Start > count || seq.Stop > count {
+			return 0, 0, false, errImapInvalidSequence
+		}
+	}
+
+	if len(mailIds) == 0 {
+		return 0, 0, false, nil
+	}
+
+	if uid {
+		start, stop := seq.Start, seq.Stop
+		// "*" is the largest uid in use
+		if start == 0 {
+			start = uint32(mailIds[len(mailIds)-1])
+		}
+		if stop != 0 && start > stop {
+			start, stop = stop, start
+		}
+		return start, stop, true, nil
+	}
+
+	// "*" is the last message
+	count := uint32(len(mailIds))
+	start, stop := seq.Start, seq.Stop
+	if start == 0 {
+		start = count
+	}
+	if stop == 0 {
+		stop = count
+	}
+	if start > stop {
+		start, stop = stop, start
+	}
+
+	return uint32(mailIds[start-1]), uint32(mailIds[stop-1]), true, nil
+}
+
+func mailInt64(value interface{}) int64 {
+	switch v := value.(type) {
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case float64:
+		return int64(v)
+	case []byte:
+		i, _ := strconv.ParseInt(string(v), 10, 64)
+		return i
+	case string:
+		i, _ := strconv.ParseInt(v, 10, 64)
+		return i
+	}
+	return 0
+}
@@ -0,0 +1,228 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/artpar/go-imap"
+	"testing"
+	"time"
+)
+
+// imapMailBoxFixture is a mail account with an INBOX holding count mails and
+// an empty Archive mail box
+func imapMailBoxFixture(t *testing.T, count int) (map[string]*DbResource, *DaptinImapMailBox, int64, func()) {
+
+	cruds, cleanup := newTestCruds(t)
+	serverId := insertTestRow(t, cruds, "mail_server", map[string]interface{}{
+		"hostname": "mail.example.com",
+	})
+	accountId := insertTestRow(t, cruds, "mail_account", map[string]interface{}{
+		"username":       "user",
+		"password":       "",
+		"password_md5":   "",
+		"mail_server_id": serverId,
+	})
+	mailBoxIds := make(map[string]int64)
+	for _, name := range []string{"INBOX", "Archive"} {
+		mailBoxIds[name] = insertTestRow(t, cruds, "mail_box", map[string]interface{}{
+			"name":            name,
+			"attributes":      "",
+			"flags":           "",
+			"permanent_flags": "",
+			"uidvalidity":     1,
+			"nextuid":         1,
+			"subscribed":      true,
+			"mail_account_id": accountId,
+		})
+	}
+
+	for i := 0; i < count; i++ {
+		insertTestRow(t, cruds, "mail", map[string]interface{}{
+			"message_id":       fmt.Sprintf("<%d@example.com>", i),
+			"mail_id":          fmt.Sprintf("%d", i),
+			"from_address":     "sender@example.com",
+			"internal_date":    time.Now(),
+			"to_address":       "user@mail.example.com",
+			"reply_to_address": "",
+			"sender_address":   "sender@example.com",
+			"subject":          fmt.Sprintf("mail %d", i),
+			"body":             "hello",
+			"mail":             "",
+			"spam_score":       0,
+			"hash":             fmt.Sprintf("hash %d", i),
+			"content_type":     "text/plain",
+			"recipient":        "user@mail.example.com",
+			"ip_addr":          "127.0.0.1",
+			"return_path":      "",
+			"size":             5,
+			"flags":            "",
+			"modseq":           1,
+			"mail_box_id":      mailBoxIds["INBOX"],
+		})
+	}
+
+	inboxReferenceId, err := cruds["mail_box"].GetIdToReferenceId("mail_box", mailBoxIds["INBOX"])
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	mailBox := &DaptinImapMailBox{
+		dbResource:         cruds,
+		name:               "INBOX",
+		mailAccountId:      accountId,
+		mailBoxId:          mailBoxIds["INBOX"],
+		mailBoxReferenceId: inboxReferenceId,
+	}
+	return cruds, mailBox, mailBoxIds["Archive"], cleanup
+}
+
+func mailBoxMailCount(t *testing.T, cruds map[string]*DbResource, mailBoxId int64) int {
+	ids, err := cruds["mail_box"].GetMailBoxMailIds(mailBoxId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(ids)
+}
+
+func TestNextMailBoxModSeq(t *testing.T) {
+
+	cruds, mailBox, _, cleanup := imapMailBoxFixture(t, 0)
+	defer cleanup()
+	dr := cruds["mail_box"]
+
+	start, err := dr.GetMailBoxHighestModSeq(mailBox.mailBoxId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(1); i <= 3; i++ {
+		modSeq, err := dr.NextMailBoxModSeq(mailBox.mailBoxId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if modSeq != start+i {
+			t.Errorf("Expected mod-sequence %d, got %d", start+i, modSeq)
+		}
+	}
+
+	// in a transaction the increment is rolled back with it
+	tx, err := dr.connection.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	modSeq, err := NewTransactionCruds(cruds, tx)["mail_box"].NextMailBoxModSeq(mailBox.mailBoxId)
+	if err != nil || modSeq != start+4 {
+		t.Errorf("Expected mod-sequence %d in the transaction, got %d: %v", start+4, modSeq, err)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if modSeq, _ = dr.GetMailBoxHighestModSeq(mailBox.mailBoxId); modSeq != start+3 {
+		t.Errorf("Expected the rolled back increment to be undone, got %d", modSeq)
+	}
+}
+
+func TestMoveMessages(t *testing.T) {
+
+	cruds, mailBox, archiveId, cleanup := imapMailBoxFixture(t, 3)
+	defer cleanup()
+
+	seqSet, err := imap.ParseSeqSet("1:2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the copies are removed with the failure to remove the moved mails
+	_, err = cruds["mail"].db.Exec("drop table mail_mail_id_has_usergroup_usergroup_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = mailBox.MoveMessages(false, seqSet, "Archive"); err == nil {
+		t.Fatalf("Expected the move to fail without the mail group table")
+	}
+	if inbox, archive := mailBoxMailCount(t, cruds, mailBox.mailBoxId), mailBoxMailCount(t, cruds, archiveId); inbox != 3 || archive != 0 {
+		t.Fatalf("Expected the failed move to be rolled back, found %d mails in the inbox and %d in the archive", inbox, archive)
+	}
+	_, err = cruds["mail"].db.Exec("create table mail_mail_id_has_usergroup_usergroup_id (mail_id integer, usergroup_id integer)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expunged, err := mailBox.MoveMessages(false, seqSet, "Archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expunged) != 2 || expunged[0] != 1 || expunged[1] != 2 {
+		t.Errorf("Expected sequence numbers 1 and 2 to be expunged, got %v", expunged)
+	}
+	if inbox, archive := mailBoxMailCount(t, cruds, mailBox.mailBoxId), mailBoxMailCount(t, cruds, archiveId); inbox != 1 || archive != 2 {
+		t.Errorf("Expected 1 mail in the inbox and 2 in the archive, found %d and %d", inbox, archive)
+	}
+	if modSeq, _ := cruds["mail_box"].GetMailBoxHighestModSeq(archiveId); modSeq < 2 {
+		t.Errorf("Expected the mod-sequence of the archive to move with the copies, got %d", modSeq)
+	}
+}
+
+func TestImapSeqToUidRange(t *testing.T) {
+
+	mailIds := []int64{3, 7, 9}
+	for _, test := range []struct {
+		uid         bool
+		seq         imap.Seq
+		start, stop uint32
+		ok          bool
+		invalid     bool
+	}{
+		{false, imap.Seq{Start: 1, Stop: 2}, 3, 7, true, false},
+		{false, imap.Seq{Start: 2, Stop: 0}, 7, 9, true, false},
+		{false, imap.Seq{Start: 0, Stop: 0}, 9, 9, true, false},
+		{false, imap.Seq{Start: 3, Stop: 1}, 3, 9, true, false},
+		{false, imap.Seq{Start: 2, Stop: 4}, 0, 0, false, true},
+		{false, imap.Seq{Start: 5, Stop: 5}, 0, 0, false, true},
+		{true, imap.Seq{Start: 4, Stop: 100}, 4, 100, true, false},
+		{true, imap.Seq{Start: 0, Stop: 0}, 9, 0, true, false},
+	} {
+		start, stop, ok, err := imapSeqToUidRange(test.uid, test.seq, mailIds)
+		if test.invalid {
+			if err != errImapInvalidSequence {
+				t.Errorf("Expected %v to be an invalid sequence, got %v", test.seq, err)
+			}
+			continue
+		}
+		if err != nil || ok != test.ok || start != test.start || stop != test.stop {
+			t.Errorf("Expected %v (uid %v) to be %d:%d, got %d:%d %v %v", test.seq, test.uid, test.start, test.stop, start, stop, ok, err)
+		}
+	}
+
+	if _, _, ok, err := imapSeqToUidRange(false, imap.Seq{Start: 1, Stop: 0}, nil); ok || err != errImapInvalidSequence {
+		t.Errorf("Expected sequence number 1 to be invalid in an empty mailbox, got %v %v", ok, err)
+	}
+	if _, _, ok, err := imapSeqToUidRange(false, imap.Seq{}, nil); ok || err != nil {
+		t.Errorf("Expected * to match nothing in an empty mailbox, got %v %v", ok, err)
+	}
+}
+
+func TestMailAccountQuota(t *testing.T) {
+
+	cruds, mailBox, _, cleanup := imapMailBoxFixture(t, 3)
+	defer cleanup()
+	dr := cruds["mail_account"]
+
+	// three mails of 5 octets use a started unit of 1024 octets
+	if err := dr.SetMailAccountQuota(mailBox.mailAccountId, 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	quota, err := dr.GetMailAccountQuota(mailBox.mailAccountId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quota.StorageUsed != 1 || quota.MessageUsed != 3 {
+		t.Errorf("Expected 1 unit of storage and 3 messages used, got %v", quota)
+	}
+	if err = dr.CheckMailAccountQuota(mailBox.mailAccountId, 1, 1); err == nil {
+		t.Errorf("Expected the storage quota to be exceeded")
+	}
+
+	// only the quota root of the account can be set
+	if err = (&imapSetQuotaHandler{Root: "other"}).Handle(nil); err == nil {
+		t.Errorf("Expected another quota root to be refused")
+	}
+}
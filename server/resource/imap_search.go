@@ -0,0 +1,268 @@
+package resource
+
+import (
+	"github.com/Masterminds/squirrel"
+	"github.com/artpar/go-imap"
+	"strings"
+)
+
+// mail headers which are stored in their own column on the mail table and can
+// be searched without reading the message body
+var imapSearchHeaderColumns = map[string]string{
+	"From":         "from_address",
+	"To":           "to_address",
+	"Subject":      "subject",
+	"Message-Id":   "message_id",
+	"Reply-To":     "reply_to_address",
+	"Sender":       "sender_address",
+	"Return-Path":  "return_path",
+	"Content-Type": "content_type",
+}
+
+// columns matched against the TEXT search key
+var imapSearchTextColumns = []string{
+	"from_address", "to_address", "sender_address", "reply_to_address", "subject", "message_id", "body",
+}
+
+// flags which have their own boolean column on the mail table
+var imapSearchFlagColumns = map[string]string{
+	strings.ToLower(imap.SeenFlag):    "seen",
+	strings.ToLower(imap.RecentFlag):  "recent",
+	strings.ToLower(imap.DeletedFlag): "deleted",
+}
+
+// ImapSearchRequiresScan returns true if the criteria refers to a header which
+// is not stored in a column on the mail table, in which case the messages need
+// to be read and matched one by one
+func ImapSearchRequiresScan(criteria *imap.SearchCriteria) bool {
+
+	if criteria == nil {
+		return false
+	}
+
+	for headerName, values := range criteria.Header {
+		if _, ok := imapSearchHeaderColumns[headerName]; !ok {
+			return true
+		}
+		for _, value := range values {
+			if value == "" {
+				return true
+			}
+		}
+	}
+
+	for _, not := range criteria.Not {
+		if ImapSearchRequiresScan(not) {
+			return true
+		}
+	}
+
+	for _, or := range criteria.Or {
+		if ImapSearchRequiresScan(or[0]) || ImapSearchRequiresScan(or[1]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ImapSearchCriteriaToSql converts a RFC 3501 search criteria to a where clause
+// on the mail table. mailIds are the uids of the mailbox in ascending order and
+// are used to resolve sequence numbers and "*".
+func ImapSearchCriteriaToSql(criteria *imap.SearchCriteria, mailIds []int64) (squirrel.Sqlizer, error) {
+
+	clauses := squirrel.And{}
+
+	if criteria == nil {
+		return squirrel.Expr("1 = 1"), nil
+	}
+
+	if criteria.SeqNum != nil {
+		clauses = append(clauses, imapSeqNumToSql(criteria.SeqNum, mailIds))
+	}
+
+	if criteria.Uid != nil {
+		clauses = append(clauses, imapUidSetToSql(criteria.Uid, mailIds))
+	}
+
+	if !criteria.Since.IsZero() {
+		clauses = append(clauses, squirrel.GtOrEq{"internal_date": criteria.Since})
+	}
+	if !criteria.Before.IsZero() {
+		clauses = append(clauses, squirrel.Lt{"internal_date": criteria.Before})
+	}
+	// internal_date is populated from the Date header when the mail is stored
+	if !criteria.SentSince.IsZero() {
+		clauses = append(clauses, squirrel.GtOrEq{"internal_date": criteria.SentSince})
+	}
+	if !criteria.SentBefore.IsZero() {
+		clauses = append(clauses, squirrel.Lt{"internal_date": criteria.SentBefore})
+	}
+
+	for headerName, values := range criteria.Header {
+		columnName, ok := imapSearchHeaderColumns[headerName]
+		if !ok {
+			continue
+		}
+		for _, value := range values {
+			clauses = append(clauses, sqlContains(columnName, value))
+		}
+	}
+
+	for _, value := range criteria.Body {
+		clauses = append(clauses, sqlContains("body", value))
+	}
+
+	for _, value := range criteria.Text {
+		textClause := squirrel.Or{}
+		for _, columnName := range imapSearchTextColumns {
+			textClause = append(textClause, sqlContains(columnName, value))
+		}
+		clauses = append(clauses, textClause)
+	}
+
+	for _, flag := range criteria.WithFlags {
+		clauses = append(clauses, imapFlagToSql(flag, true))
+	}
+	for _, flag := range criteria.WithoutFlags {
+		clauses = append(clauses, imapFlagToSql(flag, false))
+	}
+
+	if criteria.Larger > 0 {
+		clauses = append(clauses, squirrel.Gt{"size": criteria.Larger})
+	}
+	if criteria.Smaller > 0 {
+		clauses = append(clauses, squirrel.Lt{"size": criteria.Smaller})
+	}
+
+	for _, not := range criteria.Not {
+		notClause, err := ImapSearchCriteriaToSql(not, mailIds)
+		if err != nil {
+			return nil, err
+		}
+		negated, err := sqlNot(notClause)
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, negated)
+	}
+
+	for _, or := range criteria.Or {
+		left, err := ImapSearchCriteriaToSql(or[0], mailIds)
+		if err != nil {
+			return nil, err
+		}
+		right, err := ImapSearchCriteriaToSql(or[1], mailIds)
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, squirrel.Or{left, right})
+	}
+
+	if len(clauses) == 0 {
+		return squirrel.Expr("1 = 1"), nil
+	}
+
+	return clauses, nil
+}
+
+func imapSeqNumToSql(seqSet *imap.SeqSet, mailIds []int64) squirrel.Sqlizer {
+
+	ids := make([]int64, 0)
+	for i, id := range mailIds {
+		if seqSet.Contains(uint32(i + 1)) {
+			ids = append(ids, id)
+		}
+	}
+
+	// "*" refers to the last message even when the set is out of range
+	if len(mailIds) > 0 && seqSet.Dynamic() && !seqSet.Contains(uint32(len(mailIds))) {
+		ids = append(ids, mailIds[len(mailIds)-1])
+	}
+
+	if len(ids) == 0 {
+		return squirrel.Expr("1 = 0")
+	}
+
+	return squirrel.Eq{"id": ids}
+}
+
+func imapUidSetToSql(seqSet *imap.SeqSet, mailIds []int64) squirrel.Sqlizer {
+
+	var maxUid int64
+	if len(mailIds) > 0 {
+		maxUid = mailIds[len(mailIds)-1]
+	}
+
+	ranges := squirrel.Or{}
+	for _, seq := range seqSet.Set {
+		start, stop := int64(seq.Start), int64(seq.Stop)
+		if start == 0 {
+			start = maxUid
+		}
+		if stop == 0 {
+			stop = maxUid
+		}
+		if start > stop {
+			start, stop = stop, start
+		}
+
+		if start == stop {
+			ranges = append(ranges, squirrel.Eq{"id": start})
+		} else {
+			ranges = append(ranges, squirrel.And{
+				squirrel.GtOrEq{"id": start},
+				squirrel.LtOrEq{"id": stop},
+			})
+		}
+	}
+
+	if len(ranges) == 0 {
+		return squirrel.Expr("1 = 0")
+	}
+
+	return ranges
+}
+
+func imapFlagToSql(flag string, present bool) squirrel.Sqlizer {
+
+	flag = strings.ToLower(flag)
+	if columnName, ok := imapSearchFlagColumns[flag]; ok {
+		return squirrel.Eq{columnName: present}
+	}
+
+	// flags are stored as a comma separated list
+	hasFlag := squirrel.Or{
+		squirrel.Expr("LOWER(COALESCE(flags, '')) = ?", flag),
+		squirrel.Expr("LOWER(COALESCE(flags, '')) LIKE ? ESCAPE '!'", escapeLike(flag)+",%"),
+		squirrel.Expr("LOWER(COALESCE(flags, '')) LIKE ? ESCAPE '!'", "%,"+escapeLike(flag)),
+		squirrel.Expr("LOWER(COALESCE(flags, '')) LIKE ? ESCAPE '!'", "%,"+escapeLike(flag)+",%"),
+	}
+
+	if present {
+		return hasFlag
+	}
+
+	notFlag, err := sqlNot(hasFlag)
+	if err != nil {
+		return squirrel.Expr("1 = 0")
+	}
+	return notFlag
+}
+
+// sqlContains is a case insensitive substring match on the column
+func sqlContains(columnName string, value string) squirrel.Sqlizer {
+	return squirrel.Expr("LOWER(COALESCE("+columnName+", '')) LIKE ? ESCAPE '!'", "%"+escapeLike(strings.ToLower(value))+"%")
+}
+
+func sqlNot(clause squirrel.Sqlizer) (squirrel.Sqlizer, error) {
+	query, args, err := clause.ToSql()
+	if err != nil {
+		return nil, err
+	}
+	return squirrel.Expr("NOT ("+query+")", args...), nil
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(value)
+}
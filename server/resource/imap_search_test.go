@@ -0,0 +1,59 @@
+package resource
+
+import (
+	"github.com/artpar/go-imap"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func TestImapSearchCriteriaToSql(t *testing.T) {
+
+	criteria := new(imap.SearchCriteria)
+	err := criteria.ParseWithCharset([]interface{}{
+		"2:*", "NOT", "SEEN", "OR", "SUBJECT", "hello", "FROM", "50%", "LARGER", "100",
+	}, nil)
+	if err != nil {
+		t.Fatalf("Failed to parse search criteria: %v", err)
+	}
+
+	where, err := ImapSearchCriteriaToSql(criteria, []int64{3, 7, 9})
+	if err != nil {
+		t.Fatalf("Failed to convert search criteria: %v", err)
+	}
+
+	query, args, err := where.ToSql()
+	if err != nil {
+		t.Fatalf("Failed to build query: %v", err)
+	}
+	t.Logf("Search query: %v %v", query, args)
+
+	if !strings.Contains(query, "id IN (?,?)") {
+		t.Errorf("Expected sequence numbers to be resolved to ids: %v", query)
+	}
+	if !strings.Contains(query, "NOT ((seen = ?))") {
+		t.Errorf("Expected seen flag to be negated: %v", query)
+	}
+	if !strings.Contains(query, "LOWER(COALESCE(subject, '')) LIKE ?") || !strings.Contains(query, " OR ") {
+		t.Errorf("Expected subject and from to be or-ed: %v", query)
+	}
+
+	hasEscapedPattern := false
+	for _, arg := range args {
+		if arg == "%50!%%" {
+			hasEscapedPattern = true
+		}
+	}
+	if !hasEscapedPattern {
+		t.Errorf("Expected like pattern to be escaped: %v", args)
+	}
+
+	if ImapSearchRequiresScan(criteria) {
+		t.Errorf("Expected search to run in sql")
+	}
+
+	criteria.Header = textproto.MIMEHeader{"X-Priority": {"1"}}
+	if !ImapSearchRequiresScan(criteria) {
+		t.Errorf("Expected unknown header to require a scan")
+	}
+}
@@ -54,8 +54,8 @@ func (diu *DaptinImapUser) ListMailboxes(subscribed bool) ([]backend.Mailbox, er
 			name:               box["name"].(string),
 			sessionUser:        diu.sessionUser,
 			mailBoxReferenceId: box["reference_id"].(string),
-			sequenceToMail:     make(map[uint32]*imap.Message),
 			mailBoxId:          box["id"].(int64),
+			mailAccountId:      diu.mailAccountId,
 			info: imap.MailboxInfo{
 				Attributes: strings.Split(box["attributes"].(string), ";"),
 				Delimiter:  "\\",
@@ -197,7 +197,6 @@ func (diu *DaptinImapUser) GetMailbox(name string) (backend.Mailbox, error) {
 		mailBoxId:          box[0]["id"].(int64),
 		mailAccountId:      diu.mailAccountId,
 		lock:               sync.Mutex{},
-		sequenceToMail:     make(map[uint32]*imap.Message),
 		mailBoxReferenceId: box[0]["reference_id"].(string),
 		info: imap.MailboxInfo{
 			Attributes: strings.Split(box[0]["attributes"].(string), ","),
@@ -303,3 +302,22 @@ func (diu *DaptinImapUser) RenameMailbox(existingName, newName string) error {
 func (diu *DaptinImapUser) Logout() error {
 	return nil
 }
+
+// GetQuota returns the usage and limits of the mail account, shared by all of
+// its mailboxes
+func (diu *DaptinImapUser) GetQuota() (*MailAccountQuota, error) {
+	return diu.dbResource["mail_account"].GetMailAccountQuota(diu.mailAccountId)
+}
+
+// SetQuota updates the limits of the mail account, only an administrator can
+// change the quota
+func (diu *DaptinImapUser) SetQuota(storageLimit int64, messageLimit int64) error {
+
+	adminId := diu.dbResource["mail_account"].GetAdminReferenceId()
+	isAdmin := adminId != "" && adminId == diu.sessionUser.UserReferenceId
+	if !isAdmin {
+		return errors.New("only an administrator can change the quota")
+	}
+
+	return diu.dbResource["mail_account"].SetMailAccountQuota(diu.mailAccountId, storageLimit, messageLimit)
+}
@@ -0,0 +1,678 @@
+package resource
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/columntypes"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/graphql-go/graphql"
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+	"github.com/tealeg/xlsx"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// The import modes decide what happens to a row which matches an existing
+// row on the upsert keys of the table
+const (
+	// ImportModeInsert inserts every row, a duplicate fails on the unique keys
+	ImportModeInsert = "insert"
+	// ImportModeUpsert updates the matching row with the values of the row
+	ImportModeUpsert = "upsert"
+	// ImportModeSkip leaves the matching row as it is and skips the row
+	ImportModeSkip = "skip"
+)
+
+// importDefaultBatchSize is the number of rows committed in a transaction
+const importDefaultBatchSize = 100
+
+// importPreviewRows is the number of converted rows in the report of a dry run
+const importPreviewRows = 10
+
+// importReportErrors is the number of errors listed in the report, all of
+// them are written to the error report
+const importReportErrors = 100
+
+// ImportSource is a file of rows to import, the first record is the header
+type ImportSource interface {
+	Read() ([]string, error)
+}
+
+// ImportOptions are the choices of an import of rows into a table
+type ImportOptions struct {
+	// Mapping maps the headers of the file to the columns of the table, the
+	// other headers are mapped to the column with their name
+	Mapping map[string]string
+	Mode    string
+	// RelationKeys are the columns of the related rows the values of a
+	// foreign key are looked up by, the reference id when there is none
+	RelationKeys map[string]string
+	// DryRun writes the rows and rolls them back
+	DryRun    bool
+	BatchSize int
+	// User is the user importing the rows, the rows are created and updated
+	// with the permissions of the user
+	User *auth.SessionUser
+}
+
+// ImportRowError is a row which was not imported, the row is the line in the
+// file and the column is empty when the whole row failed
+type ImportRowError struct {
+	Row    int    `json:"row"`
+	Column string `json:"column"`
+	Value  string `json:"value"`
+	Error  string `json:"error"`
+}
+
+// ImportReport counts what happened to the rows of an import
+type ImportReport struct {
+	Table    string                   `json:"table"`
+	Mode     string                   `json:"mode"`
+	DryRun   bool                     `json:"dry_run"`
+	Mapping  map[string]string        `json:"mapping"`
+	Unmapped []string                 `json:"unmapped"`
+	Total    int                      `json:"total"`
+	Inserted int                      `json:"inserted"`
+	Updated  int                      `json:"updated"`
+	Skipped  int                      `json:"skipped"`
+	Failed   int                      `json:"failed"`
+	Errors   []ImportRowError         `json:"errors"`
+	Preview  []map[string]interface{} `json:"preview,omitempty"`
+}
+
+type importRow struct {
+	line   int
+	values map[string]interface{}
+}
+
+// rowImporter keeps the state of an import while the rows are read
+type rowImporter struct {
+	dr          *DbResource
+	options     ImportOptions
+	report      *ImportReport
+	errorReport *csv.Writer
+	columns     []*api2go.ColumnInfo
+	relationIds map[string]map[string]string
+	seenUpsert  map[string]bool
+	batch       []importRow
+	lastFailed  int
+}
+
+// NewCsvImportSource reads the rows of a csv file, the rows can have less or
+// more cells than the header
+func NewCsvImportSource(reader io.Reader) ImportSource {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	csvReader.LazyQuotes = true
+	return csvReader
+}
+
+type sliceImportSource struct {
+	rows [][]string
+	next int
+}
+
+func (s *sliceImportSource) Read() ([]string, error) {
+	if s.next >= len(s.rows) {
+		return nil, io.EOF
+	}
+	s.next++
+	return s.rows[s.next-1], nil
+}
+
+// NewXlsxImportSource reads the rows of the first sheet of a xlsx file
+func NewXlsxImportSource(contents []byte) (ImportSource, error) {
+	file, err := xlsx.OpenBinary(contents)
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Sheets) == 0 {
+		return nil, fmt.Errorf("no sheet in the file")
+	}
+
+	rows := make([][]string, 0)
+	for _, row := range file.Sheets[0].Rows {
+		cells := make([]string, 0, len(row.Cells))
+		for _, cell := range row.Cells {
+			cells = append(cells, cell.String())
+		}
+		rows = append(rows, cells)
+	}
+	return &sliceImportSource{rows: rows}, nil
+}
+
+// ImportRows imports the rows of the source into the table. The headers are
+// mapped to the columns, the cells are converted to the types of the columns
+// and the foreign keys are looked up by a natural key of the related table.
+// The rows are created and updated as by the api, as the user of the options.
+// The rows are written in transactions of a batch, when a batch fails its
+// rows are written one at a time so only the failing rows are not imported.
+// Every row which failed is written to the error report as csv, with the line,
+// the column, the value and the error.
+func (dr *DbResource) ImportRows(source ImportSource, options ImportOptions, errorReport io.Writer) (*ImportReport, error) {
+
+	if options.Mode == "" {
+		options.Mode = ImportModeInsert
+	}
+	if options.Mode != ImportModeInsert && options.Mode != ImportModeUpsert && options.Mode != ImportModeSkip {
+		return nil, fmt.Errorf("unknown import mode [%v], expected insert, upsert or skip", options.Mode)
+	}
+	if options.BatchSize < 1 {
+		options.BatchSize = importDefaultBatchSize
+	}
+
+	header, err := source.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("the file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	importer := &rowImporter{
+		dr:          dr,
+		options:     options,
+		relationIds: make(map[string]map[string]string),
+		seenUpsert:  make(map[string]bool),
+		report: &ImportReport{
+			Table:    dr.tableInfo.TableName,
+			Mode:     options.Mode,
+			DryRun:   options.DryRun,
+			Mapping:  make(map[string]string),
+			Unmapped: make([]string, 0),
+			Errors:   make([]ImportRowError, 0),
+		},
+	}
+	if errorReport != nil {
+		importer.errorReport = csv.NewWriter(errorReport)
+		err = importer.errorReport.Write([]string{"row", "column", "value", "error"})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = importer.mapColumns(header); err != nil {
+		return nil, err
+	}
+
+	line := 1
+	for {
+		record, err := source.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			importer.report.Total++
+			importer.fail(line, "", "", err)
+			continue
+		}
+		if isEmptyRecord(record) {
+			continue
+		}
+		importer.report.Total++
+
+		values, ok := importer.convert(line, record)
+		if !ok {
+			continue
+		}
+		importer.batch = append(importer.batch, importRow{line: line, values: values})
+		if len(importer.batch) >= options.BatchSize {
+			importer.flush()
+		}
+	}
+	importer.flush()
+
+	if importer.errorReport != nil {
+		importer.errorReport.Flush()
+		if err = importer.errorReport.Error(); err != nil {
+			return importer.report, err
+		}
+	}
+	return importer.report, nil
+}
+
+func isEmptyRecord(record []string) bool {
+	for _, cell := range record {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// mapColumns finds the column of each header, from the mapping or by the
+// name of the column. Id columns and files are not imported.
+func (ri *rowImporter) mapColumns(header []string) error {
+
+	tableInfo := ri.dr.tableInfo
+	ri.columns = make([]*api2go.ColumnInfo, len(header))
+	mapped := make(map[string]string)
+
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		columnName, explicit := ri.options.Mapping[name]
+		if !explicit {
+			columnName = strings.ToLower(name)
+			if _, ok := tableInfo.GetColumnByName(columnName); !ok && columnName != "" {
+				columnName = SmallSnakeCaseText(columnName)
+			}
+		}
+		if columnName == "" {
+			ri.report.Unmapped = append(ri.report.Unmapped, name)
+			continue
+		}
+
+		column, ok := tableInfo.GetColumnByName(columnName)
+		// foreign keys are relationships in the api, they are imported by a key of the related row
+		importable := ok && columnName != "id" && columnName != "permission"
+		if importable && column.IsForeignKey {
+			importable = column.ForeignKeyData.DataSource == "self"
+		} else if importable {
+			importable = !column.ExcludeFromApi
+		}
+		if !importable {
+			if explicit {
+				return fmt.Errorf("[%v] is mapped to [%v] which is not a column of [%v] that can be imported", name, columnName, tableInfo.TableName)
+			}
+			ri.report.Unmapped = append(ri.report.Unmapped, name)
+			continue
+		}
+		if other, ok := mapped[columnName]; ok {
+			return fmt.Errorf("[%v] and [%v] are both mapped to [%v]", other, name, columnName)
+		}
+		mapped[columnName] = name
+		ri.columns[i] = column
+		ri.report.Mapping[name] = columnName
+	}
+
+	if len(mapped) == 0 {
+		return fmt.Errorf("none of the headers is a column of [%v]", tableInfo.TableName)
+	}
+
+	if ri.options.Mode != ImportModeInsert {
+		if len(tableInfo.UpsertKeys) == 0 {
+			return fmt.Errorf("no upsert keys declared on [%v] to match rows for %v", tableInfo.TableName, ri.options.Mode)
+		}
+		for _, key := range tableInfo.UpsertKeys {
+			if _, ok := mapped[key]; !ok {
+				return fmt.Errorf("upsert key [%v] of [%v] is not mapped", key, tableInfo.TableName)
+			}
+		}
+	}
+	return nil
+}
+
+// convert returns the values of the mapped cells in the types of their
+// columns, empty cells are left out. Every invalid cell is reported.
+func (ri *rowImporter) convert(line int, record []string) (map[string]interface{}, bool) {
+
+	values := make(map[string]interface{})
+	ok := true
+	for i, cell := range record {
+		if i >= len(ri.columns) || ri.columns[i] == nil {
+			continue
+		}
+		cell = strings.TrimSpace(cell)
+		if cell == "" {
+			continue
+		}
+		column := ri.columns[i]
+		value, err := ri.convertCell(column, cell)
+		if err != nil {
+			ri.fail(line, column.ColumnName, cell, err)
+			ok = false
+			continue
+		}
+		values[column.ColumnName] = value
+	}
+	if !ok {
+		return nil, false
+	}
+
+	if ri.options.Mode == ImportModeInsert {
+		if err := ri.checkRequired(values); err != nil {
+			ri.fail(line, "", "", err)
+			return nil, false
+		}
+	}
+	return values, true
+}
+
+// importEntityType is the detector a cell of the column is converted with,
+// the cells of other columns are imported as text
+func importEntityType(column *api2go.ColumnInfo) (fieldtypes.EntityType, bool) {
+
+	baseType := strings.Split(column.ColumnType, ".")[0]
+	switch baseType {
+	case "datetime":
+		return fieldtypes.DateTime, true
+	case "date":
+		return fieldtypes.Date, true
+	case "time":
+		return fieldtypes.Time, true
+	case "email":
+		return fieldtypes.Email, true
+	case "json":
+		return fieldtypes.Json, true
+	case "color":
+		return fieldtypes.Color, true
+	}
+
+	if ColumnManager != nil {
+		if columnType, ok := ColumnManager.ColumnMap[column.ColumnType]; ok {
+			switch columnType.GraphqlType {
+			case graphql.Int:
+				return fieldtypes.NumberInt, true
+			case graphql.Float:
+				return fieldtypes.NumberFloat, true
+			case graphql.Boolean:
+				return fieldtypes.Boolean, true
+			}
+		}
+	}
+	return fieldtypes.None, false
+}
+
+func (ri *rowImporter) convertCell(column *api2go.ColumnInfo, cell string) (interface{}, error) {
+
+	if column.IsForeignKey {
+		return ri.relationId(column, cell)
+	}
+
+	if len(column.Options) > 0 {
+		for _, option := range column.Options {
+			if fmt.Sprintf("%v", option.Value) == cell {
+				return cell, nil
+			}
+		}
+		values := make([]string, 0, len(column.Options))
+		for _, option := range column.Options {
+			values = append(values, fmt.Sprintf("%v", option.Value))
+		}
+		return nil, fmt.Errorf("not one of %v", strings.Join(values, ", "))
+	}
+
+	entityType, ok := importEntityType(column)
+	if !ok {
+		return cell, nil
+	}
+	ok, value, err := fieldtypes.ConvertValue(cell, entityType)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("not a valid %v", entityType)
+	}
+	if entityType == fieldtypes.Json {
+		// stored as the text of the json
+		return cell, nil
+	}
+	return value, nil
+}
+
+// relationId is the reference id of the related row having the value in the
+// natural key of the relation, the ids are looked up once per import. Only the
+// rows the user importing can read are looked up, with the checks of the list
+// query of the related table, so an import cannot link to or find out about a
+// row the user cannot read.
+func (ri *rowImporter) relationId(column *api2go.ColumnInfo, cell string) (string, error) {
+
+	ids, ok := ri.relationIds[column.ColumnName]
+	if !ok {
+		ids = make(map[string]string)
+		ri.relationIds[column.ColumnName] = ids
+	}
+	if id, ok := ids[cell]; ok {
+		return id, nil
+	}
+
+	namespace := column.ForeignKeyData.Namespace
+	key := ri.options.RelationKeys[column.ColumnName]
+	if key == "" {
+		key = "reference_id"
+	}
+	related, ok := ri.dr.Cruds[namespace]
+	if !ok {
+		return "", fmt.Errorf("no table [%v] to look up", namespace)
+	}
+	if _, ok := related.tableInfo.GetColumnByName(key); !ok {
+		return "", fmt.Errorf("no column [%v] in [%v] to look up", key, namespace)
+	}
+
+	builder := statementbuilder.Squirrel.Select(namespace + ".reference_id").From(namespace).
+		Where(squirrel.Eq{namespace + "." + key: cell})
+	readable, readableArgs, err := ri.dr.readableRowsCondition(namespace, namespace, ri.sessionUser())
+	if err != nil {
+		return "", err
+	}
+	if readable != "" {
+		builder = builder.Where(readable, readableArgs...)
+	}
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return "", err
+	}
+	matches := make([]string, 0)
+	err = sqlx.Select(related.db, &matches, query, args...)
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no %v with %v [%v]", namespace, key, cell)
+	}
+	if len(matches) > 1 {
+		return "", fmt.Errorf("%d rows of %v have %v [%v]", len(matches), namespace, key, cell)
+	}
+	ids[cell] = matches[0]
+	return matches[0], nil
+}
+
+// checkRequired fails a new row without a value for a column which is not
+// nullable and has no default value
+func (ri *rowImporter) checkRequired(values map[string]interface{}) error {
+
+	missing := make([]string, 0)
+	for _, column := range ri.dr.tableInfo.Columns {
+		if column.IsNullable || column.DefaultValue != "" || column.IsAutoIncrement || column.ExcludeFromApi {
+			continue
+		}
+		if IsStandardColumn(column.ColumnName) {
+			continue
+		}
+		if _, ok := values[column.ColumnName]; !ok {
+			missing = append(missing, column.ColumnName)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("no value for the required columns %v", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// flush writes the rows of the batch in a transaction, if it fails the rows
+// are written again in a transaction each to find the failing ones. The rows
+// of a dry run are written in a transaction each, which is rolled back.
+func (ri *rowImporter) flush() {
+
+	batch := ri.batch
+	ri.batch = nil
+	if len(batch) == 0 {
+		return
+	}
+
+	if !ri.options.DryRun {
+		actions, err := ri.writeBatch(batch)
+		if err == nil {
+			for i, row := range batch {
+				ri.record(row, actions[i], nil)
+			}
+			return
+		}
+		log.Infof("Failed to import a batch of %d rows into [%v], importing them one at a time: %v", len(batch), ri.dr.tableInfo.TableName, err)
+	}
+
+	for _, row := range batch {
+		actions, err := ri.writeBatch([]importRow{row})
+		action := ""
+		if err == nil {
+			action = actions[0]
+		}
+		ri.record(row, action, err)
+	}
+}
+
+// writeBatch writes the rows with the resources of a transaction, so the
+// writes of the middlewares are committed or rolled back with the rows
+func (ri *rowImporter) writeBatch(batch []importRow) ([]string, error) {
+
+	tx, err := ri.dr.connection.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	transactionCruds := NewTransactionCruds(ri.dr.Cruds, tx)
+	dr := transactionCruds[ri.dr.tableInfo.TableName]
+
+	actions := make([]string, 0, len(batch))
+	for _, row := range batch {
+		action, err := ri.write(dr, row)
+		if err != nil {
+			rollbackErr := tx.Rollback()
+			CheckErr(rollbackErr, "Failed to rollback the import batch")
+			return nil, err
+		}
+		actions = append(actions, action)
+	}
+
+	if ri.options.DryRun {
+		return actions, tx.Rollback()
+	}
+	return actions, CommitTransactionCruds(transactionCruds, tx)
+}
+
+// sessionUser is the user importing the rows, a guest without one
+func (ri *rowImporter) sessionUser() *auth.SessionUser {
+	if ri.options.User == nil {
+		return &auth.SessionUser{}
+	}
+	return ri.options.User
+}
+
+// request is the request the rows are created and updated with, as the user
+// importing them
+func (ri *rowImporter) request(method string) api2go.Request {
+
+	sessionUser := ri.sessionUser()
+	httpRequest := &http.Request{
+		Method: method,
+	}
+	httpRequest = httpRequest.WithContext(context.WithValue(context.Background(), "user", sessionUser))
+	return api2go.Request{
+		PlainRequest: httpRequest,
+	}
+}
+
+// write creates, updates or skips a row and returns which of them it did.
+// The row goes through Create and Update, so the values are converted to the
+// column types, passwords are hashed, and the permissions of the table and of
+// the matched row are checked, as for a request to the api.
+func (ri *rowImporter) write(dr *DbResource, row importRow) (string, error) {
+
+	tableName := dr.model.GetName()
+	values := make(map[string]interface{}, len(row.values))
+	for column, value := range row.values {
+		values[column] = value
+	}
+
+	if ri.options.Mode != ImportModeInsert {
+		referenceId, err := dr.GetUpsertMatch(values)
+		if err != nil {
+			return "", err
+		}
+		matched := referenceId != ""
+
+		if ri.options.DryRun {
+			// the rows before were rolled back, a row matching one of them
+			// in the file is counted as matched without writing it again
+			condition, err := dr.upsertKeyValues(values, false)
+			if err != nil {
+				return "", err
+			}
+			key := fmt.Sprintf("%v", condition)
+			seen := ri.seenUpsert[key]
+			ri.seenUpsert[key] = true
+			if seen && !matched {
+				if ri.options.Mode == ImportModeSkip {
+					return "skipped", nil
+				}
+				return "updated", nil
+			}
+		}
+
+		if matched && ri.options.Mode == ImportModeSkip {
+			return "skipped", nil
+		}
+		if matched {
+			values["reference_id"] = referenceId
+			_, err = dr.Update(api2go.NewApi2GoModelWithData(tableName, nil, 0, nil, values), ri.request("PATCH"))
+			return "updated", err
+		}
+		if err = ri.checkRequired(values); err != nil {
+			return "", err
+		}
+	}
+
+	_, err := dr.Create(api2go.NewApi2GoModelWithData(tableName, nil, 0, nil, values), ri.request("POST"))
+	return "inserted", err
+}
+
+// record counts the outcome of a row, the first rows of a dry run are
+// kept as a preview
+func (ri *rowImporter) record(row importRow, action string, err error) {
+
+	if err != nil {
+		ri.fail(row.line, "", "", err)
+		return
+	}
+	switch action {
+	case "inserted":
+		ri.report.Inserted++
+	case "updated":
+		ri.report.Updated++
+	case "skipped":
+		ri.report.Skipped++
+	}
+	if ri.options.DryRun && len(ri.report.Preview) < importPreviewRows {
+		preview := make(map[string]interface{}, len(row.values)+2)
+		for column, value := range row.values {
+			preview[column] = value
+		}
+		preview["__row"] = row.line
+		preview["__action"] = action
+		ri.report.Preview = append(ri.report.Preview, preview)
+	}
+}
+
+// fail reports an error of a row, a row with several invalid cells is
+// counted once
+func (ri *rowImporter) fail(line int, column string, value string, err error) {
+
+	rowError := ImportRowError{Row: line, Column: column, Value: value, Error: err.Error()}
+	if ri.lastFailed != line {
+		ri.report.Failed++
+	}
+	ri.lastFailed = line
+	if len(ri.report.Errors) < importReportErrors {
+		ri.report.Errors = append(ri.report.Errors, rowError)
+	}
+	if ri.errorReport != nil {
+		writeErr := ri.errorReport.Write([]string{fmt.Sprintf("%d", line), column, value, rowError.Error})
+		CheckErr(writeErr, "Failed to write the import error report")
+	}
+}
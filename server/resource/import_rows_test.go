@@ -0,0 +1,158 @@
+package resource
+
+import (
+	"bytes"
+	"github.com/daptin/daptin/server/auth"
+	"strings"
+	"testing"
+)
+
+func TestImportRows(t *testing.T) {
+
+	InitialiseColumnManager()
+	cruds, cleanup := newTestCruds(t)
+	defer cleanup()
+	importer := insertTestUser(t, cruds, "importer", "importer@example.com")
+	colleague := insertTestUser(t, cruds, "colleague", "colleague@example.com")
+
+	userName := func(email string) string {
+		var name string
+		err := cruds[USER_ACCOUNT_TABLE_NAME].db.QueryRowx("select name from user_account where email = ?", email).Scan(&name)
+		if err != nil {
+			return ""
+		}
+		return name
+	}
+
+	// the address book of the card is looked up by its name, among the
+	// address books the user importing can read
+	insertTestRow(t, cruds, "world", map[string]interface{}{"table_name": "address_book", "world_schema_json": "{}"})
+	insertTestRow(t, cruds, "address_book", map[string]interface{}{"name": "personal", "user_account_id": importer.UserId})
+	insertTestRow(t, cruds, "address_book", map[string]interface{}{"name": "private", "user_account_id": colleague.UserId})
+	errorReport := &bytes.Buffer{}
+	report, err := cruds["contact_card"].ImportRows(NewCsvImportSource(strings.NewReader(
+		"File,Uid,Content,Book,Notes\na.vcf,1,BEGIN:VCARD,personal,x\n,,,,\nb.vcf,2,BEGIN:VCARD,nobody,y\nc.vcf,3,BEGIN:VCARD,private,z\n")), ImportOptions{
+		Mapping:      map[string]string{"File": "resource_name", "Book": "address_book_id"},
+		RelationKeys: map[string]string{"address_book_id": "name"},
+		User:         importer,
+	}, errorReport)
+	if err != nil {
+		t.Fatalf("Failed to import contact cards: %v", err)
+	}
+	if report.Total != 3 || report.Inserted != 1 || report.Failed != 2 {
+		t.Errorf("Expected 1 of 3 contact cards to be inserted, got %+v", report)
+	}
+	if len(report.Unmapped) != 1 || report.Unmapped[0] != "Notes" {
+		t.Errorf("Expected the notes to be unmapped, got %v", report.Unmapped)
+	}
+	if !strings.Contains(errorReport.String(), "4,address_book_id,nobody,no address_book with name [nobody]") ||
+		!strings.Contains(errorReport.String(), "5,address_book_id,private,no address_book with name [private]") {
+		t.Errorf("Expected the unknown and the unreadable address books in the error report, got %v", errorReport.String())
+	}
+	var bookName string
+	err = cruds["contact_card"].db.QueryRowx("select b.name from contact_card c join address_book b on b.id = c.address_book_id where c.uid = '1'").Scan(&bookName)
+	if err != nil || bookName != "personal" {
+		t.Errorf("Expected the card to be in the personal address book, got [%v]: %v", bookName, err)
+	}
+
+	_, err = cruds[USER_ACCOUNT_TABLE_NAME].ImportRows(NewCsvImportSource(strings.NewReader("name,email\na,a@example.com\n")),
+		ImportOptions{Mode: ImportModeUpsert}, nil)
+	if err == nil {
+		t.Errorf("Expected upsert to need the upsert keys of the table")
+	}
+	cruds[USER_ACCOUNT_TABLE_NAME].tableInfo.UpsertKeys = []string{"email"}
+
+	users := "name,email,confirmed\nrenamed,colleague@example.com,yes\nnew user,new@example.com,no\nnot confirmed,maybe@example.com,maybe\n"
+	report, err = cruds[USER_ACCOUNT_TABLE_NAME].ImportRows(NewCsvImportSource(strings.NewReader(users)), ImportOptions{
+		Mode:   ImportModeUpsert,
+		DryRun: true,
+	}, nil)
+	if err != nil {
+		t.Fatalf("Failed to preview the import of users: %v", err)
+	}
+	if report.Updated != 1 || report.Inserted != 1 || report.Failed != 1 || len(report.Preview) != 2 {
+		t.Errorf("Expected a user to be updated and one inserted, got %+v", report)
+	}
+	if len(report.Errors) != 1 || report.Errors[0].Row != 4 || report.Errors[0].Column != "confirmed" {
+		t.Errorf("Expected the confirmed value of row 4 to be invalid, got %v", report.Errors)
+	}
+	if userName("colleague@example.com") != "colleague" || userName("new@example.com") != "" {
+		t.Errorf("Expected a dry run to leave the users as they are")
+	}
+
+	report, err = cruds[USER_ACCOUNT_TABLE_NAME].ImportRows(NewCsvImportSource(strings.NewReader(users)), ImportOptions{
+		Mode:      ImportModeUpsert,
+		BatchSize: 1,
+	}, nil)
+	if err != nil {
+		t.Fatalf("Failed to import users: %v", err)
+	}
+	if report.Updated != 1 || report.Inserted != 1 || report.Failed != 1 {
+		t.Errorf("Expected a user to be updated and one inserted, got %+v", report)
+	}
+	if userName("colleague@example.com") != "renamed" || userName("new@example.com") != "new user" {
+		t.Errorf("Expected the users to be imported, got [%v] and [%v]", userName("colleague@example.com"), userName("new@example.com"))
+	}
+
+	report, err = cruds[USER_ACCOUNT_TABLE_NAME].ImportRows(NewCsvImportSource(strings.NewReader(
+		"name,email\nagain,new@example.com\nother,other@example.com\n")), ImportOptions{Mode: ImportModeSkip}, nil)
+	if err != nil {
+		t.Fatalf("Failed to import users: %v", err)
+	}
+	if report.Skipped != 1 || report.Inserted != 1 || userName("new@example.com") != "new user" {
+		t.Errorf("Expected the existing user to be skipped, got %+v", report)
+	}
+
+	// a duplicate fails its own row and not the rest of the batch
+	if _, err = cruds[USER_ACCOUNT_TABLE_NAME].db.Exec("create unique index user_account_email_unique on user_account(email)"); err != nil {
+		t.Fatal(err)
+	}
+	report, err = cruds[USER_ACCOUNT_TABLE_NAME].ImportRows(NewCsvImportSource(strings.NewReader(
+		"name,email\nfirst,first@example.com\nduplicate,other@example.com\nlast,last@example.com\n")), ImportOptions{}, nil)
+	if err != nil {
+		t.Fatalf("Failed to import users: %v", err)
+	}
+	if report.Inserted != 2 || report.Failed != 1 || len(report.Errors) != 1 || report.Errors[0].Row != 3 {
+		t.Errorf("Expected the duplicate user to fail, got %+v", report)
+	}
+
+	// the rows are written with the permissions of the user importing them
+	userAccounts := cruds[USER_ACCOUNT_TABLE_NAME]
+	permissionCheckers := []DatabaseRequestInterceptor{&TableAccessPermissionChecker{}, &ObjectAccessPermissionChecker{}}
+	userAccounts.ms.BeforeCreate = permissionCheckers
+	userAccounts.ms.BeforeUpdate = permissionCheckers
+	defer func() {
+		userAccounts.ms.BeforeCreate = nil
+		userAccounts.ms.BeforeUpdate = nil
+	}()
+	insertTestRow(t, cruds, "world", map[string]interface{}{
+		"table_name":        USER_ACCOUNT_TABLE_NAME,
+		"world_schema_json": "{}",
+	})
+	if _, err = userAccounts.db.Exec("update world set permission = ? where table_name = ?", int64(auth.ALLOW_ALL_PERMISSIONS), USER_ACCOUNT_TABLE_NAME); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = userAccounts.ImportRows(NewCsvImportSource(strings.NewReader(
+		"name,email,password\nmine,importer@example.com,secret0\ntheirs,colleague@example.com,secret1\ncreated,created@example.com,secret2\n")), ImportOptions{
+		Mode:      ImportModeUpsert,
+		BatchSize: 1,
+		User:      importer,
+	}, nil)
+	if err != nil {
+		t.Fatalf("Failed to import users: %v", err)
+	}
+	if report.Updated != 1 || report.Inserted != 1 || report.Failed != 1 || len(report.Errors) != 1 || report.Errors[0].Row != 3 {
+		t.Errorf("Expected the row of another user to fail, got %+v", report)
+	}
+	if userName("importer@example.com") != "mine" || userName("colleague@example.com") != "renamed" {
+		t.Errorf("Expected only the row of the user to be updated, got [%v] and [%v]", userName("importer@example.com"), userName("colleague@example.com"))
+	}
+	var password string
+	if err = userAccounts.db.QueryRowx("select password from user_account where email = 'created@example.com'").Scan(&password); err != nil {
+		t.Fatal(err)
+	}
+	if password == "secret2" || !BcryptCheckStringHash("secret2", password) {
+		t.Errorf("Expected the password to be hashed, got [%v]", password)
+	}
+}
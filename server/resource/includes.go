@@ -0,0 +1,197 @@
+package resource
+
+import (
+	"github.com/artpar/api2go"
+	"regexp"
+	"strings"
+)
+
+// IncludePaths is the tree of relations to include in a response, from the
+// dotted paths in included_relations, like author.organization.country. A
+// relation is named by its column, its column without _id, or the type it
+// refers to. * includes all the relations.
+type IncludePaths map[string]IncludePaths
+
+// ParseIncludePaths builds the tree from a list of dotted paths, nil when
+// there are none
+func ParseIncludePaths(paths []string) IncludePaths {
+
+	var includes IncludePaths
+	for _, path := range paths {
+		node := includes
+		for _, name := range strings.Split(strings.TrimSpace(path), ".") {
+			if name == "" {
+				break
+			}
+			if includes == nil {
+				includes = make(IncludePaths)
+				node = includes
+			}
+			child, ok := node[name]
+			if !ok {
+				child = make(IncludePaths)
+				node[name] = child
+			}
+			node = child
+		}
+	}
+
+	return includes
+}
+
+// includePathsFromRelationMap includes one level of the relations in the map
+func includePathsFromRelationMap(includedRelationMap map[string]bool) IncludePaths {
+	if includedRelationMap == nil {
+		return nil
+	}
+
+	includes := make(IncludePaths)
+	for name, included := range includedRelationMap {
+		if included {
+			includes[name] = IncludePaths{}
+		}
+	}
+	return includes
+}
+
+// Relation tells if the relation of the foreign key column is included, and
+// returns the paths to include below it
+func (includes IncludePaths) Relation(column api2go.ColumnInfo) (IncludePaths, bool) {
+
+	if includes == nil {
+		return nil, false
+	}
+
+	for _, name := range []string{column.ColumnName, strings.TrimSuffix(column.ColumnName, "_id"), column.ForeignKeyData.Namespace} {
+		if children, ok := includes[name]; ok {
+			return children, true
+		}
+	}
+
+	children, ok := includes["*"]
+	return children, ok
+}
+
+// merge adds the paths of other to includes
+func (includes IncludePaths) merge(other IncludePaths) IncludePaths {
+	if includes == nil {
+		includes = make(IncludePaths)
+	}
+	for name, children := range other {
+		includes[name] = includes[name].merge(children)
+	}
+	return includes
+}
+
+var sparseFieldsetRegex = regexp.MustCompile(`^fields\[(\w+)\]$`)
+
+// RequestedFieldsets reads the fields[type]=a,b sparse fieldsets of the
+// request, the fields to return for each type
+func RequestedFieldsets(req api2go.Request) map[string]map[string]bool {
+
+	fieldsets := make(map[string]map[string]bool)
+	for key, values := range req.QueryParams {
+		matches := sparseFieldsetRegex.FindStringSubmatch(key)
+		if len(matches) < 2 {
+			continue
+		}
+		fields := make(map[string]bool)
+		for _, value := range values {
+			for _, field := range strings.Split(value, ",") {
+				if field = strings.TrimSpace(field); field != "" {
+					fields[field] = true
+				}
+			}
+		}
+		fieldsets[matches[1]] = fields
+	}
+
+	return fieldsets
+}
+
+// fieldsetColumns are always loaded, they are needed to identify the rows
+// and to check their permission
+var fieldsetColumns = map[string]bool{
+	"id":                   true,
+	"reference_id":         true,
+	"permission":           true,
+	USER_ACCOUNT_ID_COLUMN: true,
+}
+
+// includedByKey is set on an object included through another included object,
+// it is the list of the objects it was included through, as type/reference_id
+const includedByKey = "__included_by"
+
+func includeKey(include map[string]interface{}) string {
+	referenceId, _ := include["reference_id"].(string)
+	typeName, _ := include["__type"].(string)
+	return typeName + "/" + referenceId
+}
+
+// addInclude adds an included object to the includes of a row, once. The
+// objects it was included through are merged with the existing ones, an object
+// included directly by the row is always reachable.
+func addInclude(includes []map[string]interface{}, positions map[string]int, include map[string]interface{}) []map[string]interface{} {
+
+	key := includeKey(include)
+	position, ok := positions[key]
+	if !ok {
+		positions[key] = len(includes)
+		return append(includes, include)
+	}
+
+	existing := includes[position]
+	existingParents, existingOk := existing[includedByKey].([]string)
+	parents, newOk := include[includedByKey].([]string)
+	if !existingOk {
+		return includes
+	}
+	if !newOk {
+		delete(existing, includedByKey)
+		return includes
+	}
+	existing[includedByKey] = append(append([]string{}, existingParents...), parents...)
+	return includes
+}
+
+// PruneUnreachableIncludes removes the objects included through an included
+// object which was removed, when the user cannot read it, and drops the
+// bookkeeping of the paths from the remaining ones
+func PruneUnreachableIncludes(includes []map[string]interface{}) []map[string]interface{} {
+
+	reachable := make(map[string]bool)
+	for _, include := range includes {
+		if _, nested := include[includedByKey]; !nested {
+			reachable[includeKey(include)] = true
+		}
+	}
+
+	// objects are reachable through a chain of reachable objects
+	for changed := true; changed; {
+		changed = false
+		for _, include := range includes {
+			key := includeKey(include)
+			if reachable[key] {
+				continue
+			}
+			parents, _ := include[includedByKey].([]string)
+			for _, parent := range parents {
+				if reachable[parent] {
+					reachable[key] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	pruned := make([]map[string]interface{}, 0, len(includes))
+	for _, include := range includes {
+		if !reachable[includeKey(include)] {
+			continue
+		}
+		delete(include, includedByKey)
+		pruned = append(pruned, include)
+	}
+	return pruned
+}
@@ -0,0 +1,199 @@
+package resource
+
+import (
+	"context"
+	"github.com/artpar/api2go"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseIncludePaths(t *testing.T) {
+
+	if ParseIncludePaths(nil) != nil || ParseIncludePaths([]string{""}) != nil {
+		t.Errorf("Expected no include paths")
+	}
+
+	includes := ParseIncludePaths([]string{"author.organization.country", "author.avatar", "tags"})
+	if len(includes) != 2 || len(includes["author"]) != 2 || len(includes["author"]["organization"]) != 1 || includes["tags"] == nil {
+		t.Errorf("Unexpected include paths: %v", includes)
+	}
+
+	column := api2go.ColumnInfo{
+		ColumnName:     "author_id",
+		IsForeignKey:   true,
+		ForeignKeyData: api2go.ForeignKeyData{DataSource: "self", Namespace: "user_account"},
+	}
+	for _, name := range []string{"author_id", "author", "user_account", "*"} {
+		children, ok := ParseIncludePaths([]string{name + ".organization"}).Relation(column)
+		if !ok || children["organization"] == nil {
+			t.Errorf("Expected %v to include the relation of %v", name, column.ColumnName)
+		}
+	}
+	if _, ok := includes.Relation(api2go.ColumnInfo{ColumnName: "editor_id"}); ok {
+		t.Errorf("Expected editor_id not to be included")
+	}
+}
+
+func TestRequestedFieldsets(t *testing.T) {
+
+	fieldsets := RequestedFieldsets(api2go.Request{QueryParams: map[string][]string{
+		"fields[book]":   {"title", "author_id"},
+		"fields[author]": {"name,email"},
+		"fields":         {"title"},
+		"sort":           {"title"},
+	}})
+
+	if len(fieldsets) != 2 || !fieldsets["book"]["author_id"] || !fieldsets["author"]["name"] || !fieldsets["author"]["email"] {
+		t.Errorf("Unexpected fieldsets: %v", fieldsets)
+	}
+}
+
+func TestPruneUnreachableIncludes(t *testing.T) {
+
+	includes := []map[string]interface{}{
+		{"__type": "author", "reference_id": "a1"},
+		{"__type": "country", "reference_id": "c1", includedByKey: []string{"organization/o1"}},
+		{"__type": "organization", "reference_id": "o1", includedByKey: []string{"author/a1"}},
+		{"__type": "organization", "reference_id": "o2", includedByKey: []string{"author/a2"}},
+		{"__type": "country", "reference_id": "c2", includedByKey: []string{"organization/o2"}},
+	}
+
+	pruned := PruneUnreachableIncludes(includes)
+	if len(pruned) != 3 {
+		t.Fatalf("Expected the objects included through a2 to be removed, found %v", pruned)
+	}
+	for _, include := range pruned {
+		if _, ok := include[includedByKey]; ok {
+			t.Errorf("Expected %v to be removed from %v", includedByKey, include)
+		}
+	}
+}
+
+func TestResultToArrayOfMapNestedIncludes(t *testing.T) {
+
+	cruds, cleanup := newTestCruds(t)
+	defer cleanup()
+	serverId := insertTestRow(t, cruds, "mail_server", map[string]interface{}{
+		"hostname": "mail.example.com",
+	})
+	accountId := insertTestRow(t, cruds, "mail_account", map[string]interface{}{
+		"username":       "user",
+		"password":       "",
+		"password_md5":   "",
+		"mail_server_id": serverId,
+	})
+	for _, name := range []string{"INBOX", "Sent"} {
+		insertTestRow(t, cruds, "mail_box", map[string]interface{}{
+			"name":            name,
+			"attributes":      "",
+			"flags":           "",
+			"permanent_flags": "",
+			"uidvalidity":     1,
+			"nextuid":         1,
+			"subscribed":      true,
+			"mail_account_id": accountId,
+		})
+	}
+
+	mailBox := cruds["mail_box"]
+	rows, err := mailBox.db.Queryx("select * from mail_box")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	includePaths := ParseIncludePaths([]string{"mail_account.mail_server"})
+	fieldsets := map[string]map[string]bool{"mail_account": {"username": true}}
+	results, includes, err := mailBox.ResultToArrayOfMapWithIncludes(rows, mailBox.model.GetColumnMap(), includePaths, fieldsets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 mail boxes, found %d", len(results))
+	}
+	for i := range results {
+		types := make(map[string]map[string]interface{})
+		for _, include := range includes[i] {
+			types[include["__type"].(string)] = include
+		}
+		if len(includes[i]) != 2 || types["mail_account"] == nil || types["mail_server"] == nil {
+			t.Errorf("Expected the mail account and its mail server to be included once, found %v", includes[i])
+			continue
+		}
+
+		account := types["mail_account"]
+		if account["username"] != "user" || account["reference_id"] == nil || account["mail_server_id"] != types["mail_server"]["reference_id"] {
+			t.Errorf("Unexpected mail account: %v", account)
+		}
+		if _, ok := account["password_md5"]; ok {
+			t.Errorf("Expected the fields of the mail account to be limited to the fieldset: %v", account)
+		}
+		if _, ok := types["mail_server"][includedByKey]; !ok {
+			t.Errorf("Expected the mail server to be included through the mail account")
+		}
+		if types["mail_server"]["hostname"] != "mail.example.com" {
+			t.Errorf("Unexpected mail server: %v", types["mail_server"])
+		}
+	}
+}
+
+func TestFindOneFieldsets(t *testing.T) {
+
+	cruds, cleanup := newTestCruds(t)
+	defer cleanup()
+	serverId := insertTestRow(t, cruds, "mail_server", map[string]interface{}{
+		"hostname": "mail.example.com",
+	})
+	accountId := insertTestRow(t, cruds, "mail_account", map[string]interface{}{
+		"username":       "user",
+		"password":       "secret",
+		"password_md5":   "md5",
+		"mail_server_id": serverId,
+	})
+	referenceId, err := cruds["mail_account"].GetIdToReferenceId("mail_account", accountId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	findOne := func(query string) (map[string]interface{}, http.Header) {
+		header := http.Header{}
+		request := httptest.NewRequest("GET", "/api/mail_account/"+referenceId+"?"+query, nil)
+		request = request.WithContext(context.WithValue(request.Context(), "response_header", header))
+		response, err := cruds["mail_account"].FindOne(referenceId, api2go.Request{
+			PlainRequest: request,
+			QueryParams:  request.URL.Query(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response.Result().(*api2go.Api2GoModel).Data, header
+	}
+
+	data, header := findOne("fields[mail_account]=username&include=mail_server")
+	if data["username"] != "user" || data["reference_id"] != referenceId {
+		t.Errorf("Expected the fields of the fieldset, got %v", data)
+	}
+	for _, column := range []string{"password", "password_md5", "version"} {
+		if _, ok := data[column]; ok {
+			t.Errorf("Expected %v not to be loaded outside the fieldset: %v", column, data)
+		}
+	}
+	if _, ok := data["mail_server_id"]; !ok {
+		t.Errorf("Expected the foreign key of the included relation to be loaded: %v", data)
+	}
+	if header.Get("ETag") == "" {
+		t.Errorf("Expected the etag to be set without the version in the fieldset")
+	}
+
+	data, _ = findOne("fields[mail_account]=username,version")
+	if _, ok := data["version"]; !ok {
+		t.Errorf("Expected the version when it is in the fieldset: %v", data)
+	}
+
+	data, _ = findOne("")
+	if data["password_md5"] != "md5" || data["username"] != "user" {
+		t.Errorf("Expected all the columns without a fieldset, got %v", data)
+	}
+}
@@ -0,0 +1,259 @@
+package resource
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/daptin/daptin/server/database"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/json-iterator/go"
+	log "github.com/sirupsen/logrus"
+	"github.com/xeipuuv/gojsonschema"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// keys and array indexes in json paths, they are written into the sql as they are
+var jsonPathSegmentPattern = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*|[0-9]+)$`)
+
+// jsonPathExpression returns the sql extracting the value at the path from the
+// json column as text. Sqlite has no json functions without the json1
+// extension, it uses the json_path_text function registered on the connections.
+func jsonPathExpression(driverName string, column string, path []string) string {
+
+	switch driverName {
+	case "postgres":
+		return fmt.Sprintf("(%s::jsonb #>> '{%s}')", column, strings.Join(path, ","))
+	case "mysql":
+		return fmt.Sprintf("json_unquote(json_extract(%s, '%s'))", column, jsonPathSelector(path))
+	}
+	return fmt.Sprintf("cast(json_path_text(%s, '%s') as text)", column, strings.Join(path, ","))
+}
+
+// JsonPathText is the value at the comma separated path in the json document
+// as text, like the #>> operator of postgres. It is registered as a function
+// on sqlite. The value is returned as bytes since a missing value and a json
+// null are null in sql, the caller casts it to text.
+func JsonPathText(document string, path string) []byte {
+
+	value := jsoniter.RawMessage(document)
+	for _, segment := range strings.Split(path, ",") {
+		trimmed := strings.TrimSpace(string(value))
+		if strings.HasPrefix(trimmed, "[") {
+			index, err := strconv.Atoi(segment)
+			items := make([]jsoniter.RawMessage, 0)
+			if err != nil || json.Unmarshal(value, &items) != nil || index < 0 || index >= len(items) {
+				return nil
+			}
+			value = items[index]
+		} else if strings.HasPrefix(trimmed, "{") {
+			fields := make(map[string]jsoniter.RawMessage)
+			if json.Unmarshal(value, &fields) != nil {
+				return nil
+			}
+			field, ok := fields[segment]
+			if !ok {
+				return nil
+			}
+			value = field
+		} else {
+			return nil
+		}
+	}
+
+	trimmed := strings.TrimSpace(string(value))
+	if trimmed == "null" || trimmed == "" {
+		return nil
+	}
+	if strings.HasPrefix(trimmed, "\"") {
+		var text string
+		if json.Unmarshal(value, &text) != nil {
+			return nil
+		}
+		return []byte(text)
+	}
+	return []byte(trimmed)
+}
+
+// jsonPathSelector converts the path to the $.key[index] syntax of sqlite and mysql
+func jsonPathSelector(path []string) string {
+	selector := "$"
+	for _, segment := range path {
+		if _, err := strconv.Atoi(segment); err == nil {
+			selector += "[" + segment + "]"
+		} else {
+			selector += "." + segment
+		}
+	}
+	return selector
+}
+
+// splitJsonPath splits column.key.key into the column name and the path inside
+// the column
+func splitJsonPath(columnPath string) (string, []string, error) {
+
+	parts := strings.Split(columnPath, ".")
+	for _, segment := range parts[1:] {
+		if !jsonPathSegmentPattern.MatchString(segment) {
+			return "", nil, fmt.Errorf("invalid json path [%v]", columnPath)
+		}
+	}
+	return parts[0], parts[1:], nil
+}
+
+func (dr *DbResource) driverName() string {
+	if dr.connection == nil {
+		return ""
+	}
+	return dr.connection.DriverName()
+}
+
+// jsonPathColumn returns the sql expression for a path inside a json column of
+// the table, like meta.address.city. The second return value is false when the
+// path does not start with a json column, it is then a relation path.
+func (dr *DbResource) jsonPathColumn(tableName string, alias string, columnPath string) (string, bool, error) {
+
+	crud, ok := dr.Cruds[tableName]
+	if !ok {
+		return "", false, nil
+	}
+
+	columnName := strings.SplitN(columnPath, ".", 2)[0]
+	column, ok := crud.tableInfo.GetColumnByName(columnName)
+	if !ok || column.ColumnType != "json" || column.ColumnName != columnName {
+		return "", false, nil
+	}
+
+	if !dr.isQueryableColumn(tableName, columnName) {
+		return "", true, fmt.Errorf("cannot query on column [%v] of [%v]", columnName, tableName)
+	}
+
+	_, path, err := splitJsonPath(columnPath)
+	if err != nil {
+		return "", true, err
+	}
+
+	return jsonPathExpression(dr.driverName(), alias+"."+columnName, path), true, nil
+}
+
+// jsonPathValue turns numeric text into a number so it compares with numbers
+// extracted from json documents
+func jsonPathValue(value interface{}) interface{} {
+	text, ok := value.(string)
+	if !ok {
+		return value
+	}
+	number, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return value
+	}
+	return number
+}
+
+// CreateJsonPathIndexes indexes the json paths listed in JsonIndexes of the
+// tables. Postgres and sqlite get an index on the extracting expression, mysql
+// gets a virtual generated column with an index.
+func CreateJsonPathIndexes(initConfig *CmsConfig, db database.DatabaseConnection) {
+	log.Infof("Create json path indexes")
+
+	for _, table := range initConfig.Tables {
+		for _, columnPath := range table.JsonIndexes {
+
+			columnName, path, err := splitJsonPath(columnPath)
+			column, ok := table.GetColumnByName(columnName)
+			if err != nil || len(path) == 0 || !ok || column.ColumnType != "json" {
+				log.Errorf("Invalid json index [%v] on [%v]", columnPath, table.TableName)
+				continue
+			}
+
+			indexName := "j" + GetMD5Hash("json_index_"+table.TableName+"_"+columnPath)
+			expression := jsonPathExpression(db.DriverName(), column.ColumnName, path)
+
+			var statements []string
+			switch db.DriverName() {
+			case "mysql":
+				var count int
+				err = db.QueryRowx("select count(*) from information_schema.columns where table_schema = database() and table_name = ? and column_name = ?",
+					table.TableName, indexName).Scan(&count)
+				if err == nil && count > 0 {
+					continue
+				}
+				statements = []string{
+					fmt.Sprintf("alter table %s add column %s varchar(255) generated always as (%s) virtual", table.TableName, indexName, expression),
+					fmt.Sprintf("create index %s on %s (%s)", indexName, table.TableName, indexName),
+				}
+			case "postgres":
+				statements = []string{fmt.Sprintf("create index if not exists %s on %s (%s)", indexName, table.TableName, expression)}
+			default:
+				statements = []string{fmt.Sprintf("create index if not exists %s on %s (%s)", indexName, table.TableName, expression)}
+			}
+
+			for _, statement := range statements {
+				_, err = db.Exec(statement)
+				if err != nil {
+					log.Errorf("Failed to create json index [%v] on [%v]: %v", columnPath, table.TableName, err)
+					break
+				}
+			}
+		}
+	}
+}
+
+// jsonSchemaTag is the validation tag naming the schema from the json_schema
+// table which values of a json column have to follow
+const jsonSchemaTag = "json_schema="
+
+// splitJsonSchemaTags separates the json_schema tag from the tags handled by the validator
+func splitJsonSchemaTags(tags string) (string, string) {
+	otherTags := make([]string, 0)
+	schemaName := ""
+	for _, tag := range strings.Split(tags, ",") {
+		if strings.HasPrefix(tag, jsonSchemaTag) {
+			schemaName = strings.TrimPrefix(tag, jsonSchemaTag)
+		} else if tag != "" {
+			otherTags = append(otherTags, tag)
+		}
+	}
+	return schemaName, strings.Join(otherTags, ",")
+}
+
+// ValidateJsonSchema checks the value of a json column against the schema with
+// the name in the json_schema table
+func (dr *DbResource) ValidateJsonSchema(schemaName string, value interface{}) error {
+
+	if value == nil {
+		return nil
+	}
+
+	query, args, err := statementbuilder.Squirrel.Select("json_schema").From("json_schema").
+		Where(squirrel.Eq{"schema_name": schemaName}).Limit(1).ToSql()
+	if err != nil {
+		return err
+	}
+
+	var schema string
+	err = dr.db.QueryRowx(query, args...).Scan(&schema)
+	if err != nil {
+		return fmt.Errorf("json schema [%v] not found", schemaName)
+	}
+
+	var document gojsonschema.JSONLoader
+	if text, ok := value.(string); ok {
+		if text == "" {
+			return nil
+		}
+		document = gojsonschema.NewStringLoader(text)
+	} else {
+		document = gojsonschema.NewGoLoader(value)
+	}
+
+	result, err := gojsonschema.Validate(gojsonschema.NewStringLoader(schema), document)
+	if err != nil {
+		return err
+	}
+	if !result.Valid() {
+		return errors.New(result.Errors()[0].String())
+	}
+	return nil
+}
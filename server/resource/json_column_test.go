@@ -0,0 +1,96 @@
+package resource
+
+import (
+	"database/sql"
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+	"testing"
+)
+
+func TestJsonPathExpression(t *testing.T) {
+
+	column, path, err := splitJsonPath("meta.address.lines.0")
+	if err != nil || column != "meta" || len(path) != 3 {
+		t.Fatalf("Unexpected json path: %v %v %v", column, path, err)
+	}
+
+	expected := map[string]string{
+		"sqlite3":  "cast(json_path_text(t.meta, 'address,lines,0') as text)",
+		"postgres": "(t.meta::jsonb #>> '{address,lines,0}')",
+		"mysql":    "json_unquote(json_extract(t.meta, '$.address.lines[0]'))",
+	}
+	for driverName, expression := range expected {
+		if actual := jsonPathExpression(driverName, "t.meta", path); actual != expression {
+			t.Errorf("Unexpected %v expression: %v", driverName, actual)
+		}
+	}
+
+	for _, invalid := range []string{"meta.a'b", "meta.", "meta.a b", "meta.$"} {
+		if _, _, err := splitJsonPath(invalid); err == nil {
+			t.Errorf("Expected [%v] to be invalid", invalid)
+		}
+	}
+
+	schemaName, tags := splitJsonSchemaTags("required,json_schema=address")
+	if schemaName != "address" || tags != "required" {
+		t.Errorf("Unexpected tags: %v %v", schemaName, tags)
+	}
+}
+
+func TestJsonPathText(t *testing.T) {
+
+	document := `{"name": "a", "count": 3, "price": 1.50, "open": true, "none": null, "tags": ["x", {"y": "z"}]}`
+	expected := map[string]interface{}{
+		"name":     "a",
+		"count":    "3",
+		"price":    "1.50",
+		"open":     "true",
+		"none":     nil,
+		"missing":  nil,
+		"tags,0":   "x",
+		"tags,1":   `{"y": "z"}`,
+		"tags,1,y": "z",
+		"tags,2":   nil,
+		"name,0":   nil,
+	}
+	for path, value := range expected {
+		actual := JsonPathText(document, path)
+		if value == nil && actual != nil || value != nil && string(actual) != value {
+			t.Errorf("Expected [%v] at [%v], got [%s]", value, path, actual)
+		}
+	}
+	if JsonPathText("not json", "a") != nil {
+		t.Errorf("Expected no value in invalid json")
+	}
+
+	// the function is registered on the sqlite connections of daptin
+	sql.Register("sqlite3_json_test", &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("json_path_text", JsonPathText, true)
+		},
+	})
+	db, err := sqlx.Open("sqlite3_json_test", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	db.MustExec("create table t (meta text)")
+	for _, meta := range []string{`{"city": "berlin", "size": 12}`, `{"city": "paris", "size": 2}`, `{"size": null}`} {
+		db.MustExec("insert into t (meta) values (?)", meta)
+	}
+
+	city := jsonPathExpression("sqlite3", "t.meta", []string{"city"})
+	size := jsonPathExpression("sqlite3", "t.meta", []string{"size"})
+	for query, count := range map[string]int{
+		"select count(*) from t where " + city + " = 'paris'":            1,
+		"select count(*) from t where " + city + " is null":              1,
+		"select count(*) from t where cast(" + size + " as real) > 10.0": 1,
+		"select count(*) from t where " + size + " = '2'":                1,
+	} {
+		var matched int
+		if err := db.QueryRowx(query).Scan(&matched); err != nil || matched != count {
+			t.Errorf("Expected %d rows for [%v], got %d: %v", count, query, matched, err)
+		}
+	}
+}
@@ -0,0 +1,422 @@
+package resource
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/artpar/api2go"
+	"github.com/artpar/go.uuid"
+	"github.com/artpar/parsemail"
+	"github.com/artpar/rclone/cmd"
+	"github.com/artpar/rclone/fs/config"
+	"github.com/artpar/rclone/fs/sync"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/statementbuilder"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MailRule is an inbound mail rule. Mails sent to a recipient matching
+// RecipientPattern with a subject matching SubjectPattern invoke the action
+// ActionName on EntityName with the parsed mail as the in fields.
+type MailRule struct {
+	Id               int64
+	ReferenceId      string
+	Name             string
+	RecipientPattern string
+	SubjectPattern   string
+	ActionName       string
+	EntityName       string
+	Attributes       map[string]interface{}
+	AttachmentPath   string
+	CloudStoreId     *int64
+	UserId           *int64
+
+	// the patterns compiled when the rule is loaded
+	recipientRegexp *regexp.Regexp
+	subjectRegexp   *regexp.Regexp
+}
+
+// mailRuleTimeout is how long the delivery of a mail waits for the actions of
+// the matching rules, slower actions go on after the mail is accepted
+var mailRuleTimeout = 30 * time.Second
+
+// Matches returns true if the recipient and subject match the patterns of the
+// rule. Patterns are case insensitive regular expressions, an empty pattern
+// matches everything.
+func (rule MailRule) Matches(recipient string, subject string) bool {
+	if rule.recipientRegexp == nil || rule.subjectRegexp == nil {
+		if err := rule.compilePatterns(); err != nil {
+			log.Errorf("Invalid pattern in mail rule [%v]: %v", rule.Name, err)
+			return false
+		}
+	}
+	return rule.recipientRegexp.MatchString(recipient) && rule.subjectRegexp.MatchString(subject)
+}
+
+func (rule *MailRule) compilePatterns() error {
+
+	recipientRegexp, err := regexp.Compile("(?i)" + rule.RecipientPattern)
+	if err != nil {
+		return err
+	}
+	subjectRegexp, err := regexp.Compile("(?i)" + rule.SubjectPattern)
+	if err != nil {
+		return err
+	}
+
+	rule.recipientRegexp = recipientRegexp
+	rule.subjectRegexp = subjectRegexp
+	return nil
+}
+
+// GetAllMailRules returns the enabled inbound mail rules
+func (dr *DbResource) GetAllMailRules() ([]MailRule, error) {
+
+	var rules []MailRule
+
+	s, v, err := statementbuilder.Squirrel.Select("r.id", "r.reference_id", "r.name", "r.recipient_pattern", "r.subject_pattern",
+		"r.action_name", "r.entity_name", "r.attributes", "r.attachment_path", "r.cloud_store_id", "r."+USER_ACCOUNT_ID_COLUMN).
+		From("mail_rule r").Where("r.is_enabled = ?", true).
+		ToSql()
+	if err != nil {
+		return rules, err
+	}
+
+	rows, err := dr.db.Queryx(s, v...)
+	if err != nil {
+		return rules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rule MailRule
+		var name, recipientPattern, subjectPattern, attributes, attachmentPath sql.NullString
+		var cloudStoreId, userId sql.NullInt64
+		err = rows.Scan(&rule.Id, &rule.ReferenceId, &name, &recipientPattern, &subjectPattern,
+			&rule.ActionName, &rule.EntityName, &attributes, &attachmentPath, &cloudStoreId, &userId)
+		if err != nil {
+			log.Errorf("Failed to scan mail rule from db to struct: %v", err)
+			continue
+		}
+
+		rule.Name = name.String
+		rule.RecipientPattern = recipientPattern.String
+		rule.SubjectPattern = subjectPattern.String
+		rule.AttachmentPath = attachmentPath.String
+		if cloudStoreId.Valid {
+			rule.CloudStoreId = &cloudStoreId.Int64
+		}
+		if userId.Valid {
+			rule.UserId = &userId.Int64
+		}
+
+		err = rule.compilePatterns()
+		if CheckErr(err, "Invalid pattern in mail rule [%v]", rule.Name) {
+			continue
+		}
+
+		rule.Attributes = make(map[string]interface{})
+		if attributes.String != "" {
+			err = json.Unmarshal([]byte(attributes.String), &rule.Attributes)
+			if CheckErr(err, "Failed to unmarshal attributes for mail rule [%v]", rule.Name) {
+				continue
+			}
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// RunMailRules invokes the action of every mail rule matching the recipient
+// and subject of the mail. It returns true if at least one rule matched. The
+// actions are waited for up to mailRuleTimeout.
+func (dr *DbResource) RunMailRules(recipient string, subject string, mailBytes []byte) (bool, error) {
+
+	rules, err := dr.GetAllMailRules()
+	if err != nil {
+		return false, err
+	}
+
+	matchingRules := make([]MailRule, 0)
+	for _, rule := range rules {
+		if rule.Matches(recipient, subject) {
+			matchingRules = append(matchingRules, rule)
+		}
+	}
+	if len(matchingRules) == 0 {
+		return false, nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, rule := range matchingRules {
+			log.Printf("Mail to [%v] matched rule [%v], invoking [%v] on [%v]", recipient, rule.Name, rule.ActionName, rule.EntityName)
+			_, err := dr.ExecuteMailRule(rule, recipient, mailBytes)
+			CheckErr(err, "Failed to execute mail rule [%v]", rule.Name)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(mailRuleTimeout):
+		log.Errorf("Mail rules for [%v] did not finish in %v, they go on in the background", recipient, mailRuleTimeout)
+	}
+
+	return true, nil
+}
+
+// IsLocalMailHost tells if the host is the hostname of one of the mail servers
+func (dr *DbResource) IsLocalMailHost(host string) bool {
+
+	s, v, err := statementbuilder.Squirrel.Select("count(*)").From("mail_server").
+		Where("lower(hostname) = ?", strings.ToLower(host)).ToSql()
+	if err != nil {
+		return false
+	}
+
+	var count int
+	err = dr.db.QueryRowx(s, v...).Scan(&count)
+	if CheckErr(err, "Failed to look up mail server [%v]", host) {
+		return false
+	}
+	return count > 0
+}
+
+// RunInboundMailRules runs the mail rules for a recipient at a local host,
+// after the mail account of the recipient was looked up. It returns true when a
+// rule matched a mail for which there is no mail account, so the mail is not
+// delivered any further. Mails to other hosts are relayed without running the
+// rules.
+func (dr *DbResource) RunInboundMailRules(recipient string, host string, hasAccount bool, subject string, mailBytes []byte) bool {
+
+	if !hasAccount && !dr.IsLocalMailHost(host) {
+		return false
+	}
+
+	ruleMatched, err := dr.RunMailRules(recipient, subject, mailBytes)
+	CheckErr(err, "Failed to run mail rules for [%v]", recipient)
+	return ruleMatched && !hasAccount
+}
+
+// ExecuteMailRule parses the mail, uploads its attachments to the cloud store
+// of the rule and invokes the action of the rule as the owner of the rule
+func (dr *DbResource) ExecuteMailRule(rule MailRule, recipient string, mailBytes []byte) ([]ActionResponse, error) {
+
+	crud, ok := dr.Cruds[rule.EntityName]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("no such entity [%v]", rule.EntityName))
+	}
+
+	inFields, attachments, err := ParseMailForAction(mailBytes)
+	if err != nil {
+		return nil, err
+	}
+	inFields["recipient"] = recipient
+
+	if len(attachments) > 0 && rule.CloudStoreId != nil {
+		destination, err := dr.uploadMailAttachments(*rule.CloudStoreId, rule.AttachmentPath, attachments)
+		if err != nil {
+			return nil, err
+		}
+		for _, attachment := range inFields["attachments"].([]interface{}) {
+			attachmentMap := attachment.(map[string]interface{})
+			attachmentMap["path"] = destination + "/" + attachmentMap["name"].(string)
+		}
+	}
+
+	attributes := make(map[string]interface{})
+	for key, value := range rule.Attributes {
+		attributes[key] = value
+	}
+	for key, value := range inFields {
+		attributes[key] = value
+	}
+
+	sessionUser := &auth.SessionUser{}
+	if rule.UserId != nil {
+		userReferenceId, err := dr.GetIdToReferenceId(USER_ACCOUNT_TABLE_NAME, *rule.UserId)
+		if err != nil {
+			return nil, err
+		}
+		sessionUser.UserId = *rule.UserId
+		sessionUser.UserReferenceId = userReferenceId
+		sessionUser.Groups = dr.GetObjectUserGroupsByWhere(USER_ACCOUNT_TABLE_NAME, "id", *rule.UserId)
+	}
+
+	pr1 := http.Request{
+		Method: "EXECUTE",
+	}
+	pr := pr1.WithContext(context.WithValue(context.Background(), "user", sessionUser))
+	req := api2go.Request{
+		PlainRequest: pr,
+	}
+
+	return crud.HandleActionRequest(&ActionRequest{
+		Action:     rule.ActionName,
+		Type:       rule.EntityName,
+		Attributes: attributes,
+	}, req)
+}
+
+// MailAttachment is a file attached to a mail
+type MailAttachment struct {
+	Name        string
+	ContentType string
+	Contents    []byte
+}
+
+// ParseMailForAction reads the sender, recipients, subject, text and html body
+// of a mail into a map which can be used as the in fields of an action. Each
+// attachment is described in the "attachments" list with its name, type, size
+// and base64 contents.
+func ParseMailForAction(mailBytes []byte) (map[string]interface{}, []MailAttachment, error) {
+
+	email, err := parsemail.Parse(bytes.NewReader(mailBytes))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	inFields := map[string]interface{}{
+		"subject":     email.Subject,
+		"text":        email.TextBody,
+		"html":        email.HTMLBody,
+		"message_id":  email.MessageID,
+		"to":          formatMailAddresses(email.To),
+		"cc":          formatMailAddresses(email.Cc),
+		"sender":      "",
+		"sender_name": "",
+	}
+
+	if len(email.From) > 0 {
+		inFields["sender"] = email.From[0].Address
+		inFields["sender_name"] = email.From[0].Name
+	} else if email.Sender != nil {
+		inFields["sender"] = email.Sender.Address
+		inFields["sender_name"] = email.Sender.Name
+	}
+
+	if !email.Date.IsZero() {
+		inFields["date"] = email.Date
+	}
+
+	attachments := make([]MailAttachment, 0)
+	attachmentFields := make([]interface{}, 0)
+	for _, attachment := range email.Attachments {
+		contents, err := ioutil.ReadAll(attachment.Data)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		name := filepath.Base(attachment.Filename)
+		if name == "" || name == "." || name == string(filepath.Separator) {
+			u, _ := uuid.NewV4()
+			name = u.String()
+		}
+
+		attachments = append(attachments, MailAttachment{
+			Name:        name,
+			ContentType: attachment.ContentType,
+			Contents:    contents,
+		})
+		attachmentFields = append(attachmentFields, map[string]interface{}{
+			"name":     name,
+			"type":     attachment.ContentType,
+			"size":     len(contents),
+			"contents": base64.StdEncoding.EncodeToString(contents),
+		})
+	}
+	inFields["attachments"] = attachmentFields
+
+	return inFields, attachments, nil
+}
+
+func formatMailAddresses(addresses []*mail.Address) string {
+	values := make([]string, 0)
+	for _, address := range addresses {
+		values = append(values, address.Address)
+	}
+	return strings.Join(values, ",")
+}
+
+// uploadMailAttachments copies the attachments to the folder in the cloud store
+// and returns the path of the folder in the store
+func (dr *DbResource) uploadMailAttachments(cloudStoreId int64, folder string, attachments []MailAttachment) (string, error) {
+
+	cloudStores, err := dr.GetAllCloudStores()
+	if err != nil {
+		return "", err
+	}
+
+	var cloudStore *CloudStore
+	for i := range cloudStores {
+		if cloudStores[i].Id == cloudStoreId {
+			cloudStore = &cloudStores[i]
+			break
+		}
+	}
+	if cloudStore == nil {
+		return "", errors.New(fmt.Sprintf("no such cloud store [%v]", cloudStoreId))
+	}
+
+	u, _ := uuid.NewV4()
+	tempDirectoryPath, err := ioutil.TempDir("", u.String())
+	if err != nil {
+		return "", err
+	}
+
+	for _, attachment := range attachments {
+		err = ioutil.WriteFile(filepath.Join(tempDirectoryPath, attachment.Name), attachment.Contents, 0666)
+		if err != nil {
+			os.RemoveAll(tempDirectoryPath)
+			return "", err
+		}
+	}
+
+	if cloudStore.OAutoTokenId != "" {
+		token, oauthConf, err := dr.Cruds["oauth_token"].GetTokenByTokenReferenceId(cloudStore.OAutoTokenId)
+		CheckErr(err, "Failed to get oauth2 token for mail attachment upload")
+
+		jsonToken, err := json.Marshal(token)
+		CheckErr(err, "Failed to convert token to json")
+		config.FileSet(cloudStore.StoreProvider, "client_id", oauthConf.ClientID)
+		config.FileSet(cloudStore.StoreProvider, "type", cloudStore.StoreProvider)
+		config.FileSet(cloudStore.StoreProvider, "client_secret", oauthConf.ClientSecret)
+		config.FileSet(cloudStore.StoreProvider, "token", string(jsonToken))
+		config.FileSet(cloudStore.StoreProvider, "client_scopes", strings.Join(oauthConf.Scopes, ","))
+		config.FileSet(cloudStore.StoreProvider, "redirect_url", oauthConf.RedirectURL)
+	}
+
+	destination := cloudStore.RootPath
+	if folder != "" {
+		destination = destination + "/" + strings.Trim(folder, "/")
+	}
+	defer os.RemoveAll(tempDirectoryPath)
+
+	// the copy is waited for, the action gets the paths of uploaded files
+	fsrc := cmd.NewFsDir([]string{tempDirectoryPath})
+	fdst := cmd.NewFsDir([]string{destination})
+	if fsrc == nil || fdst == nil {
+		return "", errors.New(fmt.Sprintf("failed to open cloud store [%v]", cloudStore.Name))
+	}
+
+	err = sync.CopyDir(context.Background(), fdst, fsrc, true)
+	if err != nil {
+		return "", err
+	}
+
+	return destination, nil
+}
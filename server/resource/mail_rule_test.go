@@ -0,0 +1,99 @@
+package resource
+
+import (
+	"testing"
+)
+
+func TestMailRuleMatches(t *testing.T) {
+
+	rule := MailRule{RecipientPattern: "^support@", SubjectPattern: "order [0-9]+"}
+	for _, test := range []struct {
+		recipient string
+		subject   string
+		matches   bool
+	}{
+		{"support@example.org", "Order 42 is late", true},
+		{"SUPPORT@example.org", "order 42", true},
+		{"sales@example.org", "order 42", false},
+		{"support@example.org", "hello", false},
+	} {
+		if rule.Matches(test.recipient, test.subject) != test.matches {
+			t.Errorf("Expected [%v] [%v] to match: %v", test.recipient, test.subject, test.matches)
+		}
+	}
+
+	if !(MailRule{}).Matches("anyone@example.org", "anything") {
+		t.Errorf("Expected empty patterns to match every mail")
+	}
+	if (MailRule{RecipientPattern: "("}).Matches("anyone@example.org", "") {
+		t.Errorf("Expected an invalid pattern to match no mail")
+	}
+}
+
+func TestRunInboundMailRules(t *testing.T) {
+
+	cruds, cleanup := newTestCruds(t)
+	defer cleanup()
+
+	insertTestRow(t, cruds, "mail_server", map[string]interface{}{"hostname": "example.org"})
+	insertTestRow(t, cruds, "mail_rule", map[string]interface{}{
+		"name":              "tickets",
+		"recipient_pattern": "^support@",
+		"action_name":       "create_ticket",
+		"entity_name":       "world",
+		"is_enabled":        true,
+	})
+
+	mail := []byte("From: someone@example.com\r\nTo: support@example.org\r\nSubject: help\r\n\r\nhello\r\n")
+	dr := cruds["mail_rule"]
+	for _, test := range []struct {
+		recipient  string
+		host       string
+		hasAccount bool
+		handled    bool
+	}{
+		// no local account, the rule handles the mail
+		{"support@example.org", "example.org", false, true},
+		{"support@EXAMPLE.org", "EXAMPLE.org", false, true},
+		// the mail is stored for the local account
+		{"support@example.org", "example.org", true, false},
+		// no rule matches, the mail goes on as before
+		{"sales@example.org", "example.org", false, false},
+		// other hosts are relayed even when a rule would match
+		{"support@example.com", "example.com", false, false},
+	} {
+		if handled := dr.RunInboundMailRules(test.recipient, test.host, test.hasAccount, "help", mail); handled != test.handled {
+			t.Errorf("Expected mail to [%v] with account %v to be handled by a rule: %v", test.recipient, test.hasAccount, test.handled)
+		}
+	}
+}
+
+func TestGetAllMailRules(t *testing.T) {
+
+	cruds, cleanup := newTestCruds(t)
+	defer cleanup()
+
+	for name, pattern := range map[string]string{"tickets": "^support@", "broken": "("} {
+		insertTestRow(t, cruds, "mail_rule", map[string]interface{}{
+			"name":              name,
+			"recipient_pattern": pattern,
+			"action_name":       "create_ticket",
+			"entity_name":       "world",
+			"is_enabled":        true,
+		})
+	}
+
+	rules, err := cruds["mail_rule"].GetAllMailRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].Name != "tickets" {
+		t.Fatalf("Expected only the rule with a valid pattern, got %v", rules)
+	}
+	if rules[0].recipientRegexp == nil || rules[0].subjectRegexp == nil {
+		t.Errorf("Expected the patterns to be compiled when the rule is loaded")
+	}
+	if !rules[0].Matches("Support@example.org", "") {
+		t.Errorf("Expected the loaded rule to match")
+	}
+}
@@ -55,7 +55,19 @@ func (dvm *DataValidationMiddleware) InterceptBefore(dr *DbResource, req *api2go
 				if !ok {
 					continue
 				}
-				errs := ValidatorInstance.VarWithValue(colValue, obj, validate.Tags)
+
+				schemaName, tags := splitJsonSchemaTags(validate.Tags)
+				if schemaName != "" {
+					err := dr.ValidateJsonSchema(schemaName, colValue)
+					if err != nil {
+						return nil, api2go.NewHTTPError(err, fmt.Sprintf("'%v' does not match the schema [%v]: %v", validate.ColumnName, schemaName, err), 400)
+					}
+				}
+				if tags == "" {
+					continue
+				}
+
+				errs := ValidatorInstance.VarWithValue(colValue, obj, tags)
 
 				if errs != nil {
 					validationErrors, ok := errs.(validator.ValidationErrors)
@@ -4,31 +4,42 @@ import (
 	"context"
 	"github.com/artpar/api2go"
 	log "github.com/sirupsen/logrus"
-	"strings"
 )
 
+// eventHandlerMiddleware sends the rows created, updated or deleted to the
+// subscribers of the broker
 type eventHandlerMiddleware struct {
+	eventType string
+	broker    *EntityEventBroker
 }
 
 func (pc eventHandlerMiddleware) String() string {
 	return "EventGenerator"
 }
 
+type pendingEventsKey struct{}
+
 func (pc *eventHandlerMiddleware) InterceptAfter(dr *DbResource, req *api2go.Request, results []map[string]interface{}) ([]map[string]interface{}, error) {
 
-	switch strings.ToLower(req.PlainRequest.Method) {
-	case "get":
-		break
-	case "post":
-		break
-	case "update":
-		break
-	case "delete":
-		break
-	case "patch":
-		break
-	default:
-		log.Errorf("Invalid method: %v", req.PlainRequest.Method)
+	if pc.broker == nil {
+		return results, nil
+	}
+
+	switch pc.eventType {
+	case EntityCreated, EntityUpdated:
+		for _, result := range results {
+			referenceId, ok := result["reference_id"].(string)
+			if !ok {
+				continue
+			}
+			dr.publishEntityEvent(entityEvent{broker: pc.broker, dr: dr, eventType: pc.eventType, referenceId: referenceId})
+		}
+	case EntityDeleted:
+		// deleted rows are matched before they are deleted
+		deliveries, ok := req.PlainRequest.Context().Value(pendingEventsKey{}).([]entityEventDelivery)
+		if ok && len(deliveries) > 0 {
+			dr.publishEntityEvent(entityEvent{broker: pc.broker, dr: dr, eventType: pc.eventType, deliveries: deliveries})
+		}
 	}
 
 	return results, nil
@@ -37,25 +48,20 @@ func (pc *eventHandlerMiddleware) InterceptAfter(dr *DbResource, req *api2go.Req
 
 func (pc *eventHandlerMiddleware) InterceptBefore(dr *DbResource, req *api2go.Request, objects []map[string]interface{}) ([]map[string]interface{}, error) {
 
-	reqmethod := req.PlainRequest.Method
-	//log.Infof("Generate events for objects", reqmethod)
-	switch reqmethod {
-	case "GET":
-		break
-	case "POST":
-		break
-	case "UPDATE":
-		break
-	case "DELETE":
-		break
-	case "PATCH":
-		break
-	default:
-		log.Errorf("Invalid method: %v", reqmethod)
+	if pc.broker == nil || pc.eventType != EntityDeleted {
+		return objects, nil
 	}
 
-	//currentUserId := context.Get(req.PlainRequest, "user_id").(string)
-	//currentUserGroupId := context.Get(req.PlainRequest, "usergroup_id").([]string)
+	deliveries := make([]entityEventDelivery, 0)
+	for _, object := range objects {
+		referenceId, ok := object["reference_id"].(string)
+		if !ok {
+			continue
+		}
+		deliveries = append(deliveries, pc.broker.matchSubscriptions(dr, pc.eventType, referenceId)...)
+	}
+	// always replaced, the rows deleted with this one are deleted with the same request
+	req.PlainRequest = req.PlainRequest.WithContext(context.WithValue(req.PlainRequest.Context(), pendingEventsKey{}, deliveries))
 
 	return objects, nil
 
@@ -45,6 +45,22 @@ func (pc *ObjectAccessPermissionChecker) InterceptAfter(dr *DbResource, req *api
 	notIncludedMapCache := make(map[string]bool)
 	includedMapCache := make(map[string]bool)
 
+	// permissions of all the rows are loaded together
+	rowsToCheck := make([]map[string]interface{}, 0)
+	rowsToCheckIndex := make(map[string]int)
+	for _, result := range results {
+		if result == nil || BeginsWith(result["__type"].(string), "image.") {
+			continue
+		}
+		referenceId := result["reference_id"].(string)
+		if _, ok := rowsToCheckIndex[referenceId]; ok {
+			continue
+		}
+		rowsToCheckIndex[referenceId] = len(rowsToCheck)
+		rowsToCheck = append(rowsToCheck, result)
+	}
+	rowPermissions := dr.GetRowPermissions(rowsToCheck)
+
 	for _, result := range results {
 		//log.Infof("Result: %v", result)
 
@@ -71,7 +87,7 @@ func (pc *ObjectAccessPermissionChecker) InterceptAfter(dr *DbResource, req *api
 			continue
 		}
 
-		permission := dr.GetRowPermission(result)
+		permission := rowPermissions[rowsToCheckIndex[referenceId]]
 
 		//log.Infof("Row Permission for [%v] for [%v]", permission, result)
 
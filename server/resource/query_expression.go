@@ -0,0 +1,637 @@
+package resource
+
+import (
+	"errors"
+	"fmt"
+	"github.com/daptin/daptin/server/auth"
+	"strings"
+	"unicode"
+)
+
+// ParseQueryParam reads the value of a "query" parameter. The value is either a
+// json array of queries which all have to match, a single json query tree, or
+// an expression in the text syntax
+//
+//	status = 'open' and (priority >= 3 or not author.email ~ 'example.com')
+//
+// The result is a list of queries which all have to match.
+func ParseQueryParam(value string) ([]Query, error) {
+
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return []Query{}, nil
+	}
+
+	switch value[0] {
+	case '[':
+		queries := make([]Query, 0)
+		err := json.Unmarshal([]byte(value), &queries)
+		return queries, err
+	case '{':
+		var query Query
+		err := json.Unmarshal([]byte(value), &query)
+		if err != nil {
+			return nil, err
+		}
+		return []Query{query}, nil
+	}
+
+	query, err := ParseQueryExpression(value)
+	if err != nil {
+		return nil, err
+	}
+	return []Query{query}, nil
+}
+
+// AndQueryParams combines two "query" parameters into one which matches rows
+// matched by both
+func AndQueryParams(first string, second string) (string, error) {
+
+	firstQueries, err := ParseQueryParam(first)
+	if err != nil {
+		return "", err
+	}
+	secondQueries, err := ParseQueryParam(second)
+	if err != nil {
+		return "", err
+	}
+
+	combined, err := json.Marshal(append(firstQueries, secondQueries...))
+	return string(combined), err
+}
+
+// queryOperators maps the operators of the text syntax and their aliases to the
+// operator names used in Query
+var queryOperators = map[string]string{
+	"=":               "is",
+	"eq":              "is",
+	"is":              "is",
+	"!=":              "is not",
+	"neq":             "is not",
+	"is not":          "is not",
+	"<":               "less then",
+	"lt":              "less then",
+	"before":          "less then",
+	"less then":       "less then",
+	"<=":              "at most",
+	"lte":             "at most",
+	"at most":         "at most",
+	">":               "more then",
+	"gt":              "more then",
+	"after":           "more then",
+	"more then":       "more then",
+	">=":              "at least",
+	"gte":             "at least",
+	"at least":        "at least",
+	"~":               "contains",
+	"contains":        "contains",
+	"!~":              "not contains",
+	"not contains":    "not contains",
+	"in":              "any of",
+	"any of":          "any of",
+	"not in":          "none of",
+	"none of":         "none of",
+	"is empty":        "is empty",
+	"is not empty":    "is not empty",
+	"within":          "within distance",
+	"within distance": "within distance",
+	"near":            "within distance",
+	"within box":      "within box",
+	"bbox":            "within box",
+	"nearest":         "nearest",
+}
+
+// queryCondition builds the sql condition of the query on the table, which is
+// referred to by its alias. Relation paths like author.email are turned into
+// sub queries on the related rows the user can read.
+func (dr *DbResource) queryCondition(query Query, tableName string, alias string, depth int, sessionUser *auth.SessionUser) (string, []interface{}, error) {
+
+	parts := make([]string, 0)
+	args := make([]interface{}, 0)
+
+	if query.ColumnName != "" {
+		condition, conditionArgs, err := dr.comparisonCondition(query, tableName, alias, depth, sessionUser)
+		if err != nil {
+			return "", nil, err
+		}
+		parts = append(parts, condition)
+		args = append(args, conditionArgs...)
+	}
+
+	for _, group := range []struct {
+		queries   []Query
+		separator string
+	}{{query.And, " and "}, {query.Or, " or "}} {
+
+		if len(group.queries) == 0 {
+			continue
+		}
+
+		conditions := make([]string, 0)
+		for _, subQuery := range group.queries {
+			condition, conditionArgs, err := dr.queryCondition(subQuery, tableName, alias, depth, sessionUser)
+			if err != nil {
+				return "", nil, err
+			}
+			conditions = append(conditions, condition)
+			args = append(args, conditionArgs...)
+		}
+		parts = append(parts, "("+strings.Join(conditions, group.separator)+")")
+	}
+
+	if query.Not != nil {
+		condition, conditionArgs, err := dr.queryCondition(*query.Not, tableName, alias, depth, sessionUser)
+		if err != nil {
+			return "", nil, err
+		}
+		parts = append(parts, "not ("+condition+")")
+		args = append(args, conditionArgs...)
+	}
+
+	if len(parts) == 0 {
+		return "", nil, errors.New("empty query")
+	}
+
+	return "(" + strings.Join(parts, " and ") + ")", args, nil
+}
+
+func (dr *DbResource) comparisonCondition(query Query, tableName string, alias string, depth int, sessionUser *auth.SessionUser) (string, []interface{}, error) {
+
+	column := alias + "." + query.ColumnName
+	isJsonPath := false
+	if strings.Contains(query.ColumnName, ".") {
+		var err error
+		column, isJsonPath, err = dr.jsonPathColumn(tableName, alias, query.ColumnName)
+		if err != nil {
+			return "", nil, err
+		}
+		if !isJsonPath {
+			return dr.relationCondition(query, tableName, alias, depth, sessionUser)
+		}
+	} else if expression, ok := dr.computedColumnSql(tableName, query.ColumnName); ok {
+		// the expression refers to the columns of the table without an alias
+		if alias != tableName {
+			return "", nil, fmt.Errorf("cannot query on computed column [%v] of related [%v]", query.ColumnName, tableName)
+		}
+		column = "(" + expression + ")"
+	} else if !dr.isQueryableColumn(tableName, query.ColumnName) {
+		return "", nil, fmt.Errorf("cannot query on column [%v] of [%v]", query.ColumnName, tableName)
+	}
+
+	operator, ok := queryOperators[strings.ToLower(strings.TrimSpace(query.Operator))]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown query operator [%v]", query.Operator)
+	}
+
+	switch operator {
+	case "within distance", "within box", "nearest":
+		condition, err := dr.geoCondition(query, operator, tableName, alias)
+		return condition, nil, err
+	}
+
+	if isJsonPath {
+		switch operator {
+		case "less then", "at most", "more then", "at least":
+			query.Value = jsonPathValue(query.Value)
+			// values extracted from json are text on postgres and sqlite
+			if _, isNumber := query.Value.(float64); isNumber {
+				switch dr.driverName() {
+				case "postgres":
+					column = "(" + column + ")::numeric"
+				case "mysql":
+				default:
+					column = "cast(" + column + " as real)"
+				}
+			}
+		case "is", "is not":
+			if dr.driverName() == "mysql" {
+				query.Value = jsonPathValue(query.Value)
+			}
+		}
+	}
+
+	switch operator {
+	case "is":
+		if query.Value == nil {
+			return column + " is null", nil, nil
+		}
+		return column + " = ?", []interface{}{query.Value}, nil
+	case "is not":
+		if query.Value == nil {
+			return column + " is not null", nil, nil
+		}
+		return column + " != ?", []interface{}{query.Value}, nil
+	case "less then":
+		return column + " < ?", []interface{}{query.Value}, nil
+	case "at most":
+		return column + " <= ?", []interface{}{query.Value}, nil
+	case "more then":
+		return column + " > ?", []interface{}{query.Value}, nil
+	case "at least":
+		return column + " >= ?", []interface{}{query.Value}, nil
+	case "contains":
+		return column + " like ?", []interface{}{"%" + fmt.Sprintf("%v", query.Value) + "%"}, nil
+	case "not contains":
+		return column + " not like ?", []interface{}{"%" + fmt.Sprintf("%v", query.Value) + "%"}, nil
+	case "any of", "none of":
+		values := queryValueList(query.Value)
+		if len(values) == 0 {
+			if operator == "any of" {
+				return "1 = 0", nil, nil
+			}
+			return "1 = 1", nil, nil
+		}
+		questions := strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")
+		if operator == "any of" {
+			return fmt.Sprintf("%s in (%s)", column, questions), values, nil
+		}
+		return fmt.Sprintf("%s not in (%s)", column, questions), values, nil
+	case "is empty":
+		return fmt.Sprintf("(%s is null or %s = '')", column, column), nil, nil
+	case "is not empty":
+		return fmt.Sprintf("(%s is not null and %s != '')", column, column), nil, nil
+	}
+
+	return "", nil, fmt.Errorf("unknown query operator [%v]", query.Operator)
+}
+
+// relationCondition matches rows whose related rows match the rest of the path
+func (dr *DbResource) relationCondition(query Query, tableName string, alias string, depth int, sessionUser *auth.SessionUser) (string, []interface{}, error) {
+
+	pathParts := strings.SplitN(query.ColumnName, ".", 2)
+	relationName := pathParts[0]
+
+	relatedQuery := query
+	relatedQuery.ColumnName = pathParts[1]
+	relatedAlias := fmt.Sprintf("q%d", depth+1)
+
+	crud, ok := dr.Cruds[tableName]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown table [%v]", tableName)
+	}
+
+	for _, relation := range crud.model.GetRelations() {
+
+		relatedTable := ""
+		var subQuery string
+		if relation.GetSubject() == tableName && relation.GetObjectName() == relationName {
+			relatedTable = relation.GetObject()
+			switch relation.GetRelation() {
+			case "belongs_to", "has_one":
+				subQuery = fmt.Sprintf("%s.%s in (select %s.id from %s %s where %%s)",
+					alias, relation.GetObjectName(), relatedAlias, relatedTable, relatedAlias)
+			default:
+				subQuery = fmt.Sprintf("%s.id in (select j.%s from %s j join %s %s on j.%s = %s.id where %%s)",
+					alias, relation.GetSubjectName(), relation.GetJoinTableName(), relatedTable, relatedAlias, relation.GetObjectName(), relatedAlias)
+			}
+		} else if relation.GetObject() == tableName && relation.GetSubjectName() == relationName {
+			relatedTable = relation.GetSubject()
+			switch relation.GetRelation() {
+			case "belongs_to", "has_one":
+				subQuery = fmt.Sprintf("%s.id in (select %s.%s from %s %s where %%s)",
+					alias, relatedAlias, relation.GetObjectName(), relatedTable, relatedAlias)
+			default:
+				subQuery = fmt.Sprintf("%s.id in (select j.%s from %s j join %s %s on j.%s = %s.id where %%s)",
+					alias, relation.GetObjectName(), relation.GetJoinTableName(), relatedTable, relatedAlias, relation.GetSubjectName(), relatedAlias)
+			}
+		} else {
+			continue
+		}
+
+		condition, args, err := dr.queryCondition(relatedQuery, relatedTable, relatedAlias, depth+1, sessionUser)
+		if err != nil {
+			return "", nil, err
+		}
+		permissionCondition, permissionArgs, err := dr.readableRowsCondition(relatedTable, relatedAlias, sessionUser)
+		if err != nil {
+			return "", nil, err
+		}
+		if permissionCondition != "" {
+			condition = condition + " and " + permissionCondition
+			args = append(args, permissionArgs...)
+		}
+		return fmt.Sprintf(subQuery, condition), args, nil
+	}
+
+	return "", nil, fmt.Errorf("no relation [%v] on [%v]", relationName, tableName)
+}
+
+// readableRowsCondition limits the rows of a related table to the ones the user
+// can read, with the checks of the list query of the table. A table the user
+// cannot read cannot be queried on.
+func (dr *DbResource) readableRowsCondition(tableName string, alias string, sessionUser *auth.SessionUser) (string, []interface{}, error) {
+
+	adminId := dr.GetAdminReferenceId()
+	if adminId != "" && adminId == sessionUser.UserReferenceId {
+		return "", nil, nil
+	}
+
+	tablePermission := dr.GetObjectPermissionByWhereClause("world", "table_name", tableName)
+	if !tablePermission.CanPeek(sessionUser.UserReferenceId, sessionUser.Groups) {
+		return "", nil, fmt.Errorf("cannot query on [%v]", tableName)
+	}
+	if tableName == "usergroup" {
+		return "", nil, nil
+	}
+
+	crud := dr.Cruds[tableName]
+	conditions := []string{fmt.Sprintf("((%s.permission & %d) = %d)", alias, auth.GuestRead, auth.GuestRead)}
+	args := make([]interface{}, 0)
+	if crud.model.HasMany("usergroup") {
+		joinTableName := fmt.Sprintf("%s_%s_id_has_usergroup_usergroup_id", tableName, tableName)
+		conditions = append(conditions, fmt.Sprintf("%s.id in (select g.%s_id from %s g where (g.permission & %d) = %d)",
+			alias, tableName, joinTableName, auth.GroupRead, auth.GroupRead))
+	}
+	if crud.model.HasColumn(USER_ACCOUNT_ID_COLUMN) {
+		conditions = append(conditions, fmt.Sprintf("(%s.%s = ? and (%s.permission & %d) = %d)",
+			alias, USER_ACCOUNT_ID_COLUMN, alias, auth.UserRead, auth.UserRead))
+		args = append(args, sessionUser.UserId)
+	}
+	return "(" + strings.Join(conditions, " or ") + ")", args, nil
+}
+
+// isQueryableColumn checks that the column exists and is visible over the api,
+// column names are used in the query as they are
+func (dr *DbResource) isQueryableColumn(tableName string, columnName string) bool {
+
+	crud, ok := dr.Cruds[tableName]
+	if !ok {
+		return false
+	}
+
+	for _, col := range crud.model.GetColumns() {
+		if col.ColumnName == columnName {
+			return !col.ExcludeFromApi && col.ColumnType != "password" && col.ColumnType != "encrypted"
+		}
+	}
+	return false
+}
+
+func queryValueList(value interface{}) []interface{} {
+
+	switch v := value.(type) {
+	case nil:
+		return []interface{}{}
+	case []interface{}:
+		return v
+	case []string:
+		values := make([]interface{}, len(v))
+		for i, s := range v {
+			values[i] = s
+		}
+		return values
+	}
+
+	parts := strings.Split(fmt.Sprintf("%v", value), ",")
+	values := make([]interface{}, 0)
+	for _, part := range parts {
+		values = append(values, strings.TrimSpace(part))
+	}
+	return values
+}
+
+// ParseQueryExpression parses the text syntax of queries. Comparisons look like
+// `column operator value` and are combined with and, or, not and parenthesis.
+// Columns of related rows are written as relation.column. Values are quoted
+// strings, plain words and numbers, or null.
+func ParseQueryExpression(expression string) (Query, error) {
+
+	tokens, err := tokenizeQueryExpression(expression)
+	if err != nil {
+		return Query{}, err
+	}
+
+	parser := &queryExpressionParser{tokens: tokens}
+	query, err := parser.parseOr()
+	if err != nil {
+		return Query{}, err
+	}
+	if parser.position < len(parser.tokens) {
+		return Query{}, fmt.Errorf("unexpected [%v] in query", parser.tokens[parser.position].text)
+	}
+	return query, nil
+}
+
+type queryToken struct {
+	text   string
+	quoted bool
+}
+
+func tokenizeQueryExpression(expression string) ([]queryToken, error) {
+
+	tokens := make([]queryToken, 0)
+	runes := []rune(expression)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')' || r == ',':
+			tokens = append(tokens, queryToken{text: string(r)})
+			i++
+		case r == '\'' || r == '"':
+			end := i + 1
+			var value strings.Builder
+			for ; end < len(runes) && runes[end] != r; end++ {
+				if runes[end] == '\\' && end+1 < len(runes) {
+					end++
+				}
+				value.WriteRune(runes[end])
+			}
+			if end >= len(runes) {
+				return nil, errors.New("unterminated string in query")
+			}
+			tokens = append(tokens, queryToken{text: value.String(), quoted: true})
+			i = end + 1
+		case strings.ContainsRune("=!<>~", r):
+			end := i + 1
+			for end < len(runes) && strings.ContainsRune("=<>~", runes[end]) {
+				end++
+			}
+			tokens = append(tokens, queryToken{text: string(runes[i:end])})
+			i = end
+		default:
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && !strings.ContainsRune("()',\"=!<>~", runes[end]) {
+				end++
+			}
+			tokens = append(tokens, queryToken{text: string(runes[i:end])})
+			i = end
+		}
+	}
+
+	return tokens, nil
+}
+
+type queryExpressionParser struct {
+	tokens   []queryToken
+	position int
+}
+
+// keyword consumes the next token if it is the unquoted keyword
+func (p *queryExpressionParser) keyword(word string) bool {
+	if p.position < len(p.tokens) && !p.tokens[p.position].quoted && strings.EqualFold(p.tokens[p.position].text, word) {
+		p.position++
+		return true
+	}
+	return false
+}
+
+func (p *queryExpressionParser) next() (queryToken, error) {
+	if p.position >= len(p.tokens) {
+		return queryToken{}, errors.New("unexpected end of query")
+	}
+	token := p.tokens[p.position]
+	p.position++
+	return token, nil
+}
+
+func (p *queryExpressionParser) parseOr() (Query, error) {
+
+	first, err := p.parseAnd()
+	if err != nil {
+		return Query{}, err
+	}
+
+	queries := []Query{first}
+	for p.keyword("or") {
+		next, err := p.parseAnd()
+		if err != nil {
+			return Query{}, err
+		}
+		queries = append(queries, next)
+	}
+
+	if len(queries) == 1 {
+		return first, nil
+	}
+	return Query{Or: queries}, nil
+}
+
+func (p *queryExpressionParser) parseAnd() (Query, error) {
+
+	first, err := p.parseUnary()
+	if err != nil {
+		return Query{}, err
+	}
+
+	queries := []Query{first}
+	for p.keyword("and") {
+		next, err := p.parseUnary()
+		if err != nil {
+			return Query{}, err
+		}
+		queries = append(queries, next)
+	}
+
+	if len(queries) == 1 {
+		return first, nil
+	}
+	return Query{And: queries}, nil
+}
+
+func (p *queryExpressionParser) parseUnary() (Query, error) {
+
+	if p.keyword("not") {
+		query, err := p.parseUnary()
+		if err != nil {
+			return Query{}, err
+		}
+		return Query{Not: &query}, nil
+	}
+
+	if p.keyword("(") {
+		query, err := p.parseOr()
+		if err != nil {
+			return Query{}, err
+		}
+		if !p.keyword(")") {
+			return Query{}, errors.New("missing ) in query")
+		}
+		return query, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *queryExpressionParser) parseComparison() (Query, error) {
+
+	column, err := p.next()
+	if err != nil {
+		return Query{}, err
+	}
+	if column.quoted {
+		return Query{}, fmt.Errorf("expected a column name, found '%v'", column.text)
+	}
+
+	query := Query{ColumnName: column.text}
+
+	switch {
+	case p.keyword("is"):
+		negate := p.keyword("not")
+		switch {
+		case p.keyword("empty"):
+			query.Operator = "is empty"
+		case p.keyword("null"):
+			query.Operator = "is"
+		default:
+			return Query{}, fmt.Errorf("expected empty or null after [%v is]", column.text)
+		}
+		if negate {
+			query.Operator = map[string]string{"is empty": "is not empty", "is": "is not"}[query.Operator]
+		}
+		return query, nil
+	case p.keyword("in"):
+		query.Operator = "any of"
+	case p.keyword("not"):
+		if !p.keyword("in") {
+			return Query{}, fmt.Errorf("expected in after [%v not]", column.text)
+		}
+		query.Operator = "none of"
+	}
+
+	if query.Operator != "" {
+		if !p.keyword("(") {
+			return Query{}, errors.New("expected ( after in")
+		}
+		values := make([]interface{}, 0)
+		for !p.keyword(")") {
+			value, err := p.next()
+			if err != nil {
+				return Query{}, err
+			}
+			if value.text == "," && !value.quoted {
+				continue
+			}
+			values = append(values, queryTokenValue(value))
+		}
+		query.Value = values
+		return query, nil
+	}
+
+	operator, err := p.next()
+	if err != nil {
+		return Query{}, err
+	}
+	operatorName, ok := queryOperators[operator.text]
+	if operator.quoted || !ok {
+		return Query{}, fmt.Errorf("unknown operator [%v] in query", operator.text)
+	}
+	query.Operator = operatorName
+
+	value, err := p.next()
+	if err != nil {
+		return Query{}, err
+	}
+	query.Value = queryTokenValue(value)
+
+	return query, nil
+}
+
+func queryTokenValue(token queryToken) interface{} {
+	if !token.quoted && strings.EqualFold(token.text, "null") {
+		return nil
+	}
+	return token.text
+}
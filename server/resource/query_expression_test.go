@@ -0,0 +1,130 @@
+package resource
+
+import (
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"testing"
+)
+
+func TestParseQueryExpression(t *testing.T) {
+
+	query, err := ParseQueryExpression("status = 'open' and (priority >= 3 or not author.email ~ \"example.com\") and tag in (a, 'b c') and closed_at is not null")
+	if err != nil {
+		t.Fatalf("Failed to parse query: %v", err)
+	}
+
+	if len(query.And) != 4 {
+		t.Fatalf("Expected 4 anded queries: %v", query)
+	}
+
+	status := query.And[0]
+	if status.ColumnName != "status" || status.Operator != "is" || status.Value != "open" {
+		t.Errorf("Unexpected status query: %v", status)
+	}
+
+	or := query.And[1].Or
+	if len(or) != 2 || or[0].Operator != "at least" || or[1].Not == nil || or[1].Not.ColumnName != "author.email" || or[1].Not.Operator != "contains" {
+		t.Errorf("Unexpected or query: %v", query.And[1])
+	}
+
+	values, ok := query.And[2].Value.([]interface{})
+	if query.And[2].Operator != "any of" || !ok || len(values) != 2 || values[1] != "b c" {
+		t.Errorf("Unexpected in query: %v", query.And[2])
+	}
+
+	if query.And[3].Operator != "is not" || query.And[3].Value != nil {
+		t.Errorf("Unexpected null query: %v", query.And[3])
+	}
+
+	for _, invalid := range []string{"status =", "(status = 1", "status ?? 1", "'status' = 1", "status = 'open"} {
+		_, err = ParseQueryExpression(invalid)
+		if err == nil {
+			t.Errorf("Expected [%v] to fail", invalid)
+		}
+	}
+}
+
+func TestParseQueryParam(t *testing.T) {
+
+	queries, err := ParseQueryParam(`[{"column": "name", "operator": "contains", "value": "a"}, {"or": [{"column": "age", "operator": "<", "value": 3}, {"column": "age", "operator": "is", "value": null}]}]`)
+	if err != nil {
+		t.Fatalf("Failed to parse json query: %v", err)
+	}
+	if len(queries) != 2 || len(queries[1].Or) != 2 {
+		t.Errorf("Unexpected json query: %v", queries)
+	}
+
+	combined, err := AndQueryParams(`{"column": "name", "operator": "is", "value": "a"}`, "age > 3")
+	if err != nil {
+		t.Fatalf("Failed to combine queries: %v", err)
+	}
+	queries, err = ParseQueryParam(combined)
+	if err != nil || len(queries) != 2 || queries[1].Operator != "more then" {
+		t.Errorf("Unexpected combined query: %v %v", queries, err)
+	}
+}
+
+func TestRelationQueryPermission(t *testing.T) {
+
+	cruds, cleanup := newTestCruds(t)
+	defer cleanup()
+
+	// the administrator is the member of the second user group
+	user := insertTestUser(t, cruds, "user", "user@example.com")
+	admin := insertTestUser(t, cruds, "admin", "admin@example.com")
+	users := cruds[USER_ACCOUNT_TABLE_NAME]
+
+	insertTestRow(t, cruds, "world", map[string]interface{}{"table_name": "address_book", "world_schema_json": "{}"})
+	for i, name := range []string{"shared", "private"} {
+		bookId := insertTestRow(t, cruds, "address_book", map[string]interface{}{"name": name})
+		if name == "shared" {
+			if _, err := users.db.Exec("update address_book set user_account_id = ? where id = ?", user.UserId, bookId); err != nil {
+				t.Fatal(err)
+			}
+		}
+		insertTestRow(t, cruds, "contact_card", map[string]interface{}{
+			"resource_name":   name + ".vcf",
+			"uid":             i,
+			"content":         "BEGIN:VCARD",
+			"address_book_id": bookId,
+		})
+	}
+
+	cards := cruds["contact_card"]
+	count := func(expression string, sessionUser *auth.SessionUser) (int, error) {
+		queries, err := ParseQueryParam(expression)
+		if err != nil {
+			return 0, err
+		}
+		builder, err := cards.addFilters(statementbuilder.Squirrel.Select("count(*)").From("contact_card"), queries, "contact_card", sessionUser)
+		if err != nil {
+			return 0, err
+		}
+		query, args, err := builder.ToSql()
+		if err != nil {
+			return 0, err
+		}
+		var count int
+		return count, cards.db.QueryRowx(query, args...).Scan(&count)
+	}
+
+	for _, test := range []struct {
+		expression  string
+		sessionUser *auth.SessionUser
+		count       int
+	}{
+		{"address_book_id.name = 'shared'", user, 1},
+		{"address_book_id.name = 'private'", user, 0},
+		{"address_book_id.name = 'private'", admin, 1},
+	} {
+		matched, err := count(test.expression, test.sessionUser)
+		if err != nil || matched != test.count {
+			t.Errorf("Expected [%v] to match %d cards for [%v], got %d: %v", test.expression, test.count, test.sessionUser.UserReferenceId, matched, err)
+		}
+	}
+
+	// there is no permission for the user to read the user accounts
+	if _, err := count("user_account_id.email = 'admin@example.com'", user); err == nil {
+		t.Errorf("Expected a query on a table the user cannot read to fail")
+	}
+}
@@ -0,0 +1,168 @@
+package resource
+
+import (
+	"fmt"
+	"testing"
+)
+
+// membershipFixture has count users which are each a member of a user group of
+// their own
+func membershipFixture(tb testing.TB, count int) (map[string]*DbResource, func()) {
+
+	cruds, cleanup := newTestCruds(tb)
+	for i := 0; i < count; i++ {
+		insertTestUser(tb, cruds, fmt.Sprintf("user %d", i), fmt.Sprintf("user%d@example.com", i))
+	}
+	return cruds, cleanup
+}
+
+// listMemberships lists the user group memberships with the users and the user groups included
+func listMemberships(tb testing.TB, cruds map[string]*DbResource) ([]map[string]interface{}, [][]map[string]interface{}) {
+
+	membership := cruds["user_account_user_account_id_has_usergroup_usergroup_id"]
+	rows, err := membership.db.Queryx("select * from user_account_user_account_id_has_usergroup_usergroup_id")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer rows.Close()
+
+	results, includes, err := membership.ResultToArrayOfMap(rows, membership.model.GetColumnMap(), map[string]bool{"*": true})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return results, includes
+}
+
+// listMembershipsPerRow resolves the foreign keys of the memberships one row at
+// a time, the way they were resolved before they were loaded in batches
+func listMembershipsPerRow(tb testing.TB, cruds map[string]*DbResource) []map[string]interface{} {
+
+	membership := cruds["user_account_user_account_id_has_usergroup_usergroup_id"]
+	rows, err := membership.db.Queryx("select * from user_account_user_account_id_has_usergroup_usergroup_id")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	results, err := RowsToMap(rows, membership.model.GetName())
+	rows.Close()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	for _, row := range results {
+		for _, column := range []string{"user_account_id", "usergroup_id"} {
+			namespace := column[:len(column)-3]
+			id := row[column].(int64)
+			row[column], err = membership.GetIdToReferenceId(namespace, id)
+			if err != nil {
+				tb.Fatal(err)
+			}
+			if _, err = membership.GetIdToObject(namespace, id); err != nil {
+				tb.Fatal(err)
+			}
+		}
+	}
+	return results
+}
+
+func TestResultToArrayOfMapIncludes(t *testing.T) {
+
+	cruds, cleanup := membershipFixture(t, 3)
+	defer cleanup()
+	results, includes := listMemberships(t, cruds)
+
+	if len(results) != 3 || len(includes) != 3 {
+		t.Fatalf("Expected 3 rows with includes, found %d rows and %d includes", len(results), len(includes))
+	}
+
+	for i, row := range results {
+		if len(includes[i]) != 2 {
+			t.Errorf("Expected the user and the user group to be included, found %v", includes[i])
+			continue
+		}
+		for _, include := range includes[i] {
+			column := include["__type"].(string) + "_id"
+			if row[column] != include["reference_id"] {
+				t.Errorf("Expected %v to be the reference id %v, found %v", column, include["reference_id"], row[column])
+			}
+		}
+	}
+
+	// each row gets its own copy of an included object
+	includes[0][0]["name"] = "changed"
+	if includes[1][0]["name"] == "changed" {
+		t.Errorf("Expected included objects not to be shared between rows")
+	}
+}
+
+func TestGetRowPermissions(t *testing.T) {
+
+	cruds, cleanup := membershipFixture(t, 3)
+	defer cleanup()
+	users, _, err := cruds[USER_ACCOUNT_TABLE_NAME].GetRowsByWhereClause(USER_ACCOUNT_TABLE_NAME)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	permissions := cruds[USER_ACCOUNT_TABLE_NAME].GetRowPermissions(users)
+	for i, user := range users {
+		expected := cruds[USER_ACCOUNT_TABLE_NAME].GetRowPermission(user)
+		if permissions[i].UserId != expected.UserId || permissions[i].Permission != expected.Permission {
+			t.Errorf("Expected permission %v for %v, found %v", expected, user["reference_id"], permissions[i])
+		}
+		if len(permissions[i].UserGroupId) != 1 || len(expected.UserGroupId) != 1 {
+			t.Errorf("Expected user %v to be in one user group, found %v", user["reference_id"], permissions[i].UserGroupId)
+			continue
+		}
+		group, expectedGroup := permissions[i].UserGroupId[0], expected.UserGroupId[0]
+		if group.GroupReferenceId != expectedGroup.GroupReferenceId || group.RelationReferenceId != expectedGroup.RelationReferenceId ||
+			group.Permission != expectedGroup.Permission || group.ObjectReferenceId != user["reference_id"] {
+			t.Errorf("Expected user group %v for %v, found %v", expectedGroup, user["reference_id"], group)
+		}
+	}
+}
+
+// BenchmarkResultToArrayOfMap lists 100 user group memberships with the users
+// and the user groups included. PerRow makes two queries per foreign key of
+// each row, Batched makes one query per related table.
+func BenchmarkResultToArrayOfMap(b *testing.B) {
+
+	cruds, cleanup := membershipFixture(b, 100)
+	defer cleanup()
+
+	b.Run("PerRow", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			listMembershipsPerRow(b, cruds)
+		}
+	})
+
+	b.Run("Batched", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			listMemberships(b, cruds)
+		}
+	})
+}
+
+// BenchmarkGetRowPermissions checks the permissions of 100 users
+func BenchmarkGetRowPermissions(b *testing.B) {
+
+	cruds, cleanup := membershipFixture(b, 100)
+	defer cleanup()
+	users, _, err := cruds[USER_ACCOUNT_TABLE_NAME].GetRowsByWhereClause(USER_ACCOUNT_TABLE_NAME)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("PerRow", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, user := range users {
+				cruds[USER_ACCOUNT_TABLE_NAME].GetRowPermission(user)
+			}
+		}
+	})
+
+	b.Run("Batched", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			cruds[USER_ACCOUNT_TABLE_NAME].GetRowPermissions(users)
+		}
+	})
+}
@@ -24,20 +24,57 @@ const DEFAULT_LANGUAGE = "en"
 func NewFromDbResourceWithTransaction(resources *DbResource, tx *sqlx.Tx) *DbResource {
 
 	return &DbResource{
-		Cruds:            resources.Cruds,
-		configStore:      resources.configStore,
-		model:            resources.model,
-		db:               tx,
-		connection:       resources.connection,
-		ActionHandlerMap: resources.ActionHandlerMap,
-		contextCache:     resources.contextCache,
-		defaultGroups:    resources.defaultGroups,
-		ms:               resources.ms,
-		tableInfo:        resources.tableInfo,
+		Cruds:              resources.Cruds,
+		configStore:        resources.configStore,
+		model:              resources.model,
+		db:                 tx,
+		connection:         resources.connection,
+		ActionHandlerMap:   resources.ActionHandlerMap,
+		contextCache:       resources.contextCache,
+		defaultGroups:      resources.defaultGroups,
+		ms:                 resources.ms,
+		tableInfo:          resources.tableInfo,
+		AssetFolderCache:   resources.AssetFolderCache,
+		SubsiteFolderCache: resources.SubsiteFolderCache,
 	}
 
 }
 
+// NewTransactionCruds returns the resources of all the tables running their
+// queries in the transaction, including the queries they make through Cruds.
+// The transaction is committed with CommitTransactionCruds, so the changes
+// made in it are sent to the subscribers after the commit.
+func NewTransactionCruds(cruds map[string]*DbResource, tx *sqlx.Tx) map[string]*DbResource {
+
+	pendingEvents := &pendingEntityEvents{cruds: cruds}
+	transactionCruds := make(map[string]*DbResource)
+	for tableName, dbResource := range cruds {
+		transactionCruds[tableName] = NewFromDbResourceWithTransaction(dbResource, tx)
+		transactionCruds[tableName].pendingEvents = pendingEvents
+	}
+	for _, dbResource := range transactionCruds {
+		dbResource.Cruds = transactionCruds
+	}
+	return transactionCruds
+}
+
+// CommitTransactionCruds commits the transaction of the resources returned by
+// NewTransactionCruds, and sends the changes made in it to the subscribers
+func CommitTransactionCruds(transactionCruds map[string]*DbResource, tx *sqlx.Tx) error {
+
+	err := tx.Commit()
+	if err != nil {
+		return err
+	}
+	for _, dbResource := range transactionCruds {
+		if dbResource.pendingEvents != nil {
+			dbResource.pendingEvents.publish()
+		}
+		break
+	}
+	return nil
+}
+
 // Create a new object. Newly created object/struct must be in Responder.
 // Possible Responder status codes are:
 // - 201 Created: Resource was created and needs to be returned
@@ -58,6 +95,10 @@ func (dr *DbResource) CreateWithoutFilter(obj interface{}, req api2go.Request) (
 	isAdmin := adminId != "" && adminId == sessionUser.UserReferenceId
 
 	attrs := data.GetAllAsAttributes()
+	err := dr.SetLocationCoordinates(attrs)
+	if err != nil {
+		return nil, err
+	}
 
 	allColumns := dr.model.GetColumns()
 
@@ -547,6 +588,13 @@ func (dr *DbResource) Create(obj interface{}, req api2go.Request) (api2go.Respon
 	data := obj.(*api2go.Api2GoModel)
 	//log.Infof("Create object request: [%v] %v", dr.model.GetTableName(), data.Data)
 
+	if UpsertRequested(req) {
+		response, err := dr.updateUpsertMatch(data, req)
+		if response != nil || err != nil {
+			return response, err
+		}
+	}
+
 	for _, bf := range dr.ms.BeforeCreate {
 		//log.Infof("Invoke BeforeCreate [%v][%v] on Create Request", bf.String(), dr.model.GetName())
 		data.Data["__type"] = dr.model.GetName()
@@ -562,6 +610,13 @@ func (dr *DbResource) Create(obj interface{}, req api2go.Request) (api2go.Respon
 
 	createdResource, err := dr.CreateWithoutFilter(obj, req)
 	if err != nil {
+		if UpsertRequested(req) && isUniqueViolation(err) {
+			// the row was inserted by another request after the match above
+			response, upsertErr := dr.updateUpsertMatch(data, req)
+			if response != nil || upsertErr != nil {
+				return response, upsertErr
+			}
+		}
 		return NewResponse(nil, nil, 500, nil), err
 	}
 
@@ -578,6 +633,10 @@ func (dr *DbResource) Create(obj interface{}, req api2go.Request) (api2go.Respon
 		}
 	}
 
+	if version, err := parseVersion(createdResource["version"]); err == nil {
+		SetResponseHeader(req, "ETag", VersionETag(version))
+	}
+
 	n1 := dr.model.GetName()
 	c1 := dr.model.GetColumns()
 	p1 := dr.model.GetDefaultPermission()
@@ -50,12 +50,22 @@ type PaginationData struct {
 	PageNumber uint64
 	PageSize   uint64
 	TotalCount uint64
+	// After is the reference id of the last row the query returned, before
+	// the middlewares removed the rows the user cannot read. It is empty when
+	// the query returned no rows.
+	After string
 }
 
+// Query is a condition on the rows of a table. A query either compares a column
+// with a value, or combines other queries with And, Or and Not. Columns of
+// related rows are queried as relation.column
 type Query struct {
-	ColumnName string      `json:"column"`
-	Operator   string      `json:"operator"`
-	Value      interface{} `json:"value"`
+	ColumnName string      `json:"column,omitempty"`
+	Operator   string      `json:"operator,omitempty"`
+	Value      interface{} `json:"value,omitempty"`
+	And        []Query     `json:"and,omitempty"`
+	Or         []Query     `json:"or,omitempty"`
+	Not        *Query      `json:"not,omitempty"`
 }
 
 type Group struct {
@@ -65,6 +75,12 @@ type Group struct {
 
 // PaginatedFindAll(req Request) (totalCount uint, response Responder, err error)
 func (dr *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request) ([]map[string]interface{}, [][]map[string]interface{}, *PaginationData, error) {
+	return dr.paginatedFindAllWithoutFilters(req, true)
+}
+
+// paginatedFindAllWithoutFilters reads a page of rows, the rows matching the
+// query are counted when countRows is set
+func (dr *DbResource) paginatedFindAllWithoutFilters(req api2go.Request, countRows bool) ([]map[string]interface{}, [][]map[string]interface{}, *PaginationData, error) {
 	//log.Infof("Find all row by params: [%v]: %v", dr.model.GetName(), req.QueryParams)
 	var err error
 
@@ -116,10 +132,11 @@ func (dr *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request) ([]map[
 			//so we join it back to read it as json
 			query[0] = strings.Join(query, ",")
 		}
-		if len(query) > 0 && len(query[0]) > 0 && query[0][0] == '[' {
+		if len(query) > 0 && len(query[0]) > 0 {
 			//log.Printf("Found query in request: %s", query[0])
-			err = json.Unmarshal([]byte(query[0]), &queries)
-			if CheckInfo(err, "Failed to unmarshal query as json, using as a filter instead") {
+			queries, err = ParseQueryParam(query[0])
+			if CheckInfo(err, "Failed to parse query, using as a filter instead") {
+				queries = make([]Query, 0)
 				req.QueryParams["filter"] = query
 			}
 			//log.Printf("Query: %v", queries)
@@ -138,8 +155,19 @@ func (dr *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request) ([]map[
 		InfoErr(err, fmt.Sprintf("Failed to read groups from request: %v", query[0]))
 	}
 
+	// included_relations=author.organization includes the organization of the author
+	// include is the name of the parameter in json api
+	includePaths := ParseIncludePaths(append(req.QueryParams["included_relations"], req.QueryParams["include"]...))
+	fieldsets := RequestedFieldsets(req)
+
 	reqFieldMap := make(map[string]bool)
 	requestedFields, hasRequestedFields := req.QueryParams["fields"]
+	if fieldset, ok := fieldsets[dr.model.GetName()]; ok {
+		for name := range fieldset {
+			requestedFields = append(requestedFields, name)
+		}
+		hasRequestedFields = true
+	}
 	if hasRequestedFields {
 		for _, f := range requestedFields {
 
@@ -149,6 +177,13 @@ func (dr *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request) ([]map[
 			}
 		}
 		reqFieldMap[USER_ACCOUNT_ID_COLUMN] = true
+
+		// foreign keys of the included relations are needed to load them
+		for _, col := range dr.model.GetColumns() {
+			if _, included := includePaths.Relation(col); included && col.IsForeignKey {
+				reqFieldMap[col.Name] = true
+			}
+		}
 	}
 
 	pageSize := uint64(10)
@@ -159,18 +194,6 @@ func (dr *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request) ([]map[
 		}
 	}
 
-	includedRelations := make(map[string]bool, 0)
-	if len(req.QueryParams["included_relations"]) > 0 {
-		//included := req.QueryParams["included_relations"][0]
-		//includedRelationsList := strings.Split(included, ",")
-		for _, incl := range req.QueryParams["included_relations"] {
-			includedRelations[incl] = true
-		}
-
-	} else {
-		includedRelations = nil
-	}
-
 	if pageSize == 0 {
 		pageSize = 1
 	}
@@ -203,6 +226,16 @@ func (dr *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request) ([]map[
 		pageNumber = pageNumber * pageSize
 	}
 
+	// page[offset] skips a number of rows instead of a number of pages
+	if len(req.QueryParams["page[offset]"]) > 0 {
+		pageOffset, err := strconv.ParseUint(req.QueryParams["page[offset]"][0], 10, 32)
+		if err != nil {
+			log.Errorf("Invalid parameter value: %v", req.QueryParams["page[offset]"])
+		} else {
+			pageNumber = pageOffset
+		}
+	}
+
 	tableModel := dr.model
 	//log.Infof("Get all resource type: %v\n", tableModel)
 
@@ -211,7 +244,10 @@ func (dr *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request) ([]map[
 	//log.Infof("Cols: %v", cols)
 
 	prefix := dr.model.GetName() + "."
-	if hasRequestedFields {
+	computedCols := dr.computedColumnSelects(nil)
+	// javascript computed columns can use any column of the row
+	if hasRequestedFields && !dr.hasJsComputedColumn(reqFieldMap) {
+		computedCols = dr.computedColumnSelects(reqFieldMap)
 
 		for _, col := range cols {
 			if !col.ExcludeFromApi && reqFieldMap[col.Name] && col.ColumnName != "permission" && col.ColumnName != "reference_id" {
@@ -227,6 +263,18 @@ func (dr *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request) ([]map[
 		}
 	}
 
+	// near=latitude,longitude adds the distance in meters from that point to every row
+	hasDistance := false
+	if len(req.QueryParams["near"]) > 0 {
+		//api2go splits the values on comma
+		distanceColumn, err := dr.NearDistanceColumn(strings.Join(req.QueryParams["near"], ","))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		finalCols = append(finalCols, distanceColumn+" as distance")
+		hasDistance = true
+	}
+
 	if _, ok := req.QueryParams["usergroup_id"]; ok && req.QueryParams["usergroupName"][0] == dr.model.GetName()+"_id" {
 		isRelatedGroupRequest = true
 		if relatedTableName == "" {
@@ -259,30 +307,37 @@ func (dr *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request) ([]map[
 
 	queryBuilder := statementbuilder.Squirrel.Select(distinctIdColumn).From(tableModel.GetTableName())
 
+	// the count is over the same rows as the select, the group join can
+	// repeat a row
+	var countQueryBuilder squirrel.SelectBuilder
+	countQueryBuilder = statementbuilder.Squirrel.Select(fmt.Sprintf("count(distinct(%s.id))", tableModel.GetTableName())).From(tableModel.GetTableName()).Offset(0).Limit(1)
+
 	joinTableName := fmt.Sprintf("%s_%s_id_has_usergroup_usergroup_id", tableModel.GetTableName(), tableModel.GetTableName())
 	if !isRelatedGroupRequest && tableModel.GetTableName() != "usergroup" {
-		queryBuilder = queryBuilder.LeftJoin(
-			fmt.Sprintf("%s %s on %s.id=%s.%s_id",
-				joinTableName, joinTableName, tableModel.GetTableName(), joinTableName, tableModel.GetTableName(),
-			))
-	}
-
-	var countQueryBuilder squirrel.SelectBuilder
-	countQueryBuilder = statementbuilder.Squirrel.Select("count(*)").From(tableModel.GetTableName()).Offset(0).Limit(1)
+		groupJoin := fmt.Sprintf("%s %s on %s.id=%s.%s_id",
+			joinTableName, joinTableName, tableModel.GetTableName(), joinTableName, tableModel.GetTableName(),
+		)
+		queryBuilder = queryBuilder.LeftJoin(groupJoin)
+		countQueryBuilder = countQueryBuilder.LeftJoin(groupJoin)
+	}
+	// page[after] and page[before] page on the id of the rows, the pages do
+	// not shift when rows are added or removed meanwhile
 	if req.QueryParams["page[after]"] != nil && len(req.QueryParams["page[after]"]) > 0 {
 		id, err := dr.GetReferenceIdToId(dr.TableInfo().TableName, req.QueryParams["page[after]"][0])
 		if err != nil {
-			queryBuilder = queryBuilder.Where(squirrel.Gt{
-				dr.TableInfo().TableName + ".id": id,
-			}).Limit(pageSize)
+			return nil, nil, nil, api2go.NewHTTPError(err, "no such row in page[after]", 400)
 		}
+		queryBuilder = queryBuilder.Where(squirrel.Gt{
+			dr.TableInfo().TableName + ".id": id,
+		}).OrderBy(dr.TableInfo().TableName + ".id asc").Limit(pageSize)
 	} else if req.QueryParams["page[before]"] != nil && len(req.QueryParams["page[before]"]) > 0 {
 		id, err := dr.GetReferenceIdToId(dr.TableInfo().TableName, req.QueryParams["page[before]"][0])
 		if err != nil {
-			queryBuilder = queryBuilder.Where(squirrel.Lt{
-				dr.TableInfo().TableName + ".id": id,
-			}).Limit(pageSize)
+			return nil, nil, nil, api2go.NewHTTPError(err, "no such row in page[before]", 400)
 		}
+		queryBuilder = queryBuilder.Where(squirrel.Lt{
+			dr.TableInfo().TableName + ".id": id,
+		}).OrderBy(dr.TableInfo().TableName + ".id desc").Limit(pageSize)
 	} else {
 		queryBuilder = queryBuilder.Offset(pageNumber).Limit(pageSize)
 	}
@@ -291,8 +346,26 @@ func (dr *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request) ([]map[
 
 	infos := dr.model.GetColumns()
 
-	// todo: fix search in findall operation. currently no way to do an " or " query
-	if len(filters) > 0 {
+	searchColumns := make([]string, 0)
+	for _, column := range dr.tableInfo.GetSearchableColumns() {
+		searchColumns = append(searchColumns, column.ColumnName)
+	}
+
+	if len(filters) > 0 && len(searchColumns) > 0 && fullTextIndexKind(tableModel.GetTableName()) != "" {
+
+		// the table has a native full text index
+		for _, q := range filters {
+			terms := ParseSearchQuery(q)
+			if len(terms) == 0 {
+				continue
+			}
+			condition, args := dr.fullTextCondition(terms, searchColumns)
+			queryBuilder = queryBuilder.Where(condition, args...)
+			countQueryBuilder = countQueryBuilder.Where(condition, args...)
+		}
+
+	} else if len(filters) > 0 {
+		// todo: fix search in findall operation. currently no way to do an " or " query
 
 		colsToAdd := make([]string, 0)
 		wheres := make([]interface{}, 0)
@@ -322,7 +395,14 @@ func (dr *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request) ([]map[
 		}
 	}
 
-	queryBuilder = addFilters(queryBuilder, queries, prefix)
+	queryBuilder, err = dr.addFilters(queryBuilder, queries, tableModel.GetTableName(), sessionUser)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	countQueryBuilder, err = dr.addFilters(countQueryBuilder, queries, tableModel.GetTableName(), sessionUser)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 
 	//if len(groupings) > 0 && false {
 	//	for _, groupBy := range groupings {
@@ -464,18 +544,18 @@ func (dr *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request) ([]map[
 		}
 		//log.Infof("Sort order: %v", so)
 		if so[0] == '-' {
-			ord := prefix + so[1:] + " desc"
+			ord := dr.sortColumn(so[1:], hasDistance) + " desc"
 			// queryBuilder = queryBuilder.OrderBy(ord)
 			// countQueryBuilder = countQueryBuilder.OrderBy(ord)
 			orders = append(orders, ord)
 		} else {
 			if so[0] == '+' {
-				ord := prefix + so[1:] + " asc"
+				ord := dr.sortColumn(so[1:], hasDistance) + " asc"
 				// queryBuilder = queryBuilder.OrderBy(ord)
 				// countQueryBuilder = countQueryBuilder.OrderBy(ord)
 				orders = append(orders, ord)
 			} else {
-				ord := prefix + so + " asc"
+				ord := dr.sortColumn(so, hasDistance) + " asc"
 				// queryBuilder = queryBuilder.OrderBy(ord)
 				// countQueryBuilder = countQueryBuilder.OrderBy(ord)
 				orders = append(orders, ord)
@@ -488,8 +568,8 @@ func (dr *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request) ([]map[
 			"((%s.permission & 32768) = 32768) or "+
 			"(%s.user_account_id = ? and (%s.permission & 256) = 256))", tableModel.GetTableName(), joinTableName, tableModel.GetTableName(), tableModel.GetTableName()), sessionUser.UserId)
 		countQueryBuilder = countQueryBuilder.Where(fmt.Sprintf("(((%s.permission & 2) = 2) or "+
-			//"((%s.permission & 32768) = 32768) or "+
-			"(%s.user_account_id = ? and (%s.permission & 256) = 256))", tableModel.GetTableName(), tableModel.GetTableName(), tableModel.GetTableName()), sessionUser.UserId)
+			"((%s.permission & 32768) = 32768) or "+
+			"(%s.user_account_id = ? and (%s.permission & 256) = 256))", tableModel.GetTableName(), joinTableName, tableModel.GetTableName(), tableModel.GetTableName()), sessionUser.UserId)
 	}
 
 	idsListQuery, args, err := queryBuilder.ToSql()
@@ -529,7 +609,7 @@ func (dr *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request) ([]map[
 			}
 		}
 
-		queryBuilder = statementbuilder.Squirrel.Select(finalCols...).From(tableModel.GetTableName()).Where(squirrel.Eq{
+		queryBuilder = statementbuilder.Squirrel.Select(append(finalCols, computedCols...)...).From(tableModel.GetTableName()).Where(squirrel.Eq{
 			idColumn: ids,
 		}).OrderBy(orders...)
 	} else {
@@ -556,7 +636,7 @@ func (dr *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request) ([]map[
 			}
 		}
 
-		queryBuilder = statementbuilder.Squirrel.Select(finalCols...).From(tableModel.GetTableName()).
+		queryBuilder = statementbuilder.Squirrel.Select(append(finalCols, computedCols...)...).From(tableModel.GetTableName()).
 			LeftJoin(translateTableName +
 				" on " + translateTableName + ".translation_reference_id = " + tableModel.GetTableName() + ".id" +
 				" and " + translateTableName + ".language_id = " + "'" + preferredLanguage + "'").Where(squirrel.Eq{
@@ -603,12 +683,16 @@ func (dr *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request) ([]map[
 		CheckErr(err, "Failed to close rows")
 	}()
 
-	//log.Infof("Included relations: %v", includedRelations)
-	results, includes, err := dr.ResultToArrayOfMap(rows, dr.model.GetColumnMap(), includedRelations)
+	//log.Infof("Included relations: %v", includePaths)
+	results, includes, err := dr.ResultToArrayOfMapWithIncludes(rows, dr.model.GetColumnMap(), includePaths, fieldsets)
+	dr.computeJsColumns(results)
 	//log.Infof("Found: %d results", len(results))
 	//log.Infof("Results: %v", results)
 
-	total1 := dr.GetTotalCountBySelectBuilder(countQueryBuilder)
+	total1 := uint64(0)
+	if countRows {
+		total1 = dr.GetTotalCountBySelectBuilder(countQueryBuilder)
+	}
 
 	if pageSize < 1 {
 		pageSize = 10
@@ -619,62 +703,27 @@ func (dr *DbResource) PaginatedFindAllWithoutFilters(req api2go.Request) ([]map[
 		PageSize:   pageSize,
 		TotalCount: total1,
 	}
+	if len(results) > 0 {
+		paginationData.After, _ = results[len(results)-1]["reference_id"].(string)
+	}
 
 	return results, includes, paginationData, err
 
 }
 
-func addFilters(queryBuilder squirrel.SelectBuilder, queries []Query, prefix string) squirrel.SelectBuilder {
-
-	if len(queries) == 0 {
-		return queryBuilder
-	}
+// addFilters adds the queries to the select on the table, all the queries have
+// to match. Related rows are matched when the user can read them.
+func (dr *DbResource) addFilters(queryBuilder squirrel.SelectBuilder, queries []Query, tableName string, sessionUser *auth.SessionUser) (squirrel.SelectBuilder, error) {
 
 	for _, filterQuery := range queries {
-		switch filterQuery.Operator {
-		case "contains":
-			queryBuilder = queryBuilder.Where(fmt.Sprintf("%s like ?", prefix+filterQuery.ColumnName), "%"+fmt.Sprintf("%v", filterQuery.Value)+"%")
-		case "not contains":
-			queryBuilder = queryBuilder.Where(fmt.Sprintf("%s not like ?", prefix+filterQuery.ColumnName), "%"+fmt.Sprintf("%v", filterQuery.Value)+"%")
-		case "is":
-			queryBuilder = queryBuilder.Where(fmt.Sprintf("%s = ?", prefix+filterQuery.ColumnName), filterQuery.Value)
-		case "in":
-			//queryBuilder = queryBuilder.Where(fmt.Sprintf("%s in (?)", prefix+filterQuery.ColumnName), filterQuery.Value)
-			queryBuilder.Where(squirrel.Eq{prefix + filterQuery.ColumnName: filterQuery.Value.([]string)})
-		case "is not":
-			queryBuilder = queryBuilder.Where(fmt.Sprintf("%s != ?", prefix+filterQuery.ColumnName), filterQuery.Value)
-		case "before":
-			queryBuilder = queryBuilder.Where(fmt.Sprintf("%s < ?", prefix+filterQuery.ColumnName), filterQuery.Value)
-		case "after":
-			queryBuilder = queryBuilder.Where(fmt.Sprintf("%s > ?", prefix+filterQuery.ColumnName), filterQuery.Value)
-		case "more then":
-			queryBuilder = queryBuilder.Where(fmt.Sprintf("%s > ?", prefix+filterQuery.ColumnName), filterQuery.Value)
-		case "any of":
-			vals := strings.Split(fmt.Sprintf("%v", filterQuery.Value), ",")
-			valsInterface := make([]interface{}, len(vals))
-			for i, v := range vals {
-				valsInterface[i] = v
-			}
-			questions := strings.Join(strings.Split(strings.Repeat("?", len(vals)), ""), ", ")
-			queryBuilder = queryBuilder.Where(fmt.Sprintf("%s in (%s)", prefix+filterQuery.ColumnName, questions), valsInterface...)
-		case "none of":
-			vals := strings.Split(fmt.Sprintf("%v", filterQuery.Value), ",")
-			valsInterface := make([]interface{}, len(vals))
-			for i, v := range vals {
-				valsInterface[i] = v
-			}
-			questions := strings.Join(strings.Split(strings.Repeat("?", len(vals)), ""), ", ")
-			queryBuilder = queryBuilder.Where(fmt.Sprintf("%s not in (%s)", prefix+filterQuery.ColumnName, questions), valsInterface...)
-		case "less then":
-			queryBuilder = queryBuilder.Where(fmt.Sprintf("%s < ?", prefix+filterQuery.ColumnName), filterQuery.Value)
-		case "is empty":
-			queryBuilder = queryBuilder.Where(fmt.Sprintf("%s is null or %s = ''", prefix+filterQuery.ColumnName, prefix+filterQuery.ColumnName))
-		case "is not empty":
-			queryBuilder = queryBuilder.Where(fmt.Sprintf("%s is not null and %s != ''", prefix+filterQuery.ColumnName, prefix+filterQuery.ColumnName))
+		condition, args, err := dr.queryCondition(filterQuery, tableName, tableName, 0, sessionUser)
+		if err != nil {
+			return queryBuilder, err
 		}
+		queryBuilder = queryBuilder.Where(condition, args...)
 	}
 
-	return queryBuilder
+	return queryBuilder, nil
 }
 
 func (dr *DbResource) FindAll(req api2go.Request) (response api2go.Responder, err error) {
@@ -684,18 +733,35 @@ func (dr *DbResource) FindAll(req api2go.Request) (response api2go.Responder, er
 }
 
 func (dr *DbResource) PaginatedFindAll(req api2go.Request) (totalCount uint, response api2go.Responder, err error) {
+	pagination, response, err := dr.paginatedFindAll(req, true)
+	if pagination == nil {
+		return 0, response, err
+	}
+	return uint(pagination.TotalCount), response, err
+}
+
+// PaginatedFindAllAfter reads a page of rows like PaginatedFindAll, with the
+// reference id of the last row the query returned. Pages of page[after] read
+// after that reference id do not skip the rows following the ones the user
+// cannot read. The rows are counted only when countRows is set, a count is
+// not needed for every page of a table read a page at a time.
+func (dr *DbResource) PaginatedFindAllAfter(req api2go.Request, countRows bool) (*PaginationData, api2go.Responder, error) {
+	return dr.paginatedFindAll(req, countRows)
+}
+
+func (dr *DbResource) paginatedFindAll(req api2go.Request, countRows bool) (*PaginationData, api2go.Responder, error) {
 
 	for _, bf := range dr.ms.BeforeFindAll {
 		//log.Infof("Invoke BeforeFindAll [%v][%v] on FindAll Request", bf.String(), dr.model.GetName())
 		_, err := bf.InterceptBefore(dr, &req, []map[string]interface{}{})
 		if err != nil {
 			log.Infof("Error from BeforeFindAll middleware [%v]: %v", bf.String(), err)
-			return 0, NewResponse(nil, err, 400, nil), err
+			return nil, NewResponse(nil, err, 400, nil), err
 		}
 	}
 	//log.Infof("Request [%v]: %v", dr.model.GetName(), req.QueryParams)
 
-	results, includes, pagination, err := dr.PaginatedFindAllWithoutFilters(req)
+	results, includes, pagination, err := dr.paginatedFindAllWithoutFilters(req, countRows)
 
 	for _, bf := range dr.ms.AfterFindAll {
 		//log.Infof("Invoke AfterFindAll [%v][%v] on FindAll Request", bf.String(), dr.model.GetName())
@@ -707,18 +773,19 @@ func (dr *DbResource) PaginatedFindAll(req api2go.Request) (totalCount uint, res
 		}
 	}
 
+	// the includes of each row go through all the middlewares, objects included
+	// through an object the user cannot read are removed too
 	includesNew := make([][]map[string]interface{}, 0)
-	for _, bf := range dr.ms.AfterFindAll {
-		//log.Infof("Invoke AfterFindAll Includes [%v][%v] on FindAll Request", bf.String(), dr.model.GetName())
+	for _, include := range includes {
+		for _, bf := range dr.ms.AfterFindAll {
+			//log.Infof("Invoke AfterFindAll Includes [%v][%v] on FindAll Request", bf.String(), dr.model.GetName())
 
-		for _, include := range includes {
 			include, err = bf.InterceptAfter(dr, &req, include)
 			if err != nil {
 				log.Errorf("Error from AfterFindAll[includes][%v] middleware: %v", bf.String(), err)
 			}
-			includesNew = append(includesNew, include)
 		}
-
+		includesNew = append(includesNew, PruneUnreachableIncludes(include))
 	}
 
 	result := make([]*api2go.Api2GoModel, 0)
@@ -757,7 +824,7 @@ func (dr *DbResource) PaginatedFindAll(req api2go.Request) (totalCount uint, res
 	}
 	//log.Infof("Pagination :%v", pagination)
 
-	return uint(pagination.TotalCount), NewResponse(nil, result, 200, &api2go.Pagination{
+	return pagination, NewResponse(nil, result, 200, &api2go.Pagination{
 		Next:        map[string]string{"limit": fmt.Sprintf("%v", pagination.PageSize), "offset": fmt.Sprintf("%v", pagination.PageSize+pagination.PageNumber)},
 		Prev:        map[string]string{"limit": fmt.Sprintf("%v", pagination.PageSize), "offset": fmt.Sprintf("%v", pagination.PageNumber-pagination.PageSize)},
 		First:       map[string]string{},
@@ -771,3 +838,24 @@ func (dr *DbResource) PaginatedFindAll(req api2go.Request) (totalCount uint, res
 	}), nil
 
 }
+
+// sortColumn returns the column to order by, json paths like meta.address.city
+// are sorted by the extracted value
+func (dr *DbResource) sortColumn(name string, hasDistance bool) string {
+
+	if name == "distance" && hasDistance {
+		return name
+	}
+
+	tableName := dr.model.GetName()
+	if expression, ok := dr.computedColumnSql(tableName, name); ok {
+		return "(" + expression + ")"
+	}
+	if strings.Contains(name, ".") {
+		column, isJsonPath, err := dr.jsonPathColumn(tableName, tableName, name)
+		if isJsonPath && err == nil {
+			return column
+		}
+	}
+	return tableName + "." + name
+}
@@ -0,0 +1,77 @@
+package resource
+
+import (
+	"github.com/artpar/api2go"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPaginatedFindAllPageOffset(t *testing.T) {
+
+	cruds, cleanup := newTestCruds(t)
+	defer cleanup()
+	for _, name := range []string{"ann", "bob", "cat", "dan", "eve"} {
+		insertTestUser(t, cruds, name, name+"@example.com")
+	}
+	users := cruds[USER_ACCOUNT_TABLE_NAME]
+
+	results, _, pagination, err := users.PaginatedFindAllWithoutFilters(api2go.Request{
+		PlainRequest: httptest.NewRequest("GET", "/api/user_account", nil),
+		QueryParams: map[string][]string{
+			"sort":         {"name"},
+			"page[offset]": {"3"},
+			"page[size]":   {"2"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 || results[0]["name"] != "dan" || results[1]["name"] != "eve" {
+		t.Errorf("Expected the users after the first 3, found %v", results)
+	}
+	if pagination.TotalCount != 5 {
+		t.Errorf("Expected a total count of 5, found %d", pagination.TotalCount)
+	}
+}
+
+func TestPaginatedFindAllAfter(t *testing.T) {
+
+	cruds, cleanup := newTestCruds(t)
+	defer cleanup()
+	userReferenceIds := make([]string, 0)
+	for _, name := range []string{"ann", "bob", "cat", "dan", "eve"} {
+		userReferenceIds = append(userReferenceIds, insertTestUser(t, cruds, name, name+"@example.com").UserReferenceId)
+	}
+	users := cruds[USER_ACCOUNT_TABLE_NAME]
+
+	page := func(after string, countRows bool) *PaginationData {
+		queryParams := map[string][]string{
+			"sort":       {"id"},
+			"page[size]": {"2"},
+		}
+		if after != "" {
+			queryParams["page[after]"] = []string{after}
+		}
+		pagination, _, err := users.PaginatedFindAllAfter(api2go.Request{
+			PlainRequest: httptest.NewRequest("GET", "/api/user_account", nil),
+			QueryParams:  queryParams,
+		}, countRows)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pagination
+	}
+
+	pagination := page("", true)
+	if pagination.After != userReferenceIds[1] || pagination.TotalCount != 5 {
+		t.Errorf("Expected the first page to end at bob of 5 users, found %v", pagination)
+	}
+	pagination = page(pagination.After, false)
+	if pagination.After != userReferenceIds[3] || pagination.TotalCount != 0 {
+		t.Errorf("Expected the uncounted second page to end at dan, found %v", pagination)
+	}
+	if pagination = page(page(pagination.After, false).After, false); pagination.After != "" {
+		t.Errorf("Expected no rows after the last page, found %v", pagination)
+	}
+}
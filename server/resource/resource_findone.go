@@ -7,13 +7,14 @@ import (
 	"github.com/pkg/errors"
 	//"strings"
 	log "github.com/sirupsen/logrus"
+	"net/http"
 )
 
 // FindOne returns an object by its ID
 // Possible Responder success status code 200
 func (dr *DbResource) FindOne(referenceId string, req api2go.Request) (api2go.Responder, error) {
 
-	if referenceId == "mine" && dr.tableInfo.TableName == "user_account" {
+	if referenceId == "mine" && dr.tableInfo.TableName == "user_account" && req.PlainRequest != nil {
 		log.Printf("Request for mine")
 		sessionUser := req.PlainRequest.Context().Value("user")
 		if sessionUser != nil {
@@ -48,15 +49,32 @@ func (dr *DbResource) FindOne(referenceId string, req api2go.Request) (api2go.Re
 	//}
 
 	languagePreferences := make([]string, 0)
-	prefs := req.PlainRequest.Context().Value("language_preference")
-	if prefs != nil {
-		languagePreferences = prefs.([]string)
+	if req.PlainRequest != nil {
+		prefs := req.PlainRequest.Context().Value("language_preference")
+		if prefs != nil {
+			languagePreferences = prefs.([]string)
+		}
 	}
 	if languagePreferences != nil && len(languagePreferences) > 0 {
 		//log.Printf("Language preference: %v", languagePreferences)
 	}
 
-	data, include, err := dr.GetSingleRowByReferenceId(modelName, referenceId)
+	// all the relations are included unless the request names them
+	includePaths := ParseIncludePaths(append(req.QueryParams["included_relations"], req.QueryParams["include"]...))
+	if includePaths == nil {
+		includePaths = IncludePaths{"*": {}}
+	}
+	// the version is loaded for the etag, also when the fieldset does not have it
+	fieldsets := RequestedFieldsets(req)
+	fields, hasFieldset := fieldsets[modelName]
+	if hasFieldset && !fields["version"] {
+		fieldsets[modelName] = make(map[string]bool, len(fields)+1)
+		for name := range fields {
+			fieldsets[modelName][name] = true
+		}
+		fieldsets[modelName]["version"] = true
+	}
+	data, include, err := dr.GetSingleRowByReferenceIdWithIncludes(modelName, referenceId, includePaths, fieldsets)
 
 	if len(languagePreferences) > 0 {
 		for _, lang := range languagePreferences {
@@ -72,7 +90,7 @@ func (dr *DbResource) FindOne(referenceId string, req api2go.Request) (api2go.Re
 						if IsStandardColumn(colName) {
 							continue
 						}
-						if valName == nil {
+						if valName == nil || (hasFieldset && !fields[colName]) {
 							continue
 						}
 						data[colName] = valName
@@ -105,6 +123,19 @@ func (dr *DbResource) FindOne(referenceId string, req api2go.Request) (api2go.Re
 			log.Printf("Error from AfterFindOne middleware: %v", err)
 		}
 	}
+	include = PruneUnreachableIncludes(include)
+
+	if version, err := parseVersion(data["version"]); err == nil {
+		// the ETag is sent with the 304 as well
+		etag := representationETag(req, version, languagePreferences)
+		SetResponseHeader(req, "ETag", etag)
+		if req.PlainRequest != nil && req.PlainRequest.Method == "GET" && etagListContains(req.PlainRequest.Header.Get("If-None-Match"), etag) {
+			return nil, api2go.NewHTTPError(nil, "not modified", http.StatusNotModified)
+		}
+	}
+	if hasFieldset && !fields["version"] {
+		delete(data, "version")
+	}
 
 	delete(data, "id")
 	//delete(data, "deleted_at")
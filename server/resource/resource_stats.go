@@ -3,6 +3,7 @@ package resource
 import (
 	"fmt"
 	"github.com/Masterminds/squirrel"
+	"github.com/daptin/daptin/server/auth"
 	"github.com/daptin/daptin/server/statementbuilder"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
@@ -24,6 +25,9 @@ type AggregationRequest struct {
 	TimeSample    TimeStamp
 	TimeFrom      string
 	TimeTo        string
+	// User is the user asking for the stats, related rows in the queries are
+	// matched when the user can read them
+	User *auth.SessionUser
 }
 
 // PaginatedFindAll(req Request) (totalCount uint, response Responder, err error)
@@ -110,6 +114,15 @@ func (dr *DbResource) DataStats(req AggregationRequest) (AggregateData, error) {
 		}
 	}
 
+	sessionUser := req.User
+	if sessionUser == nil {
+		sessionUser = &auth.SessionUser{}
+	}
+	builder, err = dr.addFilters(builder, req.Query, req.RootEntity, sessionUser)
+	if err != nil {
+		return AggregateData{}, err
+	}
+
 	sql, args, err := builder.ToSql()
 	CheckErr(err, "Failed to generate stats sql: [%v]")
 	if err != nil {
@@ -48,17 +48,47 @@ func (dr *DbResource) UpdateWithoutFilters(obj interface{}, req api2go.Request)
 
 	attrs := data.GetAllAsAttributes()
 
-	if !data.HasVersion() {
-		originalData, err := dr.GetReferenceIdToObject(dr.model.GetTableName(), id)
-		if err != nil {
-			return nil, err
-		}
-		data = api2go.NewApi2GoModelWithData(dr.model.GetTableName(), nil, 0, nil, originalData)
-		data.SetAttributes(attrs)
+	// changes and the expected version are checked against the stored row
+	originalData, err := dr.GetReferenceIdToObject(dr.model.GetTableName(), id)
+	if err != nil {
+		return nil, err
 	}
+	currentVersion, err := parseVersion(originalData["version"])
+	if err != nil {
+		return nil, err
+	}
+	data = api2go.NewApi2GoModelWithData(dr.model.GetTableName(), nil, 0, nil, originalData)
+	data.SetAttributes(attrs)
 
 	allChanges := data.GetChanges()
 	allColumns := dr.model.GetColumns()
+
+	versionExpected, err := checkExpectedVersion(req, allChanges, currentVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	locations := make(map[string]interface{})
+	for columnName, change := range allChanges {
+		locations[columnName] = change.NewValue
+	}
+	err = dr.SetLocationCoordinates(locations)
+	if err != nil {
+		return nil, err
+	}
+	for _, column := range dr.tableInfo.Columns {
+		if column.ColumnType != "location" {
+			continue
+		}
+		for _, columnName := range []string{column.ColumnName, locationLatitudeColumn(column.ColumnName), locationLongitudeColumn(column.ColumnName)} {
+			value, ok := locations[columnName]
+			if !ok {
+				delete(allChanges, columnName)
+				continue
+			}
+			allChanges[columnName] = api2go.Change{OldValue: allChanges[columnName].OldValue, NewValue: value}
+		}
+	}
 	//log.Infof("Update object request with changes: %v", allChanges)
 
 	//dataToInsert := make(map[string]interface{})
@@ -344,7 +374,7 @@ func (dr *DbResource) UpdateWithoutFilters(obj interface{}, req api2go.Request)
 		valsList = append(valsList, time.Now())
 
 		colsList = append(colsList, "version")
-		valsList = append(valsList, data.GetNextVersion())
+		valsList = append(valsList, currentVersion+1)
 
 		if len(languagePreferences) == 0 || !dr.tableInfo.TranslationsEnabled {
 
@@ -354,7 +384,13 @@ func (dr *DbResource) UpdateWithoutFilters(obj interface{}, req api2go.Request)
 				builder = builder.Set(colsList[i], valsList[i])
 			}
 
-			query, vals, err := builder.Where(squirrel.Eq{"reference_id": id}).ToSql()
+			builder = builder.Where(squirrel.Eq{"reference_id": id})
+			if versionExpected {
+				// a concurrent update of the row fails the expected version
+				builder = builder.Where(squirrel.Eq{"version": currentVersion})
+			}
+
+			query, vals, err := builder.ToSql()
 			//log.Infof("Update query: %v", query)
 			if err != nil {
 				log.Errorf("Failed to create update query: %v", err)
@@ -362,11 +398,18 @@ func (dr *DbResource) UpdateWithoutFilters(obj interface{}, req api2go.Request)
 			}
 
 			//log.Infof("Update query: %v == %v", query, vals)
-			_, err = dr.db.Exec(query, vals...)
+			result, err := dr.db.Exec(query, vals...)
 			if err != nil {
 				log.Errorf("Failed to execute update query: %v", err)
 				return nil, err
 			}
+			if versionExpected {
+				rowsAffected, err := result.RowsAffected()
+				if err == nil && rowsAffected == 0 {
+					err = errors.New("the object has been modified")
+					return nil, api2go.NewHTTPError(err, "the object was modified by another request", http.StatusConflict)
+				}
+			}
 
 		}
 
@@ -374,6 +417,36 @@ func (dr *DbResource) UpdateWithoutFilters(obj interface{}, req api2go.Request)
 
 	if len(languagePreferences) > 0 && dr.tableInfo.TranslationsEnabled {
 
+		if len(allChanges) > 0 {
+			// the version of the row covers its translations, so the expected
+			// version holds for a translated update as well
+			builder := statementbuilder.Squirrel.Update(dr.model.GetName()).
+				Set("version", currentVersion+1).
+				Set("updated_at", time.Now()).
+				Where(squirrel.Eq{"reference_id": id})
+			if versionExpected {
+				builder = builder.Where(squirrel.Eq{"version": currentVersion})
+			}
+
+			query, vals, err := builder.ToSql()
+			if err != nil {
+				log.Errorf("Failed to create update query: %v", err)
+				return nil, err
+			}
+			result, err := dr.db.Exec(query, vals...)
+			if err != nil {
+				log.Errorf("Failed to execute update query: %v", err)
+				return nil, err
+			}
+			if versionExpected {
+				rowsAffected, err := result.RowsAffected()
+				if err == nil && rowsAffected == 0 {
+					err = errors.New("the object has been modified")
+					return nil, api2go.NewHTTPError(err, "the object was modified by another request", http.StatusConflict)
+				}
+			}
+		}
+
 		for _, lang := range languagePreferences {
 
 			langTableCols := make([]string, 0)
@@ -812,6 +885,10 @@ func (dr *DbResource) Update(obj interface{}, req api2go.Request) (api2go.Respon
 	}
 	delete(updatedResource, "id")
 
+	if version, err := parseVersion(updatedResource["version"]); err == nil {
+		SetResponseHeader(req, "ETag", VersionETag(version))
+	}
+
 	return NewResponse(nil, api2go.NewApi2GoModelWithData(dr.model.GetName(), dr.model.GetColumns(), dr.model.GetDefaultPermission(), dr.model.GetRelations(), updatedResource), 200, nil), nil
 
 }
@@ -5,6 +5,7 @@ import (
 	"github.com/artpar/api2go"
 	"github.com/go-gota/gota/dataframe"
 	"github.com/go-gota/gota/series"
+	"strings"
 )
 
 // StreamProcess handles the Read operations, and applies transformations on the data the create a new view
@@ -73,6 +74,14 @@ func (dr *StreamProcessor) PaginatedFindAll(req api2go.Request) (totalCount uint
 	contract := dr.contract
 
 	for key, val := range contract.QueryParams {
+		// the query of the request narrows down the rows selected by the stream
+		if key == "query" && len(req.QueryParams[key]) > 0 {
+			query, err := AndQueryParams(strings.Join(val, ","), strings.Join(req.QueryParams[key], ","))
+			if err != nil {
+				return 0, nil, err
+			}
+			val = []string{query}
+		}
 		req.QueryParams[key] = val
 	}
 
@@ -6,10 +6,14 @@ import (
 	"github.com/artpar/rclone/cmd"
 	"github.com/artpar/rclone/fs"
 	"github.com/artpar/rclone/fs/config"
+	"github.com/artpar/rclone/fs/operations"
 	"github.com/artpar/rclone/fs/sync"
 	"github.com/artpar/rclone/lib/pacer"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"os"
+	"path"
+	"path/filepath"
 	"strings"
 )
 
@@ -71,3 +75,206 @@ func (res *DbResource) SyncStorageToPath(cloudStore CloudStore, cloudPath string
 
 	return nil
 }
+
+// configureCloudStoreToken sets up the rclone config for the provider of the
+// cloud store with its oauth token, stores without a token need no config
+func (res *DbResource) configureCloudStoreToken(cloudStore CloudStore) {
+
+	if cloudStore.OAutoTokenId == "" {
+		return
+	}
+
+	token, oauthConf, err := res.GetTokenByTokenReferenceId(cloudStore.OAutoTokenId)
+	if CheckErr(err, "Failed to get oauth2 token for cloud store [%v]", cloudStore.Name) {
+		return
+	}
+
+	jsonToken, err := json.Marshal(token)
+	CheckErr(err, "Failed to convert token to json")
+	config.FileSet(cloudStore.StoreProvider, "client_id", oauthConf.ClientID)
+	config.FileSet(cloudStore.StoreProvider, "type", cloudStore.StoreProvider)
+	config.FileSet(cloudStore.StoreProvider, "client_secret", oauthConf.ClientSecret)
+	config.FileSet(cloudStore.StoreProvider, "token", string(jsonToken))
+	config.FileSet(cloudStore.StoreProvider, "client_scopes", strings.Join(oauthConf.Scopes, ","))
+	config.FileSet(cloudStore.StoreProvider, "redirect_url", oauthConf.RedirectURL)
+}
+
+// storagePath is the location of a path inside the asset folder in the cloud store
+func storagePath(folder AssetFolderCache, relativePath string) string {
+	remotePath := strings.TrimSuffix(folder.CloudStore.RootPath, "/")
+	if folder.Keyname != "" {
+		remotePath = remotePath + "/" + strings.Trim(folder.Keyname, "/")
+	}
+	relativePath = strings.Trim(relativePath, "/")
+	if relativePath != "" && relativePath != "." {
+		remotePath = remotePath + "/" + relativePath
+	}
+	return remotePath
+}
+
+// SyncAssetFolderPath uploads the file or folder at relativePath in the local
+// copy of the asset folder to the cloud store backing the folder
+func (res *DbResource) SyncAssetFolderPath(folder AssetFolderCache, relativePath string) error {
+
+	relativePath = strings.Trim(path.Clean("/"+relativePath), "/")
+	localPath := filepath.Join(folder.LocalSyncPath, filepath.FromSlash(relativePath))
+
+	stat, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+
+	res.configureCloudStoreToken(folder.CloudStore)
+
+	var fsrc, fdst fs.Fs
+	fileName := ""
+	if stat.IsDir() {
+		fsrc = cmd.NewFsDir([]string{localPath})
+		fdst = cmd.NewFsDir([]string{storagePath(folder, relativePath)})
+	} else {
+		parentPath, name := path.Split(relativePath)
+		fileName = name
+		fsrc = cmd.NewFsDir([]string{filepath.Join(folder.LocalSyncPath, filepath.FromSlash(parentPath))})
+		fdst = cmd.NewFsDir([]string{storagePath(folder, parentPath)})
+	}
+
+	if fsrc == nil || fdst == nil {
+		return fmt.Errorf("failed to open cloud store [%v]", folder.CloudStore.Name)
+	}
+
+	// uploads run in the background and failures are only logged, cmd.Run
+	// would exit the process on an error
+	go func() {
+		ctx := context.Background()
+		log.Infof("Upload [%v] to [%v]", localPath, fdst.String())
+		var err error
+		if fileName == "" {
+			err = sync.CopyDir(ctx, fdst, fsrc, true)
+		} else {
+			err = operations.CopyFile(ctx, fdst, fsrc, fileName, fileName)
+		}
+		CheckErr(err, "Failed to upload [%v] to cloud store [%v]", relativePath, folder.CloudStore.Name)
+	}()
+
+	return nil
+}
+
+// DeleteAssetFolderPath removes the file or folder at relativePath from the
+// cloud store backing the asset folder
+func (res *DbResource) DeleteAssetFolderPath(folder AssetFolderCache, relativePath string, isDir bool) error {
+
+	relativePath = strings.Trim(path.Clean("/"+relativePath), "/")
+	if relativePath == "" {
+		return fmt.Errorf("cannot delete the root of the asset folder")
+	}
+
+	res.configureCloudStoreToken(folder.CloudStore)
+
+	parentPath, name := path.Split(relativePath)
+	fdst := cmd.NewFsDir([]string{storagePath(folder, parentPath)})
+
+	if fdst == nil {
+		return fmt.Errorf("failed to open cloud store [%v]", folder.CloudStore.Name)
+	}
+
+	go func() {
+		ctx := context.Background()
+		log.Infof("Delete [%v] from [%v]", name, fdst.String())
+		var err error
+		if isDir {
+			err = operations.Purge(ctx, fdst, name)
+		} else {
+			var object fs.Object
+			object, err = fdst.NewObject(ctx, name)
+			if err == nil {
+				err = operations.DeleteFile(ctx, object)
+			}
+		}
+		CheckErr(err, "Failed to delete [%v] from cloud store [%v]", relativePath, folder.CloudStore.Name)
+	}()
+
+	return nil
+}
+
+// MakeAssetFolderDirectory creates the folder at relativePath in the cloud store
+// backing the asset folder
+func (res *DbResource) MakeAssetFolderDirectory(folder AssetFolderCache, relativePath string) error {
+
+	relativePath = strings.Trim(path.Clean("/"+relativePath), "/")
+
+	res.configureCloudStoreToken(folder.CloudStore)
+
+	fdst := cmd.NewFsDir([]string{storagePath(folder, "")})
+
+	if fdst == nil {
+		return fmt.Errorf("failed to open cloud store [%v]", folder.CloudStore.Name)
+	}
+
+	go func() {
+		err := operations.Mkdir(context.Background(), fdst, relativePath)
+		CheckErr(err, "Failed to create folder [%v] in cloud store [%v]", relativePath, folder.CloudStore.Name)
+	}()
+
+	return nil
+}
+
+// CopyToCloudStore uploads the file or folder at localPath to the folder at
+// remotePath in the cloud store, and waits for the upload to complete
+func (res *DbResource) CopyToCloudStore(cloudStore CloudStore, localPath string, remotePath string) error {
+
+	stat, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+
+	res.configureCloudStoreToken(cloudStore)
+
+	fdst := cmd.NewFsDir([]string{storagePath(AssetFolderCache{CloudStore: cloudStore, Keyname: remotePath}, "")})
+	sourcePath := localPath
+	if !stat.IsDir() {
+		sourcePath = filepath.Dir(localPath)
+	}
+	fsrc := cmd.NewFsDir([]string{sourcePath})
+	if fsrc == nil || fdst == nil {
+		return fmt.Errorf("failed to open cloud store [%v]", cloudStore.Name)
+	}
+
+	log.Infof("Upload [%v] to [%v]", localPath, fdst.String())
+	if stat.IsDir() {
+		return sync.CopyDir(context.Background(), fdst, fsrc, true)
+	}
+	return operations.CopyFile(context.Background(), fdst, fsrc, stat.Name(), stat.Name())
+}
+
+// CopyFromCloudStore downloads the folder at remotePath in the cloud store to
+// localPath, and waits for the download to complete
+func (res *DbResource) CopyFromCloudStore(cloudStore CloudStore, remotePath string, localPath string) error {
+
+	res.configureCloudStoreToken(cloudStore)
+
+	fsrc := cmd.NewFsDir([]string{storagePath(AssetFolderCache{CloudStore: cloudStore, Keyname: remotePath}, "")})
+	fdst := cmd.NewFsDir([]string{localPath})
+	if fsrc == nil || fdst == nil {
+		return fmt.Errorf("failed to open cloud store [%v]", cloudStore.Name)
+	}
+
+	log.Infof("Download [%v] to [%v]", fsrc.String(), localPath)
+	return sync.CopyDir(context.Background(), fdst, fsrc, true)
+}
+
+// CopyFileFromCloudStore downloads the file at remotePath in the cloud store
+// to the folder at localPath, and waits for the download to complete
+func (res *DbResource) CopyFileFromCloudStore(cloudStore CloudStore, remotePath string, localPath string) error {
+
+	res.configureCloudStoreToken(cloudStore)
+
+	remoteFolder, fileName := path.Split(strings.Trim(remotePath, "/"))
+	fsrc := cmd.NewFsDir([]string{storagePath(AssetFolderCache{CloudStore: cloudStore, Keyname: remoteFolder}, "")})
+	fdst := cmd.NewFsDir([]string{localPath})
+	if fsrc == nil || fdst == nil {
+		return fmt.Errorf("failed to open cloud store [%v]", cloudStore.Name)
+	}
+
+	log.Infof("Download [%v] from [%v] to [%v]", fileName, fsrc.String(), localPath)
+	return operations.CopyFile(context.Background(), fdst, fsrc, fileName, fileName)
+}
@@ -0,0 +1,122 @@
+package resource
+
+import (
+	"github.com/artpar/api2go"
+	"github.com/artpar/go.uuid"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/statementbuilder"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestCruds creates the stock tables in a new sqlite database. The caller
+// removes the database with the returned cleanup.
+func newTestCruds(tb testing.TB) (map[string]*DbResource, func()) {
+
+	tempDir, err := ioutil.TempDir("", "daptin-test")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	db, err := sqlx.Open("sqlite3", filepath.Join(tempDir, "daptin_test.db"))
+	if err != nil {
+		os.RemoveAll(tempDir)
+		tb.Fatal(err)
+	}
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(tempDir)
+	}
+
+	config := &CmsConfig{
+		Tables:    append([]TableInfo{}, StandardTables...),
+		Relations: make([]api2go.TableRelation, 0),
+	}
+	CheckRelations(config)
+
+	tx := db.MustBegin()
+	CheckAllTableStatus(config, db, tx)
+	CreateRelations(config, tx)
+	if err = tx.Commit(); err != nil {
+		cleanup()
+		tb.Fatal(err)
+	}
+
+	cruds := make(map[string]*DbResource)
+	ms := &MiddlewareSet{}
+	for _, table := range config.Tables {
+		model := api2go.NewApi2GoModel(table.TableName, table.Columns, int64(table.DefaultPermission), table.Relations)
+		cruds[table.TableName] = NewDbResource(model, db, ms, cruds, nil, table)
+	}
+
+	return cruds, cleanup
+}
+
+// insertTestRow inserts a row with a new reference id and the default
+// permission, and returns its id
+func insertTestRow(tb testing.TB, cruds map[string]*DbResource, table string, row map[string]interface{}) int64 {
+
+	u, _ := uuid.NewV4()
+	columns := []string{"reference_id", "permission"}
+	values := []interface{}{u.String(), int64(auth.DEFAULT_PERMISSION)}
+	for column, value := range row {
+		columns = append(columns, column)
+		values = append(values, value)
+	}
+
+	query, args, err := statementbuilder.Squirrel.Insert(table).Columns(columns...).Values(values...).ToSql()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	result, err := cruds[table].db.Exec(query, args...)
+	if err != nil {
+		tb.Fatalf("Failed to insert into %v: %v", table, err)
+	}
+	id, _ := result.LastInsertId()
+	return id
+}
+
+// insertTestUser inserts a confirmed user who is the only member of a new user
+// group. The first member of the second user group is the administrator.
+func insertTestUser(tb testing.TB, cruds map[string]*DbResource, name string, email string) *auth.SessionUser {
+
+	userId := insertTestRow(tb, cruds, USER_ACCOUNT_TABLE_NAME, map[string]interface{}{
+		"name":      name,
+		"email":     email,
+		"password":  "",
+		"confirmed": true,
+	})
+	groupId := insertTestRow(tb, cruds, "usergroup", map[string]interface{}{
+		"name": name,
+	})
+	membershipId := insertTestRow(tb, cruds, "user_account_user_account_id_has_usergroup_usergroup_id", map[string]interface{}{
+		"user_account_id": userId,
+		"usergroup_id":    groupId,
+	})
+
+	referenceIds := make([]string, 0)
+	for _, row := range []struct {
+		table string
+		id    int64
+	}{{USER_ACCOUNT_TABLE_NAME, userId}, {"usergroup", groupId}, {"user_account_user_account_id_has_usergroup_usergroup_id", membershipId}} {
+		referenceId, err := cruds[row.table].GetIdToReferenceId(row.table, row.id)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		referenceIds = append(referenceIds, referenceId)
+	}
+
+	return &auth.SessionUser{
+		UserId:          userId,
+		UserReferenceId: referenceIds[0],
+		Groups: []auth.GroupPermission{{
+			GroupReferenceId:    referenceIds[1],
+			ObjectReferenceId:   referenceIds[0],
+			RelationReferenceId: referenceIds[2],
+			Permission:          auth.DEFAULT_PERMISSION,
+		}},
+	}
+}
@@ -0,0 +1,151 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/Masterminds/squirrel"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/statementbuilder"
+	log "github.com/sirupsen/logrus"
+	"strings"
+)
+
+// UpsertRequested is true when a create request asks to update the row having
+// the same values in the upsert keys of the table instead of failing on the
+// unique constraint
+//
+//	POST /api/product?upsert=true
+func UpsertRequested(req api2go.Request) bool {
+	values, ok := req.QueryParams["upsert"]
+	return ok && len(values) > 0 && (values[0] == "true" || values[0] == "1")
+}
+
+// upsertKeyValues returns the condition matching the values of the data in all
+// the upsert keys of the table
+func (dr *DbResource) upsertKeyValues(data map[string]interface{}, foreignKeysAsReferenceId bool) (squirrel.Eq, error) {
+
+	if len(dr.tableInfo.UpsertKeys) == 0 {
+		return nil, fmt.Errorf("no upsert keys declared on [%v]", dr.tableInfo.TableName)
+	}
+
+	condition := squirrel.Eq{}
+	for _, columnName := range dr.tableInfo.UpsertKeys {
+
+		value, ok := data[columnName]
+		if !ok || value == nil {
+			return nil, fmt.Errorf("value of [%v] is required to upsert", columnName)
+		}
+
+		column, ok := dr.tableInfo.GetColumnByName(columnName)
+		if ok && column.IsForeignKey && column.ForeignKeyData.DataSource == "self" && foreignKeysAsReferenceId {
+			referenceId, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected reference id in [%v]", columnName)
+			}
+			id, err := dr.GetReferenceIdToId(column.ForeignKeyData.Namespace, referenceId)
+			if err != nil {
+				return nil, err
+			}
+			value = id
+		}
+
+		condition[columnName] = value
+	}
+
+	return condition, nil
+}
+
+// isUniqueViolation is true for the error of an insert failing on a unique
+// index, as reported by sqlite, mysql and postgres
+func isUniqueViolation(err error) bool {
+	if err == nil {
+		return false
+	}
+	message := err.Error()
+	return strings.Contains(message, "UNIQUE constraint failed") ||
+		strings.Contains(message, "Error 1062") ||
+		strings.Contains(message, "duplicate key value violates unique constraint")
+}
+
+// GetUpsertMatch returns the reference id of the row having the values of the
+// data in the upsert keys, or an empty string when there is no such row
+func (dr *DbResource) GetUpsertMatch(data map[string]interface{}) (string, error) {
+
+	condition, err := dr.upsertKeyValues(data, true)
+	if err != nil {
+		return "", err
+	}
+
+	referenceIds, err := dr.GetReferenceIdByWhereClause(dr.tableInfo.TableName, condition)
+	if err != nil || len(referenceIds) == 0 {
+		return "", err
+	}
+	return referenceIds[0], nil
+}
+
+// updateUpsertMatch updates the row matching the upsert keys of the data. The
+// response is nil when there is no matching row and the data is to be
+// inserted. The response status stays 201 for the api to accept it as the
+// response of a create request, the meta tells if the row was updated.
+func (dr *DbResource) updateUpsertMatch(data *api2go.Api2GoModel, req api2go.Request) (api2go.Responder, error) {
+
+	referenceId, err := dr.GetUpsertMatch(data.Data)
+	if err != nil {
+		return nil, api2go.NewHTTPError(err, err.Error(), 400)
+	}
+	if referenceId == "" {
+		return nil, nil
+	}
+
+	attributes := data.GetAllAsAttributes()
+	attributes["reference_id"] = referenceId
+	updateObject := api2go.NewApi2GoModelWithData(dr.model.GetName(), nil, 0, nil, attributes)
+
+	response, err := dr.Update(updateObject, req)
+	if err != nil {
+		return response, err
+	}
+
+	return NewResponse(map[string]interface{}{
+		"upsert": "updated",
+	}, response.Result(), 201, nil), nil
+}
+
+// DirectUpsert updates the row matching the upsert keys of the data, without
+// any checks, or inserts the data when there is no such row. Values of foreign
+// keys are ids, as in the data exports.
+func (dr *DbResource) DirectUpsert(typeName string, data map[string]interface{}) error {
+
+	condition, err := dr.Cruds[typeName].upsertKeyValues(data, false)
+	if err != nil {
+		return err
+	}
+
+	ids, err := dr.GetIdByWhereClause(typeName, condition)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return dr.DirectInsert(typeName, data)
+	}
+
+	columns, values := dr.directColumnValues(typeName, data)
+
+	update := statementbuilder.Squirrel.Update(typeName)
+	for i, columnName := range columns {
+		if _, ok := data[columnName]; !ok || columnName == "id" || columnName == "reference_id" {
+			continue
+		}
+		update = update.Set(columnName, values[i])
+	}
+
+	sqlString, args, err := update.Where(squirrel.Eq{"id": ids[0]}).ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = dr.db.Exec(sqlString, args...)
+	if err != nil {
+		log.Errorf("Failed SQL  [%v] [%v]", sqlString, args)
+	}
+	return err
+}
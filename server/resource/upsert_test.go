@@ -0,0 +1,151 @@
+package resource
+
+import (
+	"context"
+	"errors"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"net/http"
+	"testing"
+)
+
+func TestUpsertRequested(t *testing.T) {
+
+	for _, value := range []string{"true", "1"} {
+		if !UpsertRequested(api2go.Request{QueryParams: map[string][]string{"upsert": {value}}}) {
+			t.Errorf("Expected upsert=%v to request an upsert", value)
+		}
+	}
+
+	for _, queryParams := range []map[string][]string{nil, {"upsert": {"false"}}, {"upsert": {}}} {
+		if UpsertRequested(api2go.Request{QueryParams: queryParams}) {
+			t.Errorf("Expected %v not to request an upsert", queryParams)
+		}
+	}
+}
+
+func TestUpsertKeyValues(t *testing.T) {
+
+	dr := &DbResource{tableInfo: &TableInfo{TableName: "product", UpsertKeys: []string{"sku", "store"}}}
+
+	condition, err := dr.upsertKeyValues(map[string]interface{}{"sku": "a1", "store": "pune", "name": "pen"}, false)
+	if err != nil || len(condition) != 2 || condition["sku"] != "a1" || condition["store"] != "pune" {
+		t.Errorf("Unexpected upsert condition: %v %v", condition, err)
+	}
+
+	if _, err = dr.upsertKeyValues(map[string]interface{}{"sku": "a1"}, false); err == nil {
+		t.Errorf("Expected an error when an upsert key has no value")
+	}
+}
+
+// concurrentInsert inserts the row of another request when the create request
+// has matched no row yet, the way two requests upserting the same row race
+type concurrentInsert struct {
+	insert func()
+}
+
+func (ci *concurrentInsert) String() string {
+	return "concurrentInsert"
+}
+
+func (ci *concurrentInsert) InterceptBefore(dr *DbResource, req *api2go.Request, objects []map[string]interface{}) ([]map[string]interface{}, error) {
+	if ci.insert != nil {
+		ci.insert()
+		ci.insert = nil
+	}
+	return objects, nil
+}
+
+func (ci *concurrentInsert) InterceptAfter(dr *DbResource, req *api2go.Request, objects []map[string]interface{}) ([]map[string]interface{}, error) {
+	return objects, nil
+}
+
+func TestUpsertCreate(t *testing.T) {
+
+	cruds, cleanup := newTestCruds(t)
+	defer cleanup()
+	db := cruds["world"].connection
+	user := insertTestUser(t, cruds, "user", "user@example.com")
+
+	productTable := TableInfo{
+		TableName:         "product",
+		DefaultPermission: auth.DEFAULT_PERMISSION,
+		UpsertKeys:        []string{"sku", "store"},
+		Columns: []api2go.ColumnInfo{
+			{Name: "sku", ColumnName: "sku", ColumnType: "label", DataType: "varchar(100)"},
+			{Name: "store", ColumnName: "store", ColumnType: "label", DataType: "varchar(100)"},
+			{Name: "name", ColumnName: "name", ColumnType: "label", DataType: "varchar(100)", IsNullable: true},
+		},
+	}
+	config := &CmsConfig{
+		Tables: []TableInfo{productTable},
+	}
+	tx := db.MustBegin()
+	CheckAllTableStatus(config, db, tx)
+	CreateUniqueConstraints(config, tx)
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	productTable = config.Tables[0]
+	race := &concurrentInsert{}
+	model := api2go.NewApi2GoModel(productTable.TableName, productTable.Columns, int64(productTable.DefaultPermission), productTable.Relations)
+	products := NewDbResource(model, db, &MiddlewareSet{BeforeCreate: []DatabaseRequestInterceptor{race}}, cruds, nil, productTable)
+	cruds["product"] = products
+
+	upsertRequest := func() api2go.Request {
+		httpRequest := &http.Request{Method: "POST"}
+		httpRequest = httpRequest.WithContext(context.WithValue(context.Background(), "user", user))
+		return api2go.Request{
+			PlainRequest: httpRequest,
+			QueryParams:  map[string][]string{"upsert": {"true"}},
+		}
+	}
+	upsert := func(sku string, name string) (string, error) {
+		data := api2go.NewApi2GoModelWithData("product", productTable.Columns, 0, nil, map[string]interface{}{
+			"sku": sku, "store": "pune", "name": name,
+		})
+		response, err := products.Create(data, upsertRequest())
+		if err != nil {
+			return "", err
+		}
+		if meta, ok := response.Metadata()["upsert"]; ok {
+			return meta.(string), nil
+		}
+		return "created", nil
+	}
+	names := func(sku string) []string {
+		rows := make([]string, 0)
+		if err := db.Select(&rows, "select name from product where sku = ? order by id", sku); err != nil {
+			t.Fatal(err)
+		}
+		return rows
+	}
+
+	if result, err := upsert("a1", "pen"); err != nil || result != "created" {
+		t.Errorf("Expected the first upsert to create the row, got %v: %v", result, err)
+	}
+	if result, err := upsert("a1", "blue pen"); err != nil || result != "updated" {
+		t.Errorf("Expected the second upsert to update the row, got %v: %v", result, err)
+	}
+	if rows := names("a1"); len(rows) != 1 || rows[0] != "blue pen" {
+		t.Errorf("Expected one updated row, got %v", rows)
+	}
+
+	// a row inserted after the match is updated instead of failing on the unique index
+	race.insert = func() {
+		insertTestRow(t, cruds, "product", map[string]interface{}{"sku": "b2", "store": "pune", "name": "pencil"})
+	}
+	if result, err := upsert("b2", "red pencil"); err != nil || result != "updated" {
+		t.Errorf("Expected the upsert to update the row inserted after the match, got %v: %v", result, err)
+	}
+	if rows := names("b2"); len(rows) != 1 || rows[0] != "red pencil" {
+		t.Errorf("Expected one updated row, got %v", rows)
+	}
+
+	if !isUniqueViolation(errors.New("UNIQUE constraint failed: product.sku, product.store")) ||
+		!isUniqueViolation(errors.New("Error 1062: Duplicate entry 'a1-pune' for key 'i1'")) ||
+		!isUniqueViolation(errors.New(`pq: duplicate key value violates unique constraint "i1"`)) ||
+		isUniqueViolation(errors.New("NOT NULL constraint failed: product.sku")) || isUniqueViolation(nil) {
+		t.Errorf("Expected only unique index errors to be unique violations")
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"fmt"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/daptin/daptin/server/sdkgen"
+	"github.com/gin-gonic/gin"
+	"sync"
+)
+
+// sdkClientCacheSize is the number of generated clients kept in memory, the
+// oldest ones are dropped first
+const sdkClientCacheSize = 20
+
+// SdkGenerator serves the clients generated from the tables and the actions of
+// the server at /sdk/:language, and the description they are generated from at
+// /sdk/schema. Every response has the hash of the schema in a header, for the
+// clients to know when they are stale.
+type SdkGenerator struct {
+	initConfig *resource.CmsConfig
+	once       sync.Once
+	schema     sdkgen.Schema
+	lock       sync.Mutex
+	clients    map[string]string
+	clientKeys []string
+}
+
+func NewSdkGenerator(initConfig *resource.CmsConfig) *SdkGenerator {
+	return &SdkGenerator{
+		initConfig: initConfig,
+		clients:    make(map[string]string),
+	}
+}
+
+// Schema is built on first use, once the column types are loaded
+func (sg *SdkGenerator) Schema() sdkgen.Schema {
+	sg.once.Do(func() {
+		sg.schema = sdkgen.BuildSchema(sg.initConfig)
+	})
+	return sg.schema
+}
+
+func (sg *SdkGenerator) SchemaHashMiddlewareFunc(c *gin.Context) {
+	c.Header(sdkgen.SchemaHashHeader, sg.Schema().Hash)
+}
+
+func (sg *SdkGenerator) SdkHandler(c *gin.Context) {
+
+	language := c.Param("language")
+	schema := sg.Schema()
+	if language == "schema" {
+		c.JSON(200, schema)
+		return
+	}
+
+	packageName := c.Query("package")
+	key := language + ":" + packageName
+	sg.lock.Lock()
+	client, ok := sg.clients[key]
+	sg.lock.Unlock()
+	if !ok {
+		var err error
+		client, err = sdkgen.Generate(schema, language, packageName)
+		if err != nil {
+			c.AbortWithStatusJSON(400, resource.NewDaptinError(err.Error(), "invalid sdk request"))
+			return
+		}
+		sg.lock.Lock()
+		if _, ok := sg.clients[key]; !ok {
+			if len(sg.clientKeys) >= sdkClientCacheSize {
+				delete(sg.clients, sg.clientKeys[0])
+				sg.clientKeys = sg.clientKeys[1:]
+			}
+			sg.clients[key] = client
+			sg.clientKeys = append(sg.clientKeys, key)
+		}
+		sg.lock.Unlock()
+	}
+
+	fileName, ok := sdkgen.Languages[language]
+	if !ok {
+		fileName = sdkgen.Languages["typescript"]
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%v", fileName))
+	c.Data(200, "text/plain; charset=utf-8", []byte(client))
+}
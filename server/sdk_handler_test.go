@@ -0,0 +1,39 @@
+package server
+
+import (
+	"fmt"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSdkHandlerClientCache(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+	sdkGenerator := NewSdkGenerator(&resource.CmsConfig{})
+	router := gin.New()
+	router.GET("/sdk/:language", sdkGenerator.SdkHandler)
+
+	request := func(url string) int {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest("GET", url, nil))
+		return recorder.Code
+	}
+
+	if code := request("/sdk/go?package=Not%20A%20Package"); code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid package name to be refused, got %v", code)
+	}
+	for i := 0; i < sdkClientCacheSize*2; i++ {
+		if code := request(fmt.Sprintf("/sdk/go?package=client%d", i)); code != http.StatusOK {
+			t.Fatalf("Expected the client of package %d, got %v", i, code)
+		}
+	}
+	if len(sdkGenerator.clients) != sdkClientCacheSize || len(sdkGenerator.clientKeys) != sdkClientCacheSize {
+		t.Errorf("Expected %d clients to be kept, found %d", sdkClientCacheSize, len(sdkGenerator.clients))
+	}
+	if _, ok := sdkGenerator.clients["go:client0"]; ok {
+		t.Errorf("Expected the oldest client to be dropped")
+	}
+}
@@ -0,0 +1,530 @@
+package sdkgen
+
+import (
+	"bytes"
+	"go/format"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/iancoleman/strcase"
+)
+
+var goIdentifierPattern = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// goName is the exported go identifier of a table, a column or an action name
+func goName(name string) string {
+	name = strcase.ToCamel(goIdentifierPattern.ReplaceAllString(name, "_"))
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "X" + name
+	}
+	return name
+}
+
+// goReservedNames are the types of the client, rows of the tables with these
+// names are named with a Row suffix
+var goReservedNames = map[string]bool{
+	"Client":         true,
+	"Reference":      true,
+	"ActionResponse": true,
+	"ErrorObject":    true,
+	"Error":          true,
+}
+
+// goRowName is the go type of the rows of a table
+func goRowName(tableName string) string {
+	name := goName(tableName)
+	if goReservedNames[name] {
+		return name + "Row"
+	}
+	return name
+}
+
+// goType is the go type of the values of a field, nullable fields are pointers
+func goType(field Field) string {
+	typeName := "string"
+	switch field.Type {
+	case "integer":
+		typeName = "int64"
+	case "number":
+		typeName = "float64"
+	case "boolean":
+		typeName = "bool"
+	}
+	if field.Nullable {
+		return "*" + typeName
+	}
+	return typeName
+}
+
+// goTag is the json tag of a field, nullable fields are left out when nil
+func goTag(field Field) string {
+	if field.Nullable {
+		return "`json:\"" + field.Name + ",omitempty\"`"
+	}
+	return "`json:\"" + field.Name + "\"`"
+}
+
+func readOnlyFields(fields []Field) []Field {
+	readOnly := make([]Field, 0)
+	for _, field := range fields {
+		if field.ReadOnly {
+			readOnly = append(readOnly, field)
+		}
+	}
+	return readOnly
+}
+
+var goTemplate = template.Must(template.New("go").Funcs(template.FuncMap{
+	"name":     goName,
+	"row":      goRowName,
+	"lower":    strcase.ToLowerCamel,
+	"type":     goType,
+	"tag":      goTag,
+	"readOnly": readOnlyFields,
+	"label": func(name string) string {
+		return strings.ReplaceAll(name, "_", " ")
+	},
+}).Parse(`// Code generated by daptin from the schema {{.Schema.Hash}}. DO NOT EDIT.
+
+// Package {{.Package}} is a client of the daptin api
+package {{.Package}}
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// SchemaHash is the hash of the schema the client was generated from
+const SchemaHash = "{{.Schema.Hash}}"
+
+// SchemaHashHeader is the header of the responses with the hash of the schema
+// of the server
+const SchemaHashHeader = "X-Daptin-Schema-Hash"
+
+// Client calls the api of a daptin server
+type Client struct {
+	BaseURL    string
+	Token      string
+	HTTPClient *http.Client
+	// OnStaleSchema is called with the hash of the schema of the server when
+	// it is not the one the client was generated from
+	OnStaleSchema func(serverHash string)
+}
+
+func NewClient(baseURL string, token string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		Token:      token,
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+// Reference is a related row
+type Reference struct {
+	Type string ` + "`json:\"type\"`" + `
+	ID   string ` + "`json:\"id\"`" + `
+}
+
+// ActionResponse is an outcome of an action
+type ActionResponse struct {
+	ResponseType string      ` + "`json:\"ResponseType\"`" + `
+	Attributes   interface{} ` + "`json:\"Attributes\"`" + `
+}
+
+// ErrorObject is an error of a failed request
+type ErrorObject struct {
+	Status string ` + "`json:\"status\"`" + `
+	Code   string ` + "`json:\"code\"`" + `
+	Title  string ` + "`json:\"title\"`" + `
+	Detail string ` + "`json:\"detail\"`" + `
+}
+
+// Error is a failed request
+type Error struct {
+	StatusCode int
+	Errors     []ErrorObject
+	Body       string
+}
+
+func (e *Error) Error() string {
+	if len(e.Errors) > 0 {
+		if e.Errors[0].Detail != "" {
+			return fmt.Sprintf("%d: %s", e.StatusCode, e.Errors[0].Detail)
+		}
+		return fmt.Sprintf("%d: %s", e.StatusCode, e.Errors[0].Title)
+	}
+	return fmt.Sprintf("%d: %s", e.StatusCode, e.Body)
+}
+
+type resourceObject struct {
+	Type          string                  ` + "`json:\"type\"`" + `
+	ID            string                  ` + "`json:\"id,omitempty\"`" + `
+	Attributes    map[string]interface{}  ` + "`json:\"attributes,omitempty\"`" + `
+	Relationships map[string]relationship ` + "`json:\"relationships,omitempty\"`" + `
+}
+
+type relationship struct {
+	Data json.RawMessage ` + "`json:\"data\"`" + `
+}
+
+type resourceDocument struct {
+	Data resourceObject ` + "`json:\"data\"`" + `
+}
+
+type listDocument struct {
+	Data  []resourceObject       ` + "`json:\"data\"`" + `
+	Links map[string]interface{} ` + "`json:\"links\"`" + `
+}
+
+// do sends a request to the api and reads the response into out
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body interface{}, out interface{}) error {
+
+	var requestBody *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		requestBody = bytes.NewReader(data)
+	} else {
+		requestBody = bytes.NewReader(nil)
+	}
+
+	requestUrl := c.BaseURL + path
+	if len(query) > 0 {
+		requestUrl = requestUrl + "?" + query.Encode()
+	}
+	request, err := http.NewRequest(method, requestUrl, requestBody)
+	if err != nil {
+		return err
+	}
+	request = request.WithContext(ctx)
+	if strings.HasPrefix(path, "/api/") {
+		request.Header.Set("Content-Type", "application/vnd.api+json")
+		request.Header.Set("Accept", "application/vnd.api+json")
+	} else {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if serverHash := response.Header.Get(SchemaHashHeader); serverHash != "" && serverHash != SchemaHash && c.OnStaleSchema != nil {
+		c.OnStaleSchema(serverHash)
+	}
+
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode >= 400 {
+		failed := &Error{StatusCode: response.StatusCode, Body: string(data)}
+		var errorDocument struct {
+			Errors []ErrorObject ` + "`json:\"errors\"`" + `
+		}
+		if json.Unmarshal(data, &errorDocument) == nil {
+			failed.Errors = errorDocument.Errors
+		}
+		return failed
+	}
+
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// CheckSchema tells if the server has the schema the client was generated from
+func (c *Client) CheckSchema(ctx context.Context) (bool, error) {
+	var schema struct {
+		Hash string ` + "`json:\"hash\"`" + `
+	}
+	err := c.do(ctx, "GET", "/sdk/schema", nil, nil, &schema)
+	if err != nil {
+		return false, err
+	}
+	return schema.Hash == SchemaHash, nil
+}
+
+// attributesOf are the attributes of a row to send, without the fields which
+// cannot be set
+func attributesOf(row interface{}, readOnly ...string) (map[string]interface{}, error) {
+	data, err := json.Marshal(row)
+	if err != nil {
+		return nil, err
+	}
+	attributes := make(map[string]interface{})
+	err = json.Unmarshal(data, &attributes)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range readOnly {
+		delete(attributes, name)
+	}
+	return attributes, nil
+}
+
+// readAttributes reads the attributes of a row, values of other types than
+// the ones of the fields, like booleans stored as numbers, are converted
+func readAttributes(attributes map[string]interface{}, types map[string]string, row interface{}) error {
+	values := make(map[string]interface{})
+	for name, value := range attributes {
+		values[name] = convertValue(value, types[name])
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, row)
+}
+
+func convertValue(value interface{}, fieldType string) interface{} {
+	switch fieldType {
+	case "boolean":
+		switch typed := value.(type) {
+		case float64:
+			return typed != 0
+		case string:
+			return typed == "true" || typed == "1"
+		}
+	case "integer", "number":
+		if typed, ok := value.(string); ok {
+			if number, err := strconv.ParseFloat(typed, 64); err == nil {
+				if fieldType == "integer" {
+					return int64(number)
+				}
+				return number
+			}
+			return nil
+		}
+		if typed, ok := value.(float64); ok && fieldType == "integer" {
+			return int64(typed)
+		}
+	case "string", "datetime":
+		switch value.(type) {
+		case string, nil:
+		default:
+			return fmt.Sprintf("%v", value)
+		}
+	}
+	return value
+}
+
+func toOne(value relationship) *Reference {
+	var reference *Reference
+	if json.Unmarshal(value.Data, &reference) != nil {
+		return nil
+	}
+	return reference
+}
+
+func toMany(value relationship) []Reference {
+	references := make([]Reference, 0)
+	if json.Unmarshal(value.Data, &references) != nil {
+		return nil
+	}
+	return references
+}
+
+func oneRelationship(reference *Reference) relationship {
+	data, _ := json.Marshal(reference)
+	return relationship{Data: data}
+}
+
+func manyRelationship(references []Reference) relationship {
+	if references == nil {
+		references = []Reference{}
+	}
+	data, _ := json.Marshal(references)
+	return relationship{Data: data}
+}
+
+func listTotal(document listDocument) int {
+	if total, ok := document.Links["total"].(float64); ok {
+		return int(total)
+	}
+	return len(document.Data)
+}
+{{range $table := .Schema.Tables}}{{$name := row $table.Name}}
+// {{$name}} is a row of {{$table.Name}}
+type {{$name}} struct {
+	ID string ` + "`json:\"-\"`" + `
+{{- range $table.Fields}}
+	{{name .Name}} {{type .}} {{tag .}}
+{{- end}}
+{{- range $table.Relations}}
+	{{name .Name}} {{if .Many}}[]Reference{{else}}*Reference{{end}} ` + "`json:\"-\"`" + `
+{{- end}}
+}
+
+var {{lower $table.Name}}FieldTypes = map[string]string{
+{{- range $table.Fields}}
+	"{{.Name}}": "{{.Type}}",
+{{- end}}
+}
+
+func (row *{{$name}}) toResource() (resourceObject, error) {
+	attributes, err := attributesOf(row{{range readOnly $table.Fields}}, "{{.Name}}"{{end}})
+	if err != nil {
+		return resourceObject{}, err
+	}
+	object := resourceObject{
+		Type:          "{{$table.Name}}",
+		ID:            row.ID,
+		Attributes:    attributes,
+		Relationships: make(map[string]relationship),
+	}
+{{- range $table.Relations}}
+{{- if .Many}}
+	if row.{{name .Name}} != nil {
+		object.Relationships["{{.Name}}"] = manyRelationship(row.{{name .Name}})
+	}
+{{- else}}
+	if row.{{name .Name}} != nil {
+		object.Relationships["{{.Name}}"] = oneRelationship(row.{{name .Name}})
+	}
+{{- end}}
+{{- end}}
+	return object, nil
+}
+
+func (row *{{$name}}) fromResource(object resourceObject) error {
+	err := readAttributes(object.Attributes, {{lower $table.Name}}FieldTypes, row)
+	if err != nil {
+		return err
+	}
+	row.ID = object.ID
+{{- range $table.Relations}}
+	if value, ok := object.Relationships["{{.Name}}"]; ok {
+		row.{{name .Name}} = {{if .Many}}toMany{{else}}toOne{{end}}(value)
+	}
+{{- end}}
+	return nil
+}
+
+// List{{$name}} lists the rows of {{label $table.Name}} matching the parameters,
+// like page[number], page[size], sort and query, with the total number of rows
+func (c *Client) List{{$name}}(ctx context.Context, params url.Values) ([]{{$name}}, int, error) {
+	var document listDocument
+	err := c.do(ctx, "GET", "/api/{{$table.Name}}", params, nil, &document)
+	if err != nil {
+		return nil, 0, err
+	}
+	rows := make([]{{$name}}, len(document.Data))
+	for i, object := range document.Data {
+		err = rows[i].fromResource(object)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return rows, listTotal(document), nil
+}
+
+// Get{{$name}} gets a row of {{label $table.Name}} by its id
+func (c *Client) Get{{$name}}(ctx context.Context, id string) (*{{$name}}, error) {
+	var document resourceDocument
+	err := c.do(ctx, "GET", "/api/{{$table.Name}}/"+url.PathEscape(id), nil, nil, &document)
+	if err != nil {
+		return nil, err
+	}
+	row := &{{$name}}{}
+	return row, row.fromResource(document.Data)
+}
+
+// Create{{$name}} creates a row of {{label $table.Name}}
+func (c *Client) Create{{$name}}(ctx context.Context, row *{{$name}}) (*{{$name}}, error) {
+	object, err := row.toResource()
+	if err != nil {
+		return nil, err
+	}
+	object.ID = ""
+	var document resourceDocument
+	err = c.do(ctx, "POST", "/api/{{$table.Name}}", nil, resourceDocument{Data: object}, &document)
+	if err != nil {
+		return nil, err
+	}
+	created := &{{$name}}{}
+	return created, created.fromResource(document.Data)
+}
+
+// Update{{$name}} updates a row of {{label $table.Name}}
+func (c *Client) Update{{$name}}(ctx context.Context, row *{{$name}}) (*{{$name}}, error) {
+	object, err := row.toResource()
+	if err != nil {
+		return nil, err
+	}
+	var document resourceDocument
+	err = c.do(ctx, "PATCH", "/api/{{$table.Name}}/"+url.PathEscape(row.ID), nil, resourceDocument{Data: object}, &document)
+	if err != nil {
+		return nil, err
+	}
+	updated := &{{$name}}{}
+	return updated, updated.fromResource(document.Data)
+}
+
+// Delete{{$name}} deletes a row of {{label $table.Name}}
+func (c *Client) Delete{{$name}}(ctx context.Context, id string) error {
+	return c.do(ctx, "DELETE", "/api/{{$table.Name}}/"+url.PathEscape(id), nil, nil, nil)
+}
+{{end}}
+{{- range $action := .Schema.Actions}}{{$name := print (name $action.Name) (name $action.OnType)}}
+// {{$name}}Input are the inputs of the {{$action.Name}} action on {{label $action.OnType}}
+type {{$name}}Input struct {
+{{- range $action.Fields}}
+	{{name .Name}} {{type .}} {{tag .}}
+{{- end}}
+}
+
+// Execute{{name $action.Name}}On{{name $action.OnType}} {{if $action.Label}}executes "{{$action.Label}}"{{else}}executes the {{$action.Name}} action{{end}}
+{{- if not $action.InstanceOptional}}, on the row of {{label $action.OnType}} with the id{{end}}
+func (c *Client) Execute{{name $action.Name}}On{{name $action.OnType}}(ctx context.Context{{if not $action.InstanceOptional}}, id string{{end}}, input {{$name}}Input) ([]ActionResponse, error) {
+	attributes, err := attributesOf(input)
+	if err != nil {
+		return nil, err
+	}
+{{- if not $action.InstanceOptional}}
+	attributes["{{$action.OnType}}_id"] = id
+{{- end}}
+	var responses []ActionResponse
+	err = c.do(ctx, "POST", "/action/{{$action.OnType}}/{{$action.Name}}", nil, map[string]interface{}{"attributes": attributes}, &responses)
+	return responses, err
+}
+{{end}}`))
+
+// GenerateGo generates the go client of the schema, as a single file of the
+// package
+func GenerateGo(schema Schema, packageName string) (string, error) {
+
+	var buffer bytes.Buffer
+	err := goTemplate.Execute(&buffer, map[string]interface{}{
+		"Schema":  schema,
+		"Package": packageName,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	source, err := format.Source(buffer.Bytes())
+	if err != nil {
+		return "", err
+	}
+	return string(source), nil
+}
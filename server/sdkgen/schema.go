@@ -0,0 +1,190 @@
+package sdkgen
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/graphql-go/graphql"
+	"sort"
+	"strings"
+)
+
+// Schema is the description of the tables and the actions of a server the
+// clients are generated from. The hash changes when any of them changes, a
+// client generated from another hash is stale.
+type Schema struct {
+	Hash    string   `json:"hash"`
+	Tables  []Table  `json:"tables"`
+	Actions []Action `json:"actions"`
+}
+
+// Field is a column of a table or an input of an action. The type is one of
+// string, integer, number, boolean and datetime.
+type Field struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable"`
+	ReadOnly bool   `json:"read_only"`
+}
+
+// Relation is a relationship of the rows of a table to the rows of another
+// table, to one row or to many rows
+type Relation struct {
+	Name   string `json:"name"`
+	Target string `json:"target"`
+	Many   bool   `json:"many"`
+}
+
+type Table struct {
+	Name      string     `json:"name"`
+	Fields    []Field    `json:"fields"`
+	Relations []Relation `json:"relations"`
+}
+
+type Action struct {
+	Name             string  `json:"name"`
+	Label            string  `json:"label"`
+	OnType           string  `json:"on_type"`
+	InstanceOptional bool    `json:"instance_optional"`
+	Fields           []Field `json:"fields"`
+}
+
+// BuildSchema describes the tables and the actions of the config, join tables
+// are left out
+func BuildSchema(config *resource.CmsConfig) Schema {
+
+	schema := Schema{
+		Tables:  make([]Table, 0),
+		Actions: make([]Action, 0),
+	}
+
+	tableNames := make(map[string]bool)
+	for _, tableInfo := range config.Tables {
+		if !tableInfo.IsJoinTable && !strings.Contains(tableInfo.TableName, "_has_") {
+			tableNames[tableInfo.TableName] = true
+		}
+	}
+
+	for _, tableInfo := range config.Tables {
+		if !tableNames[tableInfo.TableName] {
+			continue
+		}
+
+		table := Table{
+			Name:      tableInfo.TableName,
+			Fields:    make([]Field, 0),
+			Relations: make([]Relation, 0),
+		}
+
+		fieldNames := make(map[string]bool)
+		for _, column := range tableInfo.Columns {
+			// the id of a row is its reference id
+			if column.IsForeignKey || column.ColumnName == "id" || column.ColumnName == "reference_id" {
+				continue
+			}
+			standard := resource.IsStandardColumn(column.ColumnName)
+			table.Fields = append(table.Fields, Field{
+				Name:     column.ColumnName,
+				Type:     fieldType(column.ColumnType),
+				Nullable: column.IsNullable || standard,
+				ReadOnly: standard,
+			})
+			fieldNames[column.ColumnName] = true
+		}
+		for _, computedColumn := range tableInfo.ComputedColumns {
+			table.Fields = append(table.Fields, Field{
+				Name:     computedColumn.Name,
+				Type:     fieldType(computedColumn.GetColumnType()),
+				Nullable: true,
+				ReadOnly: true,
+			})
+			fieldNames[computedColumn.Name] = true
+		}
+
+		relationNames := make(map[string]bool)
+		for _, relation := range tableInfo.Relations {
+			tableRelation := tableRelation(tableInfo.TableName, relation)
+			if !tableNames[tableRelation.Target] || fieldNames[tableRelation.Name] || relationNames[tableRelation.Name] {
+				continue
+			}
+			relationNames[tableRelation.Name] = true
+			table.Relations = append(table.Relations, tableRelation)
+		}
+
+		schema.Tables = append(schema.Tables, table)
+	}
+	sort.Slice(schema.Tables, func(i, j int) bool {
+		return schema.Tables[i].Name < schema.Tables[j].Name
+	})
+
+	for _, actionInfo := range config.Actions {
+		action := Action{
+			Name:             actionInfo.Name,
+			Label:            actionInfo.Label,
+			OnType:           actionInfo.OnType,
+			InstanceOptional: actionInfo.InstanceOptional,
+			Fields:           make([]Field, 0),
+		}
+		for _, column := range actionInfo.InFields {
+			action.Fields = append(action.Fields, Field{
+				Name:     column.ColumnName,
+				Type:     fieldType(column.ColumnType),
+				Nullable: column.IsNullable,
+			})
+		}
+		schema.Actions = append(schema.Actions, action)
+	}
+	sort.SliceStable(schema.Actions, func(i, j int) bool {
+		if schema.Actions[i].OnType != schema.Actions[j].OnType {
+			return schema.Actions[i].OnType < schema.Actions[j].OnType
+		}
+		return schema.Actions[i].Name < schema.Actions[j].Name
+	})
+
+	schema.Hash = schema.ComputeHash()
+	return schema
+}
+
+// ComputeHash is the sha256 of the tables and the actions of the schema
+func (schema Schema) ComputeHash() string {
+	schema.Hash = ""
+	// the schema has no maps, the json is the same for the same schema
+	data, err := json.Marshal(schema)
+	resource.CheckErr(err, "Failed to serialize schema")
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// tableRelation is the relation as seen from the rows of the table, named as
+// it is in the relationships of the rows
+func tableRelation(tableName string, relation api2go.TableRelation) Relation {
+	if relation.Subject == tableName {
+		return Relation{
+			Name:   relation.GetObjectName(),
+			Target: relation.GetObject(),
+			Many:   relation.Relation == "has_many" || relation.Relation == "has_many_and_belongs_to_many",
+		}
+	}
+	return Relation{
+		Name:   relation.GetSubjectName(),
+		Target: relation.GetSubject(),
+		Many:   relation.Relation != "has_one",
+	}
+}
+
+// fieldType is the type of the values of a column type
+func fieldType(columnType string) string {
+	switch resource.ColumnManager.GetGraphqlType(columnType) {
+	case graphql.Int:
+		return "integer"
+	case graphql.Float:
+		return "number"
+	case graphql.Boolean:
+		return "boolean"
+	case graphql.DateTime:
+		return "datetime"
+	}
+	return "string"
+}
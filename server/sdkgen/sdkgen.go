@@ -0,0 +1,45 @@
+// Package sdkgen generates typed clients of the api of a daptin server, from
+// the description of its tables and actions
+package sdkgen
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// SchemaHashHeader is the header of the responses of the server with the hash
+// of its schema
+const SchemaHashHeader = "X-Daptin-Schema-Hash"
+
+var packageNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// npmPackageNamePattern is the name of an npm package, with an optional scope
+var npmPackageNamePattern = regexp.MustCompile(`^(@[a-z0-9~-][a-z0-9._~-]*/)?[a-z0-9~-][a-z0-9._~-]*$`)
+
+// Languages are the languages clients are generated in, with the name of the
+// file of the client
+var Languages = map[string]string{
+	"go":         "client.go",
+	"typescript": "client.ts",
+}
+
+// Generate generates the client of the schema in the language, the package
+// name is used by the languages which have packages
+func Generate(schema Schema, language string, packageName string) (string, error) {
+	switch language {
+	case "go":
+		if packageName == "" {
+			packageName = "daptin"
+		}
+		if !packageNamePattern.MatchString(packageName) {
+			return "", fmt.Errorf("invalid go package name [%v]", packageName)
+		}
+		return GenerateGo(schema, packageName)
+	case "typescript", "ts":
+		if packageName != "" && (len(packageName) > 214 || !npmPackageNamePattern.MatchString(packageName)) {
+			return "", fmt.Errorf("invalid npm package name [%v]", packageName)
+		}
+		return GenerateTypescript(schema)
+	}
+	return "", fmt.Errorf("no client generator for [%v], generators are for go and typescript", language)
+}
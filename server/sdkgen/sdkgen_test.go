@@ -0,0 +1,158 @@
+package sdkgen
+
+import (
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/resource"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+	"testing"
+)
+
+func sdkTestConfig() *resource.CmsConfig {
+
+	bookRelation := api2go.NewTableRelation("book", "belongs_to", "author")
+	columns := func(extra ...api2go.ColumnInfo) []api2go.ColumnInfo {
+		return append(append([]api2go.ColumnInfo{}, resource.StandardColumns...), extra...)
+	}
+
+	return &resource.CmsConfig{
+		Tables: []resource.TableInfo{
+			{
+				TableName: "book",
+				Columns: columns(
+					api2go.ColumnInfo{ColumnName: "title", ColumnType: "label"},
+					api2go.ColumnInfo{ColumnName: "pages", ColumnType: "measurement", IsNullable: true},
+					api2go.ColumnInfo{ColumnName: "published", ColumnType: "truefalse"},
+					api2go.ColumnInfo{ColumnName: "type", ColumnType: "label"},
+					api2go.ColumnInfo{ColumnName: "published_at", ColumnType: "datetime", IsNullable: true},
+					api2go.ColumnInfo{ColumnName: "author_id", ColumnType: "alias", IsForeignKey: true},
+				),
+				Relations: []api2go.TableRelation{bookRelation},
+			},
+			{
+				TableName: "author",
+				Columns:   columns(api2go.ColumnInfo{ColumnName: "name", ColumnType: "label"}),
+				Relations: []api2go.TableRelation{bookRelation},
+			},
+		},
+		Actions: []resource.Action{
+			{
+				Name:   "publish",
+				Label:  "Publish the book",
+				OnType: "book",
+				InFields: []api2go.ColumnInfo{
+					{ColumnName: "edition", ColumnType: "measurement"},
+					{ColumnName: "func", ColumnType: "label"},
+				},
+			},
+		},
+	}
+}
+
+// checkGoClient parses and type checks the generated go client
+func checkGoClient(t *testing.T, source string) {
+
+	fileSet := token.NewFileSet()
+	file, err := parser.ParseFile(fileSet, "client.go", source, 0)
+	if err != nil {
+		t.Fatalf("Failed to parse the go client: %v", err)
+	}
+
+	config := types.Config{Importer: importer.ForCompiler(fileSet, "source", nil)}
+	if _, err = config.Check(file.Name.Name, fileSet, []*ast.File{file}, nil); err != nil {
+		t.Fatalf("Failed to type check the go client: %v", err)
+	}
+}
+
+func TestGenerateClients(t *testing.T) {
+
+	resource.InitialiseColumnManager()
+	config := sdkTestConfig()
+	schema := BuildSchema(config)
+
+	if len(schema.Tables) != 2 || schema.Tables[0].Name != "author" {
+		t.Fatalf("Expected the tables in order of their names, got %v", schema.Tables)
+	}
+	book := schema.Tables[1]
+	fieldTypes := make(map[string]string)
+	for _, field := range book.Fields {
+		fieldTypes[field.Name] = field.Type
+	}
+	if fieldTypes["pages"] != "integer" || fieldTypes["published"] != "boolean" || fieldTypes["title"] != "string" {
+		t.Errorf("Unexpected field types %v", fieldTypes)
+	}
+	if _, ok := fieldTypes["author_id"]; ok {
+		t.Errorf("Expected the foreign key to be a relation and not a field")
+	}
+	if len(book.Relations) != 1 || book.Relations[0].Name != "author_id" || book.Relations[0].Many {
+		t.Errorf("Expected the book to belong to one author, got %v", book.Relations)
+	}
+	if author := schema.Tables[0]; len(author.Relations) != 1 || !author.Relations[0].Many {
+		t.Errorf("Expected the author to have many books, got %v", author.Relations)
+	}
+
+	goClient, err := Generate(schema, "go", "books")
+	if err != nil {
+		t.Fatalf("Failed to generate go client: %v", err)
+	}
+	checkGoClient(t, goClient)
+	for _, expected := range []string{
+		"package books",
+		`const SchemaHash = "` + schema.Hash + `"`,
+		"func (c *Client) ListBook(ctx context.Context, params url.Values) ([]Book, int, error)",
+		"row.AuthorId = toOne(value)",
+		"func (c *Client) ExecutePublishOnBook(ctx context.Context, id string, input PublishBookInput) ([]ActionResponse, error)",
+	} {
+		if !strings.Contains(goClient, expected) {
+			t.Errorf("Expected the go client to have [%v]", expected)
+		}
+	}
+
+	tsClient, err := Generate(schema, "typescript", "")
+	if err != nil {
+		t.Fatalf("Failed to generate typescript client: %v", err)
+	}
+	for _, expected := range []string{
+		`export const SCHEMA_HASH = "` + schema.Hash + `";`,
+		"export interface Book {",
+		"pages?: number | null;",
+		"async executePublishOnBook(id: string, input: PublishBookInput): Promise<ActionResponse[]>",
+	} {
+		if !strings.Contains(tsClient, expected) {
+			t.Errorf("Expected the typescript client to have [%v]", expected)
+		}
+	}
+
+	if _, err := Generate(schema, "cobol", ""); err == nil {
+		t.Errorf("Expected an error for a language without a generator")
+	}
+	for _, test := range []struct {
+		language    string
+		packageName string
+		valid       bool
+	}{
+		{"go", "books_client", true},
+		{"go", "Books", false},
+		{"go", "books-client", false},
+		{"go", "books\nfunc", false},
+		{"typescript", "@acme/books-client", true},
+		{"typescript", "Books Client", false},
+		{"typescript", strings.Repeat("b", 215), false},
+	} {
+		if _, err := Generate(schema, test.language, test.packageName); (err == nil) != test.valid {
+			t.Errorf("Expected the %v package name [%v] to be valid: %v, got %v", test.language, test.packageName, test.valid, err)
+		}
+	}
+
+	if BuildSchema(sdkTestConfig()).Hash != schema.Hash {
+		t.Errorf("Expected the same hash for the same schema")
+	}
+	config.Tables[0].Columns = append(config.Tables[0].Columns, api2go.ColumnInfo{ColumnName: "isbn", ColumnType: "label"})
+	if BuildSchema(config).Hash == schema.Hash {
+		t.Errorf("Expected another hash after adding a column")
+	}
+}
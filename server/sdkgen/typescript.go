@@ -0,0 +1,333 @@
+package sdkgen
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+var tsIdentifierPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// tsReservedNames are the types of the client, rows of the tables with these
+// names are named with a Row suffix
+var tsReservedNames = map[string]bool{
+	"Client":         true,
+	"ClientOptions":  true,
+	"Reference":      true,
+	"ActionResponse": true,
+	"ErrorObject":    true,
+	"DaptinError":    true,
+}
+
+// tsRowName is the typescript interface of the rows of a table
+func tsRowName(tableName string) string {
+	name := goName(tableName)
+	if tsReservedNames[name] {
+		return name + "Row"
+	}
+	return name
+}
+
+// tsProperty is the name of a property, quoted when it is not an identifier
+func tsProperty(name string) string {
+	if tsIdentifierPattern.MatchString(name) {
+		return name
+	}
+	return strconv.Quote(name)
+}
+
+// tsType is the typescript type of the values of a field
+func tsType(field Field) string {
+	typeName := "string"
+	switch field.Type {
+	case "integer", "number":
+		typeName = "number"
+	case "boolean":
+		typeName = "boolean"
+	}
+	if field.Nullable {
+		return typeName + " | null"
+	}
+	return typeName
+}
+
+var tsTemplate = template.Must(template.New("typescript").Funcs(template.FuncMap{
+	"name":     goName,
+	"row":      tsRowName,
+	"property": tsProperty,
+	"type":     tsType,
+	"quote":    strconv.Quote,
+	"readOnly": readOnlyFields,
+	"label": func(name string) string {
+		return strings.ReplaceAll(name, "_", " ")
+	},
+}).Parse(`// Code generated by daptin from the schema {{.Hash}}. DO NOT EDIT.
+
+/** the hash of the schema the client was generated from */
+export const SCHEMA_HASH = "{{.Hash}}";
+
+/** the header of the responses with the hash of the schema of the server */
+export const SCHEMA_HASH_HEADER = "X-Daptin-Schema-Hash";
+
+/** a related row */
+export interface Reference {
+  type: string;
+  id: string;
+}
+
+/** an outcome of an action */
+export interface ActionResponse {
+  ResponseType: string;
+  Attributes: any;
+}
+
+/** an error of a failed request */
+export interface ErrorObject {
+  status?: string;
+  code?: string;
+  title?: string;
+  detail?: string;
+}
+
+/** a failed request */
+export class DaptinError extends Error {
+  constructor(public statusCode: number, public errors: ErrorObject[], public body: string) {
+    super(statusCode + ": " + (errors.length > 0 ? errors[0].detail || errors[0].title : body));
+  }
+}
+
+type FieldType = "string" | "integer" | "number" | "boolean" | "datetime";
+
+interface ResourceObject {
+  type: string;
+  id?: string;
+  attributes?: { [name: string]: any };
+  relationships?: { [name: string]: { data: Reference | Reference[] | null } };
+}
+
+interface TypeDescription {
+  fields: { [name: string]: FieldType };
+  readOnly: string[];
+  relations: { [name: string]: boolean };
+}
+
+/** the fields and the relations of the tables, relations to many rows are true */
+const types: { [type: string]: TypeDescription } = {
+{{- range $table := .Tables}}
+  {{quote $table.Name}}: {
+    fields: { {{- range $i, $field := $table.Fields}}{{if $i}},{{end}} {{quote $field.Name}}: {{quote $field.Type}}{{end}} },
+    readOnly: [{{range $i, $field := readOnly $table.Fields}}{{if $i}}, {{end}}{{quote $field.Name}}{{end}}],
+    relations: { {{- range $i, $relation := $table.Relations}}{{if $i}},{{end}} {{quote $relation.Name}}: {{$relation.Many}}{{end}} },
+  },
+{{- end}}
+};
+
+/** converts values of other types than the ones of the fields, like booleans stored as numbers */
+function convertValue(value: any, fieldType: FieldType | undefined): any {
+  if (value === null || value === undefined) {
+    return value;
+  }
+  switch (fieldType) {
+    case "boolean":
+      if (typeof value === "number") {
+        return value !== 0;
+      }
+      if (typeof value === "string") {
+        return value === "true" || value === "1";
+      }
+      break;
+    case "integer":
+    case "number":
+      if (typeof value === "string") {
+        const num = Number(value);
+        return isNaN(num) ? null : fieldType === "integer" ? Math.trunc(num) : num;
+      }
+      break;
+    case "string":
+    case "datetime":
+      if (typeof value !== "string") {
+        return String(value);
+      }
+      break;
+  }
+  return value;
+}
+
+/** reads a row from a JSON:API resource object */
+export function deserialize<T>(object: ResourceObject): T {
+  const description = types[object.type] || { fields: {}, readOnly: [], relations: {} };
+  const row: { [name: string]: any } = { id: object.id };
+  const attributes = object.attributes || {};
+  for (const name of Object.keys(attributes)) {
+    if (name === "id" || name === "reference_id" || description.relations[name] !== undefined) {
+      continue;
+    }
+    row[name] = convertValue(attributes[name], description.fields[name]);
+  }
+  const relationships = object.relationships || {};
+  for (const name of Object.keys(description.relations)) {
+    const relationship = relationships[name];
+    if (relationship && relationship.data !== undefined) {
+      row[name] = relationship.data;
+    }
+  }
+  return row as unknown as T;
+}
+
+/** writes a row as a JSON:API resource object, without the fields which cannot be set */
+export function serialize(type: string, row: object): ResourceObject {
+  const values = row as { [name: string]: any };
+  const description = types[type] || { fields: {}, readOnly: [], relations: {} };
+  const object: ResourceObject = { type: type, attributes: {}, relationships: {} };
+  if (values.id) {
+    object.id = values.id;
+  }
+  for (const name of Object.keys(values)) {
+    if (name === "id" || description.readOnly.indexOf(name) > -1 || values[name] === undefined) {
+      continue;
+    }
+    if (description.relations[name] !== undefined) {
+      object.relationships![name] = { data: values[name] };
+    } else {
+      object.attributes![name] = values[name];
+    }
+  }
+  return object;
+}
+{{range $table := .Tables}}
+/** a row of {{$table.Name}} */
+export interface {{row $table.Name}} {
+  id: string;
+{{- range $table.Fields}}
+  {{property .Name}}{{if .Nullable}}?{{end}}: {{type .}};
+{{- end}}
+{{- range $table.Relations}}
+  {{property .Name}}?: {{if .Many}}Reference[]{{else}}Reference | null{{end}};
+{{- end}}
+}
+{{end}}
+{{- range $action := .Actions}}
+/** the inputs of the {{$action.Name}} action on {{label $action.OnType}} */
+export interface {{name $action.Name}}{{name $action.OnType}}Input {
+{{- range $action.Fields}}
+  {{property .Name}}{{if .Nullable}}?{{end}}: {{type .}};
+{{- end}}
+}
+{{end}}
+export interface ClientOptions {
+  /** the token sent as the bearer of the requests */
+  token?: string;
+  /** the fetch function, the global one by default */
+  fetch?: (input: string, init?: any) => Promise<any>;
+  /** called with the hash of the schema of the server when it is not the one the client was generated from */
+  onStaleSchema?: (serverHash: string) => void;
+}
+
+/** calls the api of a daptin server */
+export class Client {
+  constructor(public baseUrl: string, public options: ClientOptions = {}) {
+    this.baseUrl = baseUrl.replace(/\/+$/, "");
+  }
+
+  private async request<T>(method: string, path: string, query?: { [name: string]: string }, body?: any): Promise<T> {
+    let url = this.baseUrl + path;
+    if (query && Object.keys(query).length > 0) {
+      url += "?" + Object.keys(query).map(key => encodeURIComponent(key) + "=" + encodeURIComponent(query![key])).join("&");
+    }
+    const headers: { [name: string]: string } = {
+      "Content-Type": path.indexOf("/api/") === 0 ? "application/vnd.api+json" : "application/json",
+    };
+    if (path.indexOf("/api/") === 0) {
+      headers["Accept"] = "application/vnd.api+json";
+    }
+    if (this.options.token) {
+      headers["Authorization"] = "Bearer " + this.options.token;
+    }
+    const fetchFunction = this.options.fetch || (globalThis as any).fetch;
+    const response = await fetchFunction(url, {
+      method: method,
+      headers: headers,
+      body: body === undefined ? undefined : JSON.stringify(body),
+    });
+
+    const serverHash = response.headers.get(SCHEMA_HASH_HEADER);
+    if (serverHash && serverHash !== SCHEMA_HASH && this.options.onStaleSchema) {
+      this.options.onStaleSchema(serverHash);
+    }
+
+    const text = await response.text();
+    if (response.status >= 400) {
+      let errors: ErrorObject[] = [];
+      try {
+        errors = JSON.parse(text).errors || [];
+      } catch (e) {
+        // not a JSON:API error
+      }
+      throw new DaptinError(response.status, errors, text);
+    }
+    return (text.length > 0 ? JSON.parse(text) : undefined) as T;
+  }
+
+  /** tells if the server has the schema the client was generated from */
+  async checkSchema(): Promise<boolean> {
+    const schema = await this.request<{ hash: string }>("GET", "/sdk/schema");
+    return schema.hash === SCHEMA_HASH;
+  }
+{{range $table := .Tables}}{{$name := row $table.Name}}
+  /** lists the rows of {{label $table.Name}} matching the parameters, like page[number], page[size], sort and query, with the total number of rows */
+  async list{{name $table.Name}}(params: { [name: string]: string } = {}): Promise<{ rows: {{$name}}[]; total: number }> {
+    const document = await this.request<{ data: ResourceObject[]; links?: { total?: number } }>("GET", "/api/{{$table.Name}}", params);
+    const rows = (document.data || []).map(object => deserialize<{{$name}}>(object));
+    return { rows: rows, total: document.links && document.links.total !== undefined ? document.links.total : rows.length };
+  }
+
+  /** gets a row of {{label $table.Name}} by its id */
+  async get{{name $table.Name}}(id: string): Promise<{{$name}}> {
+    const document = await this.request<{ data: ResourceObject }>("GET", "/api/{{$table.Name}}/" + encodeURIComponent(id));
+    return deserialize<{{$name}}>(document.data);
+  }
+
+  /** creates a row of {{label $table.Name}} */
+  async create{{name $table.Name}}(row: Partial<{{$name}}>): Promise<{{$name}}> {
+    const object = serialize("{{$table.Name}}", row);
+    delete object.id;
+    const document = await this.request<{ data: ResourceObject }>("POST", "/api/{{$table.Name}}", undefined, { data: object });
+    return deserialize<{{$name}}>(document.data);
+  }
+
+  /** updates a row of {{label $table.Name}} */
+  async update{{name $table.Name}}(row: Partial<{{$name}}> & { id: string }): Promise<{{$name}}> {
+    const document = await this.request<{ data: ResourceObject }>("PATCH", "/api/{{$table.Name}}/" + encodeURIComponent(row.id), undefined, { data: serialize("{{$table.Name}}", row) });
+    return deserialize<{{$name}}>(document.data);
+  }
+
+  /** deletes a row of {{label $table.Name}} */
+  async delete{{name $table.Name}}(id: string): Promise<void> {
+    await this.request<void>("DELETE", "/api/{{$table.Name}}/" + encodeURIComponent(id));
+  }
+{{end}}
+{{- range $action := .Actions}}
+  /** {{if $action.Label}}executes "{{$action.Label}}"{{else}}executes the {{$action.Name}} action{{end}}{{if not $action.InstanceOptional}}, on the row of {{label $action.OnType}} with the id{{end}} */
+  async execute{{name $action.Name}}On{{name $action.OnType}}({{if not $action.InstanceOptional}}id: string, {{end}}input: {{name $action.Name}}{{name $action.OnType}}Input): Promise<ActionResponse[]> {
+    const attributes: { [name: string]: any } = Object.assign({}, input);
+{{- if not $action.InstanceOptional}}
+    attributes[{{quote (print $action.OnType "_id")}}] = id;
+{{- end}}
+    return this.request<ActionResponse[]>("POST", "/action/{{$action.OnType}}/{{$action.Name}}", undefined, { attributes: attributes });
+  }
+{{end}}}
+`))
+
+// GenerateTypescript generates the typescript client of the schema, as a
+// single module
+func GenerateTypescript(schema Schema) (string, error) {
+
+	var buffer bytes.Buffer
+	err := tsTemplate.Execute(&buffer, schema)
+	if err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
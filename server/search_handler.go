@@ -0,0 +1,111 @@
+package server
+
+import (
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CreateSearchHandler searches the searchable columns of all entities, or of
+// the entities listed in types, and returns the matching rows ordered by
+// relevance. Rows the user cannot read are not included.
+//
+//	GET /search?q=invoice "due date" pay*&types=order,customer&limit=20
+func CreateSearchHandler(initConfig *resource.CmsConfig, cruds map[string]*resource.DbResource) func(*gin.Context) {
+
+	return func(c *gin.Context) {
+
+		query := strings.TrimSpace(c.Query("q"))
+		if query == "" {
+			c.JSON(400, resource.NewDaptinError("q is required", "invalid query"))
+			return
+		}
+
+		limit := 20
+		if c.Query("limit") != "" {
+			var err error
+			limit, err = strconv.Atoi(c.Query("limit"))
+			if err != nil || limit < 1 {
+				c.JSON(400, resource.NewDaptinError("Invalid limit", "invalid limit"))
+				return
+			}
+			if limit > 100 {
+				limit = 100
+			}
+		}
+
+		types := make(map[string]bool)
+		for _, typeNames := range c.QueryArray("types") {
+			for _, typeName := range strings.Split(typeNames, ",") {
+				if typeName = strings.TrimSpace(typeName); typeName != "" {
+					types[typeName] = true
+				}
+			}
+		}
+
+		var sessionUser *auth.SessionUser
+		user := c.Request.Context().Value("user")
+		if user != nil {
+			sessionUser = user.(*auth.SessionUser)
+		}
+		isAdmin := sessionUser != nil && sessionUser.UserReferenceId == cruds[resource.USER_ACCOUNT_TABLE_NAME].GetAdminReferenceId()
+
+		results := make([]resource.SearchResult, 0)
+		for _, table := range initConfig.Tables {
+
+			if len(types) > 0 && !types[table.TableName] {
+				continue
+			}
+			dbResource, ok := cruds[table.TableName]
+			if !ok || len(table.SearchableColumns) == 0 {
+				continue
+			}
+
+			if !isAdmin {
+				perm := dbResource.GetObjectPermissionByWhereClause("world", "table_name", table.TableName)
+				if sessionUser == nil || !perm.CanRead(sessionUser.UserReferenceId, sessionUser.Groups) {
+					continue
+				}
+			}
+
+			tableResults, err := dbResource.FullTextSearch(query, sessionUser, limit)
+			if err != nil {
+				log.Errorf("Failed to search [%v]: %v", table.TableName, err)
+				continue
+			}
+			results = append(results, tableResults...)
+		}
+
+		// the ranks count the matching words, the same way for every table
+		sort.SliceStable(results, func(i, j int) bool {
+			return results[i].Rank > results[j].Rank
+		})
+		if len(results) > limit {
+			results = results[:limit]
+		}
+
+		data := make([]map[string]interface{}, 0)
+		for _, result := range results {
+			data = append(data, map[string]interface{}{
+				"type":       result.Type,
+				"id":         result.ReferenceId,
+				"attributes": result.Attributes,
+				"meta": map[string]interface{}{
+					"rank":    result.Rank,
+					"snippet": result.Snippet,
+				},
+			})
+		}
+
+		c.JSON(200, map[string]interface{}{
+			"data": data,
+			"meta": map[string]interface{}{
+				"query": query,
+			},
+		})
+	}
+}
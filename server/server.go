@@ -1,9 +1,9 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"net"
 	"os"
 	"strings"
 	"time"
@@ -225,7 +225,17 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection) (HostSwitch,
 	auth.InitJwtMiddleware([]byte(jwtSecret), jwtTokenIssuer)
 	defaultRouter.Use(authMiddleware.AuthCheckMiddleware)
 
+	// resources set headers like ETag on the response through the request context
+	defaultRouter.Use(func(c *gin.Context) {
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), "response_header", c.Writer.Header()))
+	})
+
 	cruds := make(map[string]*resource.DbResource)
+	defaultRouter.Use(NewRequestValidator(&initConfig, configStore, cruds).RequestValidationMiddlewareFunc)
+	defaultRouter.Use(NewIdempotencyMiddleware(configStore, cruds).IdempotencyMiddlewareFunc)
+
+	sdkGenerator := NewSdkGenerator(&initConfig)
+	defaultRouter.Use(sdkGenerator.SchemaHashMiddlewareFunc)
 	defaultRouter.GET("/actions", resource.CreateGuestActionListHandler(&initConfig))
 
 	api := api2go.NewAPIWithRouting(
@@ -234,7 +244,8 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection) (HostSwitch,
 		gingonic.New(defaultRouter),
 	)
 
-	ms := BuildMiddlewareSet(&initConfig, &cruds)
+	eventBroker := resource.NewEntityEventBroker()
+	ms := BuildMiddlewareSet(&initConfig, &cruds, eventBroker)
 	cruds = AddResourcesToApi2Go(api, initConfig.Tables, db, &ms, configStore, cruds)
 
 	rcloneRetries, err := configStore.GetConfigIntValueFor("rclone.retries", "backend")
@@ -284,6 +295,9 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection) (HostSwitch,
 		imapServer.Debug = nil
 		imapServer.AllowInsecureAuth = false
 		imapServer.Enable(idle.NewExtension())
+		imapServer.Enable(resource.NewImapMoveExtension())
+		imapServer.Enable(resource.NewImapQuotaExtension())
+		imapServer.Enable(resource.NewImapCondStoreExtension())
 		imapServer.Debug = os.Stdout
 		//imapServer.EnableAuth("CRAM-MD5", func(conn server.Conn) sasl.Server {
 		//
@@ -350,6 +364,32 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection) (HostSwitch,
 		Schedule:    "@every 1h",
 	})
 
+	backupSchedule, err := configStore.GetConfigValueFor("backup.schedule", "backend")
+	if err != nil {
+		configStore.SetConfigValueFor("backup.schedule", "", "backend")
+	}
+	if backupSchedule != "" {
+		backupCloudStore, _ := configStore.GetConfigValueFor("backup.cloud_store", "backend")
+		backupPath, _ := configStore.GetConfigValueFor("backup.path", "backend")
+		backupPassword, _ := configStore.GetConfigValueFor("backup.password", "backend")
+		if backupCloudStore == "" {
+			log.Errorf("Backup schedule [%v] is set without a backup.cloud_store to upload to", backupSchedule)
+		} else {
+			err = TaskScheduler.AddTask(resource.Task{
+				EntityName: "world",
+				ActionName: "backup",
+				Attributes: map[string]interface{}{
+					"cloud_store_name": backupCloudStore,
+					"path":             backupPath,
+					"password":         backupPassword,
+				},
+				AsUserEmail: cruds[resource.USER_ACCOUNT_TABLE_NAME].GetAdminEmailId(),
+				Schedule:    backupSchedule,
+			})
+			resource.CheckErr(err, "Failed to schedule backup")
+		}
+	}
+
 	TaskScheduler.StartTasks()
 
 	assetColumnFolders := CreateAssetColumnSync(cruds)
@@ -379,9 +419,7 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection) (HostSwitch,
 			err = configStore.SetConfigValueFor("ftp.listen_interface", ftp_interface, "backend")
 			resource.CheckErr(err, "Failed to store default value for ftp.listen_interface")
 		}
-		// ftpListener, err := net.Listen("tcp", ftp_interface)
-		// resource.CheckErr(err, "Failed to create listener for FTP")
-		ftpServer, err = CreateFtpServers(cruds, certificateManager, nil)
+		ftpServer, err = CreateFtpServers(cruds, certificateManager, ftp_interface)
 		auth.CheckErr(err, "Failed to creat FTP server")
 		go func() {
 			err = ftpServer.ListenAndServe()
@@ -389,6 +427,31 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection) (HostSwitch,
 		}()
 	}
 
+	enableSftp, err := configStore.GetConfigValueFor("sftp.enable", "backend")
+	if err != nil {
+		enableSftp = "false"
+		err = configStore.SetConfigValueFor("sftp.enable", enableSftp, "backend")
+		auth.CheckErr(err, "Failed to store default value for sftp.enable")
+	}
+
+	if enableSftp == "true" {
+
+		sftpInterface, err := configStore.GetConfigValueFor("sftp.listen_interface", "backend")
+		if err != nil {
+			sftpInterface = "0.0.0.0:2222"
+			err = configStore.SetConfigValueFor("sftp.listen_interface", sftpInterface, "backend")
+			resource.CheckErr(err, "Failed to store default value for sftp.listen_interface")
+		}
+		sftpServer, err := CreateSftpServer(cruds, certificateManager, sftpInterface)
+		auth.CheckErr(err, "Failed to create SFTP server")
+		if err == nil {
+			go func() {
+				err := sftpServer.ListenAndServe()
+				resource.CheckErr(err, "Failed to listen at sftp interface")
+			}()
+		}
+	}
+
 	defaultRouter.GET("/ping", func(c *gin.Context) {
 		c.String(200, "pong")
 	})
@@ -406,6 +469,11 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection) (HostSwitch,
 	feedHandler := CreateFeedHandler(cruds, streamProcessors)
 	defaultRouter.GET("/feed/:feedname", feedHandler)
 
+	defaultRouter.GET("/export/:typename", CreateExportHandler(cruds))
+
+	AddDavRoutes(defaultRouter, cruds, authMiddleware)
+	AddWebDavRoutes(defaultRouter, cruds, authMiddleware)
+
 	configHandler := CreateConfigHandler(&initConfig, cruds, configStore)
 	defaultRouter.GET("/_config/:end/:key", configHandler)
 	defaultRouter.GET("/_config", configHandler)
@@ -418,41 +486,56 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection) (HostSwitch,
 
 	if initConfig.EnableGraphQL {
 
-		// TODO: add state machine change api available as graphql
-		graphqlSchema := MakeGraphqlSchema(&initConfig, cruds)
+		graphqlSchema := MakeGraphqlSchema(&initConfig, cruds, fsmManager, db)
+
+		graphqlLimits := NewGraphqlLimits(configStore, graphqlSchema)
 
-		graphqlHttpHandler := graphqlhandler.New(&graphqlhandler.Config{
+		graphqlHttpHandler := graphqlLimits.Handler(graphqlhandler.New(&graphqlhandler.Config{
 			Schema:   graphqlSchema,
 			Pretty:   true,
-			GraphiQL: true,
-		})
+			GraphiQL: graphqlLimits.GraphiQL,
+		}))
 
-		// serve HTTP
+		graphqlSubscriptionHandler := CreateGraphqlSubscriptionHandler(graphqlSchema, graphqlLimits, eventBroker, authMiddleware)
+
+		// serve HTTP, and subscriptions over a websocket
 		defaultRouter.Handle("GET", "/graphql", func(c *gin.Context) {
-			graphqlHttpHandler.ServeHTTP(c.Writer, c.Request)
+			if strings.EqualFold(c.GetHeader("Upgrade"), "websocket") {
+				graphqlSubscriptionHandler(c)
+				return
+			}
+			graphqlHttpHandler(c)
 		})
 		// serve HTTP
 		defaultRouter.Handle("POST", "/graphql", func(c *gin.Context) {
-			graphqlHttpHandler.ServeHTTP(c.Writer, c.Request)
+			graphqlHttpHandler(c)
 		})
 		// serve HTTP
 		defaultRouter.Handle("PUT", "/graphql", func(c *gin.Context) {
-			graphqlHttpHandler.ServeHTTP(c.Writer, c.Request)
+			graphqlHttpHandler(c)
 		})
 		// serve HTTP
 		defaultRouter.Handle("PATCH", "/graphql", func(c *gin.Context) {
-			graphqlHttpHandler.ServeHTTP(c.Writer, c.Request)
+			graphqlHttpHandler(c)
 		})
 		// serve HTTP
 		defaultRouter.Handle("DELETE", "/graphql", func(c *gin.Context) {
-			graphqlHttpHandler.ServeHTTP(c.Writer, c.Request)
+			graphqlHttpHandler(c)
 		})
 	}
 
 	defaultRouter.GET("/jsmodel/:typename", handler)
 	defaultRouter.GET("/stats/:typename", statsHandler)
+	defaultRouter.GET("/search", CreateSearchHandler(&initConfig, cruds))
+	defaultRouter.GET("/geojson/:typename", CreateGeoJsonHandler(&initConfig, cruds))
+	if _, ok := cruds["operations"]; ok {
+		log.Errorf("Not serving atomic operations at /api/operations, there is a table named operations")
+	} else {
+		defaultRouter.POST("/api/operations", CreateAtomicOperationsHandler(cruds, db))
+	}
 	defaultRouter.GET("/meta", metaHandler)
 	defaultRouter.GET("/openapi.yaml", blueprintHandler)
+	defaultRouter.GET("/sdk/:language", sdkGenerator.SdkHandler)
 	defaultRouter.GET("/recline_model", modelHandler)
 	defaultRouter.OPTIONS("/jsmodel/:typename", handler)
 	defaultRouter.OPTIONS("/openapi.yaml", blueprintHandler)
@@ -509,22 +592,13 @@ func Main(boxRoot http.FileSystem, db database.DatabaseConnection) (HostSwitch,
 
 }
 
-func CreateFtpServers(resources map[string]*resource.DbResource, certManager *resource.CertificateManager, listener net.Listener) (*server2.FtpServer, error) {
+// GetFtpSites returns the sites with ftp enabled along with their local folders
+func GetFtpSites(resources map[string]*resource.DbResource) ([]SubSiteAssetCache, error) {
 
 	subsites, err := resources["ftp_server"].GetAllSites()
 	if err != nil {
 		return nil, err
 	}
-	cloudStores, err := resources["cloud_store"].GetAllCloudStores()
-
-	if err != nil {
-		return nil, err
-	}
-	cloudStoreMap := make(map[string]resource.CloudStore)
-	for _, cloudStore := range cloudStores {
-		cloudStoreMap[cloudStore.ReferenceId] = cloudStore
-	}
-	var driver *DaptinFtpDriver
 
 	sites := make([]SubSiteAssetCache, 0)
 	for _, ftpServer := range subsites {
@@ -544,10 +618,18 @@ func CreateFtpServers(resources map[string]*resource.DbResource, certManager *re
 		sites = append(sites, site)
 
 	}
+	return sites, nil
+}
+
+func CreateFtpServers(resources map[string]*resource.DbResource, certManager *resource.CertificateManager, listenInterface string) (*server2.FtpServer, error) {
+
+	sites, err := GetFtpSites(resources)
+	if err != nil {
+		return nil, err
+	}
 
-	driver, err = NewDaptinFtpDriver(resources, certManager, sites)
-	driver.DaptinFtpServerSettings.Server.Listener = listener
-	driver.DaptinFtpServerSettings.Server.ListenAddr = "0.0.0.0:2121"
+	driver, err := NewDaptinFtpDriver(resources, certManager, sites)
+	driver.DaptinFtpServerSettings.Server.ListenAddr = listenInterface
 	ftpS := server2.NewFtpServer(driver)
 	resource.CheckErr(err, "Failed to create daptin ftp driver [%v]", driver)
 	return ftpS, err
@@ -596,6 +678,7 @@ func initialiseResources(initConfig *resource.CmsConfig, db database.DatabaseCon
 	resource.CheckRelations(initConfig)
 	resource.CheckAuditTables(initConfig)
 	resource.CheckTranslationTables(initConfig)
+	resource.CheckComputedColumns(initConfig)
 	//AddStateMachines(&initConfig, db)
 
 	var errc error
@@ -635,6 +718,10 @@ func initialiseResources(initConfig *resource.CmsConfig, db database.DatabaseCon
 	}
 	resource.CheckErr(errc, "Failed to commit transaction after creating indexes")
 
+	resource.CreateFullTextIndexes(initConfig, db)
+	resource.CreateJsonPathIndexes(initConfig, db)
+	resource.CreateGeoIndexes(initConfig, db)
+
 	tx, errb = db.Beginx()
 	resource.CheckErr(errb, "Failed to begin transaction")
 
@@ -657,6 +744,43 @@ func initialiseResources(initConfig *resource.CmsConfig, db database.DatabaseCon
 
 }
 
+// LoadDbResources checks the tables of the instance as the server does when
+// it starts, and returns the resources of the tables without starting the
+// apis and the services, for commands which work on the database directly
+func LoadDbResources(db database.DatabaseConnection) (map[string]*resource.DbResource, error) {
+
+	initConfig, errs := LoadConfigFiles()
+	for _, err := range errs {
+		log.Errorf("Failed to load config indexFile: %v", err)
+	}
+
+	existingTables, _ := GetTablesFromWorld(db)
+	initConfig.Tables = MergeTables(existingTables, initConfig.Tables)
+
+	config.LoadConfig()
+	fs.Config.DryRun = false
+	fs.Config.LogLevel = 200
+	fs.Config.StatsLogLevel = 200
+
+	initialiseResources(&initConfig, db)
+
+	configStore, err := resource.NewConfigStore(db)
+	if err != nil {
+		return nil, err
+	}
+
+	ms := &resource.MiddlewareSet{}
+	cruds := make(map[string]*resource.DbResource)
+	for _, table := range initConfig.Tables {
+		if table.TableName == "" {
+			continue
+		}
+		model := api2go.NewApi2GoModel(table.TableName, table.Columns, int64(table.DefaultPermission), table.Relations)
+		cruds[table.TableName] = resource.NewDbResource(model, db, ms, cruds, configStore, table)
+	}
+	return cruds, nil
+}
+
 func actionPerformersListToMap(interfaces []resource.ActionPerformerInterface) map[string]resource.ActionPerformerInterface {
 	m := make(map[string]resource.ActionPerformerInterface)
 
@@ -742,6 +866,10 @@ func MergeTables(existingTables []resource.TableInfo, initConfigTables []resourc
 			existableTable.DefaultGroups = tableBeingModified.DefaultGroups
 			existableTable.Conformations = tableBeingModified.Conformations
 			existableTable.Validations = tableBeingModified.Validations
+			existableTable.ComputedColumns = tableBeingModified.ComputedColumns
+			existableTable.SearchableColumns = tableBeingModified.SearchableColumns
+			existableTable.JsonIndexes = tableBeingModified.JsonIndexes
+			existableTable.UpsertKeys = tableBeingModified.UpsertKeys
 			existingTables[j] = existableTable
 		} else {
 			//log.Infof("Table %s is not being modified", existableTable.TableName)
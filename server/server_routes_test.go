@@ -0,0 +1,132 @@
+package server
+
+import (
+	"github.com/daptin/daptin/server/apiblueprint"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/gin-gonic/gin"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// routes which are not in the server paths of the OpenAPI document
+var undocumentedRoutes = map[string]bool{
+	// the files of the dashboard
+	"/static/*filepath": true,
+	// described for every action, next to the tables
+	"/action/:typename/:actionName": true,
+}
+
+// addServerGoRoutes adds the routes server.go adds to the default router with
+// a literal method and path, the handlers do nothing
+func addServerGoRoutes(t *testing.T, router *gin.Engine) {
+
+	file, err := parser.ParseFile(token.NewFileSet(), "server.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	literal := func(expression ast.Expr) (string, bool) {
+		value, ok := expression.(*ast.BasicLit)
+		if !ok || value.Kind != token.STRING {
+			return "", false
+		}
+		unquoted, err := strconv.Unquote(value.Value)
+		return unquoted, err == nil
+	}
+
+	noop := func(c *gin.Context) {}
+	ast.Inspect(file, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok || len(call.Args) < 2 {
+			return true
+		}
+		selector, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if receiver, ok := selector.X.(*ast.Ident); !ok || receiver.Name != "defaultRouter" {
+			return true
+		}
+
+		method := selector.Sel.Name
+		args := call.Args
+		switch method {
+		case "GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS", "HEAD":
+		case "Handle":
+			if method, ok = literal(args[0]); !ok {
+				t.Errorf("Expected a literal method for the route at %v", call.Pos())
+				return true
+			}
+			args = args[1:]
+		default:
+			return true
+		}
+
+		path, ok := literal(args[0])
+		if !ok {
+			t.Errorf("Expected a literal path for the route at %v", call.Pos())
+			return true
+		}
+		router.Handle(method, path, noop)
+		return true
+	})
+}
+
+func TestServerPathsMatchRoutes(t *testing.T) {
+
+	cruds, db, _, cleanup := serverTestFixture(t)
+	defer cleanup()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	addServerGoRoutes(t, router)
+	authMiddleware := auth.NewAuthMiddlewareBuilder(db, "daptin-test")
+	AddDavRoutes(router, cruds, authMiddleware)
+	AddWebDavRoutes(router, cruds, authMiddleware)
+
+	paths := apiblueprint.CreateServerPaths(&resource.CmsConfig{EnableGraphQL: true}, map[string]resource.TableInfo{})
+
+	// the methods of the routes by their path in the document
+	pathParameterPattern := regexp.MustCompile(`[:*](\w+)`)
+	routes := make(map[string]map[string]bool)
+	for _, route := range router.Routes() {
+		if undocumentedRoutes[route.Path] {
+			continue
+		}
+		path := pathParameterPattern.ReplaceAllString(route.Path, "{$1}")
+		if routes[path] == nil {
+			routes[path] = make(map[string]bool)
+		}
+		routes[path][strings.ToLower(route.Method)] = true
+	}
+
+	// PROPFIND and the other dav methods are not in an OpenAPI document
+	documentedMethods := map[string]bool{
+		"get": true, "head": true, "options": true, "put": true, "post": true, "patch": true, "delete": true, "trace": true,
+	}
+	for path, methods := range routes {
+		pathItem, ok := paths[path]
+		if !ok {
+			t.Errorf("Expected the route %v in the OpenAPI document", path)
+			continue
+		}
+		for method := range methods {
+			if _, ok := pathItem[method]; !ok && documentedMethods[method] {
+				t.Errorf("Expected %v %v in the OpenAPI document", method, path)
+			}
+		}
+	}
+	for path, pathItem := range paths {
+		for method := range pathItem {
+			if !routes[path][method] {
+				t.Errorf("The OpenAPI document has %v %v which the server does not route", method, path)
+			}
+		}
+	}
+}
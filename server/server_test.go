@@ -0,0 +1,39 @@
+package server
+
+import (
+	"github.com/daptin/daptin/server/resource"
+	"reflect"
+	"testing"
+)
+
+func TestMergeTablesExistingTable(t *testing.T) {
+
+	existingTables := []resource.TableInfo{
+		{TableName: "product", UpsertKeys: []string{"sku"}},
+		{TableName: "order"},
+	}
+	initConfigTables := []resource.TableInfo{
+		{
+			TableName:         "product",
+			SearchableColumns: []string{"name", "description"},
+			JsonIndexes:       []string{"attributes.color"},
+			UpsertKeys:        []string{"sku", "store"},
+		},
+	}
+
+	allTables := MergeTables(existingTables, initConfigTables)
+	if len(allTables) != 2 {
+		t.Fatalf("Expected the two existing tables, found %v", len(allTables))
+	}
+
+	product := allTables[0]
+	if !reflect.DeepEqual(product.SearchableColumns, []string{"name", "description"}) {
+		t.Errorf("Expected the searchable columns on the existing table, found %v", product.SearchableColumns)
+	}
+	if !reflect.DeepEqual(product.JsonIndexes, []string{"attributes.color"}) {
+		t.Errorf("Expected the json indexes on the existing table, found %v", product.JsonIndexes)
+	}
+	if !reflect.DeepEqual(product.UpsertKeys, []string{"sku", "store"}) {
+		t.Errorf("Expected the new upsert key on the existing table, found %v", product.UpsertKeys)
+	}
+}
@@ -0,0 +1,355 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/pkg/sftp"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+)
+
+// the host key of the sftp server is kept in the certificate table under this hostname
+const sftpHostKeyName = "sftp"
+
+// DaptinSftpServer serves the same per site folders as the ftp server over
+// SFTP. Users log in with their daptin email and password, or with one of the
+// public keys listed in the ssh_public_keys column of their user account.
+type DaptinSftpServer struct {
+	cruds           map[string]*resource.DbResource
+	fileSystem      *DaptinWebDavFileSystem
+	config          *ssh.ServerConfig
+	listenInterface string
+}
+
+func CreateSftpServer(resources map[string]*resource.DbResource, certManager *resource.CertificateManager, listenInterface string) (*DaptinSftpServer, error) {
+
+	hostKey, err := sftpHostKey(certManager)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewDaptinSftpServer(resources, hostKey, listenInterface), nil
+}
+
+// NewDaptinSftpServer serves the sites with ftp enabled, the sites are loaded
+// again as they change
+func NewDaptinSftpServer(resources map[string]*resource.DbResource, hostKey ssh.Signer, listenInterface string) *DaptinSftpServer {
+
+	sftpServer := &DaptinSftpServer{
+		cruds:           resources,
+		fileSystem:      NewDaptinSiteFileSystem(resources, ftpEnabledSites(resources)),
+		listenInterface: listenInterface,
+	}
+
+	sftpServer.config = &ssh.ServerConfig{
+		PasswordCallback:  sftpServer.checkPassword,
+		PublicKeyCallback: sftpServer.checkPublicKey,
+	}
+	sftpServer.config.AddHostKey(hostKey)
+
+	return sftpServer
+}
+
+// ftpEnabledSites loads the sites with ftp enabled
+func ftpEnabledSites(resources map[string]*resource.DbResource) func() ([]resource.SubSite, error) {
+	return func() ([]resource.SubSite, error) {
+
+		sites, err := resources["site"].GetAllSites()
+		if err != nil {
+			return nil, err
+		}
+
+		ftpSites := make([]resource.SubSite, 0)
+		for _, site := range sites {
+			if site.FtpEnabled {
+				ftpSites = append(ftpSites, site)
+			}
+		}
+		return ftpSites, nil
+	}
+}
+
+// sftpHostKey loads the host key from the certificate store, a new key is
+// generated and stored on the first start
+func sftpHostKey(certManager *resource.CertificateManager) (ssh.Signer, error) {
+
+	_, _, privateKeyPem, _, _, err := certManager.GetTLSConfig(sftpHostKeyName, true)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(privateKeyPem)
+	if block == nil {
+		return nil, errors.New("invalid sftp host key")
+	}
+
+	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.NewSignerFromKey(privateKey)
+}
+
+func (s *DaptinSftpServer) checkPassword(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+
+	userAccount, err := s.cruds[resource.USER_ACCOUNT_TABLE_NAME].GetUserAccountRowByEmail(conn.User())
+	if err != nil {
+		return nil, err
+	}
+
+	passwordHash, _ := userAccount["password"].(string)
+	if passwordHash == "" || !resource.BcryptCheckStringHash(string(password), passwordHash) {
+		return nil, fmt.Errorf("could not authenticate you")
+	}
+
+	return sftpPermissions(userAccount), nil
+}
+
+func (s *DaptinSftpServer) checkPublicKey(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+
+	userAccount, err := s.cruds[resource.USER_ACCOUNT_TABLE_NAME].GetUserAccountRowByEmail(conn.User())
+	if err != nil {
+		return nil, err
+	}
+
+	authorizedKeys := []byte(resource.AsStringOrEmpty(userAccount["ssh_public_keys"]))
+	for len(authorizedKeys) > 0 {
+		authorizedKey, _, _, rest, err := ssh.ParseAuthorizedKey(authorizedKeys)
+		if err != nil {
+			break
+		}
+		if bytes.Equal(authorizedKey.Marshal(), key.Marshal()) {
+			return sftpPermissions(userAccount), nil
+		}
+		authorizedKeys = rest
+	}
+
+	return nil, fmt.Errorf("unknown public key for [%v]", conn.User())
+}
+
+// sftpPermissions carries the authenticated user to the connection
+func sftpPermissions(userAccount map[string]interface{}) *ssh.Permissions {
+	return &ssh.Permissions{
+		Extensions: map[string]string{
+			"user_reference_id": userAccount["reference_id"].(string),
+		},
+	}
+}
+
+func (s *DaptinSftpServer) sessionUser(userReferenceId string) (*auth.SessionUser, error) {
+
+	userId, err := s.cruds[resource.USER_ACCOUNT_TABLE_NAME].GetReferenceIdToId(resource.USER_ACCOUNT_TABLE_NAME, userReferenceId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &auth.SessionUser{
+		UserId:          userId,
+		UserReferenceId: userReferenceId,
+		Groups:          s.cruds[resource.USER_ACCOUNT_TABLE_NAME].GetObjectUserGroupsByWhere(resource.USER_ACCOUNT_TABLE_NAME, "id", userId),
+	}, nil
+}
+
+func (s *DaptinSftpServer) ListenAndServe() error {
+
+	listener, err := net.Listen("tcp", s.listenInterface)
+	if err != nil {
+		return err
+	}
+	log.Printf("Listening for SFTP connections at [%v]", s.listenInterface)
+
+	return s.Serve(listener)
+}
+
+// Serve handles the connections accepted by the listener
+func (s *DaptinSftpServer) Serve(listener net.Listener) error {
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handleConnection(conn)
+	}
+}
+
+func (s *DaptinSftpServer) handleConnection(conn net.Conn) {
+
+	sshConn, channels, requests, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		log.Printf("SFTP handshake with [%v] failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+	defer sshConn.Close()
+	go ssh.DiscardRequests(requests)
+
+	user, err := s.sessionUser(sshConn.Permissions.Extensions["user_reference_id"])
+	if err != nil {
+		log.Printf("Failed to load user [%v] for SFTP: %v", sshConn.User(), err)
+		return
+	}
+
+	handler := &sftpHandler{
+		fileSystem: s.fileSystem,
+		ctx:        context.WithValue(context.Background(), "user", user),
+	}
+	handlers := sftp.Handlers{
+		FileGet:  handler,
+		FilePut:  handler,
+		FileCmd:  handler,
+		FileList: handler,
+	}
+
+	for newChannel := range channels {
+
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			log.Printf("Failed to accept SFTP channel: %v", err)
+			return
+		}
+
+		// only the sftp subsystem is available, there is no shell
+		go func(in <-chan *ssh.Request) {
+			for req := range in {
+				isSftp := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				_ = req.Reply(isSftp, nil)
+			}
+		}(channelRequests)
+
+		go func() {
+			requestServer := sftp.NewRequestServer(channel, handlers)
+			err := requestServer.Serve()
+			if err != nil && err != io.EOF {
+				log.Printf("SFTP session of [%v] ended: %v", sshConn.User(), err)
+			}
+			_ = requestServer.Close()
+		}()
+	}
+}
+
+// sftpHandler maps sftp requests on the site folders of a user to the site
+// file system, which checks the permissions and syncs changes to the cloud store
+type sftpHandler struct {
+	fileSystem *DaptinWebDavFileSystem
+	ctx        context.Context
+}
+
+// sitePath maps the sftp path /<hostname>/... to the path of the site file system
+func sitePath(filePath string) string {
+	return "/site" + path.Clean("/"+filePath)
+}
+
+func (h *sftpHandler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+
+	file, err := h.fileSystem.OpenFile(h.ctx, sitePath(r.Filepath), os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	readerAt, ok := file.(io.ReaderAt)
+	if !ok {
+		_ = file.Close()
+		return nil, os.ErrInvalid
+	}
+	return readerAt, nil
+}
+
+func (h *sftpHandler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+
+	// writes carry their offset, so append is not passed on to the file
+	flags := os.O_WRONLY | os.O_CREATE
+	if r.Pflags().Trunc {
+		flags |= os.O_TRUNC
+	}
+
+	file, err := h.fileSystem.OpenFile(h.ctx, sitePath(r.Filepath), flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	writerAt, ok := file.(io.WriterAt)
+	if !ok {
+		_ = file.Close()
+		return nil, os.ErrInvalid
+	}
+	return writerAt, nil
+}
+
+func (h *sftpHandler) Filecmd(r *sftp.Request) error {
+
+	switch r.Method {
+	case "Setstat":
+		return nil
+	case "Rename":
+		return h.fileSystem.Rename(h.ctx, sitePath(r.Filepath), sitePath(r.Target))
+	case "Rmdir", "Remove":
+		return h.fileSystem.RemoveAll(h.ctx, sitePath(r.Filepath))
+	case "Mkdir":
+		return h.fileSystem.Mkdir(h.ctx, sitePath(r.Filepath), 0755)
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+func (h *sftpHandler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+
+	switch r.Method {
+	case "List":
+		file, err := h.fileSystem.OpenFile(h.ctx, sitePath(r.Filepath), os.O_RDONLY, 0)
+		if os.IsNotExist(err) && strings.Trim(r.Filepath, "/") == "" {
+			return sftpFileList{}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		files, err := file.Readdir(0)
+		if err != nil {
+			return nil, err
+		}
+		return sftpFileList(files), nil
+	case "Stat":
+		// the root is listed even when the user cannot read any site
+		if strings.Trim(r.Filepath, "/") == "" {
+			return sftpFileList{virtualFileInfo{
+				name: "/",
+				mode: os.FileMode(0755) | os.ModeDir,
+			}}, nil
+		}
+		stat, err := h.fileSystem.Stat(h.ctx, sitePath(r.Filepath))
+		if err != nil {
+			return nil, err
+		}
+		return sftpFileList{stat}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+type sftpFileList []os.FileInfo
+
+func (l sftpFileList) ListAt(files []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(files, l[offset:])
+	if n < len(files) {
+		return n, io.EOF
+	}
+	return n, nil
+}
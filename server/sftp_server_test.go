@@ -0,0 +1,156 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func sftpTestKey(t *testing.T) ssh.Signer {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestSftpAuthAndConfinement(t *testing.T) {
+
+	cruds, db, _, tempDir, cleanup := webDavTestFixture(t)
+	defer cleanup()
+
+	userKey := sftpTestKey(t)
+	_, err := db.Exec("update user_account set ssh_public_keys = ? where email = 'user@example.com'",
+		string(ssh.MarshalAuthorizedKey(userKey.PublicKey())))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go NewDaptinSftpServer(cruds, sftpTestKey(t), "").Serve(listener)
+
+	connect := func(email string, authMethod ssh.AuthMethod) (*sftp.Client, error) {
+		conn, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+			User:            email,
+			Auth:            []ssh.AuthMethod{authMethod},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		client, err := sftp.NewClient(conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return client, nil
+	}
+
+	for _, test := range []struct {
+		name       string
+		email      string
+		authMethod ssh.AuthMethod
+	}{
+		{"wrong password", "user@example.com", ssh.Password("wrong-password")},
+		{"unknown user", "nobody@example.com", ssh.Password(webDavTestPassword)},
+		{"unknown key", "user@example.com", ssh.PublicKeys(sftpTestKey(t))},
+		{"key of another user", "other@example.com", ssh.PublicKeys(userKey)},
+	} {
+		if client, err := connect(test.email, test.authMethod); err == nil {
+			client.Close()
+			t.Errorf("Expected the login with %v to be refused", test.name)
+		}
+	}
+
+	keyClient, err := connect("user@example.com", ssh.PublicKeys(userKey))
+	if err != nil {
+		t.Fatalf("Expected the login with the public key of the user: %v", err)
+	}
+	defer keyClient.Close()
+	client, err := connect("user@example.com", ssh.Password(webDavTestPassword))
+	if err != nil {
+		t.Fatalf("Expected the login with the password of the user: %v", err)
+	}
+	defer client.Close()
+
+	for _, sftpClient := range []*sftp.Client{keyClient, client} {
+		files, err := sftpClient.ReadDir("/")
+		if err != nil || len(files) != 1 || files[0].Name() != "site.example.com" {
+			t.Fatalf("Expected the site in the root, found %v: %v", files, err)
+		}
+	}
+
+	file, err := client.Open("/site.example.com/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := ioutil.ReadAll(file)
+	file.Close()
+	if err != nil || string(contents) != "hello" {
+		t.Errorf("Expected to read the site, found %v: %v", string(contents), err)
+	}
+
+	// paths are confined to the site folders
+	for _, name := range []string{"/site.example.com/../outside.txt", "/site.example.com/../../outside.txt", "/../outside.txt"} {
+		if file, err := client.Open(name); err == nil {
+			file.Close()
+			t.Errorf("Expected %v not to leave the site", name)
+		}
+	}
+	for _, name := range []string{"/escaped.txt", "/site.example.com/../../escaped.txt"} {
+		if file, err := client.Create(name); err == nil {
+			file.Close()
+			t.Errorf("Expected %v not to be written", name)
+		}
+	}
+	if _, err = os.Stat(filepath.Join(tempDir, "escaped.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected no file written next to the site: %v", err)
+	}
+
+	file, err = client.Create("/site.example.com/upload.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = file.Write([]byte("uploaded"))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contents, err = ioutil.ReadFile(filepath.Join(tempDir, "site", "upload.txt")); string(contents) != "uploaded" {
+		t.Errorf("Expected the upload in the site folder, found %v: %v", string(contents), err)
+	}
+
+	// the other user can log in but does not see the site
+	otherClient, err := connect("other@example.com", ssh.Password(webDavTestPassword))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer otherClient.Close()
+	if files, err := otherClient.ReadDir("/"); err != nil || len(files) != 0 {
+		t.Errorf("Expected an empty root for the other user, found %v: %v", files, err)
+	}
+	if file, err := otherClient.Open("/site.example.com/index.html"); err == nil {
+		file.Close()
+		t.Errorf("Expected the site to be hidden from the other user")
+	}
+	if file, err := otherClient.Create("/site.example.com/index.html"); err == nil {
+		file.Close()
+		t.Errorf("Expected the other user not to write to the site")
+	}
+}
@@ -247,6 +247,11 @@ var apiPaths = map[string]bool{
 	"feed":    true,
 	"asset":   true,
 	"jsmodel": true,
+	"caldav":  true,
+	"carddav": true,
+	"webdav":  true,
+	"search":  true,
+	"geojson": true,
 }
 
 // Implement the ServerHTTP method on our new type
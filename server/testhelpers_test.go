@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"github.com/artpar/api2go"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// serverTestFixture is a sqlite database with the standard tables, the config
+// and a user which the requests are made by
+func serverTestFixture(t *testing.T) (map[string]*resource.DbResource, *sqlx.DB, *auth.SessionUser, func()) {
+
+	tempDir, err := ioutil.TempDir("", "daptin-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sqlx.Open("sqlite3", filepath.Join(tempDir, "daptin_test.db"))
+	if err != nil {
+		os.RemoveAll(tempDir)
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(tempDir)
+	}
+
+	config := &resource.CmsConfig{
+		Tables:    append([]resource.TableInfo{}, resource.StandardTables...),
+		Relations: make([]api2go.TableRelation, 0),
+	}
+	resource.CheckRelations(config)
+
+	tx := db.MustBegin()
+	resource.CheckAllTableStatus(config, db, tx)
+	resource.CreateRelations(config, tx)
+	if err = tx.Commit(); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	configStore, err := resource.NewConfigStore(db)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	if err = CheckSystemSecrets(configStore); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	cruds := make(map[string]*resource.DbResource)
+	ms := &resource.MiddlewareSet{}
+	for _, table := range config.Tables {
+		// rows are made with the default permission, as UpdateWorldTable sets it
+		if table.DefaultPermission == 0 {
+			table.DefaultPermission = auth.DEFAULT_PERMISSION
+		}
+		model := api2go.NewApi2GoModel(table.TableName, table.Columns, int64(table.DefaultPermission), table.Relations)
+		cruds[table.TableName] = resource.NewDbResource(model, db, ms, cruds, configStore, table)
+	}
+
+	result, err := db.Exec("insert into user_account (name, email, password, confirmed, reference_id, permission) values ('user', 'user@example.com', '', 1, 'user-reference-id', ?)",
+		auth.DEFAULT_PERMISSION)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	userId, _ := result.LastInsertId()
+	sessionUser := &auth.SessionUser{
+		UserId:          userId,
+		UserReferenceId: "user-reference-id",
+		Groups:          []auth.GroupPermission{},
+	}
+
+	return cruds, db, sessionUser, cleanup
+}
+
+// withSessionUser is a router with the user of the fixture set on every request
+func withSessionUser(sessionUser *auth.SessionUser) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), "user", sessionUser))
+	})
+	return router
+}
@@ -165,7 +165,7 @@ func GetTablesFromWorld(db database.DatabaseConnection) ([]resource.TableInfo, e
 
 }
 
-func BuildMiddlewareSet(cmsConfig *resource.CmsConfig, cruds *map[string]*resource.DbResource) resource.MiddlewareSet {
+func BuildMiddlewareSet(cmsConfig *resource.CmsConfig, cruds *map[string]*resource.DbResource, eventBroker *resource.EntityEventBroker) resource.MiddlewareSet {
 
 	var ms resource.MiddlewareSet
 
@@ -176,9 +176,9 @@ func BuildMiddlewareSet(cmsConfig *resource.CmsConfig, cruds *map[string]*resour
 	dataValidationMiddleware := resource.NewDataValidationMiddleware(cmsConfig, cruds)
 
 	findOneHandler := resource.NewFindOneEventHandler()
-	createEventHandler := resource.NewCreateEventHandler()
-	updateEventHandler := resource.NewUpdateEventHandler()
-	deleteEventHandler := resource.NewDeleteEventHandler()
+	createEventHandler := resource.NewCreateEventHandler(eventBroker)
+	updateEventHandler := resource.NewUpdateEventHandler(eventBroker)
+	deleteEventHandler := resource.NewDeleteEventHandler(eventBroker)
 
 	ms.BeforeFindAll = []resource.DatabaseRequestInterceptor{
 		tablePermissionChecker,
@@ -196,10 +196,11 @@ func BuildMiddlewareSet(cmsConfig *resource.CmsConfig, cruds *map[string]*resour
 		dataValidationMiddleware,
 		createEventHandler,
 	}
+	// the subscribers get the changed rows before they are filtered for the user who changed them
 	ms.AfterCreate = []resource.DatabaseRequestInterceptor{
+		createEventHandler,
 		tablePermissionChecker,
 		objectPermissionChecker,
-		createEventHandler,
 		exchangeMiddleware,
 	}
 
@@ -221,9 +222,9 @@ func BuildMiddlewareSet(cmsConfig *resource.CmsConfig, cruds *map[string]*resour
 		updateEventHandler,
 	}
 	ms.AfterUpdate = []resource.DatabaseRequestInterceptor{
+		updateEventHandler,
 		tablePermissionChecker,
 		objectPermissionChecker,
-		updateEventHandler,
 	}
 
 	ms.BeforeFindOne = []resource.DatabaseRequestInterceptor{
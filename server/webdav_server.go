@@ -0,0 +1,518 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/webdav"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// webDavMountsTTL is how long the mounts are used before the sites and asset
+// folders are loaded again, so new sites and cloud store columns show up
+// without a restart
+const webDavMountsTTL = 10 * time.Second
+
+// webDavMount is a folder exposed over webdav, either the files of a subsite or
+// the asset folder of a table column
+type webDavMount struct {
+	folder     resource.AssetFolderCache
+	permission func() resource.PermissionInstance
+}
+
+// DaptinWebDavFileSystem exposes subsites at /site/<hostname> and the asset
+// folders of cloud store columns at /asset/<table>/<column>. Files are served
+// from the local copy of the folder, changes are uploaded to the cloud store
+// backing the folder.
+type DaptinWebDavFileSystem struct {
+	cruds      map[string]*resource.DbResource
+	loadSites  func() ([]resource.SubSite, error)
+	withAssets bool
+
+	lock     sync.Mutex
+	mounts   map[string]webDavMount
+	loadedAt time.Time
+	// local copies of the sites which were added after the server started
+	siteFolders  map[string]resource.AssetFolderCache
+	syncingSites map[string]bool
+	copySite     func(site resource.SubSite) (resource.AssetFolderCache, error)
+}
+
+// NewDaptinSiteFileSystem exposes only the sites returned by loadSites at
+// /site/<hostname>
+func NewDaptinSiteFileSystem(cruds map[string]*resource.DbResource, loadSites func() ([]resource.SubSite, error)) *DaptinWebDavFileSystem {
+	wfs := &DaptinWebDavFileSystem{
+		cruds:        cruds,
+		loadSites:    loadSites,
+		siteFolders:  make(map[string]resource.AssetFolderCache),
+		syncingSites: make(map[string]bool),
+	}
+	wfs.copySite = wfs.copySiteFromCloudStore
+	return wfs
+}
+
+// NewDaptinWebDavFileSystem exposes all sites and the asset folders
+func NewDaptinWebDavFileSystem(cruds map[string]*resource.DbResource) *DaptinWebDavFileSystem {
+	wfs := NewDaptinSiteFileSystem(cruds, cruds["site"].GetAllSites)
+	wfs.withAssets = true
+	return wfs
+}
+
+// currentMounts returns the mounts, loading them again when they are older
+// than webDavMountsTTL
+func (wfs *DaptinWebDavFileSystem) currentMounts() map[string]webDavMount {
+
+	wfs.lock.Lock()
+	if wfs.mounts != nil && time.Since(wfs.loadedAt) < webDavMountsTTL {
+		mounts := wfs.mounts
+		wfs.lock.Unlock()
+		return mounts
+	}
+	wfs.lock.Unlock()
+
+	sites, err := wfs.loadSites()
+	if resource.CheckErr(err, "Failed to load sites for webdav") {
+		wfs.lock.Lock()
+		mounts := wfs.mounts
+		wfs.lock.Unlock()
+		if mounts != nil {
+			return mounts
+		}
+	}
+
+	cruds := wfs.cruds
+	mounts := make(map[string]webDavMount)
+	for _, site := range sites {
+		folder, ok := wfs.siteFolder(site)
+		if !ok {
+			continue
+		}
+		siteReferenceId := site.ReferenceId
+		mounts["site/"+site.Hostname] = webDavMount{
+			folder: folder,
+			permission: func() resource.PermissionInstance {
+				return cruds["site"].GetObjectPermissionByReferenceId("site", siteReferenceId)
+			},
+		}
+	}
+
+	if wfs.withAssets {
+		for tableName, columnFolders := range cruds["world"].AssetFolderCache {
+			for columnName, columnFolder := range columnFolders {
+				table := tableName
+				mounts["asset/"+tableName+"/"+columnName] = webDavMount{
+					folder: columnFolder,
+					permission: func() resource.PermissionInstance {
+						return cruds["world"].GetObjectPermissionByWhereClause("world", "table_name", table)
+					},
+				}
+			}
+		}
+	}
+
+	if err == nil {
+		wfs.lock.Lock()
+		wfs.mounts = mounts
+		wfs.loadedAt = time.Now()
+		wfs.lock.Unlock()
+	}
+	return mounts
+}
+
+// siteFolder is the local copy of the site. Sites added after the server
+// started are synced from their cloud store in the background, they are
+// mounted once the sync has finished.
+func (wfs *DaptinWebDavFileSystem) siteFolder(site resource.SubSite) (resource.AssetFolderCache, bool) {
+
+	if folder, ok := wfs.cruds["site"].SubsiteFolderCache[site.ReferenceId]; ok {
+		return folder, true
+	}
+
+	wfs.lock.Lock()
+	defer wfs.lock.Unlock()
+
+	if folder, ok := wfs.siteFolders[site.ReferenceId]; ok {
+		return folder, true
+	}
+	if site.CloudStoreId == nil || wfs.syncingSites[site.ReferenceId] {
+		return resource.AssetFolderCache{}, false
+	}
+
+	wfs.syncingSites[site.ReferenceId] = true
+	go wfs.syncSite(site)
+	return resource.AssetFolderCache{}, false
+}
+
+// syncSite copies the site from its cloud store to a new folder, and loads the
+// mounts again when the copy is complete
+func (wfs *DaptinWebDavFileSystem) syncSite(site resource.SubSite) {
+
+	folder, err := wfs.copySite(site)
+	resource.CheckErr(err, "Failed to sync subsite [%v] for webdav", site.Name)
+
+	wfs.lock.Lock()
+	defer wfs.lock.Unlock()
+	delete(wfs.syncingSites, site.ReferenceId)
+	if err == nil {
+		wfs.siteFolders[site.ReferenceId] = folder
+		wfs.loadedAt = time.Time{}
+	}
+}
+
+// copySiteFromCloudStore downloads the site to a new temp directory
+func (wfs *DaptinWebDavFileSystem) copySiteFromCloudStore(site resource.SubSite) (resource.AssetFolderCache, error) {
+
+	stores, err := wfs.cruds["cloud_store"].GetAllCloudStores()
+	if err != nil {
+		return resource.AssetFolderCache{}, err
+	}
+	for _, store := range stores {
+		if store.Id != *site.CloudStoreId {
+			continue
+		}
+
+		tempDirectoryPath, err := ioutil.TempDir("", site.ReferenceId)
+		if err != nil {
+			return resource.AssetFolderCache{}, err
+		}
+		err = wfs.cruds["task"].CopyFromCloudStore(store, "", tempDirectoryPath)
+		if err != nil {
+			os.RemoveAll(tempDirectoryPath)
+			return resource.AssetFolderCache{}, err
+		}
+
+		return resource.AssetFolderCache{
+			LocalSyncPath: tempDirectoryPath,
+			Keyname:       "",
+			CloudStore:    store,
+		}, nil
+	}
+
+	return resource.AssetFolderCache{}, fmt.Errorf("site [%v] does not have a associated storage", site.Name)
+}
+
+// resolve finds the mount for the name and the path inside the mount. Names
+// above the mounts are virtual directories, for those the mount is nil.
+func (wfs *DaptinWebDavFileSystem) resolve(name string) (*webDavMount, string, string) {
+
+	name = strings.Trim(path.Clean("/"+name), "/")
+	parts := strings.Split(name, "/")
+
+	depth := 0
+	switch parts[0] {
+	case "site":
+		depth = 2
+	case "asset":
+		depth = 3
+	}
+
+	if depth == 0 || len(parts) < depth {
+		return nil, name, ""
+	}
+
+	mountName := strings.Join(parts[:depth], "/")
+	mount, ok := wfs.currentMounts()[mountName]
+	if !ok {
+		return nil, name, ""
+	}
+
+	return &mount, mountName, "/" + strings.Join(parts[depth:], "/")
+}
+
+func (wfs *DaptinWebDavFileSystem) isAdmin(user *auth.SessionUser) bool {
+	adminId := wfs.cruds[resource.USER_ACCOUNT_TABLE_NAME].GetAdminReferenceId()
+	return adminId != "" && adminId == user.UserReferenceId
+}
+
+func (wfs *DaptinWebDavFileSystem) canRead(ctx context.Context, mount *webDavMount) bool {
+	user := webDavSessionUser(ctx)
+	return wfs.isAdmin(user) || mount.permission().CanRead(user.UserReferenceId, user.Groups)
+}
+
+func (wfs *DaptinWebDavFileSystem) canWrite(ctx context.Context, mount *webDavMount) bool {
+	user := webDavSessionUser(ctx)
+	return wfs.isAdmin(user) || mount.permission().CanUpdate(user.UserReferenceId, user.Groups)
+}
+
+func webDavSessionUser(ctx context.Context) *auth.SessionUser {
+	user, ok := ctx.Value("user").(*auth.SessionUser)
+	if !ok || user == nil {
+		return &auth.SessionUser{
+			UserReferenceId: "",
+			Groups:          []auth.GroupPermission{},
+		}
+	}
+	return user
+}
+
+// virtualChildren lists the entries of a virtual directory which lead to a
+// mount the user can read
+func (wfs *DaptinWebDavFileSystem) virtualChildren(ctx context.Context, name string) ([]string, bool) {
+
+	prefix := ""
+	if name != "" && name != "." {
+		prefix = name + "/"
+	}
+
+	childMap := make(map[string]bool)
+	found := name == "" || name == "."
+	for mountName, mount := range wfs.currentMounts() {
+		if !strings.HasPrefix(mountName, prefix) {
+			continue
+		}
+		if !wfs.canRead(ctx, &mount) {
+			continue
+		}
+		found = true
+		childMap[strings.Split(strings.TrimPrefix(mountName, prefix), "/")[0]] = true
+	}
+
+	children := make([]string, 0)
+	for child := range childMap {
+		children = append(children, child)
+	}
+	sort.Strings(children)
+	return children, found
+}
+
+func (wfs *DaptinWebDavFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+
+	mount, _, relativePath := wfs.resolve(name)
+	if mount == nil || relativePath == "/" {
+		return os.ErrPermission
+	}
+	if !wfs.canWrite(ctx, mount) {
+		return os.ErrPermission
+	}
+
+	err := webdav.Dir(mount.folder.LocalSyncPath).Mkdir(ctx, relativePath, perm)
+	if err != nil {
+		return err
+	}
+
+	err = wfs.cruds["world"].MakeAssetFolderDirectory(mount.folder, relativePath)
+	resource.CheckErr(err, "Failed to create folder [%v] in cloud store", name)
+	return nil
+}
+
+func (wfs *DaptinWebDavFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+
+	mount, mountName, relativePath := wfs.resolve(name)
+	if mount == nil {
+		if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) != 0 {
+			return nil, os.ErrPermission
+		}
+		children, found := wfs.virtualChildren(ctx, mountName)
+		if !found {
+			return nil, os.ErrNotExist
+		}
+		return &webDavVirtualDirectory{
+			name:     path.Base("/" + mountName),
+			children: children,
+		}, nil
+	}
+
+	if !wfs.canRead(ctx, mount) {
+		return nil, os.ErrNotExist
+	}
+
+	isWrite := flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0
+	if isWrite && !wfs.canWrite(ctx, mount) {
+		return nil, os.ErrPermission
+	}
+
+	file, err := webdav.Dir(mount.folder.LocalSyncPath).OpenFile(ctx, relativePath, flag, perm)
+	if err != nil || !isWrite {
+		return file, err
+	}
+
+	return &webDavSyncedFile{
+		File: file,
+		onClose: func() {
+			err := wfs.cruds["world"].SyncAssetFolderPath(mount.folder, relativePath)
+			resource.CheckErr(err, "Failed to upload [%v] to cloud store", name)
+		},
+	}, nil
+}
+
+func (wfs *DaptinWebDavFileSystem) RemoveAll(ctx context.Context, name string) error {
+
+	mount, _, relativePath := wfs.resolve(name)
+	if mount == nil || relativePath == "/" {
+		return os.ErrPermission
+	}
+	if !wfs.canWrite(ctx, mount) {
+		return os.ErrPermission
+	}
+
+	localFileSystem := webdav.Dir(mount.folder.LocalSyncPath)
+	stat, err := localFileSystem.Stat(ctx, relativePath)
+	if err != nil {
+		return err
+	}
+
+	err = localFileSystem.RemoveAll(ctx, relativePath)
+	if err != nil {
+		return err
+	}
+
+	err = wfs.cruds["world"].DeleteAssetFolderPath(mount.folder, relativePath, stat.IsDir())
+	resource.CheckErr(err, "Failed to delete [%v] from cloud store", name)
+	return nil
+}
+
+func (wfs *DaptinWebDavFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+
+	oldMount, oldMountName, oldPath := wfs.resolve(oldName)
+	newMount, newMountName, newPath := wfs.resolve(newName)
+	if oldMount == nil || newMount == nil || oldPath == "/" || newPath == "/" {
+		return os.ErrPermission
+	}
+	if oldMountName != newMountName {
+		return errors.New("cannot move files across mounts")
+	}
+	if !wfs.canWrite(ctx, oldMount) {
+		return os.ErrPermission
+	}
+
+	localFileSystem := webdav.Dir(oldMount.folder.LocalSyncPath)
+	stat, err := localFileSystem.Stat(ctx, oldPath)
+	if err != nil {
+		return err
+	}
+
+	err = localFileSystem.Rename(ctx, oldPath, newPath)
+	if err != nil {
+		return err
+	}
+
+	err = wfs.cruds["world"].SyncAssetFolderPath(newMount.folder, newPath)
+	resource.CheckErr(err, "Failed to upload [%v] to cloud store", newName)
+	err = wfs.cruds["world"].DeleteAssetFolderPath(oldMount.folder, oldPath, stat.IsDir())
+	resource.CheckErr(err, "Failed to delete [%v] from cloud store", oldName)
+	return nil
+}
+
+func (wfs *DaptinWebDavFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+
+	mount, mountName, relativePath := wfs.resolve(name)
+	if mount == nil {
+		_, found := wfs.virtualChildren(ctx, mountName)
+		if !found {
+			return nil, os.ErrNotExist
+		}
+		return virtualFileInfo{
+			name: path.Base("/" + mountName),
+			mode: os.FileMode(0755) | os.ModeDir,
+		}, nil
+	}
+
+	if !wfs.canRead(ctx, mount) {
+		return nil, os.ErrNotExist
+	}
+
+	return webdav.Dir(mount.folder.LocalSyncPath).Stat(ctx, relativePath)
+}
+
+// webDavSyncedFile uploads the file to the cloud store once it has been written
+type webDavSyncedFile struct {
+	webdav.File
+	onClose func()
+}
+
+// WriteAt is used by the sftp server which writes at offsets
+func (f *webDavSyncedFile) WriteAt(buffer []byte, offset int64) (int, error) {
+	writerAt, ok := f.File.(io.WriterAt)
+	if !ok {
+		return 0, os.ErrInvalid
+	}
+	return writerAt.WriteAt(buffer, offset)
+}
+
+func (f *webDavSyncedFile) Close() error {
+	err := f.File.Close()
+	if err == nil {
+		f.onClose()
+	}
+	return err
+}
+
+// webDavVirtualDirectory lists the sites, tables and columns above the mounts
+type webDavVirtualDirectory struct {
+	name     string
+	children []string
+	offset   int
+}
+
+func (d *webDavVirtualDirectory) Close() error {
+	return nil
+}
+
+func (d *webDavVirtualDirectory) Read(buffer []byte) (int, error) {
+	return 0, os.ErrInvalid
+}
+
+func (d *webDavVirtualDirectory) Seek(offset int64, whence int) (int64, error) {
+	return 0, os.ErrInvalid
+}
+
+func (d *webDavVirtualDirectory) Write(buffer []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (d *webDavVirtualDirectory) Readdir(count int) ([]os.FileInfo, error) {
+
+	files := make([]os.FileInfo, 0)
+	for d.offset < len(d.children) && (count <= 0 || len(files) < count) {
+		files = append(files, virtualFileInfo{
+			name: d.children[d.offset],
+			mode: os.FileMode(0755) | os.ModeDir,
+		})
+		d.offset++
+	}
+
+	if count > 0 && len(files) == 0 {
+		return files, io.EOF
+	}
+	return files, nil
+}
+
+func (d *webDavVirtualDirectory) Stat() (os.FileInfo, error) {
+	return virtualFileInfo{
+		name: d.name,
+		mode: os.FileMode(0755) | os.ModeDir,
+	}, nil
+}
+
+// AddWebDavRoutes serves the subsites and asset folders over webdav at /webdav
+func AddWebDavRoutes(defaultRouter *gin.Engine, cruds map[string]*resource.DbResource, authMiddleware *auth.AuthMiddleware) {
+
+	webDavHandler := CreateDavHandler(&webdav.Handler{
+		Prefix:     "/webdav",
+		FileSystem: NewDaptinWebDavFileSystem(cruds),
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				log.Errorf("WebDAV [%v] %v: %v", r.Method, r.URL.Path, err)
+			}
+		},
+	}, authMiddleware)
+
+	for _, method := range append(davMethods, "POST", "LOCK", "UNLOCK") {
+		defaultRouter.Handle(method, "/webdav/*path", webDavHandler)
+	}
+	defaultRouter.Handle("PROPFIND", "/webdav", webDavHandler)
+	defaultRouter.Handle("OPTIONS", "/webdav", webDavHandler)
+}
@@ -0,0 +1,272 @@
+package server
+
+import (
+	"context"
+	"github.com/daptin/daptin/server/auth"
+	"github.com/daptin/daptin/server/resource"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const webDavTestPassword = "secret-password"
+
+// webDavTestFixture has the user of the server fixture owning the site
+// site.example.com, and a second user other@example.com, both with the
+// password webDavTestPassword. The local copy of the site in <temp>/site holds
+// index.html, next to it is outside.txt which must not be reachable from the
+// site.
+func webDavTestFixture(t *testing.T) (map[string]*resource.DbResource, *sqlx.DB, *auth.SessionUser, string, func()) {
+
+	cruds, db, sessionUser, cleanup := serverTestFixture(t)
+
+	tempDir, err := ioutil.TempDir("", "daptin-webdav-test")
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	fixtureCleanup := func() {
+		os.RemoveAll(tempDir)
+		cleanup()
+	}
+	fail := func(err error) {
+		fixtureCleanup()
+		t.Fatal(err)
+	}
+
+	passwordHash, err := resource.BcryptHashString(webDavTestPassword)
+	if err != nil {
+		fail(err)
+	}
+	if _, err = db.Exec("update user_account set password = ?", passwordHash); err != nil {
+		fail(err)
+	}
+	_, err = db.Exec("insert into user_account (name, email, password, confirmed, reference_id, permission) values ('other', 'other@example.com', ?, 1, 'other-reference-id', ?)",
+		passwordHash, auth.DEFAULT_PERMISSION)
+	if err != nil {
+		fail(err)
+	}
+
+	siteFolder := filepath.Join(tempDir, "site")
+	storeFolder := filepath.Join(tempDir, "store")
+	for _, folder := range []string{siteFolder, storeFolder} {
+		if err = os.Mkdir(folder, 0755); err != nil {
+			fail(err)
+		}
+	}
+	if err = ioutil.WriteFile(filepath.Join(siteFolder, "index.html"), []byte("hello"), 0644); err != nil {
+		fail(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(tempDir, "outside.txt"), []byte("outside"), 0644); err != nil {
+		fail(err)
+	}
+
+	_, err = db.Exec("insert into site (name, hostname, path, enable, enable_https, ftp_enabled, reference_id, permission, user_account_id) values ('site', 'site.example.com', 'site', 1, 0, 1, 'site-reference-id', ?, ?)",
+		auth.DEFAULT_PERMISSION, sessionUser.UserId)
+	if err != nil {
+		fail(err)
+	}
+	cruds["site"].SubsiteFolderCache = map[string]resource.AssetFolderCache{
+		"site-reference-id": {
+			LocalSyncPath: siteFolder,
+			CloudStore:    resource.CloudStore{Name: "local", RootPath: storeFolder},
+		},
+	}
+
+	return cruds, db, sessionUser, tempDir, fixtureCleanup
+}
+
+func TestWebDavAuthAndConfinement(t *testing.T) {
+
+	cruds, db, _, tempDir, cleanup := webDavTestFixture(t)
+	defer cleanup()
+
+	auth.InitJwtMiddleware([]byte("daptin-test-secret"), "daptin-test")
+	authMiddleware := auth.NewAuthMiddlewareBuilder(db, "daptin-test")
+	authMiddleware.SetUserCrud(cruds[resource.USER_ACCOUNT_TABLE_NAME])
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	AddWebDavRoutes(router, cruds, authMiddleware)
+
+	request := func(method, url, email, password, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if email != "" {
+			req.SetBasicAuth(email, password)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	for _, test := range []struct {
+		email    string
+		password string
+	}{
+		{"", ""},
+		{"user@example.com", "wrong-password"},
+		{"nobody@example.com", webDavTestPassword},
+	} {
+		recorder := request("GET", "/webdav/site/site.example.com/index.html", test.email, test.password, "")
+		if recorder.Code != http.StatusUnauthorized || recorder.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("Expected [%v] to be asked to authenticate, got %v", test.email, recorder.Code)
+		}
+	}
+
+	recorder := request("GET", "/webdav/site/site.example.com/index.html", "user@example.com", webDavTestPassword, "")
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "hello" {
+		t.Fatalf("Expected the owner to read the site, got %v: %v", recorder.Code, recorder.Body.String())
+	}
+
+	// paths are confined to the local copy of the site
+	for _, url := range []string{
+		"/webdav/site/site.example.com/../outside.txt",
+		"/webdav/site/site.example.com/../../outside.txt",
+		"/webdav/site/site.example.com/%2e%2e/outside.txt",
+		"/webdav/../outside.txt",
+	} {
+		recorder = request("GET", url, "user@example.com", webDavTestPassword, "")
+		if recorder.Code == http.StatusOK || strings.Contains(recorder.Body.String(), "outside") {
+			t.Errorf("Expected %v not to leave the site, got %v: %v", url, recorder.Code, recorder.Body.String())
+		}
+	}
+	recorder = request("PUT", "/webdav/site/site.example.com/../escaped.txt", "user@example.com", webDavTestPassword, "escaped")
+	if recorder.Code < 400 {
+		t.Errorf("Expected a write above the site to be refused, got %v", recorder.Code)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "escaped.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected no file written next to the site: %v", err)
+	}
+
+	// the other user can neither see nor change the site
+	recorder = request("PROPFIND", "/webdav/site/", "other@example.com", webDavTestPassword, "")
+	if strings.Contains(recorder.Body.String(), "site.example.com") {
+		t.Errorf("Expected the site not to be listed for the other user: %v", recorder.Body.String())
+	}
+	recorder = request("GET", "/webdav/site/site.example.com/index.html", "other@example.com", webDavTestPassword, "")
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected the site to be hidden from the other user, got %v", recorder.Code)
+	}
+	recorder = request("PUT", "/webdav/site/site.example.com/index.html", "other@example.com", webDavTestPassword, "changed")
+	if recorder.Code < 400 {
+		t.Errorf("Expected the other user not to write to the site, got %v", recorder.Code)
+	}
+	if contents, _ := ioutil.ReadFile(filepath.Join(tempDir, "site", "index.html")); string(contents) != "hello" {
+		t.Errorf("Expected the site to be unchanged, found %v", string(contents))
+	}
+
+	recorder = request("PUT", "/webdav/site/site.example.com/new.html", "user@example.com", webDavTestPassword, "new")
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("Expected the owner to write to the site, got %v: %v", recorder.Code, recorder.Body.String())
+	}
+	if contents, err := ioutil.ReadFile(filepath.Join(tempDir, "site", "new.html")); err != nil || string(contents) != "new" {
+		t.Errorf("Expected the new file in the site folder, found %v: %v", string(contents), err)
+	}
+}
+
+func TestWebDavMountsReload(t *testing.T) {
+
+	cruds, db, sessionUser, tempDir, cleanup := webDavTestFixture(t)
+	defer cleanup()
+
+	wfs := NewDaptinWebDavFileSystem(cruds)
+	ctx := context.WithValue(context.Background(), "user", sessionUser)
+	if _, err := wfs.Stat(ctx, "/site/site.example.com/index.html"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a site and an asset column added after the file system was made
+	_, err := db.Exec("insert into site (name, hostname, path, enable, enable_https, ftp_enabled, reference_id, permission, user_account_id) values ('second', 'second.example.com', 'second', 1, 0, 0, 'second-reference-id', ?, ?)",
+		auth.DEFAULT_PERMISSION, sessionUser.UserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cruds["site"].SubsiteFolderCache["second-reference-id"] = cruds["site"].SubsiteFolderCache["site-reference-id"]
+	cruds["world"].AssetFolderCache = map[string]map[string]resource.AssetFolderCache{
+		"site": {"logo": {LocalSyncPath: filepath.Join(tempDir, "store")}},
+	}
+	if _, err = db.Exec("insert into world (table_name, world_schema_json, reference_id, permission) values ('site', '{}', 'world-site', ?)",
+		auth.ALLOW_ALL_PERMISSIONS); err != nil {
+		t.Fatal(err)
+	}
+
+	wfs.loadedAt = time.Now().Add(-webDavMountsTTL)
+	for _, name := range []string{"/site/second.example.com/index.html", "/asset/site/logo"} {
+		if _, err = wfs.Stat(ctx, name); err != nil {
+			t.Errorf("Expected %v to be mounted once the mounts are loaded again: %v", name, err)
+		}
+	}
+
+	// the sftp server only has the sites with ftp enabled
+	sftpFileSystem := NewDaptinSftpServer(cruds, sftpTestKey(t), "").fileSystem
+	if _, err = sftpFileSystem.Stat(ctx, "/site/site.example.com/index.html"); err != nil {
+		t.Errorf("Expected the site with ftp enabled over sftp: %v", err)
+	}
+	for _, name := range []string{"/site/second.example.com", "/asset/site/logo"} {
+		if _, err = sftpFileSystem.Stat(ctx, name); !os.IsNotExist(err) {
+			t.Errorf("Expected %v not to be served over sftp, got %v", name, err)
+		}
+	}
+}
+
+func TestWebDavSiteSyncOutsideLock(t *testing.T) {
+
+	cruds, db, sessionUser, tempDir, cleanup := webDavTestFixture(t)
+	defer cleanup()
+
+	_, err := db.Exec("insert into cloud_store (name, store_type, store_provider, root_path, store_parameters, reference_id, permission) values ('remote', 'cloud', 'remote', 'remote:site', '{}', 'store-reference-id', ?)",
+		auth.DEFAULT_PERMISSION)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("insert into site (name, hostname, path, enable, enable_https, ftp_enabled, reference_id, permission, user_account_id, cloud_store_id) values ('remote', 'remote.example.com', 'remote', 1, 0, 0, 'remote-reference-id', ?, ?, (select id from cloud_store where reference_id = 'store-reference-id'))",
+		auth.DEFAULT_PERMISSION, sessionUser.UserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wfs := NewDaptinWebDavFileSystem(cruds)
+	started := make(chan bool, 1)
+	release := make(chan bool)
+	wfs.copySite = func(site resource.SubSite) (resource.AssetFolderCache, error) {
+		started <- true
+		<-release
+		return resource.AssetFolderCache{LocalSyncPath: filepath.Join(tempDir, "site")}, nil
+	}
+	ctx := context.WithValue(context.Background(), "user", sessionUser)
+
+	// the other sites are served while the new site is being synced
+	if _, err = wfs.Stat(ctx, "/site/site.example.com/index.html"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the sync of the new site to start")
+	}
+	wfs.loadedAt = time.Now().Add(-webDavMountsTTL)
+	if _, err = wfs.Stat(ctx, "/site/site.example.com/index.html"); err != nil {
+		t.Errorf("Expected the site to be served during the sync: %v", err)
+	}
+	if _, err = wfs.Stat(ctx, "/site/remote.example.com/index.html"); !os.IsNotExist(err) {
+		t.Errorf("Expected the new site not to be mounted before the sync finished, got %v", err)
+	}
+
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err = wfs.Stat(ctx, "/site/remote.example.com/index.html"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the new site to be mounted after the sync: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}